@@ -1,55 +1,67 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
-)
+	"time"
 
-var cleanupTasks []func()
+	"github.com/danchitnis/llrdc/pkg/llrdc"
+)
 
 func main() {
 	log.SetOutput(os.Stdout)
 	log.Println("Starting llrdc (Go)...")
 
-	// Initialize config
-	initConfig()
-	initScreenSize(3840, 2160)
-
-	// Setup signal handling
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigs
-		shutdown()
-	}()
-
-	// 1. Start X11 unless TEST_PATTERN is set
-	if !TestPattern {
-		if err := startX11(DisplayNum); err != nil {
-			log.Fatalf("Failed to initialize X11: %v", err)
+	cfg := llrdc.LoadConfig()
+	if cfg.LogFile != "" {
+		logFile, err := llrdc.OpenLogFile(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logFile.Close()
+		log.SetOutput(logFile)
+		log.Println("Starting llrdc (Go)...")
+	}
+	if cfg.LoadTest > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err := llrdc.LoadTest(ctx, cfg); err != nil {
+			log.Fatalf("Load test failed: %v", err)
+		}
+		return
+	}
+	srv, err := llrdc.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	if cfg.Doctor {
+		if !srv.Doctor() {
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.Benchmark {
+		if err := srv.Benchmark(); err != nil {
+			log.Fatalf("Benchmark failed: %v", err)
 		}
-		startCursorWatcher(Display)
-		initDamageTracking(Display)
-	} else {
-		log.Println("TEST_PATTERN mode: skipping X11 setup.")
+		return
 	}
 
-	// 2. Initialize WebRTC and RTP Listener
-	initWebRTC()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// 3. Start ffmpeg streaming
-	startStreaming(broadcastVideoFrame)
-	startAudioStreaming()
-	// 4. Start HTTP & WebSocket server (blocks)
-	startHTTPServer()
-}
+	runErr := srv.Run(ctx)
 
-func shutdown() {
-	log.Println("Shutting down...")
-	for i := len(cleanupTasks) - 1; i >= 0; i-- {
-		cleanupTasks[i]()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown error: %v", err)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
 	}
-	os.Exit(0)
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/h265writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+)
+
+// frame is a decoded picture in the X server's 32-bit BGRX layout.
+type frame struct {
+	width, height int
+	pixels        []byte
+}
+
+type rtpDepacketizer interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// decodeTrack pipes a video track through ffmpeg and calls show with each
+// decoded frame until the track or ffmpeg ends. ffmpeg writes PPM images,
+// whose headers carry the size, so resolution changes need no restart.
+func decodeTrack(ctx context.Context, ffmpeg string, track *webrtc.TrackRemote, show func(frame)) error {
+	mime := track.Codec().MimeType
+	inputFormat := "ivf"
+	switch mime {
+	case webrtc.MimeTypeH264:
+		inputFormat = "h264"
+	case webrtc.MimeTypeH265:
+		inputFormat = "hevc"
+	case webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeAV1:
+	default:
+		return fmt.Errorf("unsupported codec %s", mime)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer", "-flags", "low_delay", "-probesize", "32", "-analyzeduration", "0",
+		"-f", inputFormat, "-i", "pipe:0",
+		"-fps_mode", "passthrough", "-pix_fmt", "rgb24", "-c:v", "ppm", "-f", "image2pipe", "pipe:1")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var out rtpDepacketizer
+	switch inputFormat {
+	case "h264":
+		out = h264writer.NewWith(stdin)
+	case "hevc":
+		out = h265writer.NewWith(stdin)
+	default:
+		if out, err = ivfwriter.NewWith(stdin, ivfwriter.WithCodec(mime)); err != nil {
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %v", ffmpeg, err)
+	}
+
+	go func() {
+		defer out.Close()
+		for ctx.Err() == nil {
+			packet, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			if err := out.WriteRTP(packet); err != nil {
+				log.Printf("Writing to the decoder: %v", err)
+				return
+			}
+		}
+	}()
+
+	r := bufio.NewReaderSize(stdout, 1<<20)
+	for {
+		f, err := readPPM(r)
+		if err != nil {
+			cancel()
+			_ = cmd.Wait()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		show(f)
+	}
+}
+
+// readPPM reads one binary (P6) PPM image as ffmpeg writes them and
+// converts it to BGRX.
+func readPPM(r *bufio.Reader) (frame, error) {
+	var width, height, maxval int
+	if _, err := fmt.Fscanf(r, "P6\n%d %d\n%d\n", &width, &height, &maxval); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return frame{}, err
+	}
+	if width <= 0 || height <= 0 || maxval != 255 {
+		return frame{}, fmt.Errorf("unexpected PPM image %dx%d, maxval %d", width, height, maxval)
+	}
+	rgb := make([]byte, width*height*3)
+	if _, err := io.ReadFull(r, rgb); err != nil {
+		return frame{}, err
+	}
+	pixels := make([]byte, width*height*4)
+	for i, j := 0, 0; i < len(rgb); i, j = i+3, j+4 {
+		pixels[j] = rgb[i+2]
+		pixels[j+1] = rgb[i+1]
+		pixels[j+2] = rgb[i]
+	}
+	return frame{width: width, height: height, pixels: pixels}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// specialKeys maps X keysyms to the DOM key names the server understands.
+var specialKeys = map[xproto.Keysym]string{
+	0xff08: "Backspace",
+	0xff09: "Tab",
+	0xff0d: "Enter",
+	0xff1b: "Escape",
+	0xff50: "Home",
+	0xff51: "ArrowLeft",
+	0xff52: "ArrowUp",
+	0xff53: "ArrowRight",
+	0xff54: "ArrowDown",
+	0xff55: "PageUp",
+	0xff56: "PageDown",
+	0xff57: "End",
+	0xff63: "Insert",
+	0xff8d: "Enter", // KP_Enter
+	0xffe1: "Shift",
+	0xffe2: "Shift",
+	0xffe3: "Control",
+	0xffe4: "Control",
+	0xffe9: "Alt",
+	0xffea: "Alt",
+	0xffeb: "Meta",
+	0xffec: "Meta",
+	0xffff: "Delete",
+}
+
+func init() {
+	for i := 0; i < 12; i++ {
+		specialKeys[xproto.Keysym(0xffbe+i)] = "F" + strconv.Itoa(i+1)
+	}
+}
+
+// keyboardMap translates the local keyboard's keycodes to key names.
+type keyboardMap struct {
+	min     xproto.Keycode
+	perCode int
+	syms    []xproto.Keysym
+}
+
+func loadKeyboardMap(conn *xgb.Conn, setup *xproto.SetupInfo) (*keyboardMap, error) {
+	count := byte(setup.MaxKeycode - setup.MinKeycode + 1)
+	reply, err := xproto.GetKeyboardMapping(conn, setup.MinKeycode, count).Reply()
+	if err != nil {
+		return nil, err
+	}
+	return &keyboardMap{min: setup.MinKeycode, perCode: int(reply.KeysymsPerKeycode), syms: reply.Keysyms}, nil
+}
+
+// key returns the key name for a keycode pressed with the modifier state,
+// or "" for keys the server cannot inject.
+func (m *keyboardMap) key(code xproto.Keycode, state uint16) string {
+	i := (int(code) - int(m.min)) * m.perCode
+	if i < 0 || i >= len(m.syms) {
+		return ""
+	}
+	sym := m.syms[i]
+	if state&xproto.ModMaskShift != 0 && m.perCode > 1 && m.syms[i+1] != 0 {
+		sym = m.syms[i+1]
+	}
+	if name, ok := specialKeys[sym]; ok {
+		return name
+	}
+	switch {
+	case sym >= 0x20 && sym <= 0xff:
+		// Latin-1 keysyms are their code points.
+		return string(rune(sym))
+	case sym >= 0x01000100 && sym <= 0x0110ffff:
+		return string(rune(sym - 0x01000000))
+	}
+	return ""
+}
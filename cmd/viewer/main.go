@@ -0,0 +1,100 @@
+// Command viewer is a native llrdc client for X11 desktops. It negotiates
+// WebRTC over the server's WebSocket like the browser viewer, decodes the
+// video with ffmpeg, draws it in a window and forwards keyboard and mouse
+// input.
+//
+//	viewer [flags] http://host:8080
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	header := flag.String("header", "", `Extra request header for the WebSocket, as "Name: value" (e.g. for an authenticating proxy)`)
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
+	ffmpeg := flag.String("ffmpeg", "ffmpeg", "ffmpeg binary used to decode the video")
+	display := flag.String("display", os.Getenv("DISPLAY"), "X display to open the window on")
+	stun := flag.String("stun", "stun.l.google.com:19302", "Comma-separated STUN servers (host:port or stun: URLs), or none")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <server URL>\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	wsURL, err := webSocketURL(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Invalid server URL: %v", err)
+	}
+	headers := http.Header{}
+	if *header != "" {
+		name, value, ok := strings.Cut(*header, ":")
+		if !ok {
+			log.Fatalf(`--header must be "Name: value"`)
+		}
+		headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	win, err := openWindow(*display, "llrdc - "+flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open window: %v", err)
+	}
+	defer win.Close()
+
+	sess := &session{url: wsURL, headers: headers, insecure: *insecure, ffmpeg: *ffmpeg, stun: stunURLs(*stun), win: win}
+	if err := sess.run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// stunURLs parses the --stun list, accepting bare host:port entries.
+func stunURLs(list string) []string {
+	var urls []string
+	for _, server := range strings.Split(list, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" || server == "none" {
+			continue
+		}
+		if !strings.HasPrefix(server, "stun:") && !strings.HasPrefix(server, "stuns:") {
+			server = "stun:" + server
+		}
+		urls = append(urls, server)
+	}
+	return urls
+}
+
+// webSocketURL turns the server's http(s) URL into its ws(s) one.
+func webSocketURL(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+const pingInterval = 5 * time.Second
+
+// session is one connection to the server: the WebSocket carrying
+// signaling and input, and the PeerConnection carrying the media.
+type session struct {
+	url      string
+	headers  http.Header
+	insecure bool
+	ffmpeg   string
+	stun     []string
+	win      *window
+
+	conn *websocket.Conn
+	mu   sync.Mutex
+	pc   *webrtc.PeerConnection
+}
+
+func (s *session) send(v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteJSON(v)
+}
+
+// run connects and streams until ctx ends, the window is closed or the
+// server goes away.
+func (s *session) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	dialer := *websocket.DefaultDialer
+	if s.insecure {
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	log.Printf("Connecting to %s", s.url)
+	conn, _, err := dialer.DialContext(ctx, s.url, s.headers)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %v", s.url, err)
+	}
+	s.conn = conn
+	defer conn.Close()
+
+	var iceServers []webrtc.ICEServer
+	if len(s.stun) > 0 {
+		iceServers = []webrtc.ICEServer{{URLs: s.stun}}
+	}
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return err
+	}
+	s.pc = pc
+	defer pc.Close()
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			return err
+		}
+	}
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			_ = s.send(map[string]interface{}{"type": "webrtc_ice", "candidate": candidate.ToJSON()})
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("WebRTC %s", state)
+		if state == webrtc.PeerConnectionStateFailed {
+			cancel()
+		}
+	})
+	var ready sync.Once
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			// Audio playback is not supported; drain the track.
+			for {
+				if _, _, err := track.ReadRTP(); err != nil {
+					return
+				}
+			}
+		}
+		log.Printf("Receiving %s video", track.Codec().MimeType)
+		err := decodeTrack(ctx, s.ffmpeg, track, func(f frame) {
+			// The server sends WebSocket video until WebRTC delivers.
+			ready.Do(func() { _ = s.send(map[string]interface{}{"type": "webrtc_ready"}) })
+			s.win.draw(f)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Video decoding stopped: %v", err)
+			cancel()
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err == nil {
+		err = pc.SetLocalDescription(offer)
+	}
+	if err == nil {
+		err = s.send(map[string]interface{}{"type": "webrtc_offer", "sdp": pc.LocalDescription()})
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		s.readSignaling(ctx)
+		cancel()
+	}()
+	go func() {
+		s.win.run(s.send)
+		cancel()
+	}()
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ts := float64(time.Now().UnixNano()) / 1e6
+				if s.send(map[string]interface{}{"type": "ping", "timestamp": ts}) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	<-ctx.Done()
+	s.win.releaseKeys(s.send)
+	s.mu.Lock()
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	s.mu.Unlock()
+	return nil
+}
+
+// readSignaling handles the server's answer and ICE candidates until the
+// WebSocket closes. Candidates that arrive before the answer are held back.
+func (s *session) readSignaling(ctx context.Context) {
+	var pending []webrtc.ICECandidateInit
+	var codec string
+	answered := false
+	for ctx.Err() == nil {
+		msgType, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Connection closed: %v", err)
+			}
+			return
+		}
+		if msgType == websocket.BinaryMessage {
+			continue
+		}
+		var msg struct {
+			Type       string                     `json:"type"`
+			SDP        *webrtc.SessionDescription `json:"sdp"`
+			Candidate  *webrtc.ICECandidateInit   `json:"candidate"`
+			VideoCodec string                     `json:"videoCodec"`
+			Reason     string                     `json:"reason"`
+		}
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "config":
+			if msg.VideoCodec == "" || msg.VideoCodec == codec {
+				continue
+			}
+			if codec != "" {
+				// The video track was replaced; the browser viewer
+				// renegotiates, this one simply reconnects.
+				log.Printf("Server switched the video codec to %s; reconnect to continue", msg.VideoCodec)
+				return
+			}
+			codec = msg.VideoCodec
+			log.Printf("Server video codec: %s", codec)
+		case "webrtc_answer":
+			if msg.SDP == nil {
+				continue
+			}
+			if err := s.pc.SetRemoteDescription(*msg.SDP); err != nil {
+				log.Printf("Invalid answer: %v", err)
+				return
+			}
+			answered = true
+			for _, ice := range pending {
+				_ = s.pc.AddICECandidate(ice)
+			}
+			pending = nil
+		case "webrtc_ice":
+			if msg.Candidate == nil {
+				continue
+			}
+			if answered {
+				_ = s.pc.AddICECandidate(*msg.Candidate)
+			} else {
+				pending = append(pending, *msg.Candidate)
+			}
+		case "locked":
+			log.Printf("Session locked (%s); unlock it from a browser viewer", msg.Reason)
+		case "server_shutdown":
+			log.Printf("Server is shutting down")
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	defaultWidth, defaultHeight = 1280, 720
+	// Mouse moves are sent at most this often; the server coalesces
+	// bursts the same way.
+	mouseMoveInterval = 8 * time.Millisecond
+)
+
+// window is the X11 window the desktop is drawn in. It uses the core
+// protocol only, so it needs no cgo or toolkit libraries.
+type window struct {
+	conn         *xgb.Conn
+	id           xproto.Window
+	gc           xproto.Gcontext
+	depth        byte
+	maxRequest   int
+	deleteAtom   xproto.Atom
+	protocolAtom xproto.Atom
+	keys         *keyboardMap
+
+	mu            sync.Mutex
+	width, height int
+
+	// pressed maps held keycodes to the key name sent on press, so the
+	// release matches even if the modifiers changed in between.
+	pressed map[xproto.Keycode]string
+}
+
+func openWindow(display, title string) (*window, error) {
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+	setup := xproto.Setup(conn)
+	screen := setup.DefaultScreen(conn)
+	bpp := 0
+	for _, format := range setup.PixmapFormats {
+		if format.Depth == screen.RootDepth {
+			bpp = int(format.BitsPerPixel)
+		}
+	}
+	if bpp != 32 {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported X visual: depth %d at %d bits per pixel", screen.RootDepth, bpp)
+	}
+
+	w := &window{
+		conn:       conn,
+		depth:      screen.RootDepth,
+		maxRequest: int(setup.MaximumRequestLength) * 4,
+		width:      defaultWidth,
+		height:     defaultHeight,
+		pressed:    make(map[xproto.Keycode]string),
+	}
+	if w.keys, err = loadKeyboardMap(conn, setup); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if w.id, err = xproto.NewWindowId(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	events := uint32(xproto.EventMaskKeyPress | xproto.EventMaskKeyRelease |
+		xproto.EventMaskButtonPress | xproto.EventMaskButtonRelease |
+		xproto.EventMaskPointerMotion | xproto.EventMaskFocusChange |
+		xproto.EventMaskExposure | xproto.EventMaskStructureNotify)
+	err = xproto.CreateWindowChecked(conn, screen.RootDepth, w.id, screen.Root,
+		0, 0, defaultWidth, defaultHeight, 0, xproto.WindowClassInputOutput, screen.RootVisual,
+		xproto.CwBackPixel|xproto.CwEventMask, []uint32{screen.BlackPixel, events}).Check()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if w.gc, err = xproto.NewGcontextId(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := xproto.CreateGCChecked(conn, w.gc, xproto.Drawable(w.id), 0, nil).Check(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	xproto.ChangeProperty(conn, xproto.PropModeReplace, w.id, xproto.AtomWmName, xproto.AtomString,
+		8, uint32(len(title)), []byte(title))
+	// Ask the window manager for a close message instead of a disconnect.
+	w.protocolAtom = internAtom(conn, "WM_PROTOCOLS")
+	w.deleteAtom = internAtom(conn, "WM_DELETE_WINDOW")
+	if w.protocolAtom != 0 && w.deleteAtom != 0 {
+		data := make([]byte, 4)
+		xgb.Put32(data, uint32(w.deleteAtom))
+		xproto.ChangeProperty(conn, xproto.PropModeReplace, w.id, w.protocolAtom, xproto.AtomAtom, 32, 1, data)
+	}
+	xproto.MapWindow(conn, w.id)
+	return w, nil
+}
+
+func internAtom(conn *xgb.Conn, name string) xproto.Atom {
+	reply, err := xproto.InternAtom(conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0
+	}
+	return reply.Atom
+}
+
+func (w *window) Close() {
+	w.conn.Close()
+}
+
+// draw shows a decoded frame, resizing the window to the desktop's size
+// when it changes.
+func (w *window) draw(f frame) {
+	w.mu.Lock()
+	resized := f.width != w.width || f.height != w.height
+	w.width, w.height = f.width, f.height
+	w.mu.Unlock()
+	if resized {
+		xproto.ConfigureWindow(w.conn, w.id, xproto.ConfigWindowWidth|xproto.ConfigWindowHeight,
+			[]uint32{uint32(f.width), uint32(f.height)})
+	}
+
+	// Split the image into bands that fit in one request.
+	stride := f.width * 4
+	rows := max(1, (w.maxRequest-64)/stride)
+	for y := 0; y < f.height; y += rows {
+		n := min(rows, f.height-y)
+		xproto.PutImage(w.conn, xproto.ImageFormatZPixmap, xproto.Drawable(w.id), w.gc,
+			uint16(f.width), uint16(n), 0, int16(y), 0, w.depth, f.pixels[y*stride:(y+n)*stride])
+	}
+}
+
+// position returns the pointer position as the 0-1 fractions of the
+// desktop the server expects.
+func (w *window) position(x, y int16) (float64, float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	nx := min(max(float64(x)/float64(w.width), 0), 1)
+	ny := min(max(float64(y)/float64(w.height), 0), 1)
+	return nx, ny
+}
+
+// run forwards input with send until the window is closed or the X
+// connection fails.
+func (w *window) run(send func(interface{}) error) {
+	var lastMove time.Time
+	for {
+		ev, xerr := w.conn.WaitForEvent()
+		if ev == nil && xerr == nil {
+			log.Printf("X connection closed")
+			return
+		}
+		if xerr != nil {
+			log.Printf("X error: %v", xerr)
+			continue
+		}
+		switch e := ev.(type) {
+		case xproto.KeyPressEvent:
+			key := w.keys.key(e.Detail, e.State)
+			if key == "" {
+				continue
+			}
+			w.mu.Lock()
+			w.pressed[e.Detail] = key
+			w.mu.Unlock()
+			_ = send(map[string]interface{}{"type": "keydown", "key": key})
+		case xproto.KeyReleaseEvent:
+			w.mu.Lock()
+			key, ok := w.pressed[e.Detail]
+			delete(w.pressed, e.Detail)
+			w.mu.Unlock()
+			if ok {
+				_ = send(map[string]interface{}{"type": "keyup", "key": key})
+			}
+		case xproto.MotionNotifyEvent:
+			if time.Since(lastMove) < mouseMoveInterval {
+				continue
+			}
+			lastMove = time.Now()
+			x, y := w.position(e.EventX, e.EventY)
+			_ = send(map[string]interface{}{"type": "mousemove", "x": x, "y": y})
+		case xproto.ButtonPressEvent:
+			w.sendButton(send, "mousedown", e.Detail, e.EventX, e.EventY)
+		case xproto.ButtonReleaseEvent:
+			w.sendButton(send, "mouseup", e.Detail, e.EventX, e.EventY)
+		case xproto.FocusOutEvent:
+			// Keys released while another window has focus never reach us.
+			w.releaseKeys(send)
+		case xproto.ClientMessageEvent:
+			if e.Type == w.protocolAtom && xproto.Atom(e.Data.Data32[0]) == w.deleteAtom {
+				return
+			}
+		case xproto.DestroyNotifyEvent:
+			return
+		}
+	}
+}
+
+// sendButton sends a click of X button, or a wheel step for buttons 4-7.
+func (w *window) sendButton(send func(interface{}) error, action string, button xproto.Button, x, y int16) {
+	var dx, dy float64
+	switch button {
+	case 1, 2, 3:
+		nx, ny := w.position(x, y)
+		_ = send(map[string]interface{}{"type": "mousemove", "x": nx, "y": ny})
+		// The server numbers buttons like the DOM: 0 left, 1 middle, 2 right.
+		_ = send(map[string]interface{}{"type": action, "x": nx, "y": ny, "button": int(button) - 1})
+		return
+	case 4:
+		dy = -1
+	case 5:
+		dy = 1
+	case 6:
+		dx = -1
+	case 7:
+		dx = 1
+	default:
+		return
+	}
+	if action == "mousedown" {
+		_ = send(map[string]interface{}{"type": "wheel", "deltaX": dx, "deltaY": dy})
+	}
+}
+
+// releaseKeys sends key releases for every key still held.
+func (w *window) releaseKeys(send func(interface{}) error) {
+	w.mu.Lock()
+	held := w.pressed
+	w.pressed = make(map[xproto.Keycode]string)
+	w.mu.Unlock()
+	for _, key := range held {
+		_ = send(map[string]interface{}{"type": "keyup", "key": key})
+	}
+}
@@ -0,0 +1,1405 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: control.proto
+
+// Control-plane API of an llrdc server, for fleet-management tools. Served
+// on --grpc-addr alongside HTTP. Regenerate the Go code with
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative control.proto
+
+package controlpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetSessionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSessionRequest) Reset() {
+	*x = GetSessionRequest{}
+	mi := &file_control_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSessionRequest) ProtoMessage() {}
+
+func (x *GetSessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSessionRequest.ProtoReflect.Descriptor instead.
+func (*GetSessionRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{0}
+}
+
+type Session struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Display     string                 `protobuf:"bytes,1,opt,name=display,proto3" json:"display,omitempty"`
+	Width       int32                  `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height      int32                  `protobuf:"varint,3,opt,name=height,proto3" json:"height,omitempty"`
+	Rotation    string                 `protobuf:"bytes,4,opt,name=rotation,proto3" json:"rotation,omitempty"`
+	Dpi         int32                  `protobuf:"varint,5,opt,name=dpi,proto3" json:"dpi,omitempty"`
+	Locked      bool                   `protobuf:"varint,6,opt,name=locked,proto3" json:"locked,omitempty"`
+	ClientCount int32                  `protobuf:"varint,7,opt,name=client_count,json=clientCount,proto3" json:"client_count,omitempty"`
+	TestPattern bool                   `protobuf:"varint,8,opt,name=test_pattern,json=testPattern,proto3" json:"test_pattern,omitempty"`
+	// Unix time in milliseconds of the last input from any viewer.
+	LastInputUnixMs int64 `protobuf:"varint,9,opt,name=last_input_unix_ms,json=lastInputUnixMs,proto3" json:"last_input_unix_ms,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Session) Reset() {
+	*x = Session{}
+	mi := &file_control_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Session) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Session) ProtoMessage() {}
+
+func (x *Session) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Session.ProtoReflect.Descriptor instead.
+func (*Session) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Session) GetDisplay() string {
+	if x != nil {
+		return x.Display
+	}
+	return ""
+}
+
+func (x *Session) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *Session) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *Session) GetRotation() string {
+	if x != nil {
+		return x.Rotation
+	}
+	return ""
+}
+
+func (x *Session) GetDpi() int32 {
+	if x != nil {
+		return x.Dpi
+	}
+	return 0
+}
+
+func (x *Session) GetLocked() bool {
+	if x != nil {
+		return x.Locked
+	}
+	return false
+}
+
+func (x *Session) GetClientCount() int32 {
+	if x != nil {
+		return x.ClientCount
+	}
+	return 0
+}
+
+func (x *Session) GetTestPattern() bool {
+	if x != nil {
+		return x.TestPattern
+	}
+	return false
+}
+
+func (x *Session) GetLastInputUnixMs() int64 {
+	if x != nil {
+		return x.LastInputUnixMs
+	}
+	return 0
+}
+
+type SetLockedRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Locked        bool                   `protobuf:"varint,1,opt,name=locked,proto3" json:"locked,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLockedRequest) Reset() {
+	*x = SetLockedRequest{}
+	mi := &file_control_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLockedRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLockedRequest) ProtoMessage() {}
+
+func (x *SetLockedRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLockedRequest.ProtoReflect.Descriptor instead.
+func (*SetLockedRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SetLockedRequest) GetLocked() bool {
+	if x != nil {
+		return x.Locked
+	}
+	return false
+}
+
+type ListClientsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClientsRequest) Reset() {
+	*x = ListClientsRequest{}
+	mi := &file_control_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClientsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClientsRequest) ProtoMessage() {}
+
+func (x *ListClientsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClientsRequest.ProtoReflect.Descriptor instead.
+func (*ListClientsRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{3}
+}
+
+type ClientInfo struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Remote address, which also identifies the client in DisconnectClient.
+	Address string `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	// Authenticated user, see --identity-header.
+	Identity        string `protobuf:"bytes,2,opt,name=identity,proto3" json:"identity,omitempty"`
+	ConnectedUnixMs int64  `protobuf:"varint,3,opt,name=connected_unix_ms,json=connectedUnixMs,proto3" json:"connected_unix_ms,omitempty"`
+	// True once video goes over WebRTC rather than the WebSocket.
+	Webrtc bool `protobuf:"varint,4,opt,name=webrtc,proto3" json:"webrtc,omitempty"`
+	// Bandwidth cap in effect in Mbps, 0 for none.
+	BandwidthCapMbps float64 `protobuf:"fixed64,5,opt,name=bandwidth_cap_mbps,json=bandwidthCapMbps,proto3" json:"bandwidth_cap_mbps,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *ClientInfo) Reset() {
+	*x = ClientInfo{}
+	mi := &file_control_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientInfo) ProtoMessage() {}
+
+func (x *ClientInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientInfo.ProtoReflect.Descriptor instead.
+func (*ClientInfo) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ClientInfo) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *ClientInfo) GetIdentity() string {
+	if x != nil {
+		return x.Identity
+	}
+	return ""
+}
+
+func (x *ClientInfo) GetConnectedUnixMs() int64 {
+	if x != nil {
+		return x.ConnectedUnixMs
+	}
+	return 0
+}
+
+func (x *ClientInfo) GetWebrtc() bool {
+	if x != nil {
+		return x.Webrtc
+	}
+	return false
+}
+
+func (x *ClientInfo) GetBandwidthCapMbps() float64 {
+	if x != nil {
+		return x.BandwidthCapMbps
+	}
+	return 0
+}
+
+type ListClientsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Clients       []*ClientInfo          `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListClientsResponse) Reset() {
+	*x = ListClientsResponse{}
+	mi := &file_control_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListClientsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListClientsResponse) ProtoMessage() {}
+
+func (x *ListClientsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListClientsResponse.ProtoReflect.Descriptor instead.
+func (*ListClientsResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListClientsResponse) GetClients() []*ClientInfo {
+	if x != nil {
+		return x.Clients
+	}
+	return nil
+}
+
+type DisconnectClientRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisconnectClientRequest) Reset() {
+	*x = DisconnectClientRequest{}
+	mi := &file_control_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisconnectClientRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisconnectClientRequest) ProtoMessage() {}
+
+func (x *DisconnectClientRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisconnectClientRequest.ProtoReflect.Descriptor instead.
+func (*DisconnectClientRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DisconnectClientRequest) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type DisconnectClientResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DisconnectClientResponse) Reset() {
+	*x = DisconnectClientResponse{}
+	mi := &file_control_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DisconnectClientResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisconnectClientResponse) ProtoMessage() {}
+
+func (x *DisconnectClientResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisconnectClientResponse.ProtoReflect.Descriptor instead.
+func (*DisconnectClientResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{7}
+}
+
+type GetEncoderSettingsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetEncoderSettingsRequest) Reset() {
+	*x = GetEncoderSettingsRequest{}
+	mi := &file_control_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetEncoderSettingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetEncoderSettingsRequest) ProtoMessage() {}
+
+func (x *GetEncoderSettingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetEncoderSettingsRequest.ProtoReflect.Descriptor instead.
+func (*GetEncoderSettingsRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{8}
+}
+
+// EncoderSettings mirrors the viewer's "config" message. In
+// UpdateEncoderSettings, unset fields are left unchanged.
+type EncoderSettings struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	VideoCodec *string                `protobuf:"bytes,1,opt,name=video_codec,json=videoCodec,proto3,oneof" json:"video_codec,omitempty"`
+	Framerate  *int32                 `protobuf:"varint,2,opt,name=framerate,proto3,oneof" json:"framerate,omitempty"`
+	// Target bandwidth in Mbps; takes precedence over quality.
+	BandwidthMbps      *int32  `protobuf:"varint,3,opt,name=bandwidth_mbps,json=bandwidthMbps,proto3,oneof" json:"bandwidth_mbps,omitempty"`
+	Quality            *int32  `protobuf:"varint,4,opt,name=quality,proto3,oneof" json:"quality,omitempty"`
+	Vbr                *bool   `protobuf:"varint,5,opt,name=vbr,proto3,oneof" json:"vbr,omitempty"`
+	Mpdecimate         *bool   `protobuf:"varint,6,opt,name=mpdecimate,proto3,oneof" json:"mpdecimate,omitempty"`
+	KeyframeInterval   *int32  `protobuf:"varint,7,opt,name=keyframe_interval,json=keyframeInterval,proto3,oneof" json:"keyframe_interval,omitempty"`
+	ContentTune        *string `protobuf:"bytes,8,opt,name=content_tune,json=contentTune,proto3,oneof" json:"content_tune,omitempty"`
+	Chroma             *string `protobuf:"bytes,9,opt,name=chroma,proto3,oneof" json:"chroma,omitempty"`
+	CpuEffort          *int32  `protobuf:"varint,10,opt,name=cpu_effort,json=cpuEffort,proto3,oneof" json:"cpu_effort,omitempty"`
+	CpuThreads         *int32  `protobuf:"varint,11,opt,name=cpu_threads,json=cpuThreads,proto3,oneof" json:"cpu_threads,omitempty"`
+	AutoQuality        *bool   `protobuf:"varint,12,opt,name=auto_quality,json=autoQuality,proto3,oneof" json:"auto_quality,omitempty"`
+	EnableAudio        *bool   `protobuf:"varint,13,opt,name=enable_audio,json=enableAudio,proto3,oneof" json:"enable_audio,omitempty"`
+	AudioBitrate       *string `protobuf:"bytes,14,opt,name=audio_bitrate,json=audioBitrate,proto3,oneof" json:"audio_bitrate,omitempty"`
+	EnableDesktopMouse *bool   `protobuf:"varint,15,opt,name=enable_desktop_mouse,json=enableDesktopMouse,proto3,oneof" json:"enable_desktop_mouse,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *EncoderSettings) Reset() {
+	*x = EncoderSettings{}
+	mi := &file_control_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EncoderSettings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncoderSettings) ProtoMessage() {}
+
+func (x *EncoderSettings) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncoderSettings.ProtoReflect.Descriptor instead.
+func (*EncoderSettings) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *EncoderSettings) GetVideoCodec() string {
+	if x != nil && x.VideoCodec != nil {
+		return *x.VideoCodec
+	}
+	return ""
+}
+
+func (x *EncoderSettings) GetFramerate() int32 {
+	if x != nil && x.Framerate != nil {
+		return *x.Framerate
+	}
+	return 0
+}
+
+func (x *EncoderSettings) GetBandwidthMbps() int32 {
+	if x != nil && x.BandwidthMbps != nil {
+		return *x.BandwidthMbps
+	}
+	return 0
+}
+
+func (x *EncoderSettings) GetQuality() int32 {
+	if x != nil && x.Quality != nil {
+		return *x.Quality
+	}
+	return 0
+}
+
+func (x *EncoderSettings) GetVbr() bool {
+	if x != nil && x.Vbr != nil {
+		return *x.Vbr
+	}
+	return false
+}
+
+func (x *EncoderSettings) GetMpdecimate() bool {
+	if x != nil && x.Mpdecimate != nil {
+		return *x.Mpdecimate
+	}
+	return false
+}
+
+func (x *EncoderSettings) GetKeyframeInterval() int32 {
+	if x != nil && x.KeyframeInterval != nil {
+		return *x.KeyframeInterval
+	}
+	return 0
+}
+
+func (x *EncoderSettings) GetContentTune() string {
+	if x != nil && x.ContentTune != nil {
+		return *x.ContentTune
+	}
+	return ""
+}
+
+func (x *EncoderSettings) GetChroma() string {
+	if x != nil && x.Chroma != nil {
+		return *x.Chroma
+	}
+	return ""
+}
+
+func (x *EncoderSettings) GetCpuEffort() int32 {
+	if x != nil && x.CpuEffort != nil {
+		return *x.CpuEffort
+	}
+	return 0
+}
+
+func (x *EncoderSettings) GetCpuThreads() int32 {
+	if x != nil && x.CpuThreads != nil {
+		return *x.CpuThreads
+	}
+	return 0
+}
+
+func (x *EncoderSettings) GetAutoQuality() bool {
+	if x != nil && x.AutoQuality != nil {
+		return *x.AutoQuality
+	}
+	return false
+}
+
+func (x *EncoderSettings) GetEnableAudio() bool {
+	if x != nil && x.EnableAudio != nil {
+		return *x.EnableAudio
+	}
+	return false
+}
+
+func (x *EncoderSettings) GetAudioBitrate() string {
+	if x != nil && x.AudioBitrate != nil {
+		return *x.AudioBitrate
+	}
+	return ""
+}
+
+func (x *EncoderSettings) GetEnableDesktopMouse() bool {
+	if x != nil && x.EnableDesktopMouse != nil {
+		return *x.EnableDesktopMouse
+	}
+	return false
+}
+
+type GetStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_control_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{10}
+}
+
+type WatchStatsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Milliseconds between updates; 0 means 2000.
+	IntervalMs    int32 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchStatsRequest) Reset() {
+	*x = WatchStatsRequest{}
+	mi := &file_control_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchStatsRequest) ProtoMessage() {}
+
+func (x *WatchStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchStatsRequest.ProtoReflect.Descriptor instead.
+func (*WatchStatsRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *WatchStatsRequest) GetIntervalMs() int32 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+type Stats struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	FfmpegCpuPercent float64                `protobuf:"fixed64,1,opt,name=ffmpeg_cpu_percent,json=ffmpegCpuPercent,proto3" json:"ffmpeg_cpu_percent,omitempty"`
+	EncoderRestarts  int64                  `protobuf:"varint,2,opt,name=encoder_restarts,json=encoderRestarts,proto3" json:"encoder_restarts,omitempty"`
+	CaptureFps       float64                `protobuf:"fixed64,3,opt,name=capture_fps,json=captureFps,proto3" json:"capture_fps,omitempty"`
+	ClientCount      int32                  `protobuf:"varint,4,opt,name=client_count,json=clientCount,proto3" json:"client_count,omitempty"`
+	UnixMs           int64                  `protobuf:"varint,5,opt,name=unix_ms,json=unixMs,proto3" json:"unix_ms,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_control_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Stats) GetFfmpegCpuPercent() float64 {
+	if x != nil {
+		return x.FfmpegCpuPercent
+	}
+	return 0
+}
+
+func (x *Stats) GetEncoderRestarts() int64 {
+	if x != nil {
+		return x.EncoderRestarts
+	}
+	return 0
+}
+
+func (x *Stats) GetCaptureFps() float64 {
+	if x != nil {
+		return x.CaptureFps
+	}
+	return 0
+}
+
+func (x *Stats) GetClientCount() int32 {
+	if x != nil {
+		return x.ClientCount
+	}
+	return 0
+}
+
+func (x *Stats) GetUnixMs() int64 {
+	if x != nil {
+		return x.UnixMs
+	}
+	return 0
+}
+
+type ScreenshotRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Scale to this width, keeping the aspect ratio; 0 for full size.
+	Width         int32 `protobuf:"varint,1,opt,name=width,proto3" json:"width,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScreenshotRequest) Reset() {
+	*x = ScreenshotRequest{}
+	mi := &file_control_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScreenshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScreenshotRequest) ProtoMessage() {}
+
+func (x *ScreenshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScreenshotRequest.ProtoReflect.Descriptor instead.
+func (*ScreenshotRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ScreenshotRequest) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+type ScreenshotResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jpeg          []byte                 `protobuf:"bytes,1,opt,name=jpeg,proto3" json:"jpeg,omitempty"`
+	Width         int32                  `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32                  `protobuf:"varint,3,opt,name=height,proto3" json:"height,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScreenshotResponse) Reset() {
+	*x = ScreenshotResponse{}
+	mi := &file_control_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScreenshotResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScreenshotResponse) ProtoMessage() {}
+
+func (x *ScreenshotResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScreenshotResponse.ProtoReflect.Descriptor instead.
+func (*ScreenshotResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ScreenshotResponse) GetJpeg() []byte {
+	if x != nil {
+		return x.Jpeg
+	}
+	return nil
+}
+
+func (x *ScreenshotResponse) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *ScreenshotResponse) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+type BroadcastRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Text  string                 `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	// Seconds until the banner is removed; 0 keeps it until replaced.
+	DurationSeconds int32 `protobuf:"varint,2,opt,name=duration_seconds,json=durationSeconds,proto3" json:"duration_seconds,omitempty"`
+	// Also draw the banner into the video, for players that do not show
+	// protocol messages. This restarts the encoder.
+	BurnIn        bool `protobuf:"varint,3,opt,name=burn_in,json=burnIn,proto3" json:"burn_in,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BroadcastRequest) Reset() {
+	*x = BroadcastRequest{}
+	mi := &file_control_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BroadcastRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BroadcastRequest) ProtoMessage() {}
+
+func (x *BroadcastRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BroadcastRequest.ProtoReflect.Descriptor instead.
+func (*BroadcastRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *BroadcastRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *BroadcastRequest) GetDurationSeconds() int32 {
+	if x != nil {
+		return x.DurationSeconds
+	}
+	return 0
+}
+
+func (x *BroadcastRequest) GetBurnIn() bool {
+	if x != nil {
+		return x.BurnIn
+	}
+	return false
+}
+
+type BroadcastResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Viewers connected when the banner was sent.
+	ClientCount   int32 `protobuf:"varint,1,opt,name=client_count,json=clientCount,proto3" json:"client_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BroadcastResponse) Reset() {
+	*x = BroadcastResponse{}
+	mi := &file_control_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BroadcastResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BroadcastResponse) ProtoMessage() {}
+
+func (x *BroadcastResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BroadcastResponse.ProtoReflect.Descriptor instead.
+func (*BroadcastResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *BroadcastResponse) GetClientCount() int32 {
+	if x != nil {
+		return x.ClientCount
+	}
+	return 0
+}
+
+type GetEncoderLogRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetEncoderLogRequest) Reset() {
+	*x = GetEncoderLogRequest{}
+	mi := &file_control_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetEncoderLogRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetEncoderLogRequest) ProtoMessage() {}
+
+func (x *GetEncoderLogRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetEncoderLogRequest.ProtoReflect.Descriptor instead.
+func (*GetEncoderLogRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{17}
+}
+
+type EncoderRun struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	StartedUnixMs int64                  `protobuf:"varint,1,opt,name=started_unix_ms,json=startedUnixMs,proto3" json:"started_unix_ms,omitempty"`
+	// 0 while the run is going on.
+	EndedUnixMs int64    `protobuf:"varint,2,opt,name=ended_unix_ms,json=endedUnixMs,proto3" json:"ended_unix_ms,omitempty"`
+	Args        []string `protobuf:"bytes,3,rep,name=args,proto3" json:"args,omitempty"`
+	// How the run ended, such as "exit status 1"; empty while running.
+	Exit string `protobuf:"bytes,4,opt,name=exit,proto3" json:"exit,omitempty"`
+	// The end of stderr, at most --ffmpeg-log-kb.
+	Stderr []byte `protobuf:"bytes,5,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	// Bytes of stderr dropped from the start to stay within the limit.
+	DroppedBytes  int64 `protobuf:"varint,6,opt,name=dropped_bytes,json=droppedBytes,proto3" json:"dropped_bytes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EncoderRun) Reset() {
+	*x = EncoderRun{}
+	mi := &file_control_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EncoderRun) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EncoderRun) ProtoMessage() {}
+
+func (x *EncoderRun) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EncoderRun.ProtoReflect.Descriptor instead.
+func (*EncoderRun) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *EncoderRun) GetStartedUnixMs() int64 {
+	if x != nil {
+		return x.StartedUnixMs
+	}
+	return 0
+}
+
+func (x *EncoderRun) GetEndedUnixMs() int64 {
+	if x != nil {
+		return x.EndedUnixMs
+	}
+	return 0
+}
+
+func (x *EncoderRun) GetArgs() []string {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *EncoderRun) GetExit() string {
+	if x != nil {
+		return x.Exit
+	}
+	return ""
+}
+
+func (x *EncoderRun) GetStderr() []byte {
+	if x != nil {
+		return x.Stderr
+	}
+	return nil
+}
+
+func (x *EncoderRun) GetDroppedBytes() int64 {
+	if x != nil {
+		return x.DroppedBytes
+	}
+	return 0
+}
+
+type GetEncoderLogResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Oldest first; the last one is the current run.
+	Runs          []*EncoderRun `protobuf:"bytes,1,rep,name=runs,proto3" json:"runs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetEncoderLogResponse) Reset() {
+	*x = GetEncoderLogResponse{}
+	mi := &file_control_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetEncoderLogResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetEncoderLogResponse) ProtoMessage() {}
+
+func (x *GetEncoderLogResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetEncoderLogResponse.ProtoReflect.Descriptor instead.
+func (*GetEncoderLogResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *GetEncoderLogResponse) GetRuns() []*EncoderRun {
+	if x != nil {
+		return x.Runs
+	}
+	return nil
+}
+
+var File_control_proto protoreflect.FileDescriptor
+
+const file_control_proto_rawDesc = "" +
+	"\n" +
+	"\rcontrol.proto\x12\x10llrdc.control.v1\"\x13\n" +
+	"\x11GetSessionRequest\"\x8a\x02\n" +
+	"\aSession\x12\x18\n" +
+	"\adisplay\x18\x01 \x01(\tR\adisplay\x12\x14\n" +
+	"\x05width\x18\x02 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x03 \x01(\x05R\x06height\x12\x1a\n" +
+	"\brotation\x18\x04 \x01(\tR\brotation\x12\x10\n" +
+	"\x03dpi\x18\x05 \x01(\x05R\x03dpi\x12\x16\n" +
+	"\x06locked\x18\x06 \x01(\bR\x06locked\x12!\n" +
+	"\fclient_count\x18\a \x01(\x05R\vclientCount\x12!\n" +
+	"\ftest_pattern\x18\b \x01(\bR\vtestPattern\x12+\n" +
+	"\x12last_input_unix_ms\x18\t \x01(\x03R\x0flastInputUnixMs\"*\n" +
+	"\x10SetLockedRequest\x12\x16\n" +
+	"\x06locked\x18\x01 \x01(\bR\x06locked\"\x14\n" +
+	"\x12ListClientsRequest\"\xb4\x01\n" +
+	"\n" +
+	"ClientInfo\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x1a\n" +
+	"\bidentity\x18\x02 \x01(\tR\bidentity\x12*\n" +
+	"\x11connected_unix_ms\x18\x03 \x01(\x03R\x0fconnectedUnixMs\x12\x16\n" +
+	"\x06webrtc\x18\x04 \x01(\bR\x06webrtc\x12,\n" +
+	"\x12bandwidth_cap_mbps\x18\x05 \x01(\x01R\x10bandwidthCapMbps\"M\n" +
+	"\x13ListClientsResponse\x126\n" +
+	"\aclients\x18\x01 \x03(\v2\x1c.llrdc.control.v1.ClientInfoR\aclients\"3\n" +
+	"\x17DisconnectClientRequest\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\"\x1a\n" +
+	"\x18DisconnectClientResponse\"\x1b\n" +
+	"\x19GetEncoderSettingsRequest\"\xc5\x06\n" +
+	"\x0fEncoderSettings\x12$\n" +
+	"\vvideo_codec\x18\x01 \x01(\tH\x00R\n" +
+	"videoCodec\x88\x01\x01\x12!\n" +
+	"\tframerate\x18\x02 \x01(\x05H\x01R\tframerate\x88\x01\x01\x12*\n" +
+	"\x0ebandwidth_mbps\x18\x03 \x01(\x05H\x02R\rbandwidthMbps\x88\x01\x01\x12\x1d\n" +
+	"\aquality\x18\x04 \x01(\x05H\x03R\aquality\x88\x01\x01\x12\x15\n" +
+	"\x03vbr\x18\x05 \x01(\bH\x04R\x03vbr\x88\x01\x01\x12#\n" +
+	"\n" +
+	"mpdecimate\x18\x06 \x01(\bH\x05R\n" +
+	"mpdecimate\x88\x01\x01\x120\n" +
+	"\x11keyframe_interval\x18\a \x01(\x05H\x06R\x10keyframeInterval\x88\x01\x01\x12&\n" +
+	"\fcontent_tune\x18\b \x01(\tH\aR\vcontentTune\x88\x01\x01\x12\x1b\n" +
+	"\x06chroma\x18\t \x01(\tH\bR\x06chroma\x88\x01\x01\x12\"\n" +
+	"\n" +
+	"cpu_effort\x18\n" +
+	" \x01(\x05H\tR\tcpuEffort\x88\x01\x01\x12$\n" +
+	"\vcpu_threads\x18\v \x01(\x05H\n" +
+	"R\n" +
+	"cpuThreads\x88\x01\x01\x12&\n" +
+	"\fauto_quality\x18\f \x01(\bH\vR\vautoQuality\x88\x01\x01\x12&\n" +
+	"\fenable_audio\x18\r \x01(\bH\fR\venableAudio\x88\x01\x01\x12(\n" +
+	"\raudio_bitrate\x18\x0e \x01(\tH\rR\faudioBitrate\x88\x01\x01\x125\n" +
+	"\x14enable_desktop_mouse\x18\x0f \x01(\bH\x0eR\x12enableDesktopMouse\x88\x01\x01B\x0e\n" +
+	"\f_video_codecB\f\n" +
+	"\n" +
+	"_framerateB\x11\n" +
+	"\x0f_bandwidth_mbpsB\n" +
+	"\n" +
+	"\b_qualityB\x06\n" +
+	"\x04_vbrB\r\n" +
+	"\v_mpdecimateB\x14\n" +
+	"\x12_keyframe_intervalB\x0f\n" +
+	"\r_content_tuneB\t\n" +
+	"\a_chromaB\r\n" +
+	"\v_cpu_effortB\x0e\n" +
+	"\f_cpu_threadsB\x0f\n" +
+	"\r_auto_qualityB\x0f\n" +
+	"\r_enable_audioB\x10\n" +
+	"\x0e_audio_bitrateB\x17\n" +
+	"\x15_enable_desktop_mouse\"\x11\n" +
+	"\x0fGetStatsRequest\"4\n" +
+	"\x11WatchStatsRequest\x12\x1f\n" +
+	"\vinterval_ms\x18\x01 \x01(\x05R\n" +
+	"intervalMs\"\xbd\x01\n" +
+	"\x05Stats\x12,\n" +
+	"\x12ffmpeg_cpu_percent\x18\x01 \x01(\x01R\x10ffmpegCpuPercent\x12)\n" +
+	"\x10encoder_restarts\x18\x02 \x01(\x03R\x0fencoderRestarts\x12\x1f\n" +
+	"\vcapture_fps\x18\x03 \x01(\x01R\n" +
+	"captureFps\x12!\n" +
+	"\fclient_count\x18\x04 \x01(\x05R\vclientCount\x12\x17\n" +
+	"\aunix_ms\x18\x05 \x01(\x03R\x06unixMs\")\n" +
+	"\x11ScreenshotRequest\x12\x14\n" +
+	"\x05width\x18\x01 \x01(\x05R\x05width\"V\n" +
+	"\x12ScreenshotResponse\x12\x12\n" +
+	"\x04jpeg\x18\x01 \x01(\fR\x04jpeg\x12\x14\n" +
+	"\x05width\x18\x02 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x03 \x01(\x05R\x06height\"j\n" +
+	"\x10BroadcastRequest\x12\x12\n" +
+	"\x04text\x18\x01 \x01(\tR\x04text\x12)\n" +
+	"\x10duration_seconds\x18\x02 \x01(\x05R\x0fdurationSeconds\x12\x17\n" +
+	"\aburn_in\x18\x03 \x01(\bR\x06burnIn\"6\n" +
+	"\x11BroadcastResponse\x12!\n" +
+	"\fclient_count\x18\x01 \x01(\x05R\vclientCount\"\x16\n" +
+	"\x14GetEncoderLogRequest\"\xbd\x01\n" +
+	"\n" +
+	"EncoderRun\x12&\n" +
+	"\x0fstarted_unix_ms\x18\x01 \x01(\x03R\rstartedUnixMs\x12\"\n" +
+	"\rended_unix_ms\x18\x02 \x01(\x03R\vendedUnixMs\x12\x12\n" +
+	"\x04args\x18\x03 \x03(\tR\x04args\x12\x12\n" +
+	"\x04exit\x18\x04 \x01(\tR\x04exit\x12\x16\n" +
+	"\x06stderr\x18\x05 \x01(\fR\x06stderr\x12#\n" +
+	"\rdropped_bytes\x18\x06 \x01(\x03R\fdroppedBytes\"I\n" +
+	"\x15GetEncoderLogResponse\x120\n" +
+	"\x04runs\x18\x01 \x03(\v2\x1c.llrdc.control.v1.EncoderRunR\x04runs2\xd6\a\n" +
+	"\aControl\x12L\n" +
+	"\n" +
+	"GetSession\x12#.llrdc.control.v1.GetSessionRequest\x1a\x19.llrdc.control.v1.Session\x12J\n" +
+	"\tSetLocked\x12\".llrdc.control.v1.SetLockedRequest\x1a\x19.llrdc.control.v1.Session\x12Z\n" +
+	"\vListClients\x12$.llrdc.control.v1.ListClientsRequest\x1a%.llrdc.control.v1.ListClientsResponse\x12i\n" +
+	"\x10DisconnectClient\x12).llrdc.control.v1.DisconnectClientRequest\x1a*.llrdc.control.v1.DisconnectClientResponse\x12d\n" +
+	"\x12GetEncoderSettings\x12+.llrdc.control.v1.GetEncoderSettingsRequest\x1a!.llrdc.control.v1.EncoderSettings\x12]\n" +
+	"\x15UpdateEncoderSettings\x12!.llrdc.control.v1.EncoderSettings\x1a!.llrdc.control.v1.EncoderSettings\x12F\n" +
+	"\bGetStats\x12!.llrdc.control.v1.GetStatsRequest\x1a\x17.llrdc.control.v1.Stats\x12L\n" +
+	"\n" +
+	"WatchStats\x12#.llrdc.control.v1.WatchStatsRequest\x1a\x17.llrdc.control.v1.Stats0\x01\x12W\n" +
+	"\n" +
+	"Screenshot\x12#.llrdc.control.v1.ScreenshotRequest\x1a$.llrdc.control.v1.ScreenshotResponse\x12T\n" +
+	"\tBroadcast\x12\".llrdc.control.v1.BroadcastRequest\x1a#.llrdc.control.v1.BroadcastResponse\x12`\n" +
+	"\rGetEncoderLog\x12&.llrdc.control.v1.GetEncoderLogRequest\x1a'.llrdc.control.v1.GetEncoderLogResponseB+Z)github.com/danchitnis/llrdc/pkg/controlpbb\x06proto3"
+
+var (
+	file_control_proto_rawDescOnce sync.Once
+	file_control_proto_rawDescData []byte
+)
+
+func file_control_proto_rawDescGZIP() []byte {
+	file_control_proto_rawDescOnce.Do(func() {
+		file_control_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_control_proto_rawDesc), len(file_control_proto_rawDesc)))
+	})
+	return file_control_proto_rawDescData
+}
+
+var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
+var file_control_proto_goTypes = []any{
+	(*GetSessionRequest)(nil),         // 0: llrdc.control.v1.GetSessionRequest
+	(*Session)(nil),                   // 1: llrdc.control.v1.Session
+	(*SetLockedRequest)(nil),          // 2: llrdc.control.v1.SetLockedRequest
+	(*ListClientsRequest)(nil),        // 3: llrdc.control.v1.ListClientsRequest
+	(*ClientInfo)(nil),                // 4: llrdc.control.v1.ClientInfo
+	(*ListClientsResponse)(nil),       // 5: llrdc.control.v1.ListClientsResponse
+	(*DisconnectClientRequest)(nil),   // 6: llrdc.control.v1.DisconnectClientRequest
+	(*DisconnectClientResponse)(nil),  // 7: llrdc.control.v1.DisconnectClientResponse
+	(*GetEncoderSettingsRequest)(nil), // 8: llrdc.control.v1.GetEncoderSettingsRequest
+	(*EncoderSettings)(nil),           // 9: llrdc.control.v1.EncoderSettings
+	(*GetStatsRequest)(nil),           // 10: llrdc.control.v1.GetStatsRequest
+	(*WatchStatsRequest)(nil),         // 11: llrdc.control.v1.WatchStatsRequest
+	(*Stats)(nil),                     // 12: llrdc.control.v1.Stats
+	(*ScreenshotRequest)(nil),         // 13: llrdc.control.v1.ScreenshotRequest
+	(*ScreenshotResponse)(nil),        // 14: llrdc.control.v1.ScreenshotResponse
+	(*BroadcastRequest)(nil),          // 15: llrdc.control.v1.BroadcastRequest
+	(*BroadcastResponse)(nil),         // 16: llrdc.control.v1.BroadcastResponse
+	(*GetEncoderLogRequest)(nil),      // 17: llrdc.control.v1.GetEncoderLogRequest
+	(*EncoderRun)(nil),                // 18: llrdc.control.v1.EncoderRun
+	(*GetEncoderLogResponse)(nil),     // 19: llrdc.control.v1.GetEncoderLogResponse
+}
+var file_control_proto_depIdxs = []int32{
+	4,  // 0: llrdc.control.v1.ListClientsResponse.clients:type_name -> llrdc.control.v1.ClientInfo
+	18, // 1: llrdc.control.v1.GetEncoderLogResponse.runs:type_name -> llrdc.control.v1.EncoderRun
+	0,  // 2: llrdc.control.v1.Control.GetSession:input_type -> llrdc.control.v1.GetSessionRequest
+	2,  // 3: llrdc.control.v1.Control.SetLocked:input_type -> llrdc.control.v1.SetLockedRequest
+	3,  // 4: llrdc.control.v1.Control.ListClients:input_type -> llrdc.control.v1.ListClientsRequest
+	6,  // 5: llrdc.control.v1.Control.DisconnectClient:input_type -> llrdc.control.v1.DisconnectClientRequest
+	8,  // 6: llrdc.control.v1.Control.GetEncoderSettings:input_type -> llrdc.control.v1.GetEncoderSettingsRequest
+	9,  // 7: llrdc.control.v1.Control.UpdateEncoderSettings:input_type -> llrdc.control.v1.EncoderSettings
+	10, // 8: llrdc.control.v1.Control.GetStats:input_type -> llrdc.control.v1.GetStatsRequest
+	11, // 9: llrdc.control.v1.Control.WatchStats:input_type -> llrdc.control.v1.WatchStatsRequest
+	13, // 10: llrdc.control.v1.Control.Screenshot:input_type -> llrdc.control.v1.ScreenshotRequest
+	15, // 11: llrdc.control.v1.Control.Broadcast:input_type -> llrdc.control.v1.BroadcastRequest
+	17, // 12: llrdc.control.v1.Control.GetEncoderLog:input_type -> llrdc.control.v1.GetEncoderLogRequest
+	1,  // 13: llrdc.control.v1.Control.GetSession:output_type -> llrdc.control.v1.Session
+	1,  // 14: llrdc.control.v1.Control.SetLocked:output_type -> llrdc.control.v1.Session
+	5,  // 15: llrdc.control.v1.Control.ListClients:output_type -> llrdc.control.v1.ListClientsResponse
+	7,  // 16: llrdc.control.v1.Control.DisconnectClient:output_type -> llrdc.control.v1.DisconnectClientResponse
+	9,  // 17: llrdc.control.v1.Control.GetEncoderSettings:output_type -> llrdc.control.v1.EncoderSettings
+	9,  // 18: llrdc.control.v1.Control.UpdateEncoderSettings:output_type -> llrdc.control.v1.EncoderSettings
+	12, // 19: llrdc.control.v1.Control.GetStats:output_type -> llrdc.control.v1.Stats
+	12, // 20: llrdc.control.v1.Control.WatchStats:output_type -> llrdc.control.v1.Stats
+	14, // 21: llrdc.control.v1.Control.Screenshot:output_type -> llrdc.control.v1.ScreenshotResponse
+	16, // 22: llrdc.control.v1.Control.Broadcast:output_type -> llrdc.control.v1.BroadcastResponse
+	19, // 23: llrdc.control.v1.Control.GetEncoderLog:output_type -> llrdc.control.v1.GetEncoderLogResponse
+	13, // [13:24] is the sub-list for method output_type
+	2,  // [2:13] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_control_proto_init() }
+func file_control_proto_init() {
+	if File_control_proto != nil {
+		return
+	}
+	file_control_proto_msgTypes[9].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_control_proto_rawDesc), len(file_control_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   20,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_control_proto_goTypes,
+		DependencyIndexes: file_control_proto_depIdxs,
+		MessageInfos:      file_control_proto_msgTypes,
+	}.Build()
+	File_control_proto = out.File
+	file_control_proto_goTypes = nil
+	file_control_proto_depIdxs = nil
+}
@@ -0,0 +1,539 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: control.proto
+
+// Control-plane API of an llrdc server, for fleet-management tools. Served
+// on --grpc-addr alongside HTTP. Regenerate the Go code with
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative control.proto
+
+package controlpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Control_GetSession_FullMethodName            = "/llrdc.control.v1.Control/GetSession"
+	Control_SetLocked_FullMethodName             = "/llrdc.control.v1.Control/SetLocked"
+	Control_ListClients_FullMethodName           = "/llrdc.control.v1.Control/ListClients"
+	Control_DisconnectClient_FullMethodName      = "/llrdc.control.v1.Control/DisconnectClient"
+	Control_GetEncoderSettings_FullMethodName    = "/llrdc.control.v1.Control/GetEncoderSettings"
+	Control_UpdateEncoderSettings_FullMethodName = "/llrdc.control.v1.Control/UpdateEncoderSettings"
+	Control_GetStats_FullMethodName              = "/llrdc.control.v1.Control/GetStats"
+	Control_WatchStats_FullMethodName            = "/llrdc.control.v1.Control/WatchStats"
+	Control_Screenshot_FullMethodName            = "/llrdc.control.v1.Control/Screenshot"
+	Control_Broadcast_FullMethodName             = "/llrdc.control.v1.Control/Broadcast"
+	Control_GetEncoderLog_FullMethodName         = "/llrdc.control.v1.Control/GetEncoderLog"
+)
+
+// ControlClient is the client API for Control service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ControlClient interface {
+	// GetSession describes the desktop session.
+	GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// SetLocked locks or unlocks the session, as the idle lock does.
+	SetLocked(ctx context.Context, in *SetLockedRequest, opts ...grpc.CallOption) (*Session, error)
+	// ListClients lists the connected viewers.
+	ListClients(ctx context.Context, in *ListClientsRequest, opts ...grpc.CallOption) (*ListClientsResponse, error)
+	// DisconnectClient closes a viewer's connection.
+	DisconnectClient(ctx context.Context, in *DisconnectClientRequest, opts ...grpc.CallOption) (*DisconnectClientResponse, error)
+	// GetEncoderSettings returns the current encoder settings.
+	GetEncoderSettings(ctx context.Context, in *GetEncoderSettingsRequest, opts ...grpc.CallOption) (*EncoderSettings, error)
+	// UpdateEncoderSettings changes the settings that are set in the request
+	// and returns the result, as the viewer's settings panel does.
+	UpdateEncoderSettings(ctx context.Context, in *EncoderSettings, opts ...grpc.CallOption) (*EncoderSettings, error)
+	// GetStats returns encoder and client statistics.
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error)
+	// WatchStats streams statistics at an interval.
+	WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Stats], error)
+	// Screenshot captures the screen as a JPEG.
+	Screenshot(ctx context.Context, in *ScreenshotRequest, opts ...grpc.CallOption) (*ScreenshotResponse, error)
+	// Broadcast shows a text banner to all viewers, replacing the current
+	// one; an empty text removes it.
+	Broadcast(ctx context.Context, in *BroadcastRequest, opts ...grpc.CallOption) (*BroadcastResponse, error)
+	// GetEncoderLog returns the stderr of the last few encoder runs, for
+	// debugging.
+	GetEncoderLog(ctx context.Context, in *GetEncoderLogRequest, opts ...grpc.CallOption) (*GetEncoderLogResponse, error)
+}
+
+type controlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewControlClient(cc grpc.ClientConnInterface) ControlClient {
+	return &controlClient{cc}
+}
+
+func (c *controlClient) GetSession(ctx context.Context, in *GetSessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Control_GetSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) SetLocked(ctx context.Context, in *SetLockedRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Control_SetLocked_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ListClients(ctx context.Context, in *ListClientsRequest, opts ...grpc.CallOption) (*ListClientsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListClientsResponse)
+	err := c.cc.Invoke(ctx, Control_ListClients_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) DisconnectClient(ctx context.Context, in *DisconnectClientRequest, opts ...grpc.CallOption) (*DisconnectClientResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DisconnectClientResponse)
+	err := c.cc.Invoke(ctx, Control_DisconnectClient_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) GetEncoderSettings(ctx context.Context, in *GetEncoderSettingsRequest, opts ...grpc.CallOption) (*EncoderSettings, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EncoderSettings)
+	err := c.cc.Invoke(ctx, Control_GetEncoderSettings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) UpdateEncoderSettings(ctx context.Context, in *EncoderSettings, opts ...grpc.CallOption) (*EncoderSettings, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EncoderSettings)
+	err := c.cc.Invoke(ctx, Control_UpdateEncoderSettings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Control_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) WatchStats(ctx context.Context, in *WatchStatsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Stats], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Control_ServiceDesc.Streams[0], Control_WatchStats_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchStatsRequest, Stats]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Control_WatchStatsClient = grpc.ServerStreamingClient[Stats]
+
+func (c *controlClient) Screenshot(ctx context.Context, in *ScreenshotRequest, opts ...grpc.CallOption) (*ScreenshotResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScreenshotResponse)
+	err := c.cc.Invoke(ctx, Control_Screenshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) Broadcast(ctx context.Context, in *BroadcastRequest, opts ...grpc.CallOption) (*BroadcastResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BroadcastResponse)
+	err := c.cc.Invoke(ctx, Control_Broadcast_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) GetEncoderLog(ctx context.Context, in *GetEncoderLogRequest, opts ...grpc.CallOption) (*GetEncoderLogResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetEncoderLogResponse)
+	err := c.cc.Invoke(ctx, Control_GetEncoderLog_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ControlServer is the server API for Control service.
+// All implementations must embed UnimplementedControlServer
+// for forward compatibility.
+type ControlServer interface {
+	// GetSession describes the desktop session.
+	GetSession(context.Context, *GetSessionRequest) (*Session, error)
+	// SetLocked locks or unlocks the session, as the idle lock does.
+	SetLocked(context.Context, *SetLockedRequest) (*Session, error)
+	// ListClients lists the connected viewers.
+	ListClients(context.Context, *ListClientsRequest) (*ListClientsResponse, error)
+	// DisconnectClient closes a viewer's connection.
+	DisconnectClient(context.Context, *DisconnectClientRequest) (*DisconnectClientResponse, error)
+	// GetEncoderSettings returns the current encoder settings.
+	GetEncoderSettings(context.Context, *GetEncoderSettingsRequest) (*EncoderSettings, error)
+	// UpdateEncoderSettings changes the settings that are set in the request
+	// and returns the result, as the viewer's settings panel does.
+	UpdateEncoderSettings(context.Context, *EncoderSettings) (*EncoderSettings, error)
+	// GetStats returns encoder and client statistics.
+	GetStats(context.Context, *GetStatsRequest) (*Stats, error)
+	// WatchStats streams statistics at an interval.
+	WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[Stats]) error
+	// Screenshot captures the screen as a JPEG.
+	Screenshot(context.Context, *ScreenshotRequest) (*ScreenshotResponse, error)
+	// Broadcast shows a text banner to all viewers, replacing the current
+	// one; an empty text removes it.
+	Broadcast(context.Context, *BroadcastRequest) (*BroadcastResponse, error)
+	// GetEncoderLog returns the stderr of the last few encoder runs, for
+	// debugging.
+	GetEncoderLog(context.Context, *GetEncoderLogRequest) (*GetEncoderLogResponse, error)
+	mustEmbedUnimplementedControlServer()
+}
+
+// UnimplementedControlServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedControlServer struct{}
+
+func (UnimplementedControlServer) GetSession(context.Context, *GetSessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSession not implemented")
+}
+func (UnimplementedControlServer) SetLocked(context.Context, *SetLockedRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLocked not implemented")
+}
+func (UnimplementedControlServer) ListClients(context.Context, *ListClientsRequest) (*ListClientsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListClients not implemented")
+}
+func (UnimplementedControlServer) DisconnectClient(context.Context, *DisconnectClientRequest) (*DisconnectClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisconnectClient not implemented")
+}
+func (UnimplementedControlServer) GetEncoderSettings(context.Context, *GetEncoderSettingsRequest) (*EncoderSettings, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEncoderSettings not implemented")
+}
+func (UnimplementedControlServer) UpdateEncoderSettings(context.Context, *EncoderSettings) (*EncoderSettings, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateEncoderSettings not implemented")
+}
+func (UnimplementedControlServer) GetStats(context.Context, *GetStatsRequest) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedControlServer) WatchStats(*WatchStatsRequest, grpc.ServerStreamingServer[Stats]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStats not implemented")
+}
+func (UnimplementedControlServer) Screenshot(context.Context, *ScreenshotRequest) (*ScreenshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Screenshot not implemented")
+}
+func (UnimplementedControlServer) Broadcast(context.Context, *BroadcastRequest) (*BroadcastResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Broadcast not implemented")
+}
+func (UnimplementedControlServer) GetEncoderLog(context.Context, *GetEncoderLogRequest) (*GetEncoderLogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEncoderLog not implemented")
+}
+func (UnimplementedControlServer) mustEmbedUnimplementedControlServer() {}
+func (UnimplementedControlServer) testEmbeddedByValue()                 {}
+
+// UnsafeControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ControlServer will
+// result in compilation errors.
+type UnsafeControlServer interface {
+	mustEmbedUnimplementedControlServer()
+}
+
+func RegisterControlServer(s grpc.ServiceRegistrar, srv ControlServer) {
+	// If the following call pancis, it indicates UnimplementedControlServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Control_ServiceDesc, srv)
+}
+
+func _Control_GetSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetSession(ctx, req.(*GetSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_SetLocked_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetLockedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).SetLocked(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_SetLocked_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).SetLocked(ctx, req.(*SetLockedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ListClients_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListClientsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ListClients(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ListClients_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ListClients(ctx, req.(*ListClientsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_DisconnectClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisconnectClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).DisconnectClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_DisconnectClient_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).DisconnectClient(ctx, req.(*DisconnectClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_GetEncoderSettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEncoderSettingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetEncoderSettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetEncoderSettings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetEncoderSettings(ctx, req.(*GetEncoderSettingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_UpdateEncoderSettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncoderSettings)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).UpdateEncoderSettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_UpdateEncoderSettings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).UpdateEncoderSettings(ctx, req.(*EncoderSettings))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_WatchStats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStatsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControlServer).WatchStats(m, &grpc.GenericServerStream[WatchStatsRequest, Stats]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Control_WatchStatsServer = grpc.ServerStreamingServer[Stats]
+
+func _Control_Screenshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScreenshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).Screenshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_Screenshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).Screenshot(ctx, req.(*ScreenshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_Broadcast_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BroadcastRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).Broadcast(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_Broadcast_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).Broadcast(ctx, req.(*BroadcastRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_GetEncoderLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEncoderLogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetEncoderLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetEncoderLog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetEncoderLog(ctx, req.(*GetEncoderLogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Control_ServiceDesc is the grpc.ServiceDesc for Control service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Control_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "llrdc.control.v1.Control",
+	HandlerType: (*ControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSession",
+			Handler:    _Control_GetSession_Handler,
+		},
+		{
+			MethodName: "SetLocked",
+			Handler:    _Control_SetLocked_Handler,
+		},
+		{
+			MethodName: "ListClients",
+			Handler:    _Control_ListClients_Handler,
+		},
+		{
+			MethodName: "DisconnectClient",
+			Handler:    _Control_DisconnectClient_Handler,
+		},
+		{
+			MethodName: "GetEncoderSettings",
+			Handler:    _Control_GetEncoderSettings_Handler,
+		},
+		{
+			MethodName: "UpdateEncoderSettings",
+			Handler:    _Control_UpdateEncoderSettings_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Control_GetStats_Handler,
+		},
+		{
+			MethodName: "Screenshot",
+			Handler:    _Control_Screenshot_Handler,
+		},
+		{
+			MethodName: "Broadcast",
+			Handler:    _Control_Broadcast_Handler,
+		},
+		{
+			MethodName: "GetEncoderLog",
+			Handler:    _Control_GetEncoderLog_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStats",
+			Handler:       _Control_WatchStats_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "control.proto",
+}
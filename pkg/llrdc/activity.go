@@ -0,0 +1,112 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Activity-based encoder settings: a mostly idle desktop does not need to
+// be captured and encoded at full rate, and can afford a near-lossless
+// frame. Once neither XDamage nor viewer input has reported activity for
+// activityIdleAfter, ffmpeg is restarted at IdleFPS and/or IdleQuality; the
+// first sign of activity restarts it with the normal settings.
+
+const (
+	activityIdleAfter = 2 * time.Second
+	activityInterval  = 250 * time.Millisecond
+	// activityMinArea ignores small repaints such as blinking text cursors
+	// and panel clocks, which would otherwise keep the desktop "active".
+	activityMinArea = 64 * 64
+)
+
+var (
+	lastActivityTime atomic.Int64
+	activityIdle     atomic.Bool
+	// captureFPS is the rate ffmpeg is currently capturing at, for stats.
+	captureFPS atomic.Int32
+)
+
+// noteActivity records screen or input activity and, if the capture rate
+// had been lowered, restores it immediately.
+func noteActivity() {
+	lastActivityTime.Store(time.Now().UnixNano())
+	if activityIdle.CompareAndSwap(true, false) {
+		log.Println("Screen active, restoring normal encoder settings")
+		restartCapture()
+	}
+}
+
+// noteDamage reports a damaged screen area of w x h pixels.
+func noteDamage(w, h int) {
+	if w*h >= activityMinArea {
+		noteActivity()
+	}
+}
+
+// effectiveFPS returns the capture rate for a configured rate of fps.
+func effectiveFPS(fps int) int {
+	if activityIdle.Load() && IdleFPS > 0 && IdleFPS < fps {
+		return IdleFPS
+	}
+	return fps
+}
+
+// idleRefinement returns the rate control to use in place of mode, quality
+// and vbr. While idle with IdleQuality set, the encoder switches to VBR
+// quality mode so the restart keyframe renders text near-losslessly; the
+// static frames that follow cost almost nothing.
+func idleRefinement(mode string, quality int, vbr bool) (string, int, bool) {
+	if activityIdle.Load() && IdleQuality > 0 {
+		return "quality", IdleQuality, true
+	}
+	return mode, quality, vbr
+}
+
+// idleChangesEncoder reports whether going idle would change any encoder
+// setting at the configured rate of fps.
+func idleChangesEncoder(fps int) bool {
+	return (IdleFPS > 0 && IdleFPS < fps) || IdleQuality > 0
+}
+
+// startActivityTracker switches the encoder to its idle settings after a
+// period without activity. It needs XDamage, so it is not used with the
+// test pattern.
+func startActivityTracker(ctx context.Context) {
+	if IdleFPS <= 0 && IdleQuality <= 0 {
+		return
+	}
+	noteActivity()
+	goWorker(func() {
+		defer activityIdle.Store(false)
+		for sleepCtx(ctx, activityInterval) {
+			if activityIdle.Load() {
+				continue
+			}
+			ffmpegMutex.Lock()
+			fps := FPS
+			ffmpegMutex.Unlock()
+			if !idleChangesEncoder(fps) {
+				continue
+			}
+			idleFor := time.Since(time.Unix(0, lastActivityTime.Load()))
+			if idleFor < activityIdleAfter {
+				continue
+			}
+			if activityIdle.CompareAndSwap(false, true) {
+				log.Printf("Screen idle for %v, switching to idle encoder settings (%d fps, quality %d)", idleFor.Round(time.Second), effectiveFPS(fps), IdleQuality)
+				restartCapture()
+			}
+		}
+	})
+}
+
+// restartCapture restarts ffmpeg so it picks up the idle or normal settings.
+func restartCapture() {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+		ffmpegCmd.Process.Kill()
+	}
+}
@@ -0,0 +1,212 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	adaptiveInterval      = 2 * time.Second
+	adaptiveCooldown      = 6 * time.Second
+	adaptiveStaleAfter    = 10 * time.Second
+	adaptiveCongestedLoss = 0.10
+	adaptiveClearLoss     = 0.02
+	adaptiveCongestedRTT  = 400 * time.Millisecond
+	adaptiveClearRTT      = 200 * time.Millisecond
+	adaptiveClearRounds   = 3
+	adaptiveMinBandwidth  = 1
+	adaptiveMinFPS        = 15
+)
+
+// linkStats is the latest network feedback for one WebRTC client, taken
+// from RTCP receiver reports (loss, jitter) and ICE checks (RTT).
+type linkStats struct {
+	FractionLost float64
+	Jitter       uint32
+	RTT          time.Duration
+	Updated      time.Time
+}
+
+var (
+	linkStatsMutex sync.Mutex
+	linkStatsByPC  = make(map[*webrtc.PeerConnection]*linkStats)
+
+	targetAutoQuality = false
+	autoBaseFPS       int
+)
+
+// readVideoRTCP consumes RTCP for the video sender of pc and records the
+// receiver report statistics used by auto quality mode. It returns when the
+// PeerConnection is closed.
+func readVideoRTCP(pc *webrtc.PeerConnection, sender *webrtc.RTPSender) {
+	defer func() {
+		linkStatsMutex.Lock()
+		delete(linkStatsByPC, pc)
+		linkStatsMutex.Unlock()
+	}()
+
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, p := range packets {
+			rr, ok := p.(*rtcp.ReceiverReport)
+			if !ok {
+				continue
+			}
+			for _, report := range rr.Reports {
+				linkStatsMutex.Lock()
+				stats, ok := linkStatsByPC[pc]
+				if !ok {
+					stats = &linkStats{}
+					linkStatsByPC[pc] = stats
+				}
+				stats.FractionLost = float64(report.FractionLost) / 256
+				stats.Jitter = report.Jitter
+				stats.Updated = time.Now()
+				linkStatsMutex.Unlock()
+			}
+		}
+	}
+}
+
+// currentRTT returns the round trip time of the selected ICE candidate pair.
+func currentRTT(pc *webrtc.PeerConnection) time.Duration {
+	for _, s := range pc.GetStats() {
+		pair, ok := s.(webrtc.ICECandidatePairStats)
+		if !ok || !pair.Nominated || pair.State != webrtc.StatsICECandidatePairStateSucceeded {
+			continue
+		}
+		return time.Duration(pair.CurrentRoundTripTime * float64(time.Second))
+	}
+	return 0
+}
+
+// worstLink returns the highest loss and RTT across all clients with fresh
+// feedback. The encoder is shared, so it has to suit the weakest link.
+func worstLink() (loss float64, rtt time.Duration, ok bool) {
+	linkStatsMutex.Lock()
+	pcs := make(map[*webrtc.PeerConnection]*linkStats, len(linkStatsByPC))
+	for pc, s := range linkStatsByPC {
+		pcs[pc] = s
+	}
+	linkStatsMutex.Unlock()
+
+	for pc, s := range pcs {
+		r := currentRTT(pc)
+		linkStatsMutex.Lock()
+		s.RTT = r
+		fresh := time.Since(s.Updated) < adaptiveStaleAfter
+		lost := s.FractionLost
+		linkStatsMutex.Unlock()
+		if !fresh {
+			continue
+		}
+		ok = true
+		if lost > loss {
+			loss = lost
+		}
+		if r > rtt {
+			rtt = r
+		}
+	}
+	return loss, rtt, ok
+}
+
+func SetAutoQuality(enable bool) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+
+	if targetAutoQuality == enable {
+		return
+	}
+	targetAutoQuality = enable
+	if enable {
+		autoBaseFPS = FPS
+		log.Printf("Auto quality enabled (starting at %d Mbps, %d fps)", targetBandwidthMbps, FPS)
+	} else {
+		log.Println("Auto quality disabled")
+	}
+}
+
+// startAdaptiveQuality periodically steps bandwidth and framerate down when
+// clients report loss or high RTT, and back up once the links are clear.
+func startAdaptiveQuality(ctx context.Context) {
+	goWorker(func() {
+		var lastChange time.Time
+		clearRounds := 0
+
+		for sleepCtx(ctx, adaptiveInterval) {
+
+			ffmpegMutex.Lock()
+			auto := targetAutoQuality
+			bw := targetBandwidthMbps
+			fps := FPS
+			baseFPS := autoBaseFPS
+			ffmpegMutex.Unlock()
+			if !auto {
+				clearRounds = 0
+				continue
+			}
+
+			loss, rtt, ok := worstLink()
+			if !ok {
+				continue
+			}
+
+			congested := loss > adaptiveCongestedLoss || rtt > adaptiveCongestedRTT
+			linkClear := loss < adaptiveClearLoss && rtt < adaptiveClearRTT
+			if linkClear {
+				clearRounds++
+			} else {
+				clearRounds = 0
+			}
+
+			if time.Since(lastChange) < adaptiveCooldown {
+				continue
+			}
+
+			newBW, newFPS := bw, fps
+			if congested {
+				if bw > adaptiveMinBandwidth {
+					newBW = bw * 7 / 10
+					if newBW < adaptiveMinBandwidth {
+						newBW = adaptiveMinBandwidth
+					}
+				} else if fps > adaptiveMinFPS {
+					newFPS = fps / 2
+					if newFPS < adaptiveMinFPS {
+						newFPS = adaptiveMinFPS
+					}
+				}
+			} else if clearRounds >= adaptiveClearRounds {
+				if fps < baseFPS {
+					newFPS = baseFPS
+				} else if bw < AutoMaxBandwidth {
+					newBW = bw + 1
+				}
+			}
+
+			if newBW == bw && newFPS == fps {
+				continue
+			}
+
+			log.Printf("Auto quality: loss=%.1f%% rtt=%v -> %d Mbps, %d fps (was %d Mbps, %d fps)",
+				loss*100, rtt.Round(time.Millisecond), newBW, newFPS, bw, fps)
+			ffmpegMutex.Lock()
+			FPS = newFPS
+			ffmpegMutex.Unlock()
+			encoder.SetBitrate(newBW)
+			broadcastConfig(false)
+
+			lastChange = time.Now()
+			clearRounds = 0
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package llrdc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Audio device selection. Viewers can list the session's PulseAudio sinks,
+// sources and playing applications with {"type":"audio_device_list"} and
+// pick what the audio stream carries with the "audio_device" config
+// setting: a source is captured as is, a sink through its monitor, and
+// "app:<name>" captures one application only. For that the application's
+// streams are moved to a null sink of their own, audioAppSink, whose
+// monitor is captured; the router below also moves streams the
+// application opens later, and moves them all back to the default sink
+// when another device is picked. "" is the default source.
+
+const (
+	audioAppSink       = "llrdc_app"
+	audioAppPrefix     = "app:"
+	audioRouteInterval = 2 * time.Second
+)
+
+// audioDevice is an entry of an audio_devices message.
+type audioDevice struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Kind is "source", "sink" or "app".
+	Kind string `json:"kind"`
+}
+
+// pactlBlock is one object of `pactl list`: its index, fields and
+// properties.
+type pactlBlock struct {
+	index  string
+	fields map[string]string
+	props  map[string]string
+}
+
+func pactlOutput(args ...string) (string, error) {
+	cmd := exec.Command("pactl", args...)
+	// The output is parsed, so it must not be translated.
+	cmd.Env = append(sessionEnviron(Display), "LC_ALL=C")
+	runAsSessionUser(cmd)
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// pactlList lists the objects of a `pactl list` kind ("sinks", "sources",
+// "sink-inputs"), whose blocks start with header ("Sink", "Source",
+// "Sink Input") and a number.
+func pactlList(kind, header string) ([]pactlBlock, error) {
+	out, err := pactlOutput("list", kind)
+	if err != nil {
+		return nil, fmt.Errorf("pactl list %s: %w", kind, err)
+	}
+	var blocks []pactlBlock
+	for _, line := range strings.Split(out, "\n") {
+		if index, ok := strings.CutPrefix(line, header+" #"); ok {
+			blocks = append(blocks, pactlBlock{index: index, fields: map[string]string{}, props: map[string]string{}})
+			continue
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		b := blocks[len(blocks)-1]
+		if strings.HasPrefix(line, "\t\t") {
+			// Properties: `application.name = "Firefox"`.
+			if key, value, ok := strings.Cut(strings.TrimSpace(line), " = "); ok {
+				b.props[key] = strings.Trim(value, `"`)
+			}
+		} else if key, value, ok := strings.Cut(strings.TrimSpace(line), ": "); ok {
+			b.fields[key] = value
+		}
+	}
+	return blocks, nil
+}
+
+// listAudioDevices returns the sources (without sink monitors), sinks and
+// applications playing audio.
+func listAudioDevices() ([]audioDevice, error) {
+	if TestPattern {
+		return nil, fmt.Errorf("no audio server in test pattern mode")
+	}
+	var devices []audioDevice
+	sources, err := pactlList("sources", "Source")
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sources {
+		if s.fields["Monitor of Sink"] != "n/a" && s.fields["Monitor of Sink"] != "" {
+			continue
+		}
+		devices = append(devices, audioDevice{Name: s.fields["Name"], Description: s.fields["Description"], Kind: "source"})
+	}
+	sinks, err := pactlList("sinks", "Sink")
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sinks {
+		if s.fields["Name"] == audioAppSink {
+			continue
+		}
+		devices = append(devices, audioDevice{Name: s.fields["Name"], Description: s.fields["Description"], Kind: "sink"})
+	}
+	inputs, err := pactlList("sink-inputs", "Sink Input")
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, in := range inputs {
+		app := in.props["application.name"]
+		if app == "" || seen[app] {
+			continue
+		}
+		seen[app] = true
+		devices = append(devices, audioDevice{Name: audioAppPrefix + app, Description: app, Kind: "app"})
+	}
+	return devices, nil
+}
+
+func audioDevicesMessage() map[string]interface{} {
+	devices, err := listAudioDevices()
+	ffmpegMutex.Lock()
+	selected := AudioDevice
+	ffmpegMutex.Unlock()
+	msg := map[string]interface{}{
+		"type":     "audio_devices",
+		"devices":  devices,
+		"selected": selected,
+	}
+	if err != nil {
+		msg["error"] = err.Error()
+	}
+	return msg
+}
+
+// audioSourceName returns the PulseAudio source ffmpeg captures for device.
+func audioSourceName(device string) string {
+	if device == "" {
+		return "default"
+	}
+	if strings.HasPrefix(device, audioAppPrefix) {
+		return audioAppSink + ".monitor"
+	}
+	if sinks, err := pactlList("sinks", "Sink"); err == nil {
+		for _, s := range sinks {
+			if s.fields["Name"] == device {
+				return device + ".monitor"
+			}
+		}
+	}
+	return device
+}
+
+func SetAudioDevice(device string) {
+	ffmpegMutex.Lock()
+	prev := AudioDevice
+	AudioDevice = device
+	ffmpegMutex.Unlock()
+	if device == prev {
+		return
+	}
+
+	// Route before ffmpeg restarts, so it finds the application's sink.
+	if app, ok := strings.CutPrefix(device, audioAppPrefix); ok {
+		routeAudioApp(app)
+	} else if strings.HasPrefix(prev, audioAppPrefix) {
+		routeAudioApp("")
+	}
+	ffmpegMutex.Lock()
+	restartAudioLocked(fmt.Sprintf("Audio device changed to %q", device))
+	ffmpegMutex.Unlock()
+}
+
+// routeAudioApp moves the streams of app to audioAppSink, creating it if
+// needed, and any other streams on it back to the default sink. app ""
+// empties the sink.
+func routeAudioApp(app string) {
+	if TestPattern {
+		return
+	}
+	sinks, err := pactlList("sinks", "Sink")
+	if err != nil {
+		log.Printf("Audio routing: %v", err)
+		return
+	}
+	appSink := ""
+	for _, s := range sinks {
+		if s.fields["Name"] == audioAppSink {
+			appSink = s.index
+		}
+	}
+	if appSink == "" && app != "" {
+		out, err := pactlOutput("load-module", "module-null-sink", "sink_name="+audioAppSink,
+			"sink_properties=device.description=llrdc-application")
+		if err != nil {
+			log.Printf("Audio routing: failed to create the application sink: %v", err)
+			return
+		}
+		log.Printf("Audio routing: created sink %s (module %s)", audioAppSink, strings.TrimSpace(out))
+	}
+
+	inputs, err := pactlList("sink-inputs", "Sink Input")
+	if err != nil {
+		log.Printf("Audio routing: %v", err)
+		return
+	}
+	for _, in := range inputs {
+		onAppSink := appSink != "" && in.fields["Sink"] == appSink
+		target := ""
+		switch {
+		case app != "" && in.props["application.name"] == app && !onAppSink:
+			target = audioAppSink
+		case onAppSink && in.props["application.name"] != app:
+			target = "@DEFAULT_SINK@"
+		}
+		if target == "" {
+			continue
+		}
+		if _, err := pactlOutput("move-sink-input", in.index, target); err != nil {
+			log.Printf("Audio routing: failed to move %q to %s: %v", in.props["application.name"], target, err)
+		}
+	}
+}
+
+// startAudioRouter keeps an application's new streams on its sink while
+// it is the audio device.
+func startAudioRouter(ctx context.Context) {
+	goWorker(func() {
+		for sleepCtx(ctx, audioRouteInterval) {
+			ffmpegMutex.Lock()
+			device := AudioDevice
+			enabled := EnableAudio
+			ffmpegMutex.Unlock()
+			if app, ok := strings.CutPrefix(device, audioAppPrefix); ok && enabled {
+				routeAudioApp(app)
+			}
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package llrdc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Encoder auto-tuning. With CpuEffort -1 and CpuThreads 0 (the defaults)
+// the software encoder settings suit the host instead of being fixed: the
+// first start picks the thread count and VP8 cpu-used from the cores this
+// process may use (its CPU affinity and cgroup quota) minus the current
+// load. While VP8 runs, the tuner estimates the encode time per frame,
+// ffmpeg's CPU time per frame spread over its threads, and raises cpu-used
+// a step (less effort per frame) when it comes within autoTuneBusy of the
+// frame interval. After autoTuneRecover with ample headroom it steps back
+// towards the initial pick, but for autoTuneForget not to a setting that
+// was just too slow. Each step restarts ffmpeg. The settings in use
+// and the reason for them are reported in the stats message.
+
+const (
+	autoTuneInterval = 5 * time.Second
+	// autoTuneBusy and autoTuneIdle are shares of the frame interval.
+	autoTuneBusy    = 0.8
+	autoTuneIdle    = 0.4
+	autoTuneRecover = time.Minute
+	autoTuneForget  = 10 * time.Minute
+	// autoTuneMinFrames is how many frames a sample needs; a static
+	// screen says nothing about the encoder's speed.
+	autoTuneMinFrames  = 10
+	autoTuneMaxThreads = 8
+	maxCpuEffort       = 8
+	// clockTicks is the unit of CPU times in /proc (USER_HZ), 100 on
+	// every common Linux architecture.
+	clockTicks = 100
+)
+
+// encodedFrames counts the frames ffmpeg has produced, for the tuner.
+var encodedFrames atomic.Int64
+
+var tuning struct {
+	sync.Mutex
+	picked bool
+	// cores and load are what the initial pick saw.
+	cores, load float64
+	// baseEffort is the initial pick, effort the current one.
+	baseEffort, effort, threads int
+	// slowEffort was too slow until slowUntil.
+	slowEffort int
+	slowUntil  time.Time
+	// frameTime is the latest encode time estimate per frame.
+	frameTime time.Duration
+	reason    string
+}
+
+// availableCPUs returns how many CPUs this process may use: the CPUs of
+// its affinity mask, or fewer under a cgroup CPU quota.
+func availableCPUs() float64 {
+	cpus := float64(runtime.NumCPU())
+	if data, err := os.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		// cgroup v2: "<quota> <period>" or "max <period>".
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 == nil && err2 == nil && period > 0 {
+				cpus = math.Min(cpus, quota/period)
+			}
+		}
+	} else if data, err := os.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us"); err == nil {
+		quota, err1 := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		period, err2 := readFloatFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+		if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+			cpus = math.Min(cpus, quota/period)
+		}
+	}
+	return math.Max(cpus, 1)
+}
+
+func readFloatFile(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+}
+
+// systemLoad returns the one-minute load average, or 0 if unknown.
+func systemLoad() float64 {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	load, _ := strconv.ParseFloat(fields[0], 64)
+	return load
+}
+
+// pickEncoderTuning chooses the initial settings from the spare cores,
+// keeping one for capture, the X server and this process. The caller must
+// hold tuning.
+func pickEncoderTuning() {
+	tuning.picked = true
+	tuning.cores = availableCPUs()
+	tuning.load = systemLoad()
+	spare := math.Max(tuning.cores-tuning.load, 1)
+
+	tuning.threads = min(max(int(math.Round(spare))-1, 1), autoTuneMaxThreads)
+	switch {
+	case spare >= 6:
+		tuning.baseEffort = 6
+	case spare >= 3:
+		tuning.baseEffort = 7
+	default:
+		tuning.baseEffort = maxCpuEffort
+	}
+	tuning.effort = tuning.baseEffort
+	tuning.reason = fmt.Sprintf("%.1f of %.1f cores spare", spare, tuning.cores)
+	log.Printf("Encoder auto-tune: %s, cpu-used %d, %d threads", tuning.reason, tuning.effort, tuning.threads)
+}
+
+// resolveEncoderSettings resolves the requested cpu-used and thread count,
+// replacing -1 and 0 with the tuned values.
+func resolveEncoderSettings(effort, threads int) (int, int) {
+	if effort >= 0 && threads > 0 {
+		return effort, threads
+	}
+	tuning.Lock()
+	defer tuning.Unlock()
+	if !tuning.picked {
+		pickEncoderTuning()
+	}
+	if effort < 0 {
+		effort = tuning.effort
+	}
+	if threads <= 0 {
+		threads = tuning.threads
+	}
+	return effort, threads
+}
+
+// encoderTuningStats reports the encoder settings in use for the stats
+// message.
+func encoderTuningStats() map[string]interface{} {
+	ffmpegMutex.Lock()
+	effort, threads := targetCpuEffort, targetCpuThreads
+	ffmpegMutex.Unlock()
+	autoEffort, autoThreads := effort < 0, threads <= 0
+	effort, threads = resolveEncoderSettings(effort, threads)
+
+	tuning.Lock()
+	defer tuning.Unlock()
+	stats := map[string]interface{}{
+		"effort":      effort,
+		"threads":     threads,
+		"autoEffort":  autoEffort,
+		"autoThreads": autoThreads,
+		"cores":       tuning.cores,
+	}
+	if autoEffort || autoThreads {
+		stats["reason"] = tuning.reason
+	}
+	if tuning.frameTime > 0 {
+		stats["frameTimeMs"] = float64(tuning.frameTime.Microseconds()) / 1000
+	}
+	return stats
+}
+
+// processCPUTime returns the user and system time pid has used.
+func processCPUTime(pid int) (time.Duration, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces; the fields after it don't.
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	// utime and stime are fields 14 and 15, counting from the pid.
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	utime, err1 := strconv.ParseInt(fields[11], 10, 64)
+	stime, err2 := strconv.ParseInt(fields[12], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return time.Duration(utime+stime) * time.Second / clockTicks, nil
+}
+
+// startEncoderTuner adjusts the VP8 cpu-used while it is auto-tuned.
+func startEncoderTuner(ctx context.Context) {
+	goWorker(func() {
+		var (
+			lastPID    int
+			lastCPU    time.Duration
+			lastFrames int64
+			busy       int
+			idleSince  time.Time
+		)
+		for sleepCtx(ctx, autoTuneInterval) {
+			ffmpegMutex.Lock()
+			auto := targetCpuEffort < 0 && VideoCodec == "vp8"
+			requestedThreads := targetCpuThreads
+			fps := effectiveFPS(FPS)
+			pid := 0
+			if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+				pid = ffmpegCmd.Process.Pid
+			}
+			ffmpegMutex.Unlock()
+
+			cpu, err := processCPUTime(pid)
+			frames := encodedFrames.Load()
+			if !auto || pid == 0 || err != nil || pid != lastPID {
+				// Start over with each ffmpeg process.
+				lastPID, lastCPU, lastFrames = pid, cpu, frames
+				busy, idleSince = 0, time.Time{}
+				continue
+			}
+			dCPU, dFrames := cpu-lastCPU, frames-lastFrames
+			lastCPU, lastFrames = cpu, frames
+			if dFrames < autoTuneMinFrames || fps <= 0 {
+				busy, idleSince = 0, time.Time{}
+				continue
+			}
+
+			_, threads := resolveEncoderSettings(-1, requestedThreads)
+			tuning.Lock()
+			parallel := max(min(float64(threads), tuning.cores), 1)
+			frameTime := time.Duration(float64(dCPU) / float64(dFrames) / parallel)
+			interval := time.Second / time.Duration(fps)
+			tuning.frameTime = frameTime
+			effort := tuning.effort
+			step := 0
+			switch {
+			case frameTime > time.Duration(autoTuneBusy*float64(interval)):
+				idleSince = time.Time{}
+				busy++
+				if busy >= 2 && effort < maxCpuEffort {
+					step = 1
+					tuning.slowEffort, tuning.slowUntil = effort, time.Now().Add(autoTuneForget)
+				}
+			case frameTime < time.Duration(autoTuneIdle*float64(interval)):
+				busy = 0
+				if idleSince.IsZero() {
+					idleSince = time.Now()
+				} else if time.Since(idleSince) >= autoTuneRecover && effort > tuning.baseEffort &&
+					(effort-1 != tuning.slowEffort || time.Now().After(tuning.slowUntil)) {
+					step = -1
+				}
+			default:
+				busy, idleSince = 0, time.Time{}
+			}
+			if step != 0 {
+				tuning.effort += step
+				tuning.reason = fmt.Sprintf("encode time %.1f ms per frame at %d fps", float64(frameTime.Microseconds())/1000, fps)
+				log.Printf("Encoder auto-tune: %s, cpu-used %d -> %d", tuning.reason, effort, tuning.effort)
+			}
+			tuning.Unlock()
+
+			if step != 0 {
+				busy, idleSince = 0, time.Time{}
+				ffmpegMutex.Lock()
+				if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+					ffmpegCmd.Process.Kill()
+				}
+				ffmpegMutex.Unlock()
+			}
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package llrdc
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+// Audio/video synchronization. Every RTP timestamp the server sends counts
+// from one media clock, the monotonic time since mediaEpoch, instead of
+// each track adding up the durations of its own samples. A video frame is
+// stamped with the time it left the encoder, so an encoder restart or
+// frames skipped on a static screen leave a gap rather than shifting the
+// video against the audio from then on. Audio is stamped by its sample
+// positions, as a decoder needs, anchored to the media clock at the first
+// page of each ffmpeg run. The sound card's clock runs a little fast or
+// slow against the system clock; once the samples are audioResyncThreshold
+// away from the media clock, the timeline skips ahead (a gap the receiver
+// conceals) or drops pages until it is back. Both tracks share the "pion"
+// stream in one BUNDLE, so the browser lines them up by the Sender
+// Reports, which map the shared clock to wall time.
+
+const (
+	rtpOutboundMTU = 1200
+	videoClockRate = 90000
+	audioClockRate = 48000
+	// audioResyncThreshold is how far audio may drift before the timeline
+	// is corrected, well inside what viewers notice as lip sync errors.
+	audioResyncThreshold = 40 * time.Millisecond
+	// audioDriftWindow is how long the timeline measures the lag of pages
+	// behind their sample positions; the lowest lag of a window is the
+	// drift, free of the pipe's bursts.
+	audioDriftWindow = 5 * time.Second
+)
+
+var (
+	mediaEpoch = time.Now()
+	// mediaTimestampBase is the random RTP timestamp of mediaEpoch.
+	mediaTimestampBase = rand.Uint32()
+)
+
+// mediaTimestamp returns the RTP timestamp of t on the media clock.
+func mediaTimestamp(t time.Time, clockRate float64) uint32 {
+	return mediaTimestampBase + uint32(uint64(max(t.Sub(mediaEpoch), 0).Seconds()*clockRate))
+}
+
+// payloaderFor returns the RTP payloader for a codec's MIME type.
+func payloaderFor(mimeType string) rtp.Payloader {
+	switch mimeType {
+	case webrtc.MimeTypeH264:
+		return &codecs.H264Payloader{}
+	case webrtc.MimeTypeH265:
+		return &codecs.H265Payloader{}
+	case webrtc.MimeTypeAV1:
+		return &codecs.AV1Payloader{}
+	case webrtc.MimeTypeOpus:
+		return &codecs.OpusPayloader{}
+	default:
+		return &codecs.VP8Payloader{EnablePictureID: true}
+	}
+}
+
+// syncedTrack is a track whose samples carry media clock timestamps.
+type syncedTrack struct {
+	*webrtc.TrackLocalStaticRTP
+	mu         sync.Mutex
+	packetizer rtp.Packetizer
+	clockRate  float64
+}
+
+func newSyncedTrack(capability webrtc.RTPCodecCapability, id, streamID string) (*syncedTrack, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(capability, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	clockRate := uint32(videoClockRate)
+	if capability.MimeType == webrtc.MimeTypeOpus {
+		clockRate = audioClockRate
+	}
+	// The track rewrites the payload type and SSRC for each binding.
+	packetizer := rtp.NewPacketizer(rtpOutboundMTU, 0, 0, payloaderFor(capability.MimeType), rtp.NewRandomSequencer(), clockRate)
+	return &syncedTrack{TrackLocalStaticRTP: track, packetizer: packetizer, clockRate: float64(clockRate)}, nil
+}
+
+// writeAt sends a sample with RTP timestamp ts.
+func (t *syncedTrack) writeAt(data []byte, ts uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var err error
+	for _, p := range t.packetizer.Packetize(data, 0) {
+		p.Timestamp = ts
+		if e := t.WriteRTP(p); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// writeFrame sends a sample taken at captured.
+func (t *syncedTrack) writeFrame(data []byte, captured time.Time) error {
+	return t.writeAt(data, mediaTimestamp(captured, t.clockRate))
+}
+
+// audioTimeline stamps the pages of one audio ffmpeg run.
+type audioTimeline struct {
+	anchored bool
+	// base is the RTP timestamp of sample g0, which arrived at t0 plus
+	// shift, the corrections so far.
+	base  uint32
+	g0    uint64
+	t0    time.Time
+	shift time.Duration
+	// baseline is the lowest lag of the first window, lowest that of the
+	// current one, which started at window.
+	baseline, lowest time.Duration
+	measured         bool
+	window           time.Time
+	// dropping is how much audio to drop to catch up with the clock.
+	dropping time.Duration
+}
+
+// stamp returns the RTP timestamp of a page holding the samples from start
+// to end, which arrived at arrived, or false if the page is dropped.
+func (a *audioTimeline) stamp(start, end uint64, arrived time.Time) (uint32, bool) {
+	duration := time.Duration(end-start) * time.Second / audioClockRate
+	if !a.anchored {
+		// Like a video frame, the first sample is stamped with the time
+		// it left the encoder, less the rest of its page.
+		a.anchored = true
+		a.g0, a.t0, a.window = start, arrived.Add(-duration), arrived
+		a.base = mediaTimestamp(a.t0, audioClockRate)
+		a.lowest = time.Duration(1<<63 - 1)
+	}
+	if a.dropping > 0 {
+		a.dropping -= duration
+		a.base -= uint32(end - start)
+		a.shift -= duration
+		return 0, false
+	}
+
+	// The lag of the page's last sample behind where the media clock
+	// puts it.
+	lag := arrived.Sub(a.t0.Add(a.shift + time.Duration(end-a.g0)*time.Second/audioClockRate))
+	a.lowest = min(a.lowest, lag)
+	if arrived.Sub(a.window) >= audioDriftWindow {
+		if !a.measured {
+			a.baseline, a.measured = a.lowest, true
+		} else if drift := a.lowest - a.baseline; drift > audioResyncThreshold {
+			// The samples run slow: skip ahead, the receiver conceals
+			// the gap.
+			log.Printf("Audio clock is %v slow, skipping ahead", drift)
+			a.base += uint32(drift.Seconds() * audioClockRate)
+			a.shift += drift
+		} else if drift < -audioResyncThreshold {
+			log.Printf("Audio clock is %v fast, dropping audio", -drift)
+			a.dropping = -drift
+		}
+		a.window, a.lowest = arrived, time.Duration(1<<63-1)
+	}
+	return a.base + uint32(start-a.g0), true
+}
@@ -0,0 +1,132 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Admin banners. The control API's Broadcast call puts a line of text such
+// as "maintenance in 5 minutes" in front of every viewer, as a
+// {"type":"banner","text":"...","expires":<unix ms, or 0>} message that
+// the viewer shows over the video; text "" removes it. Viewers that
+// connect while a banner is up get it too. With burn_in the encoder also
+// draws the banner into the video with ffmpeg's drawtext, for players that
+// only see the video (WHEP, HLS, the native viewer), at the cost of an
+// encoder restart as it comes and goes. drawtext reads the text from a
+// file, so it needs no escaping, and needs an ffmpeg built with
+// libfreetype and fontconfig.
+
+const bannerMaxLength = 500
+
+type bannerState struct {
+	text    string
+	expires time.Time
+	burnIn  bool
+}
+
+var (
+	bannerMutex sync.Mutex
+	banner      bannerState
+	bannerTimer *time.Timer
+	// bannerFile holds the text of a burnt-in banner for drawtext.
+	bannerFile string
+)
+
+// bannerMessage returns the banner message for viewers. The caller must
+// hold bannerMutex.
+func bannerMessage() map[string]interface{} {
+	msg := map[string]interface{}{"type": "banner", "text": banner.text, "expires": 0}
+	if !banner.expires.IsZero() {
+		msg["expires"] = banner.expires.UnixMilli()
+	}
+	return msg
+}
+
+// currentBannerMessage returns the banner message for a new viewer, or nil
+// if there is no banner.
+func currentBannerMessage() map[string]interface{} {
+	bannerMutex.Lock()
+	defer bannerMutex.Unlock()
+	if banner.text == "" {
+		return nil
+	}
+	return bannerMessage()
+}
+
+// setBanner shows text to all viewers for duration (0 for good), or
+// removes the banner if text is "".
+func setBanner(text string, duration time.Duration, burnIn bool) error {
+	next := bannerState{text: text, burnIn: burnIn && text != ""}
+	if text != "" && duration > 0 {
+		next.expires = time.Now().Add(duration)
+	}
+
+	bannerMutex.Lock()
+	if next.burnIn {
+		if err := writeBannerFile(text); err != nil {
+			bannerMutex.Unlock()
+			return err
+		}
+	}
+	restart := next.burnIn || banner.burnIn
+	banner = next
+	if bannerTimer != nil {
+		bannerTimer.Stop()
+		bannerTimer = nil
+	}
+	if !next.expires.IsZero() {
+		bannerTimer = time.AfterFunc(duration, func() { expireBanner(next) })
+	}
+	msg := bannerMessage()
+	bannerMutex.Unlock()
+
+	if text == "" {
+		log.Println("Banner removed")
+	} else {
+		log.Printf("Banner: %q (for %v, burn-in %v)", text, duration, burnIn)
+	}
+	broadcastJSON(msg)
+	if restart {
+		restartCapture()
+	}
+	return nil
+}
+
+// expireBanner removes st once its time is up, unless it was replaced.
+func expireBanner(st bannerState) {
+	bannerMutex.Lock()
+	current := banner == st
+	bannerMutex.Unlock()
+	if current {
+		_ = setBanner("", 0, false)
+	}
+}
+
+// writeBannerFile replaces the text drawtext reads. The caller must hold
+// bannerMutex.
+func writeBannerFile(text string) error {
+	if bannerFile == "" {
+		f, err := os.CreateTemp("", "llrdc-banner-*.txt")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		bannerFile = f.Name()
+	}
+	return os.WriteFile(bannerFile, []byte(text), 0600)
+}
+
+// bannerFilter returns the filter that draws a burnt-in banner across the
+// top of the video, or "".
+func bannerFilter() string {
+	bannerMutex.Lock()
+	defer bannerMutex.Unlock()
+	if !banner.burnIn {
+		return ""
+	}
+	return fmt.Sprintf("drawtext=textfile=%s:expansion=none:fontsize=h/30:fontcolor=white:"+
+		"box=1:boxcolor=black@0.7:boxborderw=12:x=(w-text_w)/2:y=h/20", bannerFile)
+}
@@ -0,0 +1,217 @@
+package llrdc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	benchmarkWidth  = 1920
+	benchmarkHeight = 1080
+	benchmarkFrames = 150
+	// benchmarkHeadroom is how far above the target framerate an encoder
+	// must run to be recommended, leaving CPU for capture and the session.
+	benchmarkHeadroom = 1.5
+)
+
+type benchmarkCase struct {
+	Codec     string
+	CpuEffort int
+	Threads   int
+}
+
+type benchmarkResult struct {
+	benchmarkCase
+	FPS        float64
+	FrameMs    float64
+	FirstFrame time.Duration
+	Err        error
+}
+
+// benchmarkCases lists the encoder settings to try. cpu-used and threads
+// only affect libvpx, so the other codecs are measured once each.
+func benchmarkCases() []benchmarkCase {
+	var cases []benchmarkCase
+	for _, effort := range []int{2, 4, 6, 8} {
+		for _, threads := range []int{1, 2, 4, 8, 16} {
+			if threads > runtime.NumCPU() {
+				continue
+			}
+			cases = append(cases, benchmarkCase{Codec: "vp8", CpuEffort: effort, Threads: threads})
+		}
+	}
+	for _, codec := range []string{"h264", "h265", "av1"} {
+		cases = append(cases, benchmarkCase{Codec: codec, CpuEffort: targetCpuEffort, Threads: targetCpuThreads})
+	}
+	if UseGPU {
+		cases = append(cases,
+			benchmarkCase{Codec: "h264_nvenc", CpuEffort: targetCpuEffort, Threads: targetCpuThreads},
+			benchmarkCase{Codec: "h265_nvenc", CpuEffort: targetCpuEffort, Threads: targetCpuThreads},
+		)
+		if AV1NVENCAvailable {
+			cases = append(cases, benchmarkCase{Codec: "av1_nvenc", CpuEffort: targetCpuEffort, Threads: targetCpuThreads})
+		}
+	}
+	return cases
+}
+
+// runBenchmark encodes the test pattern with every benchmark case, prints a
+// report and writes the recommended settings as an env file that can be
+// passed to docker --env-file or sourced before starting the server.
+func runBenchmark() error {
+	ffmpegPath := ffmpegBinary()
+	fmt.Printf("Benchmarking encoders at %dx%d, target %d fps (%d CPUs)...\n\n", benchmarkWidth, benchmarkHeight, FPS, runtime.NumCPU())
+	fmt.Printf("%-12s %6s %8s %10s %10s %12s\n", "CODEC", "EFFORT", "THREADS", "FPS", "MS/FRAME", "FIRST FRAME")
+
+	var results []benchmarkResult
+	for _, c := range benchmarkCases() {
+		r := runBenchmarkCase(ffmpegPath, c)
+		results = append(results, r)
+		if r.Err != nil {
+			fmt.Printf("%-12s %6d %8d %10s %10s %12s  (%v)\n", c.Codec, c.CpuEffort, c.Threads, "-", "-", "-", r.Err)
+			continue
+		}
+		fmt.Printf("%-12s %6d %8d %10.1f %10.1f %12s\n", c.Codec, c.CpuEffort, c.Threads, r.FPS, r.FrameMs, r.FirstFrame.Round(time.Millisecond))
+	}
+
+	best, ok := recommendBenchmark(results)
+	if !ok {
+		return fmt.Errorf("no encoder reached %.0f fps; lower --fps or use a smaller screen", float64(FPS)*benchmarkHeadroom)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by llrdc --benchmark on %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "# %d CPUs, %dx%d test pattern: %.1f fps, %.1f ms/frame\n", runtime.NumCPU(), benchmarkWidth, benchmarkHeight, best.FPS, best.FrameMs)
+	fmt.Fprintf(&b, "FPS=%d\n", FPS)
+	fmt.Fprintf(&b, "VIDEO_CODEC=%s\n", best.Codec)
+	if best.Codec == "vp8" {
+		fmt.Fprintf(&b, "CPU_EFFORT=%d\n", best.CpuEffort)
+		fmt.Fprintf(&b, "CPU_THREADS=%d\n", best.Threads)
+	}
+	if isNVENCCodec(best.Codec) {
+		b.WriteString("USE_GPU=true\n")
+	}
+
+	fmt.Printf("\nRecommended configuration:\n%s", b.String())
+	if err := os.WriteFile(BenchmarkOutput, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", BenchmarkOutput, err)
+	}
+	fmt.Printf("\nWritten to %s\n", BenchmarkOutput)
+	return nil
+}
+
+func runBenchmarkCase(ffmpegPath string, c benchmarkCase) benchmarkResult {
+	result := benchmarkResult{benchmarkCase: c}
+
+	// The arg builders read the global codec settings.
+	prevCodec := VideoCodec
+	VideoCodec = c.Codec
+	outputArgs := buildOutputArgs("", "bandwidth", targetBandwidthMbps, targetQuality, FPS, false, false, c.CpuEffort, c.Threads, targetKeyframeInterval, "video")
+	VideoCodec = prevCodec
+
+	args := []string{"-hide_banner", "-nostats", "-loglevel", "error"}
+	args = append(args, hwDeviceArgs(c.Codec)...)
+	args = append(args, "-f", "lavfi", "-i", fmt.Sprintf("testsrc=size=%dx%d:rate=%d", benchmarkWidth, benchmarkHeight, FPS),
+		"-frames:v", fmt.Sprint(benchmarkFrames))
+	args = append(args, outputArgs...)
+
+	cmd := exec.Command(ffmpegPath, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	frames := 0
+	var first, last time.Time
+	onFrame := func([]byte) {
+		last = time.Now()
+		if frames == 0 {
+			first = last
+		}
+		frames++
+	}
+	switch c.Codec {
+	case "h264", "h264_nvenc":
+		splitH264AnnexB(stdout, onFrame)
+	case "h265", "h265_nvenc":
+		splitH265AnnexB(stdout, onFrame)
+	default:
+		splitIVF(stdout, onFrame)
+	}
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.IndexByte(msg, '\n'); i >= 0 {
+			msg = msg[:i]
+		}
+		result.Err = fmt.Errorf("%v: %s", err, msg)
+		return result
+	}
+	if frames < 2 {
+		result.Err = fmt.Errorf("encoded only %d frames", frames)
+		return result
+	}
+
+	result.FirstFrame = first.Sub(start)
+	elapsed := last.Sub(first)
+	result.FPS = float64(frames-1) / elapsed.Seconds()
+	result.FrameMs = float64(elapsed.Milliseconds()) / float64(frames-1)
+	return result
+}
+
+// recommendBenchmark picks the configured codec if it keeps up, otherwise
+// the fastest codec that does. For libvpx it prefers the lowest cpu-used
+// (best quality) and then the fewest threads that reach the target.
+func recommendBenchmark(results []benchmarkResult) (benchmarkResult, bool) {
+	target := float64(FPS) * benchmarkHeadroom
+	var passing []benchmarkResult
+	for _, r := range results {
+		if r.Err == nil && r.FPS >= target {
+			passing = append(passing, r)
+		}
+	}
+	if len(passing) == 0 {
+		return benchmarkResult{}, false
+	}
+
+	codec := ""
+	fastest := 0.0
+	for _, r := range passing {
+		if r.Codec == VideoCodec {
+			codec = VideoCodec
+			break
+		}
+		if r.FPS > fastest {
+			fastest = r.FPS
+			codec = r.Codec
+		}
+	}
+
+	var best benchmarkResult
+	found := false
+	for _, r := range passing {
+		if r.Codec != codec {
+			continue
+		}
+		if !found || r.CpuEffort < best.CpuEffort || (r.CpuEffort == best.CpuEffort && r.Threads < best.Threads) {
+			best = r
+			found = true
+		}
+	}
+	return best, true
+}
@@ -0,0 +1,379 @@
+package llrdc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Broker mode turns llrdc into a small VDI gateway. The broker runs no
+// desktop of its own: it identifies each user by IdentityHeader, starts a
+// backend llrdc for them on first visit (a child process with its own X
+// display, a Docker or Podman container, see broker_container.go, or a
+// Kubernetes pod, see broker_k8s.go), and proxies their HTTP and WebSocket
+// traffic, including WebRTC signaling, to it. Media flows directly between
+// the browser and the backend's UDP port. Backends are numbered slots:
+// slot i listens on BrokerPortBase+i and uses display DisplayNum+1+i. Each
+// backend gets SESSION_ID=<user>, so with DataRoot users keep their home
+// directory across sessions. Local backends inherit the broker's flags and
+// environment; containers and pods get only the environment variables
+// listed in backendEnv and otherwise use the image's defaults.
+
+const (
+	brokerStartTimeout = 60 * time.Second
+	brokerStopTimeout  = 15 * time.Second
+	brokerReapInterval = time.Minute
+)
+
+type brokerSession struct {
+	user string
+	slot int
+	// host and port are where the backend listens; startTimeout is how
+	// long it may take to get there.
+	host         string
+	port         int
+	startTimeout time.Duration
+	proxy        *httputil.ReverseProxy
+	// ready is closed once the backend accepts connections or has failed
+	// to, in which case err is set. done is closed when it has exited.
+	ready chan struct{}
+	err   error
+	done  chan struct{}
+	stop  func()
+
+	active   atomic.Int32 // proxied requests in flight, WebSockets included
+	lastUsed atomic.Int64 // UnixNano
+}
+
+type broker struct {
+	ctx      context.Context
+	mu       sync.Mutex
+	sessions map[string]*brokerSession
+	slots    []bool
+	portBase int
+}
+
+// newBrokerServer returns the HTTP server of the broker and starts reaping
+// idle backends.
+func newBrokerServer(ctx context.Context) (*http.Server, error) {
+	if IdentityHeader == "" {
+		return nil, errors.New("broker mode needs --identity-header to tell users apart")
+	}
+	switch BrokerBackend {
+	case "local":
+	case "docker", "podman":
+		if err := connectContainerEngine(ctx); err != nil {
+			return nil, err
+		}
+	case "kubernetes":
+		if err := connectKubernetes(ctx); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown broker backend %q (want local, docker, podman or kubernetes)", BrokerBackend)
+	}
+	b := &broker{
+		ctx:      ctx,
+		sessions: make(map[string]*brokerSession),
+		slots:    make([]bool, max(BrokerMaxSessions, 1)),
+		portBase: BrokerPortBase,
+	}
+	if b.portBase <= 0 {
+		b.portBase = Port + 1
+	}
+	log.Printf("Broker: %s backends on ports %d-%d", BrokerBackend, b.portBase, b.portBase+len(b.slots)-1)
+
+	goWorker(func() {
+		for sleepCtx(ctx, brokerReapInterval) {
+			b.reapIdle()
+		}
+		b.stopAll()
+	})
+	return &http.Server{Addr: httpListenAddr(), Handler: b}, nil
+}
+
+func (b *broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := requestIdentity(r)
+	if user == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s, err := b.session(user)
+	if err != nil {
+		log.Printf("Broker: no session for %q: %v", user, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case <-s.ready:
+	case <-r.Context().Done():
+		return
+	}
+	if s.err != nil {
+		http.Error(w, "Session failed to start", http.StatusBadGateway)
+		return
+	}
+	s.active.Add(1)
+	defer func() {
+		s.lastUsed.Store(time.Now().UnixNano())
+		s.active.Add(-1)
+	}()
+	s.lastUsed.Store(time.Now().UnixNano())
+	s.proxy.ServeHTTP(w, r)
+}
+
+// session returns the user's backend, starting one if needed.
+func (b *broker) session(user string) (*brokerSession, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := b.sessions[user]; s != nil {
+		return s, nil
+	}
+	if b.ctx.Err() != nil {
+		return nil, errors.New("server shutting down")
+	}
+	slot := -1
+	for i, used := range b.slots {
+		if !used {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		return nil, errors.New("all desktop sessions are in use")
+	}
+
+	s := &brokerSession{
+		user:         user,
+		slot:         slot,
+		host:         "127.0.0.1",
+		port:         b.portBase + slot,
+		startTimeout: brokerStartTimeout,
+		ready:        make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	s.lastUsed.Store(time.Now().UnixNano())
+	b.slots[slot] = true
+	b.sessions[user] = s
+
+	var err error
+	switch BrokerBackend {
+	case "kubernetes":
+		err = startKubernetesBackend(s)
+	case "docker", "podman":
+		err = startContainerBackend(s)
+	default:
+		err = startLocalBackend(s)
+	}
+	if err != nil {
+		b.slots[slot] = false
+		delete(b.sessions, user)
+		return nil, err
+	}
+	target := &url.URL{Scheme: "http", Host: s.addr()}
+	s.proxy = httputil.NewSingleHostReverseProxy(target)
+	s.proxy.FlushInterval = -1 // MJPEG, HLS and the WebSocket stream live
+	log.Printf("Broker: started session for %q on port %d", user, s.port)
+
+	go b.waitReady(s)
+	go func() {
+		<-s.done
+		b.mu.Lock()
+		if b.sessions[user] == s {
+			delete(b.sessions, user)
+		}
+		b.slots[slot] = false
+		b.mu.Unlock()
+		log.Printf("Broker: session for %q ended", user)
+	}()
+	return s, nil
+}
+
+func (s *brokerSession) addr() string {
+	return net.JoinHostPort(s.host, strconv.Itoa(s.port))
+}
+
+// waitReady closes s.ready once the backend accepts connections.
+func (b *broker) waitReady(s *brokerSession) {
+	defer close(s.ready)
+	deadline := time.Now().Add(s.startTimeout)
+	addr := s.addr()
+	for time.Now().Before(deadline) {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			return
+		}
+		select {
+		case <-s.done:
+			s.err = errors.New("backend exited during startup")
+			return
+		case <-b.ctx.Done():
+			s.err = b.ctx.Err()
+			return
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	s.err = errors.New("backend did not start in time")
+	log.Printf("Broker: session for %q did not start in time", s.user)
+	s.stop()
+}
+
+// backendOverrides is the configuration in which a backend differs from
+// the broker, as name=value pairs of flags and environment variables.
+func backendOverrides(s *brokerSession) [][2]string {
+	return [][2]string{
+		{"broker", "false"},
+		{"port", strconv.Itoa(s.port)},
+		// Desktops on the broker's host take connections only from it,
+		// so nobody can go around it and send IDENTITY_HEADER
+		// themselves. Pods are reached through their Service instead,
+		// see buildPod.
+		{"http-addr", "127.0.0.1"},
+		{"session-id", brokerSessionID(s.user)},
+		{"grpc-addr", ""},
+		{"vnc-addr", ""},
+		{"reverse-ssh", ""},
+		{"mdns", "false"},
+		{"port-mapping", "false"},
+		// The broker has filtered the connections it proxies already.
+		{"allow-ips", ""},
+		{"deny-ips", ""},
+		{"allow-countries", ""},
+		{"deny-countries", ""},
+		// The broker terminates TLS and talks plain HTTP to its desktops.
+		{"tls-cert", ""},
+		{"tls-key", ""},
+		{"tls-client-ca", ""},
+		{"http3-port", "0"},
+		{"webtransport", "false"},
+		// Only the broker writes and rotates the log file.
+		{"log-file", ""},
+	}
+}
+
+// brokerSessionID returns the session ID of user's desktops, which names
+// their home directory. Identities may hold any character, and the storage
+// name keeps only a few (see storageDirName), so the ID is the readable
+// part of the identity followed by a hash of all of it, which tells apart
+// "alice@corp" and "alice_corp". It starts with a letter or digit, as
+// volume names must.
+func brokerSessionID(user string) string {
+	name, _ := storageDirName(user)
+	name = strings.TrimLeft(name, "._-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	sum := sha256.Sum256([]byte(user))
+	if name == "" {
+		return hex.EncodeToString(sum[:8])
+	}
+	return name + "-" + hex.EncodeToString(sum[:8])
+}
+
+// backendDisplayNum returns the X display of the backend in s's slot.
+// Containers on the host network need their own too, as X listens on an
+// abstract socket that the network namespace scopes.
+func backendDisplayNum(s *brokerSession) int {
+	displayNum, err := strconv.Atoi(DisplayNum)
+	if err != nil {
+		displayNum = 99
+	}
+	return displayNum + 1 + s.slot
+}
+
+// startLocalBackend runs the backend as a child process of the broker.
+func startLocalBackend(s *brokerSession) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// The backend gets the broker's flags and environment; the overrides
+	// come last, so they win.
+	args := append([]string{}, os.Args[1:]...)
+	for _, o := range backendOverrides(s) {
+		args = append(args, "--"+o[0]+"="+o[1])
+	}
+	args = append(args, "--display-num="+strconv.Itoa(backendDisplayNum(s)))
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if LogFile != "" {
+		cmd.Stdout, cmd.Stderr = log.Writer(), log.Writer()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		_ = cmd.Wait()
+		close(s.done)
+	}()
+	s.stop = func() {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-s.done:
+		case <-time.After(brokerStopTimeout):
+			_ = cmd.Process.Kill()
+		}
+	}
+	return nil
+}
+
+// reapIdle stops backends nobody has used for BrokerIdleMinutes.
+func (b *broker) reapIdle() {
+	if BrokerIdleMinutes <= 0 {
+		return
+	}
+	idle := time.Duration(BrokerIdleMinutes) * time.Minute
+	b.mu.Lock()
+	var stale []*brokerSession
+	for _, s := range b.sessions {
+		if s.active.Load() == 0 && time.Since(time.Unix(0, s.lastUsed.Load())) > idle {
+			stale = append(stale, s)
+		}
+	}
+	b.mu.Unlock()
+	for _, s := range stale {
+		log.Printf("Broker: stopping idle session for %q", s.user)
+		go s.stop()
+	}
+}
+
+// stopAll stops every backend and waits for them to exit.
+func (b *broker) stopAll() {
+	b.mu.Lock()
+	sessions := make([]*brokerSession, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.stop()
+			select {
+			case <-s.done:
+			case <-time.After(brokerStopTimeout):
+				log.Printf("Broker: session for %q did not stop", s.user)
+			}
+		}()
+	}
+	wg.Wait()
+}
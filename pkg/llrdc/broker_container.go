@@ -0,0 +1,235 @@
+package llrdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Container backends for the broker. Docker and Podman serve the same
+// Engine API on a Unix socket, so both run each desktop as a container of
+// BrokerImage on the host network, with the slot's port and X display
+// passed in its environment. A user's home lives either in a named volume
+// of their own (BrokerVolumePrefix) or in their directory under DataRoot,
+// which is the only part of DataRoot the container sees. Containers are
+// labelled with the user and the broker's port; a broker that starts up
+// stops the containers its predecessor on that port left behind, as they
+// would hold the backend ports.
+
+const (
+	brokerVolumeMount = "/data"
+	brokerPortLabel   = "llrdc.broker.port"
+	brokerUserLabel   = "llrdc.broker.user"
+)
+
+// engineClient talks to the container engine, see connectContainerEngine.
+var engineClient *http.Client
+
+// engineSocketPath returns the socket of the container engine API.
+func engineSocketPath() string {
+	if BrokerEngineSocket != "" {
+		return strings.TrimPrefix(BrokerEngineSocket, "unix://")
+	}
+	hostVar := "DOCKER_HOST"
+	if BrokerBackend == "podman" {
+		hostVar = "CONTAINER_HOST"
+	}
+	if host := os.Getenv(hostVar); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	if BrokerBackend == "podman" {
+		// Rootless Podman serves the API from the user's runtime directory.
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Geteuid() != 0 {
+			return filepath.Join(dir, "podman", "podman.sock")
+		}
+		return "/run/podman/podman.sock"
+	}
+	return "/var/run/docker.sock"
+}
+
+// connectContainerEngine checks that the engine answers and stops the
+// containers a previous broker left behind.
+func connectContainerEngine(ctx context.Context) error {
+	socket := engineSocketPath()
+	engineClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := engineRequest(pingCtx, http.MethodGet, "/_ping", nil, nil); err != nil {
+		return fmt.Errorf("cannot reach the %s engine at %s: %v", BrokerBackend, socket, err)
+	}
+	log.Printf("Broker: using the %s engine at %s", BrokerBackend, socket)
+	stopOrphanContainers(ctx)
+	return nil
+}
+
+func engineRequest(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://engine"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := engineClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s %s: %s: %s", BrokerBackend, method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func stopOrphanContainers(ctx context.Context) {
+	filters, _ := json.Marshal(map[string][]string{"label": {brokerPortLabel + "=" + strconv.Itoa(Port)}})
+	var containers []struct{ Id string }
+	if err := engineRequest(ctx, http.MethodGet, "/containers/json?filters="+url.QueryEscape(string(filters)), nil, &containers); err != nil {
+		log.Printf("Broker: listing old containers: %v", err)
+		return
+	}
+	for _, c := range containers {
+		log.Printf("Broker: stopping container %.12s left by a previous broker", c.Id)
+		stopCtx, cancel := context.WithTimeout(ctx, brokerStopTimeout+5*time.Second)
+		path := fmt.Sprintf("/containers/%s/stop?t=%d", c.Id, int(brokerStopTimeout.Seconds()))
+		if err := engineRequest(stopCtx, http.MethodPost, path, nil, nil); err != nil {
+			log.Printf("Broker: stopping container %.12s: %v", c.Id, err)
+		}
+		cancel()
+	}
+}
+
+// userVolume creates, or finds, the named volume holding user's home.
+func userVolume(ctx context.Context, user string) (string, error) {
+	name := BrokerVolumePrefix + brokerSessionID(user)
+	err := engineRequest(ctx, http.MethodPost, "/volumes/create", map[string]interface{}{
+		"Name":   name,
+		"Labels": map[string]string{brokerUserLabel: user},
+	}, nil)
+	if err != nil {
+		// Older engines refuse to create a volume that exists.
+		if engineRequest(ctx, http.MethodGet, "/volumes/"+url.PathEscape(name), nil, nil) == nil {
+			return name, nil
+		}
+		return "", err
+	}
+	return name, nil
+}
+
+// backendEnvNames are the environment variables of the overrides whose
+// names do not follow from their flags.
+var backendEnvNames = map[string]string{
+	"webtransport": "ENABLE_WEBTRANSPORT",
+}
+
+// backendEnv returns the environment of a container or pod backend: the
+// overrides, its display and the few settings it shares with the broker.
+func backendEnv(s *brokerSession, withDataRoot bool) []string {
+	var env []string
+	for _, o := range backendOverrides(s) {
+		name, ok := backendEnvNames[o[0]]
+		if !ok {
+			name = strings.ToUpper(strings.ReplaceAll(o[0], "-", "_"))
+		}
+		env = append(env, name+"="+o[1])
+	}
+	env = append(env, "DISPLAY_NUM="+strconv.Itoa(backendDisplayNum(s)))
+	if withDataRoot && DataRoot != "" {
+		env = append(env, "DATA_ROOT="+DataRoot)
+	}
+	for _, name := range []string{"IDENTITY_HEADER", "WEBRTC_PUBLIC_IP", "VIDEO_CODEC", "FPS"} {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+// startContainerBackend runs the backend as a container of BrokerImage on
+// the host network, so its WebRTC port is reachable like a local backend's.
+func startContainerBackend(s *brokerSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerStartTimeout)
+	defer cancel()
+
+	env := backendEnv(s, BrokerVolumePrefix == "")
+	hostConfig := map[string]interface{}{"NetworkMode": "host", "AutoRemove": true}
+	switch {
+	case BrokerVolumePrefix != "":
+		volume, err := userVolume(ctx, s.user)
+		if err != nil {
+			return err
+		}
+		hostConfig["Binds"] = []string{volume + ":" + brokerVolumeMount}
+		env = append(env, "DATA_ROOT="+brokerVolumeMount)
+	case DataRoot != "":
+		// Mount only the user's own directory, where the backend will look
+		// for it.
+		dir := filepath.Join(DataRoot, brokerSessionID(s.user))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create session storage %s: %v", dir, err)
+		}
+		hostConfig["Binds"] = []string{dir + ":" + dir}
+	}
+
+	var created struct{ Id string }
+	err := engineRequest(ctx, http.MethodPost, "/containers/create", map[string]interface{}{
+		"Image":      BrokerImage,
+		"Env":        env,
+		"Labels":     map[string]string{brokerUserLabel: s.user, brokerPortLabel: strconv.Itoa(Port)},
+		"HostConfig": hostConfig,
+	}, &created)
+	if err != nil {
+		return err
+	}
+	if err := engineRequest(ctx, http.MethodPost, "/containers/"+created.Id+"/start", nil, nil); err != nil {
+		_ = engineRequest(ctx, http.MethodDelete, "/containers/"+created.Id+"?force=true", nil, nil)
+		return err
+	}
+	// Docker can wait for the auto-removal, which also avoids a race with
+	// it; Podman's compatible API only reliably knows not-running.
+	condition := "removed"
+	if BrokerBackend == "podman" {
+		condition = "not-running"
+	}
+	go func() {
+		defer close(s.done)
+		err := engineRequest(context.Background(), http.MethodPost, "/containers/"+created.Id+"/wait?condition="+condition, nil, nil)
+		if err != nil {
+			log.Printf("Broker: waiting for container %.12s: %v", created.Id, err)
+		}
+	}()
+	s.stop = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), brokerStopTimeout+5*time.Second)
+		defer cancel()
+		path := fmt.Sprintf("/containers/%s/stop?t=%d", created.Id, int(brokerStopTimeout.Seconds()))
+		if err := engineRequest(ctx, http.MethodPost, path, nil, nil); err != nil {
+			log.Printf("Broker: stopping container %.12s: %v", created.Id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package llrdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kubernetes backends for the broker. Each desktop is a Pod made from
+// BrokerK8sTemplate, a JSON Pod manifest in which "{{user}}" and
+// "{{name}}" are replaced by the user and the pod's name (for a
+// persistentVolumeClaim per user, say), plus a ClusterIP Service in front
+// of it that the broker proxies to. The broker adds the backend's
+// environment and port to the first container, labels both objects with
+// the session and its own port, and sets restartPolicy Never so a desktop
+// that exits ends the session like any other backend. Scheduling, resource
+// requests and quotas are left to the template and the namespace. The
+// broker talks to the API with its service account, or without
+// authentication to BrokerK8sAPI (kubectl proxy). Its account needs to
+// create, get, list and delete pods and services in BrokerK8sNamespace.
+//
+// WebRTC media goes straight to the pod, so browsers have to reach it on
+// its UDP port, e.g. with hostNetwork: true and WEBRTC_PUBLIC_IP in the
+// template; otherwise viewers fall back to video over the proxied
+// WebSocket.
+
+const (
+	k8sStartTimeout   = 5 * time.Minute
+	k8sPollInterval   = 5 * time.Second
+	k8sServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+	k8sPortLabel      = "llrdc.broker/port"
+	k8sSessionLabel   = "llrdc.broker/session"
+	k8sUserAnnotation = "llrdc.broker/user"
+)
+
+type k8sClient struct {
+	base      string
+	namespace string
+	// tokenFile is re-read for every request, as the kubelet rotates it.
+	tokenFile string
+	http      *http.Client
+}
+
+var k8s *k8sClient
+
+// k8sStatusError is an API error response.
+type k8sStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *k8sStatusError) Error() string {
+	return fmt.Sprintf("kubernetes API: %d %s", e.code, e.msg)
+}
+
+func k8sNotFound(err error) bool {
+	var se *k8sStatusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound
+}
+
+// connectKubernetes sets up the API client, checks that the namespace can
+// be reached and removes the desktops a previous broker left behind.
+func connectKubernetes(ctx context.Context) error {
+	k := &k8sClient{namespace: BrokerK8sNamespace, http: &http.Client{Timeout: 30 * time.Second}}
+	if BrokerK8sAPI != "" {
+		k.base = strings.TrimSuffix(BrokerK8sAPI, "/")
+	} else {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return errors.New("kubernetes backend: not running in a cluster; set --broker-k8s-api")
+		}
+		ca, err := os.ReadFile(k8sServiceAccount + "/ca.crt")
+		if err != nil {
+			return fmt.Errorf("kubernetes backend: %v", err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		k.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		k.base = "https://" + net.JoinHostPort(host, port)
+		k.tokenFile = k8sServiceAccount + "/token"
+	}
+	if k.namespace == "" {
+		if ns, err := os.ReadFile(k8sServiceAccount + "/namespace"); err == nil {
+			k.namespace = strings.TrimSpace(string(ns))
+		} else {
+			k.namespace = "default"
+		}
+	}
+	if _, err := loadPodTemplate(); err != nil {
+		return err
+	}
+	k8s = k
+
+	if err := k.request(ctx, http.MethodGet, k.path("pods")+"?limit=1", nil, nil); err != nil {
+		return fmt.Errorf("kubernetes backend: cannot list pods in %s: %v", k.namespace, err)
+	}
+	log.Printf("Broker: using Kubernetes at %s, namespace %s", k.base, k.namespace)
+	k.removeOrphans(ctx)
+	return nil
+}
+
+func (k *k8sClient) path(resource string) string {
+	return "/api/v1/namespaces/" + url.PathEscape(k.namespace) + "/" + resource
+}
+
+func (k *k8sClient) request(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var status struct{ Message string }
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		return &k8sStatusError{code: resp.StatusCode, msg: status.Message}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// removeOrphans deletes the pods and services of the broker on Port.
+func (k *k8sClient) removeOrphans(ctx context.Context) {
+	selector := "?labelSelector=" + url.QueryEscape(k8sPortLabel+"="+strconv.Itoa(Port))
+	for _, resource := range []string{"pods", "services"} {
+		var list struct {
+			Items []struct {
+				Metadata struct{ Name string }
+			}
+		}
+		if err := k.request(ctx, http.MethodGet, k.path(resource)+selector, nil, &list); err != nil {
+			log.Printf("Broker: listing old %s: %v", resource, err)
+			continue
+		}
+		for _, item := range list.Items {
+			log.Printf("Broker: deleting %s %s left by a previous broker", strings.TrimSuffix(resource, "s"), item.Metadata.Name)
+			k.delete(ctx, resource, item.Metadata.Name)
+		}
+	}
+}
+
+func (k *k8sClient) delete(ctx context.Context, resource, name string) {
+	body := map[string]interface{}{"gracePeriodSeconds": int(brokerStopTimeout.Seconds())}
+	err := k.request(ctx, http.MethodDelete, k.path(resource)+"/"+url.PathEscape(name), body, nil)
+	if err != nil && !k8sNotFound(err) {
+		log.Printf("Broker: deleting %s %s: %v", resource, name, err)
+	}
+}
+
+// waitGone waits until the named object no longer exists.
+func (k *k8sClient) waitGone(ctx context.Context, resource, name string) error {
+	for {
+		err := k.request(ctx, http.MethodGet, k.path(resource)+"/"+url.PathEscape(name), nil, nil)
+		if k8sNotFound(err) {
+			return nil
+		}
+		if !sleepCtx(ctx, time.Second) {
+			return fmt.Errorf("%s %s of a previous session is still terminating", strings.TrimSuffix(resource, "s"), name)
+		}
+	}
+}
+
+// k8sObjectName returns a DNS-1123 name for user's desktop. The hash keeps
+// users apart whose names only differ in characters that are dropped.
+func k8sObjectName(user string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(user) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	name := strings.Trim(b.String(), "-")
+	if len(name) > 40 {
+		name = strings.TrimRight(name[:40], "-")
+	}
+	sum := sha256.Sum256([]byte(user))
+	if name == "" {
+		return "llrdc-" + hex.EncodeToString(sum[:4])
+	}
+	return "llrdc-" + name + "-" + hex.EncodeToString(sum[:4])
+}
+
+// loadPodTemplate returns BrokerK8sTemplate, or a Pod of BrokerImage.
+func loadPodTemplate() (map[string]interface{}, error) {
+	if BrokerK8sTemplate == "" {
+		return map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "llrdc", "image": BrokerImage},
+				},
+			},
+		}, nil
+	}
+	data, err := os.ReadFile(BrokerK8sTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pod template: %v", err)
+	}
+	var pod map[string]interface{}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, fmt.Errorf("invalid pod template %s (JSON expected): %v", BrokerK8sTemplate, err)
+	}
+	if kind, _ := pod["kind"].(string); kind != "Pod" {
+		return nil, fmt.Errorf("invalid pod template %s: kind is %q, not Pod", BrokerK8sTemplate, kind)
+	}
+	spec, _ := pod["spec"].(map[string]interface{})
+	if containers, _ := spec["containers"].([]interface{}); len(containers) == 0 {
+		return nil, fmt.Errorf("invalid pod template %s: no containers", BrokerK8sTemplate)
+	}
+	return pod, nil
+}
+
+// substitute replaces the placeholders in every string of v.
+func substitute(v interface{}, r *strings.Replacer) interface{} {
+	switch t := v.(type) {
+	case string:
+		return r.Replace(t)
+	case []interface{}:
+		for i := range t {
+			t[i] = substitute(t[i], r)
+		}
+	case map[string]interface{}:
+		for key := range t {
+			t[key] = substitute(t[key], r)
+		}
+	}
+	return v
+}
+
+// child returns m[key] as an object, adding an empty one if missing.
+func child(m map[string]interface{}, key string) map[string]interface{} {
+	c, ok := m[key].(map[string]interface{})
+	if !ok {
+		c = map[string]interface{}{}
+		m[key] = c
+	}
+	return c
+}
+
+// buildPod makes s's Pod from the template.
+func buildPod(s *brokerSession, name string) (map[string]interface{}, error) {
+	pod, err := loadPodTemplate()
+	if err != nil {
+		return nil, err
+	}
+	// Identities may hold any character, so the template gets the session
+	// ID, which is safe in names, label values and paths.
+	substitute(pod, strings.NewReplacer("{{user}}", brokerSessionID(s.user), "{{name}}", name))
+
+	meta := child(pod, "metadata")
+	meta["name"] = name
+	delete(meta, "generateName")
+	labels := child(meta, "labels")
+	labels[k8sSessionLabel] = name
+	labels[k8sPortLabel] = strconv.Itoa(Port)
+	child(meta, "annotations")[k8sUserAnnotation] = s.user
+
+	spec := child(pod, "spec")
+	if _, ok := spec["restartPolicy"]; !ok {
+		spec["restartPolicy"] = "Never"
+	}
+	container, ok := spec["containers"].([]interface{})[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid pod template: bad first container")
+	}
+	// The backend's settings replace any the template gives.
+	env := backendEnv(s, true)
+	set := map[string]bool{}
+	var vars []interface{}
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		if k == "HTTP_ADDR" {
+			// The Service forwards to the pod's own address.
+			v = ""
+		}
+		set[k] = true
+		vars = append(vars, map[string]interface{}{"name": k, "value": v})
+	}
+	old, _ := container["env"].([]interface{})
+	for _, e := range old {
+		if m, ok := e.(map[string]interface{}); ok {
+			if k, _ := m["name"].(string); set[k] {
+				continue
+			}
+		}
+		vars = append(vars, e)
+	}
+	container["env"] = vars
+	ports, _ := container["ports"].([]interface{})
+	container["ports"] = append(ports,
+		map[string]interface{}{"name": "http", "containerPort": s.port, "protocol": "TCP"},
+		map[string]interface{}{"name": "webrtc", "containerPort": s.port, "protocol": "UDP"})
+	return pod, nil
+}
+
+// startKubernetesBackend creates s's Pod and Service and points the proxy
+// at the Service.
+func startKubernetesBackend(s *brokerSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerStartTimeout)
+	defer cancel()
+
+	name := k8sObjectName(s.user)
+	pod, err := buildPod(s, name)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{k8sSessionLabel: name, k8sPortLabel: strconv.Itoa(Port)}
+	service := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": name, "labels": labels},
+		"spec": map[string]interface{}{
+			"selector": map[string]string{k8sSessionLabel: name},
+			"ports": []interface{}{
+				map[string]interface{}{"name": "http", "port": s.port, "targetPort": s.port, "protocol": "TCP"},
+			},
+		},
+	}
+
+	// A desktop of a previous visit may still be shutting down, and its
+	// name is taken until it is gone.
+	for _, resource := range []string{"pods", "services"} {
+		k8s.delete(ctx, resource, name)
+		if err := k8s.waitGone(ctx, resource, name); err != nil {
+			return err
+		}
+	}
+	if err := k8s.request(ctx, http.MethodPost, k8s.path("pods"), pod, nil); err != nil {
+		return err
+	}
+	var created struct {
+		Spec struct{ ClusterIP string }
+	}
+	if err := k8s.request(ctx, http.MethodPost, k8s.path("services"), service, &created); err != nil {
+		k8s.delete(ctx, "pods", name)
+		return err
+	}
+	s.host = created.Spec.ClusterIP
+	if s.host == "" || s.host == "None" {
+		s.host = name + "." + k8s.namespace + ".svc"
+	}
+	s.startTimeout = k8sStartTimeout
+
+	stopping := make(chan struct{})
+	go func() {
+		defer close(s.done)
+		defer k8s.delete(context.Background(), "services", name)
+		for {
+			select {
+			case <-stopping:
+			case <-time.After(k8sPollInterval):
+			}
+			var status struct {
+				Status struct{ Phase string }
+			}
+			err := k8s.request(context.Background(), http.MethodGet, k8s.path("pods")+"/"+url.PathEscape(name), nil, &status)
+			switch {
+			case k8sNotFound(err):
+				return
+			case err != nil:
+				log.Printf("Broker: checking pod %s: %v", name, err)
+			case status.Status.Phase == "Succeeded" || status.Status.Phase == "Failed":
+				log.Printf("Broker: pod %s %s", name, strings.ToLower(status.Status.Phase))
+				k8s.delete(context.Background(), "pods", name)
+				return
+			}
+		}
+	}()
+	s.stop = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), brokerStopTimeout)
+		defer cancel()
+		k8s.delete(ctx, "pods", name)
+		select {
+		case stopping <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
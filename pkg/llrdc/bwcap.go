@@ -0,0 +1,201 @@
+package llrdc
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Per-client bandwidth caps. The encoder is shared, so a cap cannot lower
+// one viewer's encode bitrate. Instead, frames are withheld from that viewer
+// once its byte budget is spent, and delivery resumes at the next keyframe
+// so it never receives a delta frame it cannot decode. A capped viewer sees
+// a lower frame rate, down to keyframes only, while other viewers are
+// unaffected.
+//
+// WebRTC viewers normally share one track. A capped viewer is moved to a
+// track of its own, whose packetizer advances the RTP timestamp over
+// withheld frames without leaving gaps in the sequence numbers.
+
+// capBurst is how much unused budget a viewer may bank, so that keyframes
+// are not always withheld.
+const capBurst = time.Second
+
+// rateLimiter is a token bucket over encoded frames.
+type rateLimiter struct {
+	mbps        float64
+	bytesPerSec float64
+	tokens      float64
+	last        time.Time
+	waitKey     bool
+}
+
+func newRateLimiter(mbps float64) *rateLimiter {
+	bytesPerSec := mbps * 1e6 / 8
+	return &rateLimiter{
+		mbps:        mbps,
+		bytesPerSec: bytesPerSec,
+		tokens:      bytesPerSec * capBurst.Seconds(),
+		last:        time.Now(),
+	}
+}
+
+// allow reports whether a frame of size bytes fits the budget at now. A
+// keyframe only needs a positive balance, since it may be larger than the
+// whole burst; the debt is paid off by withholding the frames after it.
+func (l *rateLimiter) allow(size int, key bool, now time.Time) bool {
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.bytesPerSec, l.bytesPerSec*capBurst.Seconds())
+	l.last = now
+	if l.waitKey && !key {
+		return false
+	}
+	if (key && l.tokens <= 0) || (!key && l.tokens < float64(size)) {
+		l.waitKey = true
+		return false
+	}
+	l.tokens -= float64(size)
+	l.waitKey = false
+	return true
+}
+
+// writeCappedTracks delivers a frame written to the shared WebRTC track to
+// the capped viewers' own tracks, unless their limiter skips it.
+func writeCappedTracks(data []byte, captured time.Time) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	now := time.Now()
+	checked, key := false, false
+	for _, c := range clients {
+		if c.capTrack == nil {
+			continue
+		}
+		if !checked {
+			key = isKeyframe(VideoCodec, data)
+			checked = true
+		}
+		if c.limiter.allow(len(data), key, now) {
+			_ = c.capTrack.writeFrame(data, captured)
+		}
+	}
+}
+
+// setClientVideoSender records the client's new PeerConnection and its
+// video sender, and moves it to a private track if the client is capped.
+func setClientVideoSender(c *Client, pc *webrtc.PeerConnection) {
+	if pc == nil {
+		return
+	}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	c.pc = pc
+	c.videoSender = nil
+	c.capTrack = nil
+	for _, s := range pc.GetSenders() {
+		// The desktop's track comes first; seamless window tracks follow.
+		if t := s.Track(); t != nil && t.Kind() == webrtc.RTPCodecTypeVideo && c.videoSender == nil {
+			c.videoSender = s
+		}
+	}
+	applyClientCap(c)
+}
+
+// clientCap returns the lowest of the client's caps in Mbps, or 0 if it
+// has none. The caller must hold clientsMutex.
+func clientCap(c *Client) float64 {
+	limit := 0.0
+	for _, mbps := range []float64{c.serverCap, c.requestedCap, c.backpressureCap} {
+		if mbps > 0 && (limit <= 0 || mbps < limit) {
+			limit = mbps
+		}
+	}
+	return limit
+}
+
+// applyClientCap enforces the lowest of the client's requested,
+// server-assigned and backpressure caps. The caller must hold clientsMutex.
+func applyClientCap(c *Client) {
+	limit := clientCap(c)
+	if limit <= 0 {
+		c.limiter = nil
+		if c.capTrack != nil && c.videoSender != nil {
+			videoTrackMutex.RLock()
+			vt := videoTrack
+			videoTrackMutex.RUnlock()
+			if err := c.videoSender.ReplaceTrack(vt); err != nil {
+				log.Printf("Failed to restore shared video track: %v", err)
+			}
+		}
+		c.capTrack = nil
+		return
+	}
+
+	if c.limiter == nil || c.limiter.mbps != limit {
+		c.limiter = newRateLimiter(limit)
+	}
+	if c.capTrack == nil && c.videoSender != nil {
+		track, err := newSyncedTrack(videoTrackCapability(), "video", "pion")
+		if err == nil {
+			err = c.videoSender.ReplaceTrack(track)
+		}
+		if err != nil {
+			log.Printf("Failed to give capped client its own video track: %v", err)
+			return
+		}
+		c.capTrack = track
+	}
+}
+
+// setRequestedCap applies a viewer's own cap in Mbps (0 to remove it) and
+// returns the cap now in effect.
+func setRequestedCap(c *Client, mbps float64) float64 {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	c.requestedCap = max(mbps, 0)
+	applyClientCap(c)
+	if c.limiter == nil {
+		return 0
+	}
+	return c.limiter.mbps
+}
+
+// serverBandwidthCap returns the lowest ClientBandwidthCaps entry matching
+// the client's identity or remote address, or 0 if none matches. Entries
+// are comma-separated "match=mbps" pairs where match is a user name (see
+// IdentityHeader), an IP address or a CIDR range.
+func serverBandwidthCap(identity, remoteAddr string) float64 {
+	if ClientBandwidthCaps == "" {
+		return 0
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+
+	limit := 0.0
+	for _, entry := range strings.Split(ClientBandwidthCaps, ",") {
+		match, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		mbps, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || mbps <= 0 {
+			log.Printf("Ignoring invalid client bandwidth cap %q", entry)
+			continue
+		}
+		match = strings.TrimSpace(match)
+		matched := identity != "" && match == identity
+		if !matched && ip != nil {
+			if _, network, err := net.ParseCIDR(match); err == nil {
+				matched = network.Contains(ip)
+			} else if m := net.ParseIP(match); m != nil {
+				matched = m.Equal(ip)
+			}
+		}
+		if matched && (limit == 0 || mbps < limit) {
+			limit = mbps
+		}
+	}
+	return limit
+}
@@ -0,0 +1,136 @@
+package llrdc
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// captureParams describes the raw video an encoder wants from a capture
+// source.
+type captureParams struct {
+	Width     int
+	Height    int
+	FPS       int
+	DrawMouse bool
+}
+
+// captureInput is what an encoder needs to read from a capture source:
+// ffmpeg input options, and for sources that produce raw frames in-process
+// (e.g. XShm or PipeWire) a stream to feed to ffmpeg's stdin. Sources whose
+// frames live in GPU memory also give the filters that bring them to system
+// memory; they must come first in the consumer's filter chain.
+type captureInput struct {
+	Args   []string
+	Stdin  io.Reader
+	Filter string
+}
+
+// filterArgs returns a -vf option running the capture filter followed by
+// filters, or nil if there are none.
+func (in captureInput) filterArgs(filters ...string) []string {
+	chain := joinFilters(append([]string{in.Filter}, filters...)...)
+	if chain == "" {
+		return nil
+	}
+	return []string{"-vf", chain}
+}
+
+// joinFilters chains the non-empty filters.
+func joinFilters(filters ...string) string {
+	chain := []string{}
+	for _, f := range filters {
+		if f != "" {
+			chain = append(chain, f)
+		}
+	}
+	return strings.Join(chain, ",")
+}
+
+// CaptureSource produces raw frames of the display independently of how
+// they are encoded, so any source can be paired with any encoder backend.
+type CaptureSource interface {
+	Name() string
+	Input(p captureParams) captureInput
+}
+
+var captureSources = map[string]CaptureSource{
+	"x11grab":     x11grabCapture{},
+	"kmsgrab":     kmsgrabCapture{},
+	"pipewire":    pipewireCapture{},
+	"testpattern": testPatternCapture{},
+	"latency":     latencyPatternCapture{},
+}
+
+// currentCapture resolves CaptureSourceName into a source, defaulting to the
+// test pattern in --test-pattern mode and to x11grab otherwise.
+func currentCapture() CaptureSource {
+	name := strings.ToLower(strings.TrimSpace(CaptureSourceName))
+	if name == "" {
+		if TestPattern {
+			name = "testpattern"
+		} else {
+			name = "x11grab"
+		}
+	}
+	if c, ok := captureSources[name]; ok {
+		return c
+	}
+	log.Printf("Warning: unknown capture source %q, using x11grab", name)
+	return x11grabCapture{}
+}
+
+// x11grabCapture lets ffmpeg grab the X display directly.
+type x11grabCapture struct{}
+
+func (x11grabCapture) Name() string { return "x11grab" }
+
+func (x11grabCapture) Input(p captureParams) captureInput {
+	drawMouseStr := "0"
+	if p.DrawMouse {
+		drawMouseStr = "1"
+	}
+	return captureInput{Args: []string{
+		"-framerate", fmt.Sprintf("%d", p.FPS),
+		"-f", "x11grab",
+		"-draw_mouse", drawMouseStr,
+		"-video_size", fmt.Sprintf("%dx%d", p.Width, p.Height),
+		"-i", Display + ".0",
+	}}
+}
+
+// testPatternCapture generates ffmpeg's testsrc pattern in real time.
+type testPatternCapture struct{}
+
+func (testPatternCapture) Name() string { return "testpattern" }
+
+func (testPatternCapture) Input(p captureParams) captureInput {
+	return captureInput{Args: []string{
+		"-re", "-f", "lavfi",
+		"-i", fmt.Sprintf("testsrc=size=%dx%d:rate=%d", p.Width, p.Height, p.FPS),
+	}}
+}
+
+// kmsgrabCapture reads the scanout framebuffer of a DRM device, for hosts
+// with a real GPU and monitor. The frames bypass the X server entirely, but
+// arrive as DRM objects: hwdownload copies them to system memory, which
+// only works for linear framebuffers. The hardware cursor is a plane of its
+// own and is never in the frames. ffmpeg needs CAP_SYS_ADMIN to open the
+// framebuffer.
+type kmsgrabCapture struct{}
+
+func (kmsgrabCapture) Name() string { return "kmsgrab" }
+
+func (kmsgrabCapture) Input(p captureParams) captureInput {
+	args := []string{"-framerate", fmt.Sprintf("%d", p.FPS), "-f", "kmsgrab"}
+	if KMSGrabDevice != "" {
+		args = append(args, "-device", KMSGrabDevice)
+	}
+	return captureInput{
+		Args: append(args, "-i", "-"),
+		// The framebuffer need not match the X screen, which is what
+		// consumers size their frames for.
+		Filter: fmt.Sprintf("hwdownload,format=bgr0,scale=%d:%d", p.Width, p.Height),
+	}
+}
@@ -0,0 +1,206 @@
+package llrdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Chat and annotations between the viewers of a session, view-only guests
+// included:
+//
+//	{"type":"chat","text":"look here","name":"Ann"}
+//	{"type":"annotate","kind":"pointer","x":0.4,"y":0.2}
+//	{"type":"annotate","kind":"stroke","points":[[0.1,0.1],[0.2,0.15]],"color":"#f80"}
+//	{"type":"annotate","kind":"clear"}
+//
+// The server stamps each with its sender and relays it to every client,
+// the sender included. The sender is the authenticated identity if there is
+// one (IdentityHeader), otherwise the name the viewer gave, which is not
+// verified. Annotation coordinates are fractions of the video, like pointer
+// input; annotations are temporary, viewers fade them out and later viewers
+// never see them. The last chatHistorySize chat messages are sent to
+// viewers as they connect, in a chat_history message, and with ChatLog they
+// are also appended to a file, from which the history is reloaded after a
+// restart.
+
+const (
+	chatHistorySize     = 100
+	chatMaxLength       = 1000
+	chatMaxNameLength   = 32
+	annotationMaxPoints = 1000
+)
+
+type chatMessage struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	// Verified is set when From is an authenticated identity.
+	Verified bool   `json:"verified,omitempty"`
+	Text     string `json:"text"`
+	Time     int64  `json:"time"`
+}
+
+var (
+	chatMutex   sync.Mutex
+	chatHistory []chatMessage
+	chatLoaded  bool
+)
+
+var annotationColorRe = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// chatSender returns who sent a message giving name and whether that is an
+// authenticated identity.
+func chatSender(client *Client, name string) (string, bool) {
+	if client.identity != "" {
+		return client.identity, true
+	}
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > chatMaxNameLength {
+		name = string([]rune(name)[:chatMaxNameLength])
+	}
+	if name != "" {
+		return name, false
+	}
+	if client.share != nil {
+		return "guest", false
+	}
+	return "viewer", false
+}
+
+// loadChatHistory reads the tail of ChatLog into chatHistory once. The
+// caller must hold chatMutex.
+func loadChatHistory() {
+	if chatLoaded {
+		return
+	}
+	chatLoaded = true
+	if ChatLog == "" {
+		return
+	}
+	f, err := os.Open(ChatLog)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read chat log %s: %v", ChatLog, err)
+		}
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m chatMessage
+		if json.Unmarshal(scanner.Bytes(), &m) != nil || m.Type != "chat" {
+			continue
+		}
+		chatHistory = append(chatHistory, m)
+		if len(chatHistory) > chatHistorySize {
+			chatHistory = chatHistory[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Failed to read chat log %s: %v", ChatLog, err)
+	}
+}
+
+// appendChatLog adds m to ChatLog. The caller must hold chatMutex.
+func appendChatLog(m chatMessage) {
+	if ChatLog == "" {
+		return
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(ChatLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to open chat log %s: %v", ChatLog, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write chat log %s: %v", ChatLog, err)
+	}
+}
+
+// chatHistoryMessage returns the chat_history message for a new viewer, or
+// nil if nothing has been said.
+func chatHistoryMessage() map[string]interface{} {
+	chatMutex.Lock()
+	defer chatMutex.Unlock()
+	loadChatHistory()
+	if len(chatHistory) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"type":     "chat_history",
+		"messages": append([]chatMessage(nil), chatHistory...),
+	}
+}
+
+func handleChatMessage(client *Client, msg *chatSendMessage) {
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		return
+	}
+	if utf8.RuneCountInString(text) > chatMaxLength {
+		text = string([]rune(text)[:chatMaxLength])
+	}
+	from, verified := chatSender(client, msg.Name)
+	m := chatMessage{Type: "chat", From: from, Verified: verified, Text: text, Time: time.Now().UnixMilli()}
+
+	chatMutex.Lock()
+	loadChatHistory()
+	chatHistory = append(chatHistory, m)
+	if len(chatHistory) > chatHistorySize {
+		chatHistory = chatHistory[1:]
+	}
+	appendChatLog(m)
+	chatMutex.Unlock()
+
+	broadcastJSON(m)
+}
+
+// annotationPoint reports whether p is an [x, y] pair of fractions of the
+// video.
+func annotationPoint(p [2]float64) bool {
+	return p[0] >= 0 && p[0] <= 1 && p[1] >= 0 && p[1] <= 1
+}
+
+func handleAnnotateMessage(client *Client, msg *annotateMessage) {
+	kind := msg.Kind
+	from, verified := chatSender(client, msg.Name)
+	out := map[string]interface{}{"type": "annotate", "kind": kind, "from": from}
+	if verified {
+		out["verified"] = true
+	}
+	switch kind {
+	case "pointer":
+		if !annotationPoint([2]float64{msg.X, msg.Y}) {
+			return
+		}
+		out["x"], out["y"] = msg.X, msg.Y
+	case "stroke":
+		if len(msg.Points) == 0 || len(msg.Points) > annotationMaxPoints {
+			return
+		}
+		for _, p := range msg.Points {
+			if !annotationPoint(p) {
+				return
+			}
+		}
+		out["points"] = msg.Points
+	case "clear":
+	default:
+		return
+	}
+	if annotationColorRe.MatchString(msg.Color) {
+		out["color"] = msg.Color
+	}
+	broadcastJSON(out)
+}
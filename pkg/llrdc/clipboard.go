@@ -1,6 +1,7 @@
-package main
+package llrdc
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/exec"
@@ -16,14 +17,16 @@ var (
 
 // startClipboardPoller polls the remote X11 clipboard every second and
 // broadcasts changes to all connected clients via clipboard_get messages.
-func startClipboardPoller(display string, broadcast func(msg interface{})) {
+func startClipboardPoller(ctx context.Context, display string, broadcast func(msg interface{})) {
 	if !EnableClipboard {
 		return
 	}
 
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
+	goWorker(func() {
+		for sleepCtx(ctx, 1*time.Second) {
+			if sessionLocked.Load() {
+				continue
+			}
 			cmd := exec.Command("xclip", "-selection", "clipboard", "-o")
 			cmd.Env = append(os.Environ(), "DISPLAY="+display)
 			out, err := cmd.Output()
@@ -43,21 +46,18 @@ func startClipboardPoller(display string, broadcast func(msg interface{})) {
 				}
 			}
 		}
-	}()
+	})
 }
 
 // handleClipboardSet processes a clipboard_set message from the client.
 // It sets the remote X11 clipboard via xclip and optionally injects Ctrl+V
 // for paste operations.
-func handleClipboardSet(msg map[string]interface{}, display string) {
+func handleClipboardSet(msg *clipboardSetMessage, display string) {
 	if !EnableClipboard {
 		return
 	}
 
-	text, ok := msg["text"].(string)
-	if !ok {
-		return
-	}
+	text := msg.Text
 
 	log.Printf(">>> [Server] Setting remote clipboard: %d chars", len(text))
 	cmd := exec.Command("xclip", "-selection", "clipboard", "-i")
@@ -75,7 +75,7 @@ func handleClipboardSet(msg map[string]interface{}, display string) {
 	}
 
 	// If this is a paste operation, inject Ctrl+V after clipboard is set
-	if paste, ok := msg["paste"].(bool); ok && paste && err == nil {
+	if msg.Paste && err == nil {
 		log.Printf(">>> [Server] Injecting Ctrl+V after clipboard set")
 		time.Sleep(50 * time.Millisecond)
 		vCmd := exec.Command("xdotool", "key", "--clearmodifiers", "ctrl+v")
@@ -0,0 +1,1289 @@
+package llrdc
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var (
+	Port                    int
+	HTTPAddr                string
+	FPS                     int
+	DisplayNum              string
+	Display                 string
+	VideoCodec              string
+	Chroma                  string
+	UseGPU                  bool
+
+	AV1NVENCAvailable       bool
+	H264NVENC444Available   bool
+	H265NVENC444Available   bool
+	UseDebugX11             bool
+	UseDebugFFmpeg          bool
+	TestPattern             bool
+	TestMinimalX11          bool
+	EnableClipboard         bool
+	EnableHybrid            bool
+	EnableAudio             bool
+	AudioBitrate            string
+	TileSize                int
+	Wallpaper               string
+	WebRTCPublicIP          string
+	WebRTCInterfaces        string
+	WebRTCExcludeInterfaces string
+	HDPI                    int
+	HookURL                 string
+	HookScript              string
+	DesktopSession          string
+	KioskCommand            string
+	KioskWM                 string
+	EncoderWatchdogSeconds  int
+	FFmpegLogKB             int
+	LogFile                 string
+	LogMaxSizeMB            int
+	LogMaxFiles             int
+	SessionUID              int
+	SessionGID              int
+	SessionHome             string
+	AppCPULimit             float64
+	AppMemoryLimitMB        int
+	EnableNotifications     bool
+	EnableBandwidthProbe    bool
+	IdleLockMinutes         int
+	LockPassword            string
+	AutoMaxBandwidth        int
+	CaptureSourceName       string
+	BenchmarkOutput         string
+	StateFile               string
+	IdentityHeader          string
+	ProfilesFile            string
+	MJPEGFPS                int
+	EnableHLS               bool
+	PublishURL              string
+	DVRSeconds              int
+	SceneChangeThreshold    int
+	IdleFPS                 int
+	IdleQuality             int
+	ClientBandwidthCaps     string
+	ThumbnailFPS            int
+	DPI                     int
+	ResizePolicy            string
+	ResizeStep              int
+	ResizeAspect            string
+	ResizeModes             string
+	ResizeDebounce          int
+	DataRoot                string
+	SessionID               string
+	WebDAVDir               string
+	WebcamDevice            string
+	EnableGamepad           bool
+	EnablePen               bool
+	ScrollStepPixels        int
+	TouchGestures           bool
+	KeyRepeatDelay          int
+	KeyRepeatRate           int
+	KeyRepeatPassthrough    bool
+	EnableMacros            bool
+	GRPCAddr                string
+	GRPCToken               string
+	Broker                  bool
+	BrokerBackend           string
+	BrokerImage             string
+	BrokerPortBase          int
+	BrokerMaxSessions       int
+	BrokerIdleMinutes       int
+	BrokerEngineSocket      string
+	BrokerVolumePrefix      string
+	BrokerK8sTemplate       string
+	BrokerK8sNamespace      string
+	BrokerK8sAPI            string
+	EnableShareLinks        bool
+	ShareMaxMinutes         int
+	AllowIPs                string
+	DenyIPs                 string
+	GeoIPDB                 string
+	AllowCountries          string
+	DenyCountries           string
+	TrustedProxies          string
+	TLSCert                 string
+	TLSKey                  string
+	TLSClientCA             string
+	HTTP3Port               int
+	EnableWebTransport      bool
+	EnableSSESignaling      bool
+	EnableWHEP              bool
+	WHEPToken               string
+	VNCAddr                 string
+	VNCPassword             string
+	ReverseSSH              string
+	ReverseSSHKey           string
+	ReverseSSHKnownHosts    string
+	ReverseSSHRemote        string
+	EnableMDNS              bool
+	MDNSName                string
+	EnablePortMapping       bool
+	BackpressureCap         bool
+	WSWindowKB              int
+	WSCompression           bool
+	MaxBufferedMB           int
+	STUNServers             string
+	LANOnly                 bool
+	GPUScale                string
+	KMSGrabDevice           string
+	SeamlessWindows         bool
+	AudioDevice             string
+	AudioFrameDuration      float64
+	AudioFEC                bool
+	AudioDTX                bool
+	ChatLog                 string
+	URLHandoff              bool
+	SessionManifest         string
+)
+
+// Config holds the server settings. DefaultConfig fills it from the
+// environment and LoadConfig additionally applies command-line flags.
+type Config struct {
+	Port                    int
+	HTTPAddr                string
+	FPS                     int
+	VideoCodec              string
+	Chroma                  string
+	CaptureSource           string
+	CpuEffort               int
+	CpuThreads              int
+	UseGPU                  bool
+	UseDebugX11             bool
+	UseDebugFFmpeg          bool
+	DisplayNum              string
+	TestPattern             bool
+	TestMinimalX11          bool
+	Wallpaper               string
+	SessionUID              int
+	SessionGID              int
+	SessionHome             string
+	AppCPULimit             float64
+	AppMemoryLimitMB        int
+	KioskCommand            string
+	KioskWM                 string
+	DesktopSession          string
+	WebRTCPublicIP          string
+	WebRTCInterfaces        string
+	WebRTCExcludeInterfaces string
+	EnableClipboard         bool
+	EnableNotifications     bool
+	EnableAudio             bool
+	AudioBitrate            string
+	EnableHybrid            bool
+	TileSize                int
+	HDPI                    int
+	AutoMaxBandwidth        int
+	EnableBandwidthProbe    bool
+	IdleLockMinutes         int
+	LockPassword            string
+	EncoderWatchdogSeconds  int
+	FFmpegLogKB             int
+	LogFile                 string
+	LogMaxSizeMB            int
+	LogMaxFiles             int
+	HookURL                 string
+	HookScript              string
+	StateFile               string
+	IdentityHeader          string
+	ProfilesFile            string
+	MJPEGFPS                int
+	EnableHLS               bool
+	PublishURL              string
+	DVRSeconds              int
+	SceneChangeThreshold    int
+	IdleFPS                 int
+	IdleQuality             int
+	ClientBandwidthCaps     string
+	ThumbnailFPS            int
+	DPI                     int
+	ResizePolicy            string
+	ResizeStep              int
+	ResizeAspect            string
+	ResizeModes             string
+	ResizeDebounce          int
+	DataRoot                string
+	SessionID               string
+	WebDAVDir               string
+	WebcamDevice            string
+	EnableGamepad           bool
+	EnablePen               bool
+	ScrollStepPixels        int
+	TouchGestures           bool
+	KeyRepeatDelay          int
+	KeyRepeatRate           int
+	KeyRepeatPassthrough    bool
+	EnableMacros            bool
+	GRPCAddr                string
+	GRPCToken               string
+	Broker                  bool
+	BrokerBackend           string
+	BrokerImage             string
+	BrokerPortBase          int
+	BrokerMaxSessions       int
+	BrokerIdleMinutes       int
+	BrokerEngineSocket      string
+	BrokerVolumePrefix      string
+	BrokerK8sTemplate       string
+	BrokerK8sNamespace      string
+	BrokerK8sAPI            string
+	EnableShareLinks        bool
+	ShareMaxMinutes         int
+	AllowIPs                string
+	DenyIPs                 string
+	GeoIPDB                 string
+	AllowCountries          string
+	DenyCountries           string
+	TrustedProxies          string
+	TLSCert                 string
+	TLSKey                  string
+	TLSClientCA             string
+	HTTP3Port               int
+	EnableWebTransport      bool
+	EnableSSESignaling      bool
+	EnableWHEP              bool
+	WHEPToken               string
+	VNCAddr                 string
+	VNCPassword             string
+	ReverseSSH              string
+	ReverseSSHKey           string
+	ReverseSSHKnownHosts    string
+	ReverseSSHRemote        string
+	EnableMDNS              bool
+	MDNSName                string
+	EnablePortMapping       bool
+	BackpressureCap         bool
+	WSWindowKB              int
+	WSCompression           bool
+	MaxBufferedMB           int
+	STUNServers             string
+	LANOnly                 bool
+	GPUScale                string
+	KMSGrabDevice           string
+	SeamlessWindows         bool
+	AudioDevice             string
+	AudioFrameDuration      float64
+	AudioFEC                bool
+	AudioDTX                bool
+	ChatLog                 string
+	URLHandoff              bool
+	SessionManifest         string
+	Benchmark               bool
+	BenchmarkOutput         string
+	Doctor                  bool
+	LoadTest                int
+	LoadTestURL             string
+	LoadTestDuration        int
+	LoadTestInput           bool
+}
+
+// DefaultConfig returns the configuration from environment variables, with
+// built-in defaults for anything unset.
+func DefaultConfig() Config {
+	// Fallback from environment variables
+	defaultPort := 8080
+	if p, err := strconv.Atoi(os.Getenv("PORT")); err == nil {
+		defaultPort = p
+	}
+
+	defaultHTTPAddr := os.Getenv("HTTP_ADDR")
+
+	defaultFPS := 30
+	if f, err := strconv.Atoi(os.Getenv("FPS")); err == nil {
+		defaultFPS = f
+	}
+
+	defaultVideoCodec := os.Getenv("VIDEO_CODEC")
+	if defaultVideoCodec == "" {
+		defaultVideoCodec = "vp8"
+	}
+
+	defaultChroma := os.Getenv("CHROMA")
+	if defaultChroma == "" {
+		defaultChroma = "420"
+	}
+
+	defaultUseGPU := os.Getenv("USE_GPU") == "true"
+	defaultUseDebugX11 := os.Getenv("USE_DEBUG_X11") == "true"
+	defaultUseDebugFFmpeg := os.Getenv("USE_DEBUG_FFMPEG") == "true"
+	defaultTestPattern := os.Getenv("TEST_PATTERN") != ""
+	defaultTestMinimalX11 := os.Getenv("TEST_MINIMAL_X11") != ""
+	defaultEnableClipboard := os.Getenv("ENABLE_CLIPBOARD") != "false"
+	defaultEnableHybrid := os.Getenv("ENABLE_HYBRID") == "true"
+	defaultEnableNotifications := os.Getenv("ENABLE_NOTIFICATIONS") != "false"
+	defaultEnableBandwidthProbe := os.Getenv("ENABLE_BANDWIDTH_PROBE") != "false"
+	defaultEnableAudio := os.Getenv("ENABLE_AUDIO") != "false"
+	defaultEnableHLS := os.Getenv("ENABLE_HLS") == "true"
+	defaultAudioBitrate := os.Getenv("AUDIO_BITRATE")
+	if defaultAudioBitrate == "" {
+		defaultAudioBitrate = "128k"
+	}
+	defaultTileSizeStr := os.Getenv("TILE_SIZE")
+	defaultTileSize := 512
+	if defaultTileSizeStr != "" {
+		if val, err := strconv.Atoi(defaultTileSizeStr); err == nil {
+			defaultTileSize = val
+		}
+	}
+
+	defaultDisplayNum := os.Getenv("DISPLAY_NUM")
+	if defaultDisplayNum == "" {
+		defaultDisplayNum = "99"
+	}
+
+	defaultWallpaper := os.Getenv("WALLPAPER")
+	defaultWebRTCPublicIP := os.Getenv("WEBRTC_PUBLIC_IP")
+	defaultWebRTCInterfaces := os.Getenv("WEBRTC_INTERFACES")
+	defaultWebRTCExcludeInterfaces := os.Getenv("WEBRTC_EXCLUDE_INTERFACES")
+	
+	defaultHDPI := 0
+	if hdpi, err := strconv.Atoi(os.Getenv("HDPI")); err == nil {
+		defaultHDPI = hdpi
+	}
+
+	defaultEncoderWatchdog := 10
+	if w, err := strconv.Atoi(os.Getenv("ENCODER_WATCHDOG_SECONDS")); err == nil {
+		defaultEncoderWatchdog = w
+	}
+
+	defaultFFmpegLogKB := 64
+	if v, err := strconv.Atoi(os.Getenv("FFMPEG_LOG_KB")); err == nil {
+		defaultFFmpegLogKB = v
+	}
+
+	defaultLogFile := os.Getenv("LOG_FILE")
+
+	defaultLogMaxSizeMB := 10
+	if v, err := strconv.Atoi(os.Getenv("LOG_MAX_SIZE_MB")); err == nil {
+		defaultLogMaxSizeMB = v
+	}
+
+	defaultLogMaxFiles := 5
+	if v, err := strconv.Atoi(os.Getenv("LOG_MAX_FILES")); err == nil {
+		defaultLogMaxFiles = v
+	}
+
+	defaultSessionUID := -1
+	if uid, err := strconv.Atoi(os.Getenv("SESSION_UID")); err == nil {
+		defaultSessionUID = uid
+	}
+	defaultSessionGID := -1
+	if gid, err := strconv.Atoi(os.Getenv("SESSION_GID")); err == nil {
+		defaultSessionGID = gid
+	}
+	defaultSessionHome := os.Getenv("SESSION_HOME")
+
+	defaultAppCPULimit := 0.0
+	if c, err := strconv.ParseFloat(os.Getenv("APP_CPU_LIMIT"), 64); err == nil {
+		defaultAppCPULimit = c
+	}
+	defaultAppMemoryLimit := 0
+	if m, err := strconv.Atoi(os.Getenv("APP_MEMORY_LIMIT_MB")); err == nil {
+		defaultAppMemoryLimit = m
+	}
+
+	defaultAutoMaxBandwidth := 20
+	if bw, err := strconv.Atoi(os.Getenv("AUTO_MAX_BANDWIDTH")); err == nil {
+		defaultAutoMaxBandwidth = bw
+	}
+
+	defaultIdleLockMinutes := 0
+	if m, err := strconv.Atoi(os.Getenv("IDLE_LOCK_MINUTES")); err == nil {
+		defaultIdleLockMinutes = m
+	}
+	defaultLockPassword := os.Getenv("LOCK_PASSWORD")
+
+	defaultHookURL := os.Getenv("HOOK_URL")
+	defaultHookScript := os.Getenv("HOOK_SCRIPT")
+
+	defaultCpuEffort := -1
+	if e, err := strconv.Atoi(os.Getenv("CPU_EFFORT")); err == nil {
+		defaultCpuEffort = e
+	}
+	defaultCpuThreads := 0
+	if t, err := strconv.Atoi(os.Getenv("CPU_THREADS")); err == nil {
+		defaultCpuThreads = t
+	}
+
+	defaultCaptureSource := os.Getenv("CAPTURE_SOURCE")
+	if defaultCaptureSource == "" && os.Getenv("TEST_PATTERN") == "latency" {
+		defaultCaptureSource = "latency"
+	}
+
+	defaultBenchmarkOutput := os.Getenv("BENCHMARK_OUTPUT")
+	if defaultBenchmarkOutput == "" {
+		defaultBenchmarkOutput = "llrdc-benchmark.env"
+	}
+
+	defaultStateFile, ok := os.LookupEnv("STATE_FILE")
+	if !ok {
+		defaultStateFile = "llrdc-state.json"
+	}
+
+	defaultIdentityHeader := os.Getenv("IDENTITY_HEADER")
+	defaultProfilesFile, ok := os.LookupEnv("PROFILES_FILE")
+	if !ok {
+		defaultProfilesFile = "llrdc-profiles.json"
+	}
+
+	defaultMJPEGFPS := 5
+	if f, err := strconv.Atoi(os.Getenv("MJPEG_FPS")); err == nil {
+		defaultMJPEGFPS = f
+	}
+
+	defaultPublishURL := os.Getenv("PUBLISH_URL")
+
+	defaultDVRSeconds := 0
+	if s, err := strconv.Atoi(os.Getenv("DVR_SECONDS")); err == nil {
+		defaultDVRSeconds = s
+	}
+
+	defaultSceneChangeThreshold := 10
+	if t, err := strconv.Atoi(os.Getenv("SCENE_CHANGE_THRESHOLD")); err == nil {
+		defaultSceneChangeThreshold = t
+	}
+
+	defaultIdleFPS := 0
+	if f, err := strconv.Atoi(os.Getenv("IDLE_FPS")); err == nil {
+		defaultIdleFPS = f
+	}
+
+	defaultIdleQuality := 0
+	if q, err := strconv.Atoi(os.Getenv("IDLE_QUALITY")); err == nil {
+		defaultIdleQuality = q
+	}
+
+	defaultClientBandwidthCaps := os.Getenv("CLIENT_BANDWIDTH_CAPS")
+
+	defaultThumbnailFPS := 2
+	if f, err := strconv.Atoi(os.Getenv("THUMBNAIL_FPS")); err == nil {
+		defaultThumbnailFPS = f
+	}
+
+	defaultDPI := 0
+	if d, err := strconv.Atoi(os.Getenv("DPI")); err == nil {
+		defaultDPI = d
+	}
+
+	defaultResizePolicy := os.Getenv("RESIZE_POLICY")
+	if defaultResizePolicy == "" {
+		defaultResizePolicy = "follow"
+	}
+
+	defaultResizeStep := 2
+	if v, err := strconv.Atoi(os.Getenv("RESIZE_STEP")); err == nil {
+		defaultResizeStep = v
+	}
+
+	defaultResizeAspect := os.Getenv("RESIZE_ASPECT")
+	if defaultResizeAspect == "" {
+		defaultResizeAspect = "16:9"
+	}
+
+	defaultResizeModes := os.Getenv("RESIZE_MODES")
+	if defaultResizeModes == "" {
+		defaultResizeModes = "3840x2160,2560x1440,1920x1080,1600x900,1280x720"
+	}
+
+	defaultResizeDebounce := 200
+	if v, err := strconv.Atoi(os.Getenv("RESIZE_DEBOUNCE")); err == nil {
+		defaultResizeDebounce = v
+	}
+
+	defaultDataRoot := os.Getenv("DATA_ROOT")
+
+	defaultSessionID := os.Getenv("SESSION_ID")
+	if defaultSessionID == "" {
+		defaultSessionID = "default"
+	}
+
+	defaultWebDAVDir := os.Getenv("WEBDAV_DIR")
+
+	defaultWebcamDevice := os.Getenv("WEBCAM_DEVICE")
+
+	defaultEnableGamepad := os.Getenv("ENABLE_GAMEPAD") == "true"
+
+	defaultEnablePen := os.Getenv("ENABLE_PEN") == "true"
+
+	defaultScrollStepPixels := 20
+	if v, err := strconv.Atoi(os.Getenv("SCROLL_STEP_PIXELS")); err == nil {
+		defaultScrollStepPixels = v
+	}
+
+	defaultTouchGestures := os.Getenv("TOUCH_GESTURES") != "false"
+
+	defaultKeyRepeatDelay := 500
+	if v, err := strconv.Atoi(os.Getenv("KEY_REPEAT_DELAY")); err == nil {
+		defaultKeyRepeatDelay = v
+	}
+
+	defaultKeyRepeatRate := 30
+	if v, err := strconv.Atoi(os.Getenv("KEY_REPEAT_RATE")); err == nil {
+		defaultKeyRepeatRate = v
+	}
+
+	defaultKeyRepeatPassthrough := os.Getenv("KEY_REPEAT_PASSTHROUGH") == "true"
+
+	defaultEnableMacros := os.Getenv("ENABLE_MACROS") == "true"
+
+	defaultGRPCAddr := os.Getenv("GRPC_ADDR")
+
+	defaultGRPCToken := os.Getenv("GRPC_TOKEN")
+
+	defaultBroker := os.Getenv("BROKER") == "true"
+
+	defaultBrokerBackend := os.Getenv("BROKER_BACKEND")
+	if defaultBrokerBackend == "" {
+		defaultBrokerBackend = "local"
+	}
+
+	defaultBrokerImage := os.Getenv("BROKER_IMAGE")
+	if defaultBrokerImage == "" {
+		defaultBrokerImage = "danchitnis/llrdc"
+	}
+
+	defaultBrokerPortBase := 0
+	if v, err := strconv.Atoi(os.Getenv("BROKER_PORT_BASE")); err == nil {
+		defaultBrokerPortBase = v
+	}
+
+	defaultBrokerMaxSessions := 10
+	if v, err := strconv.Atoi(os.Getenv("BROKER_MAX_SESSIONS")); err == nil {
+		defaultBrokerMaxSessions = v
+	}
+
+	defaultBrokerIdleMinutes := 30
+	if v, err := strconv.Atoi(os.Getenv("BROKER_IDLE_MINUTES")); err == nil {
+		defaultBrokerIdleMinutes = v
+	}
+
+	defaultBrokerEngineSocket := os.Getenv("BROKER_ENGINE_SOCKET")
+
+	defaultBrokerVolumePrefix := os.Getenv("BROKER_VOLUME_PREFIX")
+
+	defaultBrokerK8sTemplate := os.Getenv("BROKER_K8S_TEMPLATE")
+
+	defaultBrokerK8sNamespace := os.Getenv("BROKER_K8S_NAMESPACE")
+
+	defaultBrokerK8sAPI := os.Getenv("BROKER_K8S_API")
+
+	defaultEnableShareLinks := os.Getenv("ENABLE_SHARE_LINKS") == "true"
+
+	defaultShareMaxMinutes := 1440
+	if v, err := strconv.Atoi(os.Getenv("SHARE_MAX_MINUTES")); err == nil {
+		defaultShareMaxMinutes = v
+	}
+
+	defaultAllowIPs := os.Getenv("ALLOW_IPS")
+
+	defaultDenyIPs := os.Getenv("DENY_IPS")
+
+	defaultGeoIPDB := os.Getenv("GEOIP_DB")
+
+	defaultAllowCountries := os.Getenv("ALLOW_COUNTRIES")
+
+	defaultDenyCountries := os.Getenv("DENY_COUNTRIES")
+
+	defaultTrustedProxies := os.Getenv("TRUSTED_PROXIES")
+
+	defaultTLSCert := os.Getenv("TLS_CERT")
+
+	defaultTLSKey := os.Getenv("TLS_KEY")
+
+	defaultTLSClientCA := os.Getenv("TLS_CLIENT_CA")
+
+	defaultHTTP3Port := 0
+	if v, err := strconv.Atoi(os.Getenv("HTTP3_PORT")); err == nil {
+		defaultHTTP3Port = v
+	}
+
+	defaultEnableWebTransport := os.Getenv("ENABLE_WEBTRANSPORT") == "true"
+
+	defaultEnableSSESignaling := os.Getenv("ENABLE_SSE_SIGNALING") != "false"
+
+	defaultEnableWHEP := os.Getenv("ENABLE_WHEP") == "true"
+
+	defaultWHEPToken := os.Getenv("WHEP_TOKEN")
+
+	defaultVNCAddr := os.Getenv("VNC_ADDR")
+
+	defaultVNCPassword := os.Getenv("VNC_PASSWORD")
+
+	defaultReverseSSH := os.Getenv("REVERSE_SSH")
+
+	defaultReverseSSHKey := os.Getenv("REVERSE_SSH_KEY")
+
+	defaultReverseSSHKnownHosts := os.Getenv("REVERSE_SSH_KNOWN_HOSTS")
+
+	defaultReverseSSHRemote := os.Getenv("REVERSE_SSH_REMOTE")
+
+	defaultEnableMDNS := os.Getenv("MDNS") == "true"
+
+	defaultMDNSName := os.Getenv("MDNS_NAME")
+
+	defaultEnablePortMapping := os.Getenv("PORT_MAPPING") == "true"
+
+	defaultBackpressureCap := os.Getenv("BACKPRESSURE_CAP") == "true"
+
+	defaultWSWindowKB := 512
+	if v, err := strconv.Atoi(os.Getenv("WS_WINDOW_KB")); err == nil {
+		defaultWSWindowKB = v
+	}
+
+	defaultWSCompression := os.Getenv("WS_COMPRESSION") != "false"
+
+	defaultMaxBufferedMB := 0
+	if v, err := strconv.Atoi(os.Getenv("MAX_BUFFERED_MB")); err == nil {
+		defaultMaxBufferedMB = v
+	}
+
+	defaultSTUNServers := os.Getenv("STUN_SERVERS")
+	if defaultSTUNServers == "" {
+		defaultSTUNServers = defaultSTUNServer
+	}
+
+	defaultLANOnly := os.Getenv("LAN_ONLY") == "true" || os.Getenv("LAN_ONLY") == "1"
+
+	defaultGPUScale := os.Getenv("GPU_SCALE")
+	if defaultGPUScale == "" {
+		defaultGPUScale = "auto"
+	}
+
+	defaultKMSGrabDevice := os.Getenv("KMSGRAB_DEVICE")
+
+	defaultSeamlessWindows := os.Getenv("SEAMLESS_WINDOWS") == "true"
+
+	defaultAudioDevice := os.Getenv("AUDIO_DEVICE")
+
+	defaultAudioFrameDuration := 20.0
+	if v, err := strconv.ParseFloat(os.Getenv("AUDIO_FRAME_DURATION"), 64); err == nil {
+		defaultAudioFrameDuration = v
+	}
+
+	defaultAudioFEC := os.Getenv("AUDIO_FEC") == "true"
+
+	defaultAudioDTX := os.Getenv("AUDIO_DTX") == "true"
+
+	defaultChatLog := os.Getenv("CHAT_LOG")
+
+	defaultURLHandoff := os.Getenv("URL_HANDOFF") == "true"
+
+	defaultSessionManifest := os.Getenv("SESSION_MANIFEST")
+
+	defaultKioskCommand := os.Getenv("KIOSK_COMMAND")
+	defaultKioskWM := os.Getenv("KIOSK_WM")
+	if defaultKioskWM == "" {
+		defaultKioskWM = "xfwm4"
+	}
+
+	defaultDesktopSession := os.Getenv("DESKTOP_SESSION")
+	if defaultDesktopSession == "" {
+		defaultDesktopSession = "xfce"
+	}
+
+	return Config{
+		Port:                    defaultPort,
+		HTTPAddr:                defaultHTTPAddr,
+		FPS:                     defaultFPS,
+		VideoCodec:              defaultVideoCodec,
+		Chroma:                  defaultChroma,
+		CaptureSource:           defaultCaptureSource,
+		CpuEffort:               defaultCpuEffort,
+		CpuThreads:              defaultCpuThreads,
+		UseGPU:                  defaultUseGPU,
+		UseDebugX11:             defaultUseDebugX11,
+		UseDebugFFmpeg:          defaultUseDebugFFmpeg,
+		DisplayNum:              defaultDisplayNum,
+		TestPattern:             defaultTestPattern,
+		TestMinimalX11:          defaultTestMinimalX11,
+		Wallpaper:               defaultWallpaper,
+		SessionUID:              defaultSessionUID,
+		SessionGID:              defaultSessionGID,
+		SessionHome:             defaultSessionHome,
+		AppCPULimit:             defaultAppCPULimit,
+		AppMemoryLimitMB:        defaultAppMemoryLimit,
+		KioskCommand:            defaultKioskCommand,
+		KioskWM:                 defaultKioskWM,
+		DesktopSession:          defaultDesktopSession,
+		WebRTCPublicIP:          defaultWebRTCPublicIP,
+		WebRTCInterfaces:        defaultWebRTCInterfaces,
+		WebRTCExcludeInterfaces: defaultWebRTCExcludeInterfaces,
+		EnableClipboard:         defaultEnableClipboard,
+		EnableNotifications:     defaultEnableNotifications,
+		EnableAudio:             defaultEnableAudio,
+		AudioBitrate:            defaultAudioBitrate,
+		EnableHybrid:            defaultEnableHybrid,
+		TileSize:                defaultTileSize,
+		HDPI:                    defaultHDPI,
+		AutoMaxBandwidth:        defaultAutoMaxBandwidth,
+		EnableBandwidthProbe:    defaultEnableBandwidthProbe,
+		IdleLockMinutes:         defaultIdleLockMinutes,
+		LockPassword:            defaultLockPassword,
+		EncoderWatchdogSeconds:  defaultEncoderWatchdog,
+		FFmpegLogKB:             defaultFFmpegLogKB,
+		LogFile:                 defaultLogFile,
+		LogMaxSizeMB:            defaultLogMaxSizeMB,
+		LogMaxFiles:             defaultLogMaxFiles,
+		HookURL:                 defaultHookURL,
+		HookScript:              defaultHookScript,
+		StateFile:               defaultStateFile,
+		IdentityHeader:          defaultIdentityHeader,
+		ProfilesFile:            defaultProfilesFile,
+		MJPEGFPS:                defaultMJPEGFPS,
+		EnableHLS:               defaultEnableHLS,
+		PublishURL:              defaultPublishURL,
+		DVRSeconds:              defaultDVRSeconds,
+		SceneChangeThreshold:    defaultSceneChangeThreshold,
+		IdleFPS:                 defaultIdleFPS,
+		IdleQuality:             defaultIdleQuality,
+		ClientBandwidthCaps:     defaultClientBandwidthCaps,
+		ThumbnailFPS:            defaultThumbnailFPS,
+		DPI:                     defaultDPI,
+		ResizePolicy:            defaultResizePolicy,
+		ResizeStep:              defaultResizeStep,
+		ResizeAspect:            defaultResizeAspect,
+		ResizeModes:             defaultResizeModes,
+		ResizeDebounce:          defaultResizeDebounce,
+		DataRoot:                defaultDataRoot,
+		SessionID:               defaultSessionID,
+		WebDAVDir:               defaultWebDAVDir,
+		WebcamDevice:            defaultWebcamDevice,
+		EnableGamepad:           defaultEnableGamepad,
+		EnablePen:               defaultEnablePen,
+		ScrollStepPixels:        defaultScrollStepPixels,
+		TouchGestures:           defaultTouchGestures,
+		KeyRepeatDelay:          defaultKeyRepeatDelay,
+		KeyRepeatRate:           defaultKeyRepeatRate,
+		KeyRepeatPassthrough:    defaultKeyRepeatPassthrough,
+		EnableMacros:            defaultEnableMacros,
+		GRPCAddr:                defaultGRPCAddr,
+		GRPCToken:               defaultGRPCToken,
+		Broker:                  defaultBroker,
+		BrokerBackend:           defaultBrokerBackend,
+		BrokerImage:             defaultBrokerImage,
+		BrokerPortBase:          defaultBrokerPortBase,
+		BrokerMaxSessions:       defaultBrokerMaxSessions,
+		BrokerIdleMinutes:       defaultBrokerIdleMinutes,
+		BrokerEngineSocket:      defaultBrokerEngineSocket,
+		BrokerVolumePrefix:      defaultBrokerVolumePrefix,
+		BrokerK8sTemplate:       defaultBrokerK8sTemplate,
+		BrokerK8sNamespace:      defaultBrokerK8sNamespace,
+		BrokerK8sAPI:            defaultBrokerK8sAPI,
+		EnableShareLinks:        defaultEnableShareLinks,
+		ShareMaxMinutes:         defaultShareMaxMinutes,
+		AllowIPs:                defaultAllowIPs,
+		DenyIPs:                 defaultDenyIPs,
+		GeoIPDB:                 defaultGeoIPDB,
+		AllowCountries:          defaultAllowCountries,
+		DenyCountries:           defaultDenyCountries,
+		TrustedProxies:          defaultTrustedProxies,
+		TLSCert:                 defaultTLSCert,
+		TLSKey:                  defaultTLSKey,
+		TLSClientCA:             defaultTLSClientCA,
+		HTTP3Port:               defaultHTTP3Port,
+		EnableWebTransport:      defaultEnableWebTransport,
+		EnableSSESignaling:      defaultEnableSSESignaling,
+		EnableWHEP:              defaultEnableWHEP,
+		WHEPToken:               defaultWHEPToken,
+		VNCAddr:                 defaultVNCAddr,
+		VNCPassword:             defaultVNCPassword,
+		ReverseSSH:              defaultReverseSSH,
+		ReverseSSHKey:           defaultReverseSSHKey,
+		ReverseSSHKnownHosts:    defaultReverseSSHKnownHosts,
+		ReverseSSHRemote:        defaultReverseSSHRemote,
+		EnableMDNS:              defaultEnableMDNS,
+		MDNSName:                defaultMDNSName,
+		EnablePortMapping:       defaultEnablePortMapping,
+		BackpressureCap:         defaultBackpressureCap,
+		WSWindowKB:              defaultWSWindowKB,
+		WSCompression:           defaultWSCompression,
+		MaxBufferedMB:           defaultMaxBufferedMB,
+		STUNServers:             defaultSTUNServers,
+		LANOnly:                 defaultLANOnly,
+		GPUScale:                defaultGPUScale,
+		KMSGrabDevice:           defaultKMSGrabDevice,
+		SeamlessWindows:         defaultSeamlessWindows,
+		AudioDevice:             defaultAudioDevice,
+		AudioFrameDuration:      defaultAudioFrameDuration,
+		AudioFEC:                defaultAudioFEC,
+		AudioDTX:                defaultAudioDTX,
+		ChatLog:                 defaultChatLog,
+		URLHandoff:              defaultURLHandoff,
+		SessionManifest:         defaultSessionManifest,
+		BenchmarkOutput:         defaultBenchmarkOutput,
+		LoadTestDuration:        30,
+	}
+}
+
+// LoadConfig returns DefaultConfig overridden by command-line flags.
+func LoadConfig() Config {
+	cfg := DefaultConfig()
+
+	// Custom Usage format
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of llrdc:\n")
+		fmt.Fprintf(os.Stderr, "  llrdc [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "Note: --port configures both the HTTP and WebRTC UDP port.\n\n")
+
+		fmt.Fprintf(os.Stderr, "User Flags:\n")
+		printFlag(os.Stderr, "port", "Port for HTTP and WebRTC UDP", cfg.Port)
+		printFlag(os.Stderr, "http-addr", "Address the HTTP server listens on (empty for all interfaces)", cfg.HTTPAddr)
+		printFlag(os.Stderr, "fps", "Target framerate", cfg.FPS)
+		printFlag(os.Stderr, "video-codec", "Video codec (vp8, h264, h264_nvenc, h265, h265_nvenc, av1, av1_nvenc)", cfg.VideoCodec)
+		printFlag(os.Stderr, "chroma", "Chroma subsampling format (420 or 444)", cfg.Chroma)
+		printFlag(os.Stderr, "capture-source", "Capture source (x11grab, kmsgrab, pipewire, testpattern or latency; empty picks from --test-pattern)", cfg.CaptureSource)
+		printFlag(os.Stderr, "cpu-effort", "VP8 cpu-used speed setting (0-8, higher is faster; -1 tunes it to the host)", cfg.CpuEffort)
+		printFlag(os.Stderr, "cpu-threads", "VP8 and AV1 encoder threads (0 picks them for the host)", cfg.CpuThreads)
+		printFlag(os.Stderr, "use-gpu", "Enable GPU acceleration if available", cfg.UseGPU)
+		printFlag(os.Stderr, "use-debug-x11", "Enable X11 debugging", cfg.UseDebugX11)
+		printFlag(os.Stderr, "use-debug-ffmpeg", "Enable FFmpeg debugging", cfg.UseDebugFFmpeg)
+		printFlag(os.Stderr, "display-num", "X11 Display number (e.g., 99 for :99)", cfg.DisplayNum)
+		printFlag(os.Stderr, "wallpaper", "Path to wallpaper image", cfg.Wallpaper)
+		printFlag(os.Stderr, "session-uid", "Run the desktop session as this UID (-1 to inherit the server's identity)", cfg.SessionUID)
+		printFlag(os.Stderr, "session-gid", "GID for the desktop session (defaults to --session-uid)", cfg.SessionGID)
+		printFlag(os.Stderr, "session-home", "Managed home directory for the session user", cfg.SessionHome)
+		printFlag(os.Stderr, "app-cpu-limit", "CPU cap for each spawned app in cores (0 for unlimited)", cfg.AppCPULimit)
+		printFlag(os.Stderr, "app-memory-limit", "Memory cap for each spawned app in MB (0 for unlimited)", cfg.AppMemoryLimitMB)
+		printFlag(os.Stderr, "kiosk-command", "Run a single fullscreen application instead of a full desktop", cfg.KioskCommand)
+		printFlag(os.Stderr, "kiosk-wm", "Window manager used in kiosk mode (empty for none)", cfg.KioskWM)
+		printFlag(os.Stderr, "desktop-session", "Desktop session (xfce, openbox, i3, lxqt, mate, a window manager command, or a startup script path)", cfg.DesktopSession)
+		printFlag(os.Stderr, "webrtc-public-ip", "Public IP for WebRTC", cfg.WebRTCPublicIP)
+		printFlag(os.Stderr, "webrtc-interfaces", "Comma-separated allowed network interfaces for WebRTC", cfg.WebRTCInterfaces)
+		printFlag(os.Stderr, "webrtc-exclude-interfaces", "Comma-separated excluded network interfaces for WebRTC", cfg.WebRTCExcludeInterfaces)
+		printFlag(os.Stderr, "enable-clipboard", "Enable clipboard synchronization", cfg.EnableClipboard)
+		printFlag(os.Stderr, "enable-notifications", "Forward desktop notifications to clients", cfg.EnableNotifications)
+		printFlag(os.Stderr, "enable-audio", "Enable audio streaming", cfg.EnableAudio)
+		printFlag(os.Stderr, "audio-bitrate", "Audio bitrate (e.g. 64k, 128k)", cfg.AudioBitrate)
+		printFlag(os.Stderr, "hdpi", "Set high DPI scaling percentage (e.g., 150, 200)", cfg.HDPI)
+		printFlag(os.Stderr, "dpi", "Set the X11 and font DPI directly (e.g., 144); overrides --hdpi", cfg.DPI)
+		printFlag(os.Stderr, "auto-max-bandwidth", "Upper bandwidth limit in Mbps for auto quality mode", cfg.AutoMaxBandwidth)
+		printFlag(os.Stderr, "bandwidth-probe", "Measure client throughput on connect to pick the initial bandwidth", cfg.EnableBandwidthProbe)
+		printFlag(os.Stderr, "idle-lock-minutes", "Lock the session after this many minutes without input (0 to disable)", cfg.IdleLockMinutes)
+		printFlag(os.Stderr, "lock-password", "Password required to unlock a locked session", "")
+		printFlag(os.Stderr, "encoder-watchdog", "Restart the encoder after this many seconds without frames (0 to disable)", cfg.EncoderWatchdogSeconds)
+		printFlag(os.Stderr, "ffmpeg-log-kb", "KB of stderr kept per encoder run for the control API's GetEncoderLog (0 to keep none)", cfg.FFmpegLogKB)
+		printFlag(os.Stderr, "log-file", "Write the log to this file instead of stdout, rotating it by size", cfg.LogFile)
+		printFlag(os.Stderr, "log-max-size", "Rotate the log file when it reaches this many MB (0 to never rotate)", cfg.LogMaxSizeMB)
+		printFlag(os.Stderr, "log-max-files", "Rotated log files to keep", cfg.LogMaxFiles)
+		printFlag(os.Stderr, "hook-url", "URL to POST session lifecycle events to", cfg.HookURL)
+		printFlag(os.Stderr, "hook-script", "Script to execute on session lifecycle events", cfg.HookScript)
+		printFlag(os.Stderr, "state-file", "File that persists encoder settings and screen size across restarts (empty to disable)", cfg.StateFile)
+		printFlag(os.Stderr, "identity-header", "Request header carrying the user name set by an authenticating proxy (enables per-user profiles)", cfg.IdentityHeader)
+		printFlag(os.Stderr, "profiles-file", "File storing each user's preferred encoder settings", cfg.ProfilesFile)
+		printFlag(os.Stderr, "mjpeg-fps", "Framerate of the /mjpeg fallback stream (0 disables the endpoint)", cfg.MJPEGFPS)
+		printFlag(os.Stderr, "enable-hls", "Publish a low-latency HLS/DASH stream under /hls/ (h264/h265 only)", cfg.EnableHLS)
+		printFlag(os.Stderr, "publish-url", "Republish the video to an RTSP server (rtsp://...) or as RTP (rtp://host:port)", cfg.PublishURL)
+		printFlag(os.Stderr, "dvr-seconds", "Keep this many seconds of video for /replay (0 to disable)", cfg.DVRSeconds)
+		printFlag(os.Stderr, "scene-change-threshold", "Force a keyframe when a frame differs from the previous one by this score, 1-100 (0 to disable)", cfg.SceneChangeThreshold)
+		printFlag(os.Stderr, "idle-fps", "Capture rate while the screen is idle (0 to always capture at --fps)", cfg.IdleFPS)
+		printFlag(os.Stderr, "idle-quality", "Encoder quality (10-100) for a sharp refresh once the screen is idle (0 to disable)", cfg.IdleQuality)
+		printFlag(os.Stderr, "client-bandwidth-caps", "Per-viewer bandwidth caps as user=mbps or cidr=mbps pairs, comma-separated", cfg.ClientBandwidthCaps)
+		printFlag(os.Stderr, "thumbnail-fps", "Framerate of the 320px /thumbnail preview stream (0 disables it)", cfg.ThumbnailFPS)
+		printFlag(os.Stderr, "resize-policy", "How viewer resizes set the desktop size: follow, aspect or fixed", cfg.ResizePolicy)
+		printFlag(os.Stderr, "resize-step", "Round viewer-requested desktop sizes down to a multiple of this many pixels (16 matches encoder macroblocks)", cfg.ResizeStep)
+		printFlag(os.Stderr, "resize-aspect", "Aspect ratio kept by --resize-policy=aspect", cfg.ResizeAspect)
+		printFlag(os.Stderr, "resize-modes", "Comma-separated WxH sizes allowed by --resize-policy=fixed", cfg.ResizeModes)
+		printFlag(os.Stderr, "resize-debounce", "Milliseconds to wait for further resize requests before resizing the desktop (0 resizes immediately)", cfg.ResizeDebounce)
+		printFlag(os.Stderr, "data-root", "Directory (usually a volume) holding a persistent home directory per session ID", cfg.DataRoot)
+		printFlag(os.Stderr, "session-id", "Name of this session's directory under --data-root", cfg.SessionID)
+		printFlag(os.Stderr, "webdav-dir", "Session folder to serve over WebDAV at /webdav/, relative to the session home (e.g. Shared)", cfg.WebDAVDir)
+		printFlag(os.Stderr, "webcam-device", "v4l2loopback device that receives a viewer's camera (e.g. /dev/video10)", cfg.WebcamDevice)
+		printFlag(os.Stderr, "enable-gamepad", "Forward viewers' gamepads as uinput joysticks (needs /dev/uinput)", cfg.EnableGamepad)
+		printFlag(os.Stderr, "enable-pen", "Forward stylus pressure and tilt through a uinput tablet (needs /dev/uinput and an X server with input hotplug)", cfg.EnablePen)
+		printFlag(os.Stderr, "scroll-step-pixels", "Pixels of touchpad or wheel scrolling per X wheel click (higher scrolls slower)", cfg.ScrollStepPixels)
+		printFlag(os.Stderr, "touch-gestures", "Translate two-finger pans into scrolling and pinches into Ctrl+wheel zoom", cfg.TouchGestures)
+		printFlag(os.Stderr, "key-repeat-delay", "Milliseconds a key is held before X starts auto-repeating it", cfg.KeyRepeatDelay)
+		printFlag(os.Stderr, "key-repeat-rate", "Auto-repeats per second for a held key", cfg.KeyRepeatRate)
+		printFlag(os.Stderr, "key-repeat-passthrough", "Inject the browser's key repeats and turn X autorepeat off", cfg.KeyRepeatPassthrough)
+		printFlag(os.Stderr, "enable-macros", "Serve the /macro API to record and replay input", cfg.EnableMacros)
+		printFlag(os.Stderr, "grpc-addr", "Address for the gRPC control API, e.g. 127.0.0.1:50051 (empty disables it)", cfg.GRPCAddr)
+		printFlag(os.Stderr, "grpc-token", "Bearer token gRPC control API callers must send", cfg.GRPCToken)
+		printFlag(os.Stderr, "broker", "Run as a session broker that starts a backend llrdc per user and proxies to it", cfg.Broker)
+		printFlag(os.Stderr, "broker-backend", "How the broker runs desktops: local (child processes), docker, podman or kubernetes", cfg.BrokerBackend)
+		printFlag(os.Stderr, "broker-image", "Container image for docker and podman broker backends", cfg.BrokerImage)
+		printFlag(os.Stderr, "broker-port-base", "First port of broker backends (0 means --port + 1)", cfg.BrokerPortBase)
+		printFlag(os.Stderr, "broker-max-sessions", "Maximum number of concurrent broker backends", cfg.BrokerMaxSessions)
+		printFlag(os.Stderr, "broker-idle-minutes", "Stop a broker backend after this many minutes without connections (0 keeps them)", cfg.BrokerIdleMinutes)
+		printFlag(os.Stderr, "broker-engine-socket", "Unix socket of the container engine API for docker and podman broker backends (default: detected)", cfg.BrokerEngineSocket)
+		printFlag(os.Stderr, "broker-volume-prefix", "Give each user of a docker or podman broker backend a named volume with this prefix for their home", cfg.BrokerVolumePrefix)
+		printFlag(os.Stderr, "broker-k8s-template", "JSON Pod manifest the kubernetes broker backend starts desktops from (default: a plain Pod of --broker-image)", cfg.BrokerK8sTemplate)
+		printFlag(os.Stderr, "broker-k8s-namespace", "Namespace of kubernetes broker desktops (default: the broker's own)", cfg.BrokerK8sNamespace)
+		printFlag(os.Stderr, "broker-k8s-api", "Kubernetes API URL without authentication, e.g. from kubectl proxy (default: the in-cluster API with the service account)", cfg.BrokerK8sAPI)
+		printFlag(os.Stderr, "enable-share-links", "Serve the /share API for expiring, revocable guest links (needs --identity-header)", cfg.EnableShareLinks)
+		printFlag(os.Stderr, "share-max-minutes", "Longest lifetime of a share link in minutes (0 for no limit)", cfg.ShareMaxMinutes)
+		printFlag(os.Stderr, "allow-ips", "Comma-separated IP addresses and CIDR ranges allowed to connect (empty allows all)", cfg.AllowIPs)
+		printFlag(os.Stderr, "deny-ips", "Comma-separated IP addresses and CIDR ranges refused a connection", cfg.DenyIPs)
+		printFlag(os.Stderr, "geoip-db", "MaxMind-format country database for --allow-countries and --deny-countries", cfg.GeoIPDB)
+		printFlag(os.Stderr, "allow-countries", "Comma-separated ISO country codes allowed to connect (needs --geoip-db)", cfg.AllowCountries)
+		printFlag(os.Stderr, "deny-countries", "Comma-separated ISO country codes refused a connection (needs --geoip-db)", cfg.DenyCountries)
+		printFlag(os.Stderr, "trusted-proxies", "Comma-separated proxy IPs/CIDRs whose X-Forwarded-For gives the client address for filtering", cfg.TrustedProxies)
+		printFlag(os.Stderr, "tls-cert", "PEM certificate (chain) for serving HTTPS", cfg.TLSCert)
+		printFlag(os.Stderr, "tls-key", "PEM private key for --tls-cert", cfg.TLSKey)
+		printFlag(os.Stderr, "tls-client-ca", "PEM CA bundle; require client certificates issued by it (mutual TLS)", cfg.TLSClientCA)
+		printFlag(os.Stderr, "http3-port", "UDP port for an experimental HTTP/3 listener (needs --tls-cert; 0 disables it)", cfg.HTTP3Port)
+		printFlag(os.Stderr, "webtransport", "Offer WebTransport on the HTTP/3 listener as an alternative to the WebSocket (experimental)", cfg.EnableWebTransport)
+		printFlag(os.Stderr, "enable-sse-signaling", "Offer Server-Sent Events and POST signaling for networks that block WebSockets", cfg.EnableSSESignaling)
+		printFlag(os.Stderr, "enable-whep", "Serve the stream to WHEP players at /whep", cfg.EnableWHEP)
+		printFlag(os.Stderr, "whep-token", "Bearer token WHEP players must send", cfg.WHEPToken)
+		printFlag(os.Stderr, "vnc-addr", "Address for the VNC (RFB) bridge, e.g. 127.0.0.1:5900 (empty disables it)", cfg.VNCAddr)
+		printFlag(os.Stderr, "vnc-password", "Password VNC clients must give (VNC authentication, at most 8 characters)", cfg.VNCPassword)
+		printFlag(os.Stderr, "reverse-ssh", "Dial out to this SSH relay (user@host[:port]) and serve through a reverse tunnel (empty disables it)", cfg.ReverseSSH)
+		printFlag(os.Stderr, "reverse-ssh-key", "Private key for the SSH relay (default ~/.ssh/id_ed25519)", cfg.ReverseSSHKey)
+		printFlag(os.Stderr, "reverse-ssh-known-hosts", "known_hosts file to verify the SSH relay with (default ~/.ssh/known_hosts)", cfg.ReverseSSHKnownHosts)
+		printFlag(os.Stderr, "reverse-ssh-remote", "Address the SSH relay listens on for this server (default localhost:<port>)", cfg.ReverseSSHRemote)
+		printFlag(os.Stderr, "mdns", "Advertise this server on the LAN over mDNS as _llrdc._tcp", cfg.EnableMDNS)
+		printFlag(os.Stderr, "mdns-name", "Name advertised over mDNS (default \"llrdc on <hostname>\")", cfg.MDNSName)
+		printFlag(os.Stderr, "port-mapping", "Ask the router to forward the HTTP and WebRTC ports (NAT-PMP or UPnP)", cfg.EnablePortMapping)
+		printFlag(os.Stderr, "backpressure-cap", "Cap the video of WebSocket viewers that fall behind to the rate they drain", cfg.BackpressureCap)
+		printFlag(os.Stderr, "ws-window-kb", "KB of fragmented WebSocket video a viewer may leave unacknowledged (0 for no limit)", cfg.WSWindowKB)
+		printFlag(os.Stderr, "ws-compression", "Compress JSON messages on the WebSocket with permessage-deflate (video is never compressed)", cfg.WSCompression)
+		printFlag(os.Stderr, "max-buffered-mb", "Budget in MB for video waiting to be sent or kept for replay (0 for none)", cfg.MaxBufferedMB)
+		printFlag(os.Stderr, "stun-servers", "Comma-separated STUN servers for WebRTC (host:port or stun: URLs), or none", cfg.STUNServers)
+		printFlag(os.Stderr, "lan-only", "Use no STUN servers and advertise only host candidates, for isolated networks", cfg.LANOnly)
+		printFlag(os.Stderr, "gpu-scale", "GPU scaling and color conversion for software encoders: auto, vaapi, libplacebo or off", cfg.GPUScale)
+		printFlag(os.Stderr, "kmsgrab-device", "DRM device for --capture-source kmsgrab (empty for ffmpeg's default, /dev/dri/card0)", cfg.KMSGrabDevice)
+		printFlag(os.Stderr, "seamless-windows", "Offer top-level windows as separate WebRTC video tracks to viewers that ask for them", cfg.SeamlessWindows)
+		printFlag(os.Stderr, "audio-device", "PulseAudio source or sink to stream, or app:<name> for one application (default: the default source)", cfg.AudioDevice)
+		printFlag(os.Stderr, "audio-frame-duration", "Opus frame duration in ms (2.5, 5, 10 or 20)", cfg.AudioFrameDuration)
+		printFlag(os.Stderr, "audio-fec", "Enable Opus in-band forward error correction", cfg.AudioFEC)
+		printFlag(os.Stderr, "audio-dtx", "Enable Opus discontinuous transmission (no packets during silence)", cfg.AudioDTX)
+		printFlag(os.Stderr, "chat-log", "File to append chat messages to and reload the history from (empty to keep chat in memory only)", cfg.ChatLog)
+		printFlag(os.Stderr, "url-handoff", "Send web links opened in the session to viewers that ask for them", cfg.URLHandoff)
+		printFlag(os.Stderr, "session-manifest", "JSON file listing apps to launch at session start and where to place their windows", cfg.SessionManifest)
+		printFlag(os.Stderr, "benchmark", "Benchmark encoder settings on this host, write a recommended config and exit", cfg.Benchmark)
+		printFlag(os.Stderr, "benchmark-output", "File the benchmark writes its recommended config to", cfg.BenchmarkOutput)
+		printFlag(os.Stderr, "doctor", "Check dependencies, ports and STUN connectivity, print a report and exit", cfg.Doctor)
+		printFlag(os.Stderr, "loadtest", "Connect this many headless WebRTC clients to a running server, report per-client bitrate and latency, and exit", cfg.LoadTest)
+		printFlag(os.Stderr, "loadtest-url", "WebSocket URL of the server to load test (default ws://127.0.0.1:<port>/)", cfg.LoadTestURL)
+		printFlag(os.Stderr, "loadtest-duration", "Seconds each load test client stays connected", cfg.LoadTestDuration)
+		printFlag(os.Stderr, "loadtest-input", "Make load test clients send pointer input", cfg.LoadTestInput)
+
+		fmt.Fprintf(os.Stderr, "\nTesting Flags:\n")
+		printFlag(os.Stderr, "test-pattern", "Run with test pattern instead of X11", cfg.TestPattern)
+		printFlag(os.Stderr, "test-minimal-x11", "Start minimal X11 without full DE", cfg.TestMinimalX11)
+	}
+
+	// Define flags
+	flag.IntVar(&cfg.Port, "port", cfg.Port, "Port for HTTP and WebRTC UDP")
+	flag.StringVar(&cfg.HTTPAddr, "http-addr", cfg.HTTPAddr, "Address the HTTP server listens on (empty for all interfaces)")
+	flag.IntVar(&cfg.FPS, "fps", cfg.FPS, "Target framerate")
+	flag.StringVar(&cfg.VideoCodec, "video-codec", cfg.VideoCodec, "Video codec (vp8, h264, h264_nvenc, h265, h265_nvenc, av1, av1_nvenc)")
+	flag.StringVar(&cfg.Chroma, "chroma", cfg.Chroma, "Chroma subsampling format (420 or 444)")
+	flag.StringVar(&cfg.CaptureSource, "capture-source", cfg.CaptureSource, "Capture source (x11grab, kmsgrab, pipewire, testpattern or latency; empty picks from --test-pattern)")
+	flag.IntVar(&cfg.CpuEffort, "cpu-effort", cfg.CpuEffort, "VP8 cpu-used speed setting (0-8, higher is faster; -1 tunes it to the host)")
+	flag.IntVar(&cfg.CpuThreads, "cpu-threads", cfg.CpuThreads, "VP8 and AV1 encoder threads (0 picks them for the host)")
+	flag.BoolVar(&cfg.UseGPU, "use-gpu", cfg.UseGPU, "Enable GPU acceleration if available")
+	flag.BoolVar(&cfg.UseDebugX11, "use-debug-x11", cfg.UseDebugX11, "Enable X11 debugging")
+	flag.BoolVar(&cfg.UseDebugFFmpeg, "use-debug-ffmpeg", cfg.UseDebugFFmpeg, "Enable FFmpeg debugging")
+	flag.StringVar(&cfg.DisplayNum, "display-num", cfg.DisplayNum, "X11 Display number (e.g., 99 for :99)")
+	flag.BoolVar(&cfg.TestPattern, "test-pattern", cfg.TestPattern, "Run with test pattern instead of X11")
+	flag.BoolVar(&cfg.TestMinimalX11, "test-minimal-x11", cfg.TestMinimalX11, "Start minimal X11 without full DE")
+	flag.StringVar(&cfg.Wallpaper, "wallpaper", cfg.Wallpaper, "Path to wallpaper image")
+	flag.IntVar(&cfg.SessionUID, "session-uid", cfg.SessionUID, "Run the desktop session as this UID (-1 to inherit the server's identity)")
+	flag.IntVar(&cfg.SessionGID, "session-gid", cfg.SessionGID, "GID for the desktop session (defaults to --session-uid)")
+	flag.StringVar(&cfg.SessionHome, "session-home", cfg.SessionHome, "Managed home directory for the session user")
+	flag.Float64Var(&cfg.AppCPULimit, "app-cpu-limit", cfg.AppCPULimit, "CPU cap for each spawned app in cores (0 for unlimited)")
+	flag.IntVar(&cfg.AppMemoryLimitMB, "app-memory-limit", cfg.AppMemoryLimitMB, "Memory cap for each spawned app in MB (0 for unlimited)")
+	flag.StringVar(&cfg.KioskCommand, "kiosk-command", cfg.KioskCommand, "Run a single fullscreen application instead of a full desktop")
+	flag.StringVar(&cfg.KioskWM, "kiosk-wm", cfg.KioskWM, "Window manager used in kiosk mode (empty for none)")
+	flag.StringVar(&cfg.DesktopSession, "desktop-session", cfg.DesktopSession, "Desktop session (xfce, openbox, i3, lxqt, mate, a window manager command, or a startup script path)")
+	flag.StringVar(&cfg.WebRTCPublicIP, "webrtc-public-ip", cfg.WebRTCPublicIP, "Public IP for WebRTC")
+	flag.StringVar(&cfg.WebRTCInterfaces, "webrtc-interfaces", cfg.WebRTCInterfaces, "Comma-separated allowed network interfaces for WebRTC")
+	flag.StringVar(&cfg.WebRTCExcludeInterfaces, "webrtc-exclude-interfaces", cfg.WebRTCExcludeInterfaces, "Comma-separated excluded network interfaces for WebRTC")
+	flag.BoolVar(&cfg.EnableClipboard, "enable-clipboard", cfg.EnableClipboard, "Enable clipboard synchronization")
+	flag.BoolVar(&cfg.EnableNotifications, "enable-notifications", cfg.EnableNotifications, "Forward desktop notifications to clients")
+	flag.BoolVar(&cfg.EnableAudio, "enable-audio", cfg.EnableAudio, "Enable audio streaming")
+	flag.StringVar(&cfg.AudioBitrate, "audio-bitrate", cfg.AudioBitrate, "Audio bitrate (e.g. 64k, 128k)")
+	flag.BoolVar(&cfg.EnableHybrid, "enable-hybrid", cfg.EnableHybrid, "Enable RDP-style hybrid sharpness patches")
+	flag.IntVar(&cfg.TileSize, "tile-size", cfg.TileSize, "Tile size for hybrid patches (64-1024)")
+	flag.IntVar(&cfg.HDPI, "hdpi", cfg.HDPI, "Set high DPI scaling percentage (e.g., 150, 200)")
+	flag.IntVar(&cfg.DPI, "dpi", cfg.DPI, "Set the X11 and font DPI directly (e.g., 144); overrides --hdpi")
+	flag.IntVar(&cfg.AutoMaxBandwidth, "auto-max-bandwidth", cfg.AutoMaxBandwidth, "Upper bandwidth limit in Mbps for auto quality mode")
+	flag.BoolVar(&cfg.EnableBandwidthProbe, "bandwidth-probe", cfg.EnableBandwidthProbe, "Measure client throughput on connect to pick the initial bandwidth")
+	flag.IntVar(&cfg.IdleLockMinutes, "idle-lock-minutes", cfg.IdleLockMinutes, "Lock the session after this many minutes without input (0 to disable)")
+	flag.StringVar(&cfg.LockPassword, "lock-password", cfg.LockPassword, "Password required to unlock a locked session")
+	flag.IntVar(&cfg.EncoderWatchdogSeconds, "encoder-watchdog", cfg.EncoderWatchdogSeconds, "Restart the encoder after this many seconds without frames (0 to disable)")
+	flag.IntVar(&cfg.FFmpegLogKB, "ffmpeg-log-kb", cfg.FFmpegLogKB, "KB of stderr kept per encoder run for the control API's GetEncoderLog (0 to keep none)")
+	flag.StringVar(&cfg.LogFile, "log-file", cfg.LogFile, "Write the log to this file instead of stdout, rotating it by size")
+	flag.IntVar(&cfg.LogMaxSizeMB, "log-max-size", cfg.LogMaxSizeMB, "Rotate the log file when it reaches this many MB (0 to never rotate)")
+	flag.IntVar(&cfg.LogMaxFiles, "log-max-files", cfg.LogMaxFiles, "Rotated log files to keep")
+	flag.StringVar(&cfg.HookURL, "hook-url", cfg.HookURL, "URL to POST session lifecycle events to")
+	flag.StringVar(&cfg.HookScript, "hook-script", cfg.HookScript, "Script to execute on session lifecycle events")
+	flag.StringVar(&cfg.StateFile, "state-file", cfg.StateFile, "File that persists encoder settings and screen size across restarts (empty to disable)")
+	flag.StringVar(&cfg.IdentityHeader, "identity-header", cfg.IdentityHeader, "Request header carrying the user name set by an authenticating proxy (enables per-user profiles)")
+	flag.StringVar(&cfg.ProfilesFile, "profiles-file", cfg.ProfilesFile, "File storing each user's preferred encoder settings")
+	flag.IntVar(&cfg.MJPEGFPS, "mjpeg-fps", cfg.MJPEGFPS, "Framerate of the /mjpeg fallback stream (0 disables the endpoint)")
+	flag.BoolVar(&cfg.EnableHLS, "enable-hls", cfg.EnableHLS, "Publish a low-latency HLS/DASH stream under /hls/ (h264/h265 only)")
+	flag.StringVar(&cfg.PublishURL, "publish-url", cfg.PublishURL, "Republish the video to an RTSP server (rtsp://...) or as RTP (rtp://host:port)")
+	flag.IntVar(&cfg.DVRSeconds, "dvr-seconds", cfg.DVRSeconds, "Keep this many seconds of video for /replay (0 to disable)")
+	flag.IntVar(&cfg.SceneChangeThreshold, "scene-change-threshold", cfg.SceneChangeThreshold, "Force a keyframe when a frame differs from the previous one by this score, 1-100 (0 to disable)")
+	flag.IntVar(&cfg.IdleFPS, "idle-fps", cfg.IdleFPS, "Capture rate while the screen is idle (0 to always capture at --fps)")
+	flag.IntVar(&cfg.IdleQuality, "idle-quality", cfg.IdleQuality, "Encoder quality (10-100) for a sharp refresh once the screen is idle (0 to disable)")
+	flag.StringVar(&cfg.ClientBandwidthCaps, "client-bandwidth-caps", cfg.ClientBandwidthCaps, "Per-viewer bandwidth caps as user=mbps or cidr=mbps pairs, comma-separated")
+	flag.IntVar(&cfg.ThumbnailFPS, "thumbnail-fps", cfg.ThumbnailFPS, "Framerate of the 320px /thumbnail preview stream (0 disables it)")
+	flag.StringVar(&cfg.ResizePolicy, "resize-policy", cfg.ResizePolicy, "How viewer resizes set the desktop size: follow, aspect or fixed")
+	flag.IntVar(&cfg.ResizeStep, "resize-step", cfg.ResizeStep, "Round viewer-requested desktop sizes down to a multiple of this many pixels (16 matches encoder macroblocks)")
+	flag.StringVar(&cfg.ResizeAspect, "resize-aspect", cfg.ResizeAspect, "Aspect ratio kept by --resize-policy=aspect")
+	flag.StringVar(&cfg.ResizeModes, "resize-modes", cfg.ResizeModes, "Comma-separated WxH sizes allowed by --resize-policy=fixed")
+	flag.IntVar(&cfg.ResizeDebounce, "resize-debounce", cfg.ResizeDebounce, "Milliseconds to wait for further resize requests before resizing the desktop (0 resizes immediately)")
+	flag.StringVar(&cfg.DataRoot, "data-root", cfg.DataRoot, "Directory (usually a volume) holding a persistent home directory per session ID")
+	flag.StringVar(&cfg.SessionID, "session-id", cfg.SessionID, "Name of this session's directory under --data-root")
+	flag.StringVar(&cfg.WebDAVDir, "webdav-dir", cfg.WebDAVDir, "Session folder to serve over WebDAV at /webdav/, relative to the session home (e.g. Shared)")
+	flag.StringVar(&cfg.WebcamDevice, "webcam-device", cfg.WebcamDevice, "v4l2loopback device that receives a viewer's camera (e.g. /dev/video10)")
+	flag.BoolVar(&cfg.EnableGamepad, "enable-gamepad", cfg.EnableGamepad, "Forward viewers' gamepads as uinput joysticks (needs /dev/uinput)")
+	flag.BoolVar(&cfg.EnablePen, "enable-pen", cfg.EnablePen, "Forward stylus pressure and tilt through a uinput tablet (needs /dev/uinput and an X server with input hotplug)")
+	flag.IntVar(&cfg.ScrollStepPixels, "scroll-step-pixels", cfg.ScrollStepPixels, "Pixels of touchpad or wheel scrolling per X wheel click (higher scrolls slower)")
+	flag.BoolVar(&cfg.TouchGestures, "touch-gestures", cfg.TouchGestures, "Translate two-finger pans into scrolling and pinches into Ctrl+wheel zoom")
+	flag.IntVar(&cfg.KeyRepeatDelay, "key-repeat-delay", cfg.KeyRepeatDelay, "Milliseconds a key is held before X starts auto-repeating it")
+	flag.IntVar(&cfg.KeyRepeatRate, "key-repeat-rate", cfg.KeyRepeatRate, "Auto-repeats per second for a held key")
+	flag.BoolVar(&cfg.KeyRepeatPassthrough, "key-repeat-passthrough", cfg.KeyRepeatPassthrough, "Inject the browser's key repeats and turn X autorepeat off")
+	flag.BoolVar(&cfg.EnableMacros, "enable-macros", cfg.EnableMacros, "Serve the /macro API to record and replay input")
+	flag.StringVar(&cfg.GRPCAddr, "grpc-addr", cfg.GRPCAddr, "Address for the gRPC control API, e.g. 127.0.0.1:50051 (empty disables it)")
+	flag.StringVar(&cfg.GRPCToken, "grpc-token", cfg.GRPCToken, "Bearer token gRPC control API callers must send")
+	flag.BoolVar(&cfg.Broker, "broker", cfg.Broker, "Run as a session broker that starts a backend llrdc per user and proxies to it")
+	flag.StringVar(&cfg.BrokerBackend, "broker-backend", cfg.BrokerBackend, "How the broker runs desktops: local (child processes), docker, podman or kubernetes")
+	flag.StringVar(&cfg.BrokerImage, "broker-image", cfg.BrokerImage, "Container image for docker and podman broker backends")
+	flag.IntVar(&cfg.BrokerPortBase, "broker-port-base", cfg.BrokerPortBase, "First port of broker backends (0 means --port + 1)")
+	flag.IntVar(&cfg.BrokerMaxSessions, "broker-max-sessions", cfg.BrokerMaxSessions, "Maximum number of concurrent broker backends")
+	flag.IntVar(&cfg.BrokerIdleMinutes, "broker-idle-minutes", cfg.BrokerIdleMinutes, "Stop a broker backend after this many minutes without connections (0 keeps them)")
+	flag.StringVar(&cfg.BrokerEngineSocket, "broker-engine-socket", cfg.BrokerEngineSocket, "Unix socket of the container engine API for docker and podman broker backends (default: detected)")
+	flag.StringVar(&cfg.BrokerVolumePrefix, "broker-volume-prefix", cfg.BrokerVolumePrefix, "Give each user of a docker or podman broker backend a named volume with this prefix for their home")
+	flag.StringVar(&cfg.BrokerK8sTemplate, "broker-k8s-template", cfg.BrokerK8sTemplate, "JSON Pod manifest the kubernetes broker backend starts desktops from (default: a plain Pod of --broker-image)")
+	flag.StringVar(&cfg.BrokerK8sNamespace, "broker-k8s-namespace", cfg.BrokerK8sNamespace, "Namespace of kubernetes broker desktops (default: the broker's own)")
+	flag.StringVar(&cfg.BrokerK8sAPI, "broker-k8s-api", cfg.BrokerK8sAPI, "Kubernetes API URL without authentication, e.g. from kubectl proxy (default: the in-cluster API with the service account)")
+	flag.BoolVar(&cfg.EnableShareLinks, "enable-share-links", cfg.EnableShareLinks, "Serve the /share API for expiring, revocable guest links (needs --identity-header)")
+	flag.IntVar(&cfg.ShareMaxMinutes, "share-max-minutes", cfg.ShareMaxMinutes, "Longest lifetime of a share link in minutes (0 for no limit)")
+	flag.StringVar(&cfg.AllowIPs, "allow-ips", cfg.AllowIPs, "Comma-separated IP addresses and CIDR ranges allowed to connect (empty allows all)")
+	flag.StringVar(&cfg.DenyIPs, "deny-ips", cfg.DenyIPs, "Comma-separated IP addresses and CIDR ranges refused a connection")
+	flag.StringVar(&cfg.GeoIPDB, "geoip-db", cfg.GeoIPDB, "MaxMind-format country database for --allow-countries and --deny-countries")
+	flag.StringVar(&cfg.AllowCountries, "allow-countries", cfg.AllowCountries, "Comma-separated ISO country codes allowed to connect (needs --geoip-db)")
+	flag.StringVar(&cfg.DenyCountries, "deny-countries", cfg.DenyCountries, "Comma-separated ISO country codes refused a connection (needs --geoip-db)")
+	flag.StringVar(&cfg.TrustedProxies, "trusted-proxies", cfg.TrustedProxies, "Comma-separated proxy IPs/CIDRs whose X-Forwarded-For gives the client address for filtering")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "PEM certificate (chain) for serving HTTPS")
+	flag.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "PEM private key for --tls-cert")
+	flag.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, "PEM CA bundle; require client certificates issued by it (mutual TLS)")
+	flag.IntVar(&cfg.HTTP3Port, "http3-port", cfg.HTTP3Port, "UDP port for an experimental HTTP/3 listener (needs --tls-cert; 0 disables it)")
+	flag.BoolVar(&cfg.EnableWebTransport, "webtransport", cfg.EnableWebTransport, "Offer WebTransport on the HTTP/3 listener as an alternative to the WebSocket (experimental)")
+	flag.BoolVar(&cfg.EnableSSESignaling, "enable-sse-signaling", cfg.EnableSSESignaling, "Offer Server-Sent Events and POST signaling for networks that block WebSockets")
+	flag.BoolVar(&cfg.EnableWHEP, "enable-whep", cfg.EnableWHEP, "Serve the stream to WHEP players at /whep")
+	flag.StringVar(&cfg.WHEPToken, "whep-token", cfg.WHEPToken, "Bearer token WHEP players must send")
+	flag.StringVar(&cfg.VNCAddr, "vnc-addr", cfg.VNCAddr, "Address for the VNC (RFB) bridge, e.g. 127.0.0.1:5900 (empty disables it)")
+	flag.StringVar(&cfg.VNCPassword, "vnc-password", cfg.VNCPassword, "Password VNC clients must give (VNC authentication, at most 8 characters)")
+	flag.StringVar(&cfg.ReverseSSH, "reverse-ssh", cfg.ReverseSSH, "Dial out to this SSH relay (user@host[:port]) and serve through a reverse tunnel (empty disables it)")
+	flag.StringVar(&cfg.ReverseSSHKey, "reverse-ssh-key", cfg.ReverseSSHKey, "Private key for the SSH relay (default ~/.ssh/id_ed25519)")
+	flag.StringVar(&cfg.ReverseSSHKnownHosts, "reverse-ssh-known-hosts", cfg.ReverseSSHKnownHosts, "known_hosts file to verify the SSH relay with (default ~/.ssh/known_hosts)")
+	flag.StringVar(&cfg.ReverseSSHRemote, "reverse-ssh-remote", cfg.ReverseSSHRemote, "Address the SSH relay listens on for this server (default localhost:<port>)")
+	flag.BoolVar(&cfg.EnableMDNS, "mdns", cfg.EnableMDNS, "Advertise this server on the LAN over mDNS as _llrdc._tcp")
+	flag.StringVar(&cfg.MDNSName, "mdns-name", cfg.MDNSName, "Name advertised over mDNS (default \"llrdc on <hostname>\")")
+	flag.BoolVar(&cfg.EnablePortMapping, "port-mapping", cfg.EnablePortMapping, "Ask the router to forward the HTTP and WebRTC ports (NAT-PMP or UPnP)")
+	flag.BoolVar(&cfg.BackpressureCap, "backpressure-cap", cfg.BackpressureCap, "Cap the video of WebSocket viewers that fall behind to the rate they drain")
+	flag.IntVar(&cfg.WSWindowKB, "ws-window-kb", cfg.WSWindowKB, "KB of fragmented WebSocket video a viewer may leave unacknowledged (0 for no limit)")
+	flag.BoolVar(&cfg.WSCompression, "ws-compression", cfg.WSCompression, "Compress JSON messages on the WebSocket with permessage-deflate (video is never compressed)")
+	flag.IntVar(&cfg.MaxBufferedMB, "max-buffered-mb", cfg.MaxBufferedMB, "Budget in MB for video waiting to be sent or kept for replay (0 for none)")
+	flag.StringVar(&cfg.STUNServers, "stun-servers", cfg.STUNServers, "Comma-separated STUN servers for WebRTC (host:port or stun: URLs), or none")
+	flag.BoolVar(&cfg.LANOnly, "lan-only", cfg.LANOnly, "Use no STUN servers and advertise only host candidates, for isolated networks")
+	flag.StringVar(&cfg.GPUScale, "gpu-scale", cfg.GPUScale, "GPU scaling and color conversion for software encoders: auto, vaapi, libplacebo or off")
+	flag.StringVar(&cfg.KMSGrabDevice, "kmsgrab-device", cfg.KMSGrabDevice, "DRM device for --capture-source kmsgrab (empty for ffmpeg's default, /dev/dri/card0)")
+	flag.BoolVar(&cfg.SeamlessWindows, "seamless-windows", cfg.SeamlessWindows, "Offer top-level windows as separate WebRTC video tracks to viewers that ask for them")
+	flag.StringVar(&cfg.AudioDevice, "audio-device", cfg.AudioDevice, "PulseAudio source or sink to stream, or app:<name> for one application (default: the default source)")
+	flag.Float64Var(&cfg.AudioFrameDuration, "audio-frame-duration", cfg.AudioFrameDuration, "Opus frame duration in ms (2.5, 5, 10 or 20)")
+	flag.BoolVar(&cfg.AudioFEC, "audio-fec", cfg.AudioFEC, "Enable Opus in-band forward error correction")
+	flag.BoolVar(&cfg.AudioDTX, "audio-dtx", cfg.AudioDTX, "Enable Opus discontinuous transmission (no packets during silence)")
+	flag.StringVar(&cfg.ChatLog, "chat-log", cfg.ChatLog, "File to append chat messages to and reload the history from (empty to keep chat in memory only)")
+	flag.BoolVar(&cfg.URLHandoff, "url-handoff", cfg.URLHandoff, "Send web links opened in the session to viewers that ask for them")
+	flag.StringVar(&cfg.SessionManifest, "session-manifest", cfg.SessionManifest, "JSON file listing apps to launch at session start and where to place their windows")
+	flag.BoolVar(&cfg.Benchmark, "benchmark", cfg.Benchmark, "Benchmark encoder settings on this host, write a recommended config and exit")
+	flag.StringVar(&cfg.BenchmarkOutput, "benchmark-output", cfg.BenchmarkOutput, "File the benchmark writes its recommended config to")
+	flag.BoolVar(&cfg.Doctor, "doctor", cfg.Doctor, "Check dependencies, ports and STUN connectivity, print a report and exit")
+	flag.IntVar(&cfg.LoadTest, "loadtest", cfg.LoadTest, "Connect this many headless WebRTC clients to a running server, report per-client bitrate and latency, and exit")
+	flag.StringVar(&cfg.LoadTestURL, "loadtest-url", cfg.LoadTestURL, "WebSocket URL of the server to load test (default ws://127.0.0.1:<port>/)")
+	flag.IntVar(&cfg.LoadTestDuration, "loadtest-duration", cfg.LoadTestDuration, "Seconds each load test client stays connected")
+	flag.BoolVar(&cfg.LoadTestInput, "loadtest-input", cfg.LoadTestInput, "Make load test clients send pointer input")
+
+	flag.Parse()
+	return cfg
+}
+
+// applyConfig copies cfg into the package settings and probes the GPU.
+func applyConfig(cfg Config) {
+	Port = cfg.Port
+	HTTPAddr = cfg.HTTPAddr
+	FPS = cfg.FPS
+	VideoCodec = cfg.VideoCodec
+	Chroma = cfg.Chroma
+	CaptureSourceName = cfg.CaptureSource
+	targetCpuEffort = cfg.CpuEffort
+	targetCpuThreads = cfg.CpuThreads
+	UseGPU = cfg.UseGPU
+	UseDebugX11 = cfg.UseDebugX11
+	UseDebugFFmpeg = cfg.UseDebugFFmpeg
+	DisplayNum = cfg.DisplayNum
+	TestPattern = cfg.TestPattern
+	TestMinimalX11 = cfg.TestMinimalX11
+	Wallpaper = cfg.Wallpaper
+	SessionUID = cfg.SessionUID
+	SessionGID = cfg.SessionGID
+	SessionHome = cfg.SessionHome
+	AppCPULimit = cfg.AppCPULimit
+	AppMemoryLimitMB = cfg.AppMemoryLimitMB
+	KioskCommand = cfg.KioskCommand
+	KioskWM = cfg.KioskWM
+	DesktopSession = cfg.DesktopSession
+	WebRTCPublicIP = cfg.WebRTCPublicIP
+	WebRTCInterfaces = cfg.WebRTCInterfaces
+	WebRTCExcludeInterfaces = cfg.WebRTCExcludeInterfaces
+	EnableClipboard = cfg.EnableClipboard
+	EnableNotifications = cfg.EnableNotifications
+	EnableAudio = cfg.EnableAudio
+	AudioBitrate = cfg.AudioBitrate
+	EnableHybrid = cfg.EnableHybrid
+	TileSize = cfg.TileSize
+	HDPI = cfg.HDPI
+	DPI = 0
+	if cfg.DPI > 0 {
+		DPI = min(max(cfg.DPI, minDPI), maxDPI)
+	}
+	AutoMaxBandwidth = cfg.AutoMaxBandwidth
+	EnableBandwidthProbe = cfg.EnableBandwidthProbe
+	IdleLockMinutes = cfg.IdleLockMinutes
+	LockPassword = cfg.LockPassword
+	EncoderWatchdogSeconds = cfg.EncoderWatchdogSeconds
+	FFmpegLogKB = cfg.FFmpegLogKB
+	LogFile = cfg.LogFile
+	LogMaxSizeMB = cfg.LogMaxSizeMB
+	LogMaxFiles = cfg.LogMaxFiles
+	HookURL = cfg.HookURL
+	HookScript = cfg.HookScript
+	StateFile = cfg.StateFile
+	IdentityHeader = cfg.IdentityHeader
+	ProfilesFile = cfg.ProfilesFile
+	MJPEGFPS = cfg.MJPEGFPS
+	EnableHLS = cfg.EnableHLS
+	PublishURL = cfg.PublishURL
+	DVRSeconds = cfg.DVRSeconds
+	SceneChangeThreshold = min(max(cfg.SceneChangeThreshold, 0), 100)
+	IdleFPS = cfg.IdleFPS
+	ClientBandwidthCaps = cfg.ClientBandwidthCaps
+	ThumbnailFPS = cfg.ThumbnailFPS
+	IdleQuality = cfg.IdleQuality
+	if IdleQuality > 0 {
+		IdleQuality = min(max(IdleQuality, 10), 100)
+	}
+	ResizePolicy = cfg.ResizePolicy
+	ResizeStep = max(cfg.ResizeStep, 2)
+	ResizeAspect = cfg.ResizeAspect
+	ResizeModes = cfg.ResizeModes
+	ResizeDebounce = cfg.ResizeDebounce
+	DataRoot = cfg.DataRoot
+	SessionID = cfg.SessionID
+	WebDAVDir = cfg.WebDAVDir
+	WebcamDevice = cfg.WebcamDevice
+	EnableGamepad = cfg.EnableGamepad
+	EnablePen = cfg.EnablePen
+	ScrollStepPixels = max(cfg.ScrollStepPixels, 1)
+	TouchGestures = cfg.TouchGestures
+	KeyRepeatDelay = max(cfg.KeyRepeatDelay, 1)
+	KeyRepeatRate = max(cfg.KeyRepeatRate, 1)
+	KeyRepeatPassthrough = cfg.KeyRepeatPassthrough
+	EnableMacros = cfg.EnableMacros
+	GRPCAddr = cfg.GRPCAddr
+	GRPCToken = cfg.GRPCToken
+	Broker = cfg.Broker
+	BrokerBackend = cfg.BrokerBackend
+	BrokerImage = cfg.BrokerImage
+	BrokerPortBase = cfg.BrokerPortBase
+	BrokerMaxSessions = cfg.BrokerMaxSessions
+	BrokerIdleMinutes = cfg.BrokerIdleMinutes
+	BrokerEngineSocket = cfg.BrokerEngineSocket
+	BrokerVolumePrefix = cfg.BrokerVolumePrefix
+	BrokerK8sTemplate = cfg.BrokerK8sTemplate
+	BrokerK8sNamespace = cfg.BrokerK8sNamespace
+	BrokerK8sAPI = cfg.BrokerK8sAPI
+	EnableShareLinks = cfg.EnableShareLinks
+	ShareMaxMinutes = cfg.ShareMaxMinutes
+	AllowIPs = cfg.AllowIPs
+	DenyIPs = cfg.DenyIPs
+	GeoIPDB = cfg.GeoIPDB
+	AllowCountries = cfg.AllowCountries
+	DenyCountries = cfg.DenyCountries
+	TrustedProxies = cfg.TrustedProxies
+	TLSCert = cfg.TLSCert
+	TLSKey = cfg.TLSKey
+	TLSClientCA = cfg.TLSClientCA
+	HTTP3Port = cfg.HTTP3Port
+	EnableWebTransport = cfg.EnableWebTransport
+	EnableSSESignaling = cfg.EnableSSESignaling
+	EnableWHEP = cfg.EnableWHEP
+	WHEPToken = cfg.WHEPToken
+	VNCAddr = cfg.VNCAddr
+	VNCPassword = cfg.VNCPassword
+	ReverseSSH = cfg.ReverseSSH
+	ReverseSSHKey = cfg.ReverseSSHKey
+	ReverseSSHKnownHosts = cfg.ReverseSSHKnownHosts
+	ReverseSSHRemote = cfg.ReverseSSHRemote
+	EnableMDNS = cfg.EnableMDNS
+	MDNSName = cfg.MDNSName
+	EnablePortMapping = cfg.EnablePortMapping
+	BackpressureCap = cfg.BackpressureCap
+	WSWindowKB = cfg.WSWindowKB
+	WSCompression = cfg.WSCompression
+	MaxBufferedMB = cfg.MaxBufferedMB
+	STUNServers = cfg.STUNServers
+	LANOnly = cfg.LANOnly
+	GPUScale = cfg.GPUScale
+	KMSGrabDevice = cfg.KMSGrabDevice
+	SeamlessWindows = cfg.SeamlessWindows
+	AudioDevice = cfg.AudioDevice
+	AudioFrameDuration = cfg.AudioFrameDuration
+	AudioFEC = cfg.AudioFEC
+	AudioDTX = cfg.AudioDTX
+	ChatLog = cfg.ChatLog
+	URLHandoff = cfg.URLHandoff
+	SessionManifest = cfg.SessionManifest
+	BenchmarkOutput = cfg.BenchmarkOutput
+
+	Display = ":" + DisplayNum
+
+	if UseGPU {
+		log.Printf("Checking NVIDIA GPU capabilities...")
+		
+		// Check basic AV1 support via encoders list
+		outAV1, _ := exec.Command("bash", "-c", "ffmpeg -hide_banner -encoders | grep -q av1_nvenc && echo true || echo false").Output()
+		AV1NVENCAvailable = strings.TrimSpace(string(outAV1)) == "true"
+		
+		if AV1NVENCAvailable {
+			log.Printf("AV1 NVENC support detected")
+			// Note: AV1 NVENC does NOT support 4:4:4 chroma on any current NVIDIA GPU.
+		}
+
+		log.Printf("Checking H.264 NVENC 4:4:4 support...")
+		outH264, _ := exec.Command("bash", "-c", "ffmpeg -y -f lavfi -i testsrc=size=256x256:rate=1 -t 1 -pix_fmt yuv444p -c:v h264_nvenc -profile:v high444p -f null - > /dev/null 2>&1 && echo true || echo false").Output()
+		H264NVENC444Available = strings.TrimSpace(string(outH264)) == "true"
+		if H264NVENC444Available {
+			log.Printf("H.264 NVENC 4:4:4 support detected")
+		} else {
+			log.Printf("H.264 NVENC 4:4:4 support NOT detected")
+		}
+
+		log.Printf("Checking H.265 NVENC 4:4:4 support...")
+		outH265, _ := exec.Command("bash", "-c", "ffmpeg -y -f lavfi -i testsrc=size=256x256:rate=1 -t 1 -pix_fmt yuv444p -c:v hevc_nvenc -profile:v rext -f null - > /dev/null 2>&1 && echo true || echo false").Output()
+		H265NVENC444Available = strings.TrimSpace(string(outH265)) == "true"
+		if H265NVENC444Available {
+			log.Printf("H.265 NVENC 4:4:4 support detected")
+		} else {
+			log.Printf("H.265 NVENC 4:4:4 support NOT detected")
+		}
+	}
+}
+
+func printFlag(w *os.File, name, usage string, def any) {
+	fmt.Fprintf(w, "  -%s\n    \t%s (default %v)\n", name, usage, def)
+}
@@ -0,0 +1,317 @@
+package llrdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/danchitnis/llrdc/pkg/controlpb"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// The control API is a gRPC service on GRPCAddr for fleet-management
+// tools: session and client inspection, encoder settings, stats,
+// screenshots, banners (banner.go) and the encoder's stderr
+// (encoderlog.go), with typed clients generated from pkg/controlpb.
+// Callers authenticate with "authorization: Bearer <GRPCToken>" metadata
+// when a token is set.
+
+const (
+	screenshotTimeout     = 10 * time.Second
+	minWatchStatsInterval = 100 * time.Millisecond
+)
+
+type controlServer struct {
+	controlpb.UnimplementedControlServer
+}
+
+// startControlAPI serves the control API until ctx is cancelled.
+func startControlAPI(ctx context.Context) error {
+	if GRPCAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", GRPCAddr)
+	if err != nil {
+		return err
+	}
+	if GRPCToken == "" {
+		log.Printf("Warning: the gRPC control API on %s has no --grpc-token; anyone who can reach it controls the session", GRPCAddr)
+	}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkControlToken(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkControlToken(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	controlpb.RegisterControlServer(srv, controlServer{})
+
+	goWorker(func() {
+		<-ctx.Done()
+		srv.Stop()
+	})
+	goWorker(func() {
+		log.Printf("gRPC control API listening on %s", ln.Addr())
+		if err := srv.Serve(ln); err != nil {
+			log.Printf("gRPC control API stopped: %v", err)
+		}
+	})
+	return nil
+}
+
+func checkControlToken(ctx context.Context) error {
+	if GRPCToken == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(GRPCToken)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+func sessionInfo() *controlpb.Session {
+	width, height := GetScreenSize()
+	clientsMutex.Lock()
+	count := len(clients)
+	clientsMutex.Unlock()
+	return &controlpb.Session{
+		Display:         Display,
+		Width:           int32(width),
+		Height:          int32(height),
+		Rotation:        GetRotation(),
+		Dpi:             int32(sessionDPI()),
+		Locked:          sessionLocked.Load(),
+		ClientCount:     int32(count),
+		TestPattern:     TestPattern,
+		LastInputUnixMs: time.Unix(0, lastInputTime.Load()).UnixMilli(),
+	}
+}
+
+func (controlServer) GetSession(context.Context, *controlpb.GetSessionRequest) (*controlpb.Session, error) {
+	return sessionInfo(), nil
+}
+
+func (controlServer) SetLocked(_ context.Context, req *controlpb.SetLockedRequest) (*controlpb.Session, error) {
+	if req.Locked {
+		if !idleLockEnabled() {
+			return nil, status.Error(codes.FailedPrecondition, "locking needs --lock-password, so viewers can unlock")
+		}
+		lockSession("control API")
+	} else {
+		unlockSession()
+	}
+	return sessionInfo(), nil
+}
+
+func (controlServer) ListClients(context.Context, *controlpb.ListClientsRequest) (*controlpb.ListClientsResponse, error) {
+	resp := &controlpb.ListClientsResponse{}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for _, c := range clients {
+		capMbps := clientCap(c)
+		resp.Clients = append(resp.Clients, &controlpb.ClientInfo{
+			Address:          c.addr,
+			Identity:         c.identity,
+			ConnectedUnixMs:  c.connectedAt.UnixMilli(),
+			Webrtc:           c.webrtcReady,
+			BandwidthCapMbps: capMbps,
+		})
+	}
+	return resp, nil
+}
+
+func (controlServer) DisconnectClient(_ context.Context, req *controlpb.DisconnectClientRequest) (*controlpb.DisconnectClientResponse, error) {
+	for _, c := range clientList() {
+		if c.addr != req.Address {
+			continue
+		}
+		log.Printf("Control API: disconnecting %s", c.addr)
+		c.Disconnect(websocket.ClosePolicyViolation, "disconnected by administrator")
+		return &controlpb.DisconnectClientResponse{}, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no client at %s", req.Address)
+}
+
+func encoderSettings() *controlpb.EncoderSettings {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	s := &controlpb.EncoderSettings{
+		VideoCodec:         proto.String(VideoCodec),
+		Framerate:          proto.Int32(int32(FPS)),
+		Vbr:                proto.Bool(targetVBR),
+		Mpdecimate:         proto.Bool(targetMpdecimate),
+		KeyframeInterval:   proto.Int32(int32(targetKeyframeInterval)),
+		ContentTune:        proto.String(targetContentTune),
+		Chroma:             proto.String(Chroma),
+		CpuEffort:          proto.Int32(int32(targetCpuEffort)),
+		CpuThreads:         proto.Int32(int32(targetCpuThreads)),
+		AutoQuality:        proto.Bool(targetAutoQuality),
+		EnableAudio:        proto.Bool(EnableAudio),
+		AudioBitrate:       proto.String(AudioBitrate),
+		EnableDesktopMouse: proto.Bool(targetDrawMouse),
+	}
+	if targetMode == "quality" {
+		s.Quality = proto.Int32(int32(targetQuality))
+	} else {
+		s.BandwidthMbps = proto.Int32(int32(targetBandwidthMbps))
+	}
+	return s
+}
+
+func (controlServer) GetEncoderSettings(context.Context, *controlpb.GetEncoderSettingsRequest) (*controlpb.EncoderSettings, error) {
+	return encoderSettings(), nil
+}
+
+// UpdateEncoderSettings turns the request into a viewer config message, so
+// both paths validate and apply settings the same way.
+func (controlServer) UpdateEncoderSettings(_ context.Context, req *controlpb.EncoderSettings) (*controlpb.EncoderSettings, error) {
+	number := func(v *int32) *float64 {
+		if v == nil {
+			return nil
+		}
+		f := float64(*v)
+		return &f
+	}
+	msg := configMessage{
+		VideoCodec:         req.VideoCodec,
+		Framerate:          number(req.Framerate),
+		Bandwidth:          number(req.BandwidthMbps),
+		Quality:            number(req.Quality),
+		VBR:                req.Vbr,
+		Mpdecimate:         req.Mpdecimate,
+		KeyframeInterval:   number(req.KeyframeInterval),
+		ContentTune:        req.ContentTune,
+		Chroma:             req.Chroma,
+		CPUEffort:          number(req.CpuEffort),
+		CPUThreads:         number(req.CpuThreads),
+		AutoQuality:        req.AutoQuality,
+		EnableAudio:        req.EnableAudio,
+		AudioBitrate:       req.AudioBitrate,
+		EnableDesktopMouse: req.EnableDesktopMouse,
+	}
+	log.Printf("Control API: updating encoder settings %v", req)
+	applyConfigMessage(msg)
+	return encoderSettings(), nil
+}
+
+func currentStats() *controlpb.Stats {
+	clientsMutex.Lock()
+	count := len(clients)
+	clientsMutex.Unlock()
+	return &controlpb.Stats{
+		FfmpegCpuPercent: ffmpegCPUUsage(),
+		EncoderRestarts:  encoderWatchdogRestarts.Load(),
+		CaptureFps:       float64(captureFPS.Load()),
+		ClientCount:      int32(count),
+		UnixMs:           time.Now().UnixMilli(),
+	}
+}
+
+func (controlServer) GetStats(context.Context, *controlpb.GetStatsRequest) (*controlpb.Stats, error) {
+	return currentStats(), nil
+}
+
+func (controlServer) WatchStats(req *controlpb.WatchStatsRequest, stream grpc.ServerStreamingServer[controlpb.Stats]) error {
+	interval := 2 * time.Second
+	if req.IntervalMs > 0 {
+		interval = max(time.Duration(req.IntervalMs)*time.Millisecond, minWatchStatsInterval)
+	}
+	for {
+		if err := stream.Send(currentStats()); err != nil {
+			return err
+		}
+		if !sleepCtx(stream.Context(), interval) {
+			return nil
+		}
+	}
+}
+
+// Screenshot grabs one frame from the capture source with a separate
+// ffmpeg, so it works without viewers and without the MJPEG streams.
+func (controlServer) Screenshot(ctx context.Context, req *controlpb.ScreenshotRequest) (*controlpb.ScreenshotResponse, error) {
+	if sessionLocked.Load() {
+		return nil, status.Error(codes.FailedPrecondition, "session locked")
+	}
+	width, height := GetScreenSize()
+	input := currentCapture().Input(captureParams{Width: width, Height: height, FPS: 1, DrawMouse: true})
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, input.Args...)
+	outW, outH := width, height
+	if req.Width > 0 && int(req.Width) < width {
+		outW = int(req.Width)
+		outH = (height*outW/width + 1) &^ 1
+		args = append(args, input.filterArgs(fmt.Sprintf("scale=%d:%d", outW, outH))...)
+	} else {
+		args = append(args, input.filterArgs()...)
+	}
+	args = append(args, "-frames:v", "1", "-c:v", "mjpeg", "-q:v", "3", "-f", "image2pipe", "pipe:1")
+
+	ctx, cancel := context.WithTimeout(ctx, screenshotTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpegBinary(), args...)
+	cmd.Env = append(os.Environ(), "DISPLAY="+Display)
+	cmd.Stdin = input.Stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil || len(out) == 0 {
+		return nil, status.Errorf(codes.Internal, "screenshot failed: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return &controlpb.ScreenshotResponse{Jpeg: out, Width: int32(outW), Height: int32(outH)}, nil
+}
+
+func (controlServer) Broadcast(_ context.Context, req *controlpb.BroadcastRequest) (*controlpb.BroadcastResponse, error) {
+	if req.DurationSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative duration")
+	}
+	if len([]rune(req.Text)) > bannerMaxLength {
+		return nil, status.Errorf(codes.InvalidArgument, "banner longer than %d characters", bannerMaxLength)
+	}
+	if err := setBanner(req.Text, time.Duration(req.DurationSeconds)*time.Second, req.BurnIn); err != nil {
+		return nil, status.Errorf(codes.Internal, "banner failed: %v", err)
+	}
+	clientsMutex.Lock()
+	count := len(clients)
+	clientsMutex.Unlock()
+	return &controlpb.BroadcastResponse{ClientCount: int32(count)}, nil
+}
+
+func (controlServer) GetEncoderLog(context.Context, *controlpb.GetEncoderLogRequest) (*controlpb.GetEncoderLogResponse, error) {
+	resp := &controlpb.GetEncoderLogResponse{}
+	for _, run := range encoderLog() {
+		r := &controlpb.EncoderRun{
+			StartedUnixMs: run.started.UnixMilli(),
+			Args:          run.args,
+			Exit:          run.exit,
+			Stderr:        run.stderr.bytes(),
+			DroppedBytes:  run.stderr.dropped,
+		}
+		if !run.ended.IsZero() {
+			r.EndedUnixMs = run.ended.UnixMilli()
+		}
+		resp.Runs = append(resp.Runs, r)
+	}
+	return resp, nil
+}
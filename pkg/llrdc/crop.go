@@ -0,0 +1,354 @@
+package llrdc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Region-of-interest capture: a viewer can have the stream show one area of
+// a large desktop instead of all of it. {"type":"crop","x":0.5,"y":0,
+// "width":0.5,"height":0.5} crops to a rectangle in fractions of the
+// screen, {"type":"crop","window":"active"} (or an X window id) to a
+// window, which the crop then follows as it moves and resizes, and
+// {"type":"crop"} shows the whole screen again. "window":"focus" follows
+// whichever window has the focus (focuszoom.go). Like the screen size, the
+// crop is shared by all viewers. The encoder crops each captured frame, so
+// the video is the size of the region, unless "aspect" (the viewer's width
+// over its height) asks for the region to be scaled and padded to fill a
+// frame of that shape. Pointer and pen positions, which viewers send
+// relative to the video, are mapped back onto the screen. The VNC bridge,
+// MJPEG streams and screenshots still see the whole screen.
+
+const cropFollowInterval = time.Second
+
+// cropRect is a region of the screen in pixels.
+type cropRect struct {
+	X, Y, W, H int
+}
+
+type cropState struct {
+	rect cropRect
+	// window is the X window the crop follows, or "".
+	window string
+	// focus makes window follow the focused window.
+	focus bool
+	// aspect is the width over the height to pad the video to, or 0.
+	aspect float64
+}
+
+var (
+	cropMutex sync.Mutex
+	crop      cropState
+)
+
+var windowIDRe = regexp.MustCompile(`^(0x[0-9a-fA-F]+|[0-9]+)$`)
+
+// currentCrop returns the crop clamped to the screen with even sizes, and
+// false if the whole screen is captured.
+func currentCrop() (cropRect, bool) {
+	cropMutex.Lock()
+	r := crop.rect
+	cropMutex.Unlock()
+	if r.W <= 0 || r.H <= 0 {
+		return r, false
+	}
+	width, height := GetScreenSize()
+	r.X = min(max(r.X, 0), width-2)
+	r.Y = min(max(r.Y, 0), height-2)
+	r.W = max(min(r.W, width-r.X)&^1, 2)
+	r.H = max(min(r.H, height-r.Y)&^1, 2)
+	if r.W >= width-1 && r.H >= height-1 {
+		return r, false
+	}
+	return r, true
+}
+
+// cropFrame returns the size of the padded frame for the region, the
+// largest one of the requested aspect ratio that fits the screen, and false
+// if the region is not padded.
+func cropFrame() (int, int, bool) {
+	cropMutex.Lock()
+	aspect := crop.aspect
+	cropMutex.Unlock()
+	if aspect <= 0 {
+		return 0, 0, false
+	}
+	width, height := GetScreenSize()
+	if float64(width) > float64(height)*aspect {
+		return int(float64(height)*aspect) &^ 1, height &^ 1, true
+	}
+	return width &^ 1, int(float64(width)/aspect) &^ 1, true
+}
+
+// cropFilter returns the filters that crop frames to the region and pad
+// them, or "".
+func cropFilter() string {
+	r, ok := currentCrop()
+	if !ok {
+		return ""
+	}
+	filter := fmt.Sprintf("crop=%d:%d:%d:%d", r.W, r.H, r.X, r.Y)
+	if fw, fh, ok := cropFrame(); ok {
+		filter += fmt.Sprintf(",scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2,pad=%d:%d:(ow-iw)/2:(oh-ih)/2", fw, fh, fw, fh)
+	}
+	return filter
+}
+
+// cropRegionPoint maps a position relative to the video to one relative to
+// the region r, outside [0, 1] in the padding around it.
+func cropRegionPoint(r cropRect, nx, ny float64) (float64, float64) {
+	fw, fh, ok := cropFrame()
+	if !ok {
+		return nx, ny
+	}
+	// Undo the scaling and padding around the region.
+	scale := min(float64(fw)/float64(r.W), float64(fh)/float64(r.H))
+	w, h := float64(r.W)*scale, float64(r.H)*scale
+	return (nx*float64(fw) - (float64(fw)-w)/2) / w, (ny*float64(fh) - (float64(fh)-h)/2) / h
+}
+
+// cropPoint maps a position relative to the video onto the screen.
+func cropPoint(nx, ny float64) (float64, float64) {
+	r, ok := currentCrop()
+	if !ok {
+		return nx, ny
+	}
+	nx, ny = cropRegionPoint(r, nx, ny)
+	nx, ny = min(max(nx, 0), 1), min(max(ny, 0), 1)
+	width, height := GetScreenSize()
+	return (float64(r.X) + nx*float64(r.W)) / float64(width),
+		(float64(r.Y) + ny*float64(r.H)) / float64(height)
+}
+
+// panCrop moves a region crop by dx, dy pixels, keeping it on the screen,
+// and reports whether it moved. Window crops follow their window instead.
+func panCrop(dx, dy int) bool {
+	width, height := GetScreenSize()
+	cropMutex.Lock()
+	st := crop
+	if st.window != "" || st.focus || st.rect.W <= 0 || st.rect.H <= 0 {
+		cropMutex.Unlock()
+		return false
+	}
+	st.rect.X = min(max(st.rect.X+dx, 0), max(width-st.rect.W, 0))
+	st.rect.Y = min(max(st.rect.Y+dy, 0), max(height-st.rect.H, 0))
+	moved := applyCrop(st)
+	cropMutex.Unlock()
+	if moved {
+		restartCapture()
+	}
+	return moved
+}
+
+// cropConfig describes the crop for config messages, or nil.
+func cropConfig() map[string]interface{} {
+	cropMutex.Lock()
+	st := crop
+	cropMutex.Unlock()
+	cfg := map[string]interface{}{}
+	if r, ok := currentCrop(); ok {
+		width, height := GetScreenSize()
+		cfg["x"] = float64(r.X) / float64(width)
+		cfg["y"] = float64(r.Y) / float64(height)
+		cfg["width"] = float64(r.W) / float64(width)
+		cfg["height"] = float64(r.H) / float64(height)
+	}
+	if st.window != "" {
+		cfg["window"] = st.window
+	}
+	if st.focus {
+		cfg["focus"] = true
+	}
+	if st.aspect > 0 {
+		cfg["aspect"] = st.aspect
+	}
+	if len(cfg) == 0 {
+		return nil
+	}
+	return cfg
+}
+
+// setCrop replaces the crop and restarts the encoder if the video changed.
+// It reports whether the crop changed.
+func setCrop(st cropState) bool {
+	cropMutex.Lock()
+	changed := st != crop
+	restart := applyCrop(st)
+	cropMutex.Unlock()
+	if restart {
+		restartCapture()
+	}
+	return changed
+}
+
+// applyCrop is setCrop without the restart, and reports whether the video
+// changed. The caller must hold cropMutex.
+func applyCrop(st cropState) bool {
+	prev := crop
+	crop = st
+	if st.rect == prev.rect && st.aspect == prev.aspect {
+		return false
+	}
+	if st.rect.W > 0 {
+		log.Printf("Cropping video to %dx%d+%d+%d", st.rect.W, st.rect.H, st.rect.X, st.rect.Y)
+	} else {
+		log.Println("Showing the whole screen")
+	}
+	return true
+}
+
+// handleCropMessage applies a viewer's crop request and reports whether
+// the crop changed.
+func handleCropMessage(msg *cropMessage) bool {
+	aspect := msg.Aspect
+	if aspect < 0.1 || aspect > 10 {
+		aspect = 0
+	}
+	window := msg.Window
+	if window == "focus" {
+		cropMutex.Lock()
+		st := crop
+		cropMutex.Unlock()
+		st.focus, st.aspect = true, aspect
+		changed := setCrop(st)
+		wakeCropFollower()
+		return changed
+	}
+	if window != "" {
+		if window == "active" {
+			out, err := xdotoolOutput("getactivewindow")
+			if err != nil {
+				log.Printf("Crop: no active window: %v", err)
+				return false
+			}
+			window = out
+		}
+		if !windowIDRe.MatchString(window) {
+			log.Printf("Crop: invalid window %q", window)
+			return false
+		}
+		r, err := windowGeometry(window)
+		if err != nil {
+			log.Printf("Crop: window %s: %v", window, err)
+			return false
+		}
+		return setCrop(cropState{rect: r, window: window, aspect: aspect})
+	}
+
+	x, y, w, h := msg.X, msg.Y, msg.Width, msg.Height
+	if w <= 0 || h <= 0 {
+		return setCrop(cropState{})
+	}
+	width, height := GetScreenSize()
+	return setCrop(cropState{rect: cropRect{
+		X: int(math.Round(x * float64(width))),
+		Y: int(math.Round(y * float64(height))),
+		W: int(math.Round(w * float64(width))),
+		H: int(math.Round(h * float64(height))),
+	}, aspect: aspect})
+}
+
+func xdotoolOutput(args ...string) (string, error) {
+	cmd := exec.Command("xdotool", args...)
+	cmd.Env = append(os.Environ(), "DISPLAY="+Display)
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// windowGeometry returns the position and size of an X window.
+func windowGeometry(window string) (cropRect, error) {
+	out, err := xdotoolOutput("getwindowgeometry", "--shell", window)
+	if err != nil {
+		return cropRect{}, err
+	}
+	var r cropRect
+	for _, line := range strings.Split(out, "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		n, _ := strconv.Atoi(value)
+		switch key {
+		case "X":
+			r.X = n
+		case "Y":
+			r.Y = n
+		case "WIDTH":
+			r.W = n
+		case "HEIGHT":
+			r.H = n
+		}
+	}
+	if r.W <= 0 || r.H <= 0 {
+		return cropRect{}, fmt.Errorf("no geometry in %q", out)
+	}
+	return r, nil
+}
+
+// startCropFollower keeps a window crop on its window, or on the focused
+// window, and shows the whole screen again once a followed window is gone.
+func startCropFollower(ctx context.Context) {
+	goWorker(func() {
+		ticker := time.NewTicker(cropFollowInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-cropFollowerWake:
+			}
+			cropMutex.Lock()
+			st := crop
+			cropMutex.Unlock()
+			next := st
+			if st.focus {
+				if id := activeWindowID.Load(); id != 0 {
+					next.window = strconv.FormatUint(uint64(id), 10)
+				}
+			}
+			if next.window == "" {
+				continue
+			}
+			r, err := windowGeometry(next.window)
+			switch {
+			case err == nil:
+				next.rect = r
+			case st.focus:
+				// Focus moves on by itself.
+				continue
+			default:
+				log.Printf("Cropped window %s is gone: %v", next.window, err)
+				next = cropState{}
+			}
+
+			cropMutex.Lock()
+			restart := false
+			// A viewer may have replaced the crop meanwhile.
+			if crop == st {
+				restart = applyCrop(next)
+			}
+			cropMutex.Unlock()
+			if restart {
+				restartCapture()
+				broadcastConfig(false)
+			}
+		}
+	})
+}
+
+// cropFollowerWake makes the follower look at the windows now.
+var cropFollowerWake = make(chan struct{}, 1)
+
+func wakeCropFollower() {
+	select {
+	case cropFollowerWake <- struct{}{}:
+	default:
+	}
+}
@@ -1,7 +1,8 @@
-package main
+package llrdc
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"image"
@@ -20,14 +21,16 @@ var lastCursorSerial uint32
 var cursorMutex sync.Mutex
 var cachedCursorMsg map[string]interface{}
 
-func startCursorWatcher(display string) {
-	go func() {
+func startCursorWatcher(ctx context.Context, display string) {
+	goWorker(func() {
 		var X *xgb.Conn
 		var err error
 
 		// Retry connecting to X and initializing xfixes
 		for i := 0; i < 10; i++ {
-			time.Sleep(2 * time.Second)
+			if !sleepCtx(ctx, 2*time.Second) {
+				return
+			}
 			X, err = xgb.NewConnDisplay(display)
 			if err != nil {
 				log.Printf("Cursor watcher attempt %d: failed to connect to X: %v", i+1, err)
@@ -74,6 +77,9 @@ func startCursorWatcher(display string) {
 			return
 		}
 		defer X.Close()
+		// Closing the connection unblocks WaitForEvent on shutdown.
+		stop := context.AfterFunc(ctx, X.Close)
+		defer stop()
 
 		log.Println("Cursor watcher started successfully")
 
@@ -82,6 +88,9 @@ func startCursorWatcher(display string) {
 
 		for {
 			ev, err := X.WaitForEvent()
+			if ctx.Err() != nil {
+				return
+			}
 			if err != nil {
 				log.Printf("Cursor watcher error waiting for event: %v", err)
 				return
@@ -95,7 +104,7 @@ func startCursorWatcher(display string) {
 				updateCursor(X)
 			}
 		}
-	}()
+	})
 }
 
 func updateCursor(X *xgb.Conn) {
@@ -0,0 +1,150 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// Cursor confinement and edge scrolling for cropped streams (crop.go).
+// Each viewer sets them for itself:
+//
+//	{"type":"pointer_options","confine":true,"edge_scroll":true}
+//
+// Pointer positions from a viewer always land inside the crop, but
+// keyboard shortcuts, applications warping the pointer or other viewers
+// can still move the cursor off the region, where no one sees it. While a
+// viewer asks for confinement, the confiner below puts it back on the edge
+// of the region. The crop is shared, so one viewer asking confines the
+// cursor for all. With edge scrolling, moving the pointer onto the edge of
+// the video pans a region crop that way, a tenth of the region at a time,
+// so a zoomed-in viewer can travel across a large desktop. Each pan
+// restarts the encoder, so pans are at least edgeScrollInterval apart.
+// Window crops follow their window and are not panned.
+
+const (
+	confineInterval    = 100 * time.Millisecond
+	edgeScrollMargin   = 0.02
+	edgeScrollStep     = 0.1
+	edgeScrollInterval = 300 * time.Millisecond
+)
+
+// confiningViewers counts the viewers that asked for confinement.
+var confiningViewers atomic.Int32
+
+// pointerOptions are one viewer's settings, owned by its connection
+// handler.
+type pointerOptions struct {
+	confine    bool
+	edgeScroll bool
+	lastPan    time.Time
+}
+
+// update applies a pointer_options message.
+func (p *pointerOptions) update(msg *pointerOptionsMessage) {
+	if msg.Confine != nil && *msg.Confine != p.confine {
+		p.confine = *msg.Confine
+		if p.confine {
+			confiningViewers.Add(1)
+		} else {
+			confiningViewers.Add(-1)
+		}
+	}
+	if msg.EdgeScroll != nil {
+		p.edgeScroll = *msg.EdgeScroll
+	}
+}
+
+// release drops the viewer's confinement when it disconnects.
+func (p *pointerOptions) release() {
+	if p.confine {
+		p.confine = false
+		confiningViewers.Add(-1)
+	}
+}
+
+func (p *pointerOptions) message() map[string]interface{} {
+	return map[string]interface{}{"type": "pointer_options", "confine": p.confine, "edge_scroll": p.edgeScroll}
+}
+
+// scrollAtEdge pans the crop if the pointer, at nx, ny relative to the
+// video, is on an edge of the region. It reports whether the crop moved.
+func (p *pointerOptions) scrollAtEdge(nx, ny float64) bool {
+	if !p.edgeScroll || time.Since(p.lastPan) < edgeScrollInterval {
+		return false
+	}
+	r, ok := currentCrop()
+	if !ok {
+		return false
+	}
+	rx, ry := cropRegionPoint(r, nx, ny)
+	step := func(pos float64, size int) int {
+		switch {
+		case pos <= edgeScrollMargin:
+			return -int(math.Ceil(float64(size) * edgeScrollStep))
+		case pos >= 1-edgeScrollMargin:
+			return int(math.Ceil(float64(size) * edgeScrollStep))
+		}
+		return 0
+	}
+	dx, dy := step(rx, r.W), step(ry, r.H)
+	if dx == 0 && dy == 0 {
+		return false
+	}
+	if !panCrop(dx, dy) {
+		return false
+	}
+	p.lastPan = time.Now()
+	return true
+}
+
+// startCursorConfiner keeps the pointer on the crop region while a viewer
+// asks for it.
+func startCursorConfiner(ctx context.Context, display string) {
+	goWorker(func() {
+		var X *xgb.Conn
+		var err error
+		for i := 0; i < 10; i++ {
+			if !sleepCtx(ctx, 2*time.Second) {
+				return
+			}
+			X, err = xgb.NewConnDisplay(display)
+			if err == nil {
+				break
+			}
+			log.Printf("Cursor confiner attempt %d: failed to connect to X: %v", i+1, err)
+		}
+		if err != nil {
+			log.Printf("Cursor confiner failed to initialize after retries")
+			return
+		}
+		defer X.Close()
+		root := xproto.Setup(X).DefaultScreen(X).Root
+
+		for sleepCtx(ctx, confineInterval) {
+			if confiningViewers.Load() <= 0 {
+				continue
+			}
+			r, ok := currentCrop()
+			if !ok {
+				continue
+			}
+			reply, err := xproto.QueryPointer(X, root).Reply()
+			if err != nil {
+				// The connection is gone, e.g. Xvfb was restarted.
+				return
+			}
+			x, y := int(reply.RootX), int(reply.RootY)
+			cx := min(max(x, r.X), r.X+r.W-1)
+			cy := min(max(y, r.Y), r.Y+r.H-1)
+			if cx != x || cy != y {
+				xproto.WarpPointer(X, xproto.WindowNone, root, 0, 0, 0, 0, int16(cx), int16(cy))
+			}
+		}
+	})
+}
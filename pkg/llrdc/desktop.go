@@ -0,0 +1,107 @@
+package llrdc
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// desktopProfile describes how to launch a desktop session and how to apply
+// the wallpaper/compositing tweaks that llrdc relies on for that session.
+type desktopProfile struct {
+	Name string
+	// Command launches the session; it is wrapped in dbus-run-session.
+	Command []string
+	// DbusProcess is a long-lived process of the session whose environment
+	// carries DBUS_SESSION_BUS_ADDRESS, used to talk to the session bus.
+	DbusProcess string
+	// DisableCompositing turns off the session compositor, which otherwise
+	// adds latency and prevents the encoder from going idle.
+	DisableCompositing func(env []string)
+	// SetWallpaper applies the configured wallpaper.
+	SetWallpaper func(env []string, wallpaper string)
+}
+
+var desktopProfiles = map[string]desktopProfile{
+	"xfce": {
+		Name:        "xfce",
+		Command:     []string{"xfce4-session"},
+		DbusProcess: "xfconfd",
+		DisableCompositing: func(env []string) {
+			runWithEnv("xfconf-query", []string{"-c", "xfwm4", "-p", "/general/use_compositing", "-s", "false"}, env)
+		},
+		SetWallpaper: setXfceWallpaper,
+	},
+	"openbox": {
+		Name:         "openbox",
+		Command:      []string{"openbox-session"},
+		SetWallpaper: setRootWallpaper,
+	},
+	"i3": {
+		Name:         "i3",
+		Command:      []string{"i3"},
+		SetWallpaper: setRootWallpaper,
+	},
+	"lxqt": {
+		Name:        "lxqt",
+		Command:     []string{"startlxqt"},
+		DbusProcess: "lxqt-session",
+		SetWallpaper: func(env []string, wallpaper string) {
+			if err := runWithEnv("pcmanfm-qt", []string{"--set-wallpaper=" + wallpaper, "--wallpaper-mode=zoom"}, env); err != nil {
+				log.Printf("Warning: failed to set LXQt wallpaper: %v", err)
+			}
+		},
+	},
+	"mate": {
+		Name:        "mate",
+		Command:     []string{"mate-session"},
+		DbusProcess: "mate-session",
+		DisableCompositing: func(env []string) {
+			runWithEnv("gsettings", []string{"set", "org.mate.Marco.general", "compositing-manager", "false"}, env)
+		},
+		SetWallpaper: func(env []string, wallpaper string) {
+			runWithEnv("gsettings", []string{"set", "org.mate.background", "picture-filename", wallpaper}, env)
+			runWithEnv("gsettings", []string{"set", "org.mate.background", "picture-options", "zoom"}, env)
+		},
+	},
+}
+
+// currentDesktop resolves DesktopSession into a profile. Unknown values are
+// treated as a user-provided startup script if they point to an existing
+// file, and as a bare window manager command otherwise.
+func currentDesktop() desktopProfile {
+	name := strings.ToLower(strings.TrimSpace(DesktopSession))
+	if name == "" {
+		name = "xfce"
+	}
+	if p, ok := desktopProfiles[name]; ok {
+		return p
+	}
+
+	if info, err := os.Stat(DesktopSession); err == nil && !info.IsDir() {
+		return desktopProfile{
+			Name:         "script",
+			Command:      []string{DesktopSession},
+			SetWallpaper: setRootWallpaper,
+		}
+	}
+
+	return desktopProfile{
+		Name:         "wm",
+		Command:      strings.Fields(DesktopSession),
+		SetWallpaper: setRootWallpaper,
+	}
+}
+
+// setRootWallpaper paints the root window directly for sessions without a
+// desktop manager, using feh when available and a solid colour otherwise.
+func setRootWallpaper(env []string, wallpaper string) {
+	if _, err := exec.LookPath("feh"); err == nil && wallpaper != "" {
+		if err := runWithEnv("feh", []string{"--no-fehbg", "--bg-fill", wallpaper}, env); err == nil {
+			log.Printf("Wallpaper set to: %s", wallpaper)
+			return
+		}
+	}
+	_ = runWithEnv("xsetroot", []string{"-solid", "#2e3440"}, env)
+}
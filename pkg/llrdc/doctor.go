@@ -0,0 +1,295 @@
+package llrdc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pion/stun/v3"
+)
+
+type doctorStatus string
+
+const (
+	doctorOK   doctorStatus = "OK"
+	doctorWarn doctorStatus = "WARN"
+	doctorFail doctorStatus = "FAIL"
+)
+
+type doctorCheck struct {
+	Section string
+	Name    string
+	Status  doctorStatus
+	Detail  string
+}
+
+// runDoctor checks the host for everything the server needs at runtime and
+// prints a report. It returns false if any check failed outright.
+func runDoctor() bool {
+	var checks []doctorCheck
+	checks = append(checks, doctorFFmpeg()...)
+	if !TestPattern {
+		checks = append(checks, doctorX11Tools()...)
+	}
+	checks = append(checks, doctorNetwork()...)
+
+	ok := true
+	section := ""
+	for _, c := range checks {
+		if c.Section != section {
+			section = c.Section
+			fmt.Printf("\n%s\n", section)
+		}
+		fmt.Printf("  [%-4s] %-20s %s\n", c.Status, c.Name, c.Detail)
+		if c.Status == doctorFail {
+			ok = false
+		}
+	}
+	fmt.Println()
+	if ok {
+		fmt.Println("All required checks passed.")
+	} else {
+		fmt.Println("Some required checks failed; the server will not work correctly until they are fixed.")
+	}
+	return ok
+}
+
+// requiredEncoder maps a --video-codec value to the ffmpeg encoder it uses.
+var requiredEncoder = map[string]string{
+	"vp8":        "libvpx",
+	"h264":       "libx264",
+	"h264_nvenc": "h264_nvenc",
+	"h265":       "libx265",
+	"h265_nvenc": "hevc_nvenc",
+	"av1":        "libaom-av1",
+	"av1_nvenc":  "av1_nvenc",
+}
+
+func doctorFFmpeg() []doctorCheck {
+	const section = "FFmpeg"
+	path := ffmpegBinary()
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return []doctorCheck{{section, "ffmpeg", doctorFail, "not found in /app/bin or PATH"}}
+	}
+
+	version := ""
+	if out, err := exec.Command(resolved, "-hide_banner", "-version").Output(); err == nil {
+		version = strings.SplitN(string(out), "\n", 2)[0]
+	}
+	checks := []doctorCheck{{section, "ffmpeg", doctorOK, fmt.Sprintf("%s (%s)", resolved, version)}}
+
+	out, err := exec.Command(resolved, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return append(checks, doctorCheck{section, "encoders", doctorFail, fmt.Sprintf("failed to list encoders: %v", err)})
+	}
+	encoders := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		if f := strings.Fields(line); len(f) >= 2 {
+			encoders[f[1]] = true
+		}
+	}
+
+	for _, codec := range []string{"vp8", "h264", "h265", "av1", "h264_nvenc", "h265_nvenc", "av1_nvenc"} {
+		if isNVENCCodec(codec) && !UseGPU && codec != VideoCodec {
+			continue
+		}
+		enc := requiredEncoder[codec]
+		switch {
+		case encoders[enc]:
+			checks = append(checks, doctorCheck{section, codec, doctorOK, enc})
+		case codec == VideoCodec:
+			checks = append(checks, doctorCheck{section, codec, doctorFail, enc + " missing (selected by --video-codec)"})
+		default:
+			checks = append(checks, doctorCheck{section, codec, doctorWarn, enc + " missing"})
+		}
+	}
+
+	if EnableAudio {
+		if encoders["libopus"] {
+			checks = append(checks, doctorCheck{section, "audio", doctorOK, "libopus"})
+		} else {
+			checks = append(checks, doctorCheck{section, "audio", doctorWarn, "libopus missing; audio streaming will fail"})
+		}
+	}
+
+	if SceneChangeThreshold > 0 {
+		if err := probeSceneChange(); err != nil {
+			checks = append(checks, doctorCheck{section, "scene change", doctorWarn, "needs ffmpeg 7.1 or newer; --scene-change-threshold will be ignored"})
+		} else {
+			checks = append(checks, doctorCheck{section, "scene change", doctorOK, "keyframes on scene changes"})
+		}
+	}
+
+	if backend := activeGPUScale(); backend != "" {
+		checks = append(checks, doctorCheck{section, "gpu scale", doctorOK, backend})
+	} else if mode := strings.ToLower(GPUScale); mode == "vaapi" || mode == "libplacebo" {
+		checks = append(checks, doctorCheck{section, "gpu scale", doctorWarn, mode + " unavailable; scaling on the CPU"})
+	}
+
+	switch currentCapture().Name() {
+	case "x11grab":
+		devices, _ := exec.Command(resolved, "-hide_banner", "-devices").Output()
+		if strings.Contains(string(devices), "x11grab") {
+			checks = append(checks, doctorCheck{section, "x11grab", doctorOK, "screen capture supported"})
+		} else {
+			checks = append(checks, doctorCheck{section, "x11grab", doctorFail, "ffmpeg was built without x11grab"})
+		}
+	case "kmsgrab":
+		devices, _ := exec.Command(resolved, "-hide_banner", "-devices").Output()
+		device := KMSGrabDevice
+		if device == "" {
+			device = "/dev/dri/card0"
+		}
+		_, statErr := os.Stat(device)
+		switch {
+		case !strings.Contains(string(devices), "kmsgrab"):
+			checks = append(checks, doctorCheck{section, "kmsgrab", doctorFail, "ffmpeg was built without kmsgrab"})
+		case statErr != nil:
+			checks = append(checks, doctorCheck{section, "kmsgrab", doctorFail, statErr.Error()})
+		default:
+			checks = append(checks, doctorCheck{section, "kmsgrab", doctorOK, "capturing " + device + " (ffmpeg needs CAP_SYS_ADMIN)"})
+		}
+	case "pipewire":
+		if _, err := exec.LookPath("gst-launch-1.0"); err != nil {
+			checks = append(checks, doctorCheck{section, "pipewire", doctorFail, "gst-launch-1.0 not found"})
+		} else if err := exec.Command("gst-inspect-1.0", "pipewiresrc").Run(); err != nil {
+			checks = append(checks, doctorCheck{section, "pipewire", doctorFail, "GStreamer pipewiresrc plugin missing"})
+		} else {
+			checks = append(checks, doctorCheck{section, "pipewire", doctorOK, "GStreamer pipewiresrc available"})
+		}
+	}
+	return checks
+}
+
+func doctorX11Tools() []doctorCheck {
+	const section = "X11 and desktop"
+	type tool struct {
+		name     string
+		required bool
+		purpose  string
+	}
+	tools := []tool{
+		{"Xvfb", true, "virtual display"},
+		{"xdotool", true, "input injection"},
+		{"xrandr", true, "display resizing"},
+		{"xset", false, "screensaver and DPMS settings"},
+		{"wmctrl", false, "workspace names and session manifest layouts"},
+		{"dbus-run-session", true, "session bus"},
+	}
+	if currentDesktop().Name == "xfce" {
+		tools = append(tools, tool{"xfconf-query", false, "XFCE settings (wallpaper, HiDPI, compositing)"})
+	}
+	if cmd := currentDesktop().Command; len(cmd) > 0 {
+		tools = append(tools, tool{cmd[0], true, "desktop session"})
+	}
+	if KioskCommand != "" && KioskWM != "" {
+		tools = append(tools, tool{KioskWM, true, "kiosk window manager"})
+	}
+	if EnableClipboard {
+		tools = append(tools, tool{"xclip", false, "clipboard sync"})
+	}
+	if EnableAudio {
+		tools = append(tools, tool{"pulseaudio", false, "audio capture"})
+	}
+	if EnableNotifications {
+		tools = append(tools, tool{"dbus-monitor", false, "notification forwarding"})
+	}
+
+	var checks []doctorCheck
+	for _, t := range tools {
+		path, err := exec.LookPath(t.name)
+		switch {
+		case err == nil:
+			checks = append(checks, doctorCheck{section, t.name, doctorOK, path})
+		case t.required:
+			checks = append(checks, doctorCheck{section, t.name, doctorFail, "not found; needed for " + t.purpose})
+		default:
+			checks = append(checks, doctorCheck{section, t.name, doctorWarn, "not found; " + t.purpose + " disabled"})
+		}
+	}
+	return checks
+}
+
+func doctorNetwork() []doctorCheck {
+	const section = "Network"
+	var checks []doctorCheck
+
+	if ln, err := net.Listen("tcp", httpListenAddr()); err != nil {
+		checks = append(checks, doctorCheck{section, "tcp port", doctorFail, fmt.Sprintf("cannot listen on %d: %v", Port, err)})
+	} else {
+		ln.Close()
+		checks = append(checks, doctorCheck{section, "tcp port", doctorOK, fmt.Sprintf("%d available for HTTP", Port)})
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: Port})
+	if err != nil {
+		checks = append(checks, doctorCheck{section, "udp port", doctorFail, fmt.Sprintf("cannot bind %d: %v", Port, err)})
+		return checks
+	}
+	defer conn.Close()
+	checks = append(checks, doctorCheck{section, "udp port", doctorOK, fmt.Sprintf("%d available for WebRTC", Port)})
+
+	var server string
+	for _, u := range stunURLs() {
+		if strings.HasPrefix(u, "stun:") {
+			server = strings.TrimPrefix(u, "stun:")
+			break
+		}
+	}
+	if server == "" {
+		checks = append(checks, doctorCheck{section, "stun", doctorOK, "no UDP STUN server configured; viewers must reach a local address"})
+		return checks
+	}
+	mapped, err := stunMappedAddress(conn, server)
+	if err != nil {
+		checks = append(checks, doctorCheck{section, "stun", doctorWarn, fmt.Sprintf("%s unreachable (%v); remote clients may need --webrtc-public-ip", server, err)})
+		return checks
+	}
+	checks = append(checks, doctorCheck{section, "stun", doctorOK, fmt.Sprintf("public address %s", mapped)})
+
+	switch {
+	case WebRTCPublicIP != "" && WebRTCPublicIP != mapped.IP.String():
+		checks = append(checks, doctorCheck{section, "public ip", doctorWarn, fmt.Sprintf("--webrtc-public-ip is %s but STUN sees %s", WebRTCPublicIP, mapped.IP)})
+	case mapped.Port != Port:
+		// A remapped port means inbound UDP to Port won't reach us unless it
+		// is explicitly forwarded.
+		checks = append(checks, doctorCheck{section, "udp mapping", doctorWarn, fmt.Sprintf("NAT maps UDP %d to %d; forward UDP %d for direct connections", Port, mapped.Port, Port)})
+	default:
+		checks = append(checks, doctorCheck{section, "udp mapping", doctorOK, "NAT preserves the UDP port"})
+	}
+	return checks
+}
+
+// stunMappedAddress sends a STUN binding request from conn and returns the
+// address the STUN server saw it from.
+func stunMappedAddress(conn *net.UDPConn, server string) (*net.UDPAddr, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	req := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.WriteToUDP(req.Raw, raddr); err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &stun.Message{Raw: buf[:n]}
+	if err := res.Decode(); err != nil {
+		return nil, err
+	}
+	var xorAddr stun.XORMappedAddress
+	if err := xorAddr.GetFrom(res); err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: xorAddr.IP, Port: xorAddr.Port}, nil
+}
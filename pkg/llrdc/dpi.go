@@ -0,0 +1,81 @@
+package llrdc
+
+import (
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	minDPI = 48
+	maxDPI = 384
+)
+
+// sessionDPI returns the DPI the session should use: DPI if set, otherwise
+// the --hdpi percentage of 96, or 0 to leave the X server default alone.
+func sessionDPI() int {
+	if DPI > 0 {
+		return DPI
+	}
+	if HDPI > 0 {
+		return 96 * HDPI / 100
+	}
+	return 0
+}
+
+// hdpiPercent returns sessionDPI as a scaling percentage for the XFCE
+// settings derived from it.
+func hdpiPercent() int {
+	return sessionDPI() * 100 / 96
+}
+
+// applyDPISettings sets the X server DPI (xrandr --dpi), the Xft.dpi
+// resource read by most toolkits at startup, and the desktop's own
+// scaling settings.
+func applyDPISettings(env []string) {
+	dpi := sessionDPI()
+	if dpi <= 0 {
+		return
+	}
+	applyXDPI(env, dpi)
+	applyHdpiSettings(env)
+}
+
+// applyXDPI updates the desktop-independent DPI settings. xrandr recomputes
+// the DPI from the physical size on every resize, so it is also called
+// after resizeDisplay.
+func applyXDPI(env []string, dpi int) {
+	if err := runWithEnv("xrandr", []string{"--dpi", strconv.Itoa(dpi)}, env); err != nil {
+		log.Printf("xrandr --dpi %d failed: %v", dpi, err)
+	}
+	xrdb := exec.Command("xrdb", "-merge")
+	xrdb.Env = env
+	xrdb.Stdin = strings.NewReader("Xft.dpi: " + strconv.Itoa(dpi) + "\n")
+	runAsSessionUser(xrdb)
+	if err := xrdb.Run(); err != nil {
+		log.Printf("xrdb -merge failed: %v", err)
+	}
+}
+
+// SetDPI changes the session DPI at runtime. Already running applications
+// pick it up if their toolkit follows XSETTINGS (GTK under XFCE); others
+// use it when restarted.
+func SetDPI(dpi int) {
+	dpi = min(max(dpi, minDPI), maxDPI)
+	if dpi == DPI {
+		return
+	}
+	DPI = dpi
+	log.Printf("Session DPI changed to %d", dpi)
+	if !TestPattern {
+		applyDPISettings(sessionEnviron(Display))
+	}
+}
+
+// restoreXDPI re-applies the X server DPI after a resize.
+func restoreXDPI(env []string) {
+	if dpi := sessionDPI(); dpi > 0 {
+		applyXDPI(env, dpi)
+	}
+}
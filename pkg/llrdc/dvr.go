@@ -0,0 +1,203 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The DVR keeps the last DVRSeconds of encoded video in memory so a user can
+// grab "what just happened" after a problem appeared on screen. /replay
+// remuxes the buffer into a downloadable clip without re-encoding.
+
+type dvrFrame struct {
+	data []byte
+	at   time.Time
+	key  bool
+}
+
+var (
+	dvrMutex  sync.Mutex
+	dvrFrames []dvrFrame
+	dvrCodec  string
+	// dvrBytes is the size of dvrFrames, accounted in bufferedVideo.
+	dvrBytes int
+)
+
+// recordDVRFrame appends an encoded frame to the ring buffer. The buffer
+// always starts on a keyframe, so it may hold up to one keyframe interval
+// more than DVRSeconds, or less when that would exceed half of the
+// buffered video budget.
+func recordDVRFrame(frame []byte, at time.Time) {
+	if DVRSeconds <= 0 {
+		return
+	}
+	codec := VideoCodec
+	key := isKeyframe(codec, frame)
+
+	dvrMutex.Lock()
+	defer dvrMutex.Unlock()
+	if codec != dvrCodec {
+		// Frames of different codecs cannot share a clip.
+		dvrTrim(len(dvrFrames))
+		dvrCodec = codec
+	}
+	if len(dvrFrames) == 0 && !key {
+		return
+	}
+	dvrFrames = append(dvrFrames, dvrFrame{data: frame, at: at, key: key})
+	dvrBytes += len(frame)
+	bufferedVideo.force(len(frame))
+
+	cutoff := at.Add(-time.Duration(DVRSeconds) * time.Second)
+	start := 0
+	for i, f := range dvrFrames {
+		if f.at.After(cutoff) {
+			break
+		}
+		if f.key {
+			start = i
+		}
+	}
+	dvrTrim(start)
+
+	// Over budget, drop whole keyframe intervals from the start, and
+	// everything if even the newest one does not fit.
+	if limit := bufferedVideo.limit() / 2; limit > 0 {
+		for len(dvrFrames) > 0 && int64(dvrBytes) > limit {
+			next := len(dvrFrames)
+			for i := 1; i < len(dvrFrames); i++ {
+				if dvrFrames[i].key {
+					next = i
+					break
+				}
+			}
+			dvrTrim(next)
+		}
+	}
+}
+
+// dvrTrim drops the first n buffered frames. The caller must hold dvrMutex.
+func dvrTrim(n int) {
+	if n <= 0 {
+		return
+	}
+	size := 0
+	for _, f := range dvrFrames[:n] {
+		size += len(f.data)
+	}
+	dvrBytes -= size
+	bufferedVideo.release(size)
+	dvrFrames = append([]dvrFrame(nil), dvrFrames[n:]...)
+}
+
+// dvrClip returns the buffered frames covering the last d, starting at the
+// keyframe before that point, and their codec.
+func dvrClip(d time.Duration) ([]dvrFrame, string) {
+	dvrMutex.Lock()
+	defer dvrMutex.Unlock()
+	if len(dvrFrames) == 0 {
+		return nil, ""
+	}
+	cutoff := time.Now().Add(-d)
+	start := 0
+	for i, f := range dvrFrames {
+		if f.at.After(cutoff) {
+			break
+		}
+		if f.key {
+			start = i
+		}
+	}
+	return append([]dvrFrame(nil), dvrFrames[start:]...), dvrCodec
+}
+
+// handleReplay serves GET /replay?seconds=N as a WebM (VP8/AV1) or Matroska
+// (H.264/H.265) clip of the last N seconds, or of the whole buffer.
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if sessionLocked.Load() {
+		http.Error(w, "Session locked", http.StatusForbidden)
+		return
+	}
+	seconds := DVRSeconds
+	if s := r.URL.Query().Get("seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, "seconds must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		seconds = min(n, DVRSeconds)
+	}
+
+	frames, codec := dvrClip(time.Duration(seconds) * time.Second)
+	if len(frames) == 0 {
+		http.Error(w, "Replay buffer is empty", http.StatusNotFound)
+		return
+	}
+
+	format, ext, mime := "webm", "webm", "video/webm"
+	if remuxInputFormat(codec) != "ivf" {
+		format, ext, mime = "matroska", "mkv", "video/x-matroska"
+	}
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if remuxInputFormat(codec) != "ivf" {
+		fps := float64(len(frames)) / max(frames[len(frames)-1].at.Sub(frames[0].at).Seconds(), 1)
+		args = append(args, "-framerate", strconv.FormatFloat(fps, 'f', 2, 64))
+	}
+	args = append(args, "-f", remuxInputFormat(codec), "-i", "pipe:0", "-c:v", "copy", "-f", format, "pipe:1")
+
+	cmd := exec.CommandContext(r.Context(), ffmpegBinary(), args...)
+	cmd.Stdout = w
+	cmd.Stderr = newStderrLog("dvr ffmpeg")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		http.Error(w, "Failed to start muxer", http.StatusInternalServerError)
+		return
+	}
+	// Headers must be set before ffmpeg starts writing the body.
+	name := fmt.Sprintf("llrdc-replay-%s.%s", time.Now().Format("20060102-150405"), ext)
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if err := cmd.Start(); err != nil {
+		log.Printf("Replay: failed to start ffmpeg: %v", err)
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "Failed to start muxer", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Replay: serving %d frames (%s) to %s", len(frames), codec, r.RemoteAddr)
+
+	go func() {
+		defer stdin.Close()
+		if remuxInputFormat(codec) != "ivf" {
+			for _, f := range frames {
+				if _, err := stdin.Write(f.data); err != nil {
+					return
+				}
+			}
+			return
+		}
+		width, height := GetScreenSize()
+		ivf := &ivfWriter{w: stdin}
+		if err := ivf.writeHeader(codec, width, height, 1000); err != nil {
+			return
+		}
+		for _, f := range frames {
+			pts := uint64(f.at.Sub(frames[0].at).Milliseconds())
+			if err := ivf.writeFrameAt(f.data, pts); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil && r.Context().Err() == nil {
+		log.Printf("Replay: ffmpeg failed: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+)
+
+// Frame is one encoded video access unit. Frames are shared by every
+// consumer (WebRTC, WebSocket, remux, DVR) without copying, so Data must
+// not be modified once the frame is delivered.
+type Frame struct {
+	Data []byte
+	// StreamID changes whenever the encoder restarts, so consumers know to
+	// wait for a new keyframe.
+	StreamID uint32
+	// packet is Data with frameHeadroom spare bytes in front of it, where
+	// the WebSocket packet header is written, or nil if the encoder did
+	// not reserve them.
+	packet []byte
+}
+
+// frameHeadroom is the space the ffmpeg splitters reserve in front of each
+// frame for the largest WebSocket packet header.
+const frameHeadroom = videoChunkHeaderSize
+
+// newFrameBuffer allocates a frame of size bytes preceded by frameHeadroom
+// spare bytes.
+func newFrameBuffer(size int) []byte {
+	return make([]byte, frameHeadroom+size)
+}
+
+// Encoder captures the display and produces an encoded video stream. The
+// HTTP and WebRTC layers only talk to this interface, so new backends
+// (hardware, GStreamer, native) can be added alongside ffmpeg.
+type Encoder interface {
+	// Start launches the encoder in the background. Frames are delivered on
+	// Frames() until ctx is cancelled.
+	Start(ctx context.Context) error
+	SetBitrate(mbps int)
+	RequestKeyframe()
+	// Resize switches encoding to a new display size.
+	Resize(width, height int)
+	Frames() <-chan Frame
+}
+
+// encoder is the active video encoder backend.
+var encoder Encoder = newFFmpegEncoder()
+
+// ffmpegEncoder runs ffmpeg as a subprocess and splits its output into
+// frames. Settings changes restart the process, see startStreaming.
+type ffmpegEncoder struct {
+	frames chan Frame
+}
+
+func newFFmpegEncoder() *ffmpegEncoder {
+	return &ffmpegEncoder{frames: make(chan Frame, 60)}
+}
+
+func (e *ffmpegEncoder) Start(ctx context.Context) error {
+	return startStreaming(ctx, func(buf []byte, streamID uint32) {
+		select {
+		case e.frames <- Frame{Data: buf[frameHeadroom:], StreamID: streamID, packet: buf}:
+		case <-ctx.Done():
+		}
+	})
+}
+
+func (e *ffmpegEncoder) SetBitrate(mbps int) {
+	SetBandwidth(mbps)
+}
+
+func (e *ffmpegEncoder) RequestKeyframe() {
+	RequestKeyframe()
+}
+
+// Resize restarts ffmpeg, which captures the display at the current screen
+// size (see SetScreenSize).
+func (e *ffmpegEncoder) Resize(width, height int) {
+	log.Printf("Encoder resize to %dx%d", width, height)
+	RestartForResize()
+}
+
+func (e *ffmpegEncoder) Frames() <-chan Frame {
+	return e.frames
+}
+
+// startVideoPipeline starts the encoder and fans its frames out to clients.
+func startVideoPipeline(ctx context.Context) error {
+	if err := encoder.Start(ctx); err != nil {
+		return err
+	}
+	frames := encoder.Frames()
+	goSupervised(ctx, "video fan-out", func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-frames:
+				broadcastVideoFrame(frame)
+			}
+		}
+	})
+	return nil
+}
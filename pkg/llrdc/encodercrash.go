@@ -0,0 +1,138 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Crash-loop detection for the encoder. ffmpeg is restarted whenever it
+// exits. A run that ends by itself within encoderCrashWindow, rather than
+// being killed for a settings change or by the watchdog, is a crash, and
+// each crash in a row doubles the wait before the next attempt, up to
+// encoderMaxBackoff, instead of retrying bad arguments or a missing codec
+// every second for good. After encoderCrashLoopRuns crashes in a row
+// viewers get
+//
+//	{"type":"encoder_error","error":"exit status 1","stderr":"...","crashes":3,"retry_ms":4000}
+//
+// with the end of the last run's stderr, so they can tell why there is no
+// video, and {"type":"encoder_recovered"} once frames come out again.
+// Viewers that connect in between get the current error. Changing the codec
+// or chroma, the usual fixes, retries at once.
+
+const (
+	encoderCrashWindow   = 10 * time.Second
+	encoderCrashLoopRuns = 3
+	encoderMaxBackoff    = time.Minute
+	encoderErrorLines    = 20
+	encoderErrorBytes    = 4096
+)
+
+var (
+	encoderCrashMutex sync.Mutex
+	// encoderCrashes counts the crashes in a row.
+	encoderCrashes int
+	// encoderErrorMsg is the encoder_error message in effect, or nil.
+	encoderErrorMsg map[string]interface{}
+	encoderFailing  atomic.Bool
+	encoderRetry    = make(chan struct{}, 1)
+)
+
+// encoderRunEnded records how run ended and returns how long to wait
+// before starting the next one.
+func encoderRunEnded(run *encoderRun, err error) time.Duration {
+	// A request to retry made while run was going is moot now.
+	select {
+	case <-encoderRetry:
+	default:
+	}
+
+	encoderCrashMutex.Lock()
+	switch {
+	case time.Since(run.started) >= encoderCrashWindow:
+		encoderCrashes = 0
+	case ffmpegCrashed(err):
+		encoderCrashes++
+	}
+	crashes := encoderCrashes
+	if crashes == 0 {
+		encoderCrashMutex.Unlock()
+		return time.Second
+	}
+	delay := min(time.Second<<min(crashes-1, 6), encoderMaxBackoff)
+	var msg map[string]interface{}
+	if crashes >= encoderCrashLoopRuns {
+		reason := "exited"
+		if err != nil {
+			reason = err.Error()
+		}
+		msg = map[string]interface{}{
+			"type":     "encoder_error",
+			"error":    reason,
+			"stderr":   run.stderrTail(encoderErrorLines, encoderErrorBytes),
+			"crashes":  crashes,
+			"retry_ms": delay.Milliseconds(),
+		}
+		encoderErrorMsg = msg
+		encoderFailing.Store(true)
+	}
+	encoderCrashMutex.Unlock()
+
+	log.Printf("ffmpeg failed %d times in a row, retrying in %v", crashes, delay)
+	if msg != nil {
+		broadcastJSON(msg)
+	}
+	return delay
+}
+
+// waitEncoderRetry waits for delay, or until a retry is requested, and
+// reports whether ctx is still live.
+func waitEncoderRetry(ctx context.Context, delay time.Duration) bool {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+	case <-encoderRetry:
+		log.Println("Retrying the encoder now")
+	}
+	return true
+}
+
+// retryEncoderNow cuts a crash-loop backoff short, after a settings change
+// that may fix it.
+func retryEncoderNow() {
+	select {
+	case encoderRetry <- struct{}{}:
+	default:
+	}
+}
+
+// clearEncoderError tells viewers the encoder works again. It is called
+// for every frame, so it returns at once when there is nothing to clear.
+func clearEncoderError() {
+	if !encoderFailing.Load() {
+		return
+	}
+	encoderCrashMutex.Lock()
+	cleared := encoderErrorMsg != nil
+	encoderErrorMsg = nil
+	encoderFailing.Store(false)
+	encoderCrashMutex.Unlock()
+	if cleared {
+		log.Println("Encoder recovered")
+		broadcastJSON(map[string]interface{}{"type": "encoder_recovered"})
+	}
+}
+
+// currentEncoderErrorMessage returns the encoder_error message for a new
+// viewer, or nil if the encoder is working.
+func currentEncoderErrorMessage() map[string]interface{} {
+	encoderCrashMutex.Lock()
+	defer encoderCrashMutex.Unlock()
+	return encoderErrorMsg
+}
@@ -0,0 +1,222 @@
+package llrdc
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture of the video encoder's stderr. Each ffmpeg run keeps the last
+// FFmpegLogKB of what it wrote in a ring buffer, and the last
+// encoderLogRuns runs are kept, so the output of a run that crashed is
+// still there after the restart. The control API returns them from
+// GetEncoderLog. Lines also go to the server log, but only the first
+// encoderLogLines of each run and, once it ends, its last
+// encoderLogTailLines unless UseDebugFFmpeg is set, so an encoder that
+// keeps complaining cannot flood it while the reason it failed still shows.
+
+const (
+	encoderLogRuns      = 4
+	encoderLogLines     = 50
+	encoderLogTailLines = 5
+	encoderLogLineMax   = 1024
+)
+
+var (
+	encoderLogMutex sync.Mutex
+	encoderRuns     []*encoderRun
+)
+
+// encoderRun is one ffmpeg run. Its fields are guarded by encoderLogMutex.
+type encoderRun struct {
+	started time.Time
+	ended   time.Time
+	args    []string
+	exit    string
+	stderr  ringBuffer
+}
+
+// ringBuffer keeps the last len(data) bytes written to it.
+type ringBuffer struct {
+	data    []byte
+	pos     int
+	full    bool
+	dropped int64
+}
+
+func (r *ringBuffer) write(p []byte) {
+	if len(r.data) == 0 {
+		r.dropped += int64(len(p))
+		return
+	}
+	if len(p) > len(r.data) {
+		r.dropped += int64(len(p) - len(r.data))
+		p = p[len(p)-len(r.data):]
+	}
+	for len(p) > 0 {
+		if r.full {
+			r.dropped += int64(min(len(p), len(r.data)-r.pos))
+		}
+		n := copy(r.data[r.pos:], p)
+		p = p[n:]
+		r.pos += n
+		if r.pos == len(r.data) {
+			r.pos = 0
+			r.full = true
+		}
+	}
+}
+
+func (r *ringBuffer) bytes() []byte {
+	if !r.full {
+		return append([]byte(nil), r.data[:r.pos]...)
+	}
+	return append(append([]byte(nil), r.data[r.pos:]...), r.data[:r.pos]...)
+}
+
+// beginEncoderRun records the start of an ffmpeg run with args.
+func beginEncoderRun(args []string) *encoderRun {
+	run := &encoderRun{
+		started: time.Now(),
+		args:    append([]string(nil), args...),
+		stderr:  ringBuffer{data: make([]byte, max(FFmpegLogKB, 0)*1024)},
+	}
+	encoderLogMutex.Lock()
+	encoderRuns = append(encoderRuns, run)
+	if len(encoderRuns) > encoderLogRuns {
+		encoderRuns = append([]*encoderRun(nil), encoderRuns[len(encoderRuns)-encoderLogRuns:]...)
+	}
+	encoderLogMutex.Unlock()
+	return run
+}
+
+// capture reads stderr until it is closed, keeping it in the run's buffer
+// and logging its first and last lines.
+func (run *encoderRun) capture(stderr io.Reader) {
+	var line []byte
+	var tail []string
+	lines := 0
+	logLine := func() {
+		if len(line) == 0 {
+			return
+		}
+		if lines < encoderLogLines || UseDebugFFmpeg {
+			log.Printf("[ffmpeg stderr]: %s", line)
+		} else {
+			tail = append(tail, string(line))
+			if len(tail) > encoderLogTailLines {
+				tail = tail[1:]
+			}
+		}
+		lines++
+		line = line[:0]
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := stderr.Read(buf)
+		if n > 0 {
+			encoderLogMutex.Lock()
+			run.stderr.write(buf[:n])
+			encoderLogMutex.Unlock()
+			// ffmpeg ends progress lines with \r.
+			for _, b := range buf[:n] {
+				if b == '\n' || b == '\r' {
+					logLine()
+				} else if len(line) < encoderLogLineMax {
+					line = append(line, b)
+				}
+			}
+		}
+		if err != nil {
+			logLine()
+			if skipped := lines - encoderLogLines - len(tail); skipped > 0 && !UseDebugFFmpeg {
+				log.Printf("[ffmpeg stderr]: ... %d lines skipped, see the control API's GetEncoderLog", skipped)
+			}
+			for _, l := range tail {
+				log.Printf("[ffmpeg stderr]: %s", l)
+			}
+			return
+		}
+	}
+}
+
+// stderrLog is a child process's stderr going to the server log, one line
+// at a time and only its first encoderLogLines lines, so a helper that
+// keeps complaining cannot flood it. It is meant for cmd.Stderr.
+type stderrLog struct {
+	name  string
+	mu    sync.Mutex
+	line  []byte
+	lines int
+}
+
+func newStderrLog(name string) *stderrLog {
+	return &stderrLog{name: name}
+}
+
+func (l *stderrLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range p {
+		if b != '\n' && b != '\r' {
+			if len(l.line) < encoderLogLineMax {
+				l.line = append(l.line, b)
+			}
+			continue
+		}
+		if len(l.line) == 0 {
+			continue
+		}
+		if l.lines < encoderLogLines {
+			log.Printf("[%s stderr]: %s", l.name, l.line)
+		} else if l.lines == encoderLogLines {
+			log.Printf("[%s stderr]: ... further lines skipped", l.name)
+		}
+		l.lines++
+		l.line = l.line[:0]
+	}
+	return len(p), nil
+}
+
+// finish records how the run ended.
+func (run *encoderRun) finish(err error) {
+	encoderLogMutex.Lock()
+	defer encoderLogMutex.Unlock()
+	run.ended = time.Now()
+	run.exit = "exit status 0"
+	if err != nil {
+		run.exit = err.Error()
+	}
+}
+
+// stderrTail returns the last maxLines lines of the run's stderr, at most
+// maxBytes of them.
+func (run *encoderRun) stderrTail(maxLines, maxBytes int) string {
+	encoderLogMutex.Lock()
+	data := run.stderr.bytes()
+	encoderLogMutex.Unlock()
+	data = bytes.TrimSpace(bytes.ReplaceAll(data, []byte("\r"), []byte("\n")))
+	if len(data) > maxBytes {
+		data = data[len(data)-maxBytes:]
+	}
+	lines := strings.Split(string(data), "\n")
+	return strings.Join(lines[max(len(lines)-maxLines, 0):], "\n")
+}
+
+// encoderLog returns copies of the kept runs, oldest first.
+func encoderLog() []encoderRun {
+	encoderLogMutex.Lock()
+	defer encoderLogMutex.Unlock()
+	runs := make([]encoderRun, 0, len(encoderRuns))
+	for _, run := range encoderRuns {
+		c := *run
+		data := run.stderr.bytes()
+		c.stderr = ringBuffer{data: data, pos: len(data), dropped: run.stderr.dropped}
+		runs = append(runs, c)
+	}
+	return runs
+}
@@ -0,0 +1,631 @@
+package llrdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	targetMode          = "bandwidth" // "bandwidth" or "quality"
+	targetBandwidthMbps = 5           // Initial default: 5 Mbps
+	targetQuality       = 70          // 10-100
+	targetVBR              = true        // Default VBR to true
+	targetMpdecimate       = false       // Default mpdecimate to false
+	targetCpuEffort        = -1          // Default: auto-tuned, see autotune.go
+	targetCpuThreads       = 0           // Default: auto-tuned
+	targetDrawMouse        = true        // Default: true
+	targetKeyframeInterval = 2           // Default: 2 seconds
+	targetContentTune      = "video"     // "video" or "text"
+	ffmpegCmd              *exec.Cmd
+	ffmpegAudioCmd         *exec.Cmd
+	ffmpegMutex            sync.Mutex
+	ffmpegStreamID         uint32
+)
+
+// Settings changes whose restart is deferred while applyEncoderChanges
+// runs, guarded by ffmpegMutex.
+var (
+	encoderBatchDepth   int
+	pendingVideoChanges []string
+	pendingAudioChanges []string
+)
+
+// restartVideoLocked restarts ffmpeg for a settings change, or records the
+// change while a batch of changes is applied (see applyEncoderChanges). The
+// caller holds ffmpegMutex.
+func restartVideoLocked(change string) {
+	if encoderBatchDepth > 0 {
+		pendingVideoChanges = append(pendingVideoChanges, change)
+		return
+	}
+	if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+		log.Printf("%s, restarting ffmpeg...", change)
+		ffmpegCmd.Process.Kill()
+	}
+}
+
+// restartAudioLocked is restartVideoLocked for the audio ffmpeg.
+func restartAudioLocked(change string) {
+	if encoderBatchDepth > 0 {
+		pendingAudioChanges = append(pendingAudioChanges, change)
+		return
+	}
+	if ffmpegAudioCmd != nil && ffmpegAudioCmd.Process != nil {
+		log.Printf("%s, restarting audio ffmpeg...", change)
+		ffmpegAudioCmd.Process.Kill()
+	}
+}
+
+// applyEncoderChanges runs fn, which calls the setters below, and then
+// restarts each ffmpeg at most once for all the changes it made, so a
+// message that changes several settings restarts the encoder once, with
+// all of them. It reports whether the video encoder was restarted.
+func applyEncoderChanges(fn func()) bool {
+	ffmpegMutex.Lock()
+	encoderBatchDepth++
+	ffmpegMutex.Unlock()
+
+	fn()
+
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	encoderBatchDepth--
+	if encoderBatchDepth > 0 {
+		// Another batch is still open; it restarts for both.
+		return len(pendingVideoChanges) > 0
+	}
+	video, audio := pendingVideoChanges, pendingAudioChanges
+	pendingVideoChanges, pendingAudioChanges = nil, nil
+	if len(audio) > 0 {
+		restartAudioLocked(strings.Join(audio, "; "))
+	}
+	if len(video) == 0 {
+		return false
+	}
+	restartVideoLocked(strings.Join(video, "; "))
+	return true
+}
+
+func SetChroma(chroma string) {
+	if chroma != "420" && chroma != "444" {
+		log.Printf("Invalid chroma setting: %s", chroma)
+		return
+	}
+
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if Chroma == chroma {
+		return
+	}
+
+	Chroma = chroma
+	retryEncoderNow()
+	restartVideoLocked(fmt.Sprintf("Target chroma changed to %s", chroma))
+}
+
+func SetVideoCodec(codec string) {
+	if codec != "vp8" && codec != "h264" && codec != "h264_nvenc" && codec != "h265" && codec != "h265_nvenc" && codec != "av1" && codec != "av1_nvenc" {
+		log.Printf("Invalid video codec: %s", codec)
+		return
+	}
+
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if VideoCodec == codec {
+		return
+	}
+
+	VideoCodec = codec
+	
+	if err := initWebRTCTrack(); err != nil { // Re-create track
+		log.Printf("Failed to re-create the WebRTC track: %v", err)
+	}
+	closeAllWHEPSessions("video codec changed")
+	retryEncoderNow()
+	restartVideoLocked(fmt.Sprintf("Target video codec changed to %s", codec))
+}
+
+func SetKeyframeInterval(interval int) {
+	if interval < 1 {
+		interval = 1
+	} else if interval > 10 {
+		interval = 10
+	}
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetKeyframeInterval == interval {
+		return
+	}
+
+	targetKeyframeInterval = interval
+	restartVideoLocked(fmt.Sprintf("Target keyframe interval changed to %d", interval))
+}
+
+// SetContentTune selects the encoder tuning preset: "video" for general
+// content and motion, "text" for IDEs, terminals and other sharp-edged,
+// mostly static screens.
+func SetContentTune(tune string) {
+	if tune != "video" && tune != "text" {
+		log.Printf("Invalid content tune: %s", tune)
+		return
+	}
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetContentTune == tune {
+		return
+	}
+
+	targetContentTune = tune
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target content tune changed to %s", tune))
+}
+
+func SetMpdecimate(mpdecimate bool) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetMpdecimate == mpdecimate {
+		return
+	}
+
+	targetMpdecimate = mpdecimate
+	restartVideoLocked(fmt.Sprintf("Target mpdecimate changed to %v", mpdecimate))
+}
+
+func SetCpuEffort(effort int) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetCpuEffort == effort {
+		return
+	}
+
+	targetCpuEffort = effort
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target CPU effort changed to %d", effort))
+}
+
+func SetCpuThreads(threads int) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetCpuThreads == threads {
+		return
+	}
+
+	targetCpuThreads = threads
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target CPU threads changed to %d", threads))
+}
+
+func SetDrawMouse(draw bool) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetDrawMouse == draw {
+		return
+	}
+
+	targetDrawMouse = draw
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target draw mouse changed to %v", draw))
+}
+
+func SetVBR(vbr bool) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetVBR == vbr {
+		return
+	}
+
+	targetVBR = vbr
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target VBR changed to %v", vbr))
+}
+
+func SetBandwidth(bwMbps int) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetMode == "bandwidth" && targetBandwidthMbps == bwMbps {
+		return
+	}
+
+	targetMode = "bandwidth"
+	targetBandwidthMbps = bwMbps
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target bandwidth changed to %d Mbps", bwMbps))
+}
+
+func SetQuality(quality int) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if targetMode == "quality" && targetQuality == quality {
+		return
+	}
+
+	targetMode = "quality"
+	targetQuality = quality
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target quality changed to %d", quality))
+}
+
+func SetFramerate(fps int) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if FPS == fps {
+		return
+	}
+
+	FPS = fps
+	scheduleStateSave()
+	restartVideoLocked(fmt.Sprintf("Target framerate changed to %d fps", fps))
+}
+
+func RestartForResize() {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+
+	restartVideoLocked("Screen size changed")
+}
+
+// RequestKeyframe restarts ffmpeg so clients receive a fresh keyframe, e.g.
+// after frames were withheld from them.
+func RequestKeyframe() {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+
+	if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+		log.Println("Keyframe requested, restarting ffmpeg...")
+		ffmpegCmd.Process.Kill()
+	}
+}
+
+func SetEnableAudio(enable bool) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if EnableAudio == enable {
+		return
+	}
+
+	EnableAudio = enable
+	restartAudioLocked(fmt.Sprintf("Enable audio changed to %v", enable))
+}
+
+func SetAudioBitrate(bitrate string) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if AudioBitrate == bitrate {
+		return
+	}
+
+	AudioBitrate = bitrate
+	restartAudioLocked(fmt.Sprintf("Audio bitrate changed to %s", bitrate))
+}
+
+// SetAudioFrameDuration sets the Opus frame duration in milliseconds.
+func SetAudioFrameDuration(ms float64) {
+	if !validOpusFrameDuration(ms) {
+		log.Printf("Invalid audio frame duration: %vms", ms)
+		return
+	}
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if AudioFrameDuration == ms {
+		return
+	}
+
+	AudioFrameDuration = ms
+	restartAudioLocked(fmt.Sprintf("Audio frame duration changed to %vms", ms))
+}
+
+func SetAudioFEC(fec bool) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if AudioFEC == fec {
+		return
+	}
+
+	AudioFEC = fec
+	restartAudioLocked(fmt.Sprintf("Audio FEC changed to %v", fec))
+}
+
+func SetAudioDTX(dtx bool) {
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	if AudioDTX == dtx {
+		return
+	}
+
+	AudioDTX = dtx
+	restartAudioLocked(fmt.Sprintf("Audio DTX changed to %v", dtx))
+}
+
+// ffmpegBinary returns the bundled ffmpeg if present, otherwise the one on PATH.
+func ffmpegBinary() string {
+	ffmpegPath := "/app/bin/ffmpeg"
+	if _, err := os.Stat(ffmpegPath); os.IsNotExist(err) {
+		log.Println("Warning: /app/bin/ffmpeg not found, relying on system PATH")
+		ffmpegPath = "ffmpeg"
+	}
+	return ffmpegPath
+}
+
+const sceneChangeProbeTimeout = 5 * time.Second
+
+var (
+	sceneChangeOnce      sync.Once
+	sceneChangeSupported bool
+)
+
+// sceneChangeKeyframes reports whether SceneChangeThreshold is in effect.
+// -force_key_frames scd_metadata needs ffmpeg 7.1, and an older one (such
+// as a distribution's ffmpeg found on PATH) would fail on every start, so
+// it is tried once on a test clip first.
+func sceneChangeKeyframes() bool {
+	if SceneChangeThreshold <= 0 {
+		return false
+	}
+	sceneChangeOnce.Do(func() {
+		if err := probeSceneChange(); err != nil {
+			log.Printf("Warning: this ffmpeg cannot insert keyframes on scene changes (needs 7.1 or newer), disabling --scene-change-threshold: %v", err)
+			return
+		}
+		sceneChangeSupported = true
+	})
+	return sceneChangeSupported
+}
+
+// probeSceneChange encodes two test frames with scene-change keyframes.
+func probeSceneChange() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sceneChangeProbeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, ffmpegBinary(), "-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "testsrc=size=64x64:rate=5", "-frames:v", "2",
+		"-vf", "scdet", "-force_key_frames", "scd_metadata", "-f", "null", "-").CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// startStreaming runs ffmpeg until ctx is cancelled, restarting it whenever
+// it exits so that settings changes (which kill the process) take effect.
+// Frames are passed to onFrame preceded by frameHeadroom spare bytes. It
+// fails only if there is no ffmpeg to run.
+func startStreaming(ctx context.Context, onFrame func([]byte, uint32)) error {
+	ffmpegPath, err := exec.LookPath(ffmpegBinary())
+	if err != nil {
+		return err
+	}
+
+	goWorker(func() {
+		defer func() {
+			ffmpegMutex.Lock()
+			ffmpegCmd = nil
+			ffmpegMutex.Unlock()
+		}()
+		for ctx.Err() == nil {
+			ffmpegMutex.Lock()
+			mode := targetMode
+			bw := targetBandwidthMbps
+			quality := targetQuality
+			fps := FPS
+			vbr := targetVBR
+			mpdecimate := targetMpdecimate
+			cpuEffort := targetCpuEffort
+			cpuThreads := targetCpuThreads
+			drawMouse := targetDrawMouse
+			keyframeInterval := targetKeyframeInterval
+			contentTune := targetContentTune
+			ffmpegMutex.Unlock()
+			fps = effectiveFPS(fps)
+			mode, quality, vbr = idleRefinement(mode, quality, vbr)
+			cpuEffort, cpuThreads = resolveEncoderSettings(cpuEffort, cpuThreads)
+			captureFPS.Store(int32(fps))
+
+			width, height := GetScreenSize()
+			capture := currentCapture()
+			input := capture.Input(captureParams{Width: width, Height: height, FPS: fps, DrawMouse: drawMouse})
+			inputArgs := input.Args
+
+			useH264 := VideoCodec == "h264" || VideoCodec == "h264_nvenc"
+			useH265 := VideoCodec == "h265" || VideoCodec == "h265_nvenc"
+			outputArgs := buildOutputArgs(joinFilters(input.Filter, cropFilter(), bannerFilter()), mode, bw, quality, fps, vbr, mpdecimate, cpuEffort, cpuThreads, keyframeInterval, contentTune)
+
+			log.Printf("Starting ffmpeg capture (%s) from %s via %s at %s target...", VideoCodec, Display, capture.Name(), mode)
+
+			initialArgs := []string{
+				"-probesize", "32",
+				"-analyzeduration", "0",
+				"-fflags", "nobuffer+genpts",
+				"-threads", "2",
+			}
+			if !UseDebugFFmpeg {
+				initialArgs = append(initialArgs, "-nostats")
+			}
+			initialArgs = append(initialArgs, hwDeviceArgs(VideoCodec)...)
+
+			args := append(initialArgs, inputArgs...)
+			if vbr {
+				args = append(args, "-fps_mode", "vfr")
+			}
+			log.Printf("ffmpeg args: %v", args)
+			args = append(args, outputArgs...)
+
+			cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+			cmd.Env = append(os.Environ(), "DISPLAY="+Display)
+			cmd.Stdin = input.Stdin
+
+			stdout, err := cmd.StdoutPipe()
+			var stderr io.ReadCloser
+			if err == nil {
+				stderr, err = cmd.StderrPipe()
+			}
+
+			ffmpegMutex.Lock()
+			ffmpegStreamID++
+			currentStreamID := ffmpegStreamID
+			ffmpegCmd = cmd
+			ffmpegMutex.Unlock()
+
+			run := beginEncoderRun(args)
+			if err == nil {
+				err = cmd.Start()
+			}
+			if err != nil {
+				// Counted as a crash, so it is retried with a backoff.
+				log.Printf("Failed to start ffmpeg: %v", err)
+				run.finish(err)
+				if !waitEncoderRetry(ctx, encoderRunEnded(run, err)) {
+					break
+				}
+				continue
+			}
+			markEncoderFrame()
+
+			// Keep and log stderr in background
+			stderrDone := make(chan struct{})
+			go func() {
+				defer close(stderrDone)
+				run.capture(stderr)
+			}()
+
+			// Start frame splitting in a bounded way
+			doneCh := make(chan struct{})
+			emitFrame := func(frame []byte) {
+				markEncoderFrame()
+				clearEncoderError()
+				encodedFrames.Add(1)
+				onFrame(frame, currentStreamID)
+			}
+			go func() {
+				defer close(doneCh)
+				panicked := runRecovered("ffmpeg reader", func() {
+					if useH264 {
+						splitH264AnnexB(stdout, emitFrame)
+					} else if useH265 {
+						splitH265AnnexB(stdout, emitFrame)
+					} else {
+						// Both VP8 and AV1 use IVF splitter
+						splitIVF(stdout, emitFrame)
+					}
+				})
+				if panicked {
+					// Nothing reads its output any more; the loop
+					// restarts it.
+					_ = cmd.Process.Kill()
+				}
+			}()
+
+			// Wait for splitter to finish reading pipeline to avoid Wait closing stdout prematurely
+			<-doneCh
+			<-stderrDone
+
+			err = cmd.Wait()
+			run.finish(err)
+			log.Printf("ffmpeg exited: %v", err)
+
+			if ctx.Err() != nil {
+				break
+			}
+			delay := encoderRunEnded(run, err)
+			if err != nil && ffmpegCrashed(err) {
+				fireHook(HookFFmpegCrash, map[string]interface{}{"error": err.Error(), "codec": VideoCodec, "stderr": run.stderrTail(encoderErrorLines, encoderErrorBytes)})
+			}
+			waitEncoderRetry(ctx, delay)
+		}
+	})
+	return nil
+}
+
+func isNVENCCodec(codec string) bool {
+	return codec == "h264_nvenc" || codec == "h265_nvenc" || codec == "av1_nvenc"
+}
+
+// buildOutputArgs returns the filter chain and encoder arguments for the
+// current VideoCodec and Chroma. The chain starts with captureFilter, the
+// capture source's own filters, if any.
+func buildOutputArgs(captureFilter string, mode string, bw int, quality int, fps int, vbr bool, mpdecimate bool, cpuEffort int, cpuThreads int, keyframeInterval int, contentTune string) []string {
+	useNVENC := isNVENCCodec(VideoCodec)
+
+	var filterStr string
+	if captureFilter != "" {
+		filterStr = captureFilter + ","
+	}
+	if mpdecimate {
+		filterStr += "mpdecimate=max=15,setpts=N/FRAME_RATE/TB"
+	} else {
+		filterStr += "setpts=N/FRAME_RATE/TB"
+	}
+	if sceneChangeKeyframes() {
+		// scdet tags frames that differ strongly from their predecessor
+		// (window switches, full-screen repaints) and -force_key_frames
+		// scd_metadata turns them into keyframes on top of the -g interval.
+		filterStr += fmt.Sprintf(",scdet=threshold=%d", SceneChangeThreshold)
+	}
+
+	outputArgs := []string{}
+	if useNVENC {
+		if filterStr != "" {
+			filterStr += ","
+		}
+		// For NVENC, ensure even dimensions on CPU, then upload to GPU.
+		if Chroma == "444" {
+			// CPU-side format=yuv444p is required because:
+			// 1. NVENC won't auto-convert BGR0→YUV444p even with high444p profile
+			// 2. scale_cuda doesn't support rgb0→yuv444p conversion
+			// This does increase CPU usage at high resolutions (~50-85%).
+			filterStr += "scale=trunc(iw/2)*2:trunc(ih/2)*2,format=yuv444p,hwupload_cuda"
+		} else {
+			filterStr += "scale=trunc(iw/2)*2:trunc(ih/2)*2,hwupload_cuda"
+		}
+		outputArgs = append(outputArgs, "-vf", filterStr)
+	} else {
+		if filterStr != "" {
+			filterStr += ","
+		}
+		if gpu := gpuScaleFilter(activeGPUScale(), Chroma); gpu != "" {
+			filterStr += gpu
+		} else if Chroma == "444" {
+			filterStr += "scale=trunc(iw/2)*2:trunc(ih/2)*2,format=yuv444p"
+		} else {
+			filterStr += "scale=trunc(iw/2)*2:trunc(ih/2)*2,format=yuv420p"
+		}
+		outputArgs = append(outputArgs, "-vf", filterStr)
+	}
+
+	useH264 := VideoCodec == "h264" || VideoCodec == "h264_nvenc"
+	useH265 := VideoCodec == "h265" || VideoCodec == "h265_nvenc"
+	useAV1 := VideoCodec == "av1" || VideoCodec == "av1_nvenc"
+
+	if useH264 {
+		outputArgs = append(outputArgs, buildH264Args(mode, bw, quality, fps, vbr, keyframeInterval, contentTune)...)
+	} else if useH265 {
+		outputArgs = append(outputArgs, buildH265Args(mode, bw, quality, fps, vbr, keyframeInterval, contentTune)...)
+	} else if useAV1 {
+		outputArgs = append(outputArgs, buildAV1Args(mode, bw, quality, fps, cpuThreads, vbr, keyframeInterval, contentTune)...)
+	} else {
+		outputArgs = append(outputArgs, buildVP8Args(mode, bw, quality, fps, cpuEffort, cpuThreads, vbr, keyframeInterval, contentTune)...)
+	}
+	if sceneChangeKeyframes() {
+		outputArgs = append([]string{"-force_key_frames", "scd_metadata"}, outputArgs...)
+	}
+	return outputArgs
+}
+
+// ffmpegCrashed reports whether ffmpeg exited on its own, or failed to
+// start, rather than being killed by us to apply new settings; everything
+// that restarts it uses SIGKILL.
+func ffmpegCrashed(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return true
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return !ok || !status.Signaled() || status.Signal() != syscall.SIGKILL
+}
@@ -0,0 +1,144 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+// audioInputArgs returns the ffmpeg input for audio: the session's
+// PulseAudio server, or in test-pattern mode a 440 Hz tone with a 1760 Hz
+// beep at the start of every second, so the audio path and its sync with
+// the once-per-second changes of the test pattern can be checked without
+// PulseAudio. device is the AudioDevice to capture (audiodevice.go).
+func audioInputArgs(device string) []string {
+	if TestPattern {
+		return []string{"-re", "-f", "lavfi", "-i", "sine=frequency=440:beep_factor=4:sample_rate=48000"}
+	}
+	return []string{"-f", "pulse", "-i", audioSourceName(device)}
+}
+
+// validOpusFrameDuration reports whether ms is a frame duration the
+// stream supports. Opus also has 40 and 60 ms frames, but they only add
+// latency.
+func validOpusFrameDuration(ms float64) bool {
+	return ms == 2.5 || ms == 5 || ms == 10 || ms == 20
+}
+
+// opusArgs returns the libopus encoder options. FEC only adds redundancy
+// when the encoder expects losses, so it comes with an expected loss rate.
+func opusArgs(bitrate string, frameDuration float64, fec, dtx bool) []string {
+	if !validOpusFrameDuration(frameDuration) {
+		frameDuration = 20
+	}
+	args := []string{
+		"-c:a", "libopus",
+		"-b:a", bitrate,
+		"-frame_duration", strconv.FormatFloat(frameDuration, 'f', -1, 64),
+	}
+	if frameDuration < 10 {
+		// The CELT-only mode is the one that supports frames under 10 ms.
+		args = append(args, "-application", "lowdelay")
+	}
+	if fec {
+		args = append(args, "-fec", "1", "-packet_loss", "10")
+	}
+	if dtx {
+		args = append(args, "-dtx", "1")
+	}
+	return args
+}
+
+func startAudioStreaming(ctx context.Context) {
+	goWorker(func() {
+		for ctx.Err() == nil {
+			ffmpegMutex.Lock()
+			enableAudio := EnableAudio
+			audioBitrate := AudioBitrate
+			audioDevice := AudioDevice
+			frameDuration := AudioFrameDuration
+			fec := AudioFEC
+			dtx := AudioDTX
+			ffmpegMutex.Unlock()
+			if !enableAudio {
+				sleepCtx(ctx, 2*time.Second)
+				continue
+			}
+
+			log.Println("Starting ffmpeg audio capture...")
+			args := append(audioInputArgs(audioDevice), opusArgs(audioBitrate, frameDuration, fec, dtx)...)
+			args = append(args,
+				"-page_duration", "20",
+				"-f", "ogg",
+				"pipe:1",
+			)
+			cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+			ffmpegMutex.Lock()
+			ffmpegAudioCmd = cmd
+			ffmpegMutex.Unlock()
+
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				log.Printf("Failed to get audio stdout: %v", err)
+				sleepCtx(ctx, 5*time.Second)
+				continue
+			}
+
+			if err := cmd.Start(); err != nil {
+				log.Printf("Failed to start audio ffmpeg: %v", err)
+				sleepCtx(ctx, 5*time.Second)
+				continue
+			}
+
+			ogg, _, err := oggreader.NewWith(stdout)
+			if err != nil {
+				log.Printf("Failed to create ogg reader: %v", err)
+				cmd.Process.Kill()
+				cmd.Wait()
+				sleepCtx(ctx, 5*time.Second)
+				continue
+			}
+
+			var lastGranule uint64
+			var timeline audioTimeline
+			for {
+				pageData, pageHeader, err := ogg.ParseNextPage()
+				if err != nil {
+					break
+				}
+				arrived := time.Now()
+
+				// Pages without samples are the stream's headers.
+				if pageHeader.GranulePosition <= lastGranule {
+					continue
+				}
+				start := lastGranule
+				lastGranule = pageHeader.GranulePosition
+				ts, ok := timeline.stamp(start, lastGranule, arrived)
+
+				videoTrackMutex.RLock()
+				at := audioTrack
+				videoTrackMutex.RUnlock()
+
+				// With DTX, silence is encoded as packets of one or two
+				// bytes, which need not be sent (RFC 7587); the receiver
+				// fills the gap with comfort noise.
+				if dtx && len(pageData) <= 2 {
+					continue
+				}
+				// Nothing is heard of a locked session.
+				if at != nil && ok && !sessionLocked.Load() {
+					_ = at.writeAt(pageData, ts)
+				}
+			}
+
+			cmd.Wait()
+			sleepCtx(ctx, 2*time.Second)
+		}
+	})
+}
@@ -1,10 +1,10 @@
-package main
+package llrdc
 
 import (
 	"fmt"
 )
 
-func buildAV1Args(mode string, bw int, quality int, fps int, vbr bool, keyframeInterval int) []string {
+func buildAV1Args(mode string, bw int, quality int, fps int, cpuThreads int, vbr bool, keyframeInterval int, contentTune string) []string {
 	var outputArgs []string
 
 	if VideoCodec == "av1_nvenc" {
@@ -15,6 +15,17 @@ func buildAV1Args(mode string, bw int, quality int, fps int, vbr bool, keyframeI
 	} else {
 		// libaom-av1 is slow, but we provide it as a software fallback
 		outputArgs = append(outputArgs, "-c:v", "libaom-av1", "-cpu-used", "8", "-usage", "realtime", "-row-mt", "1", "-lag-in-frames", "0", "-error-resilient", "1")
+		// Tile columns let the threads work on one frame side by side.
+		tileColumns := 0
+		for 1<<(tileColumns+1) <= cpuThreads && tileColumns < 2 {
+			tileColumns++
+		}
+		outputArgs = append(outputArgs, "-threads", fmt.Sprintf("%d", cpuThreads), "-tile-columns", fmt.Sprintf("%d", tileColumns))
+		if contentTune == "text" {
+			// Enables palette and intra block copy, which code UI and text far
+			// more compactly than natural-image tools.
+			outputArgs = append(outputArgs, "-tune-content", "screen")
+		}
 	}
 
 	if mode == "bandwidth" {
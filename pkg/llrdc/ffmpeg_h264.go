@@ -1,4 +1,4 @@
-package main
+package llrdc
 
 import (
 	"fmt"
@@ -6,7 +6,7 @@ import (
 	"log"
 )
 
-func buildH264Args(mode string, bw int, quality int, fps int, vbr bool, keyframeInterval int) []string {
+func buildH264Args(mode string, bw int, quality int, fps int, vbr bool, keyframeInterval int, contentTune string) []string {
 	var outputArgs []string
 
 	if VideoCodec == "h264_nvenc" {
@@ -16,6 +16,10 @@ func buildH264Args(mode string, bw int, quality int, fps int, vbr bool, keyframe
 			}
 	} else {
 			x264Params := fmt.Sprintf("aud=1:fps=%d", fps)
+			if contentTune == "text" {
+				// Weaker deblocking keeps glyph edges crisp.
+				x264Params += ":deblock=-2,-2"
+			}
 	        outputArgs = append(outputArgs, "-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency", "-x264-params", x264Params, "-level", "6.0")
 			if Chroma == "444" {
 				outputArgs = append(outputArgs, "-profile:v", "high444")
@@ -79,6 +83,8 @@ func buildH264Args(mode string, bw int, quality int, fps int, vbr bool, keyframe
 	return outputArgs
 }
 
+// splitH264AnnexB passes each access unit of an H.264 Annex B stream to
+// onFrame, preceded by frameHeadroom spare bytes.
 func splitH264AnnexB(reader io.Reader, onFrame func([]byte)) {
 	buffer := make([]byte, 0, 1024*1024)
 	temp := make([]byte, 16384)
@@ -107,8 +113,8 @@ func splitH264AnnexB(reader io.Reader, onFrame func([]byte)) {
 				}
 
 				if nextIdx > 0 {
-					frame := make([]byte, nextIdx)
-					copy(frame, buffer[:nextIdx])
+					frame := newFrameBuffer(nextIdx)
+					copy(frame[frameHeadroom:], buffer[:nextIdx])
 					onFrame(frame)
 
 					newBuf := make([]byte, len(buffer)-nextIdx)
@@ -130,8 +136,8 @@ func splitH264AnnexB(reader io.Reader, onFrame func([]byte)) {
 					}
 
 					if endIdx != -1 {
-						frame := make([]byte, endIdx)
-						copy(frame, buffer[:endIdx])
+						frame := newFrameBuffer(endIdx)
+						copy(frame[frameHeadroom:], buffer[:endIdx])
 						onFrame(frame)
 
 						newBuf := make([]byte, len(buffer)-endIdx)
@@ -150,7 +156,9 @@ func splitH264AnnexB(reader io.Reader, onFrame func([]byte)) {
 				log.Printf("Error reading H264 stream: %v", err)
 			}
 			if len(buffer) > 0 {
-				onFrame(buffer)
+				frame := newFrameBuffer(len(buffer))
+				copy(frame[frameHeadroom:], buffer)
+				onFrame(frame)
 			}
 			return
 		}
@@ -1,4 +1,4 @@
-package main
+package llrdc
 
 import (
 	"fmt"
@@ -6,7 +6,7 @@ import (
 	"log"
 )
 
-func buildH265Args(mode string, bw int, quality int, fps int, vbr bool, keyframeInterval int) []string {
+func buildH265Args(mode string, bw int, quality int, fps int, vbr bool, keyframeInterval int, contentTune string) []string {
 	var outputArgs []string
 
 	if VideoCodec == "h265_nvenc" {
@@ -16,6 +16,10 @@ func buildH265Args(mode string, bw int, quality int, fps int, vbr bool, keyframe
 			}
 	} else {
 			x265Params := fmt.Sprintf("aud=1:fps=%d", fps)
+			if contentTune == "text" {
+				// Weaker deblocking keeps glyph edges crisp.
+				x265Params += ":deblock=-2,-2"
+			}
 	        outputArgs = append(outputArgs, "-c:v", "libx265", "-preset", "ultrafast", "-tune", "zerolatency", "-x265-params", x265Params)
 			if Chroma == "444" {
 				outputArgs = append(outputArgs, "-profile:v", "main444-8")
@@ -77,6 +81,8 @@ func buildH265Args(mode string, bw int, quality int, fps int, vbr bool, keyframe
 	return outputArgs
 }
 
+// splitH265AnnexB passes each access unit of an H.265 Annex B stream to
+// onFrame, preceded by frameHeadroom spare bytes.
 func splitH265AnnexB(reader io.Reader, onFrame func([]byte)) {
 	buffer := make([]byte, 0, 1024*1024)
 	temp := make([]byte, 16384)
@@ -103,8 +109,8 @@ func splitH265AnnexB(reader io.Reader, onFrame func([]byte)) {
 				}
 
 				if nextIdx != -1 {
-					frame := make([]byte, nextIdx)
-					copy(frame, buffer[:nextIdx])
+					frame := newFrameBuffer(nextIdx)
+					copy(frame[frameHeadroom:], buffer[:nextIdx])
 					onFrame(frame)
 
 					newBuf := make([]byte, len(buffer)-nextIdx)
@@ -120,7 +126,9 @@ func splitH265AnnexB(reader io.Reader, onFrame func([]byte)) {
 				log.Printf("Error reading H265 stream: %v", err)
 			}
 			if len(buffer) > 0 {
-				onFrame(buffer)
+				frame := newFrameBuffer(len(buffer))
+				copy(frame[frameHeadroom:], buffer)
+				onFrame(frame)
 			}
 			return
 		}
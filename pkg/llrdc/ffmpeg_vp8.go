@@ -1,4 +1,4 @@
-package main
+package llrdc
 
 import (
 	"encoding/binary"
@@ -7,11 +7,20 @@ import (
 	"log"
 )
 
-func buildVP8Args(mode string, bw int, quality int, fps int, cpuEffort int, cpuThreads int, vbr bool, keyframeInterval int) []string {
+func buildVP8Args(mode string, bw int, quality int, fps int, cpuEffort int, cpuThreads int, vbr bool, keyframeInterval int, contentTune string) []string {
 	var outputArgs []string
 
 	outputArgs = append(outputArgs, "-c:v", "libvpx")
 
+	// Blocks whose change stays below the static threshold are skipped.
+	// Text mode raises it so cursor blinks and anti-aliasing noise do not
+	// cost a re-encode of an otherwise static editor.
+	staticThresh := "1000"
+	if contentTune == "text" {
+		staticThresh = "4000"
+		outputArgs = append(outputArgs, "-screen-content-mode", "1", "-sharpness", "7")
+	}
+
 	if mode == "bandwidth" {
 		bitrateStr := fmt.Sprintf("%dk", bw*1000)
 		bufSizeStr := fmt.Sprintf("%dk", bw*200)
@@ -23,7 +32,7 @@ func buildVP8Args(mode string, bw int, quality int, fps int, cpuEffort int, cpuT
 				"-maxrate", bitrateStr,
 				"-bufsize", bufSizeStr,
 				"-crf", "20",
-				"-static-thresh", "1000",
+				"-static-thresh", staticThresh,
 			)
 		} else {
 			// CBR
@@ -44,7 +53,7 @@ func buildVP8Args(mode string, bw int, quality int, fps int, cpuEffort int, cpuT
 		outputArgs = append(outputArgs,
 			"-b:v", "2M",
 			"-crf", fmt.Sprintf("%d", crf),
-			"-static-thresh", "1000",
+			"-static-thresh", staticThresh,
 		)
 
 		maxKbps := 2000 + (quality-10)*18000/90
@@ -76,6 +85,8 @@ func buildVP8Args(mode string, bw int, quality int, fps int, cpuEffort int, cpuT
 	return outputArgs
 }
 
+// splitIVF passes each frame of an IVF stream to onFrame, preceded by
+// frameHeadroom spare bytes.
 func splitIVF(reader io.Reader, onFrame func([]byte)) {
 	headerData := make([]byte, 32)
 	if _, err := io.ReadFull(reader, headerData); err != nil {
@@ -97,8 +108,8 @@ func splitIVF(reader io.Reader, onFrame func([]byte)) {
 		}
 
 		frameSize := binary.LittleEndian.Uint32(frameHeader[0:4])
-		frameData := make([]byte, frameSize)
-		if _, err := io.ReadFull(reader, frameData); err != nil {
+		frameData := newFrameBuffer(int(frameSize))
+		if _, err := io.ReadFull(reader, frameData[frameHeadroom:]); err != nil {
 			log.Printf("Error reading frame data: %v", err)
 			return
 		}
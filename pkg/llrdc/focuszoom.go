@@ -0,0 +1,85 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// Follow-focus zoom. With {"type":"crop","window":"focus","aspect":0.56}
+// the stream crops to whichever window has the focus, as the window manager
+// announces it in the root window's _NET_ACTIVE_WINDOW property (EWMH), and
+// scales and pads it to the viewer's aspect ratio, so a phone shows the
+// application rather than a shrunken desktop. The watcher below tracks the
+// property and wakes the crop follower (crop.go) when it changes; focus on
+// no window (0) leaves the crop on the last one.
+
+// activeWindowID is the X window in _NET_ACTIVE_WINDOW, or 0.
+var activeWindowID atomic.Uint32
+
+func startFocusWatcher(ctx context.Context, display string) {
+	goWorker(func() {
+		var X *xgb.Conn
+		var err error
+		for i := 0; i < 10; i++ {
+			if !sleepCtx(ctx, 2*time.Second) {
+				return
+			}
+			X, err = xgb.NewConnDisplay(display)
+			if err == nil {
+				break
+			}
+			log.Printf("Focus watcher attempt %d: failed to connect to X: %v", i+1, err)
+		}
+		if err != nil {
+			log.Printf("Focus watcher failed to initialize after retries")
+			return
+		}
+		defer X.Close()
+
+		root := xproto.Setup(X).DefaultScreen(X).Root
+		atomReply, err := xproto.InternAtom(X, false, uint16(len("_NET_ACTIVE_WINDOW")), "_NET_ACTIVE_WINDOW").Reply()
+		if err != nil {
+			log.Printf("Focus watcher: %v", err)
+			return
+		}
+		atom := atomReply.Atom
+		err = xproto.ChangeWindowAttributesChecked(X, root, xproto.CwEventMask, []uint32{xproto.EventMaskPropertyChange}).Check()
+		if err != nil {
+			log.Printf("Focus watcher: failed to watch the root window: %v", err)
+			return
+		}
+
+		readActive := func() {
+			reply, err := xproto.GetProperty(X, false, root, atom, xproto.AtomWindow, 0, 1).Reply()
+			if err != nil || reply.Format != 32 || reply.ValueLen != 1 {
+				return
+			}
+			if id := xgb.Get32(reply.Value); activeWindowID.Swap(id) != id {
+				wakeCropFollower()
+			}
+		}
+		readActive()
+
+		// Closing the connection unblocks WaitForEvent on shutdown.
+		stop := context.AfterFunc(ctx, X.Close)
+		defer stop()
+		for {
+			ev, err := X.WaitForEvent()
+			if ctx.Err() != nil {
+				return
+			}
+			if ev == nil && err == nil {
+				// The connection is gone, e.g. Xvfb was restarted.
+				return
+			}
+			if e, ok := ev.(xproto.PropertyNotifyEvent); ok && e.Atom == atom {
+				readActive()
+			}
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package llrdc
+
+import (
+	"log"
+	"math"
+)
+
+// Gamepad forwarding. The viewer samples the browser Gamepad API and sends
+//
+//	{"type":"gamepad","index":0,"buttons":[...],"axes":[...]}
+//
+// whenever a pad in the W3C "standard" layout changes, and
+// {"type":"gamepad","index":0,"connected":false} when it goes away. Each
+// pad becomes a uinput joystick that identifies as an Xbox 360 controller,
+// which SDL, Steam Input and most emulators map without configuration.
+
+const maxGamepads = 4
+
+// Event codes from linux/input-event-codes.h.
+const (
+	btnSouth  = 0x130
+	btnEast   = 0x131
+	btnNorth  = 0x133
+	btnWest   = 0x134
+	btnTL     = 0x136
+	btnTR     = 0x137
+	btnSelect = 0x13a
+	btnStart  = 0x13b
+	btnMode   = 0x13c
+	btnThumbL = 0x13d
+	btnThumbR = 0x13e
+
+	absX     = 0x00
+	absY     = 0x01
+	absZ     = 0x02
+	absRX    = 0x03
+	absRY    = 0x04
+	absRZ    = 0x05
+	absHat0X = 0x10
+	absHat0Y = 0x11
+)
+
+// gamepadButtonCodes maps standard-layout button indexes to key codes.
+// Triggers (6, 7) and the d-pad (12-15) are axes on an Xbox 360 pad.
+var gamepadButtonCodes = map[int]uint16{
+	0: btnSouth, 1: btnEast, 2: btnWest, 3: btnNorth,
+	4: btnTL, 5: btnTR, 8: btnSelect, 9: btnStart,
+	10: btnThumbL, 11: btnThumbR, 16: btnMode,
+}
+
+// gamepadStickCodes maps standard-layout axis indexes to stick axes.
+var gamepadStickCodes = [4]uint16{absX, absY, absRX, absRY}
+
+func gamepadSetup() uinputSetup {
+	s := uinputSetup{name: "Microsoft X-Box 360 pad", vendor: 0x045e, product: 0x028e}
+	for _, code := range gamepadButtonCodes {
+		s.keys = append(s.keys, code)
+	}
+	for _, code := range gamepadStickCodes {
+		s.abs = append(s.abs, uinputAbs{code: code, min: -32768, max: 32767, fuzz: 16, flat: 128})
+	}
+	s.abs = append(s.abs,
+		uinputAbs{code: absZ, max: 255},
+		uinputAbs{code: absRZ, max: 255},
+		uinputAbs{code: absHat0X, min: -1, max: 1},
+		uinputAbs{code: absHat0Y, min: -1, max: 1},
+	)
+	return s
+}
+
+type gamepad struct {
+	dev *uinputDevice
+	// last holds the values already sent, keyed by event type<<16 | code,
+	// so only changes are written.
+	last map[uint32]int32
+}
+
+func (p *gamepad) set(typ, code uint16, value int32) {
+	key := uint32(typ)<<16 | uint32(code)
+	if old, ok := p.last[key]; ok && old == value {
+		return
+	}
+	p.last[key] = value
+	_ = p.dev.emit(typ, code, value)
+}
+
+// gamepadSet holds the virtual pads of one viewer. It is only used from
+// that viewer's WebSocket read loop.
+type gamepadSet map[int]*gamepad
+
+func (s gamepadSet) handle(msg *gamepadMessage) {
+	if !EnableGamepad {
+		return
+	}
+	index := int(msg.Index)
+	if index < 0 || index >= maxGamepads {
+		return
+	}
+	if msg.Connected != nil && !*msg.Connected {
+		if p := s[index]; p != nil {
+			p.dev.Close()
+			delete(s, index)
+			log.Printf("Gamepad %d disconnected", index)
+		}
+		return
+	}
+
+	p := s[index]
+	if p == nil {
+		dev, err := newUinputDevice(gamepadSetup())
+		if err != nil {
+			log.Printf("Gamepad %d: %v", index, err)
+			return
+		}
+		p = &gamepad{dev: dev, last: make(map[uint32]int32)}
+		s[index] = p
+		log.Printf("Gamepad %d connected", index)
+	}
+
+	button := func(i int) float64 {
+		if i < len(msg.Buttons) {
+			return math.Min(math.Max(msg.Buttons[i], 0), 1)
+		}
+		return 0
+	}
+	for i, code := range gamepadButtonCodes {
+		pressed := int32(0)
+		if button(i) >= 0.5 {
+			pressed = 1
+		}
+		p.set(evKey, code, pressed)
+	}
+	p.set(evAbs, absZ, int32(math.Round(button(6)*255)))
+	p.set(evAbs, absRZ, int32(math.Round(button(7)*255)))
+	hat := func(neg, pos int) int32 {
+		return int32(math.Round(button(pos) - button(neg)))
+	}
+	p.set(evAbs, absHat0Y, hat(12, 13))
+	p.set(evAbs, absHat0X, hat(14, 15))
+
+	for i, code := range gamepadStickCodes {
+		if i < len(msg.Axes) {
+			v := msg.Axes[i]
+			p.set(evAbs, code, int32(math.Round(math.Min(math.Max(v, -1), 1)*32767)))
+		}
+	}
+	_ = p.dev.sync()
+}
+
+// Close removes all of the viewer's pads.
+func (s gamepadSet) Close() {
+	for index, p := range s {
+		p.dev.Close()
+		delete(s, index)
+	}
+}
@@ -0,0 +1,43 @@
+package llrdc
+
+import (
+	"math"
+	"sync"
+)
+
+// Touch gesture translation for applications without native touch
+// support. The viewer sends two-finger pans as pixel wheel input and
+// pinches as {"type":"pinch","scale":1.05}, the ratio of finger distances
+// since the previous pinch message; pinches become Ctrl+wheel clicks, which
+// browsers, editors, image viewers and office suites treat as zoom.
+
+// pinchStep is the scale change per Ctrl+wheel click, about what one click
+// zooms in most applications.
+const pinchStep = 1.1
+
+var (
+	pinchMutex     sync.Mutex
+	pinchRemainder float64
+)
+
+// injectPinch zooms by scale, carrying partial steps over to the next
+// pinch message like injectPixelScroll does for pans.
+func injectPinch(scale float64, display string) {
+	if !TouchGestures || scale <= 0 || math.IsInf(scale, 0) || math.IsNaN(scale) {
+		return
+	}
+	pinchMutex.Lock()
+	logScale := math.Log(scale)
+	if (pinchRemainder < 0) != (logScale < 0) {
+		pinchRemainder = 0
+	}
+	pinchRemainder += logScale
+	steps := math.Trunc(pinchRemainder / math.Log(pinchStep))
+	pinchRemainder -= steps * math.Log(pinchStep)
+	pinchMutex.Unlock()
+	if steps == 0 {
+		return
+	}
+	// Spreading the fingers zooms in, which is Ctrl+wheel up.
+	injectTask(inputTask{Type: "zoom", DY: -steps, Display: display})
+}
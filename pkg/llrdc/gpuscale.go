@@ -0,0 +1,137 @@
+package llrdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GPU-assisted conversion for the software encoders. Turning a 4K BGR0
+// capture into even-sized YUV costs ffmpeg several cores before the encoder
+// sees a pixel. With GPUScale the filter chain uploads each frame, scales
+// and converts it on the GPU and downloads the YUV frame for the encoder:
+// "vaapi" uses scale_vaapi on a DRM render node (Intel, AMD), "libplacebo"
+// uses Vulkan. "auto" tries them in that order once, if the host has a GPU,
+// with a short test conversion, and falls back to the CPU if neither works. VAAPI cannot produce
+// 4:4:4 frames, so with Chroma 444 it leaves the conversion on the CPU.
+// NVENC codecs already convert on the GPU and are not affected.
+
+const gpuScaleProbeTimeout = 10 * time.Second
+
+var (
+	gpuScaleOnce sync.Once
+	// gpuScaleMode is the working GPUScale backend, or "" for none.
+	gpuScaleMode string
+	vaapiDevice  string
+)
+
+// activeGPUScale returns the GPU scaling backend in use, probing it on
+// first use.
+func activeGPUScale() string {
+	gpuScaleOnce.Do(func() {
+		mode := strings.ToLower(strings.TrimSpace(GPUScale))
+		var candidates []string
+		switch mode {
+		case "", "off", "none":
+			return
+		case "auto":
+			candidates = []string{"vaapi", "libplacebo"}
+		case "vaapi", "libplacebo":
+			candidates = []string{mode}
+		default:
+			log.Printf("Warning: unknown GPU scaling mode %q, scaling on the CPU", GPUScale)
+			return
+		}
+		if nodes, _ := filepath.Glob("/dev/dri/renderD*"); len(nodes) > 0 {
+			vaapiDevice = nodes[0]
+		}
+		if mode == "auto" && vaapiDevice == "" {
+			// Without a GPU, Vulkan would be a software rasterizer.
+			if nvidia, _ := filepath.Glob("/dev/nvidia[0-9]*"); len(nvidia) == 0 {
+				return
+			}
+		}
+		for _, candidate := range candidates {
+			if err := probeGPUScale(candidate); err != nil {
+				if mode != "auto" {
+					log.Printf("Warning: GPU scaling with %s failed, scaling on the CPU: %v", candidate, err)
+				}
+				continue
+			}
+			log.Printf("Scaling and converting video on the GPU with %s", candidate)
+			gpuScaleMode = candidate
+			return
+		}
+	})
+	return gpuScaleMode
+}
+
+// probeGPUScale converts a test frame with backend.
+func probeGPUScale(backend string) error {
+	if backend == "vaapi" && vaapiDevice == "" {
+		return errors.New("no DRM render node")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gpuScaleProbeTimeout)
+	defer cancel()
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	args = append(args, gpuScaleDeviceArgs(backend)...)
+	args = append(args, "-f", "lavfi", "-i", "testsrc=size=256x256:rate=1", "-frames:v", "1",
+		"-vf", gpuScaleFilter(backend, "420"), "-f", "null", "-")
+	out, err := exec.CommandContext(ctx, ffmpegBinary(), args...).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// gpuScaleDeviceArgs returns the ffmpeg options that open backend's device
+// for the filters.
+func gpuScaleDeviceArgs(backend string) []string {
+	switch backend {
+	case "vaapi":
+		return []string{"-init_hw_device", "vaapi=va:" + vaapiDevice, "-filter_hw_device", "va"}
+	case "libplacebo":
+		return []string{"-init_hw_device", "vulkan=vk", "-filter_hw_device", "vk"}
+	}
+	return nil
+}
+
+// gpuScaleFilter returns the filters that turn a BGR0 frame into an
+// even-sized YUV frame with chroma (420 or 444) on backend's device, or ""
+// if it cannot produce that chroma.
+func gpuScaleFilter(backend, chroma string) string {
+	pixFmt := "yuv420p"
+	if chroma == "444" {
+		pixFmt = "yuv444p"
+	}
+	switch backend {
+	case "vaapi":
+		if chroma == "444" {
+			return ""
+		}
+		// VAAPI surfaces are NV12; splitting its chroma plane for the
+		// encoder is a copy.
+		return "format=bgr0,hwupload,scale_vaapi=w=trunc(iw/2)*2:h=trunc(ih/2)*2:format=nv12,hwdownload,format=nv12,format=" + pixFmt
+	case "libplacebo":
+		return "format=bgr0,hwupload,libplacebo=w=trunc(iw/2)*2:h=trunc(ih/2)*2:format=" + pixFmt + ",hwdownload,format=" + pixFmt
+	}
+	return ""
+}
+
+// hwDeviceArgs returns the ffmpeg options that open the GPU the filter
+// chain of buildOutputArgs uses for codec, if any.
+func hwDeviceArgs(codec string) []string {
+	if isNVENCCodec(codec) {
+		return []string{"-init_hw_device", "cuda=cu:0", "-filter_hw_device", "cu"}
+	}
+	if backend := activeGPUScale(); backend != "" && gpuScaleFilter(backend, Chroma) != "" {
+		return gpuScaleDeviceArgs(backend)
+	}
+	return nil
+}
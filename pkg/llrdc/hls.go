@@ -0,0 +1,68 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// LL-HLS/DASH output for passive viewers. ffmpeg's DASH muxer also writes a
+// low-latency HLS playlist. The segments can be served straight from /hls/
+// or pulled through a CDN, so large read-only audiences do not each need a
+// PeerConnection to the host.
+
+var hlsDir string
+
+var hlsSink = newRemuxSink("HLS",
+	func(codec string) bool {
+		return strings.HasPrefix(codec, "h264") || strings.HasPrefix(codec, "h265")
+	},
+	func(codec string) []string {
+		return []string{
+			"-f", "dash",
+			"-ldash", "1", "-lhls", "1", "-hls_playlist", "1",
+			"-streaming", "1",
+			"-seg_duration", "1", "-frag_type", "duration", "-frag_duration", "0.2",
+			"-window_size", "6", "-extra_window_size", "2",
+			"-use_template", "1", "-use_timeline", "0",
+			"-format_options", "movflags=cmaf",
+			hlsDir + "/manifest.mpd",
+		}
+	},
+)
+
+// startHLS runs the segmenter until ctx is cancelled and removes the
+// segments afterwards.
+func startHLS(ctx context.Context) {
+	if !EnableHLS {
+		return
+	}
+	dir, err := os.MkdirTemp("", "llrdc-hls-")
+	if err != nil {
+		log.Printf("HLS disabled: %v", err)
+		return
+	}
+	hlsDir = dir
+	log.Printf("HLS output enabled: /hls/master.m3u8 and /hls/manifest.mpd (segments in %s)", dir)
+	hlsSink.start(ctx, func() { os.RemoveAll(dir) })
+}
+
+// hlsHandler serves the playlists and segments. Playlists change constantly
+// and must not be cached; segments are immutable.
+func hlsHandler() http.Handler {
+	return http.StripPrefix("/hls/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hlsSink.running.Load() {
+			http.Error(w, "HLS output not running", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if strings.HasSuffix(r.URL.Path, ".m3u8") || strings.HasSuffix(r.URL.Path, ".mpd") {
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		http.FileServer(http.Dir(hlsDir)).ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,91 @@
+package llrdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	HookFirstClientConnected = "first_client_connected"
+	HookLastClientDisconnect = "last_client_disconnected"
+	HookFFmpegCrash          = "ffmpeg_crash"
+	HookXSessionCrash        = "x_session_crash"
+	hookTimeout              = 10 * time.Second
+)
+
+// fireHook notifies the configured hook URL and/or hook script about a
+// session lifecycle event. Hooks run in the background so a slow or
+// unreachable endpoint never blocks the caller.
+func fireHook(event string, data map[string]interface{}) {
+	if HookURL == "" && HookScript == "" {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"event":     event,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"display":   Display,
+		"port":      Port,
+	}
+	for k, v := range data {
+		payload[k] = v
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Hook %s: failed to encode payload: %v", event, err)
+		return
+	}
+
+	log.Printf("Firing hook: %s", event)
+
+	if HookURL != "" {
+		go postHook(event, body)
+	}
+	if HookScript != "" {
+		go execHook(event, body)
+	}
+}
+
+func postHook(event string, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, HookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Hook %s: invalid request: %v", event, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-LLrdc-Event", event)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Hook %s: POST to %s failed: %v", event, HookURL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Hook %s: POST to %s returned %s", event, HookURL, resp.Status)
+	}
+}
+
+// execHook runs the hook script with the event name in LLRDC_EVENT and the
+// JSON payload on stdin.
+func execHook(event string, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, HookScript, event)
+	cmd.Env = append(os.Environ(), "LLRDC_EVENT="+event, "DISPLAY="+Display)
+	cmd.Stdin = bytes.NewReader(body)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Hook %s: script %s failed: %v (%s)", event, HookScript, err, bytes.TrimSpace(out))
+	}
+}
@@ -0,0 +1,867 @@
+package llrdc
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// clientConn is a viewer's message connection: a *websocket.Conn, or a
+// WebTransport session (webtransport.go) or Server-Sent Events stream
+// (sse.go) that behaves like one. As with a WebSocket, writes must be
+// serialized by the caller.
+type clientConn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteJSON(v interface{}) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	Close() error
+}
+
+type Client struct {
+	conn        clientConn
+	mu          sync.Mutex
+	queue       *sendQueue
+	webrtcReady bool
+	// identity is the authenticated user, see IdentityHeader; guests
+	// have none and the share link they were admitted with instead.
+	identity    string
+	share       *shareLink
+	addr        string
+	connectedAt time.Time
+	// chunkedVideo selects the timestamped type 3 video packets over the
+	// legacy type 1 ones; awaitingKeyframe holds back delta frames until
+	// the client can decode again (after joining or a dropped frame).
+	chunkedVideo     bool
+	awaitingKeyframe bool
+	// requestedCap, serverCap and backpressureCap (sendqueue.go) are
+	// bandwidth caps in Mbps (0 for none); limiter and capTrack enforce
+	// the lowest of them, see bwcap.go.
+	requestedCap    float64
+	serverCap       float64
+	backpressureCap float64
+	limiter      *rateLimiter
+	videoSender  *webrtc.RTPSender
+	capTrack     *syncedTrack
+	// pc is the client's current PeerConnection, guarded by clientsMutex.
+	pc *webrtc.PeerConnection
+	// openURLs is set while the client wants links opened in the
+	// session (urlhandoff.go), guarded by clientsMutex.
+	openURLs bool
+
+	// flow fragments binary packets and limits how many are in flight
+	// (wsflow.go).
+	flow ackWindow
+
+	closeOnce  sync.Once
+	writerDone chan struct{}
+}
+
+// writeJSON and writeMessage serialize writes to the client's connection.
+// The unlock is deferred, so a panicking write (see panics.go) does not
+// leave the connection locked.
+func (c *Client) writeJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+func (c *Client) writeMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// Close removes the client and releases what it holds: its send queue and
+// writer goroutine, its connection and its PeerConnection. It is safe to
+// call more than once, from any goroutine but the writer.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		clientsMutex.Lock()
+		delete(clients, c.conn)
+		remaining := len(clients)
+		c.queue.close()
+		c.flow.close()
+		pc := c.pc
+		c.pc, c.videoSender, c.capTrack = nil, nil, nil
+		clientsMutex.Unlock()
+
+		// Closing the connection also fails a write the writer is stuck in.
+		c.conn.Close()
+		<-c.writerDone
+		if pc != nil {
+			pc.Close()
+		}
+
+		if remaining == 0 {
+			fireHook(HookLastClientDisconnect, map[string]interface{}{"remoteAddr": c.addr})
+		}
+	})
+}
+
+// Disconnect sends the client a close frame with code and reason, then
+// closes it. A writer stuck on a slow connection holds mu, and then the
+// close frame is skipped rather than waited for.
+func (c *Client) Disconnect(code int, reason string) {
+	if c.mu.TryLock() {
+		_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		c.mu.Unlock()
+	}
+	c.Close()
+}
+
+// clientList returns the connected clients, for acting on them without
+// holding clientsMutex.
+func clientList() []*Client {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	list := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+var clientsMutex sync.Mutex
+var clients = make(map[clientConn]*Client)
+
+// Presentation timestamps of chunked video packets count from the start of
+// the current encoder stream. Both are guarded by clientsMutex.
+var (
+	chunkStreamID    uint32
+	chunkStreamStart time.Time
+)
+
+const (
+	videoPacketHeaderSize = 9
+	videoChunkHeaderSize  = 22
+)
+
+// newHTTPServer starts the stats and clipboard broadcasters and returns the
+// HTTP server for the viewer and WebSocket endpoint.
+func newHTTPServer(ctx context.Context) *http.Server {
+	goWorker(func() {
+		for sleepCtx(ctx, 2*time.Second) {
+			cpuUsage := ffmpegCPUUsage()
+
+			statsMsg := map[string]interface{}{
+				"type": "stats",
+				"ffmpegCpu": cpuUsage,
+				"encoderRestarts": encoderWatchdogRestarts.Load(),
+				"captureFps": captureFPS.Load(),
+				"workerPanics": workerPanics.Load(),
+				"bufferedVideoBytes": bufferedVideo.used.Load(),
+				"encoder": encoderTuningStats(),
+			}
+
+			// Write outside clientsMutex, so a viewer that has fallen
+			// behind delays only its own stats, not the video of others.
+			for _, client := range clientList() {
+				client.mu.Lock()
+				_ = client.conn.WriteJSON(statsMsg)
+				client.mu.Unlock()
+			}
+		}
+	})
+
+	startClipboardPoller(ctx, Display, func(msg interface{}) {
+		broadcastJSONToControllers(msg)
+		vncBroadcastClipboard(msg)
+	})
+
+	mux := http.NewServeMux()
+	if MJPEGFPS > 0 {
+		mux.Handle("/mjpeg", newMJPEGHub(ctx, "MJPEG", MJPEGFPS, 0))
+	}
+	if ThumbnailFPS > 0 {
+		thumbs := newMJPEGHub(ctx, "Thumbnail", ThumbnailFPS, thumbnailWidth)
+		// Dashboards previewing many sessions load these cross-origin.
+		mux.HandleFunc("/thumbnail", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			thumbs.ServeHTTP(w, r)
+		})
+		mux.HandleFunc("/thumbnail.jpg", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			thumbs.ServeSnapshot(w, r)
+		})
+	}
+	if EnableHLS {
+		mux.Handle("/hls/", hlsHandler())
+	}
+	if DVRSeconds > 0 {
+		mux.HandleFunc("/replay", handleReplay)
+	}
+	if WebDAVDir != "" {
+		dav := webdavHandler(ctx)
+		mux.Handle(webdavPrefix, dav)
+		mux.Handle(webdavPrefix+"/", dav)
+	}
+	if EnableMacros {
+		macros := macroHandler()
+		mux.Handle(macroPrefix, macros)
+		mux.Handle(macroPrefix+"/", macros)
+	}
+	if EnableWebTransport {
+		mux.HandleFunc(webTransportPath, webTransportHandler)
+	}
+	if EnableWHEP {
+		whep := whepHandler(ctx)
+		mux.Handle(whepPrefix, whep)
+		mux.Handle(whepPrefix+"/", whep)
+	}
+	if EnableSSESignaling {
+		signal := sseHandler(ctx)
+		mux.Handle(ssePrefix, signal)
+		mux.Handle(ssePrefix+"/", signal)
+	}
+	if EnableShareLinks && IdentityHeader == "" {
+		log.Printf("Warning: share links need --identity-header; not enabling them")
+	}
+	if shareLinksEnabled() {
+		shares := shareHandler()
+		mux.Handle(sharePrefix, shares)
+		mux.Handle(sharePrefix+"/", shares)
+	}
+	mux.HandleFunc("/protocol.json", handleProtocolSchema)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			wsHandler(w, r)
+			return
+		}
+
+		log.Printf("HTTP %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			serveStatic(w, r)
+			return
+		}
+		http.Error(w, "Not Found", http.StatusNotFound)
+	})
+
+	var handler http.Handler = mux
+	if shareLinksEnabled() {
+		handler = shareGate(mux)
+	}
+	return &http.Server{Addr: httpListenAddr(), Handler: handler}
+}
+
+// httpListenAddr is the address the HTTP server listens on, HTTPAddr or all
+// interfaces, and Port.
+func httpListenAddr() string {
+	return net.JoinHostPort(HTTPAddr, strconv.Itoa(Port))
+}
+
+// ffmpegCPUUsage returns the CPU usage of the video encoder process in
+// percent, or 0 if it is not running.
+func ffmpegCPUUsage() float64 {
+	ffmpegMutex.Lock()
+	cmd := ffmpegCmd
+	ffmpegMutex.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return 0
+	}
+	out, err := exec.Command("ps", "-p", strconv.Itoa(cmd.Process.Pid), "-o", "%cpu=").Output()
+	if err != nil {
+		return 0
+	}
+	val, _ := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	return val
+}
+
+// closeAllClients sends a close frame to every WebSocket client and closes
+// it, so their handlers return.
+func closeAllClients() {
+	for _, client := range clientList() {
+		client.Disconnect(websocket.CloseGoingAway, "server shutdown")
+	}
+}
+
+func broadcastJSON(msg interface{}) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for _, client := range clients {
+		client.mu.Lock()
+		_ = client.conn.WriteJSON(msg)
+		client.mu.Unlock()
+	}
+}
+
+// broadcastVideoFrame hands an encoded frame to every consumer. They all
+// share its buffer, see Frame.
+func broadcastVideoFrame(f Frame) {
+	if sessionLocked.Load() {
+		return
+	}
+	frame, streamID := f.Data, f.StreamID
+	captureTime := time.Now()
+	WriteWebRTCFrame(frame, captureTime)
+	writeRemuxFrame(frame)
+	recordDVRFrame(frame, captureTime)
+
+	timestamp := float64(captureTime.UnixNano()) / float64(time.Millisecond)
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	// The first WebSocket packet is built in the frame's headroom. The two
+	// packet formats have headers of different sizes, so if clients of
+	// both are connected the second format needs a copy.
+	headroom := f.packet
+	var packet, chunk []byte
+	key, keyChecked := false, false
+	for _, client := range clients {
+		if client.webrtcReady {
+			continue // Skip sending heavy binary frames if WebRTC is handling it
+		}
+		if !keyChecked && (client.limiter != nil || client.chunkedVideo) {
+			key = isKeyframe(VideoCodec, frame)
+			keyChecked = true
+		}
+		if client.limiter != nil && !client.limiter.allow(len(frame), key, captureTime) {
+			continue
+		}
+		if !client.chunkedVideo {
+			if packet == nil {
+				packet, headroom = buildVideoPacket(frame, headroom, timestamp), nil
+			}
+			// Drop frame if client websocket buffer is full to prevent blocking ffmpeg
+			client.queue.push(packet)
+			continue
+		}
+
+		if chunk == nil {
+			chunk, headroom = buildVideoChunk(frame, headroom, streamID, captureTime, timestamp, key), nil
+		}
+		if client.awaitingKeyframe && !key {
+			continue
+		}
+		// Later delta frames would reference a dropped one.
+		client.awaitingKeyframe = !client.queue.push(chunk)
+	}
+}
+
+// buildVideoChunk frames an encoded access unit for WebCodecs clients:
+//
+//	[3][flags u8][streamID u32][pts u64 µs][capture time f64 ms][data...]
+//
+// Bit 0 of flags marks keyframes. The pts starts at zero for each encoder
+// stream and only increases, as EncodedVideoChunk timestamps should. The
+// chunk is built in headroom if there is one, see withHeader. The caller
+// must hold clientsMutex.
+func buildVideoChunk(frame, headroom []byte, streamID uint32, captureTime time.Time, captureMs float64, key bool) []byte {
+	if streamID != chunkStreamID || chunkStreamStart.IsZero() {
+		chunkStreamID = streamID
+		chunkStreamStart = captureTime
+	}
+	pts := captureTime.Sub(chunkStreamStart).Microseconds()
+
+	chunk := withHeader(frame, headroom, videoChunkHeaderSize)
+	chunk[0] = 3 // Video chunk type
+	if key {
+		chunk[1] = 1
+	}
+	binary.BigEndian.PutUint32(chunk[2:], streamID)
+	binary.BigEndian.PutUint64(chunk[6:], uint64(pts))
+	binary.BigEndian.PutUint64(chunk[14:], math.Float64bits(captureMs))
+	return chunk
+}
+
+// buildVideoPacket frames an encoded access unit for legacy clients as
+// [1][capture time in ms, float64][frame].
+func buildVideoPacket(frame, headroom []byte, captureMs float64) []byte {
+	packet := withHeader(frame, headroom, videoPacketHeaderSize)
+	packet[0] = 1 // Video Type
+	binary.BigEndian.PutUint64(packet[1:], math.Float64bits(captureMs))
+	return packet
+}
+
+// withHeader returns frame preceded by size bytes for a header. It uses
+// headroom, frame's buffer with frameHeadroom spare bytes in front, if
+// there is one, and copies the frame otherwise.
+func withHeader(frame, headroom []byte, size int) []byte {
+	if headroom != nil {
+		return headroom[frameHeadroom-size:]
+	}
+	packet := make([]byte, size+len(frame))
+	copy(packet[size:], frame)
+	return packet
+}
+
+// configPayload is the "config" message: the session's settings as a
+// viewer sees them.
+func configPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"type":             "config",
+		"webcam":           WebcamDevice != "",
+		"gamepad":          EnableGamepad,
+		"pen":              EnablePen,
+		"touch_gestures":   TouchGestures,
+		"videoCodec":       VideoCodec,
+		"chroma":           Chroma,
+		"gpuAvailable":     UseGPU,
+		"av1NvencAvailable":    AV1NVENCAvailable,
+		"h264Nvenc444Available": H264NVENC444Available,
+		"h265Nvenc444Available": H265NVENC444Available,
+		"framerate":        FPS,
+		"bandwidth":        targetBandwidthMbps,
+		"quality":          targetQuality,
+		"vbr":              targetVBR,
+		"mpdecimate":       targetMpdecimate,
+		"keyframe_interval": targetKeyframeInterval,
+		"content_tune":      targetContentTune,
+		"enableClipboard":   EnableClipboard,
+		"enable_hybrid":     EnableHybrid,
+		"settle_time":       SettleTime,
+		"tile_size":         TileSize,
+		"enable_audio":      EnableAudio,
+		"audio_bitrate":     AudioBitrate,
+		"audio_device":      AudioDevice,
+		"audio_frame_duration": AudioFrameDuration,
+		"audio_fec":         AudioFEC,
+		"audio_dtx":         AudioDTX,
+		"hdpi":              HDPI,
+		"dpi":               sessionDPI(),
+		"rotation":          GetRotation(),
+		"crop":              cropConfig(),
+		"auto_quality":      targetAutoQuality,
+		"ice_servers":       stunURLs(),
+	}
+}
+
+func broadcastConfig(restarted bool) {
+	configMsg := configPayload()
+	configMsg["restarted"] = restarted
+	broadcastJSON(configMsg)
+}
+
+// applyConfigMessage applies the settings in a viewer "config" message, or
+// the same settings from the control API, and broadcasts the result. The
+// encoder restarts at most once, with every setting the message changed.
+func applyConfigMessage(msg configMessage) {
+	restarted := applyEncoderChanges(func() { applyConfigSettings(msg) })
+	broadcastConfig(restarted)
+}
+
+func applyConfigSettings(msg configMessage) {
+	if msg.HDPI != nil {
+		hdpi := int(*msg.HDPI)
+		log.Printf("Received HDPI config: %d%%", hdpi)
+		if HDPI != hdpi || DPI != 0 {
+			// An explicit scaling percentage replaces any DPI set
+			// with "dpi".
+			HDPI = hdpi
+			DPI = 0
+			applyDPISettings(sessionEnviron(Display))
+		}
+	}
+	if msg.DPI != nil {
+		log.Printf("Received DPI config: %d", int(*msg.DPI))
+		SetDPI(int(*msg.DPI))
+	}
+	if msg.Rotation != nil {
+		log.Printf("Received rotation config: %s", *msg.Rotation)
+		rotateDisplay(*msg.Rotation)
+	}
+	if msg.VideoCodec != nil {
+		log.Printf("Received Video Codec config: %s", *msg.VideoCodec)
+		SetVideoCodec(*msg.VideoCodec)
+	}
+	if msg.Chroma != nil {
+		log.Printf("Received Chroma config: %s", *msg.Chroma)
+		SetChroma(*msg.Chroma)
+	}
+	if msg.VBR != nil {
+		log.Printf("Received VBR config: %v", *msg.VBR)
+		SetVBR(*msg.VBR)
+	}
+	if msg.Mpdecimate != nil {
+		log.Printf("Received mpdecimate config: %v", *msg.Mpdecimate)
+		SetMpdecimate(*msg.Mpdecimate)
+	}
+	if msg.KeyframeInterval != nil {
+		interval := int(*msg.KeyframeInterval)
+		log.Printf("Received keyframe interval config: %d", interval)
+		SetKeyframeInterval(interval)
+	}
+	if msg.ContentTune != nil {
+		log.Printf("Received content tune config: %s", *msg.ContentTune)
+		SetContentTune(*msg.ContentTune)
+	}
+	if msg.CPUEffort != nil {
+		effort := int(*msg.CPUEffort)
+		log.Printf("Received CPU effort config: %d", effort)
+		SetCpuEffort(effort)
+	}
+	if msg.CPUThreads != nil {
+		threads := int(*msg.CPUThreads)
+		log.Printf("Received CPU threads config: %d", threads)
+		SetCpuThreads(threads)
+	}
+	if msg.EnableDesktopMouse != nil {
+		log.Printf("Received Enable Desktop Mouse config: %v", *msg.EnableDesktopMouse)
+		SetDrawMouse(*msg.EnableDesktopMouse)
+	}
+	if msg.TouchGestures != nil && *msg.TouchGestures != TouchGestures {
+		log.Printf("Received touch gestures config: %v", *msg.TouchGestures)
+		TouchGestures = *msg.TouchGestures
+	}
+	if msg.EnableHybrid != nil {
+		log.Printf("Received Enable Hybrid Sharpness config: %v", *msg.EnableHybrid)
+		SetEnableHybrid(*msg.EnableHybrid)
+	}
+	if msg.SettleTime != nil {
+		log.Printf("Received Settle Time config: %vms", *msg.SettleTime)
+		SetSettleTime(int(*msg.SettleTime))
+	}
+	if msg.TileSize != nil {
+		log.Printf("Received Tile Size config: %vpx", *msg.TileSize)
+		SetTileSize(int(*msg.TileSize))
+	}
+	if msg.EnableAudio != nil {
+		log.Printf("Received Enable Audio config: %v", *msg.EnableAudio)
+		SetEnableAudio(*msg.EnableAudio)
+	}
+	if msg.AudioBitrate != nil {
+		log.Printf("Received Audio Bitrate config: %s", *msg.AudioBitrate)
+		SetAudioBitrate(*msg.AudioBitrate)
+	}
+	if msg.AudioDevice != nil {
+		log.Printf("Received Audio Device config: %q", *msg.AudioDevice)
+		SetAudioDevice(*msg.AudioDevice)
+	}
+	if msg.AudioFrameDuration != nil {
+		log.Printf("Received Audio Frame Duration config: %vms", *msg.AudioFrameDuration)
+		SetAudioFrameDuration(*msg.AudioFrameDuration)
+	}
+	if msg.AudioFEC != nil {
+		log.Printf("Received Audio FEC config: %v", *msg.AudioFEC)
+		SetAudioFEC(*msg.AudioFEC)
+	}
+	if msg.AudioDTX != nil {
+		log.Printf("Received Audio DTX config: %v", *msg.AudioDTX)
+		SetAudioDTX(*msg.AudioDTX)
+	}
+	if msg.AutoQuality != nil {
+		log.Printf("Received auto quality config: %v", *msg.AutoQuality)
+		if *msg.AutoQuality {
+			if msg.Framerate != nil {
+				ffmpegMutex.Lock()
+				autoBaseFPS = int(*msg.Framerate)
+				ffmpegMutex.Unlock()
+			}
+			// The controller owns bandwidth and framerate in auto mode.
+			msg.Bandwidth, msg.Quality, msg.Framerate = nil, nil, nil
+		}
+		SetAutoQuality(*msg.AutoQuality)
+	}
+	if msg.Bandwidth != nil {
+		bw := int(*msg.Bandwidth)
+		log.Printf("Received bandwidth config: %d Mbps", bw)
+		encoder.SetBitrate(bw)
+	} else if msg.Quality != nil {
+		q := int(*msg.Quality)
+		log.Printf("Received quality config: %d", q)
+		SetQuality(q)
+	}
+	if msg.Framerate != nil {
+		fps := int(*msg.Framerate)
+		log.Printf("Received framerate config: %d fps", fps)
+		SetFramerate(fps)
+	}
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	u := upgrader
+	u.EnableCompression = WSCompression
+	conn, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	if WSCompression {
+		serveClient(newCompressedConn(conn), r)
+		return
+	}
+	serveClient(conn, r)
+}
+
+// serveClient runs a viewer connection until it closes. r is the request
+// that opened it.
+func serveClient(conn clientConn, r *http.Request) {
+	// Hijacked connections outlive http.Server.Shutdown, so Server.Shutdown
+	// waits for the handler (and its PeerConnection cleanup) via workers.
+	workers.Add(1)
+	defer workers.Done()
+	defer conn.Close()
+
+	if subject := clientCertSubject(r); subject != "" {
+		log.Printf("Client connected from %s with certificate %s", r.RemoteAddr, subject)
+	} else {
+		log.Printf("Client connected from %s", r.RemoteAddr)
+	}
+
+	client := &Client{
+		conn:     conn,
+		queue:       newSendQueue(),
+		identity:    requestIdentity(r),
+		share:       shareFromRequest(r),
+		addr:        r.RemoteAddr,
+		connectedAt: time.Now(),
+		writerDone:  make(chan struct{}),
+	}
+	if client.share != nil {
+		log.Printf("Client %s is a guest of share link %s (view only: %v)", r.RemoteAddr, client.share.ID, client.share.ViewOnly)
+		expiry := time.AfterFunc(time.Until(client.share.Expires), func() {
+			disconnectShareGuests(client.share.ID, "share link expired")
+		})
+		defer expiry.Stop()
+	}
+	client.serverCap = serverBandwidthCap(client.identity, r.RemoteAddr)
+	if client.serverCap > 0 {
+		client.limiter = newRateLimiter(client.serverCap)
+		log.Printf("Client %s is capped at %.1f Mbps", r.RemoteAddr, client.serverCap)
+	}
+
+	clientsMutex.Lock()
+	clients[conn] = client
+	clientCount := len(clients)
+	clientsMutex.Unlock()
+
+	if clientCount == 1 {
+		fireHook(HookFirstClientConnected, map[string]interface{}{"remoteAddr": r.RemoteAddr})
+	}
+
+	defer client.Close()
+
+	// Background worker for non-blocking websocket writes
+	go func() {
+		defer close(client.writerDone)
+		supervise(r.Context(), "writer for "+r.RemoteAddr, client.sendWorker)
+	}()
+
+	writeJSON := client.writeJSON
+
+	if applyUserProfile(client.identity) {
+		broadcastConfig(true)
+	}
+
+	// Send initial codec and config to client
+	_ = writeJSON(configPayload())
+
+	cursorMutex.Lock()
+	if cachedCursorMsg != nil {
+		_ = writeJSON(cachedCursorMsg)
+	}
+	cursorMutex.Unlock()
+
+	if sessionLocked.Load() {
+		_ = writeJSON(map[string]interface{}{"type": "locked", "reason": "idle"})
+	}
+
+	if client.serverCap > 0 {
+		_ = writeJSON(map[string]interface{}{"type": "bandwidth_cap", "mbps": client.serverCap})
+	}
+
+	if client.share != nil {
+		_ = writeJSON(map[string]interface{}{"type": "share", "view_only": client.share.ViewOnly, "expires": client.share.Expires.UnixMilli()})
+	}
+
+	if history := chatHistoryMessage(); history != nil {
+		_ = writeJSON(history)
+	}
+
+	if msg := currentBannerMessage(); msg != nil {
+		_ = writeJSON(msg)
+	}
+
+	if msg := currentEncoderErrorMessage(); msg != nil {
+		_ = writeJSON(msg)
+	}
+
+	if clientCount == 1 {
+		startBandwidthProbe(client)
+	}
+
+	// The client owns its PeerConnection (see setClientVideoSender), but an
+	// offer handled while it closes may leave a new one only here.
+	peer := &peerSession{}
+	defer peer.close()
+	gamepads := gamepadSet{}
+	defer gamepads.Close()
+	held := heldKeys{}
+	defer held.releaseAll(Display)
+	pointer := pointerOptions{}
+	defer pointer.release()
+	unlock := unlockAttempts{}
+	protocol := newProtocolChecker(r.RemoteAddr, writeJSON)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		msgType, body, ok := protocol.decode(message)
+		if !ok {
+			continue
+		}
+
+		if sessionLocked.Load() && !lockAllowsMessage(msgType) {
+			continue
+		}
+		if client.share != nil && client.share.ViewOnly && !viewOnlyAllowsMessage(msgType) {
+			continue
+		}
+
+		switch msgType {
+		case "keydown", "keyup", "key", "mousemove", "mousedown", "mouseup", "wheel",
+			"workspace_switch", "workspace_move_window", "gamepad", "pen", "pinch", "shortcut", "system_key":
+			noteInput()
+		}
+
+		switch m := body.(type) {
+		case *keyMessage:
+			if held.filter(m.Key, msgType) {
+				injectKey(m.Key, msgType, Display)
+			}
+		case *mouseMessage:
+			if msgType == "mousemove" {
+				if m.X != nil && m.Y != nil {
+					if pointer.scrollAtEdge(*m.X, *m.Y) {
+						broadcastConfig(false)
+					}
+					x, y := cropPoint(*m.X, *m.Y)
+					injectMouseMove(x, y, Display)
+				}
+			} else if m.Button != nil {
+				injectMouseButton(int(*m.Button), msgType, Display)
+			}
+		case *wheelMessage:
+			if m.Unit == "pixel" {
+				injectPixelScroll(m.DeltaX, m.DeltaY, Display)
+			} else {
+				injectMouseWheel(m.DeltaX, m.DeltaY, Display)
+			}
+		case *spawnMessage:
+			handleSpawnMessage(m, writeJSON)
+		case *lockMessage:
+			lockSession("manual")
+		case *unlockMessage:
+			if !handleUnlock(m, &unlock, r.RemoteAddr, writeJSON) {
+				return
+			}
+		case *listProcessesMessage:
+			handleListProcesses(writeJSON)
+		case *killProcessMessage:
+			handleKillProcess(m, writeJSON)
+		case *configMessage:
+			applyConfigMessage(*m)
+			saveUserProfile(client.identity)
+		case *resizeMessage:
+			if m.DPI != nil {
+				// Viewers sizing the desktop in device pixels send their
+				// devicePixelRatio as a DPI so text keeps its logical size.
+				SetDPI(int(*m.DPI))
+			}
+			if m.Width > 0 && m.Height > 0 {
+				requestResize(int(m.Width), int(m.Height), writeJSON)
+			}
+		case *gamepadMessage:
+			gamepads.handle(m)
+		case *penMessage:
+			handlePen(m)
+		case *shortcutMessage:
+			if !injectShortcut(m.Keys, Display) {
+				log.Printf("Rejected shortcut %v", m.Keys)
+			}
+		case *systemKeyMessage:
+			if !injectSystemKey(m.Key, Display) {
+				log.Printf("Rejected system key %q", m.Key)
+			}
+		case *pinchMessage:
+			injectPinch(m.Scale, Display)
+		case *workspaceListMessage:
+			_ = writeJSON(workspacesMessage())
+		case *audioDeviceListMessage:
+			_ = writeJSON(audioDevicesMessage())
+		case *workspaceSwitchMessage:
+			if m.Index >= 0 {
+				if err := switchWorkspace(int(m.Index)); err != nil {
+					log.Printf("Workspace switch to %d failed: %v", int(m.Index), err)
+				}
+				broadcastJSON(workspacesMessage())
+			}
+		case *workspaceMoveWindowMessage:
+			if m.Index >= 0 {
+				if err := moveWindowToWorkspace(int(m.Index), m.Follow); err != nil {
+					log.Printf("Moving window to workspace %d failed: %v", int(m.Index), err)
+				}
+				broadcastJSON(workspacesMessage())
+			}
+		case *cropMessage:
+			if handleCropMessage(m) {
+				broadcastConfig(false)
+			}
+		case *openURLsMessage:
+			setOpenURLs(client, m)
+		case *pointerOptionsMessage:
+			pointer.update(m)
+			_ = writeJSON(pointer.message())
+		case *rotateMessage:
+			log.Printf("Received rotate: %s", m.Rotation)
+			broadcastConfig(rotateDisplay(m.Rotation))
+		case *videoFormatMessage:
+			clientsMutex.Lock()
+			client.chunkedVideo = m.Format == "chunked"
+			client.awaitingKeyframe = client.chunkedVideo
+			clientsMutex.Unlock()
+			client.flow.enable(m.Fragments)
+			log.Printf("Client WebSocket video format: %s (fragments: %v)", m.Format, m.Fragments)
+		case *videoAckMessage:
+			client.flow.ack(uint32(m.Seq))
+		case *webrtcReadyMessage:
+			log.Printf("Client WebRTC ready, stopping fallback websocket video transmission")
+			clientsMutex.Lock()
+			if c, ok := clients[conn]; ok {
+				c.webrtcReady = true
+			}
+			clientsMutex.Unlock()
+		case *probeResultMessage:
+			handleProbeResult(m)
+		case *pingMessage:
+			resp := map[string]interface{}{"type": "pong", "timestamp": m.Timestamp}
+			writeJSON(resp)
+		case *clipboardSetMessage:
+			handleClipboardSet(m, Display)
+		case *webrtcOfferMessage:
+			if pc := handleWebRTCOffer(m, peer, writeJSON); pc != nil {
+				setClientVideoSender(client, pc)
+			}
+		case *bandwidthCapMessage:
+			limit := setRequestedCap(client, m.Mbps)
+			log.Printf("Client %s requested a %.1f Mbps cap, %.1f Mbps in effect", r.RemoteAddr, m.Mbps, limit)
+			_ = writeJSON(map[string]interface{}{"type": "bandwidth_cap", "mbps": limit})
+		case *webrtcICEMessage:
+			handleWebRTCICE(m, peer)
+		case *chatSendMessage:
+			handleChatMessage(client, m)
+		case *annotateMessage:
+			handleAnnotateMessage(client, m)
+		}
+	}
+}
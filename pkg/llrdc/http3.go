@@ -0,0 +1,64 @@
+package llrdc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+// The HTTPS listener negotiates HTTP/2 over ALPN. HTTP3Port adds an
+// experimental HTTP/3 (QUIC) listener serving the same handler, advertised
+// to browsers with an Alt-Svc header on the TCP responses. It needs its own
+// UDP port, as Port's UDP side carries WebRTC. Browsers still open
+// WebSockets over HTTP/1.1, so HTTP/3 speeds up the viewer's assets and the
+// HTTP endpoints, not the WebSocket itself; EnableWebTransport offers
+// browsers a QUIC alternative to it (see webtransport.go).
+
+// startHTTP3 serves handler over HTTP/3 until ctx is cancelled and returns
+// handler wrapped to advertise it.
+func startHTTP3(ctx context.Context, handler http.Handler) (http.Handler, error) {
+	if HTTP3Port <= 0 {
+		return handler, nil
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: HTTP3Port})
+	if err != nil {
+		return nil, err
+	}
+	srv := &http3.Server{
+		Port:      HTTP3Port,
+		TLSConfig: http3.ConfigureTLSConfig(serverTLS.Clone()),
+		Handler:   handler,
+	}
+	serve, closeServer := srv.Serve, srv.Close
+	if EnableWebTransport {
+		webtransport.ConfigureHTTP3Server(srv)
+		wt := &webtransport.Server{
+			H3:          srv,
+			CheckOrigin: func(r *http.Request) bool { return true },
+		}
+		webTransportServer = wt
+		serve, closeServer = wt.Serve, wt.Close
+	}
+	goWorker(func() {
+		<-ctx.Done()
+		closeServer()
+		webTransportServer = nil
+	})
+	goWorker(func() {
+		log.Printf("HTTP/3 listening on udp/%s", strconv.Itoa(HTTP3Port))
+		if err := serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) && ctx.Err() == nil {
+			log.Printf("HTTP/3 server stopped: %v", err)
+		}
+		conn.Close()
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = srv.SetQUICHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,171 @@
+package llrdc
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	sessionLocked atomic.Bool
+	// lastInputTime holds the UnixNano time of the last input from any client.
+	lastInputTime atomic.Int64
+)
+
+func noteInput() {
+	lastInputTime.Store(time.Now().UnixNano())
+	noteActivity()
+}
+
+func idleLockEnabled() bool {
+	return LockPassword != ""
+}
+
+// startIdleLock locks the session once no client has sent input for
+// IdleLockMinutes. While locked, video, audio and input are suspended,
+// and notifications and links are dropped, until a client sends an unlock
+// message with the lock password.
+func startIdleLock(ctx context.Context) {
+	noteInput()
+	if IdleLockMinutes <= 0 {
+		return
+	}
+	if !idleLockEnabled() {
+		log.Println("Warning: --idle-lock-minutes requires --lock-password; idle lock disabled.")
+		return
+	}
+	timeout := time.Duration(IdleLockMinutes) * time.Minute
+
+	goWorker(func() {
+		for sleepCtx(ctx, 5*time.Second) {
+			if sessionLocked.Load() {
+				continue
+			}
+			if time.Since(time.Unix(0, lastInputTime.Load())) >= timeout {
+				lockSession("idle")
+			}
+		}
+	})
+}
+
+func lockSession(reason string) {
+	if !idleLockEnabled() || !sessionLocked.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("Session locked (%s)", reason)
+	broadcastJSON(map[string]interface{}{
+		"type":   "locked",
+		"reason": reason,
+	})
+}
+
+// Unlock attempts are limited so the lock password cannot be guessed at
+// the speed of the connection. Each failure on a connection doubles the
+// wait before its next attempt, up to unlockMaxBackoff, and a connection
+// that fails unlockMaxFailures times is disconnected. Reconnecting starts
+// afresh, so failures are also counted across all connections, and once
+// unlockGlobalFailures of them fall within unlockGlobalWindow every attempt
+// is refused until the window ends.
+const (
+	unlockMaxFailures    = 5
+	unlockMaxBackoff     = 30 * time.Second
+	unlockGlobalFailures = 20
+	unlockGlobalWindow   = time.Minute
+)
+
+var (
+	unlockMutex       sync.Mutex
+	unlockWindowStart time.Time
+	unlockWindowFails int
+)
+
+// unlockAttempts is one connection's record of failed unlocks.
+type unlockAttempts struct {
+	failures int
+	next     time.Time
+}
+
+// unlockRetryAfter returns how long every connection must wait before
+// trying again, or 0 if it need not.
+func unlockRetryAfter(now time.Time) time.Duration {
+	unlockMutex.Lock()
+	defer unlockMutex.Unlock()
+	if unlockWindowFails < unlockGlobalFailures {
+		return 0
+	}
+	return max(unlockWindowStart.Add(unlockGlobalWindow).Sub(now), 0)
+}
+
+func noteUnlockFailure(now time.Time) {
+	unlockMutex.Lock()
+	defer unlockMutex.Unlock()
+	if now.Sub(unlockWindowStart) >= unlockGlobalWindow {
+		unlockWindowStart, unlockWindowFails = now, 0
+	}
+	unlockWindowFails++
+	if unlockWindowFails == unlockGlobalFailures {
+		log.Printf("Too many failed unlock attempts, refusing them for %v", unlockGlobalWindow)
+	}
+}
+
+// handleUnlock checks an unlock attempt from the client at addr. It returns
+// false when the client has failed too often and should be disconnected.
+func handleUnlock(msg *unlockMessage, attempts *unlockAttempts, addr string, writeJSON func(interface{}) error) bool {
+	now := time.Now()
+	wait := max(attempts.next.Sub(now), unlockRetryAfter(now))
+	if wait > 0 {
+		_ = writeJSON(map[string]interface{}{
+			"type":        "unlock_failed",
+			"error":       "too many attempts",
+			"retry_after": wait.Milliseconds(),
+		})
+		return true
+	}
+	if subtle.ConstantTimeCompare([]byte(msg.Password), []byte(LockPassword)) != 1 {
+		noteUnlockFailure(now)
+		attempts.failures++
+		log.Printf("Session unlock attempt from %s failed (%d)", addr, attempts.failures)
+		if attempts.failures >= unlockMaxFailures {
+			log.Printf("Disconnecting %s after %d failed unlock attempts", addr, attempts.failures)
+			return false
+		}
+		backoff := min(time.Second<<(attempts.failures-1), unlockMaxBackoff)
+		attempts.next = now.Add(backoff)
+		_ = writeJSON(map[string]interface{}{
+			"type":        "unlock_failed",
+			"error":       "invalid password",
+			"retry_after": backoff.Milliseconds(),
+		})
+		return true
+	}
+	attempts.failures, attempts.next = 0, time.Time{}
+	unlockSession()
+	return true
+}
+
+// unlockSession resumes video and input after a lock.
+func unlockSession() {
+	if !sessionLocked.CompareAndSwap(true, false) {
+		return
+	}
+
+	log.Println("Session unlocked")
+	noteInput()
+	broadcastJSON(map[string]interface{}{"type": "unlocked"})
+	// Frames were dropped while locked, so decoders need a fresh keyframe.
+	encoder.RequestKeyframe()
+}
+
+// lockAllowsMessage reports whether a client message may be processed while
+// the session is locked. Only keepalives, WebRTC setup, video acks
+// and unlock pass.
+func lockAllowsMessage(msgType string) bool {
+	switch msgType {
+	case "ping", "unlock", "webrtc_offer", "webrtc_ice", "webrtc_ready", "video_ack":
+		return true
+	}
+	return false
+}
@@ -1,13 +1,16 @@
-package main
+package llrdc
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"math"
 	"os"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var keyMap = map[string]string{
@@ -62,16 +65,33 @@ var keyMap = map[string]string{
 	"\"":         "quotedbl",
 	"'":          "apostrophe",
 	"!":          "exclam",
+
+	// Keys browsers report when the local OS lets them through.
+	"PrintScreen":        "Print",
+	"Pause":              "Pause",
+	"ScrollLock":         "Scroll_Lock",
+	"ContextMenu":        "Menu",
+	"MediaPlayPause":     "XF86AudioPlay",
+	"MediaStop":          "XF86AudioStop",
+	"MediaTrackNext":     "XF86AudioNext",
+	"MediaTrackPrevious": "XF86AudioPrev",
+	"AudioVolumeUp":      "XF86AudioRaiseVolume",
+	"AudioVolumeDown":    "XF86AudioLowerVolume",
+	"AudioVolumeMute":    "XF86AudioMute",
 }
 
+// inputTask is one queued injection. The JSON form is what input macros
+// record.
 type inputTask struct {
-	Type    string
-	Key     string
-	NX, NY  float64
-	DX, DY  float64
-	Button  int
-	Action  string
-	Display string
+	Type    string  `json:"type"`
+	Key     string  `json:"key,omitempty"`
+	NX      float64 `json:"x,omitempty"`
+	NY      float64 `json:"y,omitempty"`
+	DX      float64 `json:"dx,omitempty"`
+	DY      float64 `json:"dy,omitempty"`
+	Button  int     `json:"button,omitempty"`
+	Action  string  `json:"action,omitempty"`
+	Display string  `json:"-"`
 }
 
 var (
@@ -84,10 +104,20 @@ func init() {
 		key := "F" + strconv.Itoa(i)
 		keyMap[key] = key
 	}
+}
 
-	go func() {
+// startInputWorker replays queued input tasks with xdotool until ctx is
+// cancelled, coalescing bursts of mouse moves.
+func startInputWorker(ctx context.Context) {
+	goSupervised(ctx, "input worker", func() {
 		var lastMouseTime time.Time
-		for task := range inputChan {
+		for {
+			var task inputTask
+			select {
+			case <-ctx.Done():
+				return
+			case task = <-inputChan:
+			}
 			if task.Type == "mousemove" {
 				pendingMove := task
 				// Coalesce all currently queued mouse moves
@@ -114,9 +144,11 @@ func init() {
 				execTask(task)
 			}
 		}
-	}()
+	})
 }
 
+// execMouseMove moves the pointer to normalized coordinates, scaled by the
+// live screen size at injection time.
 func execMouseMove(nx, ny float64, display string) {
 	width, height := GetScreenSize()
 	if width <= 0 || height <= 0 {
@@ -138,14 +170,23 @@ func execTask(task inputTask) {
 		if !mapped {
 			xKey = task.Key
 		}
-		isPrintableSingle := len(task.Key) == 1 && task.Key[0] >= 32 && task.Key[0] <= 126
-		if !mapped && !validNameRe.MatchString(xKey) && !isPrintableSingle {
-			return
-		}
 		mode := "keydown"
 		if task.Action == "keyup" || task.Action == "key" {
 			mode = task.Action
 		}
+		isPrintableSingle := len(task.Key) == 1 && task.Key[0] >= 32 && task.Key[0] <= 126
+		if sym, ok := unicodeKeysym(task.Key); ok && !mapped {
+			// Characters from dead keys, Compose or non-US layouts have no
+			// keycode in the server's layout. xdotool binds one only for the
+			// duration of a single call, so press and release together on
+			// keydown and drop the keyup.
+			if mode == "keyup" {
+				return
+			}
+			xKey, mode = sym, "key"
+		} else if !mapped && !validNameRe.MatchString(xKey) && !isPrintableSingle {
+			return
+		}
 
 		var cmd *exec.Cmd
 		if mode == "key" {
@@ -181,6 +222,22 @@ func execTask(task inputTask) {
 			go cmd.Wait()
 		}
 
+	case "shortcut":
+		cmd := exec.Command("xdotool", "key", "--clearmodifiers", task.Key)
+		cmd.Env = append(os.Environ(), "DISPLAY="+task.Display)
+		if err := cmd.Start(); err == nil {
+			_ = cmd.Wait()
+		}
+	case "zoom":
+		btn := "5"
+		if task.DY < 0 {
+			btn = "4"
+		}
+		cmd := exec.Command("xdotool", "keydown", "ctrl", "click", "--repeat", strconv.Itoa(int(math.Abs(task.DY))), btn, "keyup", "ctrl")
+		cmd.Env = append(os.Environ(), "DISPLAY="+task.Display)
+		if err := cmd.Start(); err == nil {
+			go cmd.Wait()
+		}
 	case "wheel":
 		if task.DY != 0 {
 			btn := "5"
@@ -207,39 +264,42 @@ func execTask(task inputTask) {
 	}
 }
 
-func injectKey(key, action, display string) {
-	select {
-	case inputChan <- inputTask{Type: "key", Key: key, Action: action, Display: display}:
-	default:
+// unicodeKeysym returns the Unicode keysym ("U00E9") for a single
+// non-ASCII printable character.
+func unicodeKeysym(key string) (string, bool) {
+	r, size := utf8.DecodeRuneInString(key)
+	if size != len(key) || r < 0x80 || r == utf8.RuneError || !unicode.IsPrint(r) {
+		return "", false
 	}
+	return fmt.Sprintf("U%04X", r), true
 }
 
-func injectMouseMove(nx, ny float64, display string) {
-	select {
-	case inputChan <- inputTask{Type: "mousemove", NX: nx, NY: ny, Display: display}:
-	default:
-	}
+// injectTask queues a task for the input worker, dropping it if the queue
+// is full, and adds it to the macro being recorded.
+func injectTask(task inputTask) {
+	recordMacroEvent(task)
+	queueInput(task)
 }
 
-func injectMouseButton(button int, action, display string) {
+func queueInput(task inputTask) {
 	select {
-	case inputChan <- inputTask{Type: "mousebtn", Button: button, Action: action, Display: display}:
+	case inputChan <- task:
 	default:
 	}
 }
 
-func injectMouseWheel(dx, dy float64, display string) {
-	select {
-	case inputChan <- inputTask{Type: "wheel", DX: dx, DY: dy, Display: display}:
-	default:
-	}
+func injectKey(key, action, display string) {
+	injectTask(inputTask{Type: "key", Key: key, Action: action, Display: display})
 }
 
-func spawnApp(command, display string) {
-	log.Printf("Spawning app: %s", command)
-	cmd := exec.Command("bash", "-c", command)
-	cmd.Env = append(os.Environ(), "DISPLAY="+display)
-	if err := cmd.Start(); err != nil {
-		log.Printf("Failed to spawn app %s: %v\n", command, err)
-	}
+func injectMouseMove(nx, ny float64, display string) {
+	injectTask(inputTask{Type: "mousemove", NX: nx, NY: ny, Display: display})
+}
+
+func injectMouseButton(button int, action, display string) {
+	injectTask(inputTask{Type: "mousebtn", Button: button, Action: action, Display: display})
+}
+
+func injectMouseWheel(dx, dy float64, display string) {
+	injectTask(inputTask{Type: "wheel", DX: dx, DY: dy, Display: display})
 }
@@ -0,0 +1,197 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Connection filtering restricts who may reach the HTTP server, and so the
+// WebSocket and WebRTC signaling, by client address: DenyIPs and
+// DenyCountries always reject, and if AllowIPs or AllowCountries is set a
+// client must match one of them. Countries come from a MaxMind-format
+// GeoIP database (GeoLite2-Country, DB-IP Country Lite). Behind a reverse
+// proxy listed in TrustedProxies, the client address is taken from
+// X-Forwarded-For.
+
+type ipFilter struct {
+	allow, deny, trusted          []*net.IPNet
+	allowCountries, denyCountries []string
+	geo                           *maxminddb.Reader
+}
+
+// connFilter is the filter built by Run, nil when no filtering is set up.
+var connFilter *ipFilter
+
+// parseCIDRList parses comma-separated IP addresses and CIDR ranges.
+func parseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+func parseCountryList(list string) []string {
+	var codes []string
+	for _, code := range strings.Split(list, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// newIPFilter builds the filter from the configuration. It returns nil if
+// nothing is configured.
+func newIPFilter() (*ipFilter, error) {
+	f := &ipFilter{
+		allowCountries: parseCountryList(AllowCountries),
+		denyCountries:  parseCountryList(DenyCountries),
+	}
+	var err error
+	if f.allow, err = parseCIDRList(AllowIPs); err != nil {
+		return nil, fmt.Errorf("--allow-ips: %v", err)
+	}
+	if f.deny, err = parseCIDRList(DenyIPs); err != nil {
+		return nil, fmt.Errorf("--deny-ips: %v", err)
+	}
+	if f.trusted, err = parseCIDRList(TrustedProxies); err != nil {
+		return nil, fmt.Errorf("--trusted-proxies: %v", err)
+	}
+	if len(f.allowCountries) > 0 || len(f.denyCountries) > 0 {
+		if GeoIPDB == "" {
+			return nil, fmt.Errorf("country filtering needs --geoip-db")
+		}
+		if f.geo, err = maxminddb.Open(GeoIPDB); err != nil {
+			return nil, fmt.Errorf("opening GeoIP database: %v", err)
+		}
+		log.Printf("Loaded GeoIP database %s (%s)", GeoIPDB, f.geo.Metadata.DatabaseType)
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 && f.geo == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client behind r: the peer, or the
+// last X-Forwarded-For hop before the trusted proxies.
+func (f *ipFilter) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if ip == nil || len(forwarded) == 0 || !containsIP(f.trusted, ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return nil
+		}
+		ip = hop
+		if !containsIP(f.trusted, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (f *ipFilter) country(ip net.IP) string {
+	if f.geo == nil {
+		return ""
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := f.geo.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// allowed reports whether a client may connect, and why not.
+func (f *ipFilter) allowed(ip net.IP) (bool, string) {
+	if ip == nil {
+		return false, "unknown address"
+	}
+	if containsIP(f.deny, ip) {
+		return false, "denied address"
+	}
+	country := f.country(ip)
+	if country != "" && slices.Contains(f.denyCountries, country) {
+		return false, "denied country " + country
+	}
+	if len(f.allow) == 0 && len(f.allowCountries) == 0 {
+		return true, ""
+	}
+	if containsIP(f.allow, ip) || (country != "" && slices.Contains(f.allowCountries, country)) {
+		return true, ""
+	}
+	if country == "" {
+		return false, "not in the allowlist"
+	}
+	return false, "not in the allowlist (country " + country + ")"
+}
+
+func (f *ipFilter) close() {
+	if f != nil && f.geo != nil {
+		f.geo.Close()
+	}
+}
+
+// wrap rejects filtered clients before next sees the request, so they
+// never get a WebSocket.
+func (f *ipFilter) wrap(next http.Handler) http.Handler {
+	if f == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := f.clientIP(r)
+		if ok, reason := f.allowed(ip); !ok {
+			log.Printf("Rejected %s %s from %s (%v): %s", r.Method, r.URL.Path, r.RemoteAddr, ip, reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
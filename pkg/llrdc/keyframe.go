@@ -0,0 +1,86 @@
+package llrdc
+
+import "strings"
+
+// isKeyframe reports whether an encoded access unit from the current codec
+// can start decoding on its own. It mirrors the client-side detection in
+// viewer.ts so the server can flag keyframes for WebSocket clients.
+func isKeyframe(codec string, data []byte) bool {
+	switch {
+	case strings.HasPrefix(codec, "h264"):
+		return annexBHasNAL(data, func(header byte) bool {
+			nalType := header & 0x1F
+			return nalType == 5 || nalType == 7 // IDR or SPS
+		})
+	case strings.HasPrefix(codec, "h265"):
+		return annexBHasNAL(data, func(header byte) bool {
+			nalType := (header & 0x7E) >> 1
+			// IDR_W_RADL, IDR_N_LP, CRA, VPS, SPS, PPS
+			return (nalType >= 19 && nalType <= 21) || (nalType >= 32 && nalType <= 34)
+		})
+	case strings.HasPrefix(codec, "av1"):
+		return av1HasSequenceHeader(data)
+	default:
+		// VP8: bit 0 of the frame tag is 0 for keyframes.
+		return len(data) > 0 && data[0]&0x01 == 0
+	}
+}
+
+// annexBHasNAL reports whether any NAL unit in an Annex B stream has a
+// header byte matching match.
+func annexBHasNAL(data []byte, match func(header byte) bool) bool {
+	for i := 0; i+3 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 {
+			continue
+		}
+		if data[i+2] == 1 {
+			if match(data[i+3]) {
+				return true
+			}
+			i += 2
+		} else if data[i+2] == 0 && i+4 < len(data) && data[i+3] == 1 {
+			if match(data[i+4]) {
+				return true
+			}
+			i += 3
+		}
+	}
+	return false
+}
+
+// av1HasSequenceHeader walks the leading OBUs of a temporal unit looking for
+// a sequence header, which libaom and NVENC emit with every keyframe.
+func av1HasSequenceHeader(data []byte) bool {
+	pos := 0
+	for obus := 0; obus < 4 && pos < len(data); obus++ {
+		header := data[pos]
+		obuType := (header >> 3) & 0x0F
+		if obuType == 1 {
+			return true
+		}
+		pos++
+		if header&0x04 != 0 { // extension header
+			pos++
+		}
+		if header&0x02 == 0 { // no size field: OBU runs to the end
+			return false
+		}
+		size, n := readLEB128(data[min(pos, len(data)):])
+		if n == 0 || size > uint64(len(data)) {
+			return false
+		}
+		pos += n + int(size)
+	}
+	return false
+}
+
+func readLEB128(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < 8 && i < len(data); i++ {
+		value |= uint64(data[i]&0x7F) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,47 @@
+package llrdc
+
+import (
+	"strconv"
+)
+
+// Key auto-repeat. Browsers repeat keydown while a key is held, and
+// injecting each repeat as a fresh X keydown fights the X server's own
+// autorepeat, giving bursts and stalls. By default the repeats are dropped
+// and X repeats the held key at KeyRepeatDelay/KeyRepeatRate. With
+// KeyRepeatPassthrough the viewer's repeats are injected instead and X
+// autorepeat is turned off, for clients whose repeat timing must win.
+
+// heldKeys tracks the keys one viewer holds down. It is only used from
+// that viewer's WebSocket read loop.
+type heldKeys map[string]bool
+
+// filter records a keydown or keyup and reports whether to inject it.
+func (h heldKeys) filter(key, action string) bool {
+	switch action {
+	case "keydown":
+		if h[key] && !KeyRepeatPassthrough {
+			return false
+		}
+		h[key] = true
+	case "keyup":
+		delete(h, key)
+	}
+	return true
+}
+
+// releaseAll releases every held key, so a viewer that disconnects
+// mid-keypress does not leave keys stuck (and autorepeating) in X.
+func (h heldKeys) releaseAll(display string) {
+	for key := range h {
+		injectKey(key, "keyup", display)
+		delete(h, key)
+	}
+}
+
+// xsetKeyRepeatArgs returns the xset arguments for the autorepeat policy.
+func xsetKeyRepeatArgs() []string {
+	if KeyRepeatPassthrough {
+		return []string{"r", "off"}
+	}
+	return []string{"r", "rate", strconv.Itoa(KeyRepeatDelay), strconv.Itoa(KeyRepeatRate)}
+}
@@ -0,0 +1,100 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	kioskMinBackoff  = 1 * time.Second
+	kioskMaxBackoff  = 30 * time.Second
+	kioskStableAfter = 10 * time.Second
+)
+
+var (
+	kioskMutex sync.Mutex
+	kioskPID   int
+)
+
+// startKiosk keeps KioskCommand running fullscreen, restarting it with
+// backoff whenever it exits, until ctx is cancelled. The window manager is
+// started and supervised alongside the rest of the X session.
+func startKiosk(ctx context.Context, env []string) {
+	goWorker(func() {
+		backoff := kioskMinBackoff
+		for ctx.Err() == nil {
+			log.Printf("Kiosk mode: launching %q", KioskCommand)
+			cmd := exec.CommandContext(ctx, "bash", "-c", KioskCommand)
+			cmd.Env = env
+			runAsSessionUser(cmd)
+			if UseDebugX11 {
+				cmd.Stdout, cmd.Stderr = log.Writer(), log.Writer()
+			}
+			started := time.Now()
+			if err := cmd.Start(); err != nil {
+				log.Printf("Kiosk mode: failed to start application: %v", err)
+			} else {
+				kioskMutex.Lock()
+				kioskPID = cmd.Process.Pid
+				kioskMutex.Unlock()
+
+				go fitKioskWindow()
+				err := cmd.Wait()
+
+				kioskMutex.Lock()
+				kioskPID = 0
+				kioskMutex.Unlock()
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Kiosk mode: application exited: %v", err)
+			}
+
+			if time.Since(started) > kioskStableAfter {
+				backoff = kioskMinBackoff
+			}
+			sleepCtx(ctx, backoff)
+			backoff *= 2
+			if backoff > kioskMaxBackoff {
+				backoff = kioskMaxBackoff
+			}
+		}
+	})
+}
+
+// fitKioskWindow waits for the kiosk application's window to appear and
+// stretches it over the whole screen. It is also called after resizes.
+func fitKioskWindow() {
+	if KioskCommand == "" {
+		return
+	}
+	kioskMutex.Lock()
+	pid := kioskPID
+	kioskMutex.Unlock()
+	if pid == 0 {
+		return
+	}
+
+	width, height := GetScreenSize()
+	env := sessionEnviron(Display)
+	// bash -c may exec the command directly or fork it, so match the window
+	// by the launched PID and fall back to the most recently mapped window.
+	args := []string{
+		"search", "--sync", "--onlyvisible", "--pid", strconv.Itoa(pid),
+		"windowmove", "%@", "0", "0",
+		"windowsize", "%@", strconv.Itoa(width), strconv.Itoa(height),
+	}
+	cmd := exec.Command("timeout", append([]string{"15", "xdotool"}, args...)...)
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		_ = runWithEnv("xdotool", []string{
+			"getactivewindow",
+			"windowmove", "0", "0",
+			"windowsize", strconv.Itoa(width), strconv.Itoa(height),
+		}, env)
+	}
+}
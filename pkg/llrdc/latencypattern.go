@@ -0,0 +1,126 @@
+package llrdc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// The latency pattern is a test source whose frames carry a machine-readable
+// marker, so a client can measure glass-to-glass latency by reading the
+// marker off the decoded video and comparing it with its own clock. The
+// marker is a row of square cells along the top edge:
+//
+//	[white][black][64 data bits][8 check bits]
+//
+// The data is the frame counter (16 bits) followed by the Unix time in
+// milliseconds at which the frame was drawn (48 bits), most significant bit
+// first, with white cells for 1. The check byte is the XOR of the eight
+// data bytes. Cells are large enough to survive lossy encoding and
+// downscaling; see latencyCellSize.
+
+const latencyMarkerCells = 2 + 64 + 8
+
+// latencyCellSize returns the marker cell size in pixels for a frame width.
+func latencyCellSize(width int) int {
+	return max(width/(latencyMarkerCells+6), 8)
+}
+
+// latencyPatternCapture draws the latency pattern in-process and feeds it
+// to ffmpeg as raw grayscale frames.
+type latencyPatternCapture struct{}
+
+func (latencyPatternCapture) Name() string { return "latency" }
+
+func (latencyPatternCapture) Input(p captureParams) captureInput {
+	return captureInput{
+		Args: []string{
+			"-f", "rawvideo", "-pix_fmt", "gray",
+			"-video_size", fmt.Sprintf("%dx%d", p.Width, p.Height),
+			"-framerate", fmt.Sprintf("%d", p.FPS),
+			"-i", "pipe:0",
+		},
+		Stdin: &latencyPatternReader{width: p.Width, height: p.Height, interval: time.Second / time.Duration(max(p.FPS, 1))},
+	}
+}
+
+// latencyPatternReader produces frames on demand, each drawn when it is
+// due, so the timestamp in a frame is when it entered the pipeline.
+type latencyPatternReader struct {
+	width, height int
+	interval      time.Duration
+	next          time.Time
+	frame         []byte
+	pending       []byte
+	counter       uint16
+}
+
+func (r *latencyPatternReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		now := time.Now()
+		if r.next.IsZero() {
+			r.next = now
+		}
+		if wait := r.next.Sub(now); wait > 0 {
+			time.Sleep(wait)
+		} else if wait < -r.interval {
+			// ffmpeg stalled; skip the missed frames rather than bursting.
+			r.next = now
+		}
+		r.next = r.next.Add(r.interval)
+		r.draw(time.Now())
+		r.pending = r.frame
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// draw renders the next frame: a mid-gray background with a bar sweeping
+// across it, so the encoder always has motion, and the marker.
+func (r *latencyPatternReader) draw(at time.Time) {
+	w, h := r.width, r.height
+	if len(r.frame) != w*h {
+		r.frame = make([]byte, w*h)
+	}
+	for i := range r.frame {
+		r.frame[i] = 0x80
+	}
+	barWidth := max(w/40, 4)
+	barX := int(r.counter) * barWidth % max(w-barWidth, 1)
+	for y := h / 4; y < h; y++ {
+		row := r.frame[y*w : (y+1)*w]
+		for x := barX; x < barX+barWidth && x < w; x++ {
+			row[x] = 0xff
+		}
+	}
+
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(r.counter)<<48|uint64(at.UnixMilli())&(1<<48-1))
+	check := byte(0)
+	for _, b := range data {
+		check ^= b
+	}
+	cells := make([]bool, 0, latencyMarkerCells)
+	cells = append(cells, true, false)
+	for _, b := range append(data[:], check) {
+		for bit := 7; bit >= 0; bit-- {
+			cells = append(cells, b&(1<<bit) != 0)
+		}
+	}
+
+	cell := latencyCellSize(w)
+	for y := 0; y < min(2*cell, h); y++ {
+		row := r.frame[y*w : (y+1)*w]
+		for i, on := range cells {
+			v := byte(0)
+			if on {
+				v = 0xff
+			}
+			for x := i * cell; x < (i+1)*cell && x < w; x++ {
+				row[x] = v
+			}
+		}
+	}
+	r.counter++
+}
@@ -0,0 +1,131 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	cgroupRoot    = "/sys/fs/cgroup"
+	appCgroupName = "llrdc-apps"
+	cpuPeriodUs   = 100000
+)
+
+func appLimitsEnabled() bool {
+	return AppCPULimit > 0 || AppMemoryLimitMB > 0
+}
+
+// startLimitedApp starts the command newCmd builds with the CPU and memory
+// of the app capped, so a runaway app can't starve the encoder. It prefers
+// a dedicated cgroup v2 group, which the process is started in, so nothing
+// it forks escapes the limits. When cgroups aren't writable, as in most
+// unprivileged containers, or the kernel cannot start a process in one, it
+// falls back to rlimits (memory) and nice (CPU) on the started process.
+// newCmd may be called twice, as a command can be started only once. It
+// returns the command and the path of its cgroup, if any.
+func startLimitedApp(newCmd func() *exec.Cmd) (*exec.Cmd, string, error) {
+	cmd := newCmd()
+	if !appLimitsEnabled() || cmd.Err != nil {
+		return cmd, "", cmd.Start()
+	}
+
+	path, err := createAppCgroup()
+	if err == nil {
+		if err = startInCgroup(cmd, path); err == nil {
+			log.Printf("Applied resource limits to pid %d via cgroup %s", cmd.Process.Pid, path)
+			return cmd, path, nil
+		}
+		os.Remove(path)
+		cmd = newCmd()
+	}
+	log.Printf("cgroup limits unavailable (%v), falling back to rlimits", err)
+	if err := cmd.Start(); err != nil {
+		return cmd, "", err
+	}
+	pid := cmd.Process.Pid
+	if AppMemoryLimitMB > 0 {
+		bytes := strconv.FormatInt(int64(AppMemoryLimitMB)*1024*1024, 10)
+		if out, err := exec.Command("prlimit", "--pid", strconv.Itoa(pid), "--as="+bytes).CombinedOutput(); err != nil {
+			log.Printf("Failed to set memory rlimit on pid %d: %v (%s)", pid, err, strings.TrimSpace(string(out)))
+		}
+	}
+	if AppCPULimit > 0 {
+		// rlimits can't cap CPU rate; lowering the priority at least keeps
+		// the encoder ahead of the app under contention.
+		if out, err := exec.Command("renice", "-n", "10", "-p", strconv.Itoa(pid)).CombinedOutput(); err != nil {
+			log.Printf("Failed to renice pid %d: %v (%s)", pid, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return cmd, "", nil
+}
+
+// startInCgroup starts cmd as a member of the cgroup at path (clone3 with
+// CLONE_INTO_CGROUP, Linux 5.7 and later).
+func startInCgroup(cmd *exec.Cmd, path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return cmd.Start()
+}
+
+// createAppCgroup creates an empty cgroup with the app limits.
+func createAppCgroup() (string, error) {
+	parent := filepath.Join(cgroupRoot, appCgroupName)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+
+	var controllers []string
+	if AppCPULimit > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if AppMemoryLimitMB > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644); err != nil {
+		return "", fmt.Errorf("failed to enable controllers: %v", err)
+	}
+
+	path, err := os.MkdirTemp(parent, "app-")
+	if err != nil {
+		return "", err
+	}
+
+	if AppCPULimit > 0 {
+		quota := int(AppCPULimit * cpuPeriodUs)
+		if err := os.WriteFile(filepath.Join(path, "cpu.max"), []byte(fmt.Sprintf("%d %d", quota, cpuPeriodUs)), 0644); err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("failed to set cpu.max: %v", err)
+		}
+	}
+	if AppMemoryLimitMB > 0 {
+		limit := strconv.FormatInt(int64(AppMemoryLimitMB)*1024*1024, 10)
+		if err := os.WriteFile(filepath.Join(path, "memory.max"), []byte(limit), 0644); err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("failed to set memory.max: %v", err)
+		}
+	}
+	return path, nil
+}
+
+// removeAppCgroup deletes an app cgroup once it has no processes left. It
+// is a no-op while descendants of the app are still running.
+func removeAppCgroup(path string) {
+	if path == "" {
+		return
+	}
+	_ = os.Remove(path)
+}
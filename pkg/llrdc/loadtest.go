@@ -0,0 +1,373 @@
+package llrdc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// Load testing: --loadtest N connects N headless clients to a running
+// server, each negotiating WebRTC the way the viewer does and consuming
+// the video and audio, then reports per-client bitrate, frame rate, loss
+// and round-trip time. It measures how many viewers a host sustains before
+// a deployment rather than after.
+
+const (
+	loadTestStagger       = 50 * time.Millisecond
+	loadTestPingInterval  = time.Second
+	loadTestInputInterval = 50 * time.Millisecond
+	loadTestReport        = 5 * time.Second
+)
+
+type loadClient struct {
+	id   int
+	conn *websocket.Conn
+	pc   *webrtc.PeerConnection
+	wsMu sync.Mutex
+
+	start      time.Time
+	connected  atomic.Int64 // ns after start that ICE connected
+	firstFrame atomic.Int64 // ns after start of the first video packet
+	videoBytes atomic.Int64
+	audioBytes atomic.Int64
+	wsBytes    atomic.Int64
+	frames     atomic.Int64
+	packets    atomic.Int64
+	lost       atomic.Int64
+
+	rttMu  sync.Mutex
+	rttSum time.Duration
+	rttMax time.Duration
+	rttN   int
+
+	errMu sync.Mutex
+	err   error
+}
+
+func (c *loadClient) send(v interface{}) error {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+// fail records the first error of the client.
+func (c *loadClient) fail(err error) {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// LoadTest runs cfg.LoadTest clients against cfg.LoadTestURL for
+// cfg.LoadTestDuration seconds and prints a report. It fails if any client
+// received no video.
+func LoadTest(ctx context.Context, cfg Config) error {
+	url := cfg.LoadTestURL
+	if url == "" {
+		url = fmt.Sprintf("ws://127.0.0.1:%d/", cfg.Port)
+	}
+	duration := time.Duration(max(cfg.LoadTestDuration, 1)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, duration+time.Duration(cfg.LoadTest)*loadTestStagger)
+	defer cancel()
+	log.Printf("Load test: %d clients against %s for %v", cfg.LoadTest, url, duration)
+
+	clients := make([]*loadClient, cfg.LoadTest)
+	var wg sync.WaitGroup
+	for i := range clients {
+		c := &loadClient{id: i + 1}
+		clients[i] = c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx, url, cfg.LoadTestInput)
+		}()
+		if !sleepCtx(ctx, loadTestStagger) {
+			break
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(loadTestReport)
+	defer ticker.Stop()
+	var lastBytes int64
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-ticker.C:
+			var bytes int64
+			receiving := 0
+			for _, c := range clients {
+				if c == nil {
+					continue
+				}
+				bytes += c.videoBytes.Load() + c.audioBytes.Load()
+				if c.firstFrame.Load() > 0 {
+					receiving++
+				}
+			}
+			log.Printf("Load test: %d/%d clients receiving, %.1f Mbps total", receiving, len(clients),
+				float64(bytes-lastBytes)*8/loadTestReport.Seconds()/1e6)
+			lastBytes = bytes
+		}
+	}
+
+	return printLoadTestReport(clients, duration)
+}
+
+func printLoadTestReport(clients []*loadClient, duration time.Duration) error {
+	ms := func(ns int64) string {
+		if ns <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d", time.Duration(ns).Milliseconds())
+	}
+	fmt.Printf("\n%-6s %10s %12s %10s %8s %8s %8s %8s  %s\n",
+		"CLIENT", "ICE MS", "1ST FRAME MS", "MBPS", "FPS", "LOSS %", "RTT MS", "MAX RTT", "ERROR")
+	var totalMbps float64
+	failed := 0
+	for _, c := range clients {
+		if c == nil {
+			continue
+		}
+		seconds := duration.Seconds()
+		if ff := c.firstFrame.Load(); ff > 0 {
+			seconds = max(time.Since(c.start.Add(time.Duration(ff))).Seconds(), 1)
+		} else {
+			failed++
+		}
+		mbps := float64(c.videoBytes.Load()+c.audioBytes.Load()) * 8 / seconds / 1e6
+		totalMbps += mbps
+		loss := 0.0
+		if n := c.packets.Load() + c.lost.Load(); n > 0 {
+			loss = float64(c.lost.Load()) * 100 / float64(n)
+		}
+		rtt, rttMax := "-", "-"
+		c.rttMu.Lock()
+		if c.rttN > 0 {
+			rtt = fmt.Sprintf("%.1f", float64((c.rttSum/time.Duration(c.rttN)).Microseconds())/1000)
+			rttMax = fmt.Sprintf("%.1f", float64(c.rttMax.Microseconds())/1000)
+		}
+		c.rttMu.Unlock()
+		errText := ""
+		c.errMu.Lock()
+		if c.err != nil {
+			errText = c.err.Error()
+		} else if c.firstFrame.Load() == 0 {
+			errText = "no video received"
+		}
+		c.errMu.Unlock()
+		fmt.Printf("%-6d %10s %12s %10.2f %8.1f %8.2f %8s %8s  %s\n",
+			c.id, ms(c.connected.Load()), ms(c.firstFrame.Load()), mbps,
+			float64(c.frames.Load())/seconds, loss, rtt, rttMax, errText)
+	}
+	fmt.Printf("\n%d clients, %.1f Mbps total, %.2f Mbps per client\n", len(clients), totalMbps, totalMbps/math.Max(float64(len(clients)), 1))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d clients received no video", failed, len(clients))
+	}
+	return nil
+}
+
+// run connects one client and keeps it streaming until ctx ends.
+func (c *loadClient) run(ctx context.Context, url string, input bool) {
+	c.start = time.Now()
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.conn = conn
+	defer conn.Close()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{
+		ICEServers: iceServers(),
+	})
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.pc = pc
+	defer pc.Close()
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate != nil {
+			_ = c.send(map[string]interface{}{"type": "webrtc_ice", "candidate": candidate.ToJSON()})
+		}
+	})
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		switch state {
+		case webrtc.ICEConnectionStateConnected:
+			c.connected.CompareAndSwap(0, int64(time.Since(c.start)))
+		case webrtc.ICEConnectionStateFailed:
+			c.fail(fmt.Errorf("ICE failed"))
+		}
+	})
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		c.readTrack(track)
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err == nil {
+		err = pc.SetLocalDescription(offer)
+	}
+	if err == nil {
+		err = c.send(map[string]interface{}{"type": "webrtc_offer", "sdp": pc.LocalDescription()})
+	}
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	go c.readSignaling(ctx)
+	go func() {
+		ticker := time.NewTicker(loadTestPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ts := float64(time.Now().UnixNano()) / 1e6
+				if c.send(map[string]interface{}{"type": "ping", "timestamp": ts}) != nil {
+					return
+				}
+			}
+		}
+	}()
+	if input {
+		go c.sendInput(ctx)
+	}
+	<-ctx.Done()
+	c.wsMu.Lock()
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.wsMu.Unlock()
+}
+
+// readSignaling handles the server's answer, ICE candidates and pongs.
+// Candidates that arrive before the answer are held back.
+func (c *loadClient) readSignaling(ctx context.Context) {
+	var pending []webrtc.ICECandidateInit
+	answered := false
+	for ctx.Err() == nil {
+		msgType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				c.fail(err)
+			}
+			return
+		}
+		if msgType == websocket.BinaryMessage {
+			c.wsBytes.Add(int64(len(data)))
+			continue
+		}
+		var msg struct {
+			Type      string                     `json:"type"`
+			SDP       *webrtc.SessionDescription `json:"sdp"`
+			Candidate *webrtc.ICECandidateInit   `json:"candidate"`
+			Timestamp float64                    `json:"timestamp"`
+		}
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "webrtc_answer":
+			if msg.SDP == nil {
+				continue
+			}
+			if err := c.pc.SetRemoteDescription(*msg.SDP); err != nil {
+				c.fail(err)
+				return
+			}
+			answered = true
+			for _, ice := range pending {
+				_ = c.pc.AddICECandidate(ice)
+			}
+			pending = nil
+		case "webrtc_ice":
+			if msg.Candidate == nil {
+				continue
+			}
+			if answered {
+				_ = c.pc.AddICECandidate(*msg.Candidate)
+			} else {
+				pending = append(pending, *msg.Candidate)
+			}
+		case "pong":
+			rtt := time.Duration((float64(time.Now().UnixNano())/1e6 - msg.Timestamp) * float64(time.Millisecond))
+			c.rttMu.Lock()
+			c.rttSum += rtt
+			c.rttMax = max(c.rttMax, rtt)
+			c.rttN++
+			c.rttMu.Unlock()
+		}
+	}
+}
+
+// readTrack counts the bytes, frames and lost packets of one track.
+func (c *loadClient) readTrack(track *webrtc.TrackRemote) {
+	video := track.Kind() == webrtc.RTPCodecTypeVideo
+	var lastSeq uint16
+	first := true
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		size := int64(packet.MarshalSize())
+		if !video {
+			c.audioBytes.Add(size)
+			continue
+		}
+		if c.firstFrame.CompareAndSwap(0, int64(time.Since(c.start))) {
+			// Stop the WebSocket fallback video, as the viewer does.
+			_ = c.send(map[string]interface{}{"type": "webrtc_ready"})
+		}
+		c.videoBytes.Add(size)
+		c.packets.Add(1)
+		if packet.Marker {
+			c.frames.Add(1)
+		}
+		if gap := packet.SequenceNumber - lastSeq; !first && gap > 1 && gap < 1000 {
+			c.lost.Add(int64(gap - 1))
+		}
+		lastSeq, first = packet.SequenceNumber, false
+	}
+}
+
+// sendInput moves the pointer around a circle, like a user working in the
+// session, so the input path is loaded too.
+func (c *loadClient) sendInput(ctx context.Context) {
+	ticker := time.NewTicker(loadTestInputInterval)
+	defer ticker.Stop()
+	for step := 0; ; step++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		angle := float64(step+c.id*7) / 20 * 2 * math.Pi
+		move := map[string]interface{}{"type": "mousemove", "x": 0.5 + 0.3*math.Cos(angle), "y": 0.5 + 0.3*math.Sin(angle)}
+		if c.send(move) != nil {
+			return
+		}
+	}
+}
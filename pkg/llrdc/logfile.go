@@ -0,0 +1,98 @@
+package llrdc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Log files with size-based rotation, for hosts without journald or
+// logrotate. Once the file would grow past LogMaxSizeMB it is renamed to
+// <file>.1, older files move up by one and the oldest beyond LogMaxFiles is
+// removed, so the log never takes more than about
+// (LogMaxFiles+1)*LogMaxSizeMB. A single write is never split across files.
+
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+// OpenLogFile opens cfg.LogFile for appending, rotating it as configured,
+// for use with log.SetOutput.
+func OpenLogFile(cfg Config) (io.WriteCloser, error) {
+	r := &rotatingFile{
+		path:     cfg.LogFile,
+		maxSize:  int64(max(cfg.LogMaxSizeMB, 0)) * 1024 * 1024,
+		maxFiles: max(cfg.LogMaxFiles, 0),
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the old file rather than losing lines.
+			fmt.Fprintf(os.Stderr, "llrdc: rotating %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxFiles > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	old := r.f
+	if err := r.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,226 @@
+package llrdc
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Input macros: record the input injected into the session with timestamps
+// and replay it later, for UI tests and demos driven through llrdc. A macro
+// is JSON:
+//
+//	{"events":[{"t":0,"type":"mousemove","x":0.5,"y":0.5},
+//	           {"t":120,"type":"key","key":"a","action":"keydown"}]}
+//
+// with t in milliseconds since recording started. Recording covers
+// everything that goes through the xdotool input queue, from all viewers;
+// gamepads and the uinput pen tablet are not recorded.
+//
+//	POST /macro/record         start recording
+//	POST /macro/record/stop    stop recording and return the macro
+//	POST /macro/play?speed=2   replay the macro in the request body
+//	     (&wait=1 returns only when playback has finished)
+//	POST /macro/play/stop      cancel playback
+//	GET  /macro                recording and playback status
+
+const (
+	macroPrefix    = "/macro"
+	maxMacroEvents = 100000
+	maxMacroBody   = 16 << 20
+)
+
+type macroEvent struct {
+	At int64 `json:"t"`
+	inputTask
+}
+
+type macro struct {
+	Events []macroEvent `json:"events"`
+}
+
+var (
+	macroMutex     sync.Mutex
+	macroRecording bool
+	macroStart     time.Time
+	macroEvents    []macroEvent
+	macroPlayback  context.CancelFunc
+)
+
+// recordMacroEvent appends an injected task to the macro being recorded.
+func recordMacroEvent(task inputTask) {
+	macroMutex.Lock()
+	defer macroMutex.Unlock()
+	if !macroRecording || len(macroEvents) >= maxMacroEvents {
+		return
+	}
+	macroEvents = append(macroEvents, macroEvent{At: time.Since(macroStart).Milliseconds(), inputTask: task})
+}
+
+// playMacro queues the events of m at their recorded times divided by
+// speed. Keys and buttons still down when playback ends or is cancelled
+// are released.
+func playMacro(ctx context.Context, m macro, speed float64) {
+	keys := map[string]bool{}
+	buttons := map[int]bool{}
+	defer func() {
+		for key := range keys {
+			queueInput(inputTask{Type: "key", Key: key, Action: "keyup", Display: Display})
+		}
+		for button := range buttons {
+			queueInput(inputTask{Type: "mousebtn", Button: button, Action: "mouseup", Display: Display})
+		}
+	}()
+
+	start := time.Now()
+	for _, ev := range m.Events {
+		due := time.Duration(float64(ev.At)/speed) * time.Millisecond
+		if wait := due - time.Since(start); wait > 0 && !sleepCtx(ctx, wait) {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		task := ev.inputTask
+		task.Display = Display
+		switch {
+		case task.Type == "key" && task.Action == "keydown":
+			keys[task.Key] = true
+		case task.Type == "key":
+			delete(keys, task.Key)
+		case task.Type == "mousebtn" && task.Action == "mousedown":
+			buttons[task.Button] = true
+		case task.Type == "mousebtn":
+			delete(buttons, task.Button)
+		}
+		queueInput(task)
+	}
+}
+
+func writeMacroStatus(w http.ResponseWriter) {
+	macroMutex.Lock()
+	status := map[string]interface{}{
+		"recording": macroRecording,
+		"events":    len(macroEvents),
+		"playing":   macroPlayback != nil,
+	}
+	macroMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// macroHandler serves the macro API under /macro.
+func macroHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+macroPrefix, func(w http.ResponseWriter, r *http.Request) {
+		writeMacroStatus(w)
+	})
+	mux.HandleFunc("POST "+macroPrefix+"/record", func(w http.ResponseWriter, r *http.Request) {
+		macroMutex.Lock()
+		if macroRecording {
+			macroMutex.Unlock()
+			http.Error(w, "Already recording", http.StatusConflict)
+			return
+		}
+		macroRecording = true
+		macroStart = time.Now()
+		macroEvents = nil
+		macroMutex.Unlock()
+		log.Printf("Macro: recording started by %s", r.RemoteAddr)
+		writeMacroStatus(w)
+	})
+	mux.HandleFunc("POST "+macroPrefix+"/record/stop", func(w http.ResponseWriter, r *http.Request) {
+		macroMutex.Lock()
+		if !macroRecording {
+			macroMutex.Unlock()
+			http.Error(w, "Not recording", http.StatusConflict)
+			return
+		}
+		macroRecording = false
+		m := macro{Events: macroEvents}
+		macroEvents = nil
+		macroMutex.Unlock()
+		if m.Events == nil {
+			m.Events = []macroEvent{}
+		}
+		log.Printf("Macro: recorded %d events", len(m.Events))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m)
+	})
+	mux.HandleFunc("POST "+macroPrefix+"/play", func(w http.ResponseWriter, r *http.Request) {
+		speed := 1.0
+		if s := r.URL.Query().Get("speed"); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v < 0.1 || v > 100 {
+				http.Error(w, "speed must be between 0.1 and 100", http.StatusBadRequest)
+				return
+			}
+			speed = v
+		}
+		var m macro
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMacroBody)).Decode(&m); err != nil {
+			http.Error(w, "Invalid macro: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(m.Events) > maxMacroEvents {
+			http.Error(w, "Macro too long", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		macroMutex.Lock()
+		if macroPlayback != nil {
+			macroMutex.Unlock()
+			http.Error(w, "Already playing", http.StatusConflict)
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		macroPlayback = cancel
+		macroMutex.Unlock()
+
+		log.Printf("Macro: playing %d events at %gx for %s", len(m.Events), speed, r.RemoteAddr)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			playMacro(ctx, m, speed)
+			macroMutex.Lock()
+			cancel()
+			macroPlayback = nil
+			macroMutex.Unlock()
+			log.Println("Macro: playback finished")
+		}()
+		if r.URL.Query().Get("wait") == "1" {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+				return
+			}
+			writeMacroStatus(w)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("POST "+macroPrefix+"/play/stop", func(w http.ResponseWriter, r *http.Request) {
+		macroMutex.Lock()
+		if macroPlayback != nil {
+			macroPlayback()
+		}
+		macroMutex.Unlock()
+		writeMacroStatus(w)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IdentityHeader != "" && requestIdentity(r) == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if sessionLocked.Load() {
+			http.Error(w, "Session locked", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,246 @@
+package llrdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Session manifests (SessionManifest) describe the apps a session starts
+// with and where their windows go, so a lab or demo session always comes up
+// the same way:
+//
+//	{"apps": [
+//	  {"command": "xfce4-terminal", "args": ["--title", "Build"], "cwd": "src",
+//	   "x": 0, "y": 0, "width": 960, "height": 1080},
+//	  {"command": "mousepad", "env": {"LANG": "C"}, "workspace": 1, "maximized": true}
+//	]}
+//
+// The apps are launched in order once the desktop is up, and again after
+// Xvfb is restarted, since they die with it. They are trusted like
+// KioskCommand: any command may be given, it runs directly without a shell
+// and a relative cwd is taken from the session home. Each app's first new
+// window, found by PID or, for apps that hand the window to an existing
+// process, by a "match" on its WM_CLASS or title, is then moved with
+// wmctrl before the next app starts.
+
+const (
+	manifestWindowTimeout = 20 * time.Second
+	manifestPollInterval  = 250 * time.Millisecond
+)
+
+type manifestApp struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	Cwd     string            `json:"cwd"`
+	// Match finds the window by WM_CLASS or title instead of by PID.
+	Match     string `json:"match"`
+	X         *int   `json:"x"`
+	Y         *int   `json:"y"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Workspace *int   `json:"workspace"`
+	Maximized bool   `json:"maximized"`
+}
+
+type sessionManifest struct {
+	Apps []manifestApp `json:"apps"`
+}
+
+// manifest is the loaded SessionManifest, nil without one.
+var manifest *sessionManifest
+
+// loadSessionManifest reads and checks SessionManifest.
+func loadSessionManifest() error {
+	if SessionManifest == "" || manifest != nil {
+		return nil
+	}
+	data, err := os.ReadFile(SessionManifest)
+	if err != nil {
+		return fmt.Errorf("failed to read session manifest: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var m sessionManifest
+	if err := dec.Decode(&m); err != nil {
+		return fmt.Errorf("invalid session manifest %s: %v", SessionManifest, err)
+	}
+	for i, app := range m.Apps {
+		if err := app.validate(); err != nil {
+			return fmt.Errorf("invalid session manifest %s: app %d: %v", SessionManifest, i+1, err)
+		}
+	}
+	manifest = &m
+	log.Printf("Loaded session manifest %s (%d apps)", SessionManifest, len(m.Apps))
+	return nil
+}
+
+func (a manifestApp) validate() error {
+	switch {
+	case strings.TrimSpace(a.Command) == "":
+		return errors.New("command is required")
+	case (a.X == nil) != (a.Y == nil):
+		return errors.New("x and y must be given together")
+	case a.Width < 0 || a.Height < 0:
+		return errors.New("width and height must not be negative")
+	case a.Workspace != nil && *a.Workspace < 0:
+		return errors.New("workspace must not be negative")
+	}
+	return nil
+}
+
+func (a manifestApp) request() spawnRequest {
+	req := spawnRequest{command: a.Command, args: a.Args, cwd: a.Cwd}
+	for name, value := range a.Env {
+		req.env = append(req.env, name+"="+value)
+	}
+	sort.Strings(req.env)
+	if req.cwd != "" && !filepath.IsAbs(req.cwd) {
+		req.cwd = filepath.Join(sessionHomeDir(), req.cwd)
+	}
+	return req
+}
+
+// places reports whether the app's window needs moving.
+func (a manifestApp) places() bool {
+	return a.X != nil || a.Width > 0 || a.Height > 0 || a.Workspace != nil || a.Maximized
+}
+
+// startSessionManifest launches the manifest's apps in the background.
+func startSessionManifest(ctx context.Context) {
+	if manifest == nil || len(manifest.Apps) == 0 {
+		return
+	}
+	apps := manifest.Apps
+	goWorker(func() {
+		for _, app := range apps {
+			if ctx.Err() != nil {
+				return
+			}
+			launchManifestApp(ctx, app)
+		}
+		log.Printf("Session manifest: launched %d apps", len(apps))
+	})
+}
+
+func launchManifestApp(ctx context.Context, app manifestApp) {
+	before := map[string]bool{}
+	if app.places() {
+		windows, err := listManagedWindows()
+		if err != nil {
+			log.Printf("Session manifest: cannot list windows (is wmctrl installed?): %v", err)
+		}
+		for _, w := range windows {
+			before[w.id] = true
+		}
+	}
+
+	var pid int
+	spawnApp(app.request(), Display, func(event string, p int, _ *os.ProcessState, err error) {
+		switch event {
+		case "started":
+			pid = p
+		case "error":
+			log.Printf("Session manifest: failed to start %s: %v", app.Command, err)
+		case "exited":
+			log.Printf("Session manifest: %s exited", app.Command)
+		}
+	})
+	if pid == 0 || !app.places() {
+		return
+	}
+
+	deadline := time.Now().Add(manifestWindowTimeout)
+	for sleepCtx(ctx, manifestPollInterval) {
+		windows, _ := listManagedWindows()
+		for _, w := range windows {
+			if !before[w.id] && w.matches(app, pid) {
+				placeManifestWindow(w.id, app)
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Session manifest: no window from %s after %v, leaving it where it is", app.Command, manifestWindowTimeout)
+			return
+		}
+	}
+}
+
+type managedWindow struct {
+	id    string
+	pid   int
+	class string
+	title string
+}
+
+func (w managedWindow) matches(app manifestApp, pid int) bool {
+	if app.Match == "" {
+		return w.pid == pid
+	}
+	match := strings.ToLower(app.Match)
+	return strings.Contains(strings.ToLower(w.class), match) || strings.Contains(strings.ToLower(w.title), match)
+}
+
+// listManagedWindows lists the windows the window manager knows, from
+// `wmctrl -lpx` lines such as
+//
+//	0x01e00003  0 4242   mousepad.Mousepad  host Untitled 1 - Mousepad
+func listManagedWindows() ([]managedWindow, error) {
+	out, err := workspaceOutput("wmctrl", "-lpx")
+	if err != nil {
+		return nil, err
+	}
+	var windows []managedWindow
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		pid, _ := strconv.Atoi(fields[2])
+		w := managedWindow{id: fields[0], pid: pid, class: fields[3]}
+		if len(fields) > 5 {
+			w.title = strings.Join(fields[5:], " ")
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func placeManifestWindow(id string, app manifestApp) {
+	env := sessionEnviron(Display)
+	run := func(args ...string) {
+		if err := runWithEnv("wmctrl", append([]string{"-i", "-r", id}, args...), env); err != nil {
+			log.Printf("Session manifest: wmctrl %s for %s failed: %v", strings.Join(args, " "), app.Command, err)
+		}
+	}
+	if app.Workspace != nil {
+		run("-t", strconv.Itoa(*app.Workspace))
+	}
+	if app.X != nil || app.Width > 0 || app.Height > 0 {
+		x, y, w, h := -1, -1, -1, -1
+		if app.X != nil {
+			x, y = *app.X, *app.Y
+		}
+		if app.Width > 0 {
+			w = app.Width
+		}
+		if app.Height > 0 {
+			h = app.Height
+		}
+		run("-e", fmt.Sprintf("0,%d,%d,%d,%d", x, y, w, h))
+	}
+	if app.Maximized {
+		run("-b", "add,maximized_vert,maximized_horz")
+	}
+	log.Printf("Session manifest: placed %s (window %s)", app.Command, id)
+}
@@ -0,0 +1,250 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+// LAN discovery. With EnableMDNS the server advertises itself over
+// multicast DNS as a DNS-SD service of type _llrdc._tcp, so viewers on the
+// same network can list desktops instead of typing addresses:
+//
+//	avahi-browse -r _llrdc._tcp
+//	dns-sd -B _llrdc._tcp
+//
+// The responder is minimal: IPv4 only, announcing on start and saying
+// goodbye on shutdown, answering queries for the service, the instance and
+// the host name, and without probing for name conflicts. It shares port
+// 5353 with a system responder such as Avahi if one is running.
+
+const (
+	mdnsService  = "_llrdc._tcp.local."
+	mdnsServices = "_services._dns-sd._udp.local."
+	mdnsTTL      = 120
+	// mdnsAnnouncements follows RFC 6762 section 8.3: at least two
+	// announcements, one second apart.
+	mdnsAnnouncements = 2
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+type mdnsResponder struct {
+	conn     *net.UDPConn
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	txt      []string
+}
+
+// startMDNS advertises the server until ctx is cancelled. Failing to
+// advertise is not fatal.
+func startMDNS(ctx context.Context) {
+	if !EnableMDNS {
+		return
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "llrdc"
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	name := MDNSName
+	if name == "" {
+		name = "llrdc on " + hostname
+	}
+	// Dots would split the instance name into labels, which are at most
+	// 63 bytes.
+	name = strings.ReplaceAll(name, ".", "-")
+	if len(name) > 63 {
+		name = strings.ToValidUTF8(name[:63], "")
+	}
+
+	r := &mdnsResponder{port: uint16(Port)}
+	if r.instance, err = dnsmessage.NewName(name + "." + mdnsService); err == nil {
+		r.host, err = dnsmessage.NewName(hostname + ".local.")
+	}
+	if err != nil {
+		log.Printf("mDNS advertisement disabled: %v", err)
+		return
+	}
+	r.txt = []string{"txtvers=1", "path=/", "tls=" + strconv.FormatBool(serverTLS != nil)}
+	if SessionID != "" {
+		r.txt = append(r.txt, "session="+SessionID)
+	}
+
+	if r.conn, err = net.ListenMulticastUDP("udp4", nil, mdnsGroup); err != nil {
+		log.Printf("mDNS advertisement disabled: %v", err)
+		return
+	}
+	// The socket joined the group on the default interface only.
+	if ifaces, err := net.Interfaces(); err == nil {
+		p := ipv4.NewPacketConn(r.conn)
+		for i := range ifaces {
+			if ifaces[i].Flags&net.FlagUp != 0 && ifaces[i].Flags&net.FlagMulticast != 0 {
+				_ = p.JoinGroup(&ifaces[i], mdnsGroup)
+			}
+		}
+	}
+	log.Printf("Advertising %q over mDNS as %s", name, mdnsService)
+
+	goWorker(func() {
+		<-ctx.Done()
+		r.send(r.records(0), mdnsGroup)
+		r.conn.Close()
+	})
+	goWorker(func() {
+		for i := 0; i < mdnsAnnouncements; i++ {
+			r.send(r.records(mdnsTTL), mdnsGroup)
+			if !sleepCtx(ctx, time.Second) {
+				return
+			}
+		}
+	})
+	goWorker(func() { r.serve(ctx) })
+}
+
+// serve answers queries until the connection is closed.
+func (r *mdnsResponder) serve(ctx context.Context) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("mDNS responder stopped: %v", err)
+			}
+			return
+		}
+		var p dnsmessage.Parser
+		header, err := p.Start(buf[:n])
+		if err != nil || header.Response {
+			continue
+		}
+		questions, err := p.AllQuestions()
+		if err != nil {
+			continue
+		}
+		var answers []dnsmessage.Resource
+		unicast := from.Port != mdnsGroup.Port
+		for _, q := range questions {
+			// The top bit of the class asks for a unicast reply.
+			if q.Class&(1<<15) != 0 {
+				unicast = true
+			}
+			answers = append(answers, r.answer(q)...)
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		to := mdnsGroup
+		if unicast {
+			to = from
+		}
+		msg := dnsmessage.Message{
+			Header:  dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true},
+			Answers: answers,
+		}
+		if from.Port != mdnsGroup.Port {
+			// Legacy unicast resolvers match the reply to the question,
+			// and know neither cache flushing nor long TTLs (RFC 6762
+			// section 6.7).
+			msg.Questions = questions
+			for i := range msg.Answers {
+				msg.Answers[i].Header.Class &^= 1 << 15
+				msg.Answers[i].Header.TTL = min(msg.Answers[i].Header.TTL, 10)
+			}
+		} else {
+			msg.ID = 0
+		}
+		r.sendMessage(msg, to)
+	}
+}
+
+// answer returns the records answering q, if it is about this server.
+func (r *mdnsResponder) answer(q dnsmessage.Question) []dnsmessage.Resource {
+	name := strings.ToLower(q.Name.String())
+	switch {
+	case name == mdnsServices && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+		return []dnsmessage.Resource{{
+			Header: r.header(q.Name, dnsmessage.TypePTR, mdnsTTL, false),
+			Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(mdnsService)},
+		}}
+	case name == mdnsService && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+		return r.records(mdnsTTL)
+	case name == strings.ToLower(r.instance.String()):
+		return r.records(mdnsTTL)[1:]
+	case name == strings.ToLower(r.host.String()) && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL):
+		return r.addresses(mdnsTTL)
+	}
+	return nil
+}
+
+// records returns the PTR, SRV, TXT and A records advertising the
+// instance, with ttl 0 for a goodbye.
+func (r *mdnsResponder) records(ttl uint32) []dnsmessage.Resource {
+	records := []dnsmessage.Resource{
+		{
+			Header: r.header(dnsmessage.MustNewName(mdnsService), dnsmessage.TypePTR, ttl, false),
+			Body:   &dnsmessage.PTRResource{PTR: r.instance},
+		},
+		{
+			Header: r.header(r.instance, dnsmessage.TypeSRV, ttl, true),
+			Body:   &dnsmessage.SRVResource{Target: r.host, Port: r.port},
+		},
+		{
+			Header: r.header(r.instance, dnsmessage.TypeTXT, ttl, true),
+			Body:   &dnsmessage.TXTResource{TXT: r.txt},
+		},
+	}
+	return append(records, r.addresses(ttl)...)
+}
+
+func (r *mdnsResponder) addresses(ttl uint32) []dnsmessage.Resource {
+	var records []dnsmessage.Resource
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		var a [4]byte
+		copy(a[:], ipnet.IP.To4())
+		records = append(records, dnsmessage.Resource{
+			Header: r.header(r.host, dnsmessage.TypeA, ttl, true),
+			Body:   &dnsmessage.AResource{A: a},
+		})
+	}
+	return records
+}
+
+// header builds a resource header. Records only this server owns set the
+// cache-flush bit, the top bit of the class.
+func (r *mdnsResponder) header(name dnsmessage.Name, typ dnsmessage.Type, ttl uint32, unique bool) dnsmessage.ResourceHeader {
+	class := dnsmessage.ClassINET
+	if unique {
+		class |= 1 << 15
+	}
+	return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: class, TTL: ttl}
+}
+
+func (r *mdnsResponder) send(records []dnsmessage.Resource, to *net.UDPAddr) {
+	r.sendMessage(dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: records,
+	}, to)
+}
+
+func (r *mdnsResponder) sendMessage(msg dnsmessage.Message, to *net.UDPAddr) {
+	b, err := msg.Pack()
+	if err != nil {
+		log.Printf("mDNS: %v", err)
+		return
+	}
+	_, _ = r.conn.WriteToUDP(b, to)
+}
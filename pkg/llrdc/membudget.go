@@ -0,0 +1,60 @@
+package llrdc
+
+import (
+	"sync/atomic"
+)
+
+// Bounded memory mode. Encoded video waits in three places: the WebRTC
+// frame channel, the per-viewer WebSocket send queues (sendqueue.go) and
+// the DVR buffer (dvr.go). Each of them is bounded on its own, but their
+// bounds add up per viewer, so a few stalled viewers can still grow the
+// server by hundreds of MB. With MaxBufferedMB they all draw on one
+// budget:
+//
+//   - WebRTC frames and WebSocket packets that would exceed it are
+//     dropped, as when their channel or queue is full. A viewer's queue
+//     still takes one packet when it is empty, so every viewer makes
+//     progress.
+//   - The DVR holds at most half of the budget, and drops its oldest
+//     keyframe intervals to stay within it, so the replay gets shorter.
+//
+// Frames are shared between these consumers (see Frame), so a frame
+// waiting in several places is counted in each, which overstates the
+// memory in use.
+
+// bufferedVideo accounts the bytes of encoded video waiting to be sent or
+// kept for replay.
+var bufferedVideo memBudget
+
+type memBudget struct {
+	used atomic.Int64
+}
+
+// limit is the budget in bytes, or 0 for none.
+func (b *memBudget) limit() int64 {
+	return int64(MaxBufferedMB) << 20
+}
+
+// reserve accounts n bytes, or reports false if they would exceed the
+// budget.
+func (b *memBudget) reserve(n int) bool {
+	limit := b.limit()
+	for {
+		used := b.used.Load()
+		if limit > 0 && used+int64(n) > limit {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+int64(n)) {
+			return true
+		}
+	}
+}
+
+// force accounts n bytes even if they exceed the budget.
+func (b *memBudget) force(n int) {
+	b.used.Add(int64(n))
+}
+
+func (b *memBudget) release(n int) {
+	b.used.Add(-int64(n))
+}
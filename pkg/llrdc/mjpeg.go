@@ -0,0 +1,233 @@
+package llrdc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	mjpegBoundary = "llrdcframe"
+	// mjpegQuality is ffmpeg's -q:v for MJPEG (2 best, 31 worst).
+	mjpegQuality = 7
+	// mjpegMaxFrame bounds a single JPEG so a corrupt stream cannot grow
+	// the read buffer without limit.
+	mjpegMaxFrame = 16 << 20
+	// mjpegSnapshotTimeout bounds the wait for a snapshot, which includes
+	// starting ffmpeg if nobody else is watching.
+	mjpegSnapshotTimeout = 10 * time.Second
+	// thumbnailWidth is the width of the /thumbnail preview; the height
+	// follows the screen's aspect ratio (180 px for 16:9).
+	thumbnailWidth = 320
+)
+
+// mjpegHub serves a multipart/x-mixed-replace JPEG stream for clients that
+// cannot decode WebRTC or the WebSocket video stream (thin clients,
+// dashboards, curl). It runs its own low-fps ffmpeg on the capture source
+// only while at least one viewer is connected.
+type mjpegHub struct {
+	ctx  context.Context
+	name string
+	fps  int
+	// width scales the frames down to this width; 0 keeps the screen size.
+	width int
+
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+	stop context.CancelFunc
+}
+
+func newMJPEGHub(ctx context.Context, name string, fps, width int) *mjpegHub {
+	return &mjpegHub{ctx: ctx, name: name, fps: fps, width: width, subs: make(map[chan []byte]struct{})}
+}
+
+func (h *mjpegHub) subscribe() chan []byte {
+	ch := make(chan []byte, 2)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[ch] = struct{}{}
+	if h.stop == nil {
+		ctx, cancel := context.WithCancel(h.ctx)
+		h.stop = cancel
+		goWorker(func() { h.produce(ctx) })
+	}
+	return ch
+}
+
+func (h *mjpegHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+	if len(h.subs) == 0 && h.stop != nil {
+		h.stop()
+		h.stop = nil
+	}
+}
+
+func (h *mjpegHub) publish(frame []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- frame:
+		default:
+			// Slow viewer; it will get the next frame.
+		}
+	}
+}
+
+// produce runs the MJPEG encoder until ctx is cancelled, restarting it
+// when it exits (e.g. after the screen is resized under x11grab).
+func (h *mjpegHub) produce(ctx context.Context) {
+	for ctx.Err() == nil {
+		ffmpegMutex.Lock()
+		drawMouse := targetDrawMouse
+		ffmpegMutex.Unlock()
+		width, height := GetScreenSize()
+		capture := currentCapture()
+		input := capture.Input(captureParams{Width: width, Height: height, FPS: h.fps, DrawMouse: drawMouse})
+
+		args := []string{"-nostats", "-loglevel", "error"}
+		args = append(args, input.Args...)
+		if h.width > 0 {
+			args = append(args, input.filterArgs(fmt.Sprintf("scale=%d:-2", h.width))...)
+		} else {
+			args = append(args, input.filterArgs()...)
+		}
+		args = append(args, "-an", "-c:v", "mjpeg", "-q:v", fmt.Sprint(mjpegQuality), "-pix_fmt", "yuvj420p", "-f", "image2pipe", "pipe:1")
+
+		log.Printf("Starting %s stream at %d fps via %s", h.name, h.fps, capture.Name())
+		cmd := exec.CommandContext(ctx, ffmpegBinary(), args...)
+		cmd.Env = append(os.Environ(), "DISPLAY="+Display)
+		cmd.Stdin = input.Stdin
+		cmd.Stderr = newStderrLog(h.name + " ffmpeg")
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			log.Printf("Failed to start %s ffmpeg: %v", h.name, err)
+			sleepCtx(ctx, 5*time.Second)
+			continue
+		}
+
+		splitJPEG(stdout, func(frame []byte) {
+			if !sessionLocked.Load() {
+				h.publish(frame)
+			}
+		})
+		err = cmd.Wait()
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("%s ffmpeg exited: %v", h.name, err)
+		sleepCtx(ctx, 1*time.Second)
+	}
+	log.Printf("%s stream stopped", h.name)
+}
+
+// splitJPEG cuts a stream of concatenated JPEG images at their end-of-image
+// markers. Entropy-coded data byte-stuffs 0xFF, so 0xFFD9 only appears at
+// the end of an image.
+func splitJPEG(r io.Reader, onFrame func([]byte)) {
+	br := bufio.NewReaderSize(r, 256*1024)
+	var buf bytes.Buffer
+	for {
+		chunk, err := br.ReadSlice(0xD9)
+		buf.Write(chunk)
+		if err == bufio.ErrBufferFull {
+			if buf.Len() > mjpegMaxFrame {
+				log.Println("MJPEG frame too large, dropping")
+				buf.Reset()
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+		b := buf.Bytes()
+		if len(b) < 2 || b[len(b)-2] != 0xFF {
+			// A 0xD9 data byte, not an end-of-image marker.
+			if buf.Len() > mjpegMaxFrame {
+				buf.Reset()
+			}
+			continue
+		}
+		if len(b) >= 4 && b[0] == 0xFF && b[1] == 0xD8 {
+			frame := make([]byte, len(b))
+			copy(frame, b)
+			onFrame(frame)
+		}
+		buf.Reset()
+	}
+}
+
+func (h *mjpegHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%s viewer connected from %s", h.name, r.RemoteAddr)
+	ch := h.subscribe()
+	defer func() {
+		h.unsubscribe(ch)
+		log.Printf("%s viewer disconnected from %s", h.name, r.RemoteAddr)
+	}()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.ctx.Done():
+			return
+		case frame := <-ch:
+			if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame)); err != nil {
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			if _, err := io.WriteString(w, "\r\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ServeSnapshot serves the next frame as a single JPEG, for pollers that do
+// not keep a stream open.
+func (h *mjpegHub) ServeSnapshot(w http.ResponseWriter, r *http.Request) {
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+	timeout := time.NewTimer(mjpegSnapshotTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-r.Context().Done():
+	case <-h.ctx.Done():
+		http.Error(w, "Server shutting down", http.StatusServiceUnavailable)
+	case <-timeout.C:
+		// No frames while the session is locked or the encoder is failing.
+		http.Error(w, "No frame available", http.StatusServiceUnavailable)
+	case frame := <-ch:
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Content-Length", fmt.Sprint(len(frame)))
+		_, _ = w.Write(frame)
+	}
+}
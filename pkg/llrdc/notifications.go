@@ -0,0 +1,135 @@
+package llrdc
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const notifyMatchRule = "type='method_call',interface='org.freedesktop.Notifications',member='Notify'"
+
+// startNotificationForwarder monitors org.freedesktop.Notifications calls on
+// the session bus and forwards them to clients as notification messages, so
+// the viewer can show toasts for notifications hidden behind other windows.
+func startNotificationForwarder(ctx context.Context) {
+	if !EnableNotifications {
+		return
+	}
+
+	goWorker(func() {
+		for ctx.Err() == nil {
+			dbusAddr := getSessionDbusAddress()
+			if dbusAddr == "" {
+				sleepCtx(ctx, 5*time.Second)
+				continue
+			}
+
+			cmd := exec.CommandContext(ctx, "dbus-monitor", "--address", dbusAddr, notifyMatchRule)
+			cmd.Env = sessionEnviron(Display)
+			runAsSessionUser(cmd)
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				log.Printf("Notification forwarder: failed to get stdout: %v", err)
+				sleepCtx(ctx, 5*time.Second)
+				continue
+			}
+			if err := cmd.Start(); err != nil {
+				log.Printf("Notification forwarder: failed to start dbus-monitor: %v", err)
+				sleepCtx(ctx, 30*time.Second)
+				continue
+			}
+			log.Println("Notification forwarder started")
+
+			parseNotifyCalls(bufio.NewScanner(stdout), func(n map[string]interface{}) {
+				// A locked session shows nothing of itself, so
+				// notifications that arrive meanwhile are dropped.
+				if sessionLocked.Load() {
+					return
+				}
+				n["type"] = "notification"
+				broadcastJSON(n)
+			})
+
+			_ = cmd.Wait()
+			sleepCtx(ctx, 2*time.Second)
+		}
+	})
+}
+
+// parseNotifyCalls reads dbus-monitor output and calls onNotify for every
+// Notify call with its app name, summary, body and expiry timeout.
+func parseNotifyCalls(scanner *bufio.Scanner, onNotify func(map[string]interface{})) {
+	var args []string
+	inCall := false
+	var pending strings.Builder
+	inString := false
+
+	flush := func() {
+		if inCall && len(args) >= 5 {
+			n := map[string]interface{}{
+				"app":     args[0],
+				"icon":    args[2],
+				"summary": args[3],
+				"body":    args[4],
+			}
+			if len(args) >= 6 {
+				if timeout, err := strconv.Atoi(args[len(args)-1]); err == nil {
+					n["timeout"] = timeout
+				}
+			}
+			onNotify(n)
+		}
+		args = nil
+		inCall = false
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if inString {
+			// Continuation of a multi-line string argument.
+			if strings.HasSuffix(line, `"`) {
+				pending.WriteString("\n" + strings.TrimSuffix(line, `"`))
+				args = append(args, pending.String())
+				inString = false
+			} else {
+				pending.WriteString("\n" + line)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "method call") || strings.HasPrefix(line, "signal") || strings.HasPrefix(line, "method return") {
+			flush()
+			inCall = strings.Contains(line, "member=Notify")
+			continue
+		}
+		if !inCall || !strings.HasPrefix(line, "   ") || strings.HasPrefix(line, "      ") {
+			// Only top-level arguments matter; nested array/dict entries
+			// (actions, hints) are indented further.
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, `string "`):
+			value := strings.TrimPrefix(trimmed, `string "`)
+			if strings.HasSuffix(value, `"`) {
+				args = append(args, strings.TrimSuffix(value, `"`))
+			} else {
+				pending.Reset()
+				pending.WriteString(value)
+				inString = true
+			}
+		case strings.HasPrefix(trimmed, "uint32 "), strings.HasPrefix(trimmed, "int32 "):
+			fields := strings.Fields(trimmed)
+			args = append(args, fields[len(fields)-1])
+		case strings.HasPrefix(trimmed, "array ["):
+			args = append(args, "")
+		}
+	}
+	flush()
+}
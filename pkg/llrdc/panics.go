@@ -0,0 +1,63 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Panic recovery for long-running loops, alongside the restarting of X11
+// processes in supervisor.go. A panic in a background goroutine would
+// otherwise take the whole server down, or, where something recovers it,
+// leave the loop silently dead: no more input, video or writes to a
+// viewer. Supervised loops are restarted instead, after logging the panic
+// and its stack trace. Restarts back off, so a loop that panics on every
+// run does not spin.
+
+const (
+	workerMinBackoff = 100 * time.Millisecond
+	workerMaxBackoff = 30 * time.Second
+)
+
+// workerPanics counts the panics recovered by supervise, for the stats.
+var workerPanics atomic.Int64
+
+// goSupervised runs fn under supervise in a goroutine tracked by workers.
+func goSupervised(ctx context.Context, name string, fn func()) {
+	goWorker(func() { supervise(ctx, name, fn) })
+}
+
+// supervise runs fn until it returns without panicking or ctx is
+// cancelled, restarting it after each panic.
+func supervise(ctx context.Context, name string, fn func()) {
+	backoff := workerMinBackoff
+	for {
+		start := time.Now()
+		if !runRecovered(name, fn) || ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > workerMaxBackoff {
+			backoff = workerMinBackoff
+		}
+		log.Printf("Restarting %s in %s", name, backoff)
+		if !sleepCtx(ctx, backoff) {
+			return
+		}
+		backoff = min(backoff*2, workerMaxBackoff)
+	}
+}
+
+// runRecovered runs fn and reports whether it panicked.
+func runRecovered(name string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			workerPanics.Add(1)
+			log.Printf("PANIC in %s: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	fn()
+	return false
+}
@@ -0,0 +1,148 @@
+package llrdc
+
+import (
+	"log"
+	"math"
+	"sync"
+)
+
+// Stylus input with pressure and tilt. The viewer turns pen Pointer Events
+// into
+//
+//	{"type":"pen","state":"move","x":0.5,"y":0.5,"pressure":0.4,
+//	 "tiltX":10,"tiltY":-5,"buttons":1,"eraser":false}
+//
+// with state "down", "move", "up" or "leave" and x/y normalized like mouse
+// coordinates. They drive a uinput screen tablet, so drawing applications
+// get real pen dynamics. The X server must pick up hotplugged evdev
+// devices (Xorg with libinput); Xvfb does not. If the tablet cannot be
+// created, pen input falls back to the mouse.
+
+const (
+	penAxisMax     = 32767
+	penPressureMax = 4095
+
+	absPressure = 0x18
+	absTiltX    = 0x1a
+	absTiltY    = 0x1b
+
+	btnToolPen    = 0x140
+	btnToolRubber = 0x141
+	btnTouch      = 0x14a
+	btnStylus     = 0x14b
+	btnStylus2    = 0x14c
+
+	inputPropDirect = 0x01
+)
+
+var (
+	penMutex     sync.Mutex
+	penTablet    *uinputDevice
+	penFailed    bool
+	penTool      uint16
+	penMouseDown bool
+)
+
+func penSetup() uinputSetup {
+	return uinputSetup{
+		name:    "LLrdc Pen Tablet",
+		vendor:  0x056a, // Wacom, so toolkits apply their tablet heuristics
+		product: 0x00ff,
+		keys:    []uint16{btnToolPen, btnToolRubber, btnTouch, btnStylus, btnStylus2},
+		abs: []uinputAbs{
+			{code: absX, max: penAxisMax},
+			{code: absY, max: penAxisMax},
+			{code: absPressure, max: penPressureMax},
+			{code: absTiltX, min: -90, max: 90},
+			{code: absTiltY, min: -90, max: 90},
+		},
+		props: []uint16{inputPropDirect},
+	}
+}
+
+// handlePen injects one pen event.
+func handlePen(msg *penMessage) {
+	if !EnablePen {
+		return
+	}
+	state := msg.State
+	x, y := cropPoint(math.Min(math.Max(msg.X, 0), 1), math.Min(math.Max(msg.Y, 0), 1))
+
+	penMutex.Lock()
+	defer penMutex.Unlock()
+	if penTablet == nil && !penFailed {
+		dev, err := newUinputDevice(penSetup())
+		if err != nil {
+			log.Printf("Pen tablet unavailable, using the mouse instead: %v", err)
+			penFailed = true
+		} else {
+			penTablet = dev
+			log.Println("Pen tablet created")
+		}
+	}
+	if penTablet == nil {
+		penAsMouse(state, x, y)
+		return
+	}
+
+	tool := uint16(btnToolPen)
+	if msg.Eraser {
+		tool = btnToolRubber
+	}
+	dev := penTablet
+	if state == "leave" {
+		if penTool != 0 {
+			dev.emit(evKey, btnTouch, 0)
+			dev.emit(evAbs, absPressure, 0)
+			dev.emit(evKey, penTool, 0)
+			dev.sync()
+			penTool = 0
+		}
+		return
+	}
+	if penTool != tool {
+		if penTool != 0 {
+			dev.emit(evKey, penTool, 0)
+		}
+		dev.emit(evKey, tool, 1)
+		penTool = tool
+	}
+
+	pressure, tiltX, tiltY, buttons := msg.Pressure, msg.TiltX, msg.TiltY, msg.Buttons
+	touching := state == "down" || (state == "move" && int(buttons)&1 != 0)
+	if !touching {
+		pressure = 0
+	}
+	dev.emit(evAbs, absX, int32(math.Round(x*penAxisMax)))
+	dev.emit(evAbs, absY, int32(math.Round(y*penAxisMax)))
+	dev.emit(evAbs, absPressure, int32(math.Round(math.Min(math.Max(pressure, 0), 1)*penPressureMax)))
+	dev.emit(evAbs, absTiltX, int32(math.Max(math.Min(tiltX, 90), -90)))
+	dev.emit(evAbs, absTiltY, int32(math.Max(math.Min(tiltY, 90), -90)))
+	dev.emit(evKey, btnTouch, boolEvent(touching))
+	dev.emit(evKey, btnStylus, boolEvent(int(buttons)&2 != 0))
+	dev.emit(evKey, btnStylus2, boolEvent(int(buttons)&4 != 0))
+	dev.sync()
+}
+
+func boolEvent(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// penAsMouse injects a pen event as pointer motion and left clicks. The
+// caller must hold penMutex.
+func penAsMouse(state string, x, y float64) {
+	injectMouseMove(x, y, Display)
+	switch state {
+	case "down":
+		penMouseDown = true
+		injectMouseButton(0, "mousedown", Display)
+	case "up", "leave":
+		if penMouseDown {
+			penMouseDown = false
+			injectMouseButton(0, "mouseup", Display)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package llrdc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// PipeWire capture through the xdg-desktop-portal ScreenCast API, the only
+// way to capture a Wayland session and the one a Flatpak sandbox allows.
+// The server asks the portal on the session bus for a monitor stream, which
+// may show the user a picker, and keeps the portal session for its
+// lifetime. Every consumer gets a PipeWire remote of its own from the
+// session and runs a GStreamer pipewiresrc pipeline on it that converts the
+// stream to raw BGRx frames of the screen size for ffmpeg's stdin. The
+// portal's restore token is kept so a renegotiated session (after the
+// user stopped sharing, say) normally does not ask again. The cursor is
+// drawn into the frames when the compositor supports it.
+
+const (
+	portalBus           = "org.freedesktop.portal.Desktop"
+	portalPath          = "/org/freedesktop/portal/desktop"
+	portalScreenCast    = "org.freedesktop.portal.ScreenCast"
+	portalRequest       = "org.freedesktop.portal.Request"
+	portalSession       = "org.freedesktop.portal.Session"
+	portalSourceMonitor = 1
+	portalCursorHidden  = 1
+	portalCursorEmbed   = 2
+	// portalPersistUntilRevoked keeps the permission beyond the session.
+	portalPersistUntilRevoked = 2
+	// portalTimeout bounds each request, which may wait for the user to
+	// pick a screen.
+	portalTimeout = 2 * time.Minute
+	// screenCastRetry is how long a failed negotiation is not retried, so
+	// a cancelled picker does not reappear every time ffmpeg restarts.
+	screenCastRetry = 30 * time.Second
+)
+
+// screenCast is a started portal session.
+type screenCast struct {
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+	node    uint32
+	closed  chan struct{}
+}
+
+var (
+	screenCastMutex sync.Mutex
+	activeCast      *screenCast
+	castRestore     string
+	castFailed      error
+	castFailedAt    time.Time
+	castRequests    int
+)
+
+// pipewireCapture reads the portal's screen stream through GStreamer.
+type pipewireCapture struct{}
+
+func (pipewireCapture) Name() string { return "pipewire" }
+
+func (pipewireCapture) Input(p captureParams) captureInput {
+	return captureInput{
+		Args: []string{
+			"-f", "rawvideo", "-pix_fmt", "bgr0",
+			"-video_size", fmt.Sprintf("%dx%d", p.Width, p.Height),
+			"-framerate", fmt.Sprintf("%d", p.FPS),
+			"-i", "pipe:0",
+		},
+		Stdin: &pipewireReader{p: p},
+	}
+}
+
+// pipewireReader runs a pipeline for one ffmpeg. It is only meant to be
+// ffmpeg's stdin: exec copies that with io.Copy, which uses WriteTo, and
+// WriteTo stops the pipeline once ffmpeg is gone.
+type pipewireReader struct {
+	p captureParams
+}
+
+func (r *pipewireReader) Read([]byte) (int, error) {
+	return 0, errors.New("pipewire capture is read with WriteTo")
+}
+
+func (r *pipewireReader) WriteTo(w io.Writer) (int64, error) {
+	remote, node, err := openScreenCast()
+	if err != nil {
+		return 0, err
+	}
+	defer remote.Close()
+
+	// keepalive-time repeats the last frame on a static screen, as
+	// x11grab does, so the encoder keeps its pace.
+	pipeline := []string{"-q",
+		"pipewiresrc", "fd=3", fmt.Sprintf("path=%d", node), "do-timestamp=true", "always-copy=true",
+		fmt.Sprintf("keepalive-time=%d", 1000/max(r.p.FPS, 1)), "!",
+		"videoconvert", "!", "videoscale", "!", "videorate", "!",
+		fmt.Sprintf("video/x-raw,format=BGRx,width=%d,height=%d,framerate=%d/1", r.p.Width, r.p.Height, r.p.FPS), "!",
+		"fdsink", "fd=1", "sync=false",
+	}
+	cmd := exec.Command("gst-launch-1.0", pipeline...)
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Stderr = newStderrLog("gst-launch")
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		log.Printf("Failed to start PipeWire pipeline: %v", err)
+		return 0, err
+	}
+	n, err := io.Copy(w, stdout)
+	cmd.Process.Kill()
+	_ = cmd.Wait()
+	return n, err
+}
+
+// openScreenCast returns a new PipeWire remote for the portal session and
+// the stream's node, starting a session if there is none.
+func openScreenCast() (*os.File, uint32, error) {
+	screenCastMutex.Lock()
+	defer screenCastMutex.Unlock()
+	if activeCast != nil {
+		select {
+		case <-activeCast.closed:
+			log.Println("Screen cast session was closed, negotiating a new one")
+			activeCast.conn.Close()
+			activeCast = nil
+		default:
+		}
+	}
+	if activeCast == nil {
+		if castFailed != nil && time.Since(castFailedAt) < screenCastRetry {
+			return nil, 0, castFailed
+		}
+		cast, err := startScreenCast()
+		if err != nil {
+			log.Printf("Screen cast portal: %v", err)
+			castFailed, castFailedAt = err, time.Now()
+			return nil, 0, err
+		}
+		castFailed = nil
+		activeCast = cast
+	}
+
+	var fd dbus.UnixFD
+	portal := activeCast.conn.Object(portalBus, portalPath)
+	err := portal.Call(portalScreenCast+".OpenPipeWireRemote", 0, activeCast.session, map[string]dbus.Variant{}).Store(&fd)
+	if err != nil {
+		// The next attempt negotiates a new session.
+		activeCast.conn.Close()
+		activeCast = nil
+		return nil, 0, fmt.Errorf("OpenPipeWireRemote: %v", err)
+	}
+	return os.NewFile(uintptr(fd), "pipewire-remote"), activeCast.node, nil
+}
+
+// startScreenCast negotiates a monitor stream with the portal. The caller
+// must hold screenCastMutex.
+func startScreenCast() (*screenCast, error) {
+	var conn *dbus.Conn
+	var err error
+	if addr := getSessionDbusAddress(); addr != "" {
+		conn, err = dbus.Connect(addr)
+	} else {
+		conn, err = dbus.ConnectSessionBus()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("session bus: %v", err)
+	}
+	cast := &screenCast{conn: conn, closed: make(chan struct{})}
+	if err := cast.negotiate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	cast.watchClosed()
+	log.Printf("Screen cast portal started: PipeWire node %d", cast.node)
+	return cast, nil
+}
+
+func (s *screenCast) negotiate() error {
+	portal := s.conn.Object(portalBus, portalPath)
+	var version, cursorModes uint32
+	if v, err := portal.GetProperty(portalScreenCast + ".version"); err == nil {
+		version, _ = v.Value().(uint32)
+	} else {
+		return fmt.Errorf("no ScreenCast portal: %v", err)
+	}
+	if v, err := portal.GetProperty(portalScreenCast + ".AvailableCursorModes"); err == nil {
+		cursorModes, _ = v.Value().(uint32)
+	}
+
+	results, err := s.request("CreateSession", map[string]dbus.Variant{
+		"session_handle_token": dbus.MakeVariant(fmt.Sprintf("llrdc_session%d", castRequests)),
+	})
+	if err != nil {
+		return err
+	}
+	switch handle := results["session_handle"].Value().(type) {
+	case string:
+		s.session = dbus.ObjectPath(handle)
+	case dbus.ObjectPath:
+		s.session = handle
+	default:
+		return errors.New("CreateSession returned no session")
+	}
+
+	options := map[string]dbus.Variant{
+		"types":    dbus.MakeVariant(uint32(portalSourceMonitor)),
+		"multiple": dbus.MakeVariant(false),
+	}
+	if cursorModes&portalCursorEmbed != 0 {
+		options["cursor_mode"] = dbus.MakeVariant(uint32(portalCursorEmbed))
+	} else if cursorModes&portalCursorHidden != 0 {
+		options["cursor_mode"] = dbus.MakeVariant(uint32(portalCursorHidden))
+	}
+	if version >= 4 {
+		options["persist_mode"] = dbus.MakeVariant(uint32(portalPersistUntilRevoked))
+		if castRestore != "" {
+			options["restore_token"] = dbus.MakeVariant(castRestore)
+		}
+	}
+	if _, err := s.request("SelectSources", options, s.session); err != nil {
+		return err
+	}
+
+	results, err = s.request("Start", map[string]dbus.Variant{}, s.session, "")
+	if err != nil {
+		return err
+	}
+	if token, ok := results["restore_token"].Value().(string); ok {
+		castRestore = token
+	}
+	// streams is a(ua{sv}): the PipeWire node and properties of each.
+	streams, _ := results["streams"].Value().([][]interface{})
+	if len(streams) == 0 || len(streams[0]) == 0 {
+		return errors.New("Start returned no stream")
+	}
+	node, ok := streams[0][0].(uint32)
+	if !ok {
+		return errors.New("Start returned no stream")
+	}
+	s.node = node
+	return nil
+}
+
+// request calls a ScreenCast method that answers with a Response signal on
+// a Request object, and returns the response's results. options gets the
+// handle token that names the Request object.
+func (s *screenCast) request(method string, options map[string]dbus.Variant, args ...interface{}) (map[string]dbus.Variant, error) {
+	castRequests++
+	token := fmt.Sprintf("llrdc%d", castRequests)
+	options["handle_token"] = dbus.MakeVariant(token)
+	sender := strings.ReplaceAll(strings.TrimPrefix(s.conn.Names()[0], ":"), ".", "_")
+	path := dbus.ObjectPath(fmt.Sprintf("%s/request/%s/%s", portalPath, sender, token))
+
+	// Subscribe before calling, or a fast response is missed.
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(portalRequest),
+		dbus.WithMatchMember("Response"),
+	}
+	if err := s.conn.AddMatchSignal(match...); err != nil {
+		return nil, fmt.Errorf("%s: %v", method, err)
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 8)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	portal := s.conn.Object(portalBus, portalPath)
+	if err := portal.Call(portalScreenCast+"."+method, 0, append(args, options)...).Err; err != nil {
+		return nil, fmt.Errorf("%s: %v", method, err)
+	}
+	timeout := time.NewTimer(portalTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Path != path || sig.Name != portalRequest+".Response" || len(sig.Body) < 2 {
+				continue
+			}
+			code, _ := sig.Body[0].(uint32)
+			results, _ := sig.Body[1].(map[string]dbus.Variant)
+			switch code {
+			case 0:
+				return results, nil
+			case 1:
+				return nil, fmt.Errorf("%s: cancelled by the user", method)
+			default:
+				return nil, fmt.Errorf("%s: refused by the portal", method)
+			}
+		case <-timeout.C:
+			return nil, fmt.Errorf("%s: no response from the portal", method)
+		}
+	}
+}
+
+// watchClosed closes s.closed when the portal ends the session, e.g. when
+// the user stops sharing.
+func (s *screenCast) watchClosed() {
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(s.session),
+		dbus.WithMatchInterface(portalSession),
+		dbus.WithMatchMember("Closed"),
+	}
+	if err := s.conn.AddMatchSignal(match...); err != nil {
+		log.Printf("Screen cast portal: cannot watch the session: %v", err)
+		return
+	}
+	signals := make(chan *dbus.Signal, 8)
+	s.conn.Signal(signals)
+	// Not a worker: it lives as long as the connection, which closes the
+	// channel.
+	go func() {
+		for sig := range signals {
+			if sig.Path == s.session && sig.Name == portalSession+".Closed" {
+				s.conn.RemoveSignal(signals)
+				close(s.closed)
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,270 @@
+package llrdc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Automatic port mapping for self-hosting behind a home router. With
+// EnablePortMapping the server asks the router to forward its HTTP port
+// (TCP), its ICE port (UDP, the same number) and the HTTP/3 port, trying
+// NAT-PMP first and UPnP IGD second. The mappings are renewed while the
+// server runs and removed when it stops. The router's external address is
+// advertised to WebRTC viewers alongside the local one, as if it had been
+// given with --webrtc-public-ip, unless that is set.
+
+const (
+	portMappingLifetime = time.Hour
+	portMappingTimeout  = 5 * time.Second
+	natpmpPort          = 5351
+)
+
+type portMapping struct {
+	proto string // "tcp" or "udp"
+	port  int
+}
+
+// portMapper is a router protocol that forwards external ports to this
+// host.
+type portMapper interface {
+	name() string
+	externalIP(ctx context.Context) (net.IP, error)
+	// add maps the external port to the same local port and returns the
+	// lifetime the router granted.
+	add(ctx context.Context, m portMapping, lifetime time.Duration) (time.Duration, error)
+	remove(ctx context.Context, m portMapping) error
+	// localIP is this host's address on the router's network.
+	localIP() net.IP
+}
+
+// portMappedIP holds the external and local addresses found by port
+// mapping, for the ICE candidates.
+var portMappedIP atomic.Pointer[[2]net.IP]
+
+// startPortMapping maps the server's ports in the background until ctx is
+// cancelled. Failures are logged and leave the server reachable as before.
+func startPortMapping(ctx context.Context) {
+	if !EnablePortMapping {
+		return
+	}
+	mappings := []portMapping{{"tcp", Port}}
+	if !Broker {
+		mappings = append(mappings, portMapping{"udp", Port})
+	}
+	if HTTP3Port > 0 {
+		mappings = append(mappings, portMapping{"udp", HTTP3Port})
+	}
+
+	goWorker(func() {
+		defer portMappedIP.Store(nil)
+		var mapper portMapper
+		for mapper == nil {
+			mapper = discoverPortMapper(ctx)
+			if mapper == nil && !sleepCtx(ctx, 5*time.Minute) {
+				return
+			}
+		}
+		log.Printf("Port mapping via %s from %s", mapper.name(), mapper.localIP())
+		defer func() {
+			removeCtx, cancel := context.WithTimeout(context.Background(), portMappingTimeout)
+			defer cancel()
+			for _, m := range mappings {
+				if err := mapper.remove(removeCtx, m); err != nil {
+					log.Printf("Removing %s port mapping %d: %v", strings.ToUpper(m.proto), m.port, err)
+				}
+			}
+		}()
+
+		for {
+			renew := portMappingLifetime / 2
+			for _, m := range mappings {
+				lifetime, err := mapper.add(ctx, m, portMappingLifetime)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("Mapping %s port %d via %s failed: %v", strings.ToUpper(m.proto), m.port, mapper.name(), err)
+					renew = time.Minute
+					continue
+				}
+				if lifetime > 0 {
+					renew = min(renew, lifetime/2)
+				}
+			}
+			if ip, err := mapper.externalIP(ctx); err != nil {
+				log.Printf("Querying the external address via %s failed: %v", mapper.name(), err)
+			} else if old := portMappedIP.Load(); old == nil || !old[0].Equal(ip) {
+				log.Printf("Port mapping external address: %s", ip)
+				if ip.IsPrivate() || ip.IsUnspecified() {
+					log.Printf("Warning: the router's external address %s is not public; it is probably behind another NAT", ip)
+				}
+				portMappedIP.Store(&[2]net.IP{ip, mapper.localIP()})
+			}
+			if !sleepCtx(ctx, renew) {
+				return
+			}
+		}
+	})
+}
+
+// discoverPortMapper finds a NAT-PMP or UPnP router, or returns nil.
+func discoverPortMapper(ctx context.Context) portMapper {
+	if gateway, err := defaultGateway(); err == nil {
+		m, err := newNATPMP(gateway)
+		if err == nil {
+			if _, err = m.externalIP(ctx); err == nil {
+				return m
+			}
+		}
+		log.Printf("No NAT-PMP router at %s: %v", gateway, err)
+	}
+	m, err := discoverUPnP(ctx)
+	if err != nil {
+		log.Printf("No UPnP router found: %v", err)
+		return nil
+	}
+	return m
+}
+
+// defaultGateway reads the IPv4 default gateway from the kernel's routing
+// table.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		// The table is in host (little-endian) byte order.
+		return net.IPv4(b[3], b[2], b[1], b[0]), nil
+	}
+	return nil, errors.New("no default route")
+}
+
+// routeLocalIP returns the local address used to reach ip.
+func routeLocalIP(ip net.IP) (net.IP, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// natpmp speaks NAT-PMP (RFC 6886) to the gateway.
+type natpmp struct {
+	gateway net.IP
+	local   net.IP
+}
+
+func newNATPMP(gateway net.IP) (*natpmp, error) {
+	local, err := routeLocalIP(gateway)
+	if err != nil {
+		return nil, err
+	}
+	return &natpmp{gateway: gateway, local: local}, nil
+}
+
+func (n *natpmp) name() string    { return "NAT-PMP" }
+func (n *natpmp) localIP() net.IP { return n.local }
+
+// call sends a request and returns the response, retrying with the
+// doubling timeouts of RFC 6886 section 3.1 (shortened to four tries).
+func (n *natpmp) call(ctx context.Context, req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: n.gateway, Port: natpmpPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	resp := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for try := 0; try < 4; try++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			size, err := conn.Read(resp)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				break
+			}
+			if size < respLen || resp[0] != 0 || resp[1] != req[1]|0x80 {
+				continue
+			}
+			if code := binary.BigEndian.Uint16(resp[2:]); code != 0 {
+				return nil, fmt.Errorf("result code %d", code)
+			}
+			return resp[:size], nil
+		}
+		timeout *= 2
+	}
+	return nil, errors.New("no response")
+}
+
+func (n *natpmp) externalIP(ctx context.Context) (net.IP, error) {
+	resp, err := n.call(ctx, []byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (n *natpmp) mapPort(ctx context.Context, m portMapping, lifetime time.Duration) (time.Duration, error) {
+	op := byte(2)
+	if m.proto == "udp" {
+		op = 1
+	}
+	// A deletion has lifetime 0 and suggests external port 0.
+	external := m.port
+	if lifetime == 0 {
+		external = 0
+	}
+	req := []byte{0, op, 0, 0}
+	req = binary.BigEndian.AppendUint16(req, uint16(m.port))
+	req = binary.BigEndian.AppendUint16(req, uint16(external))
+	req = binary.BigEndian.AppendUint32(req, uint32(lifetime/time.Second))
+	resp, err := n.call(ctx, req, 16)
+	if err != nil {
+		return 0, err
+	}
+	if got := int(binary.BigEndian.Uint16(resp[10:])); got != m.port && lifetime > 0 {
+		// ICE advertises the local port, so a different external one
+		// does not work for WebRTC.
+		return 0, fmt.Errorf("router assigned external port %d", got)
+	}
+	return time.Duration(binary.BigEndian.Uint32(resp[12:])) * time.Second, nil
+}
+
+func (n *natpmp) add(ctx context.Context, m portMapping, lifetime time.Duration) (time.Duration, error) {
+	return n.mapPort(ctx, m, lifetime)
+}
+
+func (n *natpmp) remove(ctx context.Context, m portMapping) error {
+	_, err := n.mapPort(ctx, m, 0)
+	return err
+}
@@ -0,0 +1,71 @@
+package llrdc
+
+import (
+	"encoding/binary"
+	"log"
+)
+
+const (
+	probePacketSize  = 16 * 1024
+	probePacketCount = 64
+	// probeHeadroom is the share of the measured throughput used for video,
+	// leaving room for input, audio and bursts on keyframes.
+	probeHeadroom = 0.7
+)
+
+// startBandwidthProbe queues a burst of padding packets to a newly connected
+// client. The client times their arrival and answers with probe_result,
+// which handleProbeResult turns into the initial encoder bandwidth.
+//
+// Each packet is [2][seq uint16][count uint16][padding...].
+func startBandwidthProbe(client *Client) {
+	if !EnableBandwidthProbe {
+		return
+	}
+	for i := 0; i < probePacketCount; i++ {
+		packet := make([]byte, probePacketSize)
+		packet[0] = 2 // Probe Type
+		binary.BigEndian.PutUint16(packet[1:], uint16(i))
+		binary.BigEndian.PutUint16(packet[3:], probePacketCount)
+		if !client.queue.push(packet) {
+			log.Println("Bandwidth probe: send buffer full, probe aborted")
+			return
+		}
+	}
+}
+
+func handleProbeResult(msg *probeResultMessage) {
+	bytes, ms := msg.Bytes, msg.MS
+	if bytes <= 0 || ms <= 0 {
+		return
+	}
+	mbps := bytes * 8 / (ms * 1000)
+
+	// The encoder is shared; only the first client picks its starting rate.
+	clientsMutex.Lock()
+	clientCount := len(clients)
+	clientsMutex.Unlock()
+	ffmpegMutex.Lock()
+	mode := targetMode
+	current := targetBandwidthMbps
+	auto := targetAutoQuality
+	ffmpegMutex.Unlock()
+	if clientCount != 1 || (mode != "bandwidth" && !auto) {
+		log.Printf("Bandwidth probe: measured %.1f Mbps, keeping current settings", mbps)
+		return
+	}
+
+	bw := int(mbps * probeHeadroom)
+	if bw < adaptiveMinBandwidth {
+		bw = adaptiveMinBandwidth
+	}
+	if bw > AutoMaxBandwidth {
+		bw = AutoMaxBandwidth
+	}
+	log.Printf("Bandwidth probe: measured %.1f Mbps over %.0fms, starting at %d Mbps", mbps, ms, bw)
+	if bw == current {
+		return
+	}
+	encoder.SetBitrate(bw)
+	broadcastConfig(false)
+}
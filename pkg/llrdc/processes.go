@@ -0,0 +1,209 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type sessionProcess struct {
+	PID      int    `json:"pid"`
+	PPID     int    `json:"ppid"`
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	RSSKB    int64  `json:"rssKb"`
+	Spawned  bool   `json:"spawned"`
+	Launched int64  `json:"launched,omitempty"`
+}
+
+var (
+	spawnedMutex sync.Mutex
+	// spawnedProcs maps the PID of each app started via spawn to its launch time.
+	spawnedProcs = make(map[int]time.Time)
+)
+
+func trackSpawnedProcess(pid int) {
+	spawnedMutex.Lock()
+	spawnedProcs[pid] = time.Now()
+	spawnedMutex.Unlock()
+}
+
+func untrackSpawnedProcess(pid int) {
+	spawnedMutex.Lock()
+	delete(spawnedProcs, pid)
+	spawnedMutex.Unlock()
+}
+
+// sessionRootPIDs returns the processes whose descendants belong to the
+// session: the desktop session, the kiosk app and every spawned app.
+func sessionRootPIDs() map[int]bool {
+	roots := make(map[int]bool)
+	x11Mutex.Lock()
+	if sessionCmd != nil && sessionCmd.Process != nil {
+		roots[sessionCmd.Process.Pid] = true
+	}
+	x11Mutex.Unlock()
+
+	kioskMutex.Lock()
+	if kioskPID > 0 {
+		roots[kioskPID] = true
+	}
+	kioskMutex.Unlock()
+
+	spawnedMutex.Lock()
+	for pid := range spawnedProcs {
+		roots[pid] = true
+	}
+	spawnedMutex.Unlock()
+	return roots
+}
+
+// listSessionProcesses walks /proc and returns every process started inside
+// the session. The session manager and bus daemon (the direct children of
+// dbus-run-session) are excluded since killing them tears down the desktop.
+func listSessionProcesses() []sessionProcess {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		log.Printf("Failed to read /proc: %v", err)
+		return nil
+	}
+
+	all := make(map[int]sessionProcess)
+	children := make(map[int][]int)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		p, ok := readProcess(pid)
+		if !ok {
+			continue
+		}
+		all[pid] = p
+		children[p.PPID] = append(children[p.PPID], pid)
+	}
+
+	roots := sessionRootPIDs()
+	x11Mutex.Lock()
+	sessionPID := 0
+	if sessionCmd != nil && sessionCmd.Process != nil {
+		sessionPID = sessionCmd.Process.Pid
+	}
+	x11Mutex.Unlock()
+
+	spawnedMutex.Lock()
+	launched := make(map[int]time.Time, len(spawnedProcs))
+	for pid, t := range spawnedProcs {
+		launched[pid] = t
+	}
+	spawnedMutex.Unlock()
+
+	var result []sessionProcess
+	seen := make(map[int]bool)
+	var walk func(pid int)
+	walk = func(pid int) {
+		if seen[pid] {
+			return
+		}
+		seen[pid] = true
+		if p, ok := all[pid]; ok && pid != sessionPID && p.PPID != sessionPID {
+			if t, ok := launched[pid]; ok {
+				p.Spawned = true
+				p.Launched = t.UnixMilli()
+			}
+			result = append(result, p)
+		}
+		for _, child := range children[pid] {
+			walk(child)
+		}
+	}
+	for pid := range roots {
+		walk(pid)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].PID < result[j].PID })
+	return result
+}
+
+func readProcess(pid int) (sessionProcess, bool) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return sessionProcess{}, false
+	}
+	// The comm field is parenthesised and may contain spaces.
+	s := string(stat)
+	lp := strings.IndexByte(s, '(')
+	rp := strings.LastIndexByte(s, ')')
+	if lp < 0 || rp < lp {
+		return sessionProcess{}, false
+	}
+	fields := strings.Fields(s[rp+1:])
+	if len(fields) < 2 {
+		return sessionProcess{}, false
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+
+	p := sessionProcess{PID: pid, PPID: ppid, Name: s[lp+1 : rp]}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		p.Command = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	if statm, err := os.ReadFile(filepath.Join(dir, "statm")); err == nil {
+		if f := strings.Fields(string(statm)); len(f) > 1 {
+			pages, _ := strconv.ParseInt(f[1], 10, 64)
+			p.RSSKB = pages * int64(os.Getpagesize()) / 1024
+		}
+	}
+	return p, true
+}
+
+// killSessionProcess terminates a process if, and only if, it belongs to the
+// session. force sends SIGKILL instead of SIGTERM.
+func killSessionProcess(pid int, force bool) error {
+	allowed := false
+	for _, p := range listSessionProcesses() {
+		if p.PID == pid {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("process %d is not part of the session", pid)
+	}
+
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+	log.Printf("Sending %v to session process %d", sig, pid)
+	return syscall.Kill(pid, sig)
+}
+
+func handleListProcesses(writeJSON func(interface{}) error) {
+	_ = writeJSON(map[string]interface{}{
+		"type":      "process_list",
+		"processes": listSessionProcesses(),
+	})
+}
+
+func handleKillProcess(msg *killProcessMessage, writeJSON func(interface{}) error) {
+	pid := int(msg.PID)
+
+	resp := map[string]interface{}{
+		"type":    "kill_process_result",
+		"pid":     pid,
+		"success": true,
+	}
+	if err := killSessionProcess(pid, msg.Force); err != nil {
+		resp["success"] = false
+		resp["error"] = err.Error()
+	}
+	_ = writeJSON(resp)
+}
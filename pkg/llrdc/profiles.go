@@ -0,0 +1,98 @@
+package llrdc
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// User profiles remember each authenticated identity's encoder settings.
+// The identity comes from IdentityHeader, which an authenticating reverse
+// proxy (oauth2-proxy, Authelia, ...) sets on the WebSocket upgrade request.
+// The encoder is shared, so the profile of the most recently connected user
+// is the one in effect.
+
+var (
+	profilesMutex sync.Mutex
+	profiles      map[string]persistedState
+)
+
+func profilesEnabled() bool {
+	return IdentityHeader != "" && ProfilesFile != ""
+}
+
+// requestIdentity returns the authenticated user of r, or "" if profiles
+// are disabled or the proxy did not supply one.
+func requestIdentity(r *http.Request) string {
+	if IdentityHeader == "" {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(IdentityHeader))
+}
+
+// loadProfiles reads ProfilesFile into memory. The caller must hold
+// profilesMutex.
+func loadProfiles() {
+	if profiles != nil {
+		return
+	}
+	profiles = make(map[string]persistedState)
+	data, err := os.ReadFile(ProfilesFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read profiles file %s: %v", ProfilesFile, err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		log.Printf("Ignoring invalid profiles file %s: %v", ProfilesFile, err)
+		profiles = make(map[string]persistedState)
+	}
+}
+
+// applyUserProfile switches the encoder to identity's saved settings, if it
+// has any. It reports whether a profile was applied.
+func applyUserProfile(identity string) bool {
+	if identity == "" || !profilesEnabled() {
+		return false
+	}
+	profilesMutex.Lock()
+	loadProfiles()
+	st, ok := profiles[identity]
+	profilesMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	ffmpegMutex.Lock()
+	defer ffmpegMutex.Unlock()
+	applyState(st)
+	log.Printf("Applied profile for %s (%s mode, %d Mbps, quality %d, %d fps)", identity, targetMode, targetBandwidthMbps, targetQuality, FPS)
+	if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+		ffmpegCmd.Process.Kill()
+	}
+	return true
+}
+
+// saveUserProfile records the current encoder settings as identity's
+// preferences.
+func saveUserProfile(identity string) {
+	if identity == "" || !profilesEnabled() {
+		return
+	}
+	st := currentState()
+	// The screen size follows the viewer's window, not a preference.
+	st.Width, st.Height = 0, 0
+
+	profilesMutex.Lock()
+	defer profilesMutex.Unlock()
+	loadProfiles()
+	profiles[identity] = st
+	if err := writeStateFile(ProfilesFile, profiles); err != nil {
+		log.Printf("Failed to save profiles to %s: %v", ProfilesFile, err)
+	}
+}
@@ -0,0 +1,565 @@
+package llrdc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// The messages viewers send over the WebSocket, or the SSE and
+// WebTransport signaling that stand in for it. Each message type decodes
+// into its struct below; clientMessages lists them, and is the protocol
+// definition. A message that is not JSON, has an unknown type, gives a
+// field the wrong type or leaves out a required one is refused, and the
+// viewer gets
+//
+//	{"type":"message_error","for":"config","error":"bandwidth: expected number, got string"}
+//
+// Fields a message does not define are ignored. They are logged and, once
+// per connection, reported with
+//
+//	{"type":"message_warning","for":"config","unknown_fields":["bandwith"]}
+//
+// so a misspelt setting does not go unnoticed. GET /protocol.json returns
+// a JSON Schema of every message, generated from the same structs. Fields
+// tagged protocol:"required" must be present and not null.
+
+const (
+	// protocolMaxReports limits how many different problems are logged
+	// and warned about for one connection.
+	protocolMaxReports = 64
+)
+
+type clientMessageType struct {
+	// body is the zero value of the message's struct.
+	body interface{}
+	doc  string
+}
+
+var clientMessages = map[string]clientMessageType{
+	"keydown":               {keyMessage{}, "Presses a key, named as in KeyboardEvent.key or as an X keysym."},
+	"keyup":                 {keyMessage{}, "Releases a key."},
+	"key":                   {keyMessage{}, "Types one character."},
+	"mousemove":             {mouseMessage{}, "Moves the pointer to x, y, fractions of the video."},
+	"mousedown":             {mouseMessage{}, "Presses a mouse button (0 left, 1 middle, 2 right, 3 back, 4 forward)."},
+	"mouseup":               {mouseMessage{}, "Releases a mouse button."},
+	"wheel":                 {wheelMessage{}, "Scrolls, by wheel clicks or, with unit \"pixel\", by pixels."},
+	"pinch":                 {pinchMessage{}, "A touchpad pinch, as the change in scale since the last one."},
+	"pen":                   {penMessage{}, "A pen event with pressure and tilt."},
+	"gamepad":               {gamepadMessage{}, "The state of a gamepad in the standard mapping, or its disconnection."},
+	"shortcut":              {shortcutMessage{}, "Presses a key combination such as [\"ctrl\",\"c\"]."},
+	"system_key":            {systemKeyMessage{}, "Sends a key the viewer's own system would intercept."},
+	"clipboard_set":         {clipboardSetMessage{}, "Sets the session's clipboard, and pastes it if paste is true."},
+	"config":                {configMessage{}, "Changes encoder and session settings. Fields left out do not change."},
+	"resize":                {resizeMessage{}, "Asks for a desktop size in pixels, and optionally a DPI."},
+	"rotate":                {rotateMessage{}, "Rotates the screen: normal, left, right or inverted."},
+	"crop":                  {cropMessage{}, "Crops the video to a region, a window or the focused window; no region shows the whole screen."},
+	"pointer_options":       {pointerOptionsMessage{}, "Sets this viewer's pointer confinement and edge scrolling."},
+	"bandwidth_cap":         {bandwidthCapMessage{}, "Caps the video bitrate sent to this viewer, in Mbps; 0 removes the cap."},
+	"video_format":          {videoFormatMessage{}, "Selects how video frames are sent over the WebSocket."},
+	"video_ack":             {videoAckMessage{}, "Acknowledges type 4 fragments up to seq."},
+	"probe_result":          {probeResultMessage{}, "Reports how long the bandwidth probe took to arrive."},
+	"ping":                  {pingMessage{}, "A keepalive, answered with a pong carrying the same timestamp."},
+	"webrtc_offer":          {webrtcOfferMessage{}, "An SDP offer. generation numbers the viewer's PeerConnections and is echoed on the answer and candidates."},
+	"webrtc_ice":            {webrtcICEMessage{}, "A trickled ICE candidate."},
+	"webrtc_ready":          {webrtcReadyMessage{}, "Stops video over the WebSocket once WebRTC plays."},
+	"open_urls":             {openURLsMessage{}, "Asks for links opened in the session to be sent to this viewer."},
+	"spawn":                 {spawnMessage{}, "Launches an allowed application."},
+	"list_processes":        {listProcessesMessage{}, "Asks for the session's processes."},
+	"kill_process":          {killProcessMessage{}, "Terminates a session process, or kills it if force is true."},
+	"workspace_list":        {workspaceListMessage{}, "Asks for the workspaces."},
+	"workspace_switch":      {workspaceSwitchMessage{}, "Switches to a workspace."},
+	"workspace_move_window": {workspaceMoveWindowMessage{}, "Moves the active window to a workspace."},
+	"audio_device_list":     {audioDeviceListMessage{}, "Asks for the audio devices."},
+	"lock":                  {lockMessage{}, "Locks the session."},
+	"unlock":                {unlockMessage{}, "Unlocks the session."},
+	"chat":                  {chatSendMessage{}, "Says something in the chat."},
+	"annotate":              {annotateMessage{}, "Draws on everyone's video: a pointer, a stroke, or clear."},
+}
+
+type keyMessage struct {
+	Key string `json:"key" protocol:"required"`
+}
+
+// mouseMessage is mousemove, mousedown and mouseup. mousemove needs x and
+// y, the others button; the viewer sends the rest as null.
+type mouseMessage struct {
+	X      *float64 `json:"x"`
+	Y      *float64 `json:"y"`
+	Button *float64 `json:"button"`
+}
+
+type wheelMessage struct {
+	DeltaX float64 `json:"deltaX" protocol:"required"`
+	DeltaY float64 `json:"deltaY" protocol:"required"`
+	Unit   string  `json:"unit"`
+}
+
+type pinchMessage struct {
+	Scale float64 `json:"scale" protocol:"required"`
+}
+
+type penMessage struct {
+	// State is "down", "move", "up" or "leave".
+	State    string  `json:"state"`
+	X        float64 `json:"x" protocol:"required"`
+	Y        float64 `json:"y" protocol:"required"`
+	Pressure float64 `json:"pressure"`
+	TiltX    float64 `json:"tiltX"`
+	TiltY    float64 `json:"tiltY"`
+	Buttons  float64 `json:"buttons"`
+	Eraser   bool    `json:"eraser"`
+}
+
+type gamepadMessage struct {
+	Index     float64   `json:"index" protocol:"required"`
+	Connected *bool     `json:"connected"`
+	Buttons   []float64 `json:"buttons"`
+	Axes      []float64 `json:"axes"`
+}
+
+type shortcutMessage struct {
+	Keys []string `json:"keys" protocol:"required"`
+}
+
+type systemKeyMessage struct {
+	Key string `json:"key" protocol:"required"`
+}
+
+type clipboardSetMessage struct {
+	Text  string `json:"text" protocol:"required"`
+	Paste bool   `json:"paste"`
+}
+
+// configMessage holds the settings a config message changes; nil fields
+// stay as they are.
+type configMessage struct {
+	HDPI               *float64 `json:"hdpi"`
+	DPI                *float64 `json:"dpi"`
+	Rotation           *string  `json:"rotation"`
+	VideoCodec         *string  `json:"video_codec"`
+	Chroma             *string  `json:"chroma"`
+	VBR                *bool    `json:"vbr"`
+	Mpdecimate         *bool    `json:"mpdecimate"`
+	KeyframeInterval   *float64 `json:"keyframe_interval"`
+	ContentTune        *string  `json:"content_tune"`
+	CPUEffort          *float64 `json:"cpu_effort"`
+	CPUThreads         *float64 `json:"cpu_threads"`
+	EnableDesktopMouse *bool    `json:"enable_desktop_mouse"`
+	TouchGestures      *bool    `json:"touch_gestures"`
+	EnableHybrid       *bool    `json:"enable_hybrid"`
+	SettleTime         *float64 `json:"settle_time"`
+	TileSize           *float64 `json:"tile_size"`
+	EnableAudio        *bool    `json:"enable_audio"`
+	AudioBitrate       *string  `json:"audio_bitrate"`
+	AudioDevice        *string  `json:"audio_device"`
+	AudioFrameDuration *float64 `json:"audio_frame_duration"`
+	AudioFEC           *bool    `json:"audio_fec"`
+	AudioDTX           *bool    `json:"audio_dtx"`
+	AutoQuality        *bool    `json:"auto_quality"`
+	// Bandwidth, in Mbps, selects bandwidth mode; Quality, without it,
+	// VBR quality mode.
+	Bandwidth *float64 `json:"bandwidth"`
+	Quality   *float64 `json:"quality"`
+	Framerate *float64 `json:"framerate"`
+}
+
+type resizeMessage struct {
+	Width  float64  `json:"width"`
+	Height float64  `json:"height"`
+	DPI    *float64 `json:"dpi"`
+}
+
+type rotateMessage struct {
+	Rotation string `json:"rotation" protocol:"required"`
+}
+
+// cropMessage selects a window (an X window ID, "active" or "focus") or
+// else a region given as fractions of the screen.
+type cropMessage struct {
+	Window string  `json:"window"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	// Aspect is the viewer's width divided by its height.
+	Aspect float64 `json:"aspect"`
+}
+
+type pointerOptionsMessage struct {
+	Confine    *bool `json:"confine"`
+	EdgeScroll *bool `json:"edge_scroll"`
+}
+
+type bandwidthCapMessage struct {
+	Mbps float64 `json:"mbps" protocol:"required"`
+}
+
+type videoFormatMessage struct {
+	// Format is "chunked" for timestamped WebCodecs chunks (type 3),
+	// anything else for the legacy type 1 packets.
+	Format string `json:"format" protocol:"required"`
+	// Fragments splits binary packets into acknowledged type 4
+	// fragments, see wsflow.go.
+	Fragments bool `json:"fragments"`
+}
+
+type videoAckMessage struct {
+	// Seq is the last fragment received; earlier ones are implied.
+	Seq float64 `json:"seq" protocol:"required"`
+}
+
+type probeResultMessage struct {
+	Bytes float64 `json:"bytes" protocol:"required"`
+	MS    float64 `json:"ms" protocol:"required"`
+}
+
+type pingMessage struct {
+	Timestamp float64 `json:"timestamp" protocol:"required"`
+}
+
+// sessionDescription is an RTCSessionDescriptionInit.
+type sessionDescription struct {
+	Type string `json:"type" protocol:"required"`
+	SDP  string `json:"sdp" protocol:"required"`
+}
+
+type webrtcOfferMessage struct {
+	SDP        sessionDescription `json:"sdp" protocol:"required"`
+	Generation *float64           `json:"generation"`
+	// Seamless asks for a track per window (see SeamlessWindows).
+	Seamless bool `json:"seamless"`
+}
+
+type webrtcICEMessage struct {
+	Candidate  webrtc.ICECandidateInit `json:"candidate" protocol:"required"`
+	Generation *float64                `json:"generation"`
+}
+
+type webrtcReadyMessage struct{}
+
+type openURLsMessage struct {
+	Enabled bool `json:"enabled" protocol:"required"`
+}
+
+// spawnMessage is checked by parseSpawnRequest. Without args, command is
+// split on spaces.
+type spawnMessage struct {
+	ID      string            `json:"id"`
+	Command string            `json:"command" protocol:"required"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	Cwd     string            `json:"cwd"`
+}
+
+type listProcessesMessage struct{}
+
+type killProcessMessage struct {
+	PID   float64 `json:"pid" protocol:"required"`
+	Force bool    `json:"force"`
+}
+
+type workspaceListMessage struct{}
+
+type workspaceSwitchMessage struct {
+	Index float64 `json:"index" protocol:"required"`
+}
+
+type workspaceMoveWindowMessage struct {
+	Index  float64 `json:"index" protocol:"required"`
+	Follow bool    `json:"follow"`
+}
+
+type audioDeviceListMessage struct{}
+
+type lockMessage struct{}
+
+type unlockMessage struct {
+	Password string `json:"password"`
+}
+
+type chatSendMessage struct {
+	Text string `json:"text" protocol:"required"`
+	// Name is shown for viewers without an authenticated identity.
+	Name string `json:"name"`
+}
+
+type annotateMessage struct {
+	// Kind is "pointer", "stroke" or "clear".
+	Kind   string       `json:"kind" protocol:"required"`
+	X      float64      `json:"x"`
+	Y      float64      `json:"y"`
+	Points [][2]float64 `json:"points"`
+	Color  string       `json:"color"`
+	Name   string       `json:"name"`
+}
+
+// protocolField is a struct field as it appears in JSON.
+type protocolField struct {
+	name     string
+	index    int
+	required bool
+}
+
+// protocolFields returns the JSON fields of struct type t.
+func protocolFields(t reflect.Type) []protocolField {
+	var fields []protocolField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, protocolField{name: name, index: i, required: f.Tag.Get("protocol") == "required"})
+	}
+	return fields
+}
+
+// protocolStruct returns the struct type t holds, through pointers, or
+// nil.
+func protocolStruct(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// jsonTypeName names the JSON type that decodes into t.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonTypeName(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// checkProtocolFields checks the object data against struct type t:
+// required fields must be there, and fields t does not have are returned.
+// Like encoding/json, it matches names without regard to case. Objects in
+// fields of struct type are checked too.
+func checkProtocolFields(data json.RawMessage, t reflect.Type, path string) (unknown []string, err error) {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(data, &raw) != nil {
+		// Not an object; decoding reports that.
+		return nil, nil
+	}
+	known := make(map[string]protocolField)
+	for _, f := range protocolFields(t) {
+		known[strings.ToLower(f.name)] = f
+	}
+	seen := make(map[string]json.RawMessage, len(raw))
+	for key, value := range raw {
+		f, ok := known[strings.ToLower(key)]
+		if !ok {
+			if path != "" || key != "type" {
+				unknown = append(unknown, path+key)
+			}
+			continue
+		}
+		seen[f.name] = value
+		if ft := protocolStruct(t.Field(f.index).Type); ft != nil && string(value) != "null" {
+			more, err := checkProtocolFields(value, ft, path+f.name+".")
+			if err != nil {
+				return nil, err
+			}
+			unknown = append(unknown, more...)
+		}
+	}
+	for _, f := range protocolFields(t) {
+		if value, ok := seen[f.name]; f.required && (!ok || string(value) == "null") {
+			return nil, fmt.Errorf("missing %s%s", path, f.name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// decodeClientMessage decodes a viewer message. It returns the message's
+// type, a pointer to its struct and the fields that were ignored. On error
+// msgType is set if the message had one.
+func decodeClientMessage(data []byte) (msgType string, body interface{}, unknown []string, err error) {
+	var head struct {
+		Type *string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field == "type" {
+			return "", nil, nil, errors.New("type: expected string, got " + typeErr.Value)
+		}
+		return "", nil, nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if head.Type == nil {
+		return "", nil, nil, errors.New("missing type")
+	}
+	msgType = *head.Type
+	mt, ok := clientMessages[msgType]
+	if !ok {
+		return msgType, nil, nil, fmt.Errorf("unknown message type %q", msgType)
+	}
+
+	t := reflect.TypeOf(mt.body)
+	unknown, err = checkProtocolFields(data, t, "")
+	if err != nil {
+		return msgType, nil, nil, err
+	}
+	v := reflect.New(t).Interface()
+	if err := json.Unmarshal(data, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return msgType, nil, nil, fmt.Errorf("%s: expected %s, got %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+		}
+		return msgType, nil, nil, err
+	}
+	return msgType, v, unknown, nil
+}
+
+// protocolChecker decodes the messages of one connection and reports
+// problems with them to the viewer.
+type protocolChecker struct {
+	addr      string
+	writeJSON func(interface{}) error
+	// reported holds the problems already logged, or warned about.
+	reported map[string]bool
+}
+
+func newProtocolChecker(addr string, writeJSON func(interface{}) error) *protocolChecker {
+	return &protocolChecker{addr: addr, writeJSON: writeJSON, reported: make(map[string]bool)}
+}
+
+// firstReport reports whether problem is new for this connection.
+func (p *protocolChecker) firstReport(problem string) bool {
+	if p.reported[problem] || len(p.reported) >= protocolMaxReports {
+		return false
+	}
+	p.reported[problem] = true
+	return true
+}
+
+// decode returns a message's type and a pointer to its struct, or ok
+// false if the message was refused.
+func (p *protocolChecker) decode(data []byte) (msgType string, body interface{}, ok bool) {
+	msgType, body, unknown, err := decodeClientMessage(data)
+	if err != nil {
+		if p.firstReport(msgType + "\x00" + err.Error()) {
+			if _, known := clientMessages[msgType]; known {
+				log.Printf("Refused %s message from %s: %v", msgType, p.addr, err)
+			} else {
+				log.Printf("Refused message from %s: %v", p.addr, err)
+			}
+		}
+		reply := map[string]interface{}{"type": "message_error", "error": err.Error()}
+		if msgType != "" {
+			reply["for"] = msgType
+		}
+		_ = p.writeJSON(reply)
+		return msgType, nil, false
+	}
+
+	var fresh []string
+	for _, field := range unknown {
+		if p.firstReport(msgType + "." + field) {
+			fresh = append(fresh, field)
+		}
+	}
+	if len(fresh) > 0 {
+		log.Printf("Ignoring unknown fields in %s message from %s: %s", msgType, p.addr, strings.Join(fresh, ", "))
+		_ = p.writeJSON(map[string]interface{}{"type": "message_warning", "for": msgType, "unknown_fields": fresh})
+	}
+	return msgType, body, true
+}
+
+// jsonSchema returns the JSON Schema of values of type t.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := jsonSchema(t.Elem())
+		s["type"] = []string{s["type"].(string), "null"}
+		return s
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		required := []string{}
+		for _, f := range protocolFields(t) {
+			props[f.name] = jsonSchema(t.Field(f.index).Type)
+			if f.required {
+				required = append(required, f.name)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": props, "required": required}
+	}
+	s := map[string]interface{}{"type": jsonTypeName(t)}
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s["minimum"] = 0
+	}
+	return s
+}
+
+// protocolSchema returns a JSON Schema (draft 2020-12) that every message
+// a viewer may send matches one branch of.
+func protocolSchema() map[string]interface{} {
+	types := make([]string, 0, len(clientMessages))
+	for name := range clientMessages {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	defs := map[string]interface{}{}
+	refs := make([]interface{}, 0, len(types))
+	for _, name := range types {
+		mt := clientMessages[name]
+		s := jsonSchema(reflect.TypeOf(mt.body))
+		s["title"] = name
+		s["description"] = mt.doc
+		s["properties"].(map[string]interface{})["type"] = map[string]interface{}{"const": name}
+		s["required"] = append([]string{"type"}, s["required"].([]string)...)
+		defs[name] = s
+		refs = append(refs, map[string]interface{}{"$ref": "#/$defs/" + name})
+	}
+	return map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "llrdc viewer messages",
+		"description": "Messages a viewer sends to the server over the WebSocket. Fields a message does not define are ignored, with a message_warning; a message that does not match is refused with a message_error.",
+		"oneOf":       refs,
+		"$defs":       defs,
+	}
+}
+
+func handleProtocolSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(protocolSchema())
+}
@@ -0,0 +1,36 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// publishSink republishes the encoded video to an RTSP server (e.g.
+// MediaMTX) or as plain RTP, so NVRs and other video infrastructure can
+// ingest the desktop like a camera.
+var publishSink = newRemuxSink("Publish",
+	func(codec string) bool { return true },
+	func(codec string) []string {
+		if strings.HasPrefix(PublishURL, "rtp://") {
+			// ffmpeg prints the SDP receivers need on stdout.
+			return []string{"-an", "-f", "rtp", PublishURL}
+		}
+		return []string{"-an", "-f", "rtsp", "-rtsp_transport", "tcp", PublishURL}
+	},
+)
+
+// startPublish validates PublishURL and starts republishing to it.
+func startPublish(ctx context.Context) {
+	if PublishURL == "" {
+		return
+	}
+	u, err := url.Parse(PublishURL)
+	if err != nil || (u.Scheme != "rtsp" && u.Scheme != "rtsps" && u.Scheme != "rtp") || u.Host == "" {
+		log.Printf("Ignoring --publish-url %q: expected rtsp://, rtsps:// or rtp://host:port", PublishURL)
+		return
+	}
+	log.Printf("Republishing video to %s://%s", u.Scheme, u.Host)
+	publishSink.start(ctx, nil)
+}
@@ -0,0 +1,211 @@
+package llrdc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// remuxSink copies the encoder's output into another container or protocol
+// (HLS segments, RTSP, RTP) with an ffmpeg "-c:v copy" process, so extra
+// outputs cost no encoding work. Frames are queued by broadcastVideoFrame
+// and dropped if the sink falls behind.
+type remuxSink struct {
+	name string
+	// supports reports whether the output can carry codec.
+	supports func(codec string) bool
+	// outputArgs are the ffmpeg arguments after the input.
+	outputArgs func(codec string) []string
+
+	frames  chan []byte
+	running atomic.Bool
+}
+
+func newRemuxSink(name string, supports func(string) bool, outputArgs func(string) []string) *remuxSink {
+	return &remuxSink{
+		name:       name,
+		supports:   supports,
+		outputArgs: outputArgs,
+		frames:     make(chan []byte, 120),
+	}
+}
+
+// remuxSinks lists every optional output fed from the encoder.
+var remuxSinks = []*remuxSink{hlsSink, publishSink}
+
+// writeRemuxFrame hands a frame to every running sink.
+func writeRemuxFrame(frame []byte) {
+	for _, s := range remuxSinks {
+		if !s.running.Load() {
+			continue
+		}
+		select {
+		case s.frames <- frame:
+		default:
+		}
+	}
+}
+
+// start runs the sink until ctx is cancelled, restarting ffmpeg when it
+// exits or the video codec changes. onStop runs after the last process.
+func (s *remuxSink) start(ctx context.Context, onStop func()) {
+	s.running.Store(true)
+	goWorker(func() {
+		defer func() {
+			s.running.Store(false)
+			if onStop != nil {
+				onStop()
+			}
+		}()
+		warned := ""
+		for ctx.Err() == nil {
+			codec := VideoCodec
+			if !s.supports(codec) {
+				if warned != codec {
+					log.Printf("%s output does not support %s; paused", s.name, codec)
+					warned = codec
+				}
+				s.drain(ctx, 2*time.Second)
+				continue
+			}
+			warned = ""
+			s.run(ctx, codec)
+			sleepCtx(ctx, 1*time.Second)
+		}
+	})
+}
+
+// drain discards queued frames for d.
+func (s *remuxSink) drain(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			return
+		case <-s.frames:
+		}
+	}
+}
+
+func (s *remuxSink) run(ctx context.Context, codec string) {
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-use_wallclock_as_timestamps", "1",
+		"-f", remuxInputFormat(codec), "-i", "pipe:0",
+		"-c:v", "copy",
+	}
+	args = append(args, s.outputArgs(codec)...)
+	cmd := exec.CommandContext(ctx, ffmpegBinary(), args...)
+	cmd.Stderr = newStderrLog(s.name + " ffmpeg")
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		log.Printf("Failed to start %s output: %v", s.name, err)
+		return
+	}
+	log.Printf("%s output started (%s)", s.name, codec)
+
+	var ivf *ivfWriter
+	if remuxInputFormat(codec) == "ivf" {
+		width, height := GetScreenSize()
+		ivf = &ivfWriter{w: stdin}
+		err = ivf.writeHeader(codec, width, height, FPS)
+	}
+
+	// The copy must start on a keyframe.
+	started := false
+	for err == nil && ctx.Err() == nil && VideoCodec == codec {
+		var frame []byte
+		select {
+		case <-ctx.Done():
+		case frame = <-s.frames:
+		}
+		if frame == nil {
+			break
+		}
+		if !started {
+			if !isKeyframe(codec, frame) {
+				continue
+			}
+			started = true
+		}
+		if ivf != nil {
+			err = ivf.writeFrame(frame)
+		} else {
+			_, err = stdin.Write(frame)
+		}
+	}
+	if err != nil && err != io.ErrClosedPipe {
+		log.Printf("%s output write failed: %v", s.name, err)
+	}
+	stdin.Close()
+	err = cmd.Wait()
+	if ctx.Err() == nil {
+		log.Printf("%s output exited: %v", s.name, err)
+	}
+}
+
+// remuxInputFormat returns ffmpeg's demuxer for the frames of codec. VP8 and
+// AV1 frames arrive without their IVF framing, which ivfWriter restores.
+func remuxInputFormat(codec string) string {
+	switch {
+	case strings.HasPrefix(codec, "h264"):
+		return "h264"
+	case strings.HasPrefix(codec, "h265"):
+		return "hevc"
+	}
+	return "ivf"
+}
+
+// ivfWriter re-wraps VP8/AV1 frames in the IVF container.
+type ivfWriter struct {
+	w   io.Writer
+	pts uint64
+}
+
+// writeHeader starts the stream with a timebase of 1/rate seconds.
+func (v *ivfWriter) writeHeader(codec string, width, height, rate int) error {
+	fourcc := "VP80"
+	if strings.HasPrefix(codec, "av1") {
+		fourcc = "AV01"
+	}
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[6:], 32)
+	copy(header[8:], fourcc)
+	binary.LittleEndian.PutUint16(header[12:], uint16(width))
+	binary.LittleEndian.PutUint16(header[14:], uint16(height))
+	binary.LittleEndian.PutUint32(header[16:], uint32(rate))
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	_, err := v.w.Write(header)
+	return err
+}
+
+// writeFrame writes frame one timebase tick after the previous one.
+func (v *ivfWriter) writeFrame(frame []byte) error {
+	err := v.writeFrameAt(frame, v.pts)
+	v.pts++
+	return err
+}
+
+// writeFrameAt writes frame with an explicit pts in timebase units.
+func (v *ivfWriter) writeFrameAt(frame []byte, pts uint64) error {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(header[4:], pts)
+	if _, err := v.w.Write(header); err != nil {
+		return err
+	}
+	_, err := v.w.Write(frame)
+	return err
+}
@@ -0,0 +1,165 @@
+package llrdc
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-side policy for viewer resize requests. Viewers send their window
+// size whenever it changes; the policy decides the desktop size actually
+// used:
+//
+//   - "follow" (default): the requested size, snapped down to ResizeStep.
+//   - "aspect": the largest ResizeAspect rectangle inside the requested size.
+//   - "fixed": the largest ResizeModes entry that fits, or the smallest one
+//     if none does.
+//
+// Requests arriving within ResizeDebounce of each other are coalesced, so
+// dragging a window edge restarts the encoder once rather than per step.
+
+type resizeReply func(v interface{}) error
+
+var (
+	resizeMutex   sync.Mutex
+	resizeTimer   *time.Timer
+	resizePending struct {
+		width, height int
+		replies       []resizeReply
+	}
+)
+
+// requestResize applies a viewer's resize request after ResizeDebounce and
+// answers every viewer whose request was coalesced into it with a
+// resize_result message.
+func requestResize(width, height int, reply resizeReply) {
+	if ResizeDebounce <= 0 {
+		applyResize(width, height, []resizeReply{reply})
+		return
+	}
+	resizeMutex.Lock()
+	defer resizeMutex.Unlock()
+	resizePending.width, resizePending.height = width, height
+	resizePending.replies = append(resizePending.replies, reply)
+	delay := time.Duration(ResizeDebounce) * time.Millisecond
+	if resizeTimer != nil {
+		resizeTimer.Reset(delay)
+		return
+	}
+	resizeTimer = time.AfterFunc(delay, func() {
+		resizeMutex.Lock()
+		width, height := resizePending.width, resizePending.height
+		replies := resizePending.replies
+		resizePending.replies = nil
+		resizeMutex.Unlock()
+		applyResize(width, height, replies)
+	})
+}
+
+func applyResize(width, height int, replies []resizeReply) {
+	policyW, policyH := resizeForPolicy(width, height)
+	changed := SetScreenSize(policyW, policyH)
+	clampedW, clampedH := GetScreenSize()
+	if changed {
+		log.Printf("Received resize: %dx%d (clamped to %dx%d)", width, height, clampedW, clampedH)
+		if !TestPattern {
+			if err := resizeDisplay(clampedW, clampedH); err != nil {
+				log.Printf("Resize failed: %v", err)
+			}
+			go fitKioskWindow()
+		}
+		encoder.Resize(clampedW, clampedH)
+		broadcastConfig(true)
+	}
+
+	result := map[string]interface{}{
+		"type":            "resize_result",
+		"requestedWidth":  width,
+		"requestedHeight": height,
+		"width":           clampedW,
+		"height":          clampedH,
+		"policy":          resizePolicyName(),
+		"changed":         changed,
+	}
+	for _, reply := range replies {
+		_ = reply(result)
+	}
+}
+
+func resizePolicyName() string {
+	switch ResizePolicy {
+	case "aspect", "fixed":
+		return ResizePolicy
+	}
+	return "follow"
+}
+
+// resizeForPolicy maps a requested size to the size ResizePolicy allows,
+// before SetScreenSize clamps it to the framebuffer limits.
+func resizeForPolicy(width, height int) (int, int) {
+	switch resizePolicyName() {
+	case "aspect":
+		if aw, ah, ok := parseSize(ResizeAspect, ":"); ok {
+			if width*ah > height*aw {
+				width = height * aw / ah
+			} else {
+				height = width * ah / aw
+			}
+		} else {
+			log.Printf("Ignoring invalid resize aspect %q", ResizeAspect)
+		}
+	case "fixed":
+		if w, h, ok := fixedResizeMode(width, height); ok {
+			return w, h
+		}
+		log.Printf("No valid entries in resize modes %q, following the viewer", ResizeModes)
+	}
+	return snapToStep(width), snapToStep(height)
+}
+
+// fixedResizeMode picks the largest ResizeModes entry that fits in
+// width x height, falling back to the smallest entry.
+func fixedResizeMode(width, height int) (int, int, bool) {
+	bestW, bestH, smallW, smallH := 0, 0, 0, 0
+	for _, mode := range strings.Split(ResizeModes, ",") {
+		w, h, ok := parseSize(strings.TrimSpace(mode), "x")
+		if !ok {
+			continue
+		}
+		if w <= width && h <= height && w*h > bestW*bestH {
+			bestW, bestH = w, h
+		}
+		if smallW == 0 || w*h < smallW*smallH {
+			smallW, smallH = w, h
+		}
+	}
+	if bestW > 0 {
+		return bestW, bestH, true
+	}
+	return smallW, smallH, smallW > 0
+}
+
+// parseSize parses "AxB" style pairs of positive integers.
+func parseSize(s, sep string) (int, int, bool) {
+	a, b, ok := strings.Cut(s, sep)
+	if !ok {
+		return 0, 0, false
+	}
+	w, errW := strconv.Atoi(strings.TrimSpace(a))
+	h, errH := strconv.Atoi(strings.TrimSpace(b))
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
+// snapToStep rounds n down to a multiple of ResizeStep. Encoders work in
+// 16x16 macroblocks, so a step of 16 avoids padded edge blocks.
+func snapToStep(n int) int {
+	if ResizeStep <= 1 || n < ResizeStep {
+		return n
+	}
+	return n / ResizeStep * ResizeStep
+}
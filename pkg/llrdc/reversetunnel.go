@@ -0,0 +1,176 @@
+package llrdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Reverse connection mode, for desktops behind NAT with no port forwarding.
+// With ReverseSSH set, the server dials out to an SSH relay and asks it to
+// forward a port (ssh -R), then serves HTTP on connections arriving there
+// exactly as on its own port. Any OpenSSH server works as the rendezvous
+// point: a reverse proxy on the relay, or GatewayPorts, exposes the
+// forwarded port. The tunnel is re-established with backoff when it drops.
+//
+// Only the HTTP port is tunnelled. WebRTC media still needs a path between
+// viewer and desktop (STUN or TURN); where there is none, viewers fall back
+// to video over the WebSocket, which the tunnel carries.
+
+const (
+	reverseSSHTimeout      = 15 * time.Second
+	reverseSSHKeepalive    = 30 * time.Second
+	reverseSSHMaxBackoff   = time.Minute
+	reverseSSHDefaultPort  = "22"
+	reverseSSHKeepaliveReq = "keepalive@openssh.com"
+)
+
+type reverseTunnel struct {
+	addr   string
+	remote string
+	config *ssh.ClientConfig
+}
+
+// newReverseTunnel validates the reverse SSH settings, returning nil when
+// reverse connection mode is off.
+func newReverseTunnel() (*reverseTunnel, error) {
+	if ReverseSSH == "" {
+		return nil, nil
+	}
+	user, addr, ok := strings.Cut(ReverseSSH, "@")
+	if !ok || user == "" || addr == "" {
+		return nil, fmt.Errorf("--reverse-ssh must be user@host[:port], got %q", ReverseSSH)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, reverseSSHDefaultPort)
+	}
+
+	home, _ := os.UserHomeDir()
+	keyPath := ReverseSSHKey
+	if keyPath == "" {
+		keyPath = filepath.Join(home, ".ssh", "id_ed25519")
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading the reverse SSH key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the reverse SSH key %s: %v", keyPath, err)
+	}
+	knownHostsPath := ReverseSSHKnownHosts
+	if knownHostsPath == "" {
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading reverse SSH known hosts: %v", err)
+	}
+
+	remote := ReverseSSHRemote
+	if remote == "" {
+		remote = "localhost:" + strconv.Itoa(Port)
+	}
+	return &reverseTunnel{
+		addr:   addr,
+		remote: remote,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         reverseSSHTimeout,
+		},
+	}, nil
+}
+
+// run keeps the tunnel up and serves srv on it until ctx is cancelled or
+// srv is shut down.
+func (t *reverseTunnel) run(ctx context.Context, srv *http.Server) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := t.serve(ctx, srv)
+		if errors.Is(err, http.ErrServerClosed) || ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > reverseSSHMaxBackoff {
+			backoff = time.Second
+		}
+		log.Printf("Reverse tunnel to %s down: %v; retrying in %s", t.addr, err, backoff)
+		if !sleepCtx(ctx, backoff) {
+			return
+		}
+		backoff = min(backoff*2, reverseSSHMaxBackoff)
+	}
+}
+
+// serve runs one tunnel connection until it fails.
+func (t *reverseTunnel) serve(ctx context.Context, srv *http.Server) error {
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, reverseSSHTimeout)
+	conn, err := d.DialContext(dialCtx, "tcp", t.addr)
+	cancel()
+	if err != nil {
+		return err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+
+	ln, err := client.Listen("tcp", t.remote)
+	if err != nil {
+		return fmt.Errorf("forwarding %s on the relay: %v", t.remote, err)
+	}
+	log.Printf("Reverse tunnel up: %s on %s forwards to this server", t.remote, t.addr)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Keepalives notice a dead relay that would otherwise leave
+		// Serve waiting forever.
+		ticker := time.NewTicker(reverseSSHKeepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				client.Close()
+				return
+			case <-ticker.C:
+				if _, _, err := client.SendRequest(reverseSSHKeepaliveReq, true, nil); err != nil {
+					client.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	if serverTLS != nil {
+		err = srv.ServeTLS(ln, "", "")
+	} else {
+		err = srv.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if werr := client.Wait(); werr != nil {
+		return werr
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Screen rotation for tablet clients used in portrait orientation. x11grab
+// captures the root window after rotation, so the video is already upright
+// and input only has to follow the rotated geometry, which execMouseMove
+// reads from GetScreenSize.
+
+var (
+	rotationMutex  sync.Mutex
+	screenRotation = "normal"
+)
+
+func validRotation(rotation string) bool {
+	switch rotation {
+	case "normal", "left", "right", "inverted":
+		return true
+	}
+	return false
+}
+
+func isPortrait(rotation string) bool {
+	return rotation == "left" || rotation == "right"
+}
+
+// GetRotation returns the current xrandr rotation.
+func GetRotation() string {
+	rotationMutex.Lock()
+	defer rotationMutex.Unlock()
+	return screenRotation
+}
+
+// SetRotation rotates the display to rotation ("normal", "left", "right" or
+// "inverted"), swapping the screen size when switching between landscape
+// and portrait. It reports whether the screen size changed, in which case
+// the caller must restart the encoder. If the X server cannot rotate, it
+// fails and the rotation stays as it was.
+func SetRotation(rotation string) (bool, error) {
+	if !validRotation(rotation) {
+		return false, fmt.Errorf("invalid rotation %q", rotation)
+	}
+	rotationMutex.Lock()
+	defer rotationMutex.Unlock()
+	if rotation == screenRotation {
+		return false, nil
+	}
+
+	if !TestPattern {
+		log.Printf("Rotating display to %s", rotation)
+		env := sessionEnviron(Display)
+		if err := runWithEnv("xrandr", []string{"--orientation", rotation}, env); err != nil {
+			return false, fmt.Errorf("xrandr --orientation %s failed: %v", rotation, err)
+		}
+	}
+	swap := isPortrait(rotation) != isPortrait(screenRotation)
+	screenRotation = rotation
+	if !swap {
+		return false, nil
+	}
+	width, height := GetScreenSize()
+	resized := SetScreenSize(height, width)
+	if TestPattern {
+		return resized, nil
+	}
+	// The size may have been clamped to the framebuffer limits, so make the
+	// root window match what the encoder will capture.
+	if err := resizeDisplay(GetScreenSize()); err != nil {
+		return resized, err
+	}
+	return resized, nil
+}
+
+// rotateDisplay applies a rotation requested by a viewer and restarts the
+// encoder at the new size. It reports whether the size changed.
+func rotateDisplay(rotation string) bool {
+	resized, err := SetRotation(rotation)
+	if err != nil {
+		log.Printf("Rotate failed: %v", err)
+	}
+	if resized {
+		if !TestPattern {
+			go fitKioskWindow()
+		}
+		encoder.Resize(GetScreenSize())
+	}
+	return resized
+}
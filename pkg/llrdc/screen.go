@@ -1,4 +1,4 @@
-package main
+package llrdc
 
 import "sync/atomic"
 
@@ -74,9 +74,13 @@ func SetScreenSize(width, height int) bool {
 
 	screenWidth.Store(int64(width))
 	screenHeight.Store(int64(height))
+	scheduleStateSave()
 	return true
 }
 
+// GetScreenSize returns the current desktop size. It is the single source
+// of geometry for capture (ffmpeg's -video_size) and input injection, so
+// the two always agree after a resize.
 func GetScreenSize() (int, int) {
 	width := screenWidth.Load()
 	height := screenHeight.Load()
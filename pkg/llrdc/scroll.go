@@ -0,0 +1,46 @@
+package llrdc
+
+import (
+	"math"
+	"sync"
+)
+
+// Pixel-precise scrolling. Touchpads and most browsers report wheel input
+// in pixels, while X applications only understand whole wheel clicks
+// (buttons 4-7), and Xvfb offers no XInput2 smooth-scroll valuators. The
+// viewer therefore sends raw pixel deltas, and the server carries the
+// fraction of a click over to the next event. A slow two-finger drag then
+// still scrolls, and a fast one turns into a steady run of clicks that
+// xdotool spaces out rather than a few large jumps.
+
+var (
+	scrollMutex      sync.Mutex
+	scrollRemainderX float64
+	scrollRemainderY float64
+)
+
+// accumulateScroll adds delta pixels to *remainder and returns the whole
+// clicks it now holds. A change of direction drops the old remainder.
+func accumulateScroll(remainder *float64, delta float64) float64 {
+	if delta == 0 {
+		return 0
+	}
+	if (*remainder < 0) != (delta < 0) {
+		*remainder = 0
+	}
+	*remainder += delta
+	steps := math.Trunc(*remainder / float64(ScrollStepPixels))
+	*remainder -= steps * float64(ScrollStepPixels)
+	return steps
+}
+
+// injectPixelScroll scrolls by dx, dy pixels of viewer wheel input.
+func injectPixelScroll(dx, dy float64, display string) {
+	scrollMutex.Lock()
+	stepsX := accumulateScroll(&scrollRemainderX, dx)
+	stepsY := accumulateScroll(&scrollRemainderY, dy)
+	scrollMutex.Unlock()
+	if stepsX != 0 || stepsY != 0 {
+		injectMouseWheel(stepsX, stepsY, display)
+	}
+}
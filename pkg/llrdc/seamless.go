@@ -0,0 +1,425 @@
+package llrdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/composite"
+	"github.com/jezek/xgb/xproto"
+	"github.com/pion/webrtc/v4"
+)
+
+// Seamless windows. With SeamlessWindows an advanced client can show remote
+// applications as windows of its own desktop instead of one remote
+// desktop, like RDP's RemoteApp. A viewer asks for it with "seamless":true
+// in its webrtc_offer, with seamlessSlots extra recvonly video transceivers
+// after the desktop's video and audio and a DataChannel labelled "windows".
+// Each extra transceiver gets a VP8 slot track (window0, window1, ...),
+// and the topmost viewable top-level windows, as the window manager
+// stacks them in _NET_CLIENT_LIST_STACKING, are each given a slot. The
+// server redirects the root's children with XComposite, so a window's
+// contents stay available while other windows cover it, and runs an ffmpeg
+// per slot that grabs just that window, without its decorations. The
+// DataChannel gets a "windows" message whenever a window moves, resizes,
+// changes title, slot or stacking:
+//
+//	{"type":"windows","screen":{"width":1920,"height":1080},
+//	 "windows":[{"slot":0,"track":"window0","id":4194311,"title":"xterm",
+//	             "x":10,"y":40,"width":640,"height":480,"z":0}]}
+//
+// z orders the windows from the bottom. Input is sent as usual, with
+// positions relative to the whole screen. The slot encoders only run while
+// a "windows" channel is open; a window that changes size or slot restarts
+// its encoder, and a new channel restarts all of them for a keyframe.
+// Docks and desktop windows are left out.
+
+const (
+	seamlessSlots        = 4
+	seamlessPollInterval = 250 * time.Millisecond
+)
+
+// seamlessWindow is a window in a "windows" message.
+type seamlessWindow struct {
+	Slot   int    `json:"slot"`
+	Track  string `json:"track"`
+	ID     uint32 `json:"id"`
+	Title  string `json:"title"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Z      int    `json:"z"`
+}
+
+// windowSlot is a slot track and the encoder feeding it.
+type windowSlot struct {
+	track *syncedTrack
+	// window and size are what the encoder grabs, while cancel is set.
+	window uint32
+	w, h   int
+	cancel context.CancelFunc
+}
+
+var (
+	seamlessOnce  sync.Once
+	seamlessMutex sync.Mutex
+	windowSlots   [seamlessSlots]*windowSlot
+	// seamlessChannels are the open "windows" DataChannels.
+	seamlessChannels = map[*webrtc.DataChannel]bool{}
+	// seamlessMessage is the latest "windows" message.
+	seamlessMessage []byte
+	// seamlessFresh is set when a channel opened since the last poll.
+	seamlessFresh bool
+)
+
+// initWindowSlots creates the slot tracks, once.
+func initWindowSlots() {
+	seamlessOnce.Do(func() {
+		for i := range windowSlots {
+			id := fmt.Sprintf("window%d", i)
+			track, err := newSyncedTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, id, id)
+			if err != nil {
+				log.Printf("Failed to create the %s track: %v", id, err)
+				return
+			}
+			windowSlots[i] = &windowSlot{track: track}
+		}
+	})
+}
+
+// addWindowTracks adds the slot tracks to a new PeerConnection and serves
+// window updates on its "windows" DataChannel.
+func addWindowTracks(pc *webrtc.PeerConnection) error {
+	initWindowSlots()
+	for _, slot := range windowSlots {
+		if slot == nil {
+			return fmt.Errorf("no window tracks")
+		}
+		sender, err := pc.AddTrack(slot.track)
+		if err != nil {
+			return err
+		}
+		go func() {
+			// Drain RTCP so the interceptors keep working.
+			for {
+				if _, _, err := sender.ReadRTCP(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Label() != "windows" {
+			return
+		}
+		dc.OnOpen(func() {
+			seamlessMutex.Lock()
+			seamlessChannels[dc] = true
+			seamlessFresh = true
+			msg := seamlessMessage
+			seamlessMutex.Unlock()
+			if msg != nil {
+				_ = dc.SendText(string(msg))
+			}
+		})
+		dc.OnClose(func() {
+			seamlessMutex.Lock()
+			delete(seamlessChannels, dc)
+			seamlessMutex.Unlock()
+		})
+	})
+	return nil
+}
+
+// seamlessAtoms are the atoms the window tracker reads.
+type seamlessAtoms struct {
+	stacking, name, utf8, windowType, dock, desktop xproto.Atom
+}
+
+// startSeamlessWindows tracks the top-level windows and runs the slot
+// encoders while seamless viewers are connected.
+func startSeamlessWindows(ctx context.Context, display string) {
+	if !SeamlessWindows {
+		return
+	}
+	goWorker(func() {
+		var X *xgb.Conn
+		var err error
+		for i := 0; i < 10; i++ {
+			if !sleepCtx(ctx, 2*time.Second) {
+				return
+			}
+			X, err = xgb.NewConnDisplay(display)
+			if err == nil {
+				break
+			}
+			log.Printf("Seamless windows attempt %d: failed to connect to X: %v", i+1, err)
+		}
+		if err != nil {
+			log.Printf("Seamless windows failed to initialize after retries")
+			return
+		}
+		defer X.Close()
+
+		root := xproto.Setup(X).DefaultScreen(X).Root
+		// The redirection lasts as long as this connection.
+		if err := composite.Init(X); err != nil {
+			log.Printf("Seamless windows: no XComposite extension: %v", err)
+			return
+		}
+		if err := composite.RedirectSubwindowsChecked(X, root, composite.RedirectAutomatic).Check(); err != nil {
+			log.Printf("Seamless windows: failed to redirect the windows: %v", err)
+			return
+		}
+		var atoms seamlessAtoms
+		for name, atom := range map[string]*xproto.Atom{
+			"_NET_CLIENT_LIST_STACKING":   &atoms.stacking,
+			"_NET_WM_NAME":                &atoms.name,
+			"UTF8_STRING":                 &atoms.utf8,
+			"_NET_WM_WINDOW_TYPE":         &atoms.windowType,
+			"_NET_WM_WINDOW_TYPE_DOCK":    &atoms.dock,
+			"_NET_WM_WINDOW_TYPE_DESKTOP": &atoms.desktop,
+		} {
+			reply, err := xproto.InternAtom(X, false, uint16(len(name)), name).Reply()
+			if err != nil {
+				log.Printf("Seamless windows: %v", err)
+				return
+			}
+			*atom = reply.Atom
+		}
+		log.Printf("Seamless windows: streaming up to %d windows to viewers that ask", seamlessSlots)
+		defer stopWindowEncoders()
+
+		for sleepCtx(ctx, seamlessPollInterval) {
+			seamlessMutex.Lock()
+			active := len(seamlessChannels) > 0
+			fresh := seamlessFresh
+			seamlessFresh = false
+			seamlessMutex.Unlock()
+			if !active {
+				stopWindowEncoders()
+				continue
+			}
+			windows, err := listWindows(X, root, atoms)
+			if err != nil {
+				// The X server is gone, e.g. Xvfb was restarted.
+				log.Printf("Seamless windows: %v", err)
+				return
+			}
+			updateWindowSlots(ctx, windows, fresh)
+		}
+	})
+}
+
+// listWindows returns the viewable top-level windows from the bottom up.
+func listWindows(X *xgb.Conn, root xproto.Window, atoms seamlessAtoms) ([]seamlessWindow, error) {
+	reply, err := xproto.GetProperty(X, false, root, atoms.stacking, xproto.AtomWindow, 0, 4096).Reply()
+	if err != nil {
+		return nil, err
+	}
+	var windows []seamlessWindow
+	for i := 0; i+4 <= len(reply.Value) && reply.Format == 32; i += 4 {
+		id := xproto.Window(xgb.Get32(reply.Value[i:]))
+		attrs, err := xproto.GetWindowAttributes(X, id).Reply()
+		if err != nil || attrs.MapState != xproto.MapStateViewable {
+			continue
+		}
+		if types, err := xproto.GetProperty(X, false, id, atoms.windowType, xproto.AtomAtom, 0, 16).Reply(); err == nil {
+			skip := false
+			for j := 0; j+4 <= len(types.Value) && types.Format == 32; j += 4 {
+				if t := xproto.Atom(xgb.Get32(types.Value[j:])); t == atoms.dock || t == atoms.desktop {
+					skip = true
+				}
+			}
+			if skip {
+				continue
+			}
+		}
+		geom, err := xproto.GetGeometry(X, xproto.Drawable(id)).Reply()
+		if err != nil || geom.Width < 2 || geom.Height < 2 {
+			continue
+		}
+		pos, err := xproto.TranslateCoordinates(X, id, root, 0, 0).Reply()
+		if err != nil {
+			continue
+		}
+		windows = append(windows, seamlessWindow{
+			Slot:   -1,
+			ID:     uint32(id),
+			Title:  windowTitle(X, id, atoms),
+			X:      int(pos.DstX),
+			Y:      int(pos.DstY),
+			Width:  int(geom.Width),
+			Height: int(geom.Height),
+		})
+	}
+	return windows, nil
+}
+
+// windowTitle returns a window's _NET_WM_NAME, or its WM_NAME.
+func windowTitle(X *xgb.Conn, id xproto.Window, atoms seamlessAtoms) string {
+	if reply, err := xproto.GetProperty(X, false, id, atoms.name, atoms.utf8, 0, 256).Reply(); err == nil && len(reply.Value) > 0 {
+		return string(reply.Value)
+	}
+	if reply, err := xproto.GetProperty(X, false, id, xproto.AtomWmName, xproto.AtomString, 0, 256).Reply(); err == nil {
+		return string(reply.Value)
+	}
+	return ""
+}
+
+// updateWindowSlots gives the topmost windows a slot, keeping the slots of
+// windows that still have one, starts or restarts their encoders and sends
+// the new layout if it changed. restart restarts every encoder.
+func updateWindowSlots(ctx context.Context, windows []seamlessWindow, restart bool) {
+	if len(windows) > seamlessSlots {
+		windows = windows[len(windows)-seamlessSlots:]
+	}
+	seamlessMutex.Lock()
+	taken := [seamlessSlots]bool{}
+	for i := range windows {
+		for s, slot := range windowSlots {
+			if slot != nil && slot.cancel != nil && slot.window == windows[i].ID {
+				windows[i].Slot = s
+				taken[s] = true
+			}
+		}
+	}
+	for i := range windows {
+		for s := 0; windows[i].Slot < 0 && s < seamlessSlots; s++ {
+			if !taken[s] && windowSlots[s] != nil {
+				windows[i].Slot = s
+				taken[s] = true
+			}
+		}
+	}
+
+	for s, slot := range windowSlots {
+		if slot == nil {
+			continue
+		}
+		var win *seamlessWindow
+		for i := range windows {
+			if windows[i].Slot == s {
+				win = &windows[i]
+			}
+		}
+		if win == nil {
+			slot.stop()
+			continue
+		}
+		if restart || slot.cancel == nil || slot.window != win.ID || slot.w != win.Width || slot.h != win.Height {
+			slot.stop()
+			slot.window, slot.w, slot.h = win.ID, win.Width, win.Height
+			var slotCtx context.Context
+			slotCtx, slot.cancel = context.WithCancel(ctx)
+			go runWindowEncoder(slotCtx, slot, win.ID)
+		}
+	}
+
+	for i := range windows {
+		windows[i].Z = i
+		windows[i].Track = fmt.Sprintf("window%d", windows[i].Slot)
+	}
+	width, height := GetScreenSize()
+	msg, _ := json.Marshal(map[string]interface{}{
+		"type":    "windows",
+		"screen":  map[string]int{"width": width, "height": height},
+		"windows": windows,
+	})
+	var channels []*webrtc.DataChannel
+	if !bytes.Equal(msg, seamlessMessage) {
+		seamlessMessage = msg
+		for dc := range seamlessChannels {
+			channels = append(channels, dc)
+		}
+	}
+	seamlessMutex.Unlock()
+	for _, dc := range channels {
+		_ = dc.SendText(string(msg))
+	}
+}
+
+// stop stops the slot's encoder. The caller must hold seamlessMutex.
+func (s *windowSlot) stop() {
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+}
+
+func stopWindowEncoders() {
+	seamlessMutex.Lock()
+	defer seamlessMutex.Unlock()
+	for _, slot := range windowSlots {
+		if slot != nil {
+			slot.stop()
+		}
+	}
+	seamlessMessage = nil
+}
+
+// runWindowEncoder grabs one window into a slot track until ctx ends. An
+// ffmpeg that exits by itself frees the slot, and the tracker starts a new
+// one on its next poll.
+func runWindowEncoder(ctx context.Context, slot *windowSlot, window uint32) {
+	ffmpegMutex.Lock()
+	quality := targetQuality
+	fps := FPS
+	cpuEffort := targetCpuEffort
+	keyframeInterval := targetKeyframeInterval
+	contentTune := targetContentTune
+	ffmpegMutex.Unlock()
+	cpuEffort, threads := resolveEncoderSettings(cpuEffort, 1)
+
+	args := []string{"-nostats", "-loglevel", "error",
+		"-framerate", fmt.Sprintf("%d", fps),
+		"-f", "x11grab", "-draw_mouse", "0",
+		"-window_id", fmt.Sprintf("0x%x", window),
+		"-i", Display + ".0",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2,format=yuv420p",
+		"-an",
+	}
+	args = append(args, buildVP8Args("quality", 0, quality, fps, cpuEffort, threads, true, keyframeInterval, contentTune)...)
+
+	cmd := exec.CommandContext(ctx, ffmpegBinary(), args...)
+	cmd.Env = append(os.Environ(), "DISPLAY="+Display)
+	cmd.Stderr = newStderrLog("window ffmpeg")
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		log.Printf("Failed to start the encoder for window 0x%x: %v", window, err)
+		return
+	}
+	splitIVF(stdout, func(frame []byte) {
+		// Encoding goes on while the session is locked, so the window
+		// comes back at once, but nothing of it is sent.
+		if sessionLocked.Load() {
+			return
+		}
+		_ = slot.track.writeFrame(frame[frameHeadroom:], time.Now())
+	})
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	log.Printf("Encoder for window 0x%x exited: %v", window, err)
+	if sleepCtx(ctx, time.Second) {
+		seamlessMutex.Lock()
+		// ctx is live, so the slot still belongs to this encoder.
+		if ctx.Err() == nil {
+			slot.stop()
+		}
+		seamlessMutex.Unlock()
+	}
+}
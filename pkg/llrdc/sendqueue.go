@@ -0,0 +1,247 @@
+package llrdc
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Per-client send queues for binary packets (WebSocket video, probes). A
+// viewer that cannot keep up fills its queue, and further packets are
+// dropped rather than blocking the encoder. The queue is bounded in bytes
+// by about sendQueueTarget of what the connection has been draining, so a
+// fast viewer may buffer a keyframe burst while a slow one is told early
+// that it is falling behind: the writer sends quality_degraded with the
+// drop counts, and with BackpressureCap also caps the viewer's video
+// (bwcap.go) just below the rate it drains. The cap is raised again while
+// nothing is dropped, and removed with quality_restored once it exceeds
+// the encoder's bitrate.
+
+const (
+	sendQueueMaxPackets = 300
+	sendQueueMinBytes   = 2 << 20
+	sendQueueMaxBytes   = 32 << 20
+	sendQueueTarget     = time.Second
+	// drainMinSample is how long a backlog must last, and how many
+	// writes it must span, before it gives a drain rate.
+	drainMinSample = 2 * time.Second
+	drainMinWrites = 4
+	// degradedNoticeInterval rate-limits quality_degraded messages.
+	degradedNoticeInterval = 5 * time.Second
+	// backpressureHeadroom is the share of the drain rate a backpressure
+	// cap allows, so the queue empties.
+	backpressureHeadroom = 0.8
+	backpressureMinMbps  = 0.5
+	// backpressureRecovery is how long a viewer must go without drops
+	// before its cap is raised by backpressureStep.
+	backpressureRecovery = 10 * time.Second
+	backpressureStep     = 1.25
+)
+
+type sendQueue struct {
+	mu      sync.Mutex
+	ready   chan struct{}
+	packets [][]byte
+	bytes   int
+	closed  bool
+	// drainRate is an estimate of the connection's throughput in bytes
+	// per second, or 0 until one is known. It is measured over a backlog,
+	// while packets are waiting: only then does the writer run at the pace
+	// of the connection rather than of the encoder. Socket buffers make
+	// single writes block for seconds and then complete in bursts, so the
+	// estimate covers the whole backlog.
+	drainRate     float64
+	backlogStart  time.Time
+	backlogBytes  int
+	backlogWrites int
+
+	// Drops since the last quality_degraded, and in total.
+	dropped, droppedBytes int
+	totalDropped          int
+	lastDrop              time.Time
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{ready: make(chan struct{}, 1)}
+}
+
+// limit is the byte bound of the queue. The caller must hold q.mu.
+func (q *sendQueue) limit() int {
+	return min(max(int(q.drainRate*sendQueueTarget.Seconds()), sendQueueMinBytes), sendQueueMaxBytes)
+}
+
+// push queues packet, or drops it and reports false if the queue or the
+// buffered video budget (membudget.go) is full. A packet always fits an
+// empty queue, so even a keyframe larger than the bound gets through.
+func (q *sendQueue) push(packet []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	if len(q.packets) == 0 {
+		bufferedVideo.force(len(packet))
+	} else if len(q.packets) >= sendQueueMaxPackets || q.bytes+len(packet) > q.limit() || !bufferedVideo.reserve(len(packet)) {
+		q.dropped++
+		q.droppedBytes += len(packet)
+		q.totalDropped++
+		q.lastDrop = time.Now()
+		return false
+	}
+	q.packets = append(q.packets, packet)
+	q.bytes += len(packet)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop returns the next packet, waiting up to wait for one. ok is false
+// once the queue is closed.
+func (q *sendQueue) pop(wait time.Duration) (packet []byte, ok bool) {
+	q.mu.Lock()
+	if len(q.packets) == 0 && !q.closed {
+		q.mu.Unlock()
+		select {
+		case <-q.ready:
+		case <-time.After(wait):
+		}
+		q.mu.Lock()
+	}
+	defer q.mu.Unlock()
+	if len(q.packets) == 0 {
+		return nil, !q.closed
+	}
+	packet = q.packets[0]
+	q.packets[0] = nil
+	q.packets = q.packets[1:]
+	q.bytes -= len(packet)
+	bufferedVideo.release(len(packet))
+	return packet, true
+}
+
+// close drops the queued packets and wakes the writer, which exits.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.packets = nil
+	bufferedVideo.release(q.bytes)
+	q.bytes = 0
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wrote updates the drain rate after a write of size bytes ending at now.
+func (q *sendQueue) wrote(size int, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.packets) == 0 {
+		// The writer is about to wait for the encoder.
+		q.backlogStart = time.Time{}
+		return
+	}
+	if q.backlogStart.IsZero() {
+		// The write that let packets pile up may have waited for the
+		// socket buffers to drain, which says little about the rate.
+		q.backlogStart, q.backlogBytes, q.backlogWrites = now, 0, 0
+		return
+	}
+	q.backlogBytes += size
+	q.backlogWrites++
+	if d := now.Sub(q.backlogStart); d >= drainMinSample && q.backlogWrites >= drainMinWrites {
+		q.drainRate = float64(q.backlogBytes) / d.Seconds()
+	}
+}
+
+// sendWorker writes c's queued packets until the queue is closed, and
+// tells the viewer when it falls behind and when it has caught up.
+func (c *Client) sendWorker() {
+	var lastNotice, lastRaise time.Time
+	var buf []byte
+	degraded := false
+	for {
+		packet, ok := c.queue.pop(time.Second)
+		if !ok {
+			return
+		}
+
+		c.queue.mu.Lock()
+		dropped, droppedBytes, total := c.queue.dropped, c.queue.droppedBytes, c.queue.totalDropped
+		lastDrop, queued, drainRate := c.queue.lastDrop, c.queue.bytes, c.queue.drainRate
+		if dropped > 0 && time.Since(lastNotice) >= degradedNoticeInterval {
+			c.queue.dropped, c.queue.droppedBytes = 0, 0
+		} else {
+			dropped = 0
+		}
+		c.queue.mu.Unlock()
+
+		switch {
+		case dropped > 0:
+			degraded = true
+			lastNotice, lastRaise = time.Now(), time.Now()
+			log.Printf("Client %s is falling behind: dropped %d packets (%d bytes)", c.addr, dropped, droppedBytes)
+			msg := map[string]interface{}{
+				"type":          "quality_degraded",
+				"dropped":       dropped,
+				"dropped_bytes": droppedBytes,
+				"total_dropped": total,
+				"queued_bytes":  queued,
+			}
+			// The first notice may come before the backlog has lasted
+			// long enough to measure the connection.
+			if mbps := drainRate * 8 / 1e6; mbps > 0 {
+				msg["throughput_mbps"] = mbps
+				if BackpressureCap {
+					msg["cap_mbps"] = setBackpressureCap(c, max(mbps*backpressureHeadroom, backpressureMinMbps))
+				}
+			}
+			_ = c.writeJSON(msg)
+		case degraded && time.Since(lastDrop) >= backpressureRecovery && time.Since(lastRaise) >= backpressureRecovery:
+			lastRaise = time.Now()
+			if raiseBackpressureCap(c) {
+				degraded = false
+				log.Printf("Client %s has caught up", c.addr)
+				_ = c.writeJSON(map[string]interface{}{"type": "quality_restored"})
+			}
+		}
+
+		if packet == nil {
+			continue
+		}
+		c.writePacket(packet, &buf)
+		c.queue.wrote(len(packet), time.Now())
+	}
+}
+
+// setBackpressureCap caps c at mbps, or lowers its backpressure cap to
+// that, and returns the cap now in effect.
+func setBackpressureCap(c *Client, mbps float64) float64 {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if c.backpressureCap == 0 || mbps < c.backpressureCap {
+		c.backpressureCap = mbps
+		applyClientCap(c)
+	}
+	return clientCap(c)
+}
+
+// raiseBackpressureCap raises c's backpressure cap by backpressureStep,
+// removing it once it exceeds the encoder's bitrate, and reports whether
+// the client is now uncapped by backpressure.
+func raiseBackpressureCap(c *Client) bool {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if c.backpressureCap == 0 {
+		return true
+	}
+	c.backpressureCap *= backpressureStep
+	if c.backpressureCap >= float64(targetBandwidthMbps) {
+		c.backpressureCap = 0
+	}
+	applyClientCap(c)
+	return c.backpressureCap == 0
+}
@@ -0,0 +1,253 @@
+// Package llrdc implements the LLrdc remote desktop server: an X11 session
+// captured and encoded by ffmpeg and streamed to browsers over WebRTC or
+// WebSockets.
+//
+// The server keeps its state in package-level variables, so only one Server
+// can exist at a time. Once it has been shut down a new one can be created.
+package llrdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var serverCreated atomic.Bool
+
+// workers tracks the background goroutines started by Run, so Shutdown can
+// wait for them to exit.
+var workers sync.WaitGroup
+
+// goWorker runs fn in a goroutine tracked by workers. fn must return once
+// the Run context is cancelled.
+func goWorker(fn func()) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		fn()
+	}()
+}
+
+// sleepCtx waits for d and reports whether ctx is still live.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// Server is a remote desktop endpoint.
+type Server struct {
+	cfg        Config
+	httpServer *http.Server
+	tunnel     *reverseTunnel
+	cancel     context.CancelFunc
+}
+
+// New applies cfg and returns a server ready to Run. It fails if another
+// Server exists that has not been shut down.
+func New(cfg Config) (*Server, error) {
+	if !serverCreated.CompareAndSwap(false, true) {
+		return nil, errors.New("llrdc: only one Server can be created per process")
+	}
+	applyConfig(cfg)
+	initScreenSize(3840, 2160)
+	loadState()
+	return &Server{cfg: cfg}, nil
+}
+
+// Run starts the desktop session, encoder and HTTP server, and blocks until
+// ctx is cancelled or the HTTP server fails. Call Shutdown afterwards to
+// stop the session.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+	systemdOnce.Do(initSystemd)
+	filter, err := newIPFilter()
+	if err != nil {
+		return fmt.Errorf("invalid connection filter: %v", err)
+	}
+	connFilter = filter
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return err
+	}
+	serverTLS = tlsConfig
+	if HTTP3Port > 0 && serverTLS == nil {
+		return errors.New("--http3-port needs --tls-cert and --tls-key")
+	}
+	if EnableWebTransport && HTTP3Port <= 0 {
+		return errors.New("--webtransport needs --http3-port")
+	}
+	if s.tunnel, err = newReverseTunnel(); err != nil {
+		return err
+	}
+	if Broker {
+		httpServer, err := newBrokerServer(ctx)
+		if err != nil {
+			return err
+		}
+		s.httpServer = httpServer
+		return s.serveHTTP(ctx)
+	}
+	encoder = newFFmpegEncoder()
+	startInputWorker(ctx)
+
+	// 1. Start X11 unless TEST_PATTERN is set
+	if !TestPattern {
+		if err := startX11(ctx, DisplayNum); err != nil {
+			return fmt.Errorf("failed to initialize X11: %v", err)
+		}
+		startCursorWatcher(ctx, Display)
+		startFocusWatcher(ctx, Display)
+		startCursorConfiner(ctx, Display)
+		startSeamlessWindows(ctx, Display)
+		initDamageTracking(ctx, Display)
+		startActivityTracker(ctx)
+		startNotificationForwarder(ctx)
+	} else {
+		log.Println("TEST_PATTERN mode: skipping X11 setup.")
+	}
+
+	// 2. Initialize WebRTC and RTP Listener
+	if err := initWebRTC(ctx); err != nil {
+		return fmt.Errorf("failed to initialize WebRTC: %v", err)
+	}
+	startAdaptiveQuality(ctx)
+
+	// 3. Start the video encoder
+	if err := startVideoPipeline(ctx); err != nil {
+		return fmt.Errorf("failed to start encoder: %v", err)
+	}
+	startEncoderWatchdog(ctx)
+	startEncoderTuner(ctx)
+	startCropFollower(ctx)
+	startIdleLock(ctx)
+	startAudioStreaming(ctx)
+	startAudioRouter(ctx)
+	startHLS(ctx)
+	startPublish(ctx)
+
+	// 4. Start HTTP & WebSocket server, the gRPC control API and the VNC bridge
+	s.httpServer = newHTTPServer(ctx)
+	if err := startControlAPI(ctx); err != nil {
+		return fmt.Errorf("failed to start gRPC control API: %v", err)
+	}
+	if err := startVNC(ctx); err != nil {
+		return fmt.Errorf("failed to start VNC bridge: %v", err)
+	}
+	return s.serveHTTP(ctx)
+}
+
+// serveHTTP runs s.httpServer until ctx is cancelled or it fails.
+func (s *Server) serveHTTP(ctx context.Context) error {
+	handler, err := startHTTP3(ctx, connFilter.wrap(s.httpServer.Handler))
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP/3 listener: %v", err)
+	}
+	s.httpServer.Handler = handler
+	if serverTLS != nil {
+		s.httpServer.TLSConfig = serverTLS
+		s.httpServer.Protocols = new(http.Protocols)
+		s.httpServer.Protocols.SetHTTP1(true)
+		s.httpServer.Protocols.SetHTTP2(true)
+	}
+	if s.tunnel != nil {
+		goWorker(func() { s.tunnel.run(ctx, s.httpServer) })
+	}
+	startMDNS(ctx)
+	startPortMapping(ctx)
+	ln, err := systemdListener()
+	if err != nil {
+		return err
+	}
+	where := s.httpServer.Addr
+	if HTTPAddr == "" {
+		where = "0.0.0.0" + where
+	}
+	if ln != nil {
+		where = ln.Addr().String() + " (socket activation)"
+	} else if ln, err = net.Listen("tcp", s.httpServer.Addr); err != nil {
+		return fmt.Errorf("HTTP server failed: %v", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if serverTLS != nil {
+			log.Printf("Server listening on https://%s", where)
+			errCh <- s.httpServer.ServeTLS(ln, "", "")
+			return
+		}
+		log.Printf("Server listening on http://%s", where)
+		errCh <- s.httpServer.Serve(ln)
+	}()
+	startSystemdNotify(ctx, !Broker)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("HTTP server failed: %v", err)
+	}
+}
+
+// Shutdown tells clients the server is going away, stops the HTTP server,
+// cancels the Run context so ffmpeg, the desktop session and the X server
+// are torn down, and waits for background workers to exit or ctx to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Println("Shutting down...")
+	_ = sdNotify("STOPPING=1")
+	broadcastJSON(map[string]interface{}{"type": "server_shutdown"})
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Shutdown(ctx)
+	}
+	connFilter.close()
+	connFilter = nil
+	// Hijacked WebSocket connections are not closed by http.Server.Shutdown.
+	// Their handlers close the PeerConnections on the way out.
+	closeAllClients()
+	flushStateSave()
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		serverCreated.Store(false)
+	case <-ctx.Done():
+		if err == nil {
+			err = fmt.Errorf("background workers did not stop: %w", ctx.Err())
+		}
+	}
+	return err
+}
+
+// Doctor checks the host for the server's runtime dependencies and prints a
+// report. It returns false if a required check failed.
+func (s *Server) Doctor() bool {
+	return runDoctor()
+}
+
+// Benchmark measures encoder performance on this host and writes the
+// recommended configuration to Config.BenchmarkOutput.
+func (s *Server) Benchmark() error {
+	return runBenchmark()
+}
@@ -0,0 +1,121 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sessionUserEnabled reports whether the desktop session should run under a
+// dedicated UID/GID rather than the server's own identity.
+func sessionUserEnabled() bool {
+	return SessionUID >= 0
+}
+
+// prepareSessionUser creates the managed home and runtime directories for
+// the session user. It must run before any session process is started.
+func prepareSessionUser() error {
+	if !sessionUserEnabled() {
+		return nil
+	}
+	if SessionGID < 0 {
+		SessionGID = SessionUID
+	}
+	if SessionHome == "" {
+		SessionHome = fmt.Sprintf("/home/llrdc-%d", SessionUID)
+	}
+
+	// Xvfb needs a world-writable socket directory when not running as root.
+	if err := os.MkdirAll("/tmp/.X11-unix", 01777); err == nil {
+		os.Chmod("/tmp/.X11-unix", 01777)
+	}
+
+	for _, dir := range []string{SessionHome, sessionRuntimeDir()} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+		if err := os.Chown(dir, SessionUID, SessionGID); err != nil {
+			return fmt.Errorf("failed to chown %s to %d:%d: %v", dir, SessionUID, SessionGID, err)
+		}
+	}
+
+	log.Printf("Desktop session will run as uid=%d gid=%d (home: %s)", SessionUID, SessionGID, SessionHome)
+	return nil
+}
+
+func sessionRuntimeDir() string {
+	return fmt.Sprintf("/tmp/runtime-%d", SessionUID)
+}
+
+// sessionEnviron returns the environment for processes running inside the
+// desktop session, with HOME and friends pointing at the session user.
+func sessionEnviron(display string) []string {
+	env := append(os.Environ(), "DISPLAY="+display)
+	var overrides map[string]string
+	if sessionUserEnabled() {
+		name := sessionUserName()
+		overrides = map[string]string{
+			"HOME":            SessionHome,
+			"USER":            name,
+			"LOGNAME":         name,
+			"XDG_RUNTIME_DIR": sessionRuntimeDir(),
+		}
+	} else if storageHome != "" {
+		overrides = map[string]string{"HOME": storageHome}
+	} else if urlHandoffDir == "" {
+		return env
+	} else {
+		overrides = map[string]string{}
+	}
+	if urlHandoffDir != "" {
+		overrides["PATH"] = urlHandoffDir + ":" + os.Getenv("PATH")
+		overrides["BROWSER"] = filepath.Join(urlHandoffDir, "xdg-open")
+	}
+
+	filtered := env[:0:0]
+	for _, e := range env {
+		key, _, _ := strings.Cut(e, "=")
+		if _, ok := overrides[key]; !ok {
+			filtered = append(filtered, e)
+		}
+	}
+	for k, v := range overrides {
+		filtered = append(filtered, k+"="+v)
+	}
+	return filtered
+}
+
+// sessionUserName returns the login name for the session UID, falling back
+// to the numeric UID when it has no passwd entry.
+func sessionUserName() string {
+	out, err := exec.Command("getent", "passwd", strconv.Itoa(SessionUID)).Output()
+	if err == nil {
+		if name, _, ok := strings.Cut(string(out), ":"); ok && name != "" {
+			return name
+		}
+	}
+	return strconv.Itoa(SessionUID)
+}
+
+// runAsSessionUser configures cmd to run with the session user's credentials
+// and, unless cmd.Dir is already set, in the session's home directory.
+func runAsSessionUser(cmd *exec.Cmd) {
+	if !sessionUserEnabled() {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid: uint32(SessionUID),
+		Gid: uint32(SessionGID),
+	}
+	if cmd.Dir == "" {
+		cmd.Dir = SessionHome
+	}
+}
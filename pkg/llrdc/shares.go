@@ -0,0 +1,296 @@
+package llrdc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Share links grant someone without the main credential access to the
+// session for a limited time, optionally view-only. They need
+// IdentityHeader: an authenticated user mints a link, and a request without
+// an identity is then only served if it carries a valid link.
+//
+//	POST   /share?minutes=30&view_only=1   mint a link
+//	GET    /share                          list active links
+//	DELETE /share/{id}                     revoke a link
+//	GET    /share/{token}                  redeem a link (sets a cookie)
+//
+// A token is "<payload>.<signature>", both base64url, where the payload is
+// "<id>.<expiry unix>.<view only 0/1>" and the signature its HMAC-SHA256
+// under a key generated at startup. Links are also tracked server-side, so
+// they can be revoked, and a restart revokes them all.
+
+const (
+	sharePrefix         = "/share"
+	shareCookie         = "llrdc_share"
+	defaultShareMinutes = 30
+)
+
+type shareLink struct {
+	ID        string    `json:"id"`
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	ViewOnly  bool      `json:"view_only"`
+}
+
+type shareLinkKey struct{}
+
+var (
+	shareMutex sync.Mutex
+	shareLinks = make(map[string]*shareLink)
+	shareKey   = func() []byte {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+		return key
+	}()
+)
+
+func shareLinksEnabled() bool {
+	return EnableShareLinks && IdentityHeader != ""
+}
+
+func signShareLink(link *shareLink) string {
+	view := "0"
+	if link.ViewOnly {
+		view = "1"
+	}
+	payload := []byte(link.ID + "." + strconv.FormatInt(link.Expires.Unix(), 10) + "." + view)
+	mac := hmac.New(sha256.New, shareKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyShareToken returns the active link a token was minted for, or nil.
+func verifyShareToken(token string) *shareLink {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(encPayload)
+	sig, err2 := base64.RawURLEncoding.DecodeString(encSig)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, shareKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil
+	}
+	id, _, _ := strings.Cut(string(payload), ".")
+
+	shareMutex.Lock()
+	defer shareMutex.Unlock()
+	link := shareLinks[id]
+	if link == nil {
+		return nil
+	}
+	if time.Now().After(link.Expires) {
+		delete(shareLinks, id)
+		return nil
+	}
+	return link
+}
+
+// shareFromRequest returns the link a guest request was admitted with, or
+// nil for requests from authenticated users.
+func shareFromRequest(r *http.Request) *shareLink {
+	link, _ := r.Context().Value(shareLinkKey{}).(*shareLink)
+	return link
+}
+
+// shareGate serves requests from authenticated users, redeems share links,
+// and admits other requests only with the cookie of a valid link.
+func shareGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestIdentity(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token, ok := strings.CutPrefix(r.URL.Path, sharePrefix+"/"); ok && r.Method == http.MethodGet {
+			redeemShareLink(w, r, token)
+			return
+		}
+		var link *shareLink
+		if cookie, err := r.Cookie(shareCookie); err == nil {
+			link = verifyShareToken(cookie.Value)
+		}
+		if link == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), shareLinkKey{}, link)))
+	})
+}
+
+func redeemShareLink(w http.ResponseWriter, r *http.Request, token string) {
+	link := verifyShareToken(token)
+	if link == nil {
+		http.Error(w, "This link has expired or was revoked", http.StatusForbidden)
+		return
+	}
+	log.Printf("Share link %s redeemed from %s", link.ID, r.RemoteAddr)
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  link.Expires,
+		HttpOnly: true,
+		Secure:   requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func requestScheme(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// shareHandler serves the API for minting, listing and revoking links.
+// Only authenticated users may use it, not guests.
+func shareHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+sharePrefix, func(w http.ResponseWriter, r *http.Request) {
+		minutes := defaultShareMinutes
+		if v := r.URL.Query().Get("minutes"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid minutes", http.StatusBadRequest)
+				return
+			}
+			minutes = n
+		}
+		if ShareMaxMinutes > 0 && minutes > ShareMaxMinutes {
+			http.Error(w, fmt.Sprintf("links may last at most %d minutes", ShareMaxMinutes), http.StatusBadRequest)
+			return
+		}
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		viewOnly, _ := strconv.ParseBool(r.URL.Query().Get("view_only"))
+		link := &shareLink{
+			ID:        hex.EncodeToString(id),
+			CreatedBy: requestIdentity(r),
+			Created:   now,
+			Expires:   now.Add(time.Duration(minutes) * time.Minute),
+			ViewOnly:  viewOnly,
+		}
+		shareMutex.Lock()
+		shareLinks[link.ID] = link
+		shareMutex.Unlock()
+		log.Printf("Share link %s minted by %q for %d minutes (view only: %v)", link.ID, link.CreatedBy, minutes, viewOnly)
+
+		path := sharePrefix + "/" + signShareLink(link)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(struct {
+			*shareLink
+			Path string `json:"path"`
+			URL  string `json:"url"`
+		}{link, path, requestScheme(r) + "://" + r.Host + path})
+	})
+	mux.HandleFunc("GET "+sharePrefix, func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		shareMutex.Lock()
+		list := make([]*shareLink, 0, len(shareLinks))
+		for id, link := range shareLinks {
+			if now.After(link.Expires) {
+				delete(shareLinks, id)
+				continue
+			}
+			list = append(list, link)
+		}
+		shareMutex.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"links": list})
+	})
+	mux.HandleFunc("GET "+sharePrefix+"/{token}", func(w http.ResponseWriter, r *http.Request) {
+		// Authenticated users have access already.
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+	mux.HandleFunc("DELETE "+sharePrefix+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		shareMutex.Lock()
+		_, ok := shareLinks[id]
+		delete(shareLinks, id)
+		shareMutex.Unlock()
+		if !ok {
+			http.Error(w, "no such link", http.StatusNotFound)
+			return
+		}
+		log.Printf("Share link %s revoked by %q", id, requestIdentity(r))
+		disconnectShareGuests(id, "share link revoked")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestIdentity(r) == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// disconnectShareGuests closes the WebSocket of every guest admitted with
+// the link id.
+func disconnectShareGuests(id, reason string) {
+	for _, c := range clientList() {
+		if c.share != nil && c.share.ID == id {
+			c.Disconnect(websocket.ClosePolicyViolation, reason)
+		}
+	}
+}
+
+// viewOnlyAllowsMessage reports whether a view-only guest may send a
+// message: only keepalives and what it takes to receive the stream.
+func viewOnlyAllowsMessage(msgType string) bool {
+	switch msgType {
+	case "ping", "webrtc_offer", "webrtc_ice", "webrtc_ready", "video_format", "video_ack", "bandwidth_cap", "probe_result",
+		"chat", "annotate":
+		return true
+	}
+	return false
+}
+
+// broadcastJSONToControllers is broadcastJSON without view-only guests, for
+// messages such as the clipboard that a watcher should not see.
+func broadcastJSONToControllers(msg interface{}) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for _, client := range clients {
+		if client.share != nil && client.share.ViewOnly {
+			continue
+		}
+		client.mu.Lock()
+		_ = client.conn.WriteJSON(msg)
+		client.mu.Unlock()
+	}
+}
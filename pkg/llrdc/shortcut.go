@@ -0,0 +1,104 @@
+package llrdc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Keyboard shortcuts injected as one chord, so viewer UI buttons ("open
+// terminal", "copy", "paste") trigger reliably instead of racing separate
+// keydown/keyup messages over the network:
+//
+//	{"type":"shortcut","keys":["ctrl","alt","t"]}
+//
+// xdotool presses the keys in order and releases them in reverse, with any
+// modifiers the viewer is holding cleared for the duration.
+//
+// System keys are keys and sequences a browser cannot capture, because the
+// local OS acts on them first (Print Screen, media keys, Ctrl+Alt+Del),
+// sent by name from a viewer menu:
+//
+//	{"type":"system_key","key":"ctrl_alt_del"}
+
+const maxShortcutKeys = 6
+
+// systemKeys maps system key names to xdotool chords.
+var systemKeys = map[string]string{
+	"print":              "Print",
+	"print_window":       "Alt_L+Print",
+	"print_region":       "Shift_L+Print",
+	"play_pause":         "XF86AudioPlay",
+	"stop":               "XF86AudioStop",
+	"next":               "XF86AudioNext",
+	"previous":           "XF86AudioPrev",
+	"volume_up":          "XF86AudioRaiseVolume",
+	"volume_down":        "XF86AudioLowerVolume",
+	"mute":               "XF86AudioMute",
+	"mic_mute":           "XF86AudioMicMute",
+	"ctrl_alt_del":       "Control_L+Alt_L+Delete",
+	"ctrl_alt_backspace": "Control_L+Alt_L+BackSpace",
+}
+
+func init() {
+	for i := 1; i <= 12; i++ {
+		systemKeys["ctrl_alt_f"+strconv.Itoa(i)] = "Control_L+Alt_L+F" + strconv.Itoa(i)
+	}
+}
+
+var shortcutModifiers = map[string]string{
+	"ctrl":    "Control_L",
+	"control": "Control_L",
+	"shift":   "Shift_L",
+	"alt":     "Alt_L",
+	"option":  "Alt_L",
+	"altgr":   "ISO_Level3_Shift",
+	"meta":    "Super_L",
+	"super":   "Super_L",
+	"win":     "Super_L",
+	"cmd":     "Super_L",
+}
+
+// shortcutKeysym maps a shortcut key name to an X keysym, or "" if the
+// name is not acceptable.
+func shortcutKeysym(key string) string {
+	if sym, ok := shortcutModifiers[strings.ToLower(key)]; ok {
+		return sym
+	}
+	if sym, ok := keyMap[key]; ok {
+		return sym
+	}
+	if len(key) == 1 && key[0] > 32 && key[0] <= 126 {
+		return key
+	}
+	if validNameRe.MatchString(key) {
+		return key
+	}
+	return ""
+}
+
+// injectShortcut queues keys as a single chord. It reports false if a key
+// is unknown, so nothing partial is sent.
+func injectShortcut(keys []string, display string) bool {
+	if len(keys) == 0 || len(keys) > maxShortcutKeys {
+		return false
+	}
+	syms := make([]string, len(keys))
+	for i, k := range keys {
+		if syms[i] = shortcutKeysym(k); syms[i] == "" {
+			return false
+		}
+	}
+	injectTask(inputTask{Type: "shortcut", Key: strings.Join(syms, "+"), Display: display})
+	return true
+}
+
+// injectSystemKey queues a system key by name. It reports false for an
+// unknown name.
+func injectSystemKey(name, display string) bool {
+	chord, ok := systemKeys[name]
+	if !ok {
+		return false
+	}
+	injectTask(inputTask{Type: "shortcut", Key: chord, Display: display})
+	return true
+}
@@ -0,0 +1,212 @@
+package llrdc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// Launching applications from a viewer:
+//
+//	{"type":"spawn","id":"t1","command":"xfce4-terminal",
+//	 "args":["--title","Build"],"env":{"LANG":"de_DE.UTF-8"},"cwd":"/home/me/src"}
+//
+// The command must be one of spawnAllowedApps and is run directly, not
+// through a shell. args, env and cwd are optional and checked by
+// parseSpawnRequest: env may set only locale, time zone, terminal and
+// display scaling variables, so a request cannot make an app load code
+// through PATH, LD_PRELOAD, GTK_MODULES, PYTHONPATH and the like, and cwd
+// must lie inside the session's home directory. The old form, a command line with its arguments
+// ({"command":"xclock -update 1"}), is still accepted and split on spaces.
+//
+// The viewer that asked gets spawn_result events, tagged with its id:
+//
+//	{"type":"spawn_result","id":"t1","event":"started","pid":4242}
+//	{"type":"spawn_result","id":"t1","event":"exited","pid":4242,"exit_code":0}
+//	{"type":"spawn_result","id":"t1","event":"error","error":"..."}
+//
+// An app killed by a signal exits with exit_code -1 and a "signal" name.
+
+const (
+	spawnMaxArgs     = 64
+	spawnMaxArgLen   = 4096
+	spawnMaxEnv      = 32
+	spawnMaxIDLength = 64
+)
+
+// spawnAllowedApps are the commands viewers may launch.
+var spawnAllowedApps = map[string]bool{
+	"gnome-calculator": true, "weston-terminal": true, "gedit": true,
+	"mousepad": true, "xclock": true, "xeyes": true, "xfce4-terminal": true,
+}
+
+var spawnEnvNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// spawnAllowedEnv are the variables a spawn request may set. LC_* are
+// allowed as well.
+var spawnAllowedEnv = map[string]bool{
+	"LANG": true, "LANGUAGE": true, "TZ": true,
+	"TERM": true, "COLORTERM": true, "NO_COLOR": true,
+	"GDK_SCALE": true, "GDK_DPI_SCALE": true, "QT_SCALE_FACTOR": true,
+}
+
+type spawnRequest struct {
+	command string
+	args    []string
+	env     []string
+	cwd     string
+}
+
+// validSpawnString rejects strings no sane argument or value contains.
+func validSpawnString(s string) bool {
+	return len(s) <= spawnMaxArgLen && !strings.ContainsRune(s, 0)
+}
+
+// parseSpawnRequest checks a spawn message against the policy above.
+func parseSpawnRequest(msg *spawnMessage) (spawnRequest, error) {
+	var req spawnRequest
+	if msg.Args != nil {
+		req.command = strings.TrimSpace(msg.Command)
+		req.args = msg.Args
+	} else {
+		fields := strings.Fields(msg.Command)
+		if len(fields) > 0 {
+			req.command, req.args = fields[0], fields[1:]
+		}
+	}
+	if !spawnAllowedApps[req.command] {
+		return req, fmt.Errorf("command %q is not allowed", req.command)
+	}
+	if len(req.args) > spawnMaxArgs {
+		return req, fmt.Errorf("too many arguments (at most %d)", spawnMaxArgs)
+	}
+	for _, arg := range req.args {
+		if !validSpawnString(arg) {
+			return req, errors.New("invalid argument")
+		}
+	}
+
+	if msg.Env != nil {
+		if len(msg.Env) > spawnMaxEnv {
+			return req, fmt.Errorf("too many environment variables (at most %d)", spawnMaxEnv)
+		}
+		for name, value := range msg.Env {
+			if !validSpawnString(value) {
+				return req, fmt.Errorf("invalid value for %s", name)
+			}
+			if !spawnEnvNameRe.MatchString(name) {
+				return req, fmt.Errorf("invalid environment variable name %q", name)
+			}
+			if !spawnAllowedEnv[name] && !strings.HasPrefix(name, "LC_") {
+				return req, fmt.Errorf("%s may not be set", name)
+			}
+			req.env = append(req.env, name+"="+value)
+		}
+	}
+
+	if msg.Cwd != "" {
+		dir, err := spawnWorkingDir(msg.Cwd)
+		if err != nil {
+			return req, err
+		}
+		req.cwd = dir
+	}
+	return req, nil
+}
+
+// spawnWorkingDir resolves cwd, which must be a directory inside the
+// session's home.
+func spawnWorkingDir(cwd string) (string, error) {
+	home := sessionHomeDir()
+	if home == "" || !filepath.IsAbs(cwd) {
+		return "", errors.New("cwd must be an absolute path inside the session home")
+	}
+	root, err := filepath.EvalSymlinks(home)
+	if err != nil {
+		return "", fmt.Errorf("session home: %v", err)
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Clean(cwd))
+	if err != nil {
+		return "", fmt.Errorf("cwd: %v", err)
+	}
+	if rel, err := filepath.Rel(root, dir); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", errors.New("cwd must be inside the session home")
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", errors.New("cwd is not a directory")
+	}
+	return dir, nil
+}
+
+// handleSpawnMessage launches the app a spawn message asks for and reports
+// on it through writeJSON.
+func handleSpawnMessage(msg *spawnMessage, writeJSON func(interface{}) error) {
+	result := func(event string) map[string]interface{} {
+		r := map[string]interface{}{"type": "spawn_result", "event": event}
+		// Long ids are dropped rather than echoed.
+		if msg.ID != "" && len(msg.ID) <= spawnMaxIDLength {
+			r["id"] = msg.ID
+		}
+		return r
+	}
+	req, err := parseSpawnRequest(msg)
+	if err != nil {
+		log.Printf("Refused spawn: %v", err)
+		r := result("error")
+		r["error"] = err.Error()
+		_ = writeJSON(r)
+		return
+	}
+	spawnApp(req, Display, func(event string, pid int, state *os.ProcessState, err error) {
+		r := result(event)
+		if pid > 0 {
+			r["pid"] = pid
+		}
+		if err != nil {
+			r["error"] = err.Error()
+		}
+		if state != nil {
+			r["exit_code"] = state.ExitCode()
+			if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				r["signal"] = ws.Signal().String()
+			}
+		}
+		_ = writeJSON(r)
+	})
+}
+
+// spawnApp starts req in the session. report, if not nil, is called with
+// "started" and then "exited", or just "error".
+func spawnApp(req spawnRequest, display string, report func(event string, pid int, state *os.ProcessState, err error)) {
+	if report == nil {
+		report = func(string, int, *os.ProcessState, error) {}
+	}
+	log.Printf("Spawning app: %s", strings.Join(append([]string{req.command}, req.args...), " "))
+	cmd, cgroupPath, err := startLimitedApp(func() *exec.Cmd {
+		cmd := exec.Command(req.command, req.args...)
+		cmd.Env = append(sessionEnviron(display), req.env...)
+		cmd.Dir = req.cwd
+		runAsSessionUser(cmd)
+		return cmd
+	})
+	if err != nil {
+		log.Printf("Failed to spawn app %s: %v\n", req.command, err)
+		report("error", 0, nil, err)
+		return
+	}
+	pid := cmd.Process.Pid
+	trackSpawnedProcess(pid)
+	report("started", pid, nil, nil)
+	go func() {
+		_ = cmd.Wait()
+		untrackSpawnedProcess(pid)
+		removeAppCgroup(cgroupPath)
+		report("exited", pid, cmd.ProcessState, nil)
+	}()
+}
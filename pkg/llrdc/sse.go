@@ -0,0 +1,239 @@
+package llrdc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server-Sent Events signaling is for networks whose proxies or firewalls
+// strip WebSocket upgrades but pass plain HTTP. The viewer opens
+//
+//	GET  /signal        an event stream, starting with a "session" event
+//	                    carrying {"id": ...}
+//	POST /signal/{id}   one JSON message per request
+//
+// and otherwise speaks the WebSocket protocol: server messages arrive as
+// "message" events and the viewer's (WebRTC offer and ICE candidates
+// included) are POSTed. Binary messages, and so the WebSocket video
+// fallback, are not carried; the video has to come over WebRTC.
+
+const (
+	ssePrefix       = "/signal"
+	sseMaxMessage   = 1 << 20
+	sseInboxSize    = 64
+	sseKeepalive    = 15 * time.Second
+	ssePostDeadline = 10 * time.Second
+)
+
+var (
+	sseMutex    sync.Mutex
+	sseSessions = make(map[string]*sseConn)
+)
+
+type sseConn struct {
+	id       string
+	identity string
+	mu       sync.Mutex
+	w        io.Writer
+	rc       *http.ResponseController
+	inbox    chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (c *sseConn) ReadMessage() (int, []byte, error) {
+	select {
+	case msg := <-c.inbox:
+		return websocket.TextMessage, msg, nil
+	case <-c.done:
+		return 0, nil, io.EOF
+	}
+}
+
+// write sends raw event stream text and flushes it. Once the connection is
+// closed the handler may have returned, so nothing more is written.
+func (c *sseConn) write(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return net.ErrClosed
+	default:
+	}
+	_, err := io.WriteString(c.w, text)
+	if err == nil {
+		err = c.rc.Flush()
+	}
+	if err != nil {
+		c.Close()
+	}
+	return err
+}
+
+// writeEvent sends one event. Data must not contain newlines, which JSON
+// encoding guarantees.
+func (c *sseConn) writeEvent(event string, data []byte) error {
+	text := "data: " + string(data) + "\n\n"
+	if event != "" {
+		text = "event: " + event + "\n" + text
+	}
+	return c.write(text)
+}
+
+func (c *sseConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.TextMessage {
+		return nil
+	}
+	return c.writeEvent("", data)
+}
+
+func (c *sseConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeEvent("", data)
+}
+
+// WriteControl only supports close messages, sent as a "close" event with
+// their reason before the stream ends.
+func (c *sseConn) WriteControl(messageType int, data []byte, _ time.Time) error {
+	if messageType != websocket.CloseMessage {
+		return nil
+	}
+	reason := ""
+	if len(data) > 2 {
+		reason = string(data[2:])
+	}
+	msg, _ := json.Marshal(map[string]string{"reason": reason})
+	return c.writeEvent("close", msg)
+}
+
+func (c *sseConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// sseHandler serves the event streams and their message POSTs until ctx is
+// cancelled.
+func sseHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+ssePrefix, func(w http.ResponseWriter, r *http.Request) {
+		serveSSE(ctx, w, r)
+	})
+	mux.HandleFunc("POST "+ssePrefix+"/{id}", postSSE)
+	return mux
+}
+
+func serveSSE(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	conn := &sseConn{
+		id:       hex.EncodeToString(id),
+		identity: requestIdentity(r),
+		w:        w,
+		rc:       http.NewResponseController(w),
+		inbox:    make(chan []byte, sseInboxSize),
+		done:     make(chan struct{}),
+	}
+	// The stream lives as long as the viewer, past any server write timeout.
+	_ = conn.rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx and similar proxies from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	session, _ := json.Marshal(map[string]string{"id": conn.id})
+	if err := conn.writeEvent("session", session); err != nil {
+		return
+	}
+
+	sseMutex.Lock()
+	sseSessions[conn.id] = conn
+	sseMutex.Unlock()
+	defer func() {
+		sseMutex.Lock()
+		delete(sseSessions, conn.id)
+		sseMutex.Unlock()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(sseKeepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Comments keep idle proxies from closing the stream.
+				if conn.write(": keepalive\n\n") != nil {
+					return
+				}
+			case <-r.Context().Done():
+				conn.Close()
+				return
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-conn.done:
+				return
+			}
+		}
+	}()
+
+	log.Printf("SSE signaling session %s from %s", conn.id, r.RemoteAddr)
+	serveClient(conn, r)
+	// serveClient has closed conn; wait for any write in progress before
+	// the handler returns and w becomes invalid.
+	conn.mu.Lock()
+	conn.mu.Unlock()
+}
+
+func postSSE(w http.ResponseWriter, r *http.Request) {
+	sseMutex.Lock()
+	conn := sseSessions[r.PathValue("id")]
+	sseMutex.Unlock()
+	if conn == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if conn.identity != requestIdentity(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, sseMaxMessage))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !json.Valid(msg) || !strings.HasPrefix(strings.TrimSpace(string(msg)), "{") {
+		http.Error(w, "expected a JSON object", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), ssePostDeadline)
+	defer cancel()
+	select {
+	case conn.inbox <- msg:
+		w.WriteHeader(http.StatusNoContent)
+	case <-conn.done:
+		http.NotFound(w, r)
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			http.Error(w, "session is not reading messages", http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package llrdc
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateSaveDelay batches bursts of settings changes (e.g. slider drags)
+// into a single write.
+const stateSaveDelay = 2 * time.Second
+
+// persistedState is the subset of runtime settings that survives restarts.
+type persistedState struct {
+	Mode          string `json:"mode"`
+	BandwidthMbps int    `json:"bandwidth"`
+	Quality       int    `json:"quality"`
+	FPS           int    `json:"framerate"`
+	VBR           bool   `json:"vbr"`
+	CpuEffort     int    `json:"cpu_effort"`
+	CpuThreads    int    `json:"cpu_threads"`
+	DrawMouse     bool   `json:"draw_mouse"`
+	ContentTune   string `json:"content_tune,omitempty"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+}
+
+var (
+	stateMutex     sync.Mutex
+	stateSaveTimer *time.Timer
+)
+
+// loadState restores the settings saved in StateFile, if any. It runs
+// after applyConfig, so saved values take precedence over flags.
+func loadState() {
+	if StateFile == "" {
+		return
+	}
+	data, err := os.ReadFile(StateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read state file %s: %v", StateFile, err)
+		}
+		return
+	}
+	var st persistedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("Ignoring invalid state file %s: %v", StateFile, err)
+		return
+	}
+
+	ffmpegMutex.Lock()
+	applyState(st)
+	ffmpegMutex.Unlock()
+
+	if st.Width > 0 && st.Height > 0 {
+		setScreenSize(st.Width, st.Height)
+	}
+	width, height := GetScreenSize()
+	log.Printf("Restored settings from %s (%s mode, %d Mbps, quality %d, %d fps, %dx%d)",
+		StateFile, st.Mode, targetBandwidthMbps, targetQuality, FPS, width, height)
+}
+
+// applyState copies the valid encoder settings from st into the target
+// variables. The caller must hold ffmpegMutex.
+func applyState(st persistedState) {
+	if st.Mode == "bandwidth" || st.Mode == "quality" {
+		targetMode = st.Mode
+	}
+	if st.BandwidthMbps > 0 {
+		targetBandwidthMbps = st.BandwidthMbps
+	}
+	if st.Quality >= 10 && st.Quality <= 100 {
+		targetQuality = st.Quality
+	}
+	if st.FPS > 0 {
+		FPS = st.FPS
+	}
+	targetVBR = st.VBR
+	if st.CpuEffort >= -1 && st.CpuEffort <= maxCpuEffort {
+		targetCpuEffort = st.CpuEffort
+	}
+	if st.CpuThreads >= 0 {
+		targetCpuThreads = st.CpuThreads
+	}
+	targetDrawMouse = st.DrawMouse
+	if st.ContentTune == "video" || st.ContentTune == "text" {
+		targetContentTune = st.ContentTune
+	}
+}
+
+// currentState snapshots the encoder settings and screen size.
+func currentState() persistedState {
+	ffmpegMutex.Lock()
+	st := persistedState{
+		Mode:          targetMode,
+		BandwidthMbps: targetBandwidthMbps,
+		Quality:       targetQuality,
+		FPS:           FPS,
+		VBR:           targetVBR,
+		CpuEffort:     targetCpuEffort,
+		CpuThreads:    targetCpuThreads,
+		DrawMouse:     targetDrawMouse,
+		ContentTune:   targetContentTune,
+	}
+	ffmpegMutex.Unlock()
+	st.Width, st.Height = GetScreenSize()
+	return st
+}
+
+// scheduleStateSave writes the current settings to StateFile shortly after
+// the last change.
+func scheduleStateSave() {
+	if StateFile == "" {
+		return
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if stateSaveTimer != nil {
+		stateSaveTimer.Reset(stateSaveDelay)
+		return
+	}
+	stateSaveTimer = time.AfterFunc(stateSaveDelay, flushStateSave)
+}
+
+// flushStateSave writes a pending save immediately. It is called on
+// shutdown so the last change is not lost.
+func flushStateSave() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if stateSaveTimer == nil {
+		return
+	}
+	stateSaveTimer.Stop()
+	stateSaveTimer = nil
+
+	if err := writeStateFile(StateFile, currentState()); err != nil {
+		log.Printf("Failed to save state to %s: %v", StateFile, err)
+	}
+}
+
+// writeStateFile replaces path atomically so a crash mid-write cannot leave
+// a truncated file behind.
+func writeStateFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".llrdc-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
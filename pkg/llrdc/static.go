@@ -0,0 +1,196 @@
+package llrdc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Serving the viewer's files from public/. The build (vite.config.ts) puts
+// its bundles under /assets/ with a content hash in their names, so those
+// are cached for a year as immutable; everything else, viewer.html above
+// all, is revalidated on every load, which costs a 304 when nothing
+// changed. Each file gets a strong ETag from a hash of its content, kept
+// until the file changes. The build also writes .br and .gz copies of
+// text files, and a browser that accepts one of those encodings gets that
+// copy instead, brotli first.
+//
+// Files are served by http.FileServer from the directory opened as an
+// os.Root, so no path, however it is spelled, reaches outside it. Symlinks
+// are followed only while they stay inside public/; one that leads out of
+// it is refused like a missing file, and logged. Only regular files are
+// served: directories are not listed, and names starting with a dot
+// (.git, .env) are hidden.
+
+const staticImmutablePrefix = "/assets/"
+
+var (
+	staticETagMutex sync.Mutex
+	staticETags     = make(map[string]staticETag)
+)
+
+// staticETag is the ETag of a file as it was when last hashed.
+type staticETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// staticEncodings are the pre-compressed copies looked for, in order of
+// preference, with their file suffixes.
+var staticEncodings = []struct{ name, suffix string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticFS is the public directory as the file server sees it.
+type staticFS struct {
+	fsys fs.FS
+}
+
+func (s staticFS) Open(name string) (fs.File, error) {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." {
+			return nil, fs.ErrNotExist
+		}
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			// Among others, a symlink that leads out of public/.
+			log.Printf("Refused static file %q: %v", name, err)
+		}
+		return nil, fs.ErrNotExist
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+// serveStatic serves a GET or HEAD request for a file in public/.
+func serveStatic(w http.ResponseWriter, r *http.Request) {
+	wd, _ := os.Getwd()
+	dir := filepath.Join(wd, "public")
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer root.Close()
+	fsys := staticFS{root.FS()}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	if urlPath == "/" {
+		urlPath = "/viewer.html"
+	}
+	name := strings.TrimPrefix(urlPath, "/")
+
+	if strings.HasPrefix(urlPath, staticImmutablePrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	// A compressed copy needs the type of the original; without one it
+	// would be sniffed from the compressed bytes.
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		varied := false
+		for _, enc := range staticEncodings {
+			if _, err := fs.Stat(fsys, name+enc.suffix); err != nil {
+				continue
+			}
+			if !varied {
+				w.Header().Add("Vary", "Accept-Encoding")
+				varied = true
+			}
+			if !acceptsEncoding(r, enc.name) {
+				continue
+			}
+			name += enc.suffix
+			w.Header().Set("Content-Type", ctype)
+			w.Header().Set("Content-Encoding", enc.name)
+			break
+		}
+	}
+
+	etag, err := staticFileETag(fsys, dir, name)
+	if err != nil {
+		w.Header().Del("Cache-Control")
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	req := new(http.Request)
+	*req = *r
+	req.URL = new(url.URL)
+	*req.URL = *r.URL
+	req.URL.Path, req.URL.RawPath = "/"+name, ""
+	http.FileServerFS(fsys).ServeHTTP(w, req)
+}
+
+// staticFileETag returns the ETag of name in fsys, hashing it unless it is
+// unchanged since the last time. dir tells apart the same name in
+// different directories.
+func staticFileETag(fsys fs.FS, dir, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	key := filepath.Join(dir, name)
+	staticETagMutex.Lock()
+	cached, ok := staticETags[key]
+	staticETagMutex.Unlock()
+	if ok && cached.modTime.Equal(fi.ModTime()) && cached.size == fi.Size() {
+		return cached.etag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	staticETagMutex.Lock()
+	staticETags[key] = staticETag{modTime: fi.ModTime(), size: fi.Size(), etag: etag}
+	staticETagMutex.Unlock()
+	return etag, nil
+}
+
+// acceptsEncoding reports whether r's Accept-Encoding allows coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		// "q=0" refuses it.
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return err != nil || q > 0
+		}
+		return true
+	}
+	return false
+}
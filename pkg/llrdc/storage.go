@@ -0,0 +1,79 @@
+package llrdc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Persistent session storage. With DataRoot on a volume, each session gets
+// DataRoot/<SessionID> as its home directory, created on first start and
+// reused by every later start with the same ID, so documents and desktop
+// settings survive container restarts. Several servers can share one
+// volume as long as each has its own SessionID.
+
+// storageHome is the persistent home of a session that does not run as a
+// dedicated user, for sessionEnviron.
+var storageHome string
+
+// sessionStorageDir returns the persistent directory for SessionID.
+func sessionStorageDir() (string, error) {
+	id, err := storageDirName(SessionID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(DataRoot, id), nil
+}
+
+// storageDirName returns the directory name the session with ID id keeps
+// its home in.
+func storageDirName(sessionID string) (string, error) {
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, sessionID)
+	if id == "" || strings.Trim(id, ".") == "" {
+		return "", fmt.Errorf("invalid session ID %q", sessionID)
+	}
+	return id, nil
+}
+
+// prepareSessionStorage creates or reattaches the session's persistent home
+// and points the session at it. It must run before prepareSessionUser.
+func prepareSessionStorage() error {
+	if DataRoot == "" {
+		return nil
+	}
+	dir, err := sessionStorageDir()
+	if err != nil {
+		return err
+	}
+
+	_, statErr := os.Stat(dir)
+	created := errors.Is(statErr, os.ErrNotExist)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create session storage %s: %v", dir, err)
+	}
+	if created {
+		log.Printf("Created persistent storage for session %q at %s", SessionID, dir)
+	} else {
+		log.Printf("Reattached persistent storage for session %q at %s", SessionID, dir)
+	}
+
+	if sessionUserEnabled() {
+		if SessionHome != "" && SessionHome != dir {
+			log.Printf("--data-root overrides --session-home %s", SessionHome)
+		}
+		// prepareSessionUser chowns it to the session user.
+		SessionHome = dir
+		return nil
+	}
+	storageHome = dir
+	return nil
+}
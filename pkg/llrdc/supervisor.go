@@ -0,0 +1,196 @@
+package llrdc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	x11MinBackoff  = 1 * time.Second
+	x11MaxBackoff  = 60 * time.Second
+	x11StableAfter = 30 * time.Second
+)
+
+var (
+	x11Mutex   sync.Mutex
+	xvfbCmd    *exec.Cmd
+	sessionCmd *exec.Cmd
+	xvfbDead   bool
+	x11Exits   = make(chan *exec.Cmd, 4)
+)
+
+// superviseX11 watches Xvfb and the desktop session (whose dbus-run-session
+// wrapper owns the session bus) and restarts them with exponential backoff
+// if they exit while the server is running. Both are killed once ctx is
+// cancelled.
+func superviseX11(ctx context.Context, xvfb, session *exec.Cmd, env []string) {
+	x11Mutex.Lock()
+	xvfbCmd = xvfb
+	sessionCmd = session
+	x11Mutex.Unlock()
+
+	watchX11Process(ctx, xvfb)
+	watchX11Process(ctx, session)
+
+	goWorker(func() {
+		<-ctx.Done()
+		x11Mutex.Lock()
+		defer x11Mutex.Unlock()
+		if sessionCmd != nil && sessionCmd.Process != nil {
+			log.Printf("Killing %s...", processName(sessionCmd))
+			sessionCmd.Process.Kill()
+		}
+		if xvfbCmd != nil && xvfbCmd.Process != nil {
+			log.Println("Killing Xvfb...")
+			xvfbCmd.Process.Kill()
+		}
+	})
+
+	goWorker(func() {
+		backoff := x11MinBackoff
+		lastRecovery := time.Now()
+		for {
+			var exited *exec.Cmd
+			select {
+			case <-ctx.Done():
+				return
+			case exited = <-x11Exits:
+			}
+
+			x11Mutex.Lock()
+			isXvfb := exited == xvfbCmd
+			isSession := exited == sessionCmd
+			x11Mutex.Unlock()
+			if !isXvfb && !isSession {
+				// A process we replaced or killed ourselves during recovery.
+				continue
+			}
+
+			name := processName(exited)
+			log.Printf("%s exited unexpectedly (%v), recovering X session...", name, exited.ProcessState)
+			fireHook(HookXSessionCrash, map[string]interface{}{"process": name, "error": fmt.Sprint(exited.ProcessState)})
+			broadcastJSON(map[string]interface{}{
+				"type":    "session_recovering",
+				"process": name,
+			})
+
+			if time.Since(lastRecovery) > x11StableAfter {
+				backoff = x11MinBackoff
+			}
+			for {
+				if !sleepCtx(ctx, backoff) {
+					return
+				}
+				backoff *= 2
+				if backoff > x11MaxBackoff {
+					backoff = x11MaxBackoff
+				}
+				// Re-check each attempt: the session often dies first when
+				// Xvfb goes away, and once Xvfb is back only the session may
+				// still need restarting.
+				x11Mutex.Lock()
+				needXvfb := xvfbDead
+				x11Mutex.Unlock()
+				if err := recoverX11(ctx, needXvfb, env); err != nil {
+					log.Printf("X session recovery failed: %v", err)
+					continue
+				}
+				break
+			}
+			lastRecovery = time.Now()
+
+			log.Printf("X session recovered after %s exit", name)
+			broadcastJSON(map[string]interface{}{
+				"type":    "session_recovered",
+				"process": name,
+			})
+		}
+	})
+}
+
+func watchX11Process(ctx context.Context, cmd *exec.Cmd) {
+	if cmd == nil {
+		return
+	}
+	goWorker(func() {
+		_ = cmd.Wait()
+		x11Mutex.Lock()
+		if cmd == xvfbCmd {
+			xvfbDead = true
+		}
+		x11Mutex.Unlock()
+		select {
+		case x11Exits <- cmd:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// recoverX11 restarts the session and, if Xvfb itself died, the X server and
+// everything attached to it.
+func recoverX11(ctx context.Context, restartXvfb bool, env []string) error {
+	if restartXvfb {
+		x11Mutex.Lock()
+		oldSession := sessionCmd
+		sessionCmd = nil
+		x11Mutex.Unlock()
+		if oldSession != nil && oldSession.Process != nil {
+			oldSession.Process.Kill()
+		}
+
+		xvfb, err := startXvfb(DisplayNum)
+		if err != nil {
+			return err
+		}
+		x11Mutex.Lock()
+		xvfbCmd = xvfb
+		xvfbDead = false
+		x11Mutex.Unlock()
+		watchX11Process(ctx, xvfb)
+
+		applyXsetDefaults(env)
+		width, height := GetScreenSize()
+		if err := resizeDisplay(width, height); err != nil {
+			log.Printf("Failed to restore screen size after recovery: %v", err)
+		}
+		startCursorWatcher(ctx, Display)
+		startFocusWatcher(ctx, Display)
+		startCursorConfiner(ctx, Display)
+		startSeamlessWindows(ctx, Display)
+		initDamageTracking(ctx, Display)
+
+		if TestMinimalX11 {
+			_ = runWithEnv("xsetroot", []string{"-solid", "#000000"}, env)
+			return nil
+		}
+	}
+
+	session, err := startSession(env)
+	if err != nil {
+		return err
+	}
+	x11Mutex.Lock()
+	sessionCmd = session
+	x11Mutex.Unlock()
+	watchX11Process(ctx, session)
+
+	configureSession(env)
+	go fitKioskWindow()
+	// The manifest's apps died with Xvfb.
+	if restartXvfb {
+		startSessionManifest(ctx)
+	}
+	return nil
+}
+
+func processName(cmd *exec.Cmd) string {
+	if len(cmd.Args) > 1 && filepath.Base(cmd.Path) == "dbus-run-session" {
+		return cmd.Args[1]
+	}
+	return filepath.Base(cmd.Path)
+}
@@ -0,0 +1,183 @@
+package llrdc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// systemd integration, for running as a Type=notify service. The server
+// reports READY=1 once the HTTP listener is up and, except for a broker,
+// the encoder has produced its first frame, so units ordered after it start
+// against a working stream. With WatchdogSec= it pings the watchdog at half
+// the interval for as long as the encoder keeps producing frames: when the
+// encoder watchdog (EncoderWatchdogSeconds) has restarted ffmpeg
+// systemdStuckRestarts times without a frame coming out, restarting the
+// encoder does not help and the pings stop, so systemd restarts the whole
+// service. An HTTP socket passed by socket activation (a .socket unit, or
+// the one named "http" with FileDescriptorName=) is served instead of
+// listening on Port. The variables systemd sets are removed from the
+// environment, so the desktop and its apps do not inherit them.
+//
+// See sd_notify(3), sd_watchdog_enabled(3) and sd_listen_fds(3).
+
+const (
+	systemdStuckRestarts = 3
+	// systemdListenFDStart is SD_LISTEN_FDS_START.
+	systemdListenFDStart = 3
+)
+
+var (
+	systemdOnce         sync.Once
+	systemdNotifySocket string
+	systemdWatchdog     time.Duration
+	// systemdListenFDs are the sockets passed by socket activation.
+	systemdListenFDs   []*os.File
+	systemdListenNames []string
+)
+
+// initSystemd takes over the environment systemd passed to this process.
+// It runs once, on the first Run.
+func initSystemd() {
+	pidMatches := func(name string) bool {
+		pid, err := strconv.Atoi(os.Getenv(name))
+		return err == nil && pid == os.Getpid()
+	}
+
+	systemdNotifySocket = os.Getenv("NOTIFY_SOCKET")
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		if os.Getenv("WATCHDOG_PID") == "" || pidMatches("WATCHDOG_PID") {
+			systemdWatchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err == nil && n > 0 && pidMatches("LISTEN_PID") {
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < n; i++ {
+			fd := systemdListenFDStart + i
+			syscall.CloseOnExec(fd)
+			name := "unknown"
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			systemdListenFDs = append(systemdListenFDs, os.NewFile(uintptr(fd), name))
+			systemdListenNames = append(systemdListenNames, name)
+		}
+	}
+	for _, name := range []string{"NOTIFY_SOCKET", "WATCHDOG_USEC", "WATCHDOG_PID", "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+}
+
+// systemdListener returns the HTTP socket passed by socket activation, or
+// nil without one. The socket can be taken only once.
+func systemdListener() (net.Listener, error) {
+	if len(systemdListenFDs) == 0 {
+		return nil, nil
+	}
+	i := 0
+	for j, name := range systemdListenNames {
+		if name == "http" {
+			i = j
+			break
+		}
+	}
+	f := systemdListenFDs[i]
+	for _, other := range systemdListenFDs {
+		if other != f {
+			other.Close()
+		}
+	}
+	systemdListenFDs, systemdListenNames = nil, nil
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket %q passed by systemd: %v", f.Name(), err)
+	}
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok && addr.Port != Port {
+		log.Printf("Warning: systemd passed a socket on port %d but the port is %d; WebRTC, LAN discovery and port mapping use %d", addr.Port, Port, Port)
+	}
+	return ln, nil
+}
+
+// sdNotify sends state to systemd. It does nothing outside a Type=notify
+// service.
+func sdNotify(state string) error {
+	if systemdNotifySocket == "" {
+		return nil
+	}
+	name := systemdNotifySocket
+	if strings.HasPrefix(name, "@") {
+		// An abstract socket.
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// startSystemdNotify reports readiness, after the first encoded frame if
+// waitForFrames, and then pings the watchdog until ctx is cancelled.
+func startSystemdNotify(ctx context.Context, waitForFrames bool) {
+	if systemdNotifySocket == "" {
+		return
+	}
+	goWorker(func() {
+		if waitForFrames {
+			_ = sdNotify("STATUS=Waiting for the first frame")
+			for encodedFrames.Load() == 0 {
+				if !sleepCtx(ctx, 100*time.Millisecond) {
+					return
+				}
+			}
+		}
+		status := fmt.Sprintf("STATUS=Serving on port %d", Port)
+		if err := sdNotify("READY=1\n" + status); err != nil {
+			log.Printf("systemd: notify failed: %v", err)
+			return
+		}
+		log.Println("systemd: notified readiness")
+	})
+	if systemdWatchdog <= 0 {
+		return
+	}
+	log.Printf("systemd: pinging the watchdog every %v", systemdWatchdog/2)
+	goWorker(func() {
+		lastFrames := encodedFrames.Load()
+		restartsAtFrame := encoderWatchdogRestarts.Load()
+		stuck := false
+		for sleepCtx(ctx, systemdWatchdog/2) {
+			if waitForFrames {
+				if frames := encodedFrames.Load(); frames != lastFrames {
+					lastFrames, restartsAtFrame = frames, encoderWatchdogRestarts.Load()
+				}
+				if encoderWatchdogRestarts.Load()-restartsAtFrame >= systemdStuckRestarts {
+					if !stuck {
+						log.Printf("systemd: the encoder was restarted %d times without producing a frame, no longer pinging the watchdog", systemdStuckRestarts)
+						_ = sdNotify("STATUS=Encoder stuck")
+					}
+					stuck = true
+					continue
+				}
+				if stuck {
+					log.Println("systemd: the encoder recovered, pinging the watchdog again")
+					_ = sdNotify(fmt.Sprintf("STATUS=Serving on port %d", Port))
+					stuck = false
+				}
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("systemd: watchdog ping failed: %v", err)
+			}
+		}
+	})
+}
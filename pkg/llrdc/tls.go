@@ -0,0 +1,64 @@
+package llrdc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// With TLSCert and TLSKey the HTTP server listens with HTTPS. TLSClientCA
+// additionally requires every client to present a certificate issued by
+// that CA (mutual TLS), so a device-certificate-enrolled browser or proxy
+// is the credential and nothing else reaches the viewer.
+
+// serverTLS is the TLS configuration built by Run, nil for plain HTTP.
+var serverTLS *tls.Config
+
+// newTLSConfig builds the listener's TLS configuration from the
+// configuration. It returns nil if TLS is off.
+func newTLSConfig() (*tls.Config, error) {
+	if TLSCert == "" && TLSKey == "" {
+		if TLSClientCA != "" {
+			return nil, errors.New("--tls-client-ca needs --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	if TLSCert == "" || TLSKey == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(TLSCert, TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if TLSClientCA != "" {
+		pem, err := os.ReadFile(TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", TLSClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		log.Printf("Requiring client certificates issued by %s", TLSClientCA)
+	}
+	return cfg, nil
+}
+
+// clientCertSubject returns the subject of the client certificate of r, or
+// "" without mutual TLS.
+func clientCertSubject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.String()
+}
@@ -0,0 +1,141 @@
+package llrdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Virtual input devices created through the kernel's uinput module, for
+// input xdotool cannot express (gamepads, pen pressure). The container
+// needs /dev/uinput, e.g. docker run --device /dev/uinput.
+
+const uinputPath = "/dev/uinput"
+
+// ioctl requests and event types from linux/uinput.h and input-event-codes.h.
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetAbsBit  = 0x40045567
+	uiSetPropBit = 0x4004556e
+
+	evSyn = 0x00
+	evKey = 0x01
+	evAbs = 0x03
+
+	synReport  = 0
+	busVirtual = 0x06
+)
+
+// uinputAbs describes one absolute axis of a device.
+type uinputAbs struct {
+	code       uint16
+	min, max   int32
+	fuzz, flat int32
+}
+
+type uinputSetup struct {
+	name    string
+	vendor  uint16
+	product uint16
+	keys    []uint16
+	abs     []uinputAbs
+	props   []uint16
+}
+
+// uinputDevice is a virtual input device. It disappears when closed.
+type uinputDevice struct {
+	f *os.File
+}
+
+func uinputIoctl(f *os.File, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// newUinputDevice registers a device with the given capabilities.
+func newUinputDevice(s uinputSetup) (*uinputDevice, error) {
+	f, err := os.OpenFile(uinputPath, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(what string, err error) (*uinputDevice, error) {
+		f.Close()
+		return nil, fmt.Errorf("uinput %s: %w", what, err)
+	}
+
+	if len(s.keys) > 0 {
+		if err := uinputIoctl(f, uiSetEvBit, evKey); err != nil {
+			return fail("EV_KEY", err)
+		}
+		for _, k := range s.keys {
+			if err := uinputIoctl(f, uiSetKeyBit, uintptr(k)); err != nil {
+				return fail("key bit", err)
+			}
+		}
+	}
+	// struct uinput_user_dev, the setup interface every kernel supports.
+	var absMax, absMin, absFuzz, absFlat [64]int32
+	if len(s.abs) > 0 {
+		if err := uinputIoctl(f, uiSetEvBit, evAbs); err != nil {
+			return fail("EV_ABS", err)
+		}
+		for _, a := range s.abs {
+			if err := uinputIoctl(f, uiSetAbsBit, uintptr(a.code)); err != nil {
+				return fail("abs bit", err)
+			}
+			absMax[a.code], absMin[a.code] = a.max, a.min
+			absFuzz[a.code], absFlat[a.code] = a.fuzz, a.flat
+		}
+	}
+	for _, p := range s.props {
+		if err := uinputIoctl(f, uiSetPropBit, uintptr(p)); err != nil {
+			return fail("property", err)
+		}
+	}
+
+	var name [80]byte
+	copy(name[:len(name)-1], s.name)
+	var dev bytes.Buffer
+	binary.Write(&dev, binary.NativeEndian, name)
+	binary.Write(&dev, binary.NativeEndian, [4]uint16{busVirtual, s.vendor, s.product, 1})
+	binary.Write(&dev, binary.NativeEndian, uint32(0)) // ff_effects_max
+	for _, v := range [][64]int32{absMax, absMin, absFuzz, absFlat} {
+		binary.Write(&dev, binary.NativeEndian, v)
+	}
+	if _, err := f.Write(dev.Bytes()); err != nil {
+		return fail("setup", err)
+	}
+	if err := uinputIoctl(f, uiDevCreate, 0); err != nil {
+		return fail("create", err)
+	}
+	return &uinputDevice{f: f}, nil
+}
+
+// emit queues an event; it takes effect at the next sync.
+func (d *uinputDevice) emit(typ, code uint16, value int32) error {
+	// struct input_event; the kernel fills in the timestamp.
+	buf := make([]byte, unsafe.Sizeof(syscall.Timeval{})+8)
+	n := len(buf) - 8
+	binary.NativeEndian.PutUint16(buf[n:], typ)
+	binary.NativeEndian.PutUint16(buf[n+2:], code)
+	binary.NativeEndian.PutUint32(buf[n+4:], uint32(value))
+	_, err := d.f.Write(buf)
+	return err
+}
+
+func (d *uinputDevice) sync() error {
+	return d.emit(evSyn, synReport, 0)
+}
+
+func (d *uinputDevice) Close() error {
+	_ = uinputIoctl(d.f, uiDevDestroy, 0)
+	return d.f.Close()
+}
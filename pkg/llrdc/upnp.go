@@ -0,0 +1,310 @@
+package llrdc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UPnP Internet Gateway Device port mapping, for routers without NAT-PMP.
+// The router is found with SSDP, and the WANIPConnection (or
+// WANPPPConnection) service of its device description is driven over
+// SOAP.
+
+const (
+	ssdpAddr        = "239.255.255.250:1900"
+	ssdpWait        = 2 * time.Second
+	upnpDescription = "llrdc"
+	// upnpOnlyPermanentLeases is the error code of routers that only
+	// accept mappings without a lease duration.
+	upnpOnlyPermanentLeases = 725
+)
+
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type upnpIGD struct {
+	controlURL  string
+	serviceType string
+	local       net.IP
+	client      *http.Client
+}
+
+// discoverUPnP finds an Internet Gateway Device with a WAN connection
+// service.
+func discoverUPnP(ctx context.Context) (*upnpIGD, error) {
+	locations, err := ssdpSearch(ctx, "urn:schemas-upnp-org:device:InternetGatewayDevice:1")
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: portMappingTimeout}
+	for _, location := range locations {
+		igd, err := loadUPnPDevice(ctx, client, location)
+		if err == nil {
+			return igd, nil
+		}
+	}
+	return nil, errors.New("no gateway with a WAN connection service")
+}
+
+// ssdpSearch multicasts an M-SEARCH for target and returns the description
+// URLs of the devices that answer within ssdpWait.
+func ssdpSearch(ctx context.Context, target string) ([]string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: " + strconv.Itoa(int(ssdpWait/time.Second)) + "\r\n" +
+		"ST: " + target + "\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(req), dst); err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(ssdpWait))
+	var locations []string
+	seen := map[string]bool{}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if resp.StatusCode == http.StatusOK && location != "" && !seen[location] {
+			seen[location] = true
+			locations = append(locations, location)
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(locations) == 0 {
+		return nil, errors.New("no response to SSDP search")
+	}
+	return locations, nil
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// loadUPnPDevice reads a device description and picks its WAN connection
+// service.
+func loadUPnPDevice(ctx context.Context, client *http.Client, location string) (*upnpIGD, error) {
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return nil, err
+	}
+	if root.URLBase != "" {
+		if u, err := url.Parse(root.URLBase); err == nil {
+			base = u
+		}
+	}
+
+	for _, serviceType := range upnpServiceTypes {
+		controlURL := findUPnPService(root.Device, serviceType)
+		if controlURL == "" {
+			continue
+		}
+		control, err := base.Parse(controlURL)
+		if err != nil {
+			return nil, err
+		}
+		host := control.Hostname()
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("control URL host %q is not an address", host)
+		}
+		local, err := routeLocalIP(ip)
+		if err != nil {
+			return nil, err
+		}
+		return &upnpIGD{controlURL: control.String(), serviceType: serviceType, local: local, client: client}, nil
+	}
+	return nil, errors.New("no WAN connection service")
+}
+
+func findUPnPService(d upnpDevice, serviceType string) string {
+	for _, s := range d.Services {
+		if s.ServiceType == serviceType {
+			return s.ControlURL
+		}
+	}
+	for _, child := range d.Devices {
+		if u := findUPnPService(child, serviceType); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+func (u *upnpIGD) name() string    { return "UPnP" }
+func (u *upnpIGD) localIP() net.IP { return u.local }
+
+// upnpError is a SOAP fault from the router.
+type upnpError struct {
+	code        int
+	description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", e.code, e.description)
+}
+
+// call invokes action with args (name, value pairs) and returns the
+// response's arguments.
+func (u *upnpIGD) call(ctx context.Context, action string, args ...string) (map[string]string, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, u.serviceType)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&body, "<%s>", args[i])
+		_ = xml.EscapeText(&body, []byte(args[i+1]))
+		fmt.Fprintf(&body, "</%s>", args[i])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.serviceType+"#"+action+`"`)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Collect the leaf elements of the body: the response arguments, or
+	// the fault's errorCode and errorDescription.
+	values := map[string]string{}
+	dec := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	var name string
+	var text []byte
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name, text = t.Name.Local, nil
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			if t.Name.Local == name {
+				values[name] = strings.TrimSpace(string(text))
+			}
+			name = ""
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		code, _ := strconv.Atoi(values["errorCode"])
+		description := values["errorDescription"]
+		if description == "" {
+			description = http.StatusText(resp.StatusCode)
+		}
+		return nil, &upnpError{code: code, description: description}
+	}
+	return values, nil
+}
+
+func (u *upnpIGD) externalIP(ctx context.Context) (net.IP, error) {
+	values, err := u.call(ctx, "GetExternalIPAddress")
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(values["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external address %q", values["NewExternalIPAddress"])
+	}
+	return ip, nil
+}
+
+func (u *upnpIGD) add(ctx context.Context, m portMapping, lifetime time.Duration) (time.Duration, error) {
+	port := strconv.Itoa(m.port)
+	addPortMapping := func(lease time.Duration) error {
+		_, err := u.call(ctx, "AddPortMapping",
+			"NewRemoteHost", "",
+			"NewExternalPort", port,
+			"NewProtocol", strings.ToUpper(m.proto),
+			"NewInternalPort", port,
+			"NewInternalClient", u.local.String(),
+			"NewEnabled", "1",
+			"NewPortMappingDescription", upnpDescription,
+			"NewLeaseDuration", strconv.Itoa(int(lease/time.Second)))
+		return err
+	}
+	err := addPortMapping(lifetime)
+	var uerr *upnpError
+	if errors.As(err, &uerr) && uerr.code == upnpOnlyPermanentLeases {
+		// Renewing a permanent mapping is harmless, and it is removed
+		// on shutdown.
+		return 0, addPortMapping(0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return lifetime, nil
+}
+
+func (u *upnpIGD) remove(ctx context.Context, m portMapping) error {
+	_, err := u.call(ctx, "DeletePortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", strconv.Itoa(m.port),
+		"NewProtocol", strings.ToUpper(m.proto))
+	return err
+}
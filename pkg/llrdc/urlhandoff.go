@@ -0,0 +1,198 @@
+package llrdc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Link handoff (URLHandoff). Session processes get an xdg-open shim first
+// on their PATH, which is also their $BROWSER. The shim posts http(s)
+// links to a loopback listener, guarded by a random token only the
+// session user can read: it sits in a 0600 file next to the shim, which
+// curl reads its header from, so it never shows on a command line. The
+// server passes the links on to the viewers that asked for them:
+//
+//	{"type":"open_urls","enabled":true}
+//	{"type":"open_url","url":"https://example.com/"}
+//
+// View-only guests never get links. When no viewer takes the link, or for
+// anything that is not a web link, the shim runs the real xdg-open, so
+// the link still opens inside the session. Applications that open links
+// through D-Bus portals or gio directly bypass the shim.
+
+const urlHandoffMaxLength = 4096
+
+var (
+	urlHandoffOnce sync.Once
+	// urlHandoffDir holds the shim, "" while the handoff is off.
+	urlHandoffDir string
+)
+
+const urlHandoffScript = `#!/bin/sh
+# Written by llrdc (--url-handoff): hands web links to the viewer.
+case "$1" in
+http://*|https://*)
+	curl -fsS -m 5 -o /dev/null -H @%s \
+		--data-urlencode "url=$1" %s && exit 0
+	;;
+esac
+PATH=%s
+%s
+exec xdg-open "$@"
+`
+
+// shellQuote quotes s for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// startURLHandoff starts the listener and writes the shim, once. Failures
+// leave the session with the plain xdg-open.
+func startURLHandoff(ctx context.Context) {
+	if !URLHandoff {
+		return
+	}
+	urlHandoffOnce.Do(func() {
+		dir, err := setupURLHandoff(ctx)
+		if err != nil {
+			log.Printf("Link handoff disabled: %v", err)
+			return
+		}
+		urlHandoffDir = dir
+		log.Printf("Link handoff enabled (shim in %s)", dir)
+	})
+}
+
+func setupURLHandoff(ctx context.Context) (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(token)
+
+	dir, err := os.MkdirTemp("", "llrdc-open-")
+	if err != nil {
+		return "", err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	browser := "unset BROWSER"
+	if b := os.Getenv("BROWSER"); b != "" {
+		browser = "export BROWSER=" + shellQuote(b)
+	}
+	auth := filepath.Join(dir, "auth")
+	if err := os.WriteFile(auth, []byte("Authorization: Bearer "+secret+"\n"), 0600); err != nil {
+		ln.Close()
+		os.RemoveAll(dir)
+		return "", err
+	}
+	script := fmt.Sprintf(urlHandoffScript,
+		shellQuote(auth),
+		shellQuote("http://"+ln.Addr().String()+"/open"),
+		shellQuote(os.Getenv("PATH")),
+		browser)
+	shim := filepath.Join(dir, "xdg-open")
+	if err := os.WriteFile(shim, []byte(script), 0700); err != nil {
+		ln.Close()
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if sessionUserEnabled() {
+		for _, p := range []string{dir, auth, shim} {
+			if err := os.Chown(p, SessionUID, SessionGID); err != nil {
+				ln.Close()
+				os.RemoveAll(dir)
+				return "", err
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/open", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if r.Method != http.MethodPost || subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		link, ok := validHandoffURL(r.PostFormValue("url"))
+		if !ok {
+			http.Error(w, "invalid url", http.StatusBadRequest)
+			return
+		}
+		n := sendOpenURL(link)
+		if n == 0 {
+			http.Error(w, "no viewer accepts links", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Handed link to %d viewer(s): %s", n, link)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := &http.Server{Handler: mux}
+	goWorker(func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("Link handoff listener failed: %v", err)
+		}
+	})
+	goWorker(func() {
+		<-ctx.Done()
+		srv.Close()
+		os.RemoveAll(dir)
+	})
+	return dir, nil
+}
+
+// validHandoffURL returns s if it is an absolute http(s) URL.
+func validHandoffURL(s string) (string, bool) {
+	if s == "" || len(s) > urlHandoffMaxLength {
+		return "", false
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// sendOpenURL sends link to the viewers that asked for links and returns
+// how many there were. While the session is locked there are none.
+func sendOpenURL(link string) int {
+	if sessionLocked.Load() {
+		return 0
+	}
+	msg := map[string]interface{}{"type": "open_url", "url": link}
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	n := 0
+	for _, client := range clients {
+		if !client.openURLs || (client.share != nil && client.share.ViewOnly) {
+			continue
+		}
+		if client.writeJSON(msg) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// setOpenURLs records whether client wants links, from an open_urls
+// message.
+func setOpenURLs(client *Client, msg *openURLsMessage) {
+	clientsMutex.Lock()
+	client.openURLs = msg.Enabled
+	clientsMutex.Unlock()
+}
@@ -0,0 +1,860 @@
+package llrdc
+
+import (
+	"bufio"
+	"context"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The VNC bridge is an RFB server on VNCAddr for legacy VNC clients and
+// automation tools. It shows the same captured desktop as the viewers,
+// through its own ffmpeg producing raw frames at vncFPS while at least one
+// VNC client is connected, and injects their input through the same path
+// as the WebSocket. Updates are sent as 64x64 tiles that changed since the
+// client's last update, using Tight (fill, JPEG when the client sets a
+// quality level, otherwise zlib) or raw encoding. Clients authenticate with
+// VNC authentication when VNCPassword is set; note that it only protects
+// the password, so use it on trusted networks or through a tunnel.
+
+const (
+	vncFPS            = 15
+	vncTile           = 64
+	vncMaxCutText     = 1 << 20
+	vncMaxEncodings   = 1024
+	vncHandshakeLimit = 10 * time.Second
+	vncAuthFailDelay  = time.Second
+
+	rfbSecurityNone = 1
+	rfbSecurityVNC  = 2
+
+	rfbSetPixelFormat           = 0
+	rfbSetEncodings             = 2
+	rfbFramebufferUpdateRequest = 3
+	rfbKeyEvent                 = 4
+	rfbPointerEvent             = 5
+	rfbClientCutText            = 6
+
+	rfbFramebufferUpdate = 0
+	rfbServerCutText     = 3
+)
+
+// vncFrame is one captured frame in BGRX. Frames are never modified once
+// published, so clients can hold on to them.
+type vncFrame struct {
+	width, height int
+	pixels        []byte
+}
+
+// vncSource captures raw frames while VNC clients are connected and wakes
+// them when a new one arrives.
+type vncSource struct {
+	ctx context.Context
+
+	mu     sync.Mutex
+	subs   map[chan struct{}]struct{}
+	stop   context.CancelFunc
+	latest *vncFrame
+}
+
+var (
+	vncFrames  *vncSource
+	vncClients = struct {
+		sync.Mutex
+		m map[*vncClient]struct{}
+	}{m: make(map[*vncClient]struct{})}
+)
+
+func (s *vncSource) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[ch] = struct{}{}
+	if s.stop == nil {
+		ctx, cancel := context.WithCancel(s.ctx)
+		s.stop = cancel
+		goWorker(func() { s.produce(ctx) })
+	}
+	return ch
+}
+
+func (s *vncSource) unsubscribe(ch chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, ch)
+	if len(s.subs) == 0 && s.stop != nil {
+		s.stop()
+		s.stop = nil
+		s.latest = nil
+	}
+}
+
+func (s *vncSource) frame() *vncFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest
+}
+
+func (s *vncSource) publish(f *vncFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = f
+	for ch := range s.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// produce runs the raw capture until ctx is cancelled, restarting it when
+// it exits or the screen is resized.
+func (s *vncSource) produce(ctx context.Context) {
+	for ctx.Err() == nil {
+		ffmpegMutex.Lock()
+		drawMouse := targetDrawMouse
+		ffmpegMutex.Unlock()
+		width, height := GetScreenSize()
+		capture := currentCapture()
+		input := capture.Input(captureParams{Width: width, Height: height, FPS: vncFPS, DrawMouse: drawMouse})
+
+		args := []string{"-nostats", "-loglevel", "error"}
+		args = append(args, input.Args...)
+		args = append(args, input.filterArgs()...)
+		args = append(args, "-an", "-f", "rawvideo", "-pix_fmt", "bgr0", "pipe:1")
+
+		log.Printf("Starting VNC capture at %dx%d, %d fps via %s", width, height, vncFPS, capture.Name())
+		runCtx, cancel := context.WithCancel(ctx)
+		cmd := exec.CommandContext(runCtx, ffmpegBinary(), args...)
+		cmd.Env = append(os.Environ(), "DISPLAY="+Display)
+		cmd.Stdin = input.Stdin
+		cmd.Stderr = newStderrLog("vnc ffmpeg")
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			cancel()
+			log.Printf("Failed to start VNC ffmpeg: %v", err)
+			sleepCtx(ctx, 5*time.Second)
+			continue
+		}
+
+		r := bufio.NewReaderSize(stdout, 1<<20)
+		for {
+			pixels := make([]byte, width*height*4)
+			if _, err := io.ReadFull(r, pixels); err != nil {
+				break
+			}
+			if w, h := GetScreenSize(); w != width || h != height {
+				break
+			}
+			if !sessionLocked.Load() {
+				s.publish(&vncFrame{width: width, height: height, pixels: pixels})
+			}
+		}
+		cancel()
+		err = cmd.Wait()
+		if ctx.Err() != nil {
+			break
+		}
+		if w, h := GetScreenSize(); w == width && h == height {
+			log.Printf("VNC ffmpeg exited: %v", err)
+			sleepCtx(ctx, 1*time.Second)
+		}
+	}
+	log.Printf("VNC capture stopped")
+}
+
+// startVNC serves the VNC bridge until ctx is cancelled.
+func startVNC(ctx context.Context) error {
+	if VNCAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", VNCAddr)
+	if err != nil {
+		return err
+	}
+	if VNCPassword == "" {
+		if host, _, _ := net.SplitHostPort(VNCAddr); !isLoopbackHost(host) {
+			log.Printf("Warning: the VNC bridge on %s has no --vnc-password; anyone who can reach it controls the session", VNCAddr)
+		}
+	} else if len(VNCPassword) > 8 {
+		log.Printf("Warning: VNC authentication only uses the first 8 characters of --vnc-password")
+	}
+	vncFrames = &vncSource{ctx: ctx, subs: make(map[chan struct{}]struct{})}
+
+	goWorker(func() {
+		<-ctx.Done()
+		ln.Close()
+	})
+	goWorker(func() {
+		log.Printf("VNC bridge listening on %s", ln.Addr())
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("VNC bridge stopped: %v", err)
+				}
+				return
+			}
+			if connFilter != nil {
+				host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+				if ok, reason := connFilter.allowed(net.ParseIP(host)); !ok {
+					log.Printf("Rejected VNC client from %s: %s", conn.RemoteAddr(), reason)
+					conn.Close()
+					continue
+				}
+			}
+			go serveVNC(ctx, conn)
+		}
+	})
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// vncBroadcastClipboard forwards a clipboard_get message from the clipboard
+// poller to VNC clients as ServerCutText.
+func vncBroadcastClipboard(msg interface{}) {
+	m, ok := msg.(map[string]interface{})
+	if !ok || m["type"] != "clipboard_get" {
+		return
+	}
+	text, _ := m["text"].(string)
+	vncClients.Lock()
+	defer vncClients.Unlock()
+	for c := range vncClients.m {
+		c.sendCutText(text)
+	}
+}
+
+// vncClient is one RFB connection. The connection's goroutine reads client
+// messages; updates are sent from a second goroutine when the client has
+// asked for one and something changed.
+type vncClient struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu          sync.Mutex
+	pf          rfbPixelFormat
+	tight       bool
+	quality     int
+	desktopSize bool
+	requested   bool
+	incremental bool
+	area        rfbRect
+	wake        chan struct{}
+
+	// Owned by the update goroutine: the frame size the client knows, the
+	// pixels it shows and the frame they were last compared to.
+	width, height int
+	shown         []byte
+	compared      *vncFrame
+	encoder       tightEncoder
+}
+
+func serveVNC(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	addr := conn.RemoteAddr()
+	width, height := GetScreenSize()
+	c := &vncClient{
+		conn:    conn,
+		w:       bufio.NewWriterSize(conn, 64*1024),
+		pf:      rfbNativeFormat,
+		quality: -1,
+		wake:    make(chan struct{}, 1),
+		width:   width,
+		height:  height,
+	}
+	r := bufio.NewReader(conn)
+
+	_ = conn.SetDeadline(time.Now().Add(vncHandshakeLimit))
+	if err := c.handshake(r, width, height); err != nil {
+		log.Printf("VNC handshake with %s failed: %v", addr, err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	log.Printf("VNC client connected from %s", addr)
+
+	ctx, cancel := context.WithCancel(ctx)
+	frames := vncFrames.subscribe()
+	vncClients.Lock()
+	vncClients.m[c] = struct{}{}
+	vncClients.Unlock()
+	held := heldKeys{}
+	defer func() {
+		cancel()
+		vncClients.Lock()
+		delete(vncClients.m, c)
+		vncClients.Unlock()
+		vncFrames.unsubscribe(frames)
+		held.releaseAll(Display)
+		log.Printf("VNC client disconnected from %s", addr)
+	}()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		if err := c.sendUpdates(ctx, frames); err != nil {
+			log.Printf("VNC client %s: %v", addr, err)
+		}
+		cancel()
+	}()
+
+	if err := c.readMessages(r, held); err != nil && ctx.Err() == nil && !errors.Is(err, io.EOF) {
+		log.Printf("VNC client %s: %v", addr, err)
+	}
+}
+
+func (c *vncClient) flush(b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.w.Write(b); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// handshake negotiates the protocol version and security and exchanges
+// the init messages (RFC 6143 section 7.1-7.3). Versions 3.3, 3.7 and 3.8
+// are supported; later 3.x versions are answered as 3.8.
+func (c *vncClient) handshake(r *bufio.Reader, width, height int) error {
+	if err := c.flush([]byte("RFB 003.008\n")); err != nil {
+		return err
+	}
+	var version [12]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 || minor < 3 {
+		return fmt.Errorf("unsupported protocol version %q", version)
+	}
+	switch {
+	case minor >= 8:
+		minor = 8
+	case minor >= 7:
+		minor = 7
+	default:
+		minor = 3
+	}
+
+	security := byte(rfbSecurityNone)
+	if VNCPassword != "" {
+		security = rfbSecurityVNC
+	}
+	if minor == 3 {
+		// The server decides, as a u32.
+		if err := c.flush([]byte{0, 0, 0, security}); err != nil {
+			return err
+		}
+	} else {
+		if err := c.flush([]byte{1, security}); err != nil {
+			return err
+		}
+		chosen, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if chosen != security {
+			return c.securityFailed(minor, "unsupported security type")
+		}
+	}
+
+	if security == rfbSecurityVNC {
+		ok, err := c.vncAuth(r)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			time.Sleep(vncAuthFailDelay)
+			return c.securityFailed(minor, "authentication failed")
+		}
+	}
+	if security == rfbSecurityVNC || minor == 8 {
+		if err := c.flush([]byte{0, 0, 0, 0}); err != nil {
+			return err
+		}
+	}
+
+	// ClientInit's shared flag is ignored: every client shares the desktop.
+	if _, err := r.ReadByte(); err != nil {
+		return err
+	}
+	name := "llrdc " + Display
+	msg := binary.BigEndian.AppendUint16(nil, uint16(width))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(height))
+	msg, _ = binary.Append(msg, binary.BigEndian, rfbNativeFormat)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(name)))
+	msg = append(msg, name...)
+	return c.flush(msg)
+}
+
+// securityFailed reports a failed SecurityResult, with a reason from 3.8.
+func (c *vncClient) securityFailed(minor int, reason string) error {
+	msg := []byte{0, 0, 0, 1}
+	if minor == 8 {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(len(reason)))
+		msg = append(msg, reason...)
+	}
+	_ = c.flush(msg)
+	return errors.New(reason)
+}
+
+// vncAuth runs VNC authentication: the client DES-encrypts a random
+// challenge with the password, whose key bytes have their bits reversed.
+func (c *vncClient) vncAuth(r *bufio.Reader) (bool, error) {
+	challenge := make([]byte, 16)
+	if _, err := rand.Read(challenge); err != nil {
+		return false, err
+	}
+	if err := c.flush(challenge); err != nil {
+		return false, err
+	}
+	response := make([]byte, 16)
+	if _, err := io.ReadFull(r, response); err != nil {
+		return false, err
+	}
+	var key [8]byte
+	copy(key[:], VNCPassword)
+	for i := range key {
+		key[i] = bits.Reverse8(key[i])
+	}
+	block, err := des.NewCipher(key[:])
+	if err != nil {
+		return false, err
+	}
+	expected := make([]byte, 16)
+	block.Encrypt(expected[:8], challenge[:8])
+	block.Encrypt(expected[8:], challenge[8:])
+	return subtle.ConstantTimeCompare(expected, response) == 1, nil
+}
+
+// readMessages handles client messages until the connection fails.
+func (c *vncClient) readMessages(r *bufio.Reader, held heldKeys) error {
+	var buttons byte
+	for {
+		msgType, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case rfbSetPixelFormat:
+			var msg struct {
+				_  [3]byte
+				PF rfbPixelFormat
+			}
+			if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if err := msg.PF.validate(); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.pf = msg.PF
+			c.mu.Unlock()
+
+		case rfbSetEncodings:
+			var msg struct {
+				_     byte
+				Count uint16
+			}
+			if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if msg.Count > vncMaxEncodings {
+				return fmt.Errorf("too many encodings (%d)", msg.Count)
+			}
+			encodings := make([]int32, msg.Count)
+			if err := binary.Read(r, binary.BigEndian, encodings); err != nil {
+				return err
+			}
+			c.setEncodings(encodings)
+
+		case rfbFramebufferUpdateRequest:
+			var msg struct {
+				Incremental         uint8
+				X, Y, Width, Height uint16
+			}
+			if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			area := rfbRect{int(msg.X), int(msg.Y), int(msg.Width), int(msg.Height)}
+			if c.requested {
+				// Merge into the pending request.
+				c.incremental = c.incremental && msg.Incremental != 0
+				c.area = c.area.union(area)
+			} else {
+				c.requested, c.incremental, c.area = true, msg.Incremental != 0, area
+			}
+			c.mu.Unlock()
+			select {
+			case c.wake <- struct{}{}:
+			default:
+			}
+
+		case rfbKeyEvent:
+			var msg struct {
+				Down   uint8
+				_      [2]byte
+				Keysym uint32
+			}
+			if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			key := keysymName(msg.Keysym)
+			if key == "" || sessionLocked.Load() {
+				continue
+			}
+			action := "keyup"
+			if msg.Down != 0 {
+				action = "keydown"
+			}
+			noteInput()
+			if held.filter(key, action) {
+				injectKey(key, action, Display)
+			}
+
+		case rfbPointerEvent:
+			var msg struct {
+				Mask uint8
+				X, Y uint16
+			}
+			if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if sessionLocked.Load() {
+				continue
+			}
+			noteInput()
+			c.pointer(msg.Mask, buttons, int(msg.X), int(msg.Y))
+			buttons = msg.Mask
+
+		case rfbClientCutText:
+			var msg struct {
+				_      [3]byte
+				Length uint32
+			}
+			if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+				return err
+			}
+			if msg.Length > vncMaxCutText {
+				return fmt.Errorf("cut text too long (%d bytes)", msg.Length)
+			}
+			latin1 := make([]byte, msg.Length)
+			if _, err := io.ReadFull(r, latin1); err != nil {
+				return err
+			}
+			if sessionLocked.Load() {
+				continue
+			}
+			text := make([]rune, len(latin1))
+			for i, b := range latin1 {
+				text[i] = rune(b)
+			}
+			handleClipboardSet(&clipboardSetMessage{Text: string(text)}, Display)
+
+		default:
+			return fmt.Errorf("unknown message type %d", msgType)
+		}
+	}
+}
+
+func (c *vncClient) setEncodings(encodings []int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tight, c.desktopSize, c.quality = false, false, -1
+	preferred := false
+	for _, e := range encodings {
+		switch {
+		case e == rfbEncodingTight && !preferred:
+			c.tight, preferred = true, true
+		case e == rfbEncodingRaw:
+			preferred = true
+		case e == rfbEncodingDesktopSize:
+			c.desktopSize = true
+		case e >= rfbEncodingQualityLevel0 && e <= rfbEncodingQualityLevel9:
+			c.quality = int(e - rfbEncodingQualityLevel0)
+		}
+	}
+}
+
+// pointer injects a PointerEvent: a move, then the changes to the button
+// mask, where bits 0-2 are the left, middle and right buttons and bits 3-6
+// wheel up, down, left and right.
+func (c *vncClient) pointer(mask, prev byte, x, y int) {
+	width, height := GetScreenSize()
+	nx := min(max(float64(x)/float64(max(width-1, 1)), 0), 1)
+	ny := min(max(float64(y)/float64(max(height-1, 1)), 0), 1)
+	injectMouseMove(nx, ny, Display)
+	for bit, button := range [3]int{0, 1, 2} {
+		m := byte(1) << bit
+		switch {
+		case mask&m != 0 && prev&m == 0:
+			injectMouseButton(button, "mousedown", Display)
+		case mask&m == 0 && prev&m != 0:
+			injectMouseButton(button, "mouseup", Display)
+		}
+	}
+	// Wheel buttons are pressed and released in consecutive events; act
+	// on the press.
+	pressed := mask &^ prev
+	switch {
+	case pressed&8 != 0:
+		injectMouseWheel(0, -1, Display)
+	case pressed&16 != 0:
+		injectMouseWheel(0, 1, Display)
+	case pressed&32 != 0:
+		injectMouseWheel(-1, 0, Display)
+	case pressed&64 != 0:
+		injectMouseWheel(1, 0, Display)
+	}
+}
+
+// sendCutText sends the clipboard as ServerCutText. RFB cut text is
+// Latin-1, so other characters are replaced with '?'.
+func (c *vncClient) sendCutText(text string) {
+	latin1 := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r == utf8.RuneError || r > 0xff {
+			r = '?'
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	msg := []byte{rfbServerCutText, 0, 0, 0}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(latin1)))
+	msg = append(msg, latin1...)
+	go func() { _ = c.flush(msg) }()
+}
+
+// sendUpdates answers update requests with the tiles that changed, waiting
+// for new frames while there are none.
+func (c *vncClient) sendUpdates(ctx context.Context, frames chan struct{}) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.wake:
+		case <-frames:
+		}
+		f := vncFrames.frame()
+		if f == nil {
+			continue
+		}
+		c.mu.Lock()
+		if !c.requested {
+			c.mu.Unlock()
+			continue
+		}
+		area, incremental := c.area, c.incremental
+		pf, tight, quality, desktopSize := c.pf, c.tight, c.quality, c.desktopSize
+		c.mu.Unlock()
+
+		var msg []byte
+		rects := 0
+		if f.width != c.width || f.height != c.height {
+			if !desktopSize {
+				return errors.New("desktop resized and the client does not support DesktopSize")
+			}
+			c.width, c.height = f.width, f.height
+			c.shown, c.compared = nil, nil
+			msg = appendRectHeader(msg, rfbRect{0, 0, f.width, f.height}, rfbEncodingDesktopSize)
+			rects++
+			area = rfbRect{0, 0, f.width, f.height}
+			incremental = false
+		}
+		if c.shown == nil {
+			c.shown = make([]byte, len(f.pixels))
+			incremental = false
+		}
+		if incremental && f == c.compared {
+			// Nothing new since the last update; wait for the next frame.
+			if rects == 0 {
+				continue
+			}
+		}
+		c.compared = f
+
+		area = area.intersect(rfbRect{0, 0, f.width, f.height})
+		for y := area.Y; y < area.Y+area.H; y += vncTile {
+			for x := area.X; x < area.X+area.W; x += vncTile {
+				tile := rfbRect{x, y, min(vncTile, area.X+area.W-x), min(vncTile, area.Y+area.H-y)}
+				if incremental && !c.changed(f, tile) {
+					continue
+				}
+				c.markShown(f, tile)
+				if tight {
+					msg = appendRectHeader(msg, tile, rfbEncodingTight)
+					var err error
+					if msg, err = c.encoder.encode(msg, tile, f, pf, quality); err != nil {
+						return err
+					}
+				} else {
+					msg = appendRectHeader(msg, tile, rfbEncodingRaw)
+					msg = tile.pixels(msg, f, pf)
+				}
+				rects++
+			}
+		}
+		if rects == 0 {
+			continue
+		}
+
+		c.mu.Lock()
+		c.requested = false
+		c.mu.Unlock()
+		header := []byte{rfbFramebufferUpdate, 0}
+		header = binary.BigEndian.AppendUint16(header, uint16(rects))
+		c.writeMu.Lock()
+		_, err := c.w.Write(header)
+		if err == nil {
+			_, err = c.w.Write(msg)
+		}
+		if err == nil {
+			err = c.w.Flush()
+		}
+		c.writeMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// changed reports whether the tile differs from what the client shows.
+func (c *vncClient) changed(f *vncFrame, r rfbRect) bool {
+	for y := r.Y; y < r.Y+r.H; y++ {
+		i, j := (y*f.width+r.X)*4, (y*f.width+r.X+r.W)*4
+		if string(f.pixels[i:j]) != string(c.shown[i:j]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *vncClient) markShown(f *vncFrame, r rfbRect) {
+	for y := r.Y; y < r.Y+r.H; y++ {
+		i, j := (y*f.width+r.X)*4, (y*f.width+r.X+r.W)*4
+		copy(c.shown[i:j], f.pixels[i:j])
+	}
+}
+
+func appendRectHeader(dst []byte, r rfbRect, encoding int32) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(r.X))
+	dst = binary.BigEndian.AppendUint16(dst, uint16(r.Y))
+	dst = binary.BigEndian.AppendUint16(dst, uint16(r.W))
+	dst = binary.BigEndian.AppendUint16(dst, uint16(r.H))
+	return binary.BigEndian.AppendUint32(dst, uint32(encoding))
+}
+
+func (r rfbRect) intersect(o rfbRect) rfbRect {
+	x0, y0 := max(r.X, o.X), max(r.Y, o.Y)
+	x1, y1 := min(r.X+r.W, o.X+o.W), min(r.Y+r.H, o.Y+o.H)
+	if x1 <= x0 || y1 <= y0 {
+		return rfbRect{}
+	}
+	return rfbRect{x0, y0, x1 - x0, y1 - y0}
+}
+
+func (r rfbRect) union(o rfbRect) rfbRect {
+	if r.W == 0 || r.H == 0 {
+		return o
+	}
+	if o.W == 0 || o.H == 0 {
+		return r
+	}
+	x0, y0 := min(r.X, o.X), min(r.Y, o.Y)
+	x1, y1 := max(r.X+r.W, o.X+o.W), max(r.Y+r.H, o.Y+o.H)
+	return rfbRect{x0, y0, x1 - x0, y1 - y0}
+}
+
+// vncKeysyms names the X keysyms in the 0xff00 range that VNC clients send
+// for non-character keys; xdotool takes the names directly.
+var vncKeysyms = map[uint32]string{
+	0xff08: "BackSpace",
+	0xff09: "Tab",
+	0xff0d: "Return",
+	0xff13: "Pause",
+	0xff14: "Scroll_Lock",
+	0xff1b: "Escape",
+	0xff50: "Home",
+	0xff51: "Left",
+	0xff52: "Up",
+	0xff53: "Right",
+	0xff54: "Down",
+	0xff55: "Page_Up",
+	0xff56: "Page_Down",
+	0xff57: "End",
+	0xff61: "Print",
+	0xff63: "Insert",
+	0xff67: "Menu",
+	0xff7f: "Num_Lock",
+	0xff8d: "KP_Enter",
+	0xffaa: "KP_Multiply",
+	0xffab: "KP_Add",
+	0xffad: "KP_Subtract",
+	0xffae: "KP_Decimal",
+	0xffaf: "KP_Divide",
+	0xffe1: "Shift_L",
+	0xffe2: "Shift_R",
+	0xffe3: "Control_L",
+	0xffe4: "Control_R",
+	0xffe5: "Caps_Lock",
+	0xffe7: "Meta_L",
+	0xffe8: "Meta_R",
+	0xffe9: "Alt_L",
+	0xffea: "Alt_R",
+	0xffeb: "Super_L",
+	0xffec: "Super_R",
+	0xfe03: "ISO_Level3_Shift",
+	0xffff: "Delete",
+}
+
+func init() {
+	for i := 0; i < 35; i++ {
+		vncKeysyms[uint32(0xffbe+i)] = "F" + strconv.Itoa(i+1)
+	}
+	for i := 0; i < 10; i++ {
+		vncKeysyms[uint32(0xffb0+i)] = "KP_" + strconv.Itoa(i)
+	}
+}
+
+// keysymName returns the key name injectKey takes for an X keysym, or ""
+// for keysyms it cannot inject.
+func keysymName(sym uint32) string {
+	if name, ok := vncKeysyms[sym]; ok {
+		return name
+	}
+	switch {
+	case sym >= 0x20 && sym <= 0x7e, sym >= 0xa0 && sym <= 0xff:
+		// Latin-1 keysyms are their code points.
+		return string(rune(sym))
+	case sym >= 0x01000100 && sym <= 0x0110ffff:
+		return string(rune(sym - 0x01000000))
+	}
+	return ""
+}
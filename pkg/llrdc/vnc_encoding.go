@@ -0,0 +1,208 @@
+package llrdc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+)
+
+// RFB encodings and the pixel formats llrdc can convert to. Frames are
+// held as BGRX (ffmpeg's bgr0), which is also the pixel format offered to
+// clients, so unless a client asks for something else raw rectangles are
+// plain copies.
+
+const (
+	rfbEncodingRaw         = 0
+	rfbEncodingTight       = 7
+	rfbEncodingDesktopSize = -223
+	// Tight quality levels are the pseudo-encodings -32 (0) to -23 (9).
+	rfbEncodingQualityLevel0 = -32
+	rfbEncodingQualityLevel9 = -23
+
+	tightFill      = 0x80
+	tightJPEG      = 0x90
+	tightMinToZlib = 12
+	tightZlibLevel = 1
+)
+
+// tightJPEGQuality maps the Tight quality levels 0-9 to JPEG qualities.
+var tightJPEGQuality = [10]int{15, 29, 41, 42, 62, 77, 79, 86, 92, 100}
+
+type rfbPixelFormat struct {
+	BitsPerPixel uint8
+	Depth        uint8
+	BigEndian    uint8
+	TrueColour   uint8
+	RedMax       uint16
+	GreenMax     uint16
+	BlueMax      uint16
+	RedShift     uint8
+	GreenShift   uint8
+	BlueShift    uint8
+	_            [3]byte
+}
+
+// rfbNativeFormat is BGRX in memory: 32-bit little-endian 0x00RRGGBB.
+var rfbNativeFormat = rfbPixelFormat{
+	BitsPerPixel: 32, Depth: 24, TrueColour: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255,
+	RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+func (pf rfbPixelFormat) validate() error {
+	if pf.TrueColour == 0 {
+		return errors.New("colour-mapped pixel formats are not supported")
+	}
+	switch pf.BitsPerPixel {
+	case 8, 16, 32:
+		return nil
+	}
+	return errors.New("unsupported bits per pixel")
+}
+
+// tightPixel24 reports whether Tight sends pixels of pf as 3-byte RGB
+// (TPIXEL) instead of in the pixel format itself.
+func (pf rfbPixelFormat) tightPixel24() bool {
+	return pf.BitsPerPixel == 32 && pf.Depth == 24 && pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255
+}
+
+// appendPixel appends one BGRX pixel in pf.
+func (pf rfbPixelFormat) appendPixel(dst []byte, bgrx []byte) []byte {
+	if pf == rfbNativeFormat {
+		return append(dst, bgrx[0], bgrx[1], bgrx[2], 0)
+	}
+	r, g, b := uint32(bgrx[2]), uint32(bgrx[1]), uint32(bgrx[0])
+	v := (r*uint32(pf.RedMax)+127)/255<<pf.RedShift |
+		(g*uint32(pf.GreenMax)+127)/255<<pf.GreenShift |
+		(b*uint32(pf.BlueMax)+127)/255<<pf.BlueShift
+	switch pf.BitsPerPixel {
+	case 8:
+		return append(dst, byte(v))
+	case 16:
+		if pf.BigEndian != 0 {
+			return binary.BigEndian.AppendUint16(dst, uint16(v))
+		}
+		return binary.LittleEndian.AppendUint16(dst, uint16(v))
+	default:
+		if pf.BigEndian != 0 {
+			return binary.BigEndian.AppendUint32(dst, v)
+		}
+		return binary.LittleEndian.AppendUint32(dst, v)
+	}
+}
+
+// rfbRect is a rectangle of the framebuffer.
+type rfbRect struct {
+	X, Y, W, H int
+}
+
+// pixels appends the rectangle of f in pf.
+func (r rfbRect) pixels(dst []byte, f *vncFrame, pf rfbPixelFormat) []byte {
+	for y := r.Y; y < r.Y+r.H; y++ {
+		row := f.pixels[(y*f.width+r.X)*4 : (y*f.width+r.X+r.W)*4]
+		if pf == rfbNativeFormat {
+			dst = append(dst, row...)
+			continue
+		}
+		for i := 0; i < len(row); i += 4 {
+			dst = pf.appendPixel(dst, row[i:i+4])
+		}
+	}
+	return dst
+}
+
+// solid reports whether the rectangle of f has a single colour.
+func (r rfbRect) solid(f *vncFrame) bool {
+	first := f.pixels[(r.Y*f.width+r.X)*4:][:3]
+	for y := r.Y; y < r.Y+r.H; y++ {
+		row := f.pixels[(y*f.width+r.X)*4 : (y*f.width+r.X+r.W)*4]
+		for i := 0; i < len(row); i += 4 {
+			if row[i] != first[0] || row[i+1] != first[1] || row[i+2] != first[2] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// tightEncoder keeps the zlib stream a client's Tight decoder mirrors.
+type tightEncoder struct {
+	buf  bytes.Buffer
+	zlib *zlib.Writer
+}
+
+func appendTightLength(dst []byte, n int) []byte {
+	dst = append(dst, byte(n&0x7f))
+	if n > 0x7f {
+		dst[len(dst)-1] |= 0x80
+		dst = append(dst, byte(n>>7&0x7f))
+		if n > 0x3fff {
+			dst[len(dst)-1] |= 0x80
+			dst = append(dst, byte(n>>14))
+		}
+	}
+	return dst
+}
+
+// tightPixels appends the rectangle of f as Tight pixels (TPIXEL).
+func tightPixels(dst []byte, r rfbRect, f *vncFrame, pf rfbPixelFormat) []byte {
+	if !pf.tightPixel24() {
+		return r.pixels(dst, f, pf)
+	}
+	for y := r.Y; y < r.Y+r.H; y++ {
+		row := f.pixels[(y*f.width+r.X)*4 : (y*f.width+r.X+r.W)*4]
+		for i := 0; i < len(row); i += 4 {
+			dst = append(dst, row[i+2], row[i+1], row[i])
+		}
+	}
+	return dst
+}
+
+// encode appends the Tight encoding of the rectangle: a fill for solid
+// areas, JPEG at quality (0-9, or -1 for lossless) and zlib-compressed
+// pixels otherwise.
+func (t *tightEncoder) encode(dst []byte, r rfbRect, f *vncFrame, pf rfbPixelFormat, quality int) ([]byte, error) {
+	if r.solid(f) {
+		dst = append(dst, tightFill)
+		return tightPixels(dst, rfbRect{r.X, r.Y, 1, 1}, f, pf), nil
+	}
+	if quality >= 0 && pf.tightPixel24() {
+		img := image.NewRGBA(image.Rect(0, 0, r.W, r.H))
+		for y := 0; y < r.H; y++ {
+			src := f.pixels[((r.Y+y)*f.width+r.X)*4:][:r.W*4]
+			row := img.Pix[y*img.Stride:][:r.W*4]
+			for i := 0; i < len(src); i += 4 {
+				row[i], row[i+1], row[i+2], row[i+3] = src[i+2], src[i+1], src[i], 0xff
+			}
+		}
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: tightJPEGQuality[quality]}); err != nil {
+			return nil, err
+		}
+		dst = append(dst, tightJPEG)
+		dst = appendTightLength(dst, out.Len())
+		return append(dst, out.Bytes()...), nil
+	}
+
+	// Basic compression on stream 0 with the copy filter.
+	data := tightPixels(nil, r, f, pf)
+	dst = append(dst, 0x00)
+	if len(data) < tightMinToZlib {
+		return append(dst, data...), nil
+	}
+	if t.zlib == nil {
+		t.zlib, _ = zlib.NewWriterLevel(&t.buf, tightZlibLevel)
+	}
+	t.buf.Reset()
+	if _, err := t.zlib.Write(data); err != nil {
+		return nil, err
+	}
+	if err := t.zlib.Flush(); err != nil {
+		return nil, err
+	}
+	dst = appendTightLength(dst, t.buf.Len())
+	return append(dst, t.buf.Bytes()...), nil
+}
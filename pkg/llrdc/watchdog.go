@@ -0,0 +1,57 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// lastEncodedFrame holds the UnixNano time of the last frame produced by
+	// the encoder, or of the encoder start if none has been produced yet.
+	lastEncodedFrame        atomic.Int64
+	encoderWatchdogRestarts atomic.Int64
+)
+
+func markEncoderFrame() {
+	lastEncodedFrame.Store(time.Now().UnixNano())
+}
+
+// startEncoderWatchdog restarts ffmpeg if it stops producing frames for
+// EncoderWatchdogSeconds while clients are connected, e.g. when ffmpeg hangs
+// or the X server stops delivering images.
+func startEncoderWatchdog(ctx context.Context) {
+	if EncoderWatchdogSeconds <= 0 {
+		return
+	}
+	timeout := time.Duration(EncoderWatchdogSeconds) * time.Second
+
+	goWorker(func() {
+		for sleepCtx(ctx, 1*time.Second) {
+
+			clientsMutex.Lock()
+			hasClients := len(clients) > 0
+			clientsMutex.Unlock()
+			if !hasClients {
+				continue
+			}
+
+			stalled := time.Since(time.Unix(0, lastEncodedFrame.Load()))
+			if stalled < timeout {
+				continue
+			}
+
+			ffmpegMutex.Lock()
+			if ffmpegCmd != nil && ffmpegCmd.Process != nil {
+				restarts := encoderWatchdogRestarts.Add(1)
+				log.Printf("Encoder watchdog: no frames for %v, restarting ffmpeg (restart #%d)...", stalled.Round(time.Second), restarts)
+				ffmpegCmd.Process.Kill()
+			}
+			ffmpegMutex.Unlock()
+
+			// Give the restarted pipeline a full timeout before checking again.
+			markEncoderFrame()
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package llrdc
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+)
+
+// Webcam passthrough: a viewer may send its camera as an extra WebRTC
+// video track, which ffmpeg decodes into WebcamDevice, a v4l2loopback
+// device that applications in the session open like any other camera.
+// There is one device, so the most recent camera replaces any earlier one.
+
+// webcamPLIInterval is how often a keyframe is requested, so the decoder
+// recovers quickly from packet loss.
+const webcamPLIInterval = 3 * time.Second
+
+var (
+	webcamMutex  sync.Mutex
+	webcamCancel context.CancelFunc
+)
+
+type rtpDepacketizer interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// handleWebcamTrack feeds an incoming video track into WebcamDevice until
+// the track ends or another viewer's camera replaces it.
+func handleWebcamTrack(pc *webrtc.PeerConnection, track *webrtc.TrackRemote) {
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		return
+	}
+	mime := track.Codec().MimeType
+	inputFormat := "ivf"
+	switch mime {
+	case webrtc.MimeTypeH264:
+		inputFormat = "h264"
+	case webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeAV1:
+	default:
+		log.Printf("Webcam: unsupported codec %s", mime)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	webcamMutex.Lock()
+	if webcamCancel != nil {
+		webcamCancel()
+	}
+	webcamCancel = cancel
+	webcamMutex.Unlock()
+
+	cmd := exec.CommandContext(ctx, ffmpegBinary(),
+		"-hide_banner", "-loglevel", "error",
+		"-fflags", "nobuffer", "-f", inputFormat, "-i", "pipe:0",
+		"-pix_fmt", "yuv420p", "-f", "v4l2", WebcamDevice)
+	cmd.Stderr = newStderrLog("webcam ffmpeg")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Printf("Webcam: %v", err)
+		return
+	}
+	var out rtpDepacketizer
+	if inputFormat == "h264" {
+		out = h264writer.NewWith(stdin)
+	} else if out, err = ivfwriter.NewWith(stdin, ivfwriter.WithCodec(mime)); err != nil {
+		log.Printf("Webcam: %v", err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Webcam: failed to start ffmpeg: %v", err)
+		return
+	}
+	log.Printf("Webcam: feeding %s track into %s", mime, WebcamDevice)
+
+	go func() {
+		pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}}
+		for {
+			if err := pc.WriteRTCP(pli); err != nil {
+				return
+			}
+			if !sleepCtx(ctx, webcamPLIInterval) {
+				return
+			}
+		}
+	}()
+
+	for ctx.Err() == nil {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			break
+		}
+		if err := out.WriteRTP(packet); err != nil {
+			break
+		}
+	}
+	out.Close()
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		log.Printf("Webcam: ffmpeg exited: %v", err)
+	}
+	log.Printf("Webcam: %s track ended", mime)
+}
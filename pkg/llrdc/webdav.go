@@ -0,0 +1,221 @@
+package llrdc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/net/webdav"
+)
+
+// WebDAV access to a folder of the session, so users can mount it with
+// their native file manager and move files in and out. It sits behind the
+// same authentication as the viewer: the reverse proxy setting
+// IdentityHeader, and the idle lock.
+
+const webdavPrefix = "/webdav"
+
+// sessionHomeDir returns the home directory of the desktop session.
+func sessionHomeDir() string {
+	if sessionUserEnabled() {
+		return SessionHome
+	}
+	if storageHome != "" {
+		return storageHome
+	}
+	home, _ := os.UserHomeDir()
+	return home
+}
+
+// webdavRoot resolves WebDAVDir, which is relative to the session home
+// unless absolute.
+func webdavRoot() string {
+	if filepath.IsAbs(WebDAVDir) {
+		return WebDAVDir
+	}
+	return filepath.Join(sessionHomeDir(), WebDAVDir)
+}
+
+// rootDirFS is a webdav.FileSystem confined to a directory opened as an
+// os.Root. The server usually runs as root while the folder belongs to the
+// session, so a symlink made in the session, such as ~/Shared/x ->
+// /etc/shadow, must not lead WebDAV out of it: symlinks are followed only
+// while they stay inside. What it creates is given to the session user, so
+// the desktop can modify what was uploaded.
+type rootDirFS struct {
+	root *os.Root
+}
+
+// rootName turns a WebDAV path into a name relative to the root.
+func rootName(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+func (fs rootDirFS) chown(f *os.File) {
+	if !sessionUserEnabled() {
+		return
+	}
+	// Through the open file, so a name swapped meanwhile is not chowned.
+	if err := f.Chown(SessionUID, SessionGID); err != nil {
+		log.Printf("WebDAV: failed to chown %s: %v", f.Name(), err)
+	}
+}
+
+func (fs rootDirFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = rootName(name)
+	if err := fs.root.Mkdir(name, perm); err != nil {
+		return err
+	}
+	if f, err := fs.root.Open(name); err == nil {
+		fs.chown(f)
+		f.Close()
+	}
+	return nil
+}
+
+func (fs rootDirFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := fs.root.OpenFile(rootName(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 {
+		fs.chown(f)
+	}
+	return f, nil
+}
+
+func (fs rootDirFS) RemoveAll(ctx context.Context, name string) error {
+	name = rootName(name)
+	if name == "." {
+		return os.ErrInvalid
+	}
+	return fs.removeAll(name)
+}
+
+// removeAll removes name and, unless it is a symlink, what it contains.
+func (fs rootDirFS) removeAll(name string) error {
+	fi, err := fs.root.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		dir, err := fs.root.Open(name)
+		if err != nil {
+			return err
+		}
+		children, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := fs.removeAll(name + "/" + child); err != nil {
+				return err
+			}
+		}
+	}
+	return fs.root.Remove(name)
+}
+
+// Rename renames within the directories holding oldName and newName,
+// opened through the root, so neither end can be outside it. renameat does
+// not follow a symlink in the last element.
+func (fs rootDirFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = rootName(oldName), rootName(newName)
+	if oldName == "." || newName == "." {
+		return os.ErrInvalid
+	}
+	oldDir, err := fs.root.Open(path.Dir(oldName))
+	if err != nil {
+		return err
+	}
+	defer oldDir.Close()
+	newDir, err := fs.root.Open(path.Dir(newName))
+	if err != nil {
+		return err
+	}
+	defer newDir.Close()
+	if err := syscall.Renameat(int(oldDir.Fd()), path.Base(oldName), int(newDir.Fd()), path.Base(newName)); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	return nil
+}
+
+func (fs rootDirFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.root.Stat(rootName(name))
+}
+
+// openWebDAVRoot opens dir as an os.Root. dir itself must not be a
+// symlink, as the session could point it anywhere.
+func openWebDAVRoot(dir string) (*os.Root, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := root.Stat(".")
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+	// Checked after opening, so the directory cannot be swapped between
+	// the check and the open.
+	named, err := os.Lstat(dir)
+	if err != nil || !os.SameFile(opened, named) {
+		root.Close()
+		return nil, fmt.Errorf("%s is a symlink", dir)
+	}
+	return root, nil
+}
+
+// webdavHandler serves WebDAVDir under /webdav/, creating it if needed,
+// until ctx is cancelled.
+func webdavHandler(ctx context.Context) http.Handler {
+	dir := webdavRoot()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("WebDAV: failed to create %s: %v", dir, err)
+	} else if sessionUserEnabled() {
+		_ = os.Lchown(dir, SessionUID, SessionGID)
+	}
+	root, err := openWebDAVRoot(dir)
+	if err != nil {
+		log.Printf("WebDAV: not serving %s: %v", dir, err)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "WebDAV unavailable", http.StatusServiceUnavailable)
+		})
+	}
+	context.AfterFunc(ctx, func() { root.Close() })
+	log.Printf("WebDAV: serving %s at %s/", dir, webdavPrefix)
+
+	dav := &webdav.Handler{
+		Prefix:     webdavPrefix,
+		FileSystem: rootDirFS{root},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IdentityHeader != "" && requestIdentity(r) == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if sessionLocked.Load() {
+			http.Error(w, "Session locked", http.StatusForbidden)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,278 @@
+package llrdc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+type WebRTCFrame struct {
+	Data        []byte
+	CaptureTime time.Time
+}
+
+var (
+	iceUDPMuxOnce sync.Once
+	iceUDPMux     ice.UDPMux
+	iceUDPMuxErr  error
+)
+
+var (
+	videoTrack      *syncedTrack
+	audioTrack      *syncedTrack
+	videoTrackMutex sync.RWMutex
+	webrtcFrameChan = make(chan WebRTCFrame, 300)
+)
+
+// videoTrackCapability describes the current VideoCodec to WebRTC.
+func videoTrackCapability() webrtc.RTPCodecCapability {
+	mimeType := webrtc.MimeTypeVP8
+	if VideoCodec == "h264" || VideoCodec == "h264_nvenc" {
+		mimeType = webrtc.MimeTypeH264
+	} else if VideoCodec == "h265" || VideoCodec == "h265_nvenc" {
+		mimeType = "video/H265"
+	} else if VideoCodec == "av1" || VideoCodec == "av1_nvenc" {
+		mimeType = webrtc.MimeTypeAV1
+	}
+	capability := webrtc.RTPCodecCapability{MimeType: mimeType}
+	if mimeType == webrtc.MimeTypeH264 {
+		capability.SDPFmtpLine = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42E034"
+	}
+	return capability
+}
+
+func initWebRTCTrack() error {
+	videoTrackMutex.Lock()
+	defer videoTrackMutex.Unlock()
+
+	capability := videoTrackCapability()
+	log.Printf("Initializing WebRTC with %s track", capability.MimeType)
+
+	track, err := newSyncedTrack(capability, "video", "pion")
+	if err != nil {
+		return fmt.Errorf("failed to create video track: %v", err)
+	}
+	videoTrack = track
+
+	if audioTrack == nil {
+		audioTrack, err = newSyncedTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "pion")
+		if err != nil {
+			return fmt.Errorf("failed to create audio track: %v", err)
+		}
+	}
+	return nil
+}
+
+func initWebRTC(ctx context.Context) error {
+	if err := initWebRTCTrack(); err != nil {
+		return err
+	}
+
+	goSupervised(ctx, "WebRTC sampler", func() {
+		framesWritten := 0
+		lastLogTime := time.Now()
+
+		for {
+			var frame WebRTCFrame
+			select {
+			case <-ctx.Done():
+				return
+			case frame = <-webrtcFrameChan:
+				bufferedVideo.release(len(frame.Data))
+			}
+			videoTrackMutex.RLock()
+			vt := videoTrack
+			videoTrackMutex.RUnlock()
+
+			if vt == nil {
+				continue
+			}
+
+			// Frames are stamped with the time they left the encoder
+			// (avsync.go), so each one goes out as soon as it arrives.
+			if err := vt.writeFrame(frame.Data, frame.CaptureTime); err == nil {
+				framesWritten++
+			}
+			writeCappedTracks(frame.Data, frame.CaptureTime)
+
+			if time.Since(lastLogTime) >= time.Second {
+				if UseDebugFFmpeg {
+					log.Printf("WebRTC wrote %d frames to track in the last second", framesWritten)
+				}
+				framesWritten = 0
+				lastLogTime = time.Now()
+			}
+		}
+	})
+	return nil
+}
+
+func WriteWebRTCFrame(frame []byte, captureTime time.Time) {
+	if !bufferedVideo.reserve(len(frame)) {
+		log.Println("WARNING: buffered video budget exhausted, dropping WebRTC frame!")
+		return
+	}
+	select {
+	case webrtcFrameChan <- WebRTCFrame{Data: frame, CaptureTime: captureTime}:
+	default:
+		bufferedVideo.release(len(frame))
+		log.Println("WARNING: webrtcFrameChan is full, dropping frame!")
+	}
+}
+
+const defaultSTUNServer = "stun.l.google.com:19302"
+
+// stunURLs returns the STUN servers of STUNServers as stun: URLs, or none
+// in LAN-only mode. Entries may be host:port or stun:/stuns: URLs, and
+// "none" stands for no server.
+func stunURLs() []string {
+	urls := []string{}
+	if LANOnly {
+		return urls
+	}
+	for _, server := range strings.Split(STUNServers, ",") {
+		server = strings.TrimSpace(server)
+		if server == "" || server == "none" {
+			continue
+		}
+		if !strings.HasPrefix(server, "stun:") && !strings.HasPrefix(server, "stuns:") {
+			server = "stun:" + server
+		}
+		urls = append(urls, server)
+	}
+	return urls
+}
+
+// iceServers returns the ICE servers for a PeerConnection.
+func iceServers() []webrtc.ICEServer {
+	urls := stunURLs()
+	if len(urls) == 0 {
+		return nil
+	}
+	return []webrtc.ICEServer{{URLs: urls}}
+}
+
+// sharedICEUDPMux returns the UDP sockets on Port that every PeerConnection
+// shares, creating them on first use. ICE tells the connections apart by
+// their ufrag, so any number of viewers fit on the one forwarded port.
+func sharedICEUDPMux() (ice.UDPMux, error) {
+	iceUDPMuxOnce.Do(func() {
+		var opts []ice.UDPMuxFromPortOption
+		if filter := webrtcInterfaceFilter(); filter != nil {
+			opts = append(opts, ice.UDPMuxFromPortWithInterfaceFilter(filter))
+		}
+		iceUDPMux, iceUDPMuxErr = ice.NewMultiUDPMuxFromPort(Port, opts...)
+		if iceUDPMuxErr != nil {
+			iceUDPMuxErr = fmt.Errorf("failed to listen on UDP %d for WebRTC: %w", Port, iceUDPMuxErr)
+		}
+	})
+	return iceUDPMux, iceUDPMuxErr
+}
+
+// webrtcInterfaceFilter returns the interface filter for
+// WebRTCInterfaces and WebRTCExcludeInterfaces, or nil to allow all.
+func webrtcInterfaceFilter() func(string) bool {
+	var filter func(string) bool
+	webrtcInterfaces := WebRTCInterfaces
+	webrtcExcludeInterfaces := WebRTCExcludeInterfaces
+	if webrtcInterfaces != "" || webrtcExcludeInterfaces != "" {
+		interfaces := strings.Split(webrtcInterfaces, ",")
+		excludeInterfaces := strings.Split(webrtcExcludeInterfaces, ",")
+		filter = func(i string) bool {
+			// Check exclusions first
+			if webrtcExcludeInterfaces != "" {
+				for _, excl := range excludeInterfaces {
+					if i == excl || strings.HasPrefix(i, excl) {
+						return false
+					}
+				}
+			}
+			// If allowed interfaces are specified, check those
+			if webrtcInterfaces != "" {
+				for _, iface := range interfaces {
+					if i == iface {
+						return true
+					}
+				}
+				return false
+			}
+			// Otherwise allow
+			return true
+		}
+		log.Printf("WebRTC Setting InterfaceFilter: allow=%v, exclude=%v", interfaces, excludeInterfaces)
+	}
+	return filter
+}
+
+func createPeerConnection() (*webrtc.PeerConnection, error) {
+	mux, err := sharedICEUDPMux()
+	if err != nil {
+		return nil, err
+	}
+	s := webrtc.SettingEngine{}
+	s.SetICEUDPMux(mux)
+	if filter := webrtcInterfaceFilter(); filter != nil {
+		s.SetInterfaceFilter(filter)
+	}
+
+	// Optionally allow overriding the public IP (e.g., if behind a strict NAT)
+	publicIP := WebRTCPublicIP
+	if publicIP != "" {
+		if net.ParseIP(publicIP) != nil {
+			s.SetNAT1To1IPs([]string{publicIP}, webrtc.ICECandidateTypeHost)
+			log.Printf("WebRTC Setting NAT1To1IPs to %s", publicIP)
+		} else {
+			log.Printf("Warning: WEBRTC_PUBLIC_IP '%s' is not a valid IP. Ignoring.", publicIP)
+		}
+	} else if mapped := portMappedIP.Load(); mapped != nil && !LANOnly {
+		// The router forwards the ICE port: offer its external address
+		// too, keeping the local one for viewers on the LAN.
+		err := s.SetICEAddressRewriteRules(webrtc.ICEAddressRewriteRule{
+			External:        []string{mapped[0].String()},
+			Local:           mapped[1].String(),
+			AsCandidateType: webrtc.ICECandidateTypeHost,
+			Mode:            webrtc.ICEAddressRewriteAppend,
+		})
+		if err != nil {
+			log.Printf("Warning: advertising the mapped address %s failed: %v", mapped[0], err)
+		}
+	}
+
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(s))
+
+	// Without STUN servers only host candidates are gathered.
+	config := webrtc.Configuration{
+		ICEServers: iceServers(),
+	}
+
+	pc, err := api.NewPeerConnection(config)
+	if err != nil {
+		return nil, err
+	}
+
+	videoTrackMutex.RLock()
+	vt := videoTrack
+	at := audioTrack
+	videoTrackMutex.RUnlock()
+
+	videoSender, err := pc.AddTrack(vt)
+	if err != nil {
+		return nil, err
+	}
+	go readVideoRTCP(pc, videoSender)
+
+	if at != nil {
+		if _, err = pc.AddTrack(at); err != nil {
+			return nil, err
+		}
+	}
+
+	return pc, nil
+}
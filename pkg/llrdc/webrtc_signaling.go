@@ -0,0 +1,152 @@
+package llrdc
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// WebRTC signaling over the viewer's WebSocket. A viewer sends a new
+// webrtc_offer to replace its PeerConnection (on reconnect, or when it
+// starts or stops its webcam) or to renegotiate the one it has (an ICE
+// restart). The viewer numbers its PeerConnections and sends the number as
+// "generation" with its offers and candidates, and the server echoes it on
+// its answers and candidates: an offer of the current generation
+// renegotiates the current PeerConnection, any other replaces it, and
+// either side drops messages of a generation it no longer has. The
+// callbacks of a replaced PeerConnection are disarmed, so a late candidate
+// of it never reaches the viewer. Offers without a generation (older
+// viewers) always replace the PeerConnection.
+
+// peerSession is a WebSocket client's PeerConnection. Its fields belong to
+// the client's read loop; only serial is read by the callbacks.
+type peerSession struct {
+	pc *webrtc.PeerConnection
+	// gen is the viewer's generation of pc, if hasGen.
+	gen    float64
+	hasGen bool
+	// serial counts replaced PeerConnections. A callback only acts while
+	// it is the serial its PeerConnection was created with.
+	serial atomic.Uint64
+}
+
+// withGeneration tags a message for the viewer with the generation it
+// belongs to, if the viewer sent one.
+func withGeneration(gen float64, hasGen bool, msg map[string]interface{}) map[string]interface{} {
+	if hasGen {
+		msg["generation"] = gen
+	}
+	return msg
+}
+
+// close closes the PeerConnection and disarms its callbacks.
+func (s *peerSession) close() {
+	s.serial.Add(1)
+	if s.pc != nil {
+		s.pc.Close()
+		s.pc = nil
+	}
+}
+
+// handleWebRTCOffer answers an offer, renegotiating the current
+// PeerConnection or replacing it, and returns the PeerConnection if it is
+// a new one.
+func handleWebRTCOffer(msg *webrtcOfferMessage, s *peerSession, writeJSON func(interface{}) error) *webrtc.PeerConnection {
+	log.Println("Received webrtc_offer")
+	sdp := webrtc.SessionDescription{Type: webrtc.NewSDPType(msg.SDP.Type), SDP: msg.SDP.SDP}
+	if sdp.Type == webrtc.SDPTypeUnknown {
+		log.Printf("webrtc_offer has an invalid SDP type %q", msg.SDP.Type)
+		return nil
+	}
+	var gen float64
+	hasGen := msg.Generation != nil
+	if hasGen {
+		gen = *msg.Generation
+	}
+
+	if s.pc != nil && hasGen && s.hasGen && gen == s.gen &&
+		s.pc.ConnectionState() != webrtc.PeerConnectionStateClosed &&
+		s.pc.SignalingState() == webrtc.SignalingStateStable {
+		err := answerWebRTCOffer(s.pc, sdp)
+		if err == nil {
+			log.Println("Sending webrtc_answer (renegotiated)")
+			writeJSON(withGeneration(gen, hasGen, map[string]interface{}{
+				"type": "webrtc_answer",
+				"sdp":  s.pc.LocalDescription(),
+			}))
+			return nil
+		}
+		log.Printf("Renegotiation failed, replacing the PeerConnection: %v", err)
+	}
+
+	s.close()
+	newPC, err := createPeerConnection()
+	if err != nil {
+		log.Printf("Failed to create PeerConnection: %v", err)
+		return nil
+	}
+	s.pc, s.gen, s.hasGen = newPC, gen, hasGen
+	serial := s.serial.Load()
+
+	if WebcamDevice != "" {
+		newPC.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			handleWebcamTrack(newPC, track)
+		})
+	}
+	if msg.Seamless && SeamlessWindows {
+		if err := addWindowTracks(newPC); err != nil {
+			log.Printf("Failed to add the window tracks: %v", err)
+		}
+	}
+
+	newPC.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil || s.serial.Load() != serial {
+			return
+		}
+		writeJSON(withGeneration(gen, hasGen, map[string]interface{}{
+			"type":      "webrtc_ice",
+			"candidate": candidate.ToJSON(),
+		}))
+	})
+
+	if err := answerWebRTCOffer(newPC, sdp); err != nil {
+		log.Println(err)
+		return newPC
+	}
+
+	log.Println("Sending webrtc_answer")
+	writeJSON(withGeneration(gen, hasGen, map[string]interface{}{
+		"type": "webrtc_answer",
+		"sdp":  newPC.LocalDescription(),
+	}))
+	return newPC
+}
+
+func answerWebRTCOffer(pc *webrtc.PeerConnection, sdp webrtc.SessionDescription) error {
+	if err := pc.SetRemoteDescription(sdp); err != nil {
+		return fmt.Errorf("SetRemoteDescription error: %w", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("CreateAnswer error: %w", err)
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("SetLocalDescription error: %w", err)
+	}
+	return nil
+}
+
+func handleWebRTCICE(msg *webrtcICEMessage, s *peerSession) {
+	if s.pc == nil {
+		return
+	}
+	if msg.Generation != nil && (!s.hasGen || *msg.Generation != s.gen) {
+		// A candidate of a replaced PeerConnection.
+		return
+	}
+	if err := s.pc.AddICECandidate(msg.Candidate); err != nil {
+		log.Printf("AddICECandidate error: %v", err)
+	}
+}
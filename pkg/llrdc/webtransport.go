@@ -0,0 +1,194 @@
+package llrdc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/webtransport-go"
+)
+
+// WebTransport is an alternative to the WebSocket for browsers that support
+// it, served on the HTTP/3 listener. It carries the same messages, WebRTC
+// signaling included, so a client behaves exactly as over a WebSocket. When
+// WebRTC is blocked, WebSocket fallback video moves onto unreliable QUIC
+// datagrams, where a lost frame is skipped rather than stalling the ones
+// behind it.
+//
+// GET /wt (over any transport) returns {"url": ...}, the WebTransport URL,
+// and CONNECT /wt over HTTP/3 opens a session. The client then opens one
+// bidirectional stream carrying frames of
+//
+//	[kind u8][length u32][payload]
+//
+// with kind 0 for JSON text, 1 for binary messages and, server to client
+// only, 2 for a video packet as [seq u32][packet]. Video packets are those
+// of the WebSocket fallback (types 1 and 3) numbered by a per-session
+// sequence. Keyframes and legacy type 1 packets go on the stream; delta
+// chunks (type 3) go as datagrams of
+//
+//	[seq u32][index u16][count u16][fragment]
+//
+// The client reassembles them, and after a gap in the sequence waits for
+// the next keyframe, which comes at least every keyframe interval.
+
+const (
+	webTransportPath       = "/wt"
+	wtFrameText            = 0
+	wtFrameBinary          = 1
+	wtFrameVideo           = 2
+	wtMaxMessage           = 1 << 20
+	wtDatagramPayload      = 1100
+	wtDatagramHeader       = 8
+	wtStreamAcceptDeadline = 10 * time.Second
+)
+
+// webTransportServer is set by startHTTP3 when WebTransport is enabled.
+var webTransportServer *webtransport.Server
+
+type wtConn struct {
+	sess     *webtransport.Session
+	stream   *webtransport.Stream
+	reader   *bufio.Reader
+	videoSeq uint32
+}
+
+func (c *wtConn) ReadMessage() (int, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > wtMaxMessage {
+		return 0, nil, fmt.Errorf("WebTransport message of %d bytes is too large", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return 0, nil, err
+	}
+	if header[0] == wtFrameText {
+		return websocket.TextMessage, data, nil
+	}
+	return websocket.BinaryMessage, data, nil
+}
+
+func (c *wtConn) writeFrame(kind byte, prefix, data []byte) error {
+	frame := make([]byte, 5+len(prefix)+len(data))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(prefix)+len(data)))
+	copy(frame[5:], prefix)
+	copy(frame[5+len(prefix):], data)
+	_, err := c.stream.Write(frame)
+	return err
+}
+
+func (c *wtConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.BinaryMessage {
+		return c.writeFrame(wtFrameText, nil, data)
+	}
+	if len(data) < 2 || (data[0] != 1 && data[0] != 3) {
+		return c.writeFrame(wtFrameBinary, nil, data)
+	}
+	c.videoSeq++
+	delta := data[0] == 3 && data[1]&1 == 0
+	if delta && c.sendDatagrams(data) == nil {
+		return nil
+	}
+	// If datagrams failed part way, the client completes the packet from
+	// the stream and drops the stray fragments.
+	var seq [4]byte
+	binary.BigEndian.PutUint32(seq[:], c.videoSeq)
+	return c.writeFrame(wtFrameVideo, seq[:], data)
+}
+
+// sendDatagrams sends a video packet as numbered fragments.
+func (c *wtConn) sendDatagrams(packet []byte) error {
+	count := (len(packet) + wtDatagramPayload - 1) / wtDatagramPayload
+	if count > 0xffff {
+		return fmt.Errorf("video packet of %d bytes is too large for datagrams", len(packet))
+	}
+	buf := make([]byte, wtDatagramHeader+wtDatagramPayload)
+	binary.BigEndian.PutUint32(buf[0:], c.videoSeq)
+	binary.BigEndian.PutUint16(buf[6:], uint16(count))
+	for i := 0; i < count; i++ {
+		fragment := packet[i*wtDatagramPayload : min((i+1)*wtDatagramPayload, len(packet))]
+		binary.BigEndian.PutUint16(buf[4:], uint16(i))
+		n := copy(buf[wtDatagramHeader:], fragment)
+		if err := c.sess.SendDatagram(buf[:wtDatagramHeader+n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *wtConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wtFrameText, nil, data)
+}
+
+// WriteControl only supports close messages, which end the session with
+// their reason.
+func (c *wtConn) WriteControl(messageType int, data []byte, _ time.Time) error {
+	if messageType != websocket.CloseMessage {
+		return nil
+	}
+	reason := ""
+	if len(data) > 2 {
+		reason = string(data[2:])
+	}
+	return c.sess.CloseWithError(0, reason)
+}
+
+func (c *wtConn) Close() error {
+	return c.sess.CloseWithError(0, "")
+}
+
+// webTransportHandler answers discovery requests and opens WebTransport
+// sessions.
+func webTransportHandler(w http.ResponseWriter, r *http.Request) {
+	if webTransportServer == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodConnect {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"url": "https://" + net.JoinHostPort(host, strconv.Itoa(HTTP3Port)) + webTransportPath,
+		})
+		return
+	}
+
+	sess, err := webTransportServer.Upgrade(w, r)
+	if err != nil {
+		log.Printf("WebTransport upgrade error: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(sess.Context(), wtStreamAcceptDeadline)
+	stream, err := sess.AcceptStream(ctx)
+	cancel()
+	if err != nil {
+		log.Printf("WebTransport client %s opened no stream: %v", r.RemoteAddr, err)
+		_ = sess.CloseWithError(0, "no stream")
+		return
+	}
+	log.Printf("WebTransport session from %s", r.RemoteAddr)
+	serveClient(&wtConn{sess: sess, stream: stream, reader: bufio.NewReader(stream)}, r)
+}
@@ -0,0 +1,243 @@
+package llrdc
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// WHEP (WebRTC-HTTP Egress Protocol, RFC 9725) lets standard players such
+// as GStreamer's whepsrc or OBS watch the desktop without llrdc's viewer:
+//
+//	POST   /whep        SDP offer in, SDP answer out (201, Location)
+//	PATCH  /whep/{id}   trickled ICE candidates (trickle-ice-sdpfrag)
+//	DELETE /whep/{id}   end the session
+//
+// A WHEP session receives the same video and audio tracks as the viewers
+// but sends no input. The answer carries all of llrdc's ICE candidates, so
+// clients need not support trickle ICE. WHEPToken, if set, is the bearer
+// token clients must send; otherwise the usual identity check applies.
+
+const (
+	whepPrefix          = "/whep"
+	whepMaxSDP          = 64 << 10
+	whepGatherTimeout   = 3 * time.Second
+	whepConnectDeadline = 30 * time.Second
+)
+
+type whepSession struct {
+	id      string
+	pc      *webrtc.PeerConnection
+	addr    string
+	created time.Time
+}
+
+var (
+	whepMutex    sync.Mutex
+	whepSessions = make(map[string]*whepSession)
+)
+
+func whepAuthorized(r *http.Request) bool {
+	if WHEPToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(token), []byte(WHEPToken)) == 1
+	}
+	return IdentityHeader == "" || requestIdentity(r) != ""
+}
+
+// hasContentType reports whether r carries a body of media type want.
+func hasContentType(r *http.Request, want string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == want
+}
+
+// whepHandler serves WHEP until ctx is cancelled, which ends the sessions.
+func whepHandler(ctx context.Context) http.Handler {
+	goWorker(func() {
+		<-ctx.Done()
+		closeAllWHEPSessions("server shutdown")
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+whepPrefix, handleWHEPOffer)
+	mux.HandleFunc("PATCH "+whepPrefix+"/{id}", handleWHEPCandidates)
+	mux.HandleFunc("DELETE "+whepPrefix+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !closeWHEPSession(r.PathValue("id"), "deleted by client") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browser-based players call WHEP cross-origin.
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "Location")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Accept-Patch", "application/trickle-ice-sdpfrag")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !whepAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func handleWHEPOffer(w http.ResponseWriter, r *http.Request) {
+	if !hasContentType(r, "application/sdp") {
+		http.Error(w, "expected application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := io.ReadAll(http.MaxBytesReader(w, r.Body, whepMaxSDP))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pc, err := createPeerConnection()
+	if err != nil {
+		log.Printf("WHEP: failed to create PeerConnection: %v", err)
+		http.Error(w, "failed to create PeerConnection", http.StatusInternalServerError)
+		return
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offer)}); err != nil {
+		pc.Close()
+		http.Error(w, "invalid offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		http.Error(w, "cannot answer offer: "+err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case <-gathered:
+	case <-time.After(whepGatherTimeout):
+	}
+
+	s := &whepSession{id: hex.EncodeToString(id), pc: pc, addr: r.RemoteAddr, created: time.Now()}
+	whepMutex.Lock()
+	whepSessions[s.id] = s
+	whepMutex.Unlock()
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("WHEP session %s: %s", s.id, state)
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			closeWHEPSession(s.id, state.String())
+		}
+	})
+	// Drop sessions whose client never completes ICE.
+	time.AfterFunc(whepConnectDeadline, func() {
+		if pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+			closeWHEPSession(s.id, "not connected in time")
+		}
+	})
+
+	log.Printf("WHEP session %s from %s", s.id, r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", whepPrefix+"/"+s.id)
+	w.WriteHeader(http.StatusCreated)
+	_, _ = io.WriteString(w, pc.LocalDescription().SDP)
+}
+
+// handleWHEPCandidates adds the client's trickled ICE candidates. ICE
+// restarts are not supported.
+func handleWHEPCandidates(w http.ResponseWriter, r *http.Request) {
+	whepMutex.Lock()
+	s := whepSessions[r.PathValue("id")]
+	whepMutex.Unlock()
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !hasContentType(r, "application/trickle-ice-sdpfrag") {
+		http.Error(w, "expected application/trickle-ice-sdpfrag", http.StatusUnsupportedMediaType)
+		return
+	}
+	frag, err := io.ReadAll(http.MaxBytesReader(w, r.Body, whepMaxSDP))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var mid string
+	scanner := bufio.NewScanner(strings.NewReader(string(frag)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if v, ok := strings.CutPrefix(line, "a=mid:"); ok {
+			mid = v
+			continue
+		}
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		candidate := webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a=")}
+		if mid != "" {
+			m := mid
+			candidate.SDPMid = &m
+		}
+		if err := s.pc.AddICECandidate(candidate); err != nil {
+			http.Error(w, "invalid candidate: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// closeWHEPSession ends a session and reports whether it existed.
+func closeWHEPSession(id, reason string) bool {
+	whepMutex.Lock()
+	s := whepSessions[id]
+	delete(whepSessions, id)
+	whepMutex.Unlock()
+	if s == nil {
+		return false
+	}
+	log.Printf("WHEP session %s from %s closed after %v: %s", s.id, s.addr, time.Since(s.created).Round(time.Second), reason)
+	go s.pc.Close()
+	return true
+}
+
+// closeAllWHEPSessions ends every session, e.g. when the video track is
+// replaced and the negotiated codec no longer applies. Players reconnect
+// with a new offer.
+func closeAllWHEPSessions(reason string) {
+	whepMutex.Lock()
+	ids := make([]string, 0, len(whepSessions))
+	for id := range whepSessions {
+		ids = append(ids, id)
+	}
+	whepMutex.Unlock()
+	for _, id := range ids {
+		closeWHEPSession(id, reason)
+	}
+}
@@ -0,0 +1,135 @@
+package llrdc
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Workspace (virtual desktop) switching through the EWMH hints every
+// supported window manager implements. xdotool performs the switches;
+// wmctrl, if installed, also provides the workspace names.
+
+type workspaceInfo struct {
+	Count   int
+	Current int
+	Names   []string
+}
+
+func workspaceOutput(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = sessionEnviron(Display)
+	runAsSessionUser(cmd)
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// listWorkspaces returns the number of workspaces, the current one and
+// their names.
+func listWorkspaces() (workspaceInfo, error) {
+	if TestPattern {
+		return workspaceInfo{}, fmt.Errorf("no desktop session in test pattern mode")
+	}
+	if out, err := workspaceOutput("wmctrl", "-d"); err == nil {
+		if info, ok := parseWmctrlDesktops(out); ok {
+			return info, nil
+		}
+	}
+
+	out, err := workspaceOutput("xdotool", "get_num_desktops")
+	if err != nil {
+		return workspaceInfo{}, fmt.Errorf("xdotool get_num_desktops: %w", err)
+	}
+	count, err := strconv.Atoi(out)
+	if err != nil {
+		return workspaceInfo{}, fmt.Errorf("xdotool get_num_desktops: unexpected output %q", out)
+	}
+	out, err = workspaceOutput("xdotool", "get_desktop")
+	if err != nil {
+		return workspaceInfo{}, fmt.Errorf("xdotool get_desktop: %w", err)
+	}
+	current, _ := strconv.Atoi(out)
+	info := workspaceInfo{Count: count, Current: current}
+	for i := 0; i < count; i++ {
+		info.Names = append(info.Names, fmt.Sprintf("Workspace %d", i+1))
+	}
+	return info, nil
+}
+
+// parseWmctrlDesktops parses `wmctrl -d` lines such as
+// "0  * DG: 1920x1080  VP: 0,0  WA: 0,0 1920x1040  Workspace 1".
+func parseWmctrlDesktops(out string) (workspaceInfo, bool) {
+	var info workspaceInfo
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil || index != info.Count {
+			return workspaceInfo{}, false
+		}
+		if fields[1] == "*" {
+			info.Current = index
+		}
+		name := fmt.Sprintf("Workspace %d", index+1)
+		for i, f := range fields {
+			if f != "WA:" {
+				continue
+			}
+			// The work area is "x,y WxH", or "N/A" if unset.
+			start := i + 3
+			if i+1 < len(fields) && fields[i+1] == "N/A" {
+				start = i + 2
+			}
+			if start < len(fields) {
+				name = strings.Join(fields[start:], " ")
+			}
+			break
+		}
+		info.Names = append(info.Names, name)
+		info.Count++
+	}
+	return info, info.Count > 0
+}
+
+// switchWorkspace makes workspace index (0-based) the current one.
+func switchWorkspace(index int) error {
+	if TestPattern {
+		return nil
+	}
+	return runWithEnv("xdotool", []string{"set_desktop", strconv.Itoa(index)}, sessionEnviron(Display))
+}
+
+// moveWindowToWorkspace moves the focused window to workspace index, and
+// switches there too if follow is set.
+func moveWindowToWorkspace(index int, follow bool) error {
+	if TestPattern {
+		return nil
+	}
+	env := sessionEnviron(Display)
+	if err := runWithEnv("xdotool", []string{"getactivewindow", "set_desktop_for_window", strconv.Itoa(index)}, env); err != nil {
+		return err
+	}
+	if follow {
+		return runWithEnv("xdotool", []string{"set_desktop", strconv.Itoa(index)}, env)
+	}
+	return nil
+}
+
+// workspacesMessage returns the workspaces message sent to viewers, with
+// count 0 if workspaces are unavailable.
+func workspacesMessage() map[string]interface{} {
+	info, err := listWorkspaces()
+	msg := map[string]interface{}{
+		"type":    "workspaces",
+		"count":   info.Count,
+		"current": info.Current,
+		"names":   info.Names,
+	}
+	if err != nil {
+		msg["error"] = err.Error()
+	}
+	return msg
+}
@@ -0,0 +1,38 @@
+package llrdc
+
+import (
+	"compress/flate"
+
+	"github.com/gorilla/websocket"
+)
+
+// permessage-deflate (RFC 7692) for the WebSocket, with WSCompression. It is
+// negotiated with browsers that offer it, which all current ones do, and
+// applied to text messages only: stats, cursor, clipboard and signaling
+// JSON compress well, while video and probes are already compressed, and
+// deflating them would cost CPU on every frame and skew the bandwidth probe.
+// gorilla/websocket supports only the "no context takeover" variant, so
+// each message is compressed on its own.
+
+// compressedConn is a WebSocket that compresses only text messages. Writes
+// are serialized by the caller, so switching compression per message is
+// safe.
+type compressedConn struct {
+	*websocket.Conn
+}
+
+func newCompressedConn(conn *websocket.Conn) compressedConn {
+	// Signaling messages are small and frequent; favour speed.
+	_ = conn.SetCompressionLevel(flate.BestSpeed)
+	return compressedConn{conn}
+}
+
+func (c compressedConn) WriteMessage(messageType int, data []byte) error {
+	c.Conn.EnableWriteCompression(messageType == websocket.TextMessage)
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+func (c compressedConn) WriteJSON(v interface{}) error {
+	c.Conn.EnableWriteCompression(true)
+	return c.Conn.WriteJSON(v)
+}
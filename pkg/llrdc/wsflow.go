@@ -0,0 +1,190 @@
+package llrdc
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Fragmentation and flow control for binary packets over the WebSocket. A
+// 4K keyframe can be several hundred KB, and written as one message it
+// holds the connection until it is through, so pongs, answers and other
+// JSON wait behind it. A viewer that sends
+//
+//	{"type":"video_format","format":"chunked","fragments":true}
+//
+// gets every binary packet (video and probes) split into type 4 fragments
+// of at most wsFragmentSize bytes, with JSON free to go between them:
+//
+//	[4][flags u8][seq u32 BE][part of the packet]
+//
+// Flags bit 0 marks the first fragment of a packet and bit 1 the last;
+// seq counts fragments from 0 on each connection. The viewer acknowledges
+// them with {"type":"video_ack","seq":N}, meaning every fragment up to N
+// has arrived, and the writer keeps at most WSWindowKB unacknowledged
+// before waiting. Packets queued meanwhile are dropped by the send queue
+// (sendqueue.go) as for any slow viewer. A viewer that acknowledges
+// nothing for wsAckTimeout is disconnected.
+
+const (
+	wsFragmentType  = 4
+	wsFragmentSize  = 64 << 10
+	wsFragmentFirst = 1 << 0
+	wsFragmentLast  = 1 << 1
+	wsAckTimeout    = 10 * time.Second
+)
+
+var (
+	errAckTimeout   = errors.New("no acknowledgement from the viewer")
+	errWindowClosed = errors.New("connection closed")
+)
+
+// ackWindow tracks a client's unacknowledged fragments.
+type ackWindow struct {
+	mu      sync.Mutex
+	enabled bool
+	closed  bool
+	// next is the seq of the next fragment and base that of the oldest
+	// unacknowledged one; sizes holds the sizes from base on.
+	next  uint32
+	base  uint32
+	sizes []int
+	bytes int
+	// wake is closed and replaced when an ack or close may let a
+	// waiting writer go on.
+	wake chan struct{}
+}
+
+func (w *ackWindow) isEnabled() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enabled
+}
+
+// enable turns fragmentation on or off. Turning it off forgets what is in
+// flight, so a writer waiting for acks goes on.
+func (w *ackWindow) enable(on bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enabled = on
+	if !on {
+		w.base, w.sizes, w.bytes = w.next, nil, 0
+		w.signal()
+	}
+}
+
+// signal wakes a waiting writer. mu must be held.
+func (w *ackWindow) signal() {
+	if w.wake != nil {
+		close(w.wake)
+		w.wake = nil
+	}
+}
+
+// acquire waits until a fragment of size bytes fits in the window and
+// returns its seq. A fragment always fits when nothing is in flight, so
+// one larger than the window still goes out.
+func (w *ackWindow) acquire(size int) (uint32, error) {
+	limit := WSWindowKB * 1024
+	deadline := time.Now().Add(wsAckTimeout)
+	w.mu.Lock()
+	for !w.closed && limit > 0 && w.bytes > 0 && w.bytes+size > limit {
+		if w.wake == nil {
+			w.wake = make(chan struct{})
+		}
+		wake, base := w.wake, w.base
+		w.mu.Unlock()
+		t := time.NewTimer(time.Until(deadline))
+		select {
+		case <-wake:
+		case <-t.C:
+		}
+		t.Stop()
+		w.mu.Lock()
+		if w.base != base {
+			deadline = time.Now().Add(wsAckTimeout)
+		} else if !time.Now().Before(deadline) {
+			w.mu.Unlock()
+			return 0, errAckTimeout
+		}
+	}
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errWindowClosed
+	}
+	seq := w.next
+	w.next++
+	w.sizes = append(w.sizes, size)
+	w.bytes += size
+	return seq, nil
+}
+
+// ack records that every fragment up to seq has arrived. Stale and
+// out-of-range acks are ignored.
+func (w *ackWindow) ack(seq uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := int(seq - w.base + 1)
+	if n <= 0 || n > len(w.sizes) {
+		return
+	}
+	for _, size := range w.sizes[:n] {
+		w.bytes -= size
+	}
+	w.sizes = w.sizes[n:]
+	w.base += uint32(n)
+	w.signal()
+}
+
+// close fails a waiting and any later acquire.
+func (w *ackWindow) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.signal()
+}
+
+// writeFragments sends packet as type 4 fragments, taking the connection
+// for one fragment at a time. buf is reused between calls.
+func (c *Client) writeFragments(packet []byte, buf *[]byte) error {
+	for off, first := 0, true; first || off < len(packet); first = false {
+		end := min(off+wsFragmentSize, len(packet))
+		frame := append((*buf)[:0], wsFragmentType, 0, 0, 0, 0, 0)
+		frame = append(frame, packet[off:end]...)
+		*buf = frame
+		if first {
+			frame[1] |= wsFragmentFirst
+		}
+		if end == len(packet) {
+			frame[1] |= wsFragmentLast
+		}
+		seq, err := c.flow.acquire(len(frame))
+		if err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint32(frame[2:], seq)
+		if err := c.writeMessage(websocket.BinaryMessage, frame); err != nil {
+			return err
+		}
+		off = end
+	}
+	return nil
+}
+
+// writePacket sends one queued packet, fragmented if the viewer asked.
+func (c *Client) writePacket(packet []byte, buf *[]byte) {
+	if !c.flow.isEnabled() {
+		_ = c.writeMessage(websocket.BinaryMessage, packet)
+		return
+	}
+	if err := c.writeFragments(packet, buf); errors.Is(err, errAckTimeout) {
+		log.Printf("Client %s stopped acknowledging video for %v, disconnecting", c.addr, wsAckTimeout)
+		// Close needs the writer to return, so only the connection is
+		// closed here; the reader then fails and closes the client.
+		c.conn.Close()
+	}
+}
@@ -1,6 +1,7 @@
-package main
+package llrdc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -12,7 +13,11 @@ import (
 )
 
 func getSessionDbusAddress() string {
-	out, err := exec.Command("pgrep", "-x", "xfconfd").Output()
+	proc := currentDesktop().DbusProcess
+	if proc == "" {
+		return ""
+	}
+	out, err := exec.Command("pgrep", "-x", proc).Output()
 	if err != nil {
 		return ""
 	}
@@ -34,41 +39,32 @@ func getSessionDbusAddress() string {
 	return ""
 }
 
-func startX11(displayNum string) error {
-	display := ":" + displayNum
-	log.Printf("Starting Xvfb on %s...", display)
-
-	// Clean up stale locks
-	lockFile := fmt.Sprintf("/tmp/.X%s-lock", displayNum)
-	os.Remove(lockFile)
-	socketPath := fmt.Sprintf("/tmp/.X11-unix/X%s", displayNum)
-	os.Remove(socketPath)
-
-	// Start Xvfb
-	xvfb := exec.Command("Xvfb", display, "-screen", "0", "3840x2160x24", "-nolisten", "tcp", "-ac", "+extension", "RANDR", "+extension", "XFIXES")
-	if UseDebugX11 {
-		xvfb.Stdout = os.Stdout
-		xvfb.Stderr = os.Stderr
+func startX11(ctx context.Context, displayNum string) error {
+	if err := prepareSessionStorage(); err != nil {
+		return err
 	}
-	if err := xvfb.Start(); err != nil {
-		return fmt.Errorf("failed to start Xvfb: %v", err)
+	if err := prepareSessionUser(); err != nil {
+		return err
+	}
+	startURLHandoff(ctx)
+	if err := loadSessionManifest(); err != nil {
+		return err
 	}
 
-	cleanupTasks = append(cleanupTasks, func() {
-		log.Println("Killing Xvfb...")
-		xvfb.Process.Kill()
-	})
-
-	if err := waitForXServer(socketPath, 10*time.Second); err != nil {
+	xvfb, err := startXvfb(displayNum)
+	if err != nil {
 		return err
 	}
-	log.Println("Xvfb is ready.")
 
 	// Configure X11
-	env := append(os.Environ(), "DISPLAY="+display)
-	runWithEnv("xset", []string{"s", "off"}, env)
-	runWithEnv("xset", []string{"-dpms"}, env)
-	runWithEnv("xset", []string{"s", "noblank"}, env)
+	env := sessionEnviron(Display)
+	applyXsetDefaults(env)
+	// Xvfb starts at the maximum size; shrink it to a restored size.
+	if width, height := GetScreenSize(); int64(width) != maxScreenWidth.Load() || int64(height) != maxScreenHeight.Load() {
+		if err := resizeDisplay(width, height); err != nil {
+			log.Printf("Failed to restore screen size: %v", err)
+		}
+	}
 
 	// In tests, we sometimes want a *truly static* screen so the encoder can drop
 	// identical frames. XFCE introduces periodic repaints (clock/panel/etc) which
@@ -77,6 +73,7 @@ func startX11(displayNum string) error {
 		log.Println("TEST_MINIMAL_X11 mode: skipping xfce4-session.")
 		// Best-effort: set a solid root background if xsetroot exists.
 		_ = runWithEnv("xsetroot", []string{"-solid", "#000000"}, env)
+		superviseX11(ctx, xvfb, nil, env)
 		return nil
 	}
 
@@ -84,46 +81,129 @@ func startX11(displayNum string) error {
 	log.Println("Starting pulseaudio...")
 	paCmd := exec.Command("pulseaudio", "-D", "--exit-idle-time=-1")
 	paCmd.Env = env
+	runAsSessionUser(paCmd)
 	if UseDebugX11 {
-		paCmd.Stdout = os.Stdout
-		paCmd.Stderr = os.Stderr
+		paCmd.Stdout, paCmd.Stderr = log.Writer(), log.Writer()
 	}
 	if err := paCmd.Run(); err != nil {
 		log.Printf("Warning: pulseaudio failed to start: %v", err)
 	}
 
-	// Start XFCE
-	log.Println("Starting xfce4-session...")
-	session := exec.Command("dbus-run-session", "xfce4-session")
+	session, err := startSession(env)
+	if err != nil {
+		return err
+	}
+	if KioskCommand != "" {
+		startKiosk(ctx, env)
+	}
+	configureSession(env)
+	startSessionManifest(ctx)
+
+	superviseX11(ctx, xvfb, session, env)
+	return nil
+}
+
+// startXvfb launches the virtual framebuffer and waits until it accepts
+// connections.
+func startXvfb(displayNum string) (*exec.Cmd, error) {
+	display := ":" + displayNum
+	log.Printf("Starting Xvfb on %s...", display)
+
+	// Clean up stale locks
+	lockFile := fmt.Sprintf("/tmp/.X%s-lock", displayNum)
+	os.Remove(lockFile)
+	socketPath := fmt.Sprintf("/tmp/.X11-unix/X%s", displayNum)
+	os.Remove(socketPath)
+
+	// Start Xvfb
+	xvfb := exec.Command("Xvfb", display, "-screen", "0", "3840x2160x24", "-nolisten", "tcp", "-ac", "+extension", "RANDR", "+extension", "XFIXES")
+	runAsSessionUser(xvfb)
+	if UseDebugX11 {
+		xvfb.Stdout, xvfb.Stderr = log.Writer(), log.Writer()
+	}
+	if err := xvfb.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start Xvfb: %v", err)
+	}
+
+	if err := waitForXServer(socketPath, 10*time.Second); err != nil {
+		xvfb.Process.Kill()
+		return nil, err
+	}
+	log.Println("Xvfb is ready.")
+	return xvfb, nil
+}
+
+// startSession launches the desktop session, or the kiosk window manager in
+// kiosk mode. It returns a nil command if there is nothing to supervise.
+func startSession(env []string) (*exec.Cmd, error) {
+	var args []string
+	var name string
+	if KioskCommand != "" {
+		if KioskWM == "" {
+			return nil, nil
+		}
+		name = KioskWM
+		args = []string{KioskWM}
+		log.Printf("Kiosk mode: starting window manager %s...", KioskWM)
+	} else {
+		desktop := currentDesktop()
+		if len(desktop.Command) == 0 {
+			return nil, fmt.Errorf("empty desktop session command for %q", DesktopSession)
+		}
+		name = desktop.Command[0]
+		args = desktop.Command
+		log.Printf("Starting %s (%s desktop)...", name, desktop.Name)
+	}
+
+	session := exec.Command("dbus-run-session", args...)
 	session.Env = env
+	runAsSessionUser(session)
 	if UseDebugX11 {
-		session.Stdout = os.Stdout
-		session.Stderr = os.Stderr
+		session.Stdout, session.Stderr = log.Writer(), log.Writer()
 	}
 	if err := session.Start(); err != nil {
-		return fmt.Errorf("failed to start xfce4-session: %v", err)
+		return nil, fmt.Errorf("failed to start %s: %v", name, err)
 	}
+	return session, nil
+}
 
-	cleanupTasks = append(cleanupTasks, func() {
-		log.Println("Killing xfce4-session...")
-		session.Process.Kill()
-	})
+// configureSession applies the screen saver, compositing, wallpaper and HDPI
+// tweaks to a freshly started session.
+func configureSession(env []string) {
+	if KioskCommand != "" {
+		time.Sleep(1 * time.Second)
+		applyXsetDefaults(env)
+		setRootWallpaper(env, Wallpaper)
+		applyDPISettings(env)
+		return
+	}
 
 	time.Sleep(3 * time.Second)
 
 	// Post configure
-	runWithEnv("xset", []string{"s", "off"}, env)
-	runWithEnv("xset", []string{"-dpms"}, env)
-	runWithEnv("xset", []string{"s", "noblank"}, env)
-	runWithEnv("xfconf-query", []string{"-c", "xfwm4", "-p", "/general/use_compositing", "-s", "false"}, env)
+	applyXsetDefaults(env)
+
+	desktop := currentDesktop()
+	sessionEnv := env
+	if dbusAddr := getSessionDbusAddress(); dbusAddr != "" {
+		sessionEnv = append(env, "DBUS_SESSION_BUS_ADDRESS="+dbusAddr)
+	}
+	if desktop.DisableCompositing != nil {
+		desktop.DisableCompositing(sessionEnv)
+	}
 
 	// Set wallpaper
-	setWallpaper(env, displayNum)
+	setWallpaper(env)
 
-	// Apply HDPI settings if enabled
-	applyHdpiSettings(env)
+	// Apply DPI/HDPI settings if enabled
+	applyDPISettings(env)
+}
 
-	return nil
+func applyXsetDefaults(env []string) {
+	runWithEnv("xset", []string{"s", "off"}, env)
+	runWithEnv("xset", []string{"-dpms"}, env)
+	runWithEnv("xset", []string{"s", "noblank"}, env)
+	runWithEnv("xset", xsetKeyRepeatArgs(), env)
 }
 
 func resizeDisplay(width, height int) error {
@@ -132,11 +212,12 @@ func resizeDisplay(width, height int) error {
 	}
 	mode := fmt.Sprintf("%dx%d", width, height)
 	log.Printf("Resizing X11 display to %s", mode)
-	env := append(os.Environ(), "DISPLAY="+Display)
+	env := sessionEnviron(Display)
 
 	// Try multiple ways to resize
 	// 1. try xrandr -s
 	if err := runWithEnv("xrandr", []string{"-s", mode}, env); err == nil {
+		restoreXDPI(env)
 		return nil
 	}
 
@@ -144,23 +225,37 @@ func resizeDisplay(width, height int) error {
 	if err := runWithEnv("xrandr", []string{"--fb", mode}, env); err != nil {
 		log.Printf("xrandr --fb failed: %v", err)
 	}
+	restoreXDPI(env)
 
 	return nil
 }
 
-func setWallpaper(baseEnv []string, displayNum string) {
-	dbusAddr := getSessionDbusAddress()
-	if dbusAddr == "" {
-		log.Println("Warning: Could not find DBUS session bus address; wallpaper not set.")
+// setWallpaper applies the configured wallpaper using the mechanism of the
+// current desktop session.
+func setWallpaper(baseEnv []string) {
+	desktop := currentDesktop()
+	if desktop.SetWallpaper == nil {
 		return
 	}
 
-	env := append(baseEnv, "DBUS_SESSION_BUS_ADDRESS="+dbusAddr)
+	env := baseEnv
+	if desktop.DbusProcess != "" {
+		dbusAddr := getSessionDbusAddress()
+		if dbusAddr == "" {
+			log.Println("Warning: Could not find DBUS session bus address; wallpaper not set.")
+			return
+		}
+		env = append(baseEnv, "DBUS_SESSION_BUS_ADDRESS="+dbusAddr)
+	}
+
 	wallpaper := Wallpaper
 	if wallpaper == "" {
 		wallpaper = "/usr/share/backgrounds/xfce/xfce-shapes.svg"
 	}
+	desktop.SetWallpaper(env, wallpaper)
+}
 
+func setXfceWallpaper(env []string, wallpaper string) {
 	out, _ := exec.Command("xfconf-query", "-c", "xfce4-desktop", "-l").Output()
 	allProps := strings.Split(string(out), "\n")
 	var imageProps []string
@@ -180,8 +275,9 @@ func setWallpaper(baseEnv []string, displayNum string) {
 	if len(imageProps) > 0 {
 		cmd := exec.Command("xfdesktop", "--reload")
 		cmd.Env = env
+		runAsSessionUser(cmd)
 		cmd.Run()
-		log.Printf("Wallpaper set to: %s [bus: %s]", wallpaper, dbusAddr)
+		log.Printf("Wallpaper set to: %s", wallpaper)
 	}
 }
 
@@ -199,11 +295,17 @@ func waitForXServer(socketPath string, timeout time.Duration) error {
 func runWithEnv(cmd string, args []string, env []string) error {
 	c := exec.Command(cmd, args...)
 	c.Env = env
+	runAsSessionUser(c)
 	return c.Run()
 }
 
 func applyHdpiSettings(baseEnv []string) {
-	if HDPI <= 0 {
+	percent := hdpiPercent()
+	if percent <= 0 {
+		return
+	}
+	if currentDesktop().Name != "xfce" {
+		log.Printf("HDPI scaling is only applied automatically for XFCE sessions; skipping for %s.", currentDesktop().Name)
 		return
 	}
 
@@ -215,29 +317,29 @@ func applyHdpiSettings(baseEnv []string) {
 
 	env := append(baseEnv, "DBUS_SESSION_BUS_ADDRESS="+dbusAddr)
 
-	dpi := (96 * HDPI) / 100
-	log.Printf("Applying HDPI scaling: %d%% (DPI: %d)", HDPI, dpi)
+	dpi := sessionDPI()
+	log.Printf("Applying HDPI scaling: %d%% (DPI: %d)", percent, dpi)
 
 	// Set Xft DPI
 	runWithEnv("xfconf-query", []string{"-c", "xsettings", "-p", "/Xft/DPI", "-n", "-t", "int", "-s", strconv.Itoa(dpi)}, env)
 
 	// Set GDK Window Scaling Factor
 	scale := 1
-	if HDPI >= 200 {
-		scale = HDPI / 100
+	if percent >= 200 {
+		scale = percent / 100
 	}
 	runWithEnv("xfconf-query", []string{"-c", "xsettings", "-p", "/Gdk/WindowScalingFactor", "-n", "-t", "int", "-s", strconv.Itoa(scale)}, env)
 
 	// Set Icon Size on Desktop
-	iconSize := 48 * HDPI / 100
+	iconSize := 48 * percent / 100
 	runWithEnv("xfconf-query", []string{"-c", "xfce4-desktop", "-p", "/desktop-icons/icon-size", "-n", "-t", "int", "-s", strconv.Itoa(iconSize)}, env)
 
 	// Set Cursor Size
-	cursorSize := 24 * HDPI / 100
+	cursorSize := 24 * percent / 100
 	runWithEnv("xfconf-query", []string{"-c", "xsettings", "-p", "/Gtk/CursorThemeSize", "-n", "-t", "int", "-s", strconv.Itoa(cursorSize)}, env)
 
 	// Set Panel Size
-	panelSize := 30 * HDPI / 100
+	panelSize := 30 * percent / 100
 	runWithEnv("xfconf-query", []string{"-c", "xfce4-panel", "-p", "/panels/panel-1/size", "-n", "-t", "int", "-s", strconv.Itoa(panelSize)}, env)
 	
 	// Restart panel to apply size changes effectively
@@ -1,7 +1,8 @@
-package main
+package llrdc
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"image"
@@ -78,7 +79,7 @@ func SetEnableHybrid(enable bool) {
 	}
 }
 
-func initDamageTracking(display string) {
+func initDamageTracking(ctx context.Context, display string) {
 	log.Println("Starting initDamageTracking...")
 	var err error
 	xgbConnDamage, err = xgb.NewConnDisplay(display)
@@ -111,15 +112,24 @@ func initDamageTracking(display string) {
 	}
 
 	dmgChan := make(chan image.Rectangle, 1000)
-	go func() {
+	goWorker(func() {
 		for rect := range dmgChan {
+			noteDamage(rect.Dx(), rect.Dy())
 			handleDamage(rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
 		}
-	}()
+	})
 
-	go func() {
+	conn := xgbConnDamage
+	goWorker(func() {
+		defer close(dmgChan)
+		// Closing the connection unblocks WaitForEvent on shutdown.
+		stop := context.AfterFunc(ctx, conn.Close)
+		defer stop()
 		for {
-			ev, err := xgbConnDamage.WaitForEvent()
+			ev, err := conn.WaitForEvent()
+			if ctx.Err() != nil {
+				return
+			}
 			if err != nil {
 				log.Printf("XGB WaitForEvent error: %v", err)
 				return
@@ -129,7 +139,7 @@ func initDamageTracking(display string) {
 			}
 			switch e := ev.(type) {
 			case damage.NotifyEvent:
-				damage.Subtract(xgbConnDamage, e.Damage, 0, 0)
+				damage.Subtract(conn, e.Damage, 0, 0)
 				select {
 				case dmgChan <- image.Rect(int(e.Area.X), int(e.Area.Y), int(e.Area.X)+int(e.Area.Width), int(e.Area.Y)+int(e.Area.Height)):
 				default:
@@ -137,7 +147,7 @@ func initDamageTracking(display string) {
 				}
 			}
 		}
-	}()
+	})
 	log.Println("XDamage tracking initialized.")
 }
 
@@ -199,7 +209,7 @@ func handleDamage(x, y, w, h int) {
 
 func sendLosslessPatches() {
 	damageTrackerMutex.Lock()
-	if !EnableHybrid {
+	if !EnableHybrid || sessionLocked.Load() {
 		dirtyTiles = make(map[string]image.Rectangle)
 		damageTrackerMutex.Unlock()
 		return
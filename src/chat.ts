@@ -0,0 +1,229 @@
+import { chatBtn, annotateBtn, chatPanel, chatLogEl, chatForm, chatNameInput, chatInput, annotationLayerEl, annotationSvg, videoEl, displayEl } from './ui';
+
+// Chat and annotations shared with the other viewers of the session, see
+// chat.go. The server echoes our own messages back, so everything is shown
+// when it arrives from the server, in the order everyone sees it.
+
+const SVG_NS = 'http://www.w3.org/2000/svg';
+const NAME_KEY = 'llrdc-chat-name';
+const ANNOTATION_COLOR = '#ff9800';
+const POINTER_MS = 3000;
+const STROKE_MS = 10000;
+// Drags shorter than this (in pixels) are a pointer, not a stroke.
+const MIN_STROKE_PX = 8;
+const MAX_STROKE_POINTS = 1000;
+
+export interface ChatEntry {
+    from: string;
+    verified?: boolean;
+    text: string;
+    time: number;
+}
+
+// layoutAnnotations places the SVG over the video as object-fit: contain
+// draws it, so its pixels map onto fractions of the video.
+function layoutAnnotations() {
+    const rect = annotationLayerEl.getBoundingClientRect();
+    let videoW = displayEl ? displayEl.width : 0;
+    let videoH = displayEl ? displayEl.height : 0;
+    if (videoEl && videoEl.videoWidth > 0 && videoEl.videoHeight > 0) {
+        videoW = videoEl.videoWidth;
+        videoH = videoEl.videoHeight;
+    }
+    let drawW = rect.width;
+    let drawH = rect.height;
+    if (videoW > 0 && videoH > 0 && rect.height > 0) {
+        if (rect.width / rect.height > videoW / videoH) {
+            drawW = rect.height * videoW / videoH;
+        } else {
+            drawH = rect.width * videoH / videoW;
+        }
+    }
+    annotationSvg.style.left = `${(rect.width - drawW) / 2}px`;
+    annotationSvg.style.top = `${(rect.height - drawH) / 2}px`;
+    annotationSvg.style.width = `${drawW}px`;
+    annotationSvg.style.height = `${drawH}px`;
+}
+
+function normalizedPos(e: PointerEvent): [number, number] | null {
+    const rect = annotationSvg.getBoundingClientRect();
+    if (rect.width === 0 || rect.height === 0) return null;
+    return [
+        Math.max(0, Math.min(1, (e.clientX - rect.left) / rect.width)),
+        Math.max(0, Math.min(1, (e.clientY - rect.top) / rect.height)),
+    ];
+}
+
+function toPixels(points: [number, number][]): string {
+    const rect = annotationSvg.getBoundingClientRect();
+    return points.map(([x, y]) => `${x * rect.width},${y * rect.height}`).join(' ');
+}
+
+// fadeOut removes el after ms, fading it out over the last second.
+function fadeOut(el: SVGElement, ms: number) {
+    setTimeout(() => el.classList.add('fading'), Math.max(0, ms - 1000));
+    setTimeout(() => el.remove(), ms);
+}
+
+export function setupChat(sendMsg: (data: string) => void) {
+    if (!chatPanel || !annotationLayerEl || !annotationSvg) return;
+
+    chatNameInput.value = localStorage.getItem(NAME_KEY) || '';
+
+    // Typing in the chat must not reach the remote desktop.
+    for (const type of ['keydown', 'keyup', 'keypress']) {
+        chatPanel.addEventListener(type, (e) => e.stopPropagation());
+    }
+
+    chatBtn?.addEventListener('click', () => {
+        chatPanel.classList.toggle('hidden');
+        chatBtn.classList.remove('unread');
+        if (!chatPanel.classList.contains('hidden')) {
+            chatLogEl.scrollTop = chatLogEl.scrollHeight;
+            chatInput.focus();
+        }
+    });
+
+    chatForm.addEventListener('submit', (e) => {
+        e.preventDefault();
+        const text = chatInput.value.trim();
+        if (!text) return;
+        const name = chatNameInput.value.trim();
+        localStorage.setItem(NAME_KEY, name);
+        sendMsg(JSON.stringify({ type: 'chat', text, name }));
+        chatInput.value = '';
+    });
+
+    annotateBtn?.addEventListener('click', () => {
+        annotateBtn.classList.toggle('active', annotationLayerEl.classList.toggle('annotating'));
+    });
+
+    // While annotating, a click points, a drag draws and a right click
+    // clears everyone's annotations.
+    let stroke: [number, number][] | null = null;
+    let preview: SVGPolylineElement | null = null;
+    let start = { x: 0, y: 0 };
+    let dragged = false;
+
+    annotationLayerEl.addEventListener('pointerdown', (e) => {
+        if (e.button !== 0) return;
+        layoutAnnotations();
+        const p = normalizedPos(e);
+        if (!p) return;
+        annotationLayerEl.setPointerCapture(e.pointerId);
+        stroke = [p];
+        start = { x: e.clientX, y: e.clientY };
+        dragged = false;
+        preview = document.createElementNS(SVG_NS, 'polyline');
+        preview.setAttribute('fill', 'none');
+        preview.setAttribute('stroke', ANNOTATION_COLOR);
+        preview.setAttribute('stroke-width', '3');
+        preview.setAttribute('opacity', '0.5');
+        annotationSvg.appendChild(preview);
+    });
+
+    annotationLayerEl.addEventListener('pointermove', (e) => {
+        if (!stroke || !preview) return;
+        dragged = dragged || Math.hypot(e.clientX - start.x, e.clientY - start.y) >= MIN_STROKE_PX;
+        const p = normalizedPos(e);
+        if (!p || stroke.length >= MAX_STROKE_POINTS) return;
+        stroke.push(p);
+        preview.setAttribute('points', toPixels(stroke));
+    });
+
+    const finish = () => {
+        if (!stroke) return;
+        if (dragged && stroke.length > 1) {
+            sendMsg(JSON.stringify({ type: 'annotate', kind: 'stroke', points: stroke, color: ANNOTATION_COLOR, name: chatNameInput.value.trim() }));
+        } else {
+            const [x, y] = stroke[0];
+            sendMsg(JSON.stringify({ type: 'annotate', kind: 'pointer', x, y, color: ANNOTATION_COLOR, name: chatNameInput.value.trim() }));
+        }
+        preview?.remove();
+        stroke = null;
+        preview = null;
+    };
+    annotationLayerEl.addEventListener('pointerup', finish);
+    annotationLayerEl.addEventListener('pointercancel', () => {
+        preview?.remove();
+        stroke = null;
+        preview = null;
+    });
+
+    annotationLayerEl.addEventListener('contextmenu', (e) => {
+        e.preventDefault();
+        sendMsg(JSON.stringify({ type: 'annotate', kind: 'clear' }));
+    });
+
+    window.addEventListener('resize', layoutAnnotations);
+    videoEl?.addEventListener('resize', layoutAnnotations);
+    layoutAnnotations();
+}
+
+function appendChatEntry(m: ChatEntry) {
+    const line = document.createElement('div');
+    const from = document.createElement('span');
+    from.className = m.verified ? 'chat-from' : 'chat-from unverified';
+    from.textContent = m.from;
+    from.title = new Date(m.time).toLocaleTimeString();
+    line.append(from, document.createTextNode(m.text));
+    chatLogEl.appendChild(line);
+}
+
+export function showChatMessage(m: ChatEntry) {
+    if (!chatLogEl) return;
+    const atBottom = chatLogEl.scrollHeight - chatLogEl.scrollTop - chatLogEl.clientHeight < 20;
+    appendChatEntry(m);
+    if (atBottom) {
+        chatLogEl.scrollTop = chatLogEl.scrollHeight;
+    }
+    if (chatPanel.classList.contains('hidden')) {
+        chatBtn?.classList.add('unread');
+    }
+}
+
+export function showChatHistory(messages: ChatEntry[]) {
+    if (!chatLogEl) return;
+    chatLogEl.replaceChildren();
+    messages.forEach(appendChatEntry);
+    chatLogEl.scrollTop = chatLogEl.scrollHeight;
+}
+
+export function showAnnotation(msg: Record<string, unknown>) {
+    if (!annotationSvg) return;
+    layoutAnnotations();
+    const color = typeof msg.color === 'string' ? msg.color : ANNOTATION_COLOR;
+    const rect = annotationSvg.getBoundingClientRect();
+
+    if (msg.kind === 'clear') {
+        annotationSvg.replaceChildren();
+    } else if (msg.kind === 'pointer' && typeof msg.x === 'number' && typeof msg.y === 'number') {
+        const g = document.createElementNS(SVG_NS, 'g');
+        const circle = document.createElementNS(SVG_NS, 'circle');
+        circle.setAttribute('cx', String(msg.x * rect.width));
+        circle.setAttribute('cy', String(msg.y * rect.height));
+        circle.setAttribute('r', '14');
+        circle.setAttribute('fill', 'none');
+        circle.setAttribute('stroke', color);
+        circle.setAttribute('stroke-width', '3');
+        const label = document.createElementNS(SVG_NS, 'text');
+        label.setAttribute('x', String(msg.x * rect.width + 18));
+        label.setAttribute('y', String(msg.y * rect.height - 18));
+        label.setAttribute('fill', color);
+        label.setAttribute('font-size', '13');
+        label.textContent = String(msg.from || '');
+        g.append(circle, label);
+        annotationSvg.appendChild(g);
+        fadeOut(g, POINTER_MS);
+    } else if (msg.kind === 'stroke' && Array.isArray(msg.points)) {
+        const line = document.createElementNS(SVG_NS, 'polyline');
+        line.setAttribute('points', toPixels(msg.points as [number, number][]));
+        line.setAttribute('fill', 'none');
+        line.setAttribute('stroke', color);
+        line.setAttribute('stroke-width', '3');
+        line.setAttribute('stroke-linecap', 'round');
+        line.setAttribute('stroke-linejoin', 'round');
+        annotationSvg.appendChild(line);
+        fadeOut(line, STROKE_MS);
+    }
+}
@@ -31,6 +31,16 @@ function processPendingClipboard() {
     }
 }
 
+let touchGesturesEnabled = true;
+export function setTouchGesturesEnabled(enabled: boolean) {
+    touchGesturesEnabled = enabled;
+}
+
+let penEnabled = false;
+export function setPenEnabled(enabled: boolean) {
+    penEnabled = enabled;
+}
+
 export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: () => void) {
     let withheldKey: string | null = null;
     const isMac = navigator.platform.toUpperCase().indexOf('MAC') >= 0;
@@ -106,6 +116,10 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
         focusClipboard();
     });
 
+    // The last non-ASCII character sent by keydown, see compositionend.
+    let lastComposedKey = '';
+    let lastComposedAt = 0;
+
     window.addEventListener('keydown', (event: KeyboardEvent) => {
         syncModifiers(event);
         
@@ -126,12 +140,12 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
         processPendingClipboard();
         if (hasMod && isC) {
             console.log('>>> [Input] Intercepted Copy shortcut');
-            sendMsgWrapped({ type: 'key', key: 'ctrl+c' });
+            sendMsg(JSON.stringify({ type: 'shortcut', keys: ['ctrl', 'c'] }));
             return;
         }
         if (hasMod && isA) {
             console.log('>>> [Input] Intercepted Select-All shortcut');
-            sendMsgWrapped({ type: 'key', key: 'ctrl+a' });
+            sendMsg(JSON.stringify({ type: 'shortcut', keys: ['ctrl', 'a'] }));
             return;
         }
 
@@ -139,7 +153,17 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
             event.preventDefault();
         }
 
+        // Dead keys and IME keys produce nothing by themselves; the composed
+        // character arrives as the next keydown or in compositionend.
+        if (event.key === 'Dead' || event.key === 'Process' || event.isComposing) {
+            return;
+        }
+
         let key = event.key;
+        if (key.length === 1 && key.charCodeAt(0) > 126) {
+            lastComposedKey = key;
+            lastComposedAt = Date.now();
+        }
         // General mapping for other shortcuts (Cmd+S, etc)
         // Check both 'Meta' key name and 'OS' (older browsers)
         if (isMac && (key === 'Meta' || key === 'OS')) {
@@ -155,6 +179,10 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
             return;
         }
 
+        if (event.key === 'Dead' || event.key === 'Process' || event.isComposing) {
+            return;
+        }
+
         let key = event.key;
         if (isMac && (key === 'Meta' || key === 'OS')) {
             key = 'Control';
@@ -162,6 +190,20 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
         sendMsgWrapped({ type: 'keyup', key });
     });
 
+    // Compose sequences and some dead-key implementations deliver the
+    // result only as composition text. Characters a keydown just sent are
+    // skipped so they are not typed twice.
+    window.addEventListener('compositionend', (event: CompositionEvent) => {
+        for (const ch of event.data || '') {
+            if (ch === lastComposedKey && Date.now() - lastComposedAt < 100) {
+                lastComposedKey = '';
+                continue;
+            }
+            sendMsgWrapped({ type: 'key', key: ch });
+        }
+        if (clipboardArea) clipboardArea.value = '';
+    });
+
     if (clipboardArea) {
         clipboardArea.addEventListener('paste', (event: ClipboardEvent) => {
             if (!clipboardEnabled) return;
@@ -241,7 +283,46 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
             };
         };
 
+        // Pens send pressure and tilt as pen messages instead, and the
+        // compatibility mouse events that follow are ignored.
+        let lastPointerPen = false;
+        const sendPen = (state: string, e: PointerEvent) => {
+            const pos = getNormalizedPos(e);
+            if (!pos) return;
+            sendMsg(JSON.stringify({
+                type: 'pen', state, x: pos.x, y: pos.y,
+                pressure: e.pressure, tiltX: e.tiltX, tiltY: e.tiltY,
+                buttons: e.buttons, eraser: (e.buttons & 32) !== 0
+            }));
+        };
+        overlayEl.addEventListener('pointerdown', (e: PointerEvent) => {
+            lastPointerPen = penEnabled && e.pointerType === 'pen';
+            if (!lastPointerPen) return;
+            overlayEl.setPointerCapture(e.pointerId);
+            sendPen('down', e);
+            e.preventDefault();
+        });
+        overlayEl.addEventListener('pointermove', (e: PointerEvent) => {
+            lastPointerPen = penEnabled && e.pointerType === 'pen';
+            if (!lastPointerPen) return;
+            // Coalesced events keep fast strokes smooth.
+            const events = e.getCoalescedEvents ? e.getCoalescedEvents() : [];
+            for (const ev of events.length > 0 ? events : [e]) {
+                sendPen('move', ev);
+            }
+        });
+        overlayEl.addEventListener('pointerup', (e: PointerEvent) => {
+            if (!lastPointerPen) return;
+            sendPen('up', e);
+            e.preventDefault();
+        });
+        overlayEl.addEventListener('pointerleave', (e: PointerEvent) => {
+            if (!lastPointerPen) return;
+            sendPen('leave', e);
+        });
+
         overlayEl.addEventListener('mousemove', (e: MouseEvent) => {
+            if (lastPointerPen) return;
             const now = Date.now();
             if (now - lastMove < 8) return;
             lastMove = now;
@@ -253,6 +334,7 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
             sendMouse('mousemove', pos.x, pos.y, null);
         });
         overlayEl.addEventListener('mousedown', (e: MouseEvent) => {
+            if (lastPointerPen) return;
             processPendingClipboard();
             focusClipboard();
             const pos = getNormalizedPos(e);
@@ -265,6 +347,7 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
         });
 
         overlayEl.addEventListener('mouseup', (e: MouseEvent) => {
+            if (lastPointerPen) return;
             const pos = getNormalizedPos(e);
             if (pos) {
                 sendMouse('mousemove', pos.x, pos.y, null);
@@ -278,8 +361,23 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
             return false;
         });
 
-        let wheelAccumX = 0;
-        let wheelAccumY = 0;
+        // Wheel input is sent as pixels once per frame; the server turns
+        // it into X wheel clicks and keeps the fractions between events.
+        let wheelPixelsX = 0;
+        let wheelPixelsY = 0;
+        let wheelFlushPending = false;
+        const flushWheel = () => {
+            wheelFlushPending = false;
+            if (wheelPixelsX === 0 && wheelPixelsY === 0) return;
+            sendMsg(JSON.stringify({
+                type: 'wheel',
+                deltaX: Math.round(wheelPixelsX * 10) / 10,
+                deltaY: Math.round(wheelPixelsY * 10) / 10,
+                unit: 'pixel'
+            }));
+            wheelPixelsX = 0;
+            wheelPixelsY = 0;
+        };
         overlayEl.addEventListener('wheel', (e: WheelEvent) => {
             let dx = e.deltaX;
             let dy = e.deltaY;
@@ -293,27 +391,115 @@ export function setupInput(sendMsg: (data: string) => void, onMouseMoveLocal?: (
                 dy *= 800;
             }
 
-            wheelAccumX += dx;
-            wheelAccumY += dy;
-
-            const THRESHOLD = 20; // Lower threshold for smoother scrolling
-            let sendDx = 0;
-            let sendDy = 0;
-
-            if (Math.abs(wheelAccumX) >= THRESHOLD) {
-                sendDx = Math.sign(wheelAccumX) * Math.floor(Math.abs(wheelAccumX) / THRESHOLD);
-                wheelAccumX -= sendDx * THRESHOLD;
-            }
-            if (Math.abs(wheelAccumY) >= THRESHOLD) {
-                sendDy = Math.sign(wheelAccumY) * Math.floor(Math.abs(wheelAccumY) / THRESHOLD);
-                wheelAccumY -= sendDy * THRESHOLD;
+            wheelPixelsX += dx;
+            wheelPixelsY += dy;
+            if (!wheelFlushPending) {
+                wheelFlushPending = true;
+                requestAnimationFrame(flushWheel);
             }
+            e.preventDefault();
+        }, { passive: false });
 
-            if (sendDx !== 0 || sendDy !== 0) {
-                sendMsg(JSON.stringify({ type: 'wheel', deltaX: sendDx, deltaY: sendDy }));
+        // Two-finger gestures: a pan scrolls (through the wheel path above)
+        // and a pinch zooms. Which one is decided by the first 12 px of
+        // movement and kept until a finger lifts, so pinches don't jitter
+        // the scroll position.
+        type TouchGeometry = { x: number; y: number; dist: number };
+        let lastTouch: TouchGeometry | null = null;
+        let gestureStart: TouchGeometry | null = null;
+        let gestureMode: '' | 'pan' | 'pinch' = '';
+        const touchGeometry = (e: TouchEvent): TouchGeometry => {
+            const a = e.touches[0];
+            const b = e.touches[1];
+            return {
+                x: (a.clientX + b.clientX) / 2,
+                y: (a.clientY + b.clientY) / 2,
+                dist: Math.hypot(a.clientX - b.clientX, a.clientY - b.clientY)
+            };
+        };
+        overlayEl.addEventListener('touchstart', (e: TouchEvent) => {
+            if (!touchGesturesEnabled || e.touches.length !== 2) {
+                lastTouch = null;
+                return;
             }
+            lastTouch = gestureStart = touchGeometry(e);
+            gestureMode = '';
             e.preventDefault();
         }, { passive: false });
+        overlayEl.addEventListener('touchmove', (e: TouchEvent) => {
+            if (!touchGesturesEnabled || e.touches.length !== 2 || !lastTouch || !gestureStart) return;
+            e.preventDefault();
+            const g = touchGeometry(e);
+            if (gestureMode === '') {
+                const spread = Math.abs(g.dist - gestureStart.dist);
+                const moved = Math.hypot(g.x - gestureStart.x, g.y - gestureStart.y);
+                if (Math.max(spread, moved) < 12) return;
+                gestureMode = spread > moved ? 'pinch' : 'pan';
+            }
+            if (gestureMode === 'pinch' && lastTouch.dist > 0) {
+                sendMsg(JSON.stringify({ type: 'pinch', scale: g.dist / lastTouch.dist }));
+            } else if (gestureMode === 'pan') {
+                // Content follows the fingers, so scroll the opposite way.
+                wheelPixelsX += lastTouch.x - g.x;
+                wheelPixelsY += lastTouch.y - g.y;
+                if (!wheelFlushPending) {
+                    wheelFlushPending = true;
+                    requestAnimationFrame(flushWheel);
+                }
+            }
+            lastTouch = g;
+        }, { passive: false });
+        overlayEl.addEventListener('touchend', (e: TouchEvent) => {
+            if (e.touches.length < 2) {
+                lastTouch = null;
+            }
+        });
     }
 }
 
+let gamepadEnabled = false;
+export function setGamepadEnabled(enabled: boolean) {
+    gamepadEnabled = enabled;
+}
+
+// Forwards standard-layout gamepads to the server, sending a pad's full
+// state whenever it changes.
+export function setupGamepads(sendMsg: (data: string) => void) {
+    if (!navigator.getGamepads) return;
+    const lastState = new Map<number, string>();
+    let polling = false;
+
+    const poll = () => {
+        const pads = navigator.getGamepads();
+        let connected = 0;
+        for (const pad of pads) {
+            if (!pad || pad.mapping !== 'standard') continue;
+            connected++;
+            if (!gamepadEnabled) continue;
+            const buttons = pad.buttons.map(b => Math.round(b.value * 100) / 100);
+            const axes = pad.axes.map(a => Math.round(a * 1000) / 1000);
+            const state = JSON.stringify({ type: 'gamepad', index: pad.index, buttons, axes });
+            if (lastState.get(pad.index) !== state) {
+                lastState.set(pad.index, state);
+                sendMsg(state);
+            }
+        }
+        if (connected > 0) {
+            requestAnimationFrame(poll);
+        } else {
+            polling = false;
+        }
+    };
+
+    window.addEventListener('gamepadconnected', () => {
+        if (!polling) {
+            polling = true;
+            requestAnimationFrame(poll);
+        }
+    });
+    window.addEventListener('gamepaddisconnected', (e: GamepadEvent) => {
+        if (lastState.delete(e.gamepad.index)) {
+            sendMsg(JSON.stringify({ type: 'gamepad', index: e.gamepad.index, connected: false }));
+        }
+    });
+}
@@ -1,78 +1,367 @@
 import { log, statusEl } from './ui';
 
+// WebTransport stream frames: [kind u8][length u32][payload], see
+// pkg/llrdc/webtransport.go.
+const WT_FRAME_TEXT = 0;
+const WT_FRAME_BINARY = 1;
+const WT_FRAME_VIDEO = 2;
+const WT_DATAGRAM_HEADER = 8;
+const WT_MAX_PENDING_FRAMES = 64;
+const WT_CONNECT_TIMEOUT_MS = 3000;
+
+// WebSocket fragments: [4][flags u8][seq u32][part], see pkg/llrdc/wsflow.go.
+const WS_FRAGMENT = 4;
+const WS_FRAGMENT_HEADER = 6;
+const WS_FRAGMENT_FIRST = 1;
+const WS_FRAGMENT_LAST = 2;
+
+interface PendingVideoFrame {
+    count: number;
+    parts: (Uint8Array | undefined)[];
+    received: number;
+}
+
 export class NetworkManager {
-    public ws: WebSocket;
+    public ws: WebSocket | null = null;
+    public transport: 'websocket' | 'webtransport' | 'sse' = 'websocket';
     public networkLatency = 0;
     public wsBandwidthMbps = 0;
     public wsConnected = false;
     public totalBytesReceived = 0;
+    public serverShuttingDown = false;
 
     private onBinaryMessage: (buffer: ArrayBuffer) => void;
     private onJsonMessage: (msg: Record<string, unknown>) => void;
     private onOpenCallback: () => void;
-    
+
     private bytesReceived = 0;
     private lastBytesUpdate = Date.now();
 
+    private wtWriter: WritableStreamDefaultWriter<Uint8Array> | null = null;
+    private textEncoder = new TextEncoder();
+    private textDecoder = new TextDecoder();
+    // Video over WebTransport is numbered; after a gap, delta frames are
+    // dropped until the next keyframe.
+    private lastVideoSeq = -1;
+    private videoGap = false;
+    private pendingVideo = new Map<number, PendingVideoFrame>();
+    // Parts of the fragmented WebSocket message being reassembled.
+    private fragments: Uint8Array[] = [];
+    private fragmentBytes = 0;
+
+    // With Server-Sent Events signaling, messages are POSTed one at a time
+    // to keep them in order.
+    private sseSession: string | null = null;
+    private ssePosts: Promise<unknown> = Promise.resolve();
+
     constructor(onBinaryMessage: (buffer: ArrayBuffer) => void, onJsonMessage: (msg: Record<string, unknown>) => void, onOpenCallback: () => void) {
         this.onBinaryMessage = onBinaryMessage;
         this.onJsonMessage = onJsonMessage;
         this.onOpenCallback = onOpenCallback;
 
+        void this.connect();
+    }
+
+    private async connect() {
+        if (this.requestedTransport() === 'sse') {
+            this.connectSSE();
+            return;
+        }
+        const url = await this.webTransportUrl();
+        if (url && await this.connectWebTransport(url)) return;
+        this.connectWebSocket();
+    }
+
+    private requestedTransport(): string | null {
+        return new URLSearchParams(window.location.search).get('transport');
+    }
+
+    // webTransportUrl returns the server's WebTransport endpoint if both the
+    // server and this browser support it. ?transport=websocket opts out.
+    private async webTransportUrl(): Promise<string | null> {
+        if (typeof WebTransport === 'undefined') return null;
+        if (this.requestedTransport() === 'websocket') return null;
+        try {
+            const resp = await fetch('/wt', { cache: 'no-store' });
+            if (!resp.ok) return null;
+            const info = await resp.json() as { url?: string };
+            return info.url ?? null;
+        } catch {
+            return null;
+        }
+    }
+
+    private connectWebSocket() {
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
         const wsUrl = `${protocol}//${window.location.host}`;
         log(`Connecting to ${wsUrl}...`);
 
-        this.ws = new WebSocket(wsUrl);
-        this.ws.binaryType = 'arraybuffer';
+        const ws = new WebSocket(wsUrl);
+        this.ws = ws;
+        ws.binaryType = 'arraybuffer';
+        let opened = false;
 
-        this.ws.onopen = () => {
+        ws.onopen = () => {
+            opened = true;
             log('WebSocket Connected');
-            this.wsConnected = true;
-            if (statusEl) {
-                statusEl.textContent = 'Connected, Negotiating WebRTC...';
-            }
-            setInterval(() => this.sendPing(), 1000);
-            setInterval(() => this.updateBandwidth(), 1000);
-            this.onOpenCallback();
+            this.onConnected();
         };
 
-        this.ws.onclose = () => {
-            log('WebSocket Disconnected');
-            this.wsConnected = false;
-            if (statusEl) {
-                statusEl.textContent = 'Disconnected';
-                statusEl.style.color = '#f44';
+        ws.onclose = () => {
+            if (!opened && this.requestedTransport() !== 'websocket') {
+                // Something on the way may strip WebSocket upgrades.
+                log('WebSocket failed, trying Server-Sent Events signaling');
+                this.ws = null;
+                this.connectSSE();
+                return;
             }
+            log('WebSocket Disconnected');
+            this.onDisconnected();
         };
 
-        this.ws.onerror = (err: Event) => {
+        ws.onerror = (err: Event) => {
             log('WebSocket Error');
             console.error(err);
         };
 
-        this.ws.onmessage = (event: MessageEvent) => {
+        ws.onmessage = (event: MessageEvent) => {
             if (event.data instanceof ArrayBuffer) {
-                this.bytesReceived += event.data.byteLength;
-                this.totalBytesReceived += event.data.byteLength;
-                this.onBinaryMessage(event.data);
+                this.handleBinary(event.data);
             } else if (typeof event.data === 'string') {
-                this.bytesReceived += event.data.length;
-                this.totalBytesReceived += event.data.length;
-                try {
-                    const msg = JSON.parse(event.data) as Record<string, unknown>;
-                    if (msg.type === 'pong') {
-                        this.networkLatency = Date.now() - (msg.timestamp as number);
-                    } else {
-                        this.onJsonMessage(msg);
+                this.handleText(event.data);
+            }
+        };
+    }
+
+    private async connectWebTransport(url: string): Promise<boolean> {
+        log(`Connecting to ${url} (WebTransport)...`);
+        let wt: WebTransport | null = null;
+        try {
+            wt = new WebTransport(url);
+            const timeout = new Promise<never>((_, reject) => setTimeout(() => reject(new Error('timeout')), WT_CONNECT_TIMEOUT_MS));
+            await Promise.race([wt.ready, timeout]);
+            const stream = await wt.createBidirectionalStream();
+            this.wtWriter = stream.writable.getWriter();
+            this.transport = 'webtransport';
+            void this.readWebTransportStream(stream.readable);
+            void this.readWebTransportDatagrams(wt.datagrams.readable);
+            wt.closed.catch(() => undefined).then(() => {
+                log('WebTransport Disconnected');
+                this.wtWriter = null;
+                this.onDisconnected();
+            });
+            log('WebTransport Connected');
+            this.onConnected();
+            return true;
+        } catch (err) {
+            log(`WebTransport unavailable (${err}), falling back to WebSocket`);
+            wt?.close();
+            return false;
+        }
+    }
+
+    private connectSSE() {
+        log('Connecting to /signal (Server-Sent Events)...');
+        const events = new EventSource('/signal');
+        let opened = false;
+
+        events.addEventListener('session', (event: MessageEvent) => {
+            this.sseSession = (JSON.parse(event.data) as { id: string }).id;
+            this.transport = 'sse';
+            opened = true;
+            log('Server-Sent Events Connected');
+            this.onConnected();
+        });
+
+        events.onmessage = (event: MessageEvent) => {
+            this.handleText(event.data);
+        };
+
+        // The server does not resume sessions, so a dropped stream is a
+        // disconnect rather than something for EventSource to retry.
+        const disconnect = () => {
+            events.close();
+            this.sseSession = null;
+            if (opened) {
+                log('Server-Sent Events Disconnected');
+            } else {
+                log('Server-Sent Events signaling unavailable');
+            }
+            this.onDisconnected();
+        };
+        events.addEventListener('close', disconnect);
+        events.onerror = disconnect;
+    }
+
+    private onConnected() {
+        this.wsConnected = true;
+        if (statusEl) {
+            statusEl.textContent = 'Connected, Negotiating WebRTC...';
+        }
+        setInterval(() => this.sendPing(), 1000);
+        setInterval(() => this.updateBandwidth(), 1000);
+        this.onOpenCallback();
+    }
+
+    private onDisconnected() {
+        this.wsConnected = false;
+        if (statusEl) {
+            statusEl.textContent = this.serverShuttingDown ? 'Server restarting...' : 'Disconnected';
+            statusEl.style.color = '#f44';
+        }
+    }
+
+    private handleBinary(buffer: ArrayBuffer) {
+        this.bytesReceived += buffer.byteLength;
+        this.totalBytesReceived += buffer.byteLength;
+        if (buffer.byteLength >= WS_FRAGMENT_HEADER && new Uint8Array(buffer, 0, 1)[0] === WS_FRAGMENT) {
+            this.handleFragment(buffer);
+            return;
+        }
+        this.onBinaryMessage(buffer);
+    }
+
+    // handleFragment acknowledges a fragment at once, so the server can
+    // keep sending, and passes on the message once its last part is in.
+    private handleFragment(buffer: ArrayBuffer) {
+        const view = new DataView(buffer);
+        const flags = view.getUint8(1);
+        const seq = view.getUint32(2);
+        this.sendMsg(JSON.stringify({ type: 'video_ack', seq }));
+
+        if (flags & WS_FRAGMENT_FIRST) {
+            this.fragments = [];
+            this.fragmentBytes = 0;
+        } else if (this.fragments.length === 0) {
+            // The start of this message was missed.
+            return;
+        }
+        const part = new Uint8Array(buffer, WS_FRAGMENT_HEADER);
+        this.fragments.push(part);
+        this.fragmentBytes += part.byteLength;
+        if (!(flags & WS_FRAGMENT_LAST)) {
+            return;
+        }
+        const packet = new Uint8Array(this.fragmentBytes);
+        let offset = 0;
+        for (const p of this.fragments) {
+            packet.set(p, offset);
+            offset += p.byteLength;
+        }
+        this.fragments = [];
+        this.fragmentBytes = 0;
+        this.onBinaryMessage(packet.buffer);
+    }
+
+    private handleText(text: string) {
+        this.bytesReceived += text.length;
+        this.totalBytesReceived += text.length;
+        try {
+            const msg = JSON.parse(text) as Record<string, unknown>;
+            if (msg.type === 'pong') {
+                this.networkLatency = Date.now() - (msg.timestamp as number);
+            } else {
+                this.onJsonMessage(msg);
+            }
+        } catch {
+            // Ignored
+        }
+    }
+
+    private async readWebTransportStream(readable: ReadableStream<Uint8Array>) {
+        const reader = readable.getReader();
+        let buffer = new Uint8Array(0);
+        try {
+            for (;;) {
+                const { value, done } = await reader.read();
+                if (done) return;
+                const joined = new Uint8Array(buffer.length + value.length);
+                joined.set(buffer);
+                joined.set(value, buffer.length);
+                buffer = joined;
+
+                while (buffer.length >= 5) {
+                    const dv = new DataView(buffer.buffer, buffer.byteOffset, buffer.byteLength);
+                    const length = dv.getUint32(1, false);
+                    if (buffer.length < 5 + length) break;
+                    const kind = buffer[0];
+                    const payload = buffer.slice(5, 5 + length);
+                    buffer = buffer.subarray(5 + length);
+                    if (kind === WT_FRAME_TEXT) {
+                        this.handleText(this.textDecoder.decode(payload));
+                    } else if (kind === WT_FRAME_BINARY) {
+                        this.handleBinary(payload.buffer);
+                    } else if (kind === WT_FRAME_VIDEO && payload.length > 4) {
+                        const seq = new DataView(payload.buffer).getUint32(0, false);
+                        this.deliverVideo(seq, payload.subarray(4));
                     }
-                } catch {
-                    // Ignored
                 }
             }
-        };
+        } catch {
+            // The session closed.
+        }
+    }
+
+    private async readWebTransportDatagrams(readable: ReadableStream<Uint8Array>) {
+        const reader = readable.getReader();
+        try {
+            for (;;) {
+                const { value, done } = await reader.read();
+                if (done) return;
+                if (value.length < WT_DATAGRAM_HEADER) continue;
+                const dv = new DataView(value.buffer, value.byteOffset, value.byteLength);
+                const seq = dv.getUint32(0, false);
+                const index = dv.getUint16(4, false);
+                const count = dv.getUint16(6, false);
+                if (seq <= this.lastVideoSeq || index >= count) continue;
+
+                let frame = this.pendingVideo.get(seq);
+                if (!frame) {
+                    if (this.pendingVideo.size >= WT_MAX_PENDING_FRAMES) {
+                        const oldest = Math.min(...this.pendingVideo.keys());
+                        this.pendingVideo.delete(oldest);
+                    }
+                    frame = { count, parts: new Array(count), received: 0 };
+                    this.pendingVideo.set(seq, frame);
+                }
+                if (frame.parts[index]) continue;
+                frame.parts[index] = value.slice(WT_DATAGRAM_HEADER);
+                frame.received++;
+                if (frame.received < frame.count) continue;
+
+                this.pendingVideo.delete(seq);
+                const size = frame.parts.reduce((n, part) => n + (part ? part.length : 0), 0);
+                const packet = new Uint8Array(size);
+                let offset = 0;
+                for (const part of frame.parts) {
+                    if (!part) continue;
+                    packet.set(part, offset);
+                    offset += part.length;
+                }
+                this.deliverVideo(seq, packet);
+            }
+        } catch {
+            // The session closed.
+        }
     }
-    
+
+    // deliverVideo passes on video packets in sequence order. Late packets
+    // are dropped, and after a lost one delta chunks wait for a keyframe.
+    private deliverVideo(seq: number, packet: Uint8Array) {
+        if (seq <= this.lastVideoSeq) return;
+        if (this.lastVideoSeq >= 0 && seq !== this.lastVideoSeq + 1) {
+            this.videoGap = true;
+        }
+        this.lastVideoSeq = seq;
+        for (const pending of this.pendingVideo.keys()) {
+            if (pending <= seq) this.pendingVideo.delete(pending);
+        }
+        const deltaChunk = packet[0] === 3 && (packet[1] & 0x01) === 0;
+        if (this.videoGap && deltaChunk) return;
+        this.videoGap = false;
+        this.handleBinary(packet.slice().buffer);
+    }
+
     private updateBandwidth() {
         const now = Date.now();
         const deltaMs = now - this.lastBytesUpdate;
@@ -85,13 +374,25 @@ export class NetworkManager {
     }
 
     private sendPing() {
-        if (this.ws.readyState === WebSocket.OPEN) {
-            this.ws.send(JSON.stringify({ type: 'ping', timestamp: Date.now() }));
-        }
+        this.sendMsg(JSON.stringify({ type: 'ping', timestamp: Date.now() }));
     }
 
     public sendMsg(data: string) {
-        if (this.ws.readyState === WebSocket.OPEN) {
+        if (this.wtWriter) {
+            const payload = this.textEncoder.encode(data);
+            const frame = new Uint8Array(5 + payload.length);
+            frame[0] = WT_FRAME_TEXT;
+            new DataView(frame.buffer).setUint32(1, payload.length, false);
+            frame.set(payload, 5);
+            this.wtWriter.write(frame).catch(() => undefined);
+        } else if (this.sseSession) {
+            const url = `/signal/${this.sseSession}`;
+            this.ssePosts = this.ssePosts.then(() => fetch(url, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: data,
+            })).catch(() => undefined);
+        } else if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(data);
         }
     }
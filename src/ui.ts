@@ -14,6 +14,8 @@ export const settleValue = document.getElementById('settle-value') as HTMLSpanEl
 export const tileSizeSlider = document.getElementById('tile-size-slider') as HTMLInputElement;
 export const tileSizeValue = document.getElementById('tile-size-value') as HTMLSpanElement;
 export const keyframeIntervalSelect = document.getElementById('keyframe-interval-select') as HTMLSelectElement;
+export const contentTuneSelect = document.getElementById('content-tune-select') as HTMLSelectElement;
+export const bandwidthCapSelect = document.getElementById('bandwidth-cap-select') as HTMLSelectElement;
 
 export const configBtn = document.getElementById('config-btn') as HTMLButtonElement;
 export const configDropdown = document.getElementById('config-dropdown') as HTMLDivElement;
@@ -23,6 +25,10 @@ export const qualitySlider = document.getElementById('quality-slider') as HTMLIn
 export const qualityValue = document.getElementById('quality-value') as HTMLSpanElement;
 export const framerateSelect = document.getElementById('framerate-select') as HTMLSelectElement;
 export const hdpiSelect = document.getElementById('hdpi-select') as HTMLSelectElement;
+export const rotationSelect = document.getElementById('rotation-select') as HTMLSelectElement;
+export const followFocusCheckbox = document.getElementById('follow-focus-checkbox') as HTMLInputElement;
+export const confineCursorCheckbox = document.getElementById('confine-cursor-checkbox') as HTMLInputElement;
+export const edgeScrollCheckbox = document.getElementById('edge-scroll-checkbox') as HTMLInputElement;
 export const maxResSelect = document.getElementById('max-res-select') as HTMLSelectElement;
 
 export const cpuEffortSlider = document.getElementById('cpu-effort-slider') as HTMLInputElement;
@@ -34,8 +40,36 @@ export const codecGpuOpts = document.querySelectorAll('.codec-opt-gpu') as NodeL
 export const clientGpuCheckbox = document.getElementById('client-gpu-checkbox') as HTMLInputElement;
 export const chromaCheckbox = document.getElementById('chroma-checkbox') as HTMLInputElement;
 export const clipboardCheckbox = document.getElementById('clipboard-checkbox') as HTMLInputElement;
+export const touchGesturesCheckbox = document.getElementById('touch-gestures-checkbox') as HTMLInputElement;
+export const webcamCheckbox = document.getElementById('webcam-checkbox') as HTMLInputElement;
+export const systemKeySelect = document.getElementById('system-key-select') as HTMLSelectElement;
+export const systemKeyBtn = document.getElementById('system-key-btn') as HTMLButtonElement;
+export const webcamGroup = document.getElementById('webcam-group') as HTMLDivElement;
 export const enableAudioCheckbox = document.getElementById('enable-audio-checkbox') as HTMLInputElement;
 export const audioBitrateSelect = document.getElementById('audio-bitrate-select') as HTMLSelectElement;
+export const audioFrameDurationSelect = document.getElementById('audio-frame-duration-select') as HTMLSelectElement;
+export const audioFecCheckbox = document.getElementById('audio-fec-checkbox') as HTMLInputElement;
+export const audioDtxCheckbox = document.getElementById('audio-dtx-checkbox') as HTMLInputElement;
+export const audioDeviceSelect = document.getElementById('audio-device-select') as HTMLSelectElement;
+export const chatBtn = document.getElementById('chat-btn') as HTMLButtonElement;
+export const annotateBtn = document.getElementById('annotate-btn') as HTMLButtonElement;
+export const chatPanel = document.getElementById('chat-panel') as HTMLDivElement;
+export const chatLogEl = document.getElementById('chat-log') as HTMLDivElement;
+export const chatForm = document.getElementById('chat-form') as HTMLFormElement;
+export const chatNameInput = document.getElementById('chat-name') as HTMLInputElement;
+export const chatInput = document.getElementById('chat-input') as HTMLInputElement;
+export const annotationLayerEl = document.getElementById('annotation-layer') as HTMLDivElement;
+export const annotationSvg = document.getElementById('annotation-svg') as unknown as SVGSVGElement;
+export const bannerEl = document.getElementById('banner') as HTMLDivElement;
+export const bannerTextEl = document.getElementById('banner-text') as HTMLSpanElement;
+export const bannerCloseBtn = document.getElementById('banner-close') as HTMLButtonElement;
+export const encoderErrorEl = document.getElementById('encoder-error') as HTMLDivElement;
+export const encoderErrorReasonEl = document.getElementById('encoder-error-reason') as HTMLDivElement;
+export const encoderErrorStderrEl = document.getElementById('encoder-error-stderr') as HTMLPreElement;
+export const openLinksCheckbox = document.getElementById('open-links-checkbox') as HTMLInputElement;
+export const openUrlEl = document.getElementById('open-url') as HTMLDivElement;
+export const openUrlLink = document.getElementById('open-url-link') as HTMLAnchorElement;
+export const openUrlCloseBtn = document.getElementById('open-url-close') as HTMLButtonElement;
 
 export const ctx = displayEl.getContext('2d', { alpha: false, desynchronized: true });
 if (ctx) {
@@ -65,6 +99,12 @@ export function setServerFfmpegCpu(cpu: number) {
     serverFfmpegCpu = cpu;
 }
 
+export let serverCaptureFps = 0;
+
+export function setServerCaptureFps(fps: number) {
+    serverCaptureFps = fps;
+}
+
 export function updateStatusText(isWebRtcActive: boolean, fps: number, latencyMonitor: number, networkLatency: number, bandwidthMbps: number = 0, width: number = 0, height: number = 0, codec: string = 'vp8') {
     if (!statusEl) return;
     
@@ -90,5 +130,5 @@ export function updateStatusText(isWebRtcActive: boolean, fps: number, latencyMo
     }
     
     statusEl.style.color = color;
-    statusEl.textContent = `${transportInfo}${resInfo} | FPS: ${fps} | Latency (Video): ${latencyMonitor}ms | Ping: ${networkLatency}ms | BW: ${bandwidthMbps.toFixed(2)} Mbps | FFmpeg CPU: ${Math.round(serverFfmpegCpu)}%`;
+    statusEl.textContent = `${transportInfo}${resInfo} | FPS: ${fps} | Latency (Video): ${latencyMonitor}ms | Ping: ${networkLatency}ms | BW: ${bandwidthMbps.toFixed(2)} Mbps | FFmpeg CPU: ${Math.round(serverFfmpegCpu)}%${serverCaptureFps > 0 ? ` | Capture: ${serverCaptureFps} fps` : ''}`;
 }
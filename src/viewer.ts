@@ -1,8 +1,9 @@
-import { log, bandwidthSelect, vbrCheckbox, mpdecimateCheckbox, hybridCheckbox, settleSlider, settleValue, tileSizeSlider, tileSizeValue, keyframeIntervalSelect, configBtn, configDropdown, targetTypeRadios, qualitySlider, qualityValue, framerateSelect, hdpiSelect, maxResSelect, displayContainerEl, overlayEl, configTabBtns, cpuEffortSlider, cpuEffortValue, cpuThreadsSelect, desktopMouseCheckbox, videoCodecSelect, codecGpuOpts, clientGpuCheckbox, chromaCheckbox, clipboardCheckbox, enableAudioCheckbox, audioBitrateSelect, setServerFfmpegCpu, videoEl, sharpnessLayerEl, sharpnessCtx } from './ui';
+import { log, bandwidthSelect, vbrCheckbox, mpdecimateCheckbox, hybridCheckbox, settleSlider, settleValue, tileSizeSlider, tileSizeValue, keyframeIntervalSelect, contentTuneSelect, bandwidthCapSelect, configBtn, configDropdown, targetTypeRadios, qualitySlider, qualityValue, framerateSelect, hdpiSelect, rotationSelect, followFocusCheckbox, confineCursorCheckbox, edgeScrollCheckbox, maxResSelect, displayContainerEl, overlayEl, configTabBtns, cpuEffortSlider, cpuEffortValue, cpuThreadsSelect, desktopMouseCheckbox, videoCodecSelect, codecGpuOpts, clientGpuCheckbox, chromaCheckbox, clipboardCheckbox, touchGesturesCheckbox, webcamCheckbox, webcamGroup, systemKeySelect, systemKeyBtn, enableAudioCheckbox, audioBitrateSelect, audioFrameDurationSelect, audioFecCheckbox, audioDtxCheckbox, audioDeviceSelect, bannerEl, bannerTextEl, bannerCloseBtn, encoderErrorEl, encoderErrorReasonEl, encoderErrorStderrEl, openLinksCheckbox, openUrlEl, openUrlLink, openUrlCloseBtn, setServerFfmpegCpu, setServerCaptureFps, videoEl, sharpnessLayerEl, sharpnessCtx } from './ui';
 import { NetworkManager } from './network';
 import { WebCodecsManager } from './webcodecs';
 import { WebRTCManager } from './webrtc';
-import { setupInput, setPendingClipboard, setClipboardEnabled } from './input';
+import { setupChat, showChatMessage, showChatHistory, showAnnotation, type ChatEntry } from './chat';
+import { setupInput, setupGamepads, setGamepadEnabled, setPenEnabled, setTouchGesturesEnabled, setPendingClipboard, setClipboardEnabled } from './input';
 
 export { };
 
@@ -22,12 +23,28 @@ let triggerResizeUpdate: () => void = () => { };
 
 // eslint-disable-next-line prefer-const
 let webrtc: WebRTCManager;
+let awaitingWebRTCConfig = false;
 
 const network = new NetworkManager(
     handleBinaryMessage,
     handleJsonMessage,
     () => {
-        if (webrtc) webrtc.initWebRTC();
+        // Ask for timestamped, keyframe-flagged chunks instead of raw frames,
+        // fragmented over a WebSocket so large keyframes do not hold up
+        // other messages.
+        network.sendMsg(JSON.stringify({ type: 'video_format', format: 'chunked', fragments: network.transport === 'websocket' }));
+        // Caps are per connection, so restore this viewer's cap on reconnect.
+        if (bandwidthCapSelect && bandwidthCapSelect.value !== '0') {
+            network.sendMsg(JSON.stringify({ type: 'bandwidth_cap', mbps: parseFloat(bandwidthCapSelect.value) }));
+        }
+        if (confineCursorCheckbox?.checked || edgeScrollCheckbox?.checked) {
+            sendPointerOptions();
+        }
+        if (openLinksCheckbox?.checked) {
+            network.sendMsg(JSON.stringify({ type: 'open_urls', enabled: true }));
+        }
+        // WebRTC starts once the server's config names its ICE servers.
+        awaitingWebRTCConfig = true;
         triggerResizeUpdate();
     }
 );
@@ -48,6 +65,8 @@ webrtc = new WebRTCManager(
 window.webrtcManager = webrtc;
 
 setupInput((data) => network.sendMsg(data));
+setupChat((data) => network.sendMsg(data));
+setupGamepads((data) => network.sendMsg(data));
 
 interface ConfigMessage {
     type: 'config';
@@ -57,17 +76,30 @@ interface ConfigMessage {
     vbr?: boolean;
     mpdecimate?: boolean;
     keyframe_interval?: number;
+    content_tune?: string;
     cpu_effort?: number;
     cpu_threads?: number;
     enable_desktop_mouse?: boolean;
     video_codec?: string;
     chroma?: string;
     hdpi?: number;
+    dpi?: number;
+    rotation?: string;
+    crop?: { focus?: boolean } | null;
+    webcam?: boolean;
+    gamepad?: boolean;
+    pen?: boolean;
+    touch_gestures?: boolean;
     enable_hybrid?: boolean;
     settle_time?: number;
     tile_size?: number;
     enable_audio?: boolean;
     audio_bitrate?: string;
+    audio_device?: string;
+    audio_frame_duration?: number;
+    audio_fec?: boolean;
+    audio_dtx?: boolean;
+    auto_quality?: boolean;
 }
 
 let configDebounceTimer: number | null = null;
@@ -88,13 +120,14 @@ function sendConfig() {
         }
 
         const config: ConfigMessage = { type: 'config' };
+        config.auto_quality = target === 'auto';
         if (target === 'bandwidth') {
             config.bandwidth = parseInt(bandwidthSelect.value, 10);
-        } else {
+        } else if (target === 'quality') {
             config.quality = parseInt(qualitySlider.value, 10);
         }
         config.framerate = parseInt(framerateSelect.value, 10);
-        if (hdpiSelect) {
+        if (hdpiSelect && hdpiSelect.value !== 'auto') {
             config.hdpi = parseInt(hdpiSelect.value, 10);
         }
         if (vbrCheckbox) {
@@ -106,6 +139,9 @@ function sendConfig() {
         if (keyframeIntervalSelect) {
             config.keyframe_interval = parseInt(keyframeIntervalSelect.value, 10);
         }
+        if (contentTuneSelect) {
+            config.content_tune = contentTuneSelect.value;
+        }
         if (cpuEffortSlider) {
             config.cpu_effort = parseInt(cpuEffortSlider.value, 10);
         }
@@ -144,6 +180,18 @@ function sendConfig() {
             config.audio_bitrate = audioBitrateSelect.value;
         }
 
+        if (audioFrameDurationSelect) {
+            config.audio_frame_duration = parseFloat(audioFrameDurationSelect.value);
+        }
+
+        if (audioFecCheckbox) {
+            config.audio_fec = audioFecCheckbox.checked;
+        }
+
+        if (audioDtxCheckbox) {
+            config.audio_dtx = audioDtxCheckbox.checked;
+        }
+
         network.sendMsg(JSON.stringify(config));
         configDebounceTimer = null;
     }, 100);
@@ -152,6 +200,9 @@ function sendConfig() {
 if (configBtn && configDropdown) {
     configBtn.addEventListener('click', () => {
         configDropdown.classList.toggle('hidden');
+        if (!configDropdown.classList.contains('hidden') && audioDeviceSelect) {
+            network.sendMsg(JSON.stringify({ type: 'audio_device_list' }));
+        }
     });
 }
 
@@ -160,7 +211,7 @@ for (const radio of targetTypeRadios) {
         const isBandwidth = radio.value === 'bandwidth';
         bandwidthSelect.disabled = !isBandwidth;
         if (vbrCheckbox) vbrCheckbox.disabled = !isBandwidth;
-        qualitySlider.disabled = isBandwidth;
+        qualitySlider.disabled = radio.value !== 'quality';
         sendConfig();
     });
 }
@@ -214,6 +265,16 @@ if (keyframeIntervalSelect) {
     keyframeIntervalSelect.addEventListener('change', sendConfig);
 }
 
+if (contentTuneSelect) {
+    contentTuneSelect.addEventListener('change', sendConfig);
+}
+
+if (bandwidthCapSelect) {
+    bandwidthCapSelect.addEventListener('change', () => {
+        network.sendMsg(JSON.stringify({ type: 'bandwidth_cap', mbps: parseFloat(bandwidthCapSelect.value) }));
+    });
+}
+
 if (qualitySlider && qualityValue) {
     qualitySlider.addEventListener('input', (e) => {
         qualityValue.textContent = (e.target as HTMLInputElement).value;
@@ -226,7 +287,61 @@ if (framerateSelect) {
 }
 
 if (hdpiSelect) {
-    hdpiSelect.addEventListener('change', sendConfig);
+    hdpiSelect.addEventListener('change', () => {
+        if (hdpiSelect.value === 'auto') {
+            // Force the next resize message to carry the device DPI.
+            lastResizeDpi = 0;
+            scheduleResize();
+        } else {
+            sendConfig();
+        }
+    });
+}
+
+if (rotationSelect) {
+    rotationSelect.addEventListener('change', () => {
+        network.sendMsg(JSON.stringify({ type: 'rotate', rotation: rotationSelect.value }));
+    });
+}
+
+// Follow-focus zoom pads the focused window to the shape of the video
+// area, so it fills this screen.
+function sendFollowFocus() {
+    if (!followFocusCheckbox) return;
+    if (!followFocusCheckbox.checked) {
+        network.sendMsg(JSON.stringify({ type: 'crop' }));
+        return;
+    }
+    const rect = displayContainerEl.getBoundingClientRect();
+    const aspect = rect.width > 0 && rect.height > 0 ? rect.width / rect.height : window.innerWidth / window.innerHeight;
+    network.sendMsg(JSON.stringify({ type: 'crop', window: 'focus', aspect }));
+}
+
+if (followFocusCheckbox) {
+    followFocusCheckbox.addEventListener('change', sendFollowFocus);
+    let followFocusTimer: number | null = null;
+    window.addEventListener('resize', () => {
+        if (!followFocusCheckbox.checked) return;
+        if (followFocusTimer) clearTimeout(followFocusTimer);
+        followFocusTimer = window.setTimeout(sendFollowFocus, 500);
+    });
+}
+
+// Pointer options are per connection, like the bandwidth cap.
+function sendPointerOptions() {
+    network.sendMsg(JSON.stringify({
+        type: 'pointer_options',
+        confine: !!confineCursorCheckbox?.checked,
+        edge_scroll: !!edgeScrollCheckbox?.checked,
+    }));
+}
+
+if (confineCursorCheckbox) {
+    confineCursorCheckbox.addEventListener('change', sendPointerOptions);
+}
+
+if (edgeScrollCheckbox) {
+    edgeScrollCheckbox.addEventListener('change', sendPointerOptions);
 }
 
 if (maxResSelect) {
@@ -259,7 +374,8 @@ if (configTabBtns) {
 
 if (cpuEffortSlider && cpuEffortValue) {
     cpuEffortSlider.addEventListener('input', (e) => {
-        cpuEffortValue.textContent = (e.target as HTMLInputElement).value;
+        const value = (e.target as HTMLInputElement).value;
+        cpuEffortValue.textContent = value === '-1' ? 'Auto' : value;
     });
     cpuEffortSlider.addEventListener('change', sendConfig);
 }
@@ -278,6 +394,33 @@ if (clipboardCheckbox) {
     });
 }
 
+if (touchGesturesCheckbox) {
+    touchGesturesCheckbox.addEventListener('change', () => {
+        setTouchGesturesEnabled(touchGesturesCheckbox.checked);
+        network.sendMsg(JSON.stringify({ type: 'config', touch_gestures: touchGesturesCheckbox.checked }));
+    });
+}
+
+if (webcamCheckbox) {
+    webcamCheckbox.addEventListener('change', async () => {
+        if (webcamCheckbox.checked) {
+            try {
+                webrtc.cameraStream = await navigator.mediaDevices.getUserMedia({ video: { width: 1280, height: 720 } });
+                log('Sharing webcam with the remote session');
+            } catch (err) {
+                log('Webcam access failed: ' + (err as Error).message);
+                webcamCheckbox.checked = false;
+                return;
+            }
+        } else if (webrtc.cameraStream) {
+            webrtc.cameraStream.getTracks().forEach(t => t.stop());
+            webrtc.cameraStream = null;
+        }
+        // The camera track is negotiated in the offer, so reconnect.
+        webrtc.initWebRTC();
+    });
+}
+
 if (enableAudioCheckbox) {
     enableAudioCheckbox.addEventListener('change', sendConfig);
 }
@@ -286,6 +429,75 @@ if (audioBitrateSelect) {
     audioBitrateSelect.addEventListener('change', sendConfig);
 }
 
+if (audioFrameDurationSelect) {
+    audioFrameDurationSelect.addEventListener('change', sendConfig);
+}
+
+if (audioFecCheckbox) {
+    audioFecCheckbox.addEventListener('change', sendConfig);
+}
+
+if (audioDtxCheckbox) {
+    audioDtxCheckbox.addEventListener('change', sendConfig);
+}
+
+// The device is sent on its own: until the list has arrived, the select
+// does not show the server's choice.
+if (audioDeviceSelect) {
+    audioDeviceSelect.addEventListener('change', () => {
+        const config: ConfigMessage = { type: 'config', audio_device: audioDeviceSelect.value };
+        network.sendMsg(JSON.stringify(config));
+    });
+}
+
+if (systemKeyBtn && systemKeySelect) {
+    systemKeyBtn.addEventListener('click', () => {
+        network.sendMsg(JSON.stringify({ type: 'system_key', key: systemKeySelect.value }));
+    });
+}
+
+if (bannerCloseBtn && bannerEl) {
+    bannerCloseBtn.addEventListener('click', () => bannerEl.classList.add('hidden'));
+}
+
+if (openLinksCheckbox) {
+    openLinksCheckbox.addEventListener('change', () => {
+        network.sendMsg(JSON.stringify({ type: 'open_urls', enabled: openLinksCheckbox.checked }));
+    });
+}
+
+if (openUrlCloseBtn && openUrlEl) {
+    openUrlCloseBtn.addEventListener('click', () => openUrlEl.classList.add('hidden'));
+    openUrlLink.addEventListener('click', () => openUrlEl.classList.add('hidden'));
+}
+
+// openRemoteUrl opens a link handed over from the session (urlhandoff.go).
+// Popup blockers usually stop a tab opened without a click, so the link is
+// then offered to click instead.
+function openRemoteUrl(url: string) {
+    log(`[Link] ${url}`);
+    const win = window.open(url, '_blank');
+    if (win) {
+        win.opener = null;
+        return;
+    }
+    if (openUrlEl && openUrlLink) {
+        openUrlLink.href = url;
+        openUrlLink.textContent = `Open ${url}`;
+        openUrlEl.classList.remove('hidden');
+    }
+}
+
+// selectAudioDevice shows device in the select, adding it if the list
+// does not have it (yet).
+function selectAudioDevice(device: string) {
+    if (!audioDeviceSelect) return;
+    if (!Array.from(audioDeviceSelect.options).some(o => o.value === device)) {
+        audioDeviceSelect.add(new Option(device, device));
+    }
+    audioDeviceSelect.value = device;
+}
+
 if (videoCodecSelect) {
     videoCodecSelect.addEventListener('change', () => {
         if (cpuEffortSlider) {
@@ -303,6 +515,7 @@ if (clientGpuCheckbox) {
 
 let lastResizeWidth = 0;
 let lastResizeHeight = 0;
+let lastResizeDpi = 0;
 let resizeTimer: number | null = null;
 
 let isReinitializingWebRTC = false;
@@ -326,14 +539,19 @@ function sendResize() {
 
     console.log(`sendResize evaluated: w=${width}, h=${height}, lastW=${lastResizeWidth}, lastH=${lastResizeHeight}, connected=${network.wsConnected}`);
 
-    if (width === lastResizeWidth && height === lastResizeHeight) return;
+    // In auto mode the desktop DPI follows devicePixelRatio, so text keeps
+    // the same logical size at any physical resolution.
+    const dpi = hdpiSelect && hdpiSelect.value === 'auto' ? Math.round(96 * scale) : 0;
+
+    if (width === lastResizeWidth && height === lastResizeHeight && dpi === lastResizeDpi) return;
 
     if (!network.wsConnected) return; // Wait until network is connected to send and save state
 
     lastResizeWidth = width;
     lastResizeHeight = height;
-    console.log(`Sending resize: ${width}x${height}`);
-    network.sendMsg(JSON.stringify({ type: 'resize', width, height }));
+    lastResizeDpi = dpi;
+    console.log(`Sending resize: ${width}x${height}${dpi ? ` @ ${dpi} dpi` : ''}`);
+    network.sendMsg(JSON.stringify(dpi ? { type: 'resize', width, height, dpi } : { type: 'resize', width, height }));
 }
 
 function scheduleResize() {
@@ -366,6 +584,10 @@ window.addEventListener('load', () => {
     window.addEventListener('keydown', unmuteVideo, { once: true });
 });
 
+let probeStart: number | null = null;
+let probeBytes = 0;
+let videoStreamId = -1;
+
 function handleBinaryMessage(buffer: ArrayBuffer) {
     const dv = new DataView(buffer);
     const type = dv.getUint8(0);
@@ -435,6 +657,45 @@ function handleBinaryMessage(buffer: ArrayBuffer) {
         if (webrtc && webrtc.isWebRtcActive) return;
 
         webcodecs.decodeChunk(isKey, timestamp, chunkData);
+    } else if (type === 3) { // Video chunk: [3][flags][streamId u32][pts u64 us][capture f64 ms]
+        const isKey = (dv.getUint8(1) & 0x01) !== 0;
+        const streamId = dv.getUint32(2, false);
+        const pts = Number(dv.getBigUint64(6, false));
+        const captureTime = dv.getFloat64(14, false);
+        const chunkData = new Uint8Array(buffer, 22);
+
+        webcodecs.latencyMonitor = Math.round(Math.abs(Date.now() - captureTime));
+
+        if (streamId !== videoStreamId) {
+            // The encoder restarted; wait for its first keyframe.
+            videoStreamId = streamId;
+            window.hasReceivedKeyFrame = false;
+        }
+        if (isKey) {
+            window.hasReceivedKeyFrame = true;
+        }
+
+        if (!window.hasReceivedKeyFrame) return;
+        if (webrtc && webrtc.isWebRtcActive) return;
+
+        webcodecs.decodeChunk(isKey, pts / 1000, chunkData);
+    } else if (type === 2) { // Bandwidth probe
+        const seq = dv.getUint16(1, false);
+        const count = dv.getUint16(3, false);
+        const now = performance.now();
+        if (seq === 0) {
+            probeStart = now;
+            probeBytes = 0;
+        } else if (probeStart !== null) {
+            // The first packet only marks the start; time the rest.
+            probeBytes += buffer.byteLength;
+        }
+        if (seq === count - 1 && probeStart !== null) {
+            const ms = now - probeStart;
+            log(`Bandwidth probe: ${probeBytes} bytes in ${ms.toFixed(1)}ms`);
+            network.sendMsg(JSON.stringify({ type: 'probe_result', bytes: probeBytes, ms }));
+            probeStart = null;
+        }
     }
 }
 
@@ -470,12 +731,44 @@ function handleJsonMessage(msg: Record<string, unknown>) {
 
         if (msg.hdpi !== undefined && typeof msg.hdpi === 'number') {
             currentHdpi = msg.hdpi === 0 ? 100 : msg.hdpi;
-            if (hdpiSelect) {
+            if (hdpiSelect && hdpiSelect.value !== 'auto') {
                 hdpiSelect.value = currentHdpi.toString();
             }
         }
+        if (typeof msg.dpi === 'number' && msg.dpi > 0) {
+            // The effective DPI wins over the percentage when set directly.
+            currentHdpi = Math.round(msg.dpi * 100 / 96);
+        }
+        if (typeof msg.gamepad === 'boolean') {
+            setGamepadEnabled(msg.gamepad);
+        }
+        if (typeof msg.pen === 'boolean') {
+            setPenEnabled(msg.pen);
+        }
+        if (typeof msg.touch_gestures === 'boolean') {
+            setTouchGesturesEnabled(msg.touch_gestures);
+            if (touchGesturesCheckbox) {
+                touchGesturesCheckbox.checked = msg.touch_gestures;
+            }
+        }
+        if (typeof msg.webcam === 'boolean' && webcamGroup) {
+            webcamGroup.style.display = msg.webcam ? '' : 'none';
+        }
+        if (typeof msg.rotation === 'string' && rotationSelect) {
+            rotationSelect.value = msg.rotation;
+        }
+        if ('crop' in msg && followFocusCheckbox) {
+            followFocusCheckbox.checked = msg.crop?.focus === true;
+        }
+
+        if (Array.isArray(msg.ice_servers)) {
+            webrtc.iceServers = msg.ice_servers.length > 0 ? [{ urls: msg.ice_servers as string[] }] : [];
+        }
 
-        if (webrtc.rtcPeer && (codecChanged || msg.restarted === true)) {
+        if (awaitingWebRTCConfig) {
+            awaitingWebRTCConfig = false;
+            webrtc.initWebRTC();
+        } else if (webrtc.rtcPeer && (codecChanged || msg.restarted === true)) {
             log('Config change triggered FFmpeg restart, re-initializing WebRTC...');
             isReinitializingWebRTC = true;
             webrtc.initWebRTC();
@@ -565,6 +858,10 @@ function handleJsonMessage(msg: Record<string, unknown>) {
             keyframeIntervalSelect.value = (msg.keyframe_interval as number).toString();
         }
 
+        if (msg.content_tune !== undefined && contentTuneSelect) {
+            contentTuneSelect.value = msg.content_tune as string;
+        }
+
         if (msg.chroma && typeof msg.chroma === 'string') {
             log(`Server chroma: ${msg.chroma}`);
             if (webcodecs.chroma !== msg.chroma) {
@@ -588,18 +885,101 @@ function handleJsonMessage(msg: Record<string, unknown>) {
         if (msg.audio_bitrate && typeof msg.audio_bitrate === 'string' && audioBitrateSelect) {
             audioBitrateSelect.value = msg.audio_bitrate;
         }
+
+        if (typeof msg.audio_frame_duration === 'number' && audioFrameDurationSelect) {
+            audioFrameDurationSelect.value = String(msg.audio_frame_duration);
+        }
+
+        if (typeof msg.audio_fec === 'boolean' && audioFecCheckbox) {
+            audioFecCheckbox.checked = msg.audio_fec;
+        }
+
+        if (typeof msg.audio_dtx === 'boolean' && audioDtxCheckbox) {
+            audioDtxCheckbox.checked = msg.audio_dtx;
+        }
+
+        if (typeof msg.audio_device === 'string') {
+            selectAudioDevice(msg.audio_device);
+        }
+    } else if (msg.type === 'chat') {
+        showChatMessage(msg as unknown as ChatEntry);
+    } else if (msg.type === 'chat_history') {
+        if (Array.isArray(msg.messages)) {
+            showChatHistory(msg.messages as ChatEntry[]);
+        }
+    } else if (msg.type === 'annotate') {
+        showAnnotation(msg);
+    } else if (msg.type === 'banner') {
+        if (bannerEl && bannerTextEl) {
+            const text = typeof msg.text === 'string' ? msg.text : '';
+            bannerTextEl.textContent = text;
+            bannerEl.classList.toggle('hidden', text === '');
+            if (text) log(`[Banner] ${text}`);
+        }
+    } else if (msg.type === 'encoder_error') {
+        if (encoderErrorEl && encoderErrorReasonEl && encoderErrorStderrEl) {
+            const reason = typeof msg.error === 'string' ? msg.error : 'exited';
+            const retry = typeof msg.retry_ms === 'number' ? Math.round(msg.retry_ms / 1000) : 0;
+            encoderErrorReasonEl.textContent = `ffmpeg ${reason} (${msg.crashes} times in a row), retrying in ${retry}s`;
+            encoderErrorStderrEl.textContent = typeof msg.stderr === 'string' ? msg.stderr : '';
+            encoderErrorEl.classList.remove('hidden');
+            log(`[Encoder] ${reason}`);
+        }
+    } else if (msg.type === 'encoder_recovered') {
+        encoderErrorEl?.classList.add('hidden');
+        log('[Encoder] Recovered');
+    } else if (msg.type === 'message_error') {
+        // The server refused a message; see GET /protocol.json.
+        log(`[Protocol] ${msg.for ?? 'message'} refused: ${msg.error}`);
+    } else if (msg.type === 'message_warning') {
+        const fields = Array.isArray(msg.unknown_fields) ? msg.unknown_fields.join(', ') : '';
+        log(`[Protocol] ${msg.for} ignored unknown fields: ${fields}`);
+    } else if (msg.type === 'audio_devices') {
+        if (audioDeviceSelect && Array.isArray(msg.devices)) {
+            audioDeviceSelect.replaceChildren(new Option('Default', ''));
+            for (const d of msg.devices as { name: string; description: string; kind: string }[]) {
+                const label = d.kind === 'app' ? `App: ${d.description}` : `${d.description || d.name} (${d.kind})`;
+                audioDeviceSelect.add(new Option(label, d.name));
+            }
+        }
+        if (typeof msg.selected === 'string') {
+            selectAudioDevice(msg.selected);
+        }
     } else if (msg.type === 'clipboard_get') {
         if (typeof msg.text === 'string') {
             setPendingClipboard(msg.text);
         }
     } else if (msg.type === 'webrtc_answer') {
-        webrtc.handleAnswer(msg.sdp as RTCSessionDescriptionInit);
+        webrtc.handleAnswer(msg.sdp as RTCSessionDescriptionInit, msg.generation as number | undefined);
     } else if (msg.type === 'webrtc_ice' && msg.candidate) {
-        webrtc.handleIce(msg.candidate as RTCIceCandidateInit);
+        webrtc.handleIce(msg.candidate as RTCIceCandidateInit, msg.generation as number | undefined);
+    } else if (msg.type === 'bandwidth_cap') {
+        if (typeof msg.mbps === 'number') {
+            log(msg.mbps > 0 ? `Video to this viewer capped at ${msg.mbps} Mbps` : 'Bandwidth cap removed');
+        }
+    } else if (msg.type === 'quality_degraded') {
+        let text = `Connection falling behind: ${msg.dropped} frames dropped`;
+        if (typeof msg.throughput_mbps === 'number') {
+            text += ` at ${msg.throughput_mbps.toFixed(1)} Mbps`;
+        }
+        if (typeof msg.cap_mbps === 'number') {
+            text += `, video capped at ${msg.cap_mbps.toFixed(1)} Mbps`;
+        }
+        log(text);
+    } else if (msg.type === 'quality_restored') {
+        log('Connection caught up, full quality restored');
+    } else if (msg.type === 'resize_result') {
+        if (typeof msg.width === 'number' && typeof msg.height === 'number' &&
+            (msg.width !== msg.requestedWidth || msg.height !== msg.requestedHeight)) {
+            log(`Requested ${msg.requestedWidth}x${msg.requestedHeight}, server applied ${msg.width}x${msg.height} (${msg.policy} policy)`);
+        }
     } else if (msg.type === 'stats') {
         if (typeof msg.ffmpegCpu === 'number') {
             setServerFfmpegCpu(msg.ffmpegCpu);
         }
+        if (typeof msg.captureFps === 'number') {
+            setServerCaptureFps(msg.captureFps);
+        }
     } else if (msg.type === 'lossless_patch') {
         if (sharpnessCtx && msg.data && typeof msg.data === 'string' && typeof msg.x === 'number' && typeof msg.y === 'number') {
             const img = new Image();
@@ -616,6 +996,39 @@ function handleJsonMessage(msg: Record<string, unknown>) {
         } else {
             clearLosslessCanvas(msg.x as number | undefined, msg.y as number | undefined, msg.w as number | undefined, msg.h as number | undefined);
         }
+    } else if (msg.type === 'locked' || msg.type === 'unlock_failed') {
+        log(msg.type === 'locked' ? `Session locked (${msg.reason})` : `Unlock failed: ${msg.error}`);
+        displayContainerEl.style.visibility = 'hidden';
+        // After a failure the server refuses attempts for retry_after ms.
+        const delay = typeof msg.retry_after === 'number' ? msg.retry_after : 0;
+        setTimeout(() => {
+            const password = window.prompt('Session locked. Enter password to unlock:');
+            if (password !== null) {
+                network.sendMsg(JSON.stringify({ type: 'unlock', password }));
+            }
+        }, delay);
+    } else if (msg.type === 'unlocked') {
+        log('Session unlocked');
+        displayContainerEl.style.visibility = 'visible';
+    } else if (msg.type === 'open_url') {
+        // Only http(s) links are forwarded, but check rather than trust.
+        if (typeof msg.url === 'string' && /^https?:\/\//i.test(msg.url) && openLinksCheckbox?.checked) {
+            openRemoteUrl(msg.url);
+        }
+    } else if (msg.type === 'notification') {
+        const summary = typeof msg.summary === 'string' ? msg.summary : '';
+        const body = typeof msg.body === 'string' ? msg.body : '';
+        log(`[${msg.app || 'Notification'}] ${summary}${body ? `: ${body}` : ''}`);
+        if ('Notification' in window && Notification.permission === 'granted' && document.hidden) {
+            new Notification(summary || String(msg.app), { body });
+        }
+    } else if (msg.type === 'server_shutdown') {
+        log('Server is shutting down');
+        network.serverShuttingDown = true;
+    } else if (msg.type === 'session_recovering') {
+        log(`Remote session process ${msg.process} exited, recovering...`);
+    } else if (msg.type === 'session_recovered') {
+        log(`Remote session recovered`);
     } else if (msg.type === 'cursor_shape') {
         const shape = msg.shape as string;
         if (overlayEl && typeof msg.dataURL === 'string' && typeof msg.xhot === 'number' && typeof msg.yhot === 'number') {
@@ -5,6 +5,9 @@ export class WebRTCManager {
     public isWebRtcActive = false;
     public fps = 0;
     public videoCodec = 'vp8';
+    public cameraStream: MediaStream | null = null;
+    // The server's STUN servers, from its config message.
+    public iceServers: RTCIceServer[] = [{ urls: 'stun:stun.l.google.com:19302' }];
 
     private sendWs: (data: string) => void;
     private getNetworkLatencyVal: () => number;
@@ -19,6 +22,14 @@ export class WebRTCManager {
     private webrtcLatency = 0;
     private hasSentWebrtcReady = false;
     private statsInterval: ReturnType<typeof setInterval> | null = null;
+    // Each PeerConnection gets the next generation, which tags its
+    // signaling messages so that late ones of a replaced connection are
+    // dropped on both sides.
+    private generation = 0;
+    // Local candidates found before the offer is sent wait here, as the
+    // server only takes them once it has the offer.
+    private pendingIce: RTCIceCandidate[] | null = null;
+    private iceRestarted = false;
 
     constructor(sendWs: (data: string) => void, getNetworkLatencyVal: () => number, getLatencyMonitor: () => number) {
         console.log('[WebRTCManager] Constructor called');
@@ -45,10 +56,13 @@ export class WebRTCManager {
         this.frameCount = 0;
         this.lastVideoFrameTime = 0;
         this.hasSentWebrtcReady = false;
-        this.rtcPeer = new RTCPeerConnection({
-            iceServers: [{ urls: 'stun:stun.l.google.com:19302' }],
+        this.iceRestarted = false;
+        const generation = ++this.generation;
+        const pc = new RTCPeerConnection({
+            iceServers: this.iceServers,
             bundlePolicy: 'max-bundle'
         });
+        this.rtcPeer = pc;
         window.rtcPeer = this.rtcPeer;
 
         this.statsInterval = setInterval(() => this.pollStats(), 1000);
@@ -64,12 +78,16 @@ export class WebRTCManager {
         };
 
         this.rtcPeer.onicecandidate = (e: RTCPeerConnectionIceEvent) => {
-            if (e.candidate) {
-                this.sendWs(JSON.stringify({ type: 'webrtc_ice', candidate: e.candidate }));
+            if (!e.candidate || pc !== this.rtcPeer) return;
+            if (this.pendingIce) {
+                this.pendingIce.push(e.candidate);
+            } else {
+                this.sendWs(JSON.stringify({ type: 'webrtc_ice', candidate: e.candidate, generation }));
             }
         };
 
         this.rtcPeer.ontrack = (e: RTCTrackEvent) => {
+            if (pc !== this.rtcPeer) return;
             log('WebRTC track received: ' + e.track.kind);
             let stream = videoEl.srcObject as MediaStream;
             if (!stream) {
@@ -95,35 +113,68 @@ export class WebRTCManager {
         };
 
         this.rtcPeer.oniceconnectionstatechange = () => {
-            if (!this.rtcPeer) return;
-            log('ICE state: ' + this.rtcPeer.iceConnectionState);
-            if (this.rtcPeer.iceConnectionState === 'disconnected' || this.rtcPeer.iceConnectionState === 'failed') {
+            if (pc !== this.rtcPeer) return;
+            log('ICE state: ' + pc.iceConnectionState);
+            if (pc.iceConnectionState === 'connected') {
+                this.iceRestarted = false;
+            }
+            if (pc.iceConnectionState === 'disconnected' || pc.iceConnectionState === 'failed') {
                 this.isWebRtcActive = false;
                 if (statusEl) {
                     statusEl.textContent = 'WebCodecs Fallback';
                 }
             }
+            if (pc.iceConnectionState === 'failed' && !this.iceRestarted) {
+                // Renegotiate the same connection once before giving up on it.
+                this.iceRestarted = true;
+                log('ICE failed, restarting ICE');
+                this.sendOffer(pc, generation, { iceRestart: true });
+            }
         };
 
         this.rtcPeer.addTransceiver('video', { direction: 'recvonly' });
         this.rtcPeer.addTransceiver('audio', { direction: 'recvonly' });
-        this.rtcPeer.createOffer().then((offer: RTCSessionDescriptionInit) => {
+        const cameraTrack = this.cameraStream?.getVideoTracks()[0];
+        if (cameraTrack) {
+            const transceiver = this.rtcPeer.addTransceiver(cameraTrack, { direction: 'sendonly', streams: [this.cameraStream!] });
+            // Prefer VP8, which every browser can encode cheaply.
+            const codecs = RTCRtpSender.getCapabilities?.('video')?.codecs;
+            if (codecs && transceiver.setCodecPreferences) {
+                const rank = (mime: string) => mime === 'video/VP8' ? 0 : mime === 'video/H264' ? 1 : 2;
+                transceiver.setCodecPreferences([...codecs].sort((a, b) => rank(a.mimeType) - rank(b.mimeType)));
+            }
+        }
+        this.sendOffer(pc, generation);
+    }
+
+    // sendOffer sends an offer for pc, a new connection or, with the same
+    // generation, a renegotiation of the current one.
+    private sendOffer(pc: RTCPeerConnection, generation: number, options?: RTCOfferOptions) {
+        this.pendingIce = [];
+        pc.createOffer(options).then((offer: RTCSessionDescriptionInit) => {
             if (offer.sdp) {
                 offer.sdp = offer.sdp.replace(/a=rtcp-fb:\d* transport-cc\r\n/g, '');
                 offer.sdp = offer.sdp.replace(/a=rtcp-fb:\d* goog-remb\r\n/g, '');
             }
-            return this.rtcPeer!.setLocalDescription(offer);
+            return pc.setLocalDescription(offer);
         }).then(() => {
-            if (this.rtcPeer!.localDescription) {
+            if (pc !== this.rtcPeer) return;
+            if (pc.localDescription) {
                 log('Sending WebRTC offer...');
                 this.sendWs(JSON.stringify({
                     type: 'webrtc_offer',
                     sdp: {
-                        type: this.rtcPeer!.localDescription.type,
-                        sdp: this.rtcPeer!.localDescription.sdp
-                    }
+                        type: pc.localDescription.type,
+                        sdp: pc.localDescription.sdp
+                    },
+                    generation
                 }));
             }
+            const pending = this.pendingIce || [];
+            this.pendingIce = null;
+            for (const candidate of pending) {
+                this.sendWs(JSON.stringify({ type: 'webrtc_ice', candidate, generation }));
+            }
         }).catch((err: unknown) => {
             log('WebRTC createOffer/setLocalDescription error: ' + (err as Error).message);
             console.error('WebRTC Error:', err);
@@ -251,11 +302,23 @@ export class WebRTCManager {
         }
     }
 
-    public handleAnswer(sdp: RTCSessionDescriptionInit) {
-        if (this.rtcPeer) this.rtcPeer.setRemoteDescription(new RTCSessionDescription(sdp));
+    // isStale reports whether a server message belongs to a replaced
+    // PeerConnection. Servers that do not echo generations send none.
+    private isStale(generation?: number) {
+        return generation !== undefined && generation !== this.generation;
+    }
+
+    public handleAnswer(sdp: RTCSessionDescriptionInit, generation?: number) {
+        if (!this.rtcPeer || this.isStale(generation)) return;
+        this.rtcPeer.setRemoteDescription(new RTCSessionDescription(sdp)).catch((err: unknown) => {
+            log('WebRTC setRemoteDescription error: ' + (err as Error).message);
+        });
     }
 
-    public handleIce(candidate: RTCIceCandidateInit) {
-        if (this.rtcPeer) this.rtcPeer.addIceCandidate(new RTCIceCandidate(candidate));
+    public handleIce(candidate: RTCIceCandidateInit, generation?: number) {
+        if (!this.rtcPeer || this.isStale(generation)) return;
+        this.rtcPeer.addIceCandidate(new RTCIceCandidate(candidate)).catch((err: unknown) => {
+            log('WebRTC addIceCandidate error: ' + (err as Error).message);
+        });
     }
 }
@@ -0,0 +1,223 @@
+import { test, expect, APIRequestContext, Browser, Page } from '@playwright/test';
+import { spawn, ChildProcess } from 'child_process';
+import net from 'net';
+
+// Session lock (pkg/llrdc/idlelock.go): a client can lock the session, and
+// it locks by itself after a minute without input. Every viewer is told,
+// the HTTP APIs refuse to act while it lasts, and only the lock password
+// unlocks it, with failed attempts backed off and, after too many, the
+// connection dropped.
+
+let serverProcess: ChildProcess;
+let serverPort: number;
+let serverUrl: string;
+const lockPassword = 'llrdc-lock-test';
+
+async function getFreePort(): Promise<number> {
+    return new Promise((resolve, reject) => {
+        const server = net.createServer();
+        server.unref();
+        server.on('error', reject);
+        server.listen(0, () => {
+            const port = (server.address() as net.AddressInfo).port;
+            server.close(() => resolve(port));
+        });
+    });
+}
+
+// openViewer opens a WebSocket from a page of the server, recording the
+// JSON it receives in window.received and its close code in
+// window.closeCode. The viewer itself is not loaded, as it would prompt for
+// the password.
+async function openViewer(browser: Browser): Promise<Page> {
+    const page = await browser.newPage();
+    await page.goto(`${serverUrl}/protocol.json`);
+    await page.evaluate((url) => new Promise<void>((resolve, reject) => {
+        const w = window as any;
+        w.received = [];
+        w.closeCode = 0;
+        const ws = new WebSocket(url);
+        w.ws = ws;
+        ws.onmessage = (e) => {
+            if (typeof e.data === 'string') w.received.push(JSON.parse(e.data));
+        };
+        ws.onclose = (e) => { w.closeCode = e.code; };
+        ws.onopen = () => resolve();
+        ws.onerror = () => reject(new Error('WebSocket failed'));
+    }), `ws://localhost:${serverPort}/`);
+    return page;
+}
+
+async function waitForMessage(page: Page, type: string, timeout = 5000): Promise<any> {
+    const handle = await page.waitForFunction(
+        (t) => (window as any).received.find((m: any) => m.type === t),
+        type,
+        { timeout },
+    );
+    return handle.jsonValue();
+}
+
+// sendMessage forgets what the page has received so far, so that
+// waitForMessage sees only what comes after.
+async function sendMessage(page: Page, msg: object) {
+    await page.evaluate((m) => {
+        const w = window as any;
+        w.received = [];
+        w.ws.send(JSON.stringify(m));
+    }, msg);
+}
+
+async function macroStatus(request: APIRequestContext): Promise<number> {
+    return (await request.get(`${serverUrl}/macro`)).status();
+}
+
+test.beforeAll(async () => {
+    serverPort = await getFreePort();
+    serverUrl = `http://localhost:${serverPort}`;
+    console.log(`Starting server on port ${serverPort}...`);
+
+    const DISPLAY_NUM = 100 + Math.floor(Math.random() * 100);
+
+    serverProcess = spawn('docker', [
+        'run', '--rm',
+        '-p', `${serverPort}:${serverPort}/tcp`,
+        '-p', `${serverPort}:${serverPort}/udp`,
+        '-e', `PORT=${serverPort}`,
+        '-e', `DISPLAY_NUM=${DISPLAY_NUM}`,
+        '-e', 'TEST_PATTERN=1',
+        '-e', 'WEBRTC_PUBLIC_IP=127.0.0.1',
+        '-e', `LOCK_PASSWORD=${lockPassword}`,
+        '-e', 'IDLE_LOCK_MINUTES=1',
+        '-e', 'ENABLE_MACROS=true',
+        'danchitnis/llrdc',
+        './llrdc',
+        '--port', String(serverPort),
+        '--display-num', String(DISPLAY_NUM),
+        '--webrtc-public-ip', '127.0.0.1'
+    ], {
+        stdio: 'pipe',
+        detached: false
+    });
+
+    serverProcess.stdout?.on('data', (data) => console.log(`[Server]: ${data}`));
+    serverProcess.stderr?.on('data', (data) => console.error(`[Server Error]: ${data}`));
+
+    try {
+        await new Promise<void>((resolve, reject) => {
+            const timeout = setTimeout(() => reject(new Error('Timeout waiting for server start')), 20000);
+            const dataHandler = (data: Buffer) => {
+                if (data.toString().includes(`Server listening on`)) {
+                    clearTimeout(timeout);
+                    resolve();
+                }
+            };
+            serverProcess.stdout?.on('data', dataHandler);
+            serverProcess.stderr?.on('data', dataHandler);
+            serverProcess.on('exit', (code) => {
+                if (code !== null && code !== 0) reject(new Error('Server failed to start'));
+            });
+        });
+        console.log(`Server is ready on port ${serverPort}`);
+    } catch (e) {
+        console.error('Server failed to start');
+        if (serverProcess) serverProcess.kill();
+        throw e;
+    }
+});
+
+test.afterAll(async () => {
+    if (serverProcess) {
+        console.log('Stopping server...');
+        serverProcess.kill('SIGTERM');
+        await new Promise(r => setTimeout(r, 1000));
+        if (!serverProcess.killed) serverProcess.kill('SIGKILL');
+    }
+});
+
+test('locks every viewer and the HTTP APIs on request', async ({ browser, request }) => {
+    const locker = await openViewer(browser);
+    const other = await openViewer(browser);
+    expect(await macroStatus(request)).toBe(200);
+
+    await sendMessage(locker, { type: 'lock' });
+    const locked = await waitForMessage(other, 'locked');
+    expect(locked.reason).toBe('manual');
+    expect(await macroStatus(request)).toBe(403);
+
+    // A viewer connecting now is told at once.
+    const late = await openViewer(browser);
+    await waitForMessage(late, 'locked');
+
+    await sendMessage(late, { type: 'unlock', password: lockPassword });
+    await waitForMessage(other, 'unlocked');
+    expect(await macroStatus(request)).toBe(200);
+
+    for (const page of [locker, other, late]) await page.close();
+});
+
+test('backs off failed unlock attempts', async ({ browser, request }) => {
+    const page = await openViewer(browser);
+    await sendMessage(page, { type: 'lock' });
+    await waitForMessage(page, 'locked');
+
+    await sendMessage(page, { type: 'unlock', password: 'wrong' });
+    const failed = await waitForMessage(page, 'unlock_failed');
+    expect(failed.error).toBe('invalid password');
+    expect(failed.retry_after).toBe(1000);
+
+    // Within the backoff even the right password is refused.
+    await sendMessage(page, { type: 'unlock', password: lockPassword });
+    const refused = await waitForMessage(page, 'unlock_failed');
+    expect(refused.error).toBe('too many attempts');
+    expect(refused.retry_after).toBeGreaterThan(0);
+    expect(await macroStatus(request)).toBe(403);
+
+    await page.waitForTimeout(refused.retry_after + 100);
+    await sendMessage(page, { type: 'unlock', password: lockPassword });
+    await waitForMessage(page, 'unlocked');
+    expect(await macroStatus(request)).toBe(200);
+    await page.close();
+});
+
+test('disconnects a viewer after repeated failures', async ({ browser, request }) => {
+    // The backoff doubles from a second: 1 + 2 + 4 + 8 s before the fifth
+    // attempt.
+    test.setTimeout(60000);
+
+    const page = await openViewer(browser);
+    await sendMessage(page, { type: 'lock' });
+    await waitForMessage(page, 'locked');
+
+    for (let attempt = 1; attempt < 5; attempt++) {
+        await sendMessage(page, { type: 'unlock', password: `wrong-${attempt}` });
+        const failed = await waitForMessage(page, 'unlock_failed');
+        expect(failed.error).toBe('invalid password');
+        expect(failed.retry_after).toBe(1000 * 2 ** (attempt - 1));
+        await page.waitForTimeout(failed.retry_after + 100);
+    }
+    await sendMessage(page, { type: 'unlock', password: 'wrong-5' });
+    await page.waitForFunction(() => (window as any).closeCode !== 0);
+    expect(await macroStatus(request)).toBe(403);
+
+    // Reconnecting starts afresh.
+    const again = await openViewer(browser);
+    await sendMessage(again, { type: 'unlock', password: lockPassword });
+    await waitForMessage(again, 'unlocked');
+    await page.close();
+    await again.close();
+});
+
+test('locks by itself after a minute without input', async ({ browser, request }) => {
+    // The idle check runs every 5 s.
+    test.setTimeout(150000);
+
+    const page = await openViewer(browser);
+    expect(await macroStatus(request)).toBe(200);
+    const locked = await waitForMessage(page, 'locked', 90000);
+    expect(locked.reason).toBe('idle');
+    expect(await macroStatus(request)).toBe(403);
+
+    await sendMessage(page, { type: 'unlock', password: lockPassword });
+    await waitForMessage(page, 'unlocked');
+    await page.close();
+});
@@ -0,0 +1,141 @@
+import { test, expect } from '@playwright/test';
+import { spawn, ChildProcess } from 'child_process';
+import net from 'net';
+
+// Connection filtering (pkg/llrdc/ipfilter.go): only clients in the
+// allowlist, and not in the denylist, get a page or a WebSocket. Docker
+// puts its proxy between the test and the server, so every hop is trusted
+// and the client address comes from X-Forwarded-For.
+
+let serverProcess: ChildProcess;
+let serverPort: number;
+
+async function getFreePort(): Promise<number> {
+    return new Promise((resolve, reject) => {
+        const server = net.createServer();
+        server.unref();
+        server.on('error', reject);
+        server.listen(0, () => {
+            const port = (server.address() as net.AddressInfo).port;
+            server.close(() => resolve(port));
+        });
+    });
+}
+
+// rawStatus sends a GET with the given headers and returns the status of
+// the response, without waiting for the body of an upgraded connection.
+function rawStatus(headers: Record<string, string>): Promise<number> {
+    return new Promise((resolve, reject) => {
+        const socket = net.connect(serverPort, '127.0.0.1');
+        let head = '';
+        socket.setTimeout(5000, () => socket.destroy(new Error('timeout')));
+        socket.on('connect', () => {
+            const lines = Object.entries({ 'Connection': 'close', ...headers })
+                .map(([k, v]) => `${k}: ${v}\r\n`).join('');
+            socket.write(`GET / HTTP/1.1\r\nHost: localhost\r\n${lines}\r\n`);
+        });
+        socket.on('data', (data) => {
+            head += data.toString('latin1');
+            if (head.includes('\r\n')) {
+                resolve(parseInt(head.split(' ')[1] ?? '0', 10));
+                socket.destroy();
+            }
+        });
+        socket.on('error', reject);
+    });
+}
+
+const upgrade = {
+    'Connection': 'Upgrade',
+    'Upgrade': 'websocket',
+    'Sec-WebSocket-Version': '13',
+    'Sec-WebSocket-Key': 'dGhlIHNhbXBsZSBub25jZQ==',
+};
+
+test.beforeAll(async () => {
+    serverPort = await getFreePort();
+    console.log(`Starting server on port ${serverPort}...`);
+
+    const DISPLAY_NUM = 100 + Math.floor(Math.random() * 100);
+
+    serverProcess = spawn('docker', [
+        'run', '--rm',
+        '-p', `${serverPort}:${serverPort}/tcp`,
+        '-p', `${serverPort}:${serverPort}/udp`,
+        '-e', `PORT=${serverPort}`,
+        '-e', `DISPLAY_NUM=${DISPLAY_NUM}`,
+        '-e', 'TEST_PATTERN=1',
+        '-e', 'WEBRTC_PUBLIC_IP=127.0.0.1',
+        '-e', 'ALLOW_IPS=203.0.113.0/24',
+        '-e', 'DENY_IPS=203.0.113.66',
+        '-e', 'TRUSTED_PROXIES=0.0.0.0/0,::/0',
+        'danchitnis/llrdc',
+        './llrdc',
+        '--port', String(serverPort),
+        '--display-num', String(DISPLAY_NUM),
+        '--webrtc-public-ip', '127.0.0.1'
+    ], {
+        stdio: 'pipe',
+        detached: false
+    });
+
+    serverProcess.stdout?.on('data', (data) => console.log(`[Server]: ${data}`));
+    serverProcess.stderr?.on('data', (data) => console.error(`[Server Error]: ${data}`));
+
+    try {
+        await new Promise<void>((resolve, reject) => {
+            const timeout = setTimeout(() => reject(new Error('Timeout waiting for server start')), 20000);
+            const dataHandler = (data: Buffer) => {
+                if (data.toString().includes(`Server listening on`)) {
+                    clearTimeout(timeout);
+                    resolve();
+                }
+            };
+            serverProcess.stdout?.on('data', dataHandler);
+            serverProcess.stderr?.on('data', dataHandler);
+            serverProcess.on('exit', (code) => {
+                if (code !== null && code !== 0) reject(new Error('Server failed to start'));
+            });
+        });
+        console.log(`Server is ready on port ${serverPort}`);
+    } catch (e) {
+        console.error('Server failed to start');
+        if (serverProcess) serverProcess.kill();
+        throw e;
+    }
+});
+
+test.afterAll(async () => {
+    if (serverProcess) {
+        console.log('Stopping server...');
+        serverProcess.kill('SIGTERM');
+        await new Promise(r => setTimeout(r, 1000));
+        if (!serverProcess.killed) serverProcess.kill('SIGKILL');
+    }
+});
+
+test('serves clients in the allowlist', async () => {
+    expect(await rawStatus({ 'X-Forwarded-For': '203.0.113.7' })).toBe(200);
+    // The first hop is the client when every hop is trusted.
+    expect(await rawStatus({ 'X-Forwarded-For': '203.0.113.7, 198.51.100.1' })).toBe(200);
+});
+
+test('refuses clients outside the allowlist or in the denylist', async () => {
+    const refused = [
+        {},
+        { 'X-Forwarded-For': '198.51.100.1' },
+        { 'X-Forwarded-For': '203.0.113.66' },
+        { 'X-Forwarded-For': '198.51.100.1, 203.0.113.7' },
+        { 'X-Forwarded-For': 'not-an-address' },
+    ];
+    for (const headers of refused) {
+        expect(await rawStatus(headers), JSON.stringify(headers)).toBe(403);
+    }
+});
+
+test('filters before the WebSocket upgrade', async () => {
+    expect(await rawStatus({ ...upgrade, 'X-Forwarded-For': '203.0.113.7' })).toBe(101);
+    expect(await rawStatus(upgrade)).toBe(403);
+    expect(await rawStatus({ ...upgrade, 'X-Forwarded-For': '203.0.113.66' })).toBe(403);
+    expect(await rawStatus({ ...upgrade, 'X-Forwarded-For': '198.51.100.1' })).toBe(403);
+});
@@ -0,0 +1,147 @@
+import { test, expect } from '@playwright/test';
+import { spawn, ChildProcess } from 'child_process';
+import net from 'net';
+
+// Glass-to-glass latency from the TEST_PATTERN=latency source. Every frame
+// carries a marker row with its frame counter and the server time it was
+// drawn; reading it off the decoded video and comparing with the browser
+// clock gives the capture -> encode -> transport -> decode latency. Server
+// and browser share the host clock.
+
+let serverProcess: ChildProcess;
+let serverPort: number;
+let serverUrl: string;
+
+async function getFreePort(): Promise<number> {
+  return new Promise((resolve, reject) => {
+    const server = net.createServer();
+    server.unref();
+    server.on('error', reject);
+    server.listen(0, () => {
+      const port = (server.address() as net.AddressInfo).port;
+      server.close(() => resolve(port));
+    });
+  });
+}
+
+test.beforeAll(async () => {
+  serverPort = await getFreePort();
+  serverUrl = `http://localhost:${serverPort}`;
+  console.log(`Starting server on port ${serverPort}...`);
+
+  const DISPLAY_NUM = 100 + Math.floor(Math.random() * 100);
+
+  serverProcess = spawn('npm', ['start'], {
+    env: { ...process.env, PORT: String(serverPort), FPS: '30', DISPLAY_NUM: DISPLAY_NUM.toString(), TEST_PATTERN: 'latency' },
+    stdio: 'pipe',
+    detached: false
+  });
+
+  serverProcess.stdout?.on('data', (data) => console.log(`[Server]: ${data}`));
+  serverProcess.stderr?.on('data', (data) => console.error(`[Server Error]: ${data}`));
+
+  await new Promise<void>((resolve, reject) => {
+    const timeout = setTimeout(() => reject(new Error('Timeout waiting for server start')), 20000);
+    const dataHandler = (data: any) => {
+      if (data.toString().includes(`Server listening on`)) {
+        clearTimeout(timeout);
+        resolve();
+      }
+    };
+    serverProcess.stdout?.on('data', dataHandler);
+    serverProcess.stderr?.on('data', dataHandler);
+    serverProcess.on('exit', (code) => {
+      if (code !== null && code !== 0) reject(new Error('Server failed to start'));
+    });
+  });
+});
+
+test.afterAll(async () => {
+  if (serverProcess) {
+    console.log('Stopping server...');
+    serverProcess.kill('SIGTERM');
+    await new Promise(r => setTimeout(r, 1000));
+    if (!serverProcess.killed) serverProcess.kill('SIGKILL');
+  }
+});
+
+test('measure glass-to-glass latency from frame markers', async ({ page }) => {
+  test.setTimeout(60000);
+  page.on('console', msg => console.log(`[Browser]: ${msg.text()}`));
+  await page.goto(serverUrl);
+
+  await page.waitForFunction(() => {
+    const stats = (window as any).getStats();
+    return stats && stats.fps > 0;
+  }, null, { timeout: 15000 });
+
+  const result = await page.evaluate(async (durationMs: number) => {
+    // Must match latencyMarkerCells and latencyCellSize in
+    // pkg/llrdc/latencypattern.go.
+    const MARKER_CELLS = 2 + 64 + 8;
+    const display = document.getElementById('display') as HTMLVideoElement | HTMLCanvasElement;
+    const canvas = document.createElement('canvas');
+    const ctx = canvas.getContext('2d', { willReadFrequently: true })!;
+
+    const decode = () => {
+      const width = (display as HTMLVideoElement).videoWidth || (display as HTMLCanvasElement).width;
+      const height = (display as HTMLVideoElement).videoHeight || (display as HTMLCanvasElement).height;
+      if (!width || !height) return null;
+      const cell = Math.max(Math.floor(width / (MARKER_CELLS + 6)), 8);
+      canvas.width = MARKER_CELLS * cell;
+      canvas.height = 2 * cell;
+      ctx.drawImage(display, 0, 0, canvas.width, canvas.height, 0, 0, canvas.width, canvas.height);
+      const row = ctx.getImageData(0, cell, canvas.width, 1).data;
+      const bits: number[] = [];
+      for (let i = 0; i < MARKER_CELLS; i++) {
+        bits.push(row[(i * cell + (cell >> 1)) * 4] > 128 ? 1 : 0);
+      }
+      if (bits[0] !== 1 || bits[1] !== 0) return null;
+      const bytes: number[] = [];
+      for (let b = 0; b < 9; b++) {
+        let v = 0;
+        for (let i = 0; i < 8; i++) v = (v << 1) | bits[2 + b * 8 + i];
+        bytes.push(v);
+      }
+      if (bytes.slice(0, 8).reduce((a, b) => a ^ b, 0) !== bytes[8]) return null;
+      const counter = (bytes[0] << 8) | bytes[1];
+      let ms = 0;
+      for (let i = 2; i < 8; i++) ms = ms * 256 + bytes[i];
+      return { counter, ms };
+    };
+
+    const latencies: number[] = [];
+    let lastCounter = -1;
+    let decodeFailures = 0;
+    const start = performance.now();
+    await new Promise<void>(resolve => {
+      const video = display as HTMLVideoElement;
+      const sample = () => {
+        const marker = decode();
+        if (!marker) {
+          decodeFailures++;
+        } else if (marker.counter !== lastCounter) {
+          lastCounter = marker.counter;
+          latencies.push(Date.now() - marker.ms);
+        }
+        if (performance.now() - start > durationMs) return resolve();
+        // Sample each presented frame where the browser can tell us.
+        if (typeof video.requestVideoFrameCallback === 'function') video.requestVideoFrameCallback(sample);
+        else requestAnimationFrame(sample);
+      };
+      sample();
+    });
+    return { latencies, decodeFailures };
+  }, 5000);
+
+  const sorted = [...result.latencies].sort((a, b) => a - b);
+  console.log(`Decoded ${sorted.length} frames (${result.decodeFailures} unreadable samples)`);
+  expect(sorted.length).toBeGreaterThan(10);
+  const median = sorted[Math.floor(sorted.length / 2)];
+  const p95 = sorted[Math.floor(sorted.length * 0.95)];
+  console.log(`Glass-to-glass latency: median ${median}ms, p95 ${p95}ms, min ${sorted[0]}ms, max ${sorted[sorted.length - 1]}ms`);
+  console.log(`LATENCY_MARKER_JSON ${JSON.stringify({ median, p95, samples: sorted.length })}`);
+
+  expect(median).toBeGreaterThanOrEqual(0);
+  expect(median).toBeLessThan(250);
+});
@@ -0,0 +1,239 @@
+import { test, expect, APIRequestContext, Browser, Page } from '@playwright/test';
+import { spawn, ChildProcess } from 'child_process';
+import net from 'net';
+
+// Share links (pkg/llrdc/shares.go): an authenticated user, identified by
+// the proxy header, mints links; a guest redeems one for a cookie that
+// admits it to the viewer and WebSocket until the link expires or is
+// revoked. Guests never reach the /share or /macro APIs, and a view-only
+// guest's control messages are dropped. The session lock serves as the
+// visible effect of a control message: a locked session refuses /macro.
+
+let serverProcess: ChildProcess;
+let serverPort: number;
+let serverUrl: string;
+const user = { 'X-Forwarded-User': 'alice' };
+const lockPassword = 'llrdc-share-test';
+
+async function getFreePort(): Promise<number> {
+    return new Promise((resolve, reject) => {
+        const server = net.createServer();
+        server.unref();
+        server.on('error', reject);
+        server.listen(0, () => {
+            const port = (server.address() as net.AddressInfo).port;
+            server.close(() => resolve(port));
+        });
+    });
+}
+
+interface MintedLink {
+    id: string;
+    path: string;
+    view_only: boolean;
+}
+
+async function mintLink(request: APIRequestContext, query: string): Promise<MintedLink> {
+    const res = await request.post(`${serverUrl}/share?${query}`, { headers: user });
+    expect(res.status()).toBe(201);
+    return res.json();
+}
+
+// joinAsGuest redeems a link in a fresh browser context and opens a
+// WebSocket from a page of the server, recording the JSON it receives in
+// window.received and its close code in window.closeCode.
+async function joinAsGuest(browser: Browser, link: MintedLink): Promise<Page> {
+    const context = await browser.newContext();
+    const redeem = await context.request.get(`${serverUrl}${link.path}`, { maxRedirects: 0 });
+    expect(redeem.status()).toBe(303);
+
+    const page = await context.newPage();
+    await page.goto(`${serverUrl}/protocol.json`);
+    await page.evaluate((url) => new Promise<void>((resolve, reject) => {
+        const w = window as any;
+        w.received = [];
+        w.closeCode = 0;
+        const ws = new WebSocket(url);
+        w.ws = ws;
+        ws.onmessage = (e) => {
+            if (typeof e.data === 'string') w.received.push(JSON.parse(e.data));
+        };
+        ws.onclose = (e) => { w.closeCode = e.code; };
+        ws.onopen = () => resolve();
+        ws.onerror = () => reject(new Error('WebSocket failed'));
+    }), `ws://localhost:${serverPort}/`);
+    return page;
+}
+
+async function waitForMessage(page: Page, type: string): Promise<any> {
+    const handle = await page.waitForFunction(
+        (t) => (window as any).received.find((m: any) => m.type === t),
+        type,
+    );
+    return handle.jsonValue();
+}
+
+async function sendMessage(page: Page, msg: object) {
+    await page.evaluate((m) => (window as any).ws.send(JSON.stringify(m)), msg);
+}
+
+test.beforeAll(async () => {
+    serverPort = await getFreePort();
+    serverUrl = `http://localhost:${serverPort}`;
+    console.log(`Starting server on port ${serverPort}...`);
+
+    const DISPLAY_NUM = 100 + Math.floor(Math.random() * 100);
+
+    serverProcess = spawn('docker', [
+        'run', '--rm',
+        '-p', `${serverPort}:${serverPort}/tcp`,
+        '-p', `${serverPort}:${serverPort}/udp`,
+        '-e', `PORT=${serverPort}`,
+        '-e', `DISPLAY_NUM=${DISPLAY_NUM}`,
+        '-e', 'TEST_PATTERN=1',
+        '-e', 'WEBRTC_PUBLIC_IP=127.0.0.1',
+        '-e', 'IDENTITY_HEADER=X-Forwarded-User',
+        '-e', 'ENABLE_SHARE_LINKS=true',
+        '-e', 'ENABLE_MACROS=true',
+        '-e', `LOCK_PASSWORD=${lockPassword}`,
+        'danchitnis/llrdc',
+        './llrdc',
+        '--port', String(serverPort),
+        '--display-num', String(DISPLAY_NUM),
+        '--webrtc-public-ip', '127.0.0.1'
+    ], {
+        stdio: 'pipe',
+        detached: false
+    });
+
+    serverProcess.stdout?.on('data', (data) => console.log(`[Server]: ${data}`));
+    serverProcess.stderr?.on('data', (data) => console.error(`[Server Error]: ${data}`));
+
+    try {
+        await new Promise<void>((resolve, reject) => {
+            const timeout = setTimeout(() => reject(new Error('Timeout waiting for server start')), 20000);
+            const dataHandler = (data: Buffer) => {
+                if (data.toString().includes(`Server listening on`)) {
+                    clearTimeout(timeout);
+                    resolve();
+                }
+            };
+            serverProcess.stdout?.on('data', dataHandler);
+            serverProcess.stderr?.on('data', dataHandler);
+            serverProcess.on('exit', (code) => {
+                if (code !== null && code !== 0) reject(new Error('Server failed to start'));
+            });
+        });
+        console.log(`Server is ready on port ${serverPort}`);
+    } catch (e) {
+        console.error('Server failed to start');
+        if (serverProcess) serverProcess.kill();
+        throw e;
+    }
+});
+
+test.afterAll(async () => {
+    if (serverProcess) {
+        console.log('Stopping server...');
+        serverProcess.kill('SIGTERM');
+        await new Promise(r => setTimeout(r, 1000));
+        if (!serverProcess.killed) serverProcess.kill('SIGKILL');
+    }
+});
+
+test('admits only authenticated users and guests with a link', async ({ request }) => {
+    const anonymous = await request.get(`${serverUrl}/`);
+    expect(anonymous.status()).toBe(401);
+
+    const authenticated = await request.get(`${serverUrl}/`, { headers: user });
+    expect(authenticated.status()).toBe(200);
+
+    const forged = await request.get(`${serverUrl}/share/bm90LmEubGluaw.c2lnbmF0dXJl`, { maxRedirects: 0 });
+    expect(forged.status()).toBe(403);
+});
+
+test('keeps guests out of the /share and /macro APIs', async ({ browser, request }) => {
+    const link = await mintLink(request, 'minutes=30');
+    const page = await joinAsGuest(browser, link);
+    const guest = page.context().request;
+
+    expect((await guest.get(`${serverUrl}/`)).status()).toBe(200);
+    expect((await guest.get(`${serverUrl}/share`)).status()).toBe(401);
+    expect((await guest.post(`${serverUrl}/share?minutes=30`)).status()).toBe(401);
+    expect((await guest.delete(`${serverUrl}/share/${link.id}`)).status()).toBe(401);
+    expect((await guest.get(`${serverUrl}/macro`)).status()).toBe(401);
+    expect((await guest.post(`${serverUrl}/macro/record`)).status()).toBe(401);
+    expect((await guest.post(`${serverUrl}/macro/play`, { data: { events: [] } })).status()).toBe(401);
+
+    // The user can still see and revoke the link.
+    const list = await (await request.get(`${serverUrl}/share`, { headers: user })).json();
+    expect(list.links.map((l: any) => l.id)).toContain(link.id);
+    expect((await request.delete(`${serverUrl}/share/${link.id}`, { headers: user })).status()).toBe(204);
+    await page.context().close();
+});
+
+test('drops control messages from view-only guests', async ({ browser, request }) => {
+    const viewOnly = await mintLink(request, 'minutes=30&view_only=1');
+    expect(viewOnly.view_only).toBe(true);
+    const watcher = await joinAsGuest(browser, viewOnly);
+    const share = await waitForMessage(watcher, 'share');
+    expect(share.view_only).toBe(true);
+
+    await sendMessage(watcher, { type: 'lock' });
+    await watcher.waitForTimeout(1000);
+    expect((await request.get(`${serverUrl}/macro`, { headers: user })).status()).toBe(200);
+
+    // The same message from a guest with control does lock the session.
+    const controller = await joinAsGuest(browser, await mintLink(request, 'minutes=30'));
+    expect((await waitForMessage(controller, 'share')).view_only).toBe(false);
+    await sendMessage(controller, { type: 'lock' });
+    await waitForMessage(controller, 'locked');
+    expect((await request.get(`${serverUrl}/macro`, { headers: user })).status()).toBe(403);
+
+    await sendMessage(controller, { type: 'unlock', password: lockPassword });
+    await waitForMessage(controller, 'unlocked');
+    expect((await request.get(`${serverUrl}/macro`, { headers: user })).status()).toBe(200);
+
+    await watcher.context().close();
+    await controller.context().close();
+});
+
+test('revoking a link disconnects its guests and refuses the cookie', async ({ browser, request }) => {
+    const link = await mintLink(request, 'minutes=30');
+    const page = await joinAsGuest(browser, link);
+    await waitForMessage(page, 'share');
+
+    expect((await request.delete(`${serverUrl}/share/${link.id}`, { headers: user })).status()).toBe(204);
+    await page.waitForFunction(() => (window as any).closeCode !== 0);
+    expect(await page.evaluate(() => (window as any).closeCode)).toBe(1008);
+
+    const guest = page.context().request;
+    expect((await guest.get(`${serverUrl}/`)).status()).toBe(401);
+    expect((await guest.get(`${serverUrl}${link.path}`, { maxRedirects: 0 })).status()).toBe(403);
+    expect((await request.delete(`${serverUrl}/share/${link.id}`, { headers: user })).status()).toBe(404);
+
+    const list = await (await request.get(`${serverUrl}/share`, { headers: user })).json();
+    expect(list.links.map((l: any) => l.id)).not.toContain(link.id);
+    await page.context().close();
+});
+
+test('an expired link disconnects its guests and no longer redeems', async ({ browser, request }) => {
+    // Links last whole minutes, so this waits for the shortest one to run out.
+    test.setTimeout(150000);
+
+    const link = await mintLink(request, 'minutes=1');
+    const page = await joinAsGuest(browser, link);
+    const share = await waitForMessage(page, 'share');
+    expect(share.expires).toBeGreaterThan(Date.now());
+
+    await page.waitForFunction(() => (window as any).closeCode !== 0, null, { timeout: 90000 });
+    expect(await page.evaluate(() => (window as any).closeCode)).toBe(1008);
+
+    const guest = page.context().request;
+    expect((await guest.get(`${serverUrl}/`)).status()).toBe(401);
+    expect((await request.get(`${serverUrl}${link.path}`, { maxRedirects: 0 })).status()).toBe(403);
+
+    const list = await (await request.get(`${serverUrl}/share`, { headers: user })).json();
+    expect(list.links.map((l: any) => l.id)).not.toContain(link.id);
+    await page.context().close();
+});
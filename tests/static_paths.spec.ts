@@ -0,0 +1,178 @@
+import { test, expect } from '@playwright/test';
+import { spawn, ChildProcess, execSync } from 'child_process';
+import net from 'net';
+
+// Static file serving (pkg/llrdc/static.go): traversal attempts, hidden
+// files, directories and symlinks leading out must not reach anything
+// outside public/, while the viewer itself, and a symlink to it, still load
+// with their caching headers.
+
+let serverProcess: ChildProcess;
+let serverPort: number;
+let serverUrl: string;
+const secret = 'llrdc-static-secret';
+
+async function getFreePort(): Promise<number> {
+    return new Promise((resolve, reject) => {
+        const server = net.createServer();
+        server.unref();
+        server.on('error', reject);
+        server.listen(0, () => {
+            const port = (server.address() as net.AddressInfo).port;
+            server.close(() => resolve(port));
+        });
+    });
+}
+
+interface RawResponse {
+    status: number;
+    headers: string;
+    body: string;
+}
+
+// rawGet sends the path exactly as given; HTTP clients would normalize the
+// dot segments away before they reach the server.
+function rawGet(path: string): Promise<RawResponse> {
+    return new Promise((resolve, reject) => {
+        const socket = net.connect(serverPort, '127.0.0.1');
+        const chunks: Buffer[] = [];
+        socket.setTimeout(5000, () => socket.destroy(new Error(`timeout on ${path}`)));
+        socket.on('connect', () => {
+            socket.write(`GET ${path} HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n`);
+        });
+        socket.on('data', (data) => chunks.push(data));
+        socket.on('error', reject);
+        socket.on('end', () => {
+            const text = Buffer.concat(chunks).toString('latin1');
+            const [head, ...rest] = text.split('\r\n\r\n');
+            const status = parseInt(head.split(' ')[1] ?? '0', 10);
+            resolve({ status, headers: head, body: rest.join('\r\n\r\n') });
+        });
+    });
+}
+
+test.beforeAll(async () => {
+    serverPort = await getFreePort();
+    serverUrl = `http://localhost:${serverPort}`;
+    console.log(`Starting server on port ${serverPort}...`);
+
+    const DISPLAY_NUM = 100 + Math.floor(Math.random() * 100);
+
+    serverProcess = spawn('npm', ['start'], {
+        env: { ...process.env, PORT: String(serverPort), TEST_PATTERN: '1', DISPLAY_NUM: DISPLAY_NUM.toString() },
+        stdio: 'pipe',
+        detached: false
+    });
+
+    serverProcess.stdout?.on('data', (data) => console.log(`[Server]: ${data}`));
+    serverProcess.stderr?.on('data', (data) => console.error(`[Server Error]: ${data}`));
+
+    try {
+        await new Promise<void>((resolve, reject) => {
+            const timeout = setTimeout(() => reject(new Error('Timeout waiting for server start')), 20000);
+            const dataHandler = (data: Buffer) => {
+                if (data.toString().includes(`Server listening on`)) {
+                    clearTimeout(timeout);
+                    resolve();
+                }
+            };
+            serverProcess.stdout?.on('data', dataHandler);
+            serverProcess.stderr?.on('data', dataHandler);
+            serverProcess.on('exit', (code) => {
+                if (code !== null && code !== 0) reject(new Error('Server failed to start'));
+            });
+        });
+        console.log(`Server is ready on port ${serverPort}`);
+
+        // Symlinks in the container's public/: two that lead out of it, to
+        // /etc/passwd and to a directory holding a secret, and one to the
+        // viewer that stays inside.
+        const containerId = execSync(`docker ps -q --filter ancestor=danchitnis/llrdc --filter publish=${serverPort}`).toString().trim().split('\n')[0];
+        execSync(`docker exec ${containerId} sh -c '${[
+            'mkdir -p /tmp/outside',
+            `echo ${secret} > /tmp/outside/secret.txt`,
+            'ln -sf /etc/passwd /app/public/passwd-link',
+            'ln -sfn /tmp/outside /app/public/outside-link',
+            'ln -sf viewer.html /app/public/inside-link.html',
+        ].join(' && ')}'`);
+    } catch (e) {
+        console.error('Server failed to start');
+        if (serverProcess) serverProcess.kill();
+        throw e;
+    }
+});
+
+test.afterAll(async () => {
+    if (serverProcess) {
+        console.log('Stopping server...');
+        serverProcess.kill('SIGTERM');
+        await new Promise(r => setTimeout(r, 1000));
+        if (!serverProcess.killed) serverProcess.kill('SIGKILL');
+    }
+});
+
+test('serves the viewer with revalidation', async ({ request }) => {
+    const res = await request.get(`${serverUrl}/`);
+    expect(res.status()).toBe(200);
+    expect(res.headers()['content-type']).toContain('text/html');
+    expect(res.headers()['cache-control']).toBe('no-cache');
+    const etag = res.headers()['etag'];
+    expect(etag).toBeTruthy();
+
+    const again = await request.get(`${serverUrl}/`, { headers: { 'If-None-Match': etag } });
+    expect(again.status()).toBe(304);
+});
+
+test('refuses paths outside public/', async () => {
+    const attempts = [
+        '/../../../../etc/passwd',
+        '/..',
+        '/../',
+        '/assets/../../../etc/passwd',
+        '/..%2f..%2f..%2f..%2fetc%2fpasswd',
+        '/%2e%2e/%2e%2e/%2e%2e/%2e%2e/etc/passwd',
+        '/..%5c..%5c..%5c..%5cetc%5cpasswd',
+        '//etc/passwd',
+        '/a%00b',
+        '/.',
+        '/x',
+    ];
+    for (const path of attempts) {
+        const res = await rawGet(path);
+        // The mux may redirect to the cleaned path, which stays inside
+        // public/; nothing may come back from outside it.
+        expect(res.status, path).toBeGreaterThan(0);
+        expect(res.body, path).not.toMatch(/root:.*:0:0:/);
+        if (res.status === 200) {
+            expect(res.headers, path).toMatch(/content-type: text\/html/i);
+        }
+    }
+
+    // The server is still answering.
+    const res = await rawGet('/');
+    expect(res.status).toBe(200);
+});
+
+test('hides dot files and does not list directories', async () => {
+    for (const path of ['/.git/config', '/.env', '/assets/', '/assets']) {
+        const res = await rawGet(path);
+        expect(res.status, path).toBe(404);
+    }
+});
+
+test('refuses symlinks that lead out of public/', async () => {
+    for (const path of ['/passwd-link', '/outside-link/secret.txt', '/outside-link/']) {
+        const res = await rawGet(path);
+        expect(res.status, path).toBe(404);
+        expect(res.body, path).not.toMatch(/root:.*:0:0:/);
+        expect(res.body, path).not.toContain(secret);
+    }
+});
+
+test('serves symlinks that stay inside public/', async ({ request }) => {
+    const viewer = await request.get(`${serverUrl}/viewer.html`);
+    const res = await request.get(`${serverUrl}/inside-link.html`);
+    expect(res.status()).toBe(200);
+    expect(res.headers()['content-type']).toContain('text/html');
+    expect(await res.text()).toBe(await viewer.text());
+});
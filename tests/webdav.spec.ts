@@ -0,0 +1,211 @@
+import { test, expect } from '@playwright/test';
+import { spawn, ChildProcess, execSync } from 'child_process';
+import net from 'net';
+
+// WebDAV (pkg/llrdc/webdav.go): files can be put, listed, moved and
+// removed inside the served folder, but nothing leads out of it, neither
+// dot segments nor symlinks made in the session pointing elsewhere, for
+// reading or for writing.
+
+let serverProcess: ChildProcess;
+let serverPort: number;
+let serverUrl: string;
+let containerId: string;
+const secret = 'llrdc-webdav-secret';
+
+async function getFreePort(): Promise<number> {
+    return new Promise((resolve, reject) => {
+        const server = net.createServer();
+        server.unref();
+        server.on('error', reject);
+        server.listen(0, () => {
+            const port = (server.address() as net.AddressInfo).port;
+            server.close(() => resolve(port));
+        });
+    });
+}
+
+interface RawResponse {
+    status: number;
+    body: string;
+}
+
+// rawGet sends the path exactly as given; HTTP clients would normalize the
+// dot segments away before they reach the server.
+function rawGet(path: string): Promise<RawResponse> {
+    return new Promise((resolve, reject) => {
+        const socket = net.connect(serverPort, '127.0.0.1');
+        const chunks: Buffer[] = [];
+        socket.setTimeout(5000, () => socket.destroy(new Error(`timeout on ${path}`)));
+        socket.on('connect', () => {
+            socket.write(`GET ${path} HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n`);
+        });
+        socket.on('data', (data) => chunks.push(data));
+        socket.on('error', reject);
+        socket.on('end', () => {
+            const text = Buffer.concat(chunks).toString('latin1');
+            const [head, ...rest] = text.split('\r\n\r\n');
+            const status = parseInt(head.split(' ')[1] ?? '0', 10);
+            resolve({ status, body: rest.join('\r\n\r\n') });
+        });
+    });
+}
+
+// outsideFiles lists what is in the directory the symlinks lead to.
+function outsideFiles(): string[] {
+    return execSync(`docker exec ${containerId} ls /tmp/outside`).toString().trim().split('\n');
+}
+
+test.beforeAll(async () => {
+    serverPort = await getFreePort();
+    serverUrl = `http://localhost:${serverPort}`;
+    console.log(`Starting server on port ${serverPort}...`);
+
+    const DISPLAY_NUM = 100 + Math.floor(Math.random() * 100);
+
+    serverProcess = spawn('docker', [
+        'run', '--rm',
+        '-p', `${serverPort}:${serverPort}/tcp`,
+        '-p', `${serverPort}:${serverPort}/udp`,
+        '-e', `PORT=${serverPort}`,
+        '-e', `DISPLAY_NUM=${DISPLAY_NUM}`,
+        '-e', 'TEST_PATTERN=1',
+        '-e', 'WEBRTC_PUBLIC_IP=127.0.0.1',
+        '-e', 'WEBDAV_DIR=/tmp/shared',
+        'danchitnis/llrdc',
+        './llrdc',
+        '--port', String(serverPort),
+        '--display-num', String(DISPLAY_NUM),
+        '--webrtc-public-ip', '127.0.0.1'
+    ], {
+        stdio: 'pipe',
+        detached: false
+    });
+
+    serverProcess.stdout?.on('data', (data) => console.log(`[Server]: ${data}`));
+    serverProcess.stderr?.on('data', (data) => console.error(`[Server Error]: ${data}`));
+
+    try {
+        await new Promise<void>((resolve, reject) => {
+            const timeout = setTimeout(() => reject(new Error('Timeout waiting for server start')), 20000);
+            const dataHandler = (data: Buffer) => {
+                if (data.toString().includes(`Server listening on`)) {
+                    clearTimeout(timeout);
+                    resolve();
+                }
+            };
+            serverProcess.stdout?.on('data', dataHandler);
+            serverProcess.stderr?.on('data', dataHandler);
+            serverProcess.on('exit', (code) => {
+                if (code !== null && code !== 0) reject(new Error('Server failed to start'));
+            });
+        });
+        console.log(`Server is ready on port ${serverPort}`);
+
+        // Symlinks in the served folder, as the session could make them: to
+        // /etc/passwd and to a directory holding a secret.
+        containerId = execSync(`docker ps -q --filter ancestor=danchitnis/llrdc --filter publish=${serverPort}`).toString().trim().split('\n')[0];
+        execSync(`docker exec ${containerId} sh -c '${[
+            'mkdir -p /tmp/outside',
+            `echo ${secret} > /tmp/outside/secret.txt`,
+            'ln -sf /etc/passwd /tmp/shared/passwd-link',
+            'ln -sfn /tmp/outside /tmp/shared/out',
+        ].join(' && ')}'`);
+    } catch (e) {
+        console.error('Server failed to start');
+        if (serverProcess) serverProcess.kill();
+        throw e;
+    }
+});
+
+test.afterAll(async () => {
+    if (serverProcess) {
+        console.log('Stopping server...');
+        serverProcess.kill('SIGTERM');
+        await new Promise(r => setTimeout(r, 1000));
+        if (!serverProcess.killed) serverProcess.kill('SIGKILL');
+    }
+});
+
+test('serves files inside the folder', async ({ request }) => {
+    const put = await request.put(`${serverUrl}/webdav/hello.txt`, { data: 'hello' });
+    expect(put.status()).toBe(201);
+
+    const get = await request.get(`${serverUrl}/webdav/hello.txt`);
+    expect(get.status()).toBe(200);
+    expect(await get.text()).toBe('hello');
+
+    const list = await request.fetch(`${serverUrl}/webdav/`, { method: 'PROPFIND', headers: { 'Depth': '1' } });
+    expect(list.status()).toBe(207);
+    const listing = await list.text();
+    expect(listing).toContain('/webdav/hello.txt');
+    expect(listing).not.toContain(secret);
+
+    const move = await request.fetch(`${serverUrl}/webdav/hello.txt`, {
+        method: 'MOVE',
+        headers: { 'Destination': '/webdav/renamed.txt' },
+    });
+    expect(move.status()).toBe(201);
+    expect(await (await request.get(`${serverUrl}/webdav/renamed.txt`)).text()).toBe('hello');
+
+    expect((await request.delete(`${serverUrl}/webdav/renamed.txt`)).status()).toBe(204);
+    expect((await request.get(`${serverUrl}/webdav/renamed.txt`)).status()).toBe(404);
+});
+
+test('refuses paths outside the folder', async () => {
+    const attempts = [
+        '/webdav/../../../../etc/passwd',
+        '/webdav/..%2f..%2f..%2f..%2fetc%2fpasswd',
+        '/webdav/%2e%2e/%2e%2e/%2e%2e/%2e%2e/etc/passwd',
+        '/webdav/..%5c..%5c..%5c..%5cetc%5cpasswd',
+        '/webdav//etc/passwd',
+    ];
+    for (const path of attempts) {
+        const res = await rawGet(path);
+        expect(res.status, path).toBeGreaterThan(0);
+        expect(res.body, path).not.toMatch(/root:.*:0:0:/);
+    }
+});
+
+test('does not read through symlinks that lead out', async ({ request }) => {
+    for (const path of ['/webdav/passwd-link', '/webdav/out/secret.txt']) {
+        const res = await request.get(`${serverUrl}${path}`);
+        expect(res.status(), path).toBe(404);
+        const body = await res.text();
+        expect(body, path).not.toMatch(/root:.*:0:0:/);
+        expect(body, path).not.toContain(secret);
+    }
+
+    const list = await request.fetch(`${serverUrl}/webdav/out/`, { method: 'PROPFIND', headers: { 'Depth': '1' } });
+    expect(list.status()).not.toBe(207);
+    expect(await list.text()).not.toContain('secret.txt');
+});
+
+test('does not write through symlinks that lead out', async ({ request }) => {
+    expect((await request.put(`${serverUrl}/webdav/inside.txt`, { data: 'inside' })).status()).toBe(201);
+
+    const attempts = [
+        () => request.put(`${serverUrl}/webdav/out/put.txt`, { data: 'put' }),
+        () => request.fetch(`${serverUrl}/webdav/out/dir`, { method: 'MKCOL' }),
+        () => request.fetch(`${serverUrl}/webdav/inside.txt`, {
+            method: 'COPY',
+            headers: { 'Destination': '/webdav/out/copied.txt' },
+        }),
+        () => request.fetch(`${serverUrl}/webdav/inside.txt`, {
+            method: 'MOVE',
+            headers: { 'Destination': '/webdav/out/moved.txt' },
+        }),
+        () => request.fetch(`${serverUrl}/webdav/inside.txt`, {
+            method: 'MOVE',
+            headers: { 'Destination': '/webdav/../../../tmp/outside/moved.txt' },
+        }),
+        () => request.delete(`${serverUrl}/webdav/out/secret.txt`),
+    ];
+    for (const attempt of attempts) {
+        const res = await attempt();
+        expect(res.status(), res.url()).toBeGreaterThanOrEqual(400);
+    }
+
+    expect(outsideFiles()).toEqual(['secret.txt']);
+    expect(await (await request.get(`${serverUrl}/webdav/inside.txt`)).text()).toBe('inside');
+});
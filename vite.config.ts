@@ -1,11 +1,44 @@
-import { defineConfig } from 'vite';
+import { defineConfig, type Plugin } from 'vite';
 import { resolve, dirname } from 'path';
 import { fileURLToPath } from 'url';
+import { readdirSync, readFileSync, writeFileSync } from 'fs';
+import { brotliCompressSync, constants, gzipSync } from 'zlib';
 
 const __filename = fileURLToPath(import.meta.url);
 const __dirname = dirname(__filename);
 
+// Writes .br and .gz copies of the built text files, which the server sends
+// to browsers that accept them (pkg/llrdc/static.go).
+function precompress(): Plugin {
+    const compressible = /\.(html|js|css|svg|json|map|wasm|txt)$/;
+    const walk = (dir: string) => {
+        for (const entry of readdirSync(dir, { withFileTypes: true })) {
+            const path = resolve(dir, entry.name);
+            if (entry.isDirectory()) {
+                walk(path);
+                continue;
+            }
+            if (!compressible.test(entry.name)) continue;
+            const data = readFileSync(path);
+            // Small files gain little over the headers.
+            if (data.length < 1024) continue;
+            writeFileSync(`${path}.br`, brotliCompressSync(data, {
+                params: { [constants.BROTLI_PARAM_QUALITY]: constants.BROTLI_MAX_QUALITY },
+            }));
+            writeFileSync(`${path}.gz`, gzipSync(data, { level: 9 }));
+        }
+    };
+    return {
+        name: 'llrdc-precompress',
+        apply: 'build',
+        closeBundle() {
+            walk(resolve(__dirname, 'public'));
+        },
+    };
+}
+
 export default defineConfig({
+    plugins: [precompress()],
     build: {
         outDir: 'public',
         emptyOutDir: true,